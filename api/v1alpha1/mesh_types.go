@@ -17,7 +17,9 @@ limitations under the License.
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 // References:
@@ -31,6 +33,13 @@ type MeshSpec struct {
 	// +kubebuilder:default="latest"
 	ReleaseVersion string `json:"release_version"`
 
+	// The version of Grey Matter the mesh's config objects are written for, if older than release_version.
+	// They're migrated to release_version before they're validated and applied, so the config can be
+	// updated after an upgrade rather than in lockstep with it.
+	// +kubebuilder:validation:Enum="1.6";"1.7"
+	// +optional
+	ConfigVersion string `json:"config_version,omitempty"`
+
 	// A list of OCI image strings and their respective pull secret names.
 	// These are treated as overrides to the specified "release_version".
 	// +optional
@@ -44,6 +53,11 @@ type MeshSpec struct {
 	// +kubebuilder:default=default-zone
 	Zone string `json:"zone"`
 
+	// Additional zones the mesh spans, e.g. in other failure domains. Each is created in its Control API,
+	// config objects keyed to it are sent to that Control API, and Catalog is given a session for it.
+	// +optional
+	Zones []Zone `json:"zones,omitempty"`
+
 	// Namespace where mesh core components and dependencies should be installed.
 	InstallNamespace string `json:"install_namespace"`
 
@@ -51,9 +65,141 @@ type MeshSpec struct {
 	// +optional
 	WatchNamespaces []string `json:"watch_namespaces,omitempty"`
 
+	// If set, namespaces with matching labels are also included in the mesh network, from when they're labeled until
+	// they're unlabeled or deleted.
+	// +optional
+	WatchNamespaceSelector *metav1.LabelSelector `json:"watch_namespace_selector,omitempty"`
+
 	// Add user tokens to the JWT Security Service.
 	// +optional
 	UserTokens []UserToken `json:"user_tokens,omitempty"`
+
+	// Overrides of the CUE defaults for the replicas, resources, and scheduling of core components.
+	// +optional
+	Overrides *ComponentOverrides `json:"overrides,omitempty"`
+
+	// Autoscaling of the edge and Control, whose replicas are then left to their HorizontalPodAutoscalers.
+	// +optional
+	Autoscaling *Autoscaling `json:"autoscaling,omitempty"`
+
+	// Core components the operator doesn't install or configure, e.g. to run without a dashboard or with a Redis
+	// provided outside the mesh. Control and the edge can't be disabled.
+	// +optional
+	DisabledComponents []CoreComponent `json:"disabled_components,omitempty"`
+
+	// Restricts which workloads in watched namespaces are eligible for sidecar injection.
+	// +optional
+	Injection *InjectionPolicy `json:"injection,omitempty"`
+
+	// The git repository the operator syncs its config from, replacing the one set by its startup flags.
+	// Changes take effect without restarting the operator.
+	// +optional
+	GitOps *GitOpsSource `json:"gitops,omitempty"`
+
+	// Authentication and authorization of requests at the mesh's edge, added to the edge listener.
+	// +optional
+	EdgeAuth *EdgeAuth `json:"edge_auth,omitempty"`
+}
+
+// GitOpsSource is a git repository and ref holding the operator's CUE config.
+type GitOpsSource struct {
+	// URL of the repository, over SSH or HTTPS, or an OCI artifact as oci://registry/repository.
+	Remote string `json:"remote"`
+
+	// Branch to track. Mutually exclusive with tag. Defaults to the repository's default branch.
+	// +optional
+	Branch string `json:"branch,omitempty"`
+
+	// Tag to check out, or the tag or digest of an OCI artifact. Mutually exclusive with branch.
+	// +optional
+	Tag string `json:"tag,omitempty"`
+
+	// Seconds between fetches of the repository.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=30
+	// +optional
+	IntervalSeconds int `json:"interval_seconds,omitempty"`
+
+	// Name of a Secret in the gm-operator namespace with credentials for the repository, in the same keys
+	// as the gm-git-credentials Secret (ssh-privatekey, passphrase, token, username, and known_hosts).
+	// +optional
+	CredentialsSecret string `json:"credentials_secret,omitempty"`
+
+	// Path prefixes (e.g. "gm/", "k8s/"). If set, only commits changing files under them trigger a reload.
+	// +optional
+	PathFilters []string `json:"path_filters,omitempty"`
+}
+
+// EdgeAuth configures authentication and authorization of requests at the mesh's edge.
+type EdgeAuth struct {
+	// Single sign-on with an OpenID Connect provider: unauthenticated requests are redirected to log in, and
+	// access tokens are validated on each request.
+	// +optional
+	OIDC *OIDCAuth `json:"oidc,omitempty"`
+
+	// An external authorization service asked whether to allow each request.
+	// +optional
+	ExtAuthz *ExtAuthz `json:"ext_authz,omitempty"`
+}
+
+// OIDCAuth is single sign-on at the edge with an OpenID Connect provider.
+type OIDCAuth struct {
+	// URL of the provider, which issues the access tokens, e.g. https://keycloak.example.com/auth/realms/greymatter.
+	IssuerURL string `json:"issuer_url"`
+
+	// URL of the provider's JSON Web Key Set. Defaults to <issuer_url>/protocol/openid-connect/certs (as on Keycloak).
+	// +optional
+	JWKSURL string `json:"jwks_url,omitempty"`
+
+	// Name of a Secret in the install namespace with the edge's OIDC client credentials, in client_id and
+	// client_secret keys.
+	CredentialsSecret string `json:"credentials_secret"`
+
+	// Audiences accepted in access tokens. Defaults to the client ID.
+	// +optional
+	Audiences []string `json:"audiences,omitempty"`
+
+	// External URL of the edge, which the provider redirects back to after logging in, e.g. https://edge.example.com.
+	ServiceURL string `json:"service_url"`
+
+	// Path of the edge the provider redirects back to.
+	// +kubebuilder:default=/oauth
+	// +optional
+	CallbackPath string `json:"callback_path,omitempty"`
+
+	// Scopes requested in addition to openid.
+	// +optional
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// ExtAuthz is an external authorization service the edge asks whether to allow each request.
+type ExtAuthz struct {
+	// URL of the service's HTTP authorization endpoint, e.g. http://opa.authz.svc:8181.
+	URL string `json:"url"`
+
+	// Milliseconds to wait for the service's decision.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=1000
+	// +optional
+	TimeoutMillis int `json:"timeout_ms,omitempty"`
+
+	// Whether requests are allowed while the service is unavailable.
+	// +optional
+	FailureModeAllow bool `json:"failure_mode_allow,omitempty"`
+}
+
+// Zone is an additional zone of a mesh.
+type Zone struct {
+	// The zone's name, used as its zone_key.
+	Name string `json:"name"`
+
+	// URL of the Control API serving the zone. Defaults to the mesh's Control API.
+	// +optional
+	APIURL string `json:"api_url,omitempty"`
+
+	// Address of the Control server Catalog connects to for the zone's session. Defaults to the mesh's Control server.
+	// +optional
+	SessionURL string `json:"session_url,omitempty"`
 }
 
 type UserToken struct {
@@ -72,11 +218,176 @@ type Images struct {
 	Prometheus  string `json:"prometheus,omitempty"`
 }
 
+// CoreComponent is a core component of the mesh that can be disabled.
+// +kubebuilder:validation:Enum=catalog;dashboard;jwt_security;redis;prometheus
+type CoreComponent string
+
+// ComponentOverrides holds overrides for each core component.
+type ComponentOverrides struct {
+	// +optional
+	Control *ComponentOverride `json:"control,omitempty"`
+	// +optional
+	Catalog *ComponentOverride `json:"catalog,omitempty"`
+	// +optional
+	Edge *ComponentOverride `json:"edge,omitempty"`
+	// +optional
+	Redis *ComponentOverride `json:"redis,omitempty"`
+}
+
+// ComponentOverride replaces the CUE defaults of a core component's workload.
+// Unset fields keep their defaults.
+type ComponentOverride struct {
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	Replicas *int32 `json:"replicas,omitempty"`
+
+	// Compute resources for the component's containers (excluding any injected sidecar).
+	// +optional
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// +optional
+	NodeSelector map[string]string `json:"node_selector,omitempty"`
+
+	// +optional
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +kubebuilder:validation:Type=object
+	// +optional
+	Affinity *corev1.Affinity `json:"affinity,omitempty"`
+
+	// Name of an existing PriorityClass for the component's pods, replacing the one from config.availability.
+	// +optional
+	PriorityClassName string `json:"priority_class_name,omitempty"`
+
+	// Replaces the disruption budget of the component's pods from config.availability.
+	// +optional
+	PodDisruptionBudget *PodDisruptionBudgetOverride `json:"pod_disruption_budget,omitempty"`
+}
+
+// Autoscaling holds the autoscaling of each core component that can be autoscaled.
+type Autoscaling struct {
+	// +optional
+	Control *ComponentAutoscaling `json:"control,omitempty"`
+	// +optional
+	Edge *ComponentAutoscaling `json:"edge,omitempty"`
+}
+
+// ComponentAutoscaling scales a core component's replicas with a HorizontalPodAutoscaler, and optionally has a
+// VerticalPodAutoscaler recommend its resource requests.
+type ComponentAutoscaling struct {
+	// Defaults to 1.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MinReplicas *int32 `json:"min_replicas,omitempty"`
+
+	// +kubebuilder:validation:Minimum=1
+	MaxReplicas int32 `json:"max_replicas"`
+
+	// Average CPU utilization of the component's pods, as a percentage of their requests, that it's scaled to keep.
+	// Defaults to 80 if no memory target is set.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	TargetCPUUtilization *int32 `json:"target_cpu_utilization,omitempty"`
+
+	// Average memory utilization of the component's pods, as a percentage of their requests, that it's scaled to keep.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	TargetMemoryUtilization *int32 `json:"target_memory_utilization,omitempty"`
+
+	// Whether a VerticalPodAutoscaler recommends the component's resource requests, without applying them. Requires
+	// the VerticalPodAutoscaler CRDs.
+	// +optional
+	VPARecommendations bool `json:"vpa_recommendations,omitempty"`
+}
+
+// PodDisruptionBudgetOverride limits how many of a core component's pods voluntary disruptions, such as node drains,
+// may take down at once. Only one of its fields may be set.
+type PodDisruptionBudgetOverride struct {
+	// Pods that must stay available, as a number or a percentage.
+	// +optional
+	MinAvailable *intstr.IntOrString `json:"min_available,omitempty"`
+
+	// Pods that may be unavailable, as a number or a percentage.
+	// +optional
+	MaxUnavailable *intstr.IntOrString `json:"max_unavailable,omitempty"`
+}
+
+// InjectionPolicy restricts sidecar injection beyond the watched namespaces.
+// Workloads are never injected in system namespaces (kube-system, kube-public, kube-node-lease, gm-operator),
+// nor if annotated with greymatter.io/exclude-sidecar: "true".
+type InjectionPolicy struct {
+	// If set, only namespaces with matching labels are eligible for injection.
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespace_selector,omitempty"`
+
+	// If set, only pods with matching labels are eligible for injection.
+	// +optional
+	PodSelector *metav1.LabelSelector `json:"pod_selector,omitempty"`
+
+	// Namespaces that are never eligible for injection.
+	// +optional
+	ExcludedNamespaces []string `json:"excluded_namespaces,omitempty"`
+
+	// What to do with workloads that another service mesh (Istio or Linkerd) injects its sidecar into:
+	// skip them, or inject a sidecar anyway. Either way, a warning event is emitted on the mesh.
+	// +kubebuilder:validation:Enum=skip;inject
+	// +kubebuilder:default=skip
+	// +optional
+	OtherMeshes string `json:"other_meshes,omitempty"`
+}
+
 // MeshStatus describes the observed state of a Grey Matter mesh.
 type MeshStatus struct {
 	SidecarList []string `json:"sidecar_list,omitempty"`
+
+	// Conditions describe the latest observations of the operator's management of this mesh.
+	// +optional
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+
+	// SidecarRecommendations are the CPU and memory requests recommended for each workload's sidecars from their
+	// sampled usage, if the operator's config.rightsizing is enabled.
+	// +optional
+	SidecarRecommendations []SidecarRecommendation `json:"sidecar_recommendations,omitempty"`
 }
 
+// SidecarRecommendation is the CPU and memory recommended for the sidecars of a workload, which is identified by its
+// namespace and the greymatter.io/cluster label of its pods.
+type SidecarRecommendation struct {
+	Namespace string `json:"namespace"`
+	Cluster   string `json:"cluster"`
+	// Recommended CPU request, e.g. "50m"
+	CPU string `json:"cpu"`
+	// Recommended memory request, e.g. "64Mi"
+	Memory string `json:"memory"`
+}
+
+// Condition types reported in MeshStatus.Conditions.
+const (
+	// ConditionGitOpsVerified is false when the latest fetched GitOps commit failed signature verification.
+	ConditionGitOpsVerified = "GitOpsVerified"
+	// ConditionStateBackupAvailable is false while Redis is unavailable and the operator's state is only kept in memory.
+	ConditionStateBackupAvailable = "StateBackupAvailable"
+	// ConditionGitOpsSourceReady is false when the GitOps source set in the mesh's spec can't be used.
+	ConditionGitOpsSourceReady = "GitOpsSourceReady"
+	// ConditionDeletionsReleased is false while deletions are held back by the deletion policy, until they're released.
+	ConditionDeletionsReleased = "DeletionsReleased"
+	// ConditionRolledOut is unknown while the Deployments and StatefulSets applied in the latest sync cycle roll out,
+	// and false if any fail to.
+	ConditionRolledOut = "RolledOut"
+	// ConditionStatePersisted is false while the operator's state has had changes not persisted to Redis for longer
+	// than config.reconcile.state_divergence_seconds.
+	ConditionStatePersisted = "StatePersisted"
+	// ConditionEdgeCertificatesReady is false while any certificate requested for the edge hosts isn't issued, or has
+	// expired.
+	ConditionEdgeCertificatesReady = "EdgeCertificatesReady"
+)
+
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
 // +kubebuilder:resource:scope=Cluster
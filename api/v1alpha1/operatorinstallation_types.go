@@ -0,0 +1,85 @@
+/*
+Copyright greymatter.io 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Cleanup policies of an OperatorInstallation.
+const (
+	// CleanupDelete deletes the resources an OperatorInstallation owns when it's deleted.
+	CleanupDelete = "Delete"
+	// CleanupOrphan leaves the resources an OperatorInstallation owns in place when it's deleted, no longer owned.
+	CleanupOrphan = "Orphan"
+)
+
+// OperatorInstallationSpec defines how the resources owned by an installation of the Grey Matter Operator are
+// cleaned up.
+type OperatorInstallationSpec struct {
+	// What happens to the resources the operator installed outside of a mesh (e.g. SPIRE) when the installation is
+	// deleted: Delete deletes them, and Orphan leaves them in place.
+	// +kubebuilder:validation:Enum=Delete;Orphan
+	// +kubebuilder:default=Delete
+	// +optional
+	CleanupPolicy string `json:"cleanup_policy,omitempty"`
+}
+
+// OperatorInstallationStatus describes the observed state of an installation of the Grey Matter Operator.
+type OperatorInstallationStatus struct {
+	// Conditions describe the latest observations of the operator's management of this installation.
+	// +optional
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+// Condition types reported in OperatorInstallationStatus.Conditions.
+const (
+	// ConditionCleanupBlocked is true while the installation is being deleted but the resources it owns can't be
+	// cleaned up, so its finalizer keeps it from being deleted.
+	ConditionCleanupBlocked = "CleanupBlocked"
+)
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:printcolumn:name="Cleanup Policy",type=string,JSONPath=`.spec.cleanup_policy`
+
+// OperatorInstallation owns the cluster-scoped resources installed by the Grey Matter Operator outside of a mesh,
+// such as SPIRE, and cleans them up when it's deleted.
+type OperatorInstallation struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              OperatorInstallationSpec   `json:"spec,omitempty"`
+	Status            OperatorInstallationStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// OperatorInstallationList contains a list of OperatorInstallation custom resources.
+type OperatorInstallationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []OperatorInstallation `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&OperatorInstallation{}, &OperatorInstallationList{})
+}
@@ -20,6 +20,31 @@ const OperatorImageURL = "docker.greymatter.io/development/gm-operator:latest"
 //go:embed *
 var configFS embed.FS
 
+// MeshCRD returns the YAML of the Mesh CustomResourceDefinition installed with the operator.
+func MeshCRD() ([]byte, error) {
+	return configFS.ReadFile("base/crd/bases/greymatter.io_meshes.yaml")
+}
+
+// CRDs returns the YAML of each CustomResourceDefinition installed with the operator, the Mesh's among them.
+func CRDs() ([][]byte, error) {
+	entries, err := fs.ReadDir(configFS, "base/crd/bases")
+	if err != nil {
+		return nil, err
+	}
+	var crds [][]byte
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".yaml") {
+			continue
+		}
+		b, err := configFS.ReadFile("base/crd/bases/" + entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		crds = append(crds, b)
+	}
+	return crds, nil
+}
+
 func MkKubernetesCommand(name, usage string) *cli.Command {
 	command := kubernetesCommand
 	command.Name = name
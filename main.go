@@ -17,24 +17,43 @@ limitations under the License.
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"net"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/greymatter-io/operator/pkg/admin"
+	"github.com/greymatter-io/operator/pkg/bootstrap"
 	"github.com/greymatter-io/operator/pkg/cfsslsrv"
+	"github.com/greymatter-io/operator/pkg/crds"
+	"github.com/greymatter-io/operator/pkg/credentials"
 	"github.com/greymatter-io/operator/pkg/cuemodule"
+	"github.com/greymatter-io/operator/pkg/egress"
 	"github.com/greymatter-io/operator/pkg/gitops"
 	"github.com/greymatter-io/operator/pkg/gmapi"
+	"github.com/greymatter-io/operator/pkg/k8sapi"
+	"github.com/greymatter-io/operator/pkg/logging"
 	"github.com/greymatter-io/operator/pkg/mesh_install"
+	"github.com/greymatter-io/operator/pkg/notify"
+	"github.com/greymatter-io/operator/pkg/preflight"
+	"github.com/greymatter-io/operator/pkg/stateredis"
 	"github.com/greymatter-io/operator/pkg/webhooks"
+	"github.com/greymatter-io/operator/pkg/xds"
 	configv1 "github.com/openshift/api/config/v1"
+	routev1 "github.com/openshift/api/route/v1"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	corev1 "k8s.io/api/core/v1"
 	extv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
@@ -49,13 +68,20 @@ import (
 var (
 	scheme = runtime.NewScheme()
 	logger = ctrl.Log.WithName("init")
+
+	// The operator's version, set when it's built with -ldflags "-X main.version=<version>".
+	version = "dev"
 )
 
+// How many of the latest log entries are kept for support bundles when the admin API is enabled.
+const supportBundleLogEntries = 5000
+
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 	utilruntime.Must(v1alpha1.AddToScheme(scheme))
 	utilruntime.Must(extv1.AddToScheme(scheme))
 	utilruntime.Must(configv1.AddToScheme(scheme))
+	utilruntime.Must(routev1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
@@ -65,14 +91,98 @@ var (
 	zapDevMode bool
 	pprofAddr  string
 
+	// Log encoding, per-subsystem verbosity, and sampling of repeated entries.
+	logFormat   string
+	logLevels   string
+	logSampling int
+
 	// Configuration flags for fetching the initial operator
 	// config repository on startup with Git.
 	syncRepo           string
 	syncSSHKeyPath     string
 	syncSSHKeyPassword string
+	syncKnownHostsPath string
+	syncInsecure       bool
 	syncTag            string
 	syncBranch         string
 	syncInterval       int
+
+	// Allow-lists of keys that must sign GitOps commits before they are applied.
+	syncVerifyKeyring        string
+	syncVerifyAllowedSigners string
+	// Public keys that must have signed an OCI artifact with cosign, and how many of them.
+	syncVerifyArtifactKeys    string
+	syncVerifyArtifactSigners int
+
+	// Comma-delimited path prefixes that must change for a new commit to trigger a reload.
+	syncPathFilters string
+
+	// Cache of the CUE dependencies the config repo lists, and whether to skip its git submodules.
+	cueModuleCache     string
+	syncSkipSubmodules bool
+
+	// Directory of the config repo checkout, e.g. on a PersistentVolumeClaim so it outlives restarts.
+	syncGitDir string
+
+	// Comma-delimited webhook targets notified of sync outcomes.
+	notifyWebhooks string
+
+	// Address for the admin API. Disabled if empty.
+	adminAddr string
+	// Bearer token, and TLS certificate, key, and client CAs, authenticating changes through the admin API.
+	adminToken    string
+	adminTLSCert  string
+	adminTLSKey   string
+	adminClientCA string
+	// Send Grey Matter config to in-memory mock Control and Catalog APIs, for local development.
+	mockAPIs bool
+	// Experimental: address for serving proxy config to sidecars over xDS instead of sending it to Control.
+	xdsAddr string
+	// TLS certificate, key, and client CAs authenticating sidecars to the xDS server.
+	xdsTLSCert  string
+	xdsTLSKey   string
+	xdsClientCA string
+	// Path to a state snapshot (exported from the admin API) to import on startup.
+	importStatePath string
+	// Identity of this operator among those sharing a Redis, namespacing its state keys.
+	operatorID string
+
+	// Path to the bootstrap file, and JSON overriding the CUE config.
+	configPath      string
+	configOverrides string
+
+	// Environment whose values file is unified over the CUE.
+	environment string
+
+	// Proxy and extra trusted CAs for reaching the git remote and other zones' Control APIs.
+	httpProxy    string
+	httpsProxy   string
+	noProxy      string
+	caBundlePath string
+
+	// External secret store and remote keys for sourcing the operator's own credentials via ExternalSecrets.
+	externalSecretStore      string
+	externalSecretStoreKind  string
+	imagePullSecretRemoteKey string
+	redisPasswordRemoteKey   string
+	gitCredentialsRemoteKey  string
+
+	// Deploy and manage a Redis in gm-operator for the operator's state, instead of using the one in the CUE defaults.
+	managedRedis             bool
+	managedRedisImage        string
+	managedRedisStorage      string
+	managedRedisStorageClass string
+	// BoltDB file to keep the operator's state in instead of Redis, e.g. on a PersistentVolumeClaim.
+	stateFile string
+
+	// Whether the operator refuses to start when any preflight check fails, or only a required one.
+	preflightPolicy string
+
+	// Install and upgrade the operator's CRDs from those embedded in it.
+	manageCRDs bool
+
+	// Seconds the work in flight is given to finish on shutdown before it's cut short.
+	shutdownTimeout int
 )
 
 func main() {
@@ -92,19 +202,69 @@ func run() error {
 	flag.StringVar(&cueRoot, "cueRoot", "core", "Path to the CUE module with Grey Matter config. Defaults to the current working directory.")
 	flag.BoolVar(&zapDevMode, "zapDevMode", false, "Configure zap logger in development mode.")
 	flag.StringVar(&pprofAddr, "pprofAddr", ":1234", "Address for pprof server; has no effect on release builds")
+	flag.StringVar(&logFormat, "logFormat", "", "Log encoding: 'json' or 'console'. Defaults to console in development mode, otherwise JSON.")
+	flag.StringVar(&logLevels, "logLevels", "", "Comma-delimited log levels of the gitops, gmapi, installer, and state subsystems, and the default for all other logs, each 'subsystem=level' with level debug, info, error, or an integer V-level (e.g. 'default=info,gitops=debug,state=2'). Can be changed at runtime through the admin API.")
+	flag.IntVar(&logSampling, "logSampling", 100, "Entries with the same message and level logged each second before only every Nth is, to limit high-frequency reconcile logs. 0 disables sampling.")
 
 	// Flags that enable gitops configuration loading from a git repo.
-	flag.StringVar(&syncRepo, "repo", "", "Bootstrap repository for operator configuration.")
+	flag.StringVar(&syncRepo, "repo", "", "Bootstrap repository for operator configuration, or an OCI artifact with it as oci://registry/repository (e.g. pushed with oras).")
 	flag.StringVar(&syncSSHKeyPath, "sshPrivateKeyPath", "", "SSH key which has privileges to fetch the operators core configuration from Git.")
 	flag.StringVar(&syncSSHKeyPassword, "sshPrivateKeyPassword", "", "Password for the SSH key")
-	flag.StringVar(&syncTag, "tag", "", "target tag to fetch and watch for changes in the core configuration repo.")
+	flag.StringVar(&syncKnownHostsPath, "sshKnownHostsPath", "", "known_hosts file (e.g. mounted from a Secret) for verifying the config repo's SSH host key. Defaults to $SSH_KNOWN_HOSTS or ~/.ssh/known_hosts.")
+	flag.BoolVar(&syncInsecure, "gitInsecureSkipVerify", false, "Skip verification of the config repo's SSH host key and HTTPS certificate. Only for lab use.")
+	flag.StringVar(&syncTag, "tag", "", "target tag to fetch and watch for changes in the core configuration repo, or the tag or digest of an OCI artifact.")
 	flag.StringVar(&syncBranch, "branch", "", "target branch to fetch and watch for changes in the core configuration repo. defaults to 'main' if no branch or tag specified")
 	flag.IntVar(&syncInterval, "interval", 30, "Interval to watch sync core config repo.")
+	flag.StringVar(&syncVerifyKeyring, "verifyKeyring", "", "Path to an armored GPG public keyring. If set, only commits signed by a key in the ring are applied.")
+	flag.StringVar(&syncVerifyAllowedSigners, "verifyAllowedSigners", "", "Path to an SSH allowed signers file. If set, only commits signed by a listed SSH key are applied.")
+	flag.StringVar(&syncVerifyArtifactKeys, "verifyArtifactKeys", "", "Path to PEM-encoded public keys (e.g. a cosign.pub). If set, only OCI artifacts signed with cosign by -verifyArtifactSigners of the keys are applied.")
+	flag.IntVar(&syncVerifyArtifactSigners, "verifyArtifactSigners", 1, "Number of the keys in -verifyArtifactKeys that must have signed an OCI artifact.")
+	flag.StringVar(&environment, "environment", "", "Name of the environment (e.g. 'prod') whose values file in the CUE module's environments/ directory is unified over the CUE.")
+	flag.StringVar(&cueModuleCache, "cueModuleCache", "", "Directory caching the CUE dependencies listed in the config repo's cue.mod/dependencies.yaml. Pre-populate it for offline use. Defaults to a temporary directory.")
+	flag.StringVar(&syncGitDir, "gitDir", "fetched_cue", "Directory of the config repo checkout. Point it at a subdirectory of a PersistentVolumeClaim mount to keep the checkout across restarts; a damaged checkout is cloned again.")
+	flag.BoolVar(&syncSkipSubmodules, "skipSubmodules", false, "Don't clone or pull the config repo's git submodules, e.g. when it lists its CUE dependencies in cue.mod/dependencies.yaml instead.")
+	flag.StringVar(&syncPathFilters, "pathFilters", "", "Comma-delimited path prefixes (e.g. 'gm/,k8s/'). If set, only commits changing files under these prefixes trigger a reload.")
+	flag.StringVar(&adminAddr, "adminAddr", "", "Address for the admin API (e.g. '127.0.0.1:9090'). Disabled if empty.")
+	flag.StringVar(&adminToken, "adminToken", "", "Bearer token that requests other than GET to the admin API must carry in an Authorization header. Unless it or -adminClientCA is set, the admin API only serves GET requests.")
+	flag.StringVar(&adminTLSCert, "adminTLSCert", "", "PEM-encoded certificate to serve the admin API over TLS with, along with -adminTLSKey.")
+	flag.StringVar(&adminTLSKey, "adminTLSKey", "", "PEM-encoded private key of -adminTLSCert.")
+	flag.StringVar(&adminClientCA, "adminClientCA", "", "PEM-encoded CAs whose client certificates authenticate requests other than GET to the admin API, when it's served over TLS.")
+	flag.BoolVar(&mockAPIs, "mockAPIs", false, "Send Grey Matter config to in-memory mock Control and Catalog APIs instead of the mesh's, so the greymatter CLI and a control plane aren't needed. Only for local development.")
+	flag.StringVar(&xdsAddr, "xdsAddr", "", "Experimental: address (e.g. ':18000') for serving the mesh's proxies, listeners, domains, routes, and clusters to sidecars directly, over Envoy's v3 REST-JSON xDS API, instead of sending them to Control. Requires -xdsTLSCert, -xdsTLSKey, and -xdsClientCA. Disabled if empty.")
+	flag.StringVar(&xdsTLSCert, "xdsTLSCert", "", "PEM-encoded certificate to serve xDS over TLS with, along with -xdsTLSKey.")
+	flag.StringVar(&xdsTLSKey, "xdsTLSKey", "", "PEM-encoded private key of -xdsTLSCert.")
+	flag.StringVar(&xdsClientCA, "xdsClientCA", "", "PEM-encoded CAs whose client certificates sidecars must present to be served xDS.")
+	flag.StringVar(&operatorID, "operatorID", "gm-operator", "Identity of this operator among those sharing a Redis. Its state is saved under keys prefixed with the identity and the mesh name (e.g. 'gm-operator:mesh-sample:'), so operators and meshes don't overwrite each other's state.")
+	flag.StringVar(&importStatePath, "importState", "", "Path to a state snapshot exported from the admin API, imported on startup before any config is applied.")
+	flag.StringVar(&notifyWebhooks, "notifyWebhooks", "", "Comma-delimited webhooks notified of sync outcomes, each 'slack=URL', 'teams=URL', 'generic=URL', or a bare URL (generic).")
+	flag.StringVar(&httpProxy, "httpProxy", "", "Proxy for HTTP requests by git and the greymatter CLI. Defaults to $HTTP_PROXY.")
+	flag.StringVar(&httpsProxy, "httpsProxy", "", "Proxy for HTTPS requests by git and the greymatter CLI. Defaults to $HTTPS_PROXY.")
+	flag.StringVar(&noProxy, "noProxy", "", "Comma-delimited hosts that aren't proxied, in addition to in-cluster .svc and .cluster.local hosts. Defaults to $NO_PROXY.")
+	flag.StringVar(&caBundlePath, "caBundlePath", "", "PEM-encoded CA certificates trusted in addition to the system roots by git and the greymatter CLI.")
+	flag.StringVar(&externalSecretStore, "externalSecretStore", "", "Name of an external-secrets store to source the operator's credentials from with ExternalSecrets in gm-operator.")
+	flag.StringVar(&externalSecretStoreKind, "externalSecretStoreKind", "SecretStore", "Kind of the external-secrets store: SecretStore or ClusterSecretStore.")
+	flag.StringVar(&imagePullSecretRemoteKey, "imagePullSecretRemoteKey", "", "Remote key of the docker config JSON materialized as the gm-docker-secret image pull secret.")
+	flag.StringVar(&redisPasswordRemoteKey, "redisPasswordRemoteKey", "", "Remote key of the Redis password for state backup, overriding the one in the CUE.")
+	flag.StringVar(&gitCredentialsRemoteKey, "gitCredentialsRemoteKey", "", "Remote key with an 'ssh-privatekey' (and optional 'passphrase' and 'known_hosts') or 'token' property for the config repo.")
+
+	flag.BoolVar(&managedRedis, "managedRedis", false, "Deploy and manage a Redis StatefulSet in gm-operator for the operator's state, with a generated password, instead of using defaults.redis_host from the CUE.")
+	flag.StringVar(&managedRedisImage, "managedRedisImage", stateredis.DefaultImage, "Image of the managed Redis.")
+	flag.StringVar(&managedRedisStorage, "managedRedisStorage", stateredis.DefaultStorage, "Size of the managed Redis's PersistentVolumeClaim. Only used when it's first created.")
+	flag.StringVar(&managedRedisStorageClass, "managedRedisStorageClass", "", "StorageClass of the managed Redis's PersistentVolumeClaim. Defaults to the cluster's default.")
+
+	flag.StringVar(&stateFile, "stateFile", "", "BoltDB file to keep the operator's state in instead of Redis, overriding defaults.state_file from the CUE. Point it at a PersistentVolumeClaim mount so state outlives restarts. Only one replica can use the file at a time.")
+
+	flag.BoolVar(&manageCRDs, "manageCRDs", true, "Install the operator's CustomResourceDefinitions on startup, and upgrade them when their embedded definitions change. Disable if they're installed separately, e.g. by OLM.")
+	flag.IntVar(&shutdownTimeout, "shutdownTimeout", 30, "Seconds the sync cycle and applies in flight, and the Grey Matter config commands queued, are given to finish when the operator is signaled to shut down, before they're cut short. An interrupted sync cycle is re-run on the next start. Keep it below the pod's terminationGracePeriodSeconds.")
+	flag.StringVar(&preflightPolicy, "preflightPolicy", preflight.PolicyDegrade, "What to do when startup preflight checks fail: 'strict' refuses to start if any fails, and 'degrade' starts unless one the operator can't run without (CRDs, config checkout, or CUE) fails.")
+
+	// Layered configuration: the bootstrap file and CUE config overrides.
+	flag.StringVar(&configPath, bootstrap.PathFlag, "", "Path to a YAML bootstrap file with flag names as keys and a 'config' object overriding the CUE config. Overridden by GM_OPERATOR_* environment variables and flags.")
+	flag.StringVar(&configOverrides, bootstrap.ConfigKey, "", "JSON object overriding keys of the CUE config (e.g. '{\"prune_orphans\":true}'), merged over the bootstrap file's and $GM_OPERATOR_CONFIG.")
 
 	// Bind flags for Zap logger options.
-	opts := zap.Options{Development: zapDevMode}
+	opts := zap.Options{}
 	opts.BindFlags(flag.CommandLine)
-	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
 	// Create context for goroutine cleanup
 	ctx := ctrl.SetupSignalHandler()
@@ -112,8 +272,72 @@ func run() error {
 	// We have to call Parse late for some reason
 	flag.Parse()
 
-	// If neither a branch nor a tag is specified, default to the main branch
-	if syncBranch == "" && syncTag == "" {
+	// Fill in flags not set on the command line from environment variables and the bootstrap file
+	bootstrapConfig, err := bootstrap.Load(flag.CommandLine, "sshPrivateKeyPassword", "adminToken")
+	if err != nil {
+		return err
+	}
+	opts.Development = opts.Development || zapDevMode
+	levels, err := logging.ParseLevels(logLevels)
+	if err != nil {
+		return err
+	}
+	// The latest logs are kept for the admin API's support bundles
+	var recentLogs *logging.Recent
+	if adminAddr != "" {
+		recentLogs = logging.NewRecent(supportBundleLogEntries)
+	}
+	log, verbosity, err := logging.New(logging.Options{Format: logFormat, Levels: levels, Sampling: logSampling, Zap: opts, Recent: recentLogs})
+	if err != nil {
+		return err
+	}
+	ctrl.SetLogger(log)
+	bootstrapConfig.Log()
+	if err := cuemodule.ValidateConfigOverrides(bootstrapConfig.ConfigOverrides); err != nil {
+		return err
+	}
+	// Wherever the CUE is loaded, it's overridden by the bootstrap config and unified with the environment's values
+	cueOptions := []func(*cuemodule.OperatorCUE){
+		cuemodule.WithConfigOverrides(bootstrapConfig.ConfigOverrides),
+		cuemodule.WithEnvironment(environment),
+	}
+
+	// Fail fast on invalid flags, before anything is fetched or created
+	if err := bootstrap.Validate(bootstrap.Flags{
+		OperatorID:              operatorID,
+		Repo:                    syncRepo,
+		Branch:                  syncBranch,
+		Tag:                     syncTag,
+		Interval:                syncInterval,
+		ArtifactKeys:            syncVerifyArtifactKeys,
+		ArtifactSigners:         syncVerifyArtifactSigners,
+		Environment:             environment,
+		HTTPProxy:               httpProxy,
+		HTTPSProxy:              httpsProxy,
+		ExternalSecretStore:     externalSecretStore,
+		ExternalSecretStoreKind: externalSecretStoreKind,
+		RemoteKeys: map[string]string{
+			"imagePullSecretRemoteKey": imagePullSecretRemoteKey,
+			"redisPasswordRemoteKey":   redisPasswordRemoteKey,
+			"gitCredentialsRemoteKey":  gitCredentialsRemoteKey,
+		},
+		ManagedRedis:        managedRedis,
+		ManagedRedisStorage: managedRedisStorage,
+		StateFile:           stateFile,
+		PreflightPolicy:     preflightPolicy,
+		AdminTLSCert:        adminTLSCert,
+		AdminTLSKey:         adminTLSKey,
+		AdminClientCA:       adminClientCA,
+		XDSAddr:             xdsAddr,
+		XDSTLSCert:          xdsTLSCert,
+		XDSTLSKey:           xdsTLSKey,
+		XDSClientCA:         xdsClientCA,
+	}).Err(); err != nil {
+		return err
+	}
+
+	// If neither a branch nor a tag is specified, default to the main branch (an OCI artifact defaults to latest)
+	if syncBranch == "" && syncTag == "" && !gitops.IsOCI(syncRepo) {
 		syncBranch = "main"
 	}
 
@@ -122,33 +346,199 @@ func run() error {
 	// build sync options based on user configuration.
 	syncOpts := []func(*gitops.Sync){}
 	syncOpts = append(syncOpts, gitops.WithSSHInfo(syncSSHKeyPath, syncSSHKeyPassword))
+	syncOpts = append(syncOpts, gitops.WithHostKeyVerification(syncKnownHostsPath, syncInsecure))
 	syncOpts = append(syncOpts, gitops.WithRepoInfo(syncRepo, syncBranch, syncTag))
+	if syncInsecure {
+		logger.Info("WARNING: -gitInsecureSkipVerify is set; the config repo's SSH host key and HTTPS certificate are not verified")
+	}
+	syncOpts = append(syncOpts, gitops.WithCommitVerification(syncVerifyKeyring, syncVerifyAllowedSigners))
+	syncOpts = append(syncOpts, gitops.WithArtifactVerification(syncVerifyArtifactKeys, syncVerifyArtifactSigners))
+	syncOpts = append(syncOpts, gitops.WithPathFilters(strings.Split(syncPathFilters, ",")...))
+	syncOpts = append(syncOpts, gitops.WithCUEDependencies(cueModuleCache, syncSkipSubmodules))
+	syncOpts = append(syncOpts, gitops.WithOperatorID(operatorID))
+	if stateFile != "" {
+		syncOpts = append(syncOpts, gitops.WithStateFile(stateFile))
+	}
+	egressConfig := egress.Config{HTTPProxy: httpProxy, HTTPSProxy: httpsProxy, NoProxy: noProxy, CABundlePath: caBundlePath}
+	if egressConfig.Enabled() {
+		transport, err := egressConfig.Transport()
+		if err != nil {
+			return fmt.Errorf("invalid proxy or CA bundle: %w", err)
+		}
+		syncOpts = append(syncOpts, gitops.WithHTTPTransport(transport))
+	}
+	notifier, err := notify.New(ctx, strings.Split(notifyWebhooks, ","))
+	if err != nil {
+		return fmt.Errorf("invalid notification webhooks: %w", err)
+	}
+	if notifier != nil {
+		syncOpts = append(syncOpts, gitops.WithListener(notifier))
+	}
 
-	// Create a context we can cancel and clean up our go routine with.
-	sync := gitops.New(syncRepo, ctx, nil, syncOpts...)
+	// Create a rest.Config that has settings for communicating with the K8s cluster.
+	restConfig := ctrl.GetConfigOrDie()
 
-	if syncRepo != "" {
-		// GitDir should be cueRoot (where the operator expects to load its config from)
-		cueRoot = "fetched_cue"
-		sync.GitDir = cueRoot
-		err := sync.Bootstrap()
+	// Create a write+read client for making requests to the API server.
+	c, err := client.NewWithWatch(restConfig, client.Options{Scheme: scheme})
+	if err != nil {
+		return fmt.Errorf("failed to create initial client: %w", err)
+	}
+
+	// Deploy the operator's own Redis for its state, if configured.
+	// It needn't be ready yet: state is kept in memory until it can be reached.
+	if managedRedis {
+		password, err := stateredis.Apply(ctx, c, stateredis.Options{
+			Image:        managedRedisImage,
+			Storage:      managedRedisStorage,
+			StorageClass: managedRedisStorageClass,
+		})
 		if err != nil {
-			return fmt.Errorf("failed to load operator initial configuration: %w", err)
+			return err
 		}
-		// sync.Watch() will happen inside of mesh_install.New
+		syncOpts = append(syncOpts, gitops.WithRedisAddress(stateredis.Host(), stateredis.Port), gitops.WithRedisPassword(password))
 	}
 
-	// Immediately load all CUE
-	operatorCUE, initialMesh, err := cuemodule.LoadAll(cueRoot)
+	// Source the operator's own credentials from an external secret store, if configured.
+	// Those needed before the manager starts are waited on here; the image pull secret is waited on by the installer.
+	credentialSource := credentials.Source{
+		SecretStore:        externalSecretStore,
+		SecretStoreKind:    externalSecretStoreKind,
+		ImagePullSecretKey: imagePullSecretRemoteKey,
+		RedisPasswordKey:   redisPasswordRemoteKey,
+		GitCredentialsKey:  gitCredentialsRemoteKey,
+	}
+	var credentialWatcher *credentials.Watcher
+	if credentialSource.Enabled() {
+		credentialWatcher = credentials.NewWatcher(c, credentialSource)
+		if err := credentialWatcher.Apply(ctx); err != nil {
+			return err
+		}
+		if gitCredentialsRemoteKey != "" {
+			secret, err := credentialWatcher.Wait(ctx, credentials.GitCredentialsSecretName)
+			if err != nil {
+				return err
+			}
+			syncOpts = append(syncOpts, gitops.WithGitCredentials(credentials.GitCredentials(secret)))
+		}
+		if redisPasswordRemoteKey != "" {
+			secret, err := credentialWatcher.Wait(ctx, credentials.RedisPasswordSecretName)
+			if err != nil {
+				return err
+			}
+			syncOpts = append(syncOpts, gitops.WithRedisPassword(string(secret.Data[credentials.RedisPasswordKey])))
+		}
+	}
+
+	// GitOps syncs, the state backup, and greymatter CLI clients outlive the signal to shut down, so their work in
+	// flight can be drained in order once the manager has stopped (see Installer.Shutdown).
+	runCtx, stopRun := context.WithCancel(context.Background())
+	defer stopRun()
+
+	// Create a context we can cancel and clean up our go routine with.
+	syncCtx, stopSync := context.WithCancel(runCtx)
+	sync := gitops.New(syncRepo, syncCtx, stopSync, syncOpts...)
+
+	// The checkout lives here whether it's cloned now from the flags, or later from a Mesh's GitOps source
+	sync.GitDir = syncGitDir
+	sync.Interval = syncInterval
+
+	// Install or upgrade the operator's CRDs, unless they're installed separately.
+	// Whether they're then established (whoever installed them) is checked below.
+	embeddedCRDs, err := crds.Embedded()
 	if err != nil {
-		// initial load panics if unsuccessful, because we need valid config to start up
-		panic(err)
+		return err
+	}
+	permissions := preflight.OperatorPermissions
+	if manageCRDs {
+		if err := crds.Apply(ctx, c, embeddedCRDs); err != nil {
+			logger.Error(err, "Failed to install or upgrade CustomResourceDefinitions")
+		}
+		permissions = append(permissions, preflight.Permission{Group: "apiextensions.k8s.io", Resource: "customresourcedefinitions", Verbs: []string{"create"}})
+		for _, name := range crds.Names(embeddedCRDs) {
+			permissions = append(permissions, preflight.Permission{Group: "apiextensions.k8s.io", Resource: "customresourcedefinitions", Name: name, Verbs: []string{"update"}})
+		}
+	}
+
+	// Check everything the operator depends on before starting, reporting every problem at once.
+	// The config is checked out from the flags' repo (watched from inside of mesh_install.New) and its CUE loaded here.
+	var operatorCUE *cuemodule.OperatorCUE
+	var initialMesh *v1alpha1.Mesh
+	var effectiveConfig cuemodule.Config
+	checks := []preflight.Check{
+		preflight.CRDs(c, crds.Names(embeddedCRDs)...),
+		preflight.RBAC(c, permissions),
+	}
+	if syncRepo != "" {
+		// GitDir should be cueRoot (where the operator expects to load its config from)
+		cueRoot = sync.GitDir
+		checks = append(checks,
+			preflight.Check{Name: "git", Run: sync.CheckRemote},
+			preflight.Check{Name: "checkout", Required: true, Run: func(context.Context) error {
+				if err := sync.Bootstrap(); err != nil {
+					return fmt.Errorf("failed to load operator initial configuration: %w", err)
+				}
+				return nil
+			}},
+		)
+	}
+	cueCheck := preflight.Check{Name: "cue", Required: true, Run: func(ctx context.Context) error {
+		var err error
+		if operatorCUE, initialMesh, err = cuemodule.LoadAll(cueRoot, cueOptions...); err != nil {
+			return err
+		}
+		logger.Info(fmt.Sprintf("Loaded CUE module from %s", cueRoot))
+		var defaults cuemodule.Defaults
+		effectiveConfig, defaults = operatorCUE.ExtractConfig()
+		logger.Info("Effective CUE config", "config", effectiveConfig)
+		if managedRedis {
+			defaults.RedisHost, defaults.RedisPort = stateredis.Host(), stateredis.Port
+		}
+		if stateFile != "" {
+			defaults.StateFile = stateFile
+		}
+		if err := cuemodule.Validate(effectiveConfig, defaults, initialMesh).Err(); err != nil {
+			return err
+		}
+		// The operator's own Redis, if configured apart from the mesh's, is read with credentials from its Secret
+		if stateRedis := effectiveConfig.StateRedis; stateRedis.Host != "" && stateRedis.CredentialsSecret != "" && !managedRedis && defaults.StateFile == "" {
+			username, password, err := credentials.RedisCredentials(ctx, c, stateRedis.CredentialsSecret)
+			if err != nil {
+				return err
+			}
+			sync.SetStateRedisCredentials(username, password)
+		}
+		return nil
+	}}
+	if syncRepo != "" {
+		cueCheck.After = []string{"checkout"}
+	}
+	checks = append(checks, cueCheck)
+	// The managed Redis was only just deployed, so it isn't expected to be reachable yet.
+	if !managedRedis {
+		checks = append(checks, preflight.Check{Name: "state_store", After: []string{"cue"}, Run: func(ctx context.Context) error {
+			return sync.CheckStateStore(ctx, operatorCUE)
+		}})
+	}
+	if !mockAPIs {
+		checks = append(checks, preflight.Check{Name: "dns", After: []string{"cue"}, Run: func(ctx context.Context) error {
+			return preflight.DNS(preflight.APIHosts(effectiveConfig, initialMesh)...).Run(ctx)
+		}})
+	}
+	report := preflight.Run(ctx, checks...)
+	report.Log()
+	if err := report.Err(preflightPolicy); err != nil {
+		return err
 	}
-	logger.Info(fmt.Sprintf("Loaded CUE module from %s", cueRoot))
 
 	// StartStateBackup initiates the diffing mechanism internal to the operator
 	// to maintain it's state in the deployed redis instance.
-	sync.StartStateBackup(ctx, operatorCUE, initialMesh)
+	sync.StartStateBackup(runCtx, operatorCUE, initialMesh)
+
+	if importStatePath != "" {
+		if err := importState(sync, importStatePath); err != nil {
+			return err
+		}
+	}
 
 	// Initialize operator options with set values.
 	// These values will not be replaced by any values set in a read configPath.
@@ -173,19 +563,21 @@ func run() error {
 		return fmt.Errorf("failed to start CFSSL server: %w", err)
 	}
 
-	// Initialize interface with greymatter CLI
-	gmcli, err := gmapi.New(ctx, operatorCUE)
-	if err != nil {
+	// Initialize interface with greymatter CLI, or with mock APIs standing in for the mesh's
+	var gmcli *gmapi.CLI
+	if mockAPIs {
+		mock := gmapi.NewMockAPI()
+		defer mock.Close()
+		logger.Info("WARNING: -mockAPIs is set; Grey Matter config is sent to in-memory mock APIs instead of the mesh's", "Control", mock.Control.URL, "Catalog", mock.Catalog.URL)
+		gmcli = mock.NewCLI(runCtx, operatorCUE)
+	} else if gmcli, err = gmapi.New(runCtx, operatorCUE); err != nil {
 		return err
 	}
-
-	// Create a rest.Config that has settings for communicating with the K8s cluster.
-	restConfig := ctrl.GetConfigOrDie()
-
-	// Create a write+read client for making requests to the API server.
-	c, err := client.New(restConfig, client.Options{Scheme: scheme})
-	if err != nil {
-		return fmt.Errorf("failed to create initial client: %w", err)
+	gmcli.SetEnv(egressConfig.Env())
+	var xdsServer *xds.Server
+	if xdsAddr != "" {
+		logger.Info("WARNING: -xdsAddr is set; Grey Matter config is served to sidecars over xDS instead of sent to Control", "Addr", xdsAddr)
+		xdsServer = xds.New(xdsAddr, xds.WithTLS(xdsTLSCert, xdsTLSKey, xdsClientCA))
 	}
 
 	// Initialize controller-runtime manager with configured options
@@ -195,20 +587,63 @@ func run() error {
 	}
 
 	// Initialize manifests mesh_install.
-	inst, err := mesh_install.New(&c, operatorCUE, initialMesh, cueRoot, gmcli, cfssl, sync)
+	inst, err := mesh_install.New(c, operatorCUE, initialMesh, cueRoot, gmcli, cfssl, sync, mgr.GetEventRecorderFor("gm-operator"))
 	if err != nil {
 		return fmt.Errorf("failed to initialize manifest mesh_install: %w", err)
 	}
+	inst.OperatorVersion = version
+	inst.OperatorID = operatorID
+	inst.CUEOptions = cueOptions
+	if _, port, err := net.SplitHostPort(adminAddr); err == nil && adminTLSCert == "" {
+		// Where the operator's sidecar sends traffic, with config.self_registration, over plain HTTP
+		inst.AdminPort, _ = strconv.Atoi(port)
+	}
+	inst.AdminTokenRequired = adminToken != ""
+	k8sapi.SetTimeout(inst.Config.Reconcile.APITimeout())
+	if xdsServer != nil {
+		// Unless the mesh's xds feature is switched off
+		gmcli.DeliverControlConfig(xdsServer, func() bool { return inst.FeatureEnabled(cuemodule.FeatureXDS) })
+	}
 
 	// Initialize the webhooks loader.
-	wl, err := webhooks.New(&c, inst, gmcli, cfssl, mgr.GetWebhookServer)
+	wl, err := webhooks.New(c, inst, gmcli, cfssl, mgr.GetWebhookServer)
 	if err != nil {
 		return err
 	}
 
+	// React to credentials rotated in the external secret store.
+	if credentialWatcher != nil {
+		if gitCredentialsRemoteKey != "" {
+			credentialWatcher.OnChange(credentials.GitCredentialsSecretName, func(secret *corev1.Secret) {
+				sync.SetGitCredentials(credentials.GitCredentials(secret))
+			})
+		}
+		if redisPasswordRemoteKey != "" {
+			credentialWatcher.OnChange(credentials.RedisPasswordSecretName, func(secret *corev1.Secret) {
+				if sync.SyncState != nil {
+					sync.SyncState.SetRedisPassword(string(secret.Data[credentials.RedisPasswordKey]))
+				}
+			})
+		}
+		if imagePullSecretRemoteKey != "" {
+			credentialWatcher.OnChange(credentials.ImagePullSecretName, inst.SetImagePullSecret)
+		}
+		go credentialWatcher.Watch(ctx)
+	}
+
 	// Register our webhooks loader and manifests mesh_install into the controller manager's start process queue.
 	mgr.Add(wl)
 	mgr.Add(inst)
+	if xdsServer != nil {
+		mgr.Add(xdsServer)
+	}
+	if adminAddr != "" {
+		mgr.Add(admin.New(adminAddr, sync, inst, admin.WithVerbosity(verbosity),
+			admin.WithRecentLogs(recentLogs), admin.WithStartupConfig(bootstrapConfig),
+			admin.WithToken(adminToken), admin.WithTLS(adminTLSCert, adminTLSKey, adminClientCA),
+			// Once the operator is reachable through the edge, only its metrics are served without authentication
+			admin.WithAuthenticatedReads(inst.Config.SelfRegistration.Enabled)))
+	}
 
 	//+kubebuilder:scaffold:builder
 
@@ -218,10 +653,39 @@ func run() error {
 	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
 		return fmt.Errorf("failed to set up readyz endpoint: %w", err)
 	}
+	// Not ready to be sent admission requests until the webhook server is serving
+	if err := mgr.AddReadyzCheck("webhooks", mgr.GetWebhookServer().StartedChecker()); err != nil {
+		return fmt.Errorf("failed to set up readyz endpoint: %w", err)
+	}
+
+	err = mgr.Start(ctx)
 
-	if err := mgr.Start(ctx); err != nil {
+	// The manager has stopped, so no more admission requests or reconciles arrive; finish or checkpoint the work in
+	// flight before exiting
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(shutdownTimeout)*time.Second)
+	defer cancel()
+	if err := inst.Shutdown(shutdownCtx); err != nil {
+		logger.Error(err, "Failed to shut down cleanly")
+	}
+
+	if err != nil {
 		return fmt.Errorf("failed to start controller-manager: %w", err)
 	}
+	return nil
+}
 
+// importState loads a state snapshot from a file into the sync state.
+func importState(sync *gitops.Sync, path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read state snapshot: %w", err)
+	}
+	var snap gitops.StateSnapshot
+	if err := json.Unmarshal(b, &snap); err != nil {
+		return fmt.Errorf("failed to parse state snapshot %s: %w", path, err)
+	}
+	if err := sync.ImportState(snap); err != nil {
+		return fmt.Errorf("failed to import state snapshot %s: %w", path, err)
+	}
 	return nil
 }
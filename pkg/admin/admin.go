@@ -0,0 +1,748 @@
+// Package admin serves operational endpoints for operator administrators. Requests other than GET change the
+// operator's state, so they must authenticate (see WithToken and WithTLS). Routes served only by a config source
+// implementing an optional interface (e.g. Renderer) return 501 Not Implemented otherwise.
+//
+//	GET  /state   exports the operator's internal state as JSON
+//	POST /state   imports a previously exported state
+//	POST /state/rebuild
+//	              purges the state and re-applies the mesh in full, rebuilding it
+//	GET  /config  exports the Grey Matter config derived from CUE as a .tar.gz of JSON files
+//	              (with ?live=true, also the config currently in Control and Catalog)
+//	GET  /render  returns the K8s manifests and Grey Matter config rendered from the current checkout as JSON
+//	GET  /topology
+//	              returns the services, clusters, routes, and edges of the Grey Matter config derived from CUE as JSON
+//	              (with ?live=true, of the config currently in Control and Catalog)
+//	POST /simulate
+//	              returns the K8s and Grey Matter config objects a sync cycle would create, change, and delete if the
+//	              config tree uploaded as a tarball (e.g. by git archive) were checked out, without applying them
+//	GET  /source  returns the GitOps source being synced
+//	POST /source  switches the GitOps source to the branch or tag given as JSON (e.g. {"tag": "v1.2.0"})
+//	GET  /deletions
+//	              lists the deletions held back by the deletion policy
+//	POST /deletions
+//	              releases the held deletions, deleting the objects
+//	GET  /logging returns the log level of each subsystem
+//	POST /logging sets the log levels of the subsystems given as JSON (e.g. {"gitops": "debug"})
+//	GET  /queue   lists the commands not yet sent to Control and Catalog successfully
+//	POST /queue/flush
+//	              drops the commands waiting to be sent (with ?mesh= and ?api=control|catalog, only those)
+//	POST /queue/cancel?id=
+//	              drops a queued command, interrupting it if it's in flight
+//	GET  /workloads
+//	              lists the pre-existing workloads adopted into the mesh
+//	POST /workloads/adopt?kind=&namespace=&name=&port=
+//	              adopts a pre-existing Deployment or StatefulSet, injecting a sidecar to the port
+//	POST /workloads/rollback?kind=&namespace=&name=
+//	              rolls back a workload's adoption, removing everything it added
+//	GET  /sidecars/recommendations
+//	              returns the CPU and memory requests recommended for each workload's sidecars from their usage
+//	GET  /features
+//	              returns whether each of the mesh's feature flags is on
+//	GET  /metrics returns the operator's Prometheus metrics, also served on the metrics address
+//	GET  /support-bundle
+//	              returns a .tar.gz of the operator's latest logs, its config with secrets redacted, its state, the
+//	              latest sync report, the rendered manifests, and the status of the core components' pods
+package admin
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/greymatter-io/operator/pkg/bootstrap"
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	"github.com/greymatter-io/operator/pkg/gitops"
+	"github.com/greymatter-io/operator/pkg/gmapi"
+	"github.com/greymatter-io/operator/pkg/logging"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	logger = ctrl.Log.WithName("admin")
+)
+
+// Server serves the admin API. It is added to the controller manager as a Runnable,
+// so it only runs on the elected leader (whose state is the one that matters).
+type Server struct {
+	addr      string
+	sync      *gitops.Sync
+	config    ConfigSource
+	verbosity *logging.Verbosity
+	mux       *http.ServeMux
+
+	// Authenticate requests that change the operator's state, which are refused unless one is set
+	token        string
+	certFile     string
+	keyFile      string
+	clientCAFile string
+	// Whether reads other than of the metrics must be authenticated too
+	authenticateReads bool
+
+	// Included in support bundles, if set
+	recentLogs    *logging.Recent
+	startupConfig *bootstrap.Config
+}
+
+// ConfigSource provides the Grey Matter config objects exported by the admin API.
+type ConfigSource interface {
+	// DerivedConfig returns the config objects currently derived from CUE, along with their kinds.
+	DerivedConfig() ([]json.RawMessage, []string, error)
+	// LiveConfig returns the config objects currently in Control and Catalog, along with their kinds.
+	LiveConfig(ctx context.Context) ([]json.RawMessage, []string, error)
+}
+
+// Reapplier re-applies the mesh in full. If the config source given to New is also a Reapplier,
+// POST /state/rebuild re-applies the mesh after purging the state, instead of waiting for the next sync.
+type Reapplier interface {
+	Reapply() error
+}
+
+// Renderer renders the K8s manifests and Grey Matter config objects the operator would apply.
+// If the config source given to New is also a Renderer, GET /render returns them.
+type Renderer interface {
+	Render() (manifests []client.Object, configs []json.RawMessage, kinds []string, err error)
+}
+
+// TopologySource describes the structure of the mesh's Grey Matter config.
+// If the config source given to New is also a TopologySource, GET /topology returns it.
+type TopologySource interface {
+	Topology(ctx context.Context, live bool) (cuemodule.Topology, error)
+}
+
+// Simulator computes what a sync cycle would change if a config tree were checked out.
+// If the config source given to New is also a Simulator, POST /simulate returns the changes of an uploaded tree.
+type Simulator interface {
+	Simulate(cueRoot string) (gitops.SimulatedChanges, error)
+}
+
+// Releaser deletes the objects whose deletion is held back by the deletion policy.
+// If the config source given to New is also a Releaser, POST /deletions releases them.
+type Releaser interface {
+	ReleaseDeletions() (gitops.HeldDeletions, error)
+}
+
+// CommandQueue exposes the commands waiting to be sent to Control and Catalog.
+// If the config source given to New is also a CommandQueue, /queue lists, flushes, and cancels them.
+type CommandQueue interface {
+	Queue() []gmapi.QueuedCommand
+	FlushQueue(mesh, api string) int
+	CancelCommand(id uint64) bool
+}
+
+// Adopter adopts pre-existing workloads into the mesh, and rolls back their adoption.
+// If the config source given to New is also an Adopter, /workloads lists, adopts, and rolls back workloads.
+type Adopter interface {
+	AdoptedWorkloads() []gitops.AdoptedWorkload
+	AdoptWorkload(ctx context.Context, kind, namespace, name, port string) error
+	RollbackAdoption(ctx context.Context, kind, namespace, name string) error
+}
+
+// Rightsizer recommends the resources requested by workloads' sidecars from their usage.
+// If the config source given to New is also a Rightsizer, GET /sidecars/recommendations returns its recommendations.
+type Rightsizer interface {
+	SidecarRecommendations() []v1alpha1.SidecarRecommendation
+}
+
+// FeatureSource has the mesh's feature flags.
+// If the config source given to New is also a FeatureSource, GET /features returns whether each is on.
+type FeatureSource interface {
+	Features() map[string]bool
+}
+
+// RenderedObjects are the rendered K8s manifests and Grey Matter config objects returned by GET /render.
+type RenderedObjects struct {
+	K8sManifests []client.Object  `json:"k8s_manifests"`
+	MeshConfigs  []RenderedConfig `json:"mesh_configs"`
+}
+
+// RenderedConfig is a rendered Grey Matter config object and its kind.
+type RenderedConfig struct {
+	Kind   string          `json:"kind"`
+	Object json.RawMessage `json:"object"`
+}
+
+// WithVerbosity lets the admin API change the levels of the operator's logs.
+func WithVerbosity(v *logging.Verbosity) func(*Server) {
+	return func(s *Server) {
+		s.verbosity = v
+	}
+}
+
+// New returns a *Server listening on addr that manages the state of the given sync,
+// and exports the Grey Matter config of the given source.
+func New(addr string, sync *gitops.Sync, config ConfigSource, opts ...func(*Server)) *Server {
+	s := &Server{addr: addr, sync: sync, config: config, mux: http.NewServeMux()}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.mux.HandleFunc("/state", s.handleState)
+	s.mux.HandleFunc("/state/rebuild", s.handleRebuild)
+	s.mux.HandleFunc("/config", s.handleConfig)
+	s.mux.HandleFunc("/render", s.handleRender)
+	s.mux.HandleFunc("/topology", s.handleTopology)
+	s.mux.HandleFunc("/simulate", s.handleSimulate)
+	s.mux.HandleFunc("/source", s.handleSource)
+	s.mux.HandleFunc("/deletions", s.handleDeletions)
+	s.mux.HandleFunc("/logging", s.handleLogging)
+	s.mux.HandleFunc("/queue", s.handleQueue)
+	s.mux.HandleFunc("/queue/flush", s.handleQueueFlush)
+	s.mux.HandleFunc("/queue/cancel", s.handleQueueCancel)
+	s.mux.HandleFunc("/workloads", s.handleWorkloads)
+	s.mux.HandleFunc("/workloads/adopt", s.handleAdopt)
+	s.mux.HandleFunc("/workloads/rollback", s.handleAdopt)
+	s.mux.HandleFunc("/sidecars/recommendations", s.handleSidecarRecommendations)
+	s.mux.HandleFunc("/features", s.handleFeatures)
+	// The operator's metrics, also served on the metrics address, for when only the admin API is reachable (e.g.
+	// through the operator's own sidecar)
+	s.mux.Handle("/metrics", promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{}))
+	s.mux.HandleFunc("/support-bundle", s.handleSupportBundle)
+	return s
+}
+
+// Start implements sigs.k8s.io/controller-runtime/pkg/manager.Runnable.
+func (s *Server) Start(ctx context.Context) error {
+	srv := &http.Server{Addr: s.addr, Handler: s, ReadHeaderTimeout: 10 * time.Second}
+	if s.certFile != "" {
+		tlsConfig, err := s.tlsConfig()
+		if err != nil {
+			return err
+		}
+		srv.TLSConfig = tlsConfig
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	logger.Info("Starting admin server", "Addr", s.addr, "TLS", s.certFile != "")
+	if !s.AuthConfigured() {
+		logger.Info("Changes through the admin API are disabled, since it requires no authentication (-adminToken or -adminClientCA)")
+	}
+	var err error
+	if s.certFile != "" {
+		err = srv.ListenAndServeTLS(s.certFile, s.keyFile)
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("admin server failed: %w", err)
+	}
+	return nil
+}
+
+func (s *Server) handleState(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		snap, err := s.sync.ExportState()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="gm-operator-state.json"`)
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(snap); err != nil {
+			logger.Error(err, "Failed to write state export")
+		}
+
+	case http.MethodPost:
+		var snap gitops.StateSnapshot
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<20)).Decode(&snap); err != nil {
+			http.Error(w, fmt.Sprintf("invalid state snapshot: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := s.sync.ImportState(snap); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleRebuild(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := s.sync.ResetState(); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if reapplier, ok := s.config.(Reapplier); ok {
+		if err := reapplier.Reapply(); err != nil {
+			http.Error(w, fmt.Sprintf("state was purged, but re-applying the mesh failed (it will be rebuilt on the next sync): %v", err),
+				http.StatusServiceUnavailable)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Server) handleSource(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(s.sync.Source()); err != nil {
+			logger.Error(err, "Failed to write GitOps source")
+		}
+
+	case http.MethodPost:
+		var ref struct {
+			Branch string `json:"branch"`
+			Tag    string `json:"tag"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&ref); err != nil {
+			http.Error(w, fmt.Sprintf("invalid ref: %v", err), http.StatusBadRequest)
+			return
+		}
+		src := s.sync.Source()
+		if src.Remote == "" {
+			http.Error(w, "no GitOps source is configured", http.StatusConflict)
+			return
+		}
+		src.Branch, src.Tag = ref.Branch, ref.Tag
+		if _, err := s.sync.Reconfigure(src); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleDeletions(w http.ResponseWriter, r *http.Request) {
+	if s.sync.SyncState == nil {
+		http.Error(w, "sync state has not been initialized", http.StatusServiceUnavailable)
+		return
+	}
+	var held gitops.HeldDeletions
+	switch r.Method {
+	case http.MethodGet:
+		held = s.sync.SyncState.HeldDeletions()
+
+	case http.MethodPost:
+		releaser, ok := s.config.(Releaser)
+		if !ok {
+			http.Error(w, "releasing deletions is not supported", http.StatusNotImplemented)
+			return
+		}
+		var err error
+		if held, err = releaser.ReleaseDeletions(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(held); err != nil {
+		logger.Error(err, "Failed to write held deletions")
+	}
+}
+
+func (s *Server) handleLogging(w http.ResponseWriter, r *http.Request) {
+	if s.verbosity == nil {
+		http.Error(w, "changing log levels is not supported", http.StatusNotImplemented)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+
+	case http.MethodPost:
+		var levels logging.Levels
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&levels); err != nil {
+			http.Error(w, fmt.Sprintf("invalid log levels: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := s.verbosity.Set(levels); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logger.Info("Changed log levels", "Levels", levels)
+
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(s.verbosity.Levels()); err != nil {
+		logger.Error(err, "Failed to write log levels")
+	}
+}
+
+func (s *Server) handleQueue(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	queue, ok := s.config.(CommandQueue)
+	if !ok {
+		http.Error(w, "inspecting the command queue is not supported", http.StatusNotImplemented)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(queue.Queue()); err != nil {
+		logger.Error(err, "Failed to write command queue")
+	}
+}
+
+func (s *Server) handleQueueFlush(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	queue, ok := s.config.(CommandQueue)
+	if !ok {
+		http.Error(w, "flushing the command queue is not supported", http.StatusNotImplemented)
+		return
+	}
+	api := r.URL.Query().Get("api")
+	if api != "" && api != "control" && api != "catalog" {
+		http.Error(w, fmt.Sprintf("api must be control or catalog, not %q", api), http.StatusBadRequest)
+		return
+	}
+	mesh := r.URL.Query().Get("mesh")
+	flushed := queue.FlushQueue(mesh, api)
+	logger.Info("Flushed command queue", "Mesh", mesh, "API", api, "Flushed", flushed)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]int{"flushed": flushed}); err != nil {
+		logger.Error(err, "Failed to write flushed command count")
+	}
+}
+
+func (s *Server) handleQueueCancel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	queue, ok := s.config.(CommandQueue)
+	if !ok {
+		http.Error(w, "cancelling commands is not supported", http.StatusNotImplemented)
+		return
+	}
+	id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "id must be the ID of a queued command", http.StatusBadRequest)
+		return
+	}
+	if !queue.CancelCommand(id) {
+		http.Error(w, fmt.Sprintf("no queued command %d", id), http.StatusNotFound)
+		return
+	}
+	logger.Info("Cancelled queued command", "ID", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleWorkloads(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	adopter, ok := s.config.(Adopter)
+	if !ok {
+		http.Error(w, "adopting workloads is not supported", http.StatusNotImplemented)
+		return
+	}
+	adopted := adopter.AdoptedWorkloads()
+	if adopted == nil {
+		adopted = []gitops.AdoptedWorkload{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(adopted); err != nil {
+		logger.Error(err, "Failed to write adopted workloads")
+	}
+}
+
+func (s *Server) handleSidecarRecommendations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rightsizer, ok := s.config.(Rightsizer)
+	if !ok {
+		http.Error(w, "sidecar recommendations are not supported", http.StatusNotImplemented)
+		return
+	}
+	recommendations := rightsizer.SidecarRecommendations()
+	if recommendations == nil {
+		recommendations = []v1alpha1.SidecarRecommendation{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(recommendations); err != nil {
+		logger.Error(err, "Failed to write sidecar recommendations")
+	}
+}
+
+// handleFeatures returns whether each of the mesh's feature flags is on.
+func (s *Server) handleFeatures(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	source, ok := s.config.(FeatureSource)
+	if !ok {
+		http.Error(w, "feature flags are not supported", http.StatusNotImplemented)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(source.Features()); err != nil {
+		logger.Error(err, "Failed to write feature flags")
+	}
+}
+
+// handleAdopt adopts a workload, or rolls back its adoption, as given by the request's path.
+func (s *Server) handleAdopt(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	adopter, ok := s.config.(Adopter)
+	if !ok {
+		http.Error(w, "adopting workloads is not supported", http.StatusNotImplemented)
+		return
+	}
+	query := r.URL.Query()
+	kind, namespace, name := query.Get("kind"), query.Get("namespace"), query.Get("name")
+	if kind == "" || namespace == "" || name == "" {
+		http.Error(w, "kind, namespace, and name are required", http.StatusBadRequest)
+		return
+	}
+	var err error
+	if r.URL.Path == "/workloads/rollback" {
+		err = adopter.RollbackAdoption(r.Context(), kind, namespace, name)
+	} else {
+		err = adopter.AdoptWorkload(r.Context(), kind, namespace, name, query.Get("port"))
+	}
+	if apierrors.IsNotFound(err) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	logger.Info("Requested workload adoption", "Path", r.URL.Path, "Kind", kind, "Namespace", namespace, "Name", name)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Gather everything before writing, so a failure can still be reported with a status code.
+	derivedObjects, derivedKinds, err := s.config.DerivedConfig()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to derive config from CUE: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+	// The config carries credentials, such as the edge's OIDC client secret, that aren't exported
+	sets := []configSet{{name: "derived", objects: cuemodule.RedactMeshConfigs(derivedObjects), kinds: derivedKinds}}
+	if r.URL.Query().Get("live") == "true" {
+		liveObjects, liveKinds, err := s.config.LiveConfig(r.Context())
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to list config from Control and Catalog: %v", err), http.StatusBadGateway)
+			return
+		}
+		sets = append(sets, configSet{name: "live", objects: cuemodule.RedactMeshConfigs(liveObjects), kinds: liveKinds})
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", `attachment; filename="gm-config.tar.gz"`)
+	if err := writeConfigArchive(w, time.Now(), sets...); err != nil {
+		logger.Error(err, "Failed to write config export")
+	}
+}
+
+func (s *Server) handleRender(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	renderer, ok := s.config.(Renderer)
+	if !ok {
+		http.Error(w, "rendering is not supported", http.StatusNotImplemented)
+		return
+	}
+	manifests, configs, kinds, err := renderer.Render()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to render: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+
+	// Anyone who can reach the admin API can read it, so Secrets' data and the config's credentials are left out
+	rendered := redactRendered(manifests, configs, kinds)
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(rendered); err != nil {
+		logger.Error(err, "Failed to write rendered objects")
+	}
+}
+
+func (s *Server) handleTopology(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	source, ok := s.config.(TopologySource)
+	if !ok {
+		http.Error(w, "topology is not supported", http.StatusNotImplemented)
+		return
+	}
+	live := r.URL.Query().Get("live") == "true"
+	topology, err := source.Topology(r.Context(), live)
+	if err != nil {
+		status := http.StatusServiceUnavailable
+		if live {
+			status = http.StatusBadGateway
+		}
+		http.Error(w, fmt.Sprintf("failed to build topology: %v", err), status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(topology); err != nil {
+		logger.Error(err, "Failed to write topology")
+	}
+}
+
+// Largest config tree accepted by POST /simulate.
+const maxSimulatedTreeBytes = 64 << 20
+
+func (s *Server) handleSimulate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	simulator, ok := s.config.(Simulator)
+	if !ok {
+		http.Error(w, "simulation is not supported", http.StatusNotImplemented)
+		return
+	}
+	dir, err := os.MkdirTemp("", "gm-simulate-")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(dir)
+	if err := s.sync.UnpackTree(http.MaxBytesReader(w, r.Body, maxSimulatedTreeBytes), dir); err != nil {
+		http.Error(w, fmt.Sprintf("failed to unpack config tree: %v", err), http.StatusBadRequest)
+		return
+	}
+	changes, err := simulator.Simulate(dir)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to simulate: %v", err), http.StatusUnprocessableEntity)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(changes); err != nil {
+		logger.Error(err, "Failed to write simulated changes")
+	}
+}
+
+// configSet is a named set of Grey Matter config objects and their kinds.
+type configSet struct {
+	name    string
+	objects []json.RawMessage
+	kinds   []string
+}
+
+// writeConfigArchive writes a gzipped tarball with a <set>/<kind>/<key>.json file for each object in each set.
+func writeConfigArchive(w io.Writer, modTime time.Time, sets ...configSet) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	for _, set := range sets {
+		for i, obj := range set.objects {
+			kind := set.kinds[i]
+			if kind == "" {
+				kind = "unknown"
+			}
+			key := gmapi.ObjectKey(kind, obj)
+			if key == "" {
+				key = fmt.Sprintf("object-%d", i)
+			}
+			var indented bytes.Buffer
+			if err := json.Indent(&indented, obj, "", "  "); err != nil {
+				return fmt.Errorf("invalid %s object %s: %w", kind, key, err)
+			}
+			hdr := &tar.Header{
+				Name:    path.Join(set.name, kind, strings.ReplaceAll(key, "/", "_")+".json"),
+				Mode:    0644,
+				Size:    int64(indented.Len()),
+				ModTime: modTime,
+			}
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			if _, err := tw.Write(indented.Bytes()); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
@@ -0,0 +1,98 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// WithToken lets requests that change the operator's state authenticate with the given bearer token, in an
+// "Authorization: Bearer <token>" header.
+func WithToken(token string) func(*Server) {
+	return func(s *Server) {
+		s.token = token
+	}
+}
+
+// WithTLS serves the admin API over TLS with the given certificate and key. If clientCAFile is set, requests that
+// change the operator's state can authenticate with a client certificate signed by one of its PEM-encoded CAs.
+func WithTLS(certFile, keyFile, clientCAFile string) func(*Server) {
+	return func(s *Server) {
+		s.certFile, s.keyFile, s.clientCAFile = certFile, keyFile, clientCAFile
+	}
+}
+
+// WithAuthenticatedReads, if enabled, requires the token or a verified client certificate for reads too, except of
+// the operator's metrics at GET /metrics. It's enabled when the operator registers itself into the mesh, since anyone
+// who can reach the edge can then reach the admin API.
+func WithAuthenticatedReads(enabled bool) func(*Server) {
+	return func(s *Server) {
+		s.authenticateReads = enabled
+	}
+}
+
+// AuthConfigured reports whether requests that change the operator's state can be authenticated, so they're served.
+func (s *Server) AuthConfigured() bool {
+	return s.token != "" || (s.certFile != "" && s.clientCAFile != "")
+}
+
+// tlsConfig returns the TLS config the admin API is served with, verifying the client certificates signed by the
+// client CAs, if any.
+func (s *Server) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if s.clientCAFile == "" {
+		return config, nil
+	}
+	pem, err := os.ReadFile(s.clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read admin API client CAs: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no PEM-encoded certificates in %s", s.clientCAFile)
+	}
+	config.ClientCAs = pool
+	// Reads don't need a client certificate, so one is only verified if given
+	config.ClientAuth = tls.VerifyClientCertIfGiven
+	return config, nil
+}
+
+// ServeHTTP serves the admin API's routes. Reads are served to anyone who can reach it (unless WithAuthenticatedReads
+// is enabled), but requests that change the operator's state must carry the token or a verified client certificate,
+// and are refused if neither is configured.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	read := r.Method == http.MethodGet || r.Method == http.MethodHead
+	if (!read || (s.authenticateReads && r.URL.Path != "/metrics")) && !s.authenticated(r) {
+		if !s.AuthConfigured() {
+			msg := "changes through the admin API are disabled until it requires authentication"
+			if read {
+				msg = "the admin API only serves metrics until it requires authentication"
+			}
+			http.Error(w, msg, http.StatusForbidden)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="gm-operator"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// authenticated reports whether a request carries the token or a client certificate signed by the client CAs.
+func (s *Server) authenticated(r *http.Request) bool {
+	if s.clientCAFile != "" && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return true
+	}
+	if s.token == "" {
+		return false
+	}
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(header, "Bearer ")), []byte(s.token)) == 1
+}
@@ -0,0 +1,254 @@
+package admin
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/greymatter-io/operator/pkg/bootstrap"
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	"github.com/greymatter-io/operator/pkg/logging"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// SupportSource provides the operator's config and the status of the mesh's core components for support bundles.
+// If the config source given to New is also a SupportSource, GET /support-bundle includes them.
+type SupportSource interface {
+	// EffectiveConfig returns the operator's config and defaults from CUE, with secrets redacted.
+	EffectiveConfig() (json.RawMessage, error)
+	// ComponentPods returns the pods in the mesh's install namespace.
+	ComponentPods(ctx context.Context) ([]corev1.Pod, error)
+}
+
+// WithRecentLogs includes the operator's latest logs in support bundles.
+func WithRecentLogs(recent *logging.Recent) func(*Server) {
+	return func(s *Server) {
+		s.recentLogs = recent
+	}
+}
+
+// WithStartupConfig includes the operator's startup configuration, with secrets redacted, in support bundles.
+func WithStartupConfig(config *bootstrap.Config) func(*Server) {
+	return func(s *Server) {
+		redactedConfig := config.Redacted()
+		s.startupConfig = &redactedConfig
+	}
+}
+
+// SupportBundle describes a support bundle, in its bundle.json: when it was generated, and why any of its files are
+// missing.
+type SupportBundle struct {
+	Generated time.Time `json:"generated"`
+	// Files in the bundle
+	Files []string `json:"files"`
+	// By the file that couldn't be gathered
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// PodStatus is the status of one of the mesh's core component pods in a support bundle.
+type PodStatus struct {
+	Namespace string            `json:"namespace"`
+	Name      string            `json:"name"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Status    corev1.PodStatus  `json:"status"`
+}
+
+// Longest a support bundle waits to list the core components' pods.
+const supportBundleTimeout = 30 * time.Second
+
+func (s *Server) handleSupportBundle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), supportBundleTimeout)
+	defer cancel()
+	now := time.Now()
+	files := s.gatherSupportBundle(ctx, now)
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="gm-support-bundle-%s.tar.gz"`, now.UTC().Format("20060102T150405Z")))
+	if err := writeArchive(w, now, files); err != nil {
+		logger.Error(err, "Failed to write support bundle")
+	}
+}
+
+// gatherSupportBundle gathers the files of a support bundle by name. A file that can't be gathered is left out, and
+// the reason recorded in bundle.json, so a bundle is still produced when the operator is partly broken.
+func (s *Server) gatherSupportBundle(ctx context.Context, now time.Time) map[string][]byte {
+	files := make(map[string][]byte)
+	bundle := SupportBundle{Generated: now.UTC(), Errors: make(map[string]string)}
+	add := func(name string, gather func() (interface{}, error)) {
+		v, err := gather()
+		if err != nil {
+			bundle.Errors[name] = err.Error()
+			return
+		}
+		var b []byte
+		switch v := v.(type) {
+		case []byte:
+			b = v
+		case json.RawMessage:
+			var indented bytes.Buffer
+			if err = json.Indent(&indented, v, "", "  "); err == nil {
+				b = append(indented.Bytes(), '\n')
+			}
+		default:
+			if b, err = json.MarshalIndent(v, "", "  "); err == nil {
+				b = append(b, '\n')
+			}
+		}
+		if err != nil {
+			bundle.Errors[name] = err.Error()
+			return
+		}
+		files[name] = b
+	}
+	unsupported := func(what string) (interface{}, error) {
+		return nil, fmt.Errorf("%s is not available from this operator", what)
+	}
+
+	add("logs/operator.log", func() (interface{}, error) {
+		if s.recentLogs == nil {
+			return unsupported("the operator's log")
+		}
+		var out bytes.Buffer
+		_, err := s.recentLogs.WriteTo(&out)
+		return out.Bytes(), err
+	})
+	add("config/startup.json", func() (interface{}, error) {
+		if s.startupConfig == nil {
+			return unsupported("the startup configuration")
+		}
+		return s.startupConfig, nil
+	})
+	support, _ := s.config.(SupportSource)
+	add("config/operator.json", func() (interface{}, error) {
+		if support == nil {
+			return unsupported("the operator's config")
+		}
+		return support.EffectiveConfig()
+	})
+	add("state/state.json", func() (interface{}, error) {
+		return s.sync.ExportState()
+	})
+	add("state/sync-report.json", func() (interface{}, error) {
+		report := s.sync.CurrentReport()
+		if report == nil {
+			return nil, fmt.Errorf("no sync cycle has started")
+		}
+		return report, nil
+	})
+	add("render/rendered.json", func() (interface{}, error) {
+		renderer, ok := s.config.(Renderer)
+		if !ok {
+			return unsupported("rendering")
+		}
+		manifests, configs, kinds, err := renderer.Render()
+		if err != nil {
+			return nil, err
+		}
+		return redactRendered(manifests, configs, kinds), nil
+	})
+	add("status/pods.json", func() (interface{}, error) {
+		if support == nil {
+			return unsupported("the component pods' status")
+		}
+		pods, err := support.ComponentPods(ctx)
+		if err != nil {
+			return nil, err
+		}
+		statuses := make([]PodStatus, len(pods))
+		for idx, pod := range pods {
+			statuses[idx] = PodStatus{Namespace: pod.Namespace, Name: pod.Name, Labels: pod.Labels, Status: pod.Status}
+		}
+		return statuses, nil
+	})
+
+	for name := range files {
+		bundle.Files = append(bundle.Files, name)
+	}
+	sort.Strings(bundle.Files)
+	add("bundle.json", func() (interface{}, error) { return bundle, nil })
+	return files
+}
+
+// redactRendered returns the rendered manifests and Grey Matter config with Secrets' data and the config's credentials
+// removed, so they can be shared.
+func redactRendered(manifests []client.Object, configs []json.RawMessage, kinds []string) RenderedObjects {
+	rendered := RenderedObjects{K8sManifests: redactSecrets(manifests), MeshConfigs: make([]RenderedConfig, len(configs))}
+	for idx, obj := range cuemodule.RedactMeshConfigs(configs) {
+		rendered.MeshConfigs[idx] = RenderedConfig{Kind: kinds[idx], Object: obj}
+	}
+	return rendered
+}
+
+// redactSecrets returns the manifests with the data of any Secrets removed.
+func redactSecrets(manifests []client.Object) []client.Object {
+	redacted := make([]client.Object, len(manifests))
+	for idx, manifest := range manifests {
+		switch obj := manifest.(type) {
+		case *corev1.Secret:
+			secret := obj.DeepCopy()
+			for key := range secret.Data {
+				secret.Data[key] = nil
+			}
+			for key := range secret.StringData {
+				secret.StringData[key] = ""
+			}
+			manifest = secret
+		case *unstructured.Unstructured:
+			if obj.GroupVersionKind().GroupKind() == (schema.GroupKind{Kind: "Secret"}) {
+				secret := obj.DeepCopy()
+				for _, field := range []string{"data", "stringData"} {
+					values, _, _ := unstructured.NestedMap(secret.Object, field)
+					for key := range values {
+						values[key] = ""
+					}
+					if values != nil {
+						_ = unstructured.SetNestedMap(secret.Object, values, field)
+					}
+				}
+				manifest = secret
+			}
+		}
+		redacted[idx] = manifest
+	}
+	return redacted
+}
+
+// writeArchive writes a gzipped tarball of the files, by name, in name order.
+func writeArchive(w io.Writer, modTime time.Time, files map[string][]byte) error {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, name := range names {
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(files[name])), ModTime: modTime}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(files[name]); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
@@ -0,0 +1,316 @@
+// Package bootstrap layers the operator's startup configuration. Each layer overrides the ones before it:
+//
+//  1. defaults: each flag's default, and the `config` in the CUE
+//  2. the bootstrap file given by -configPath (or GM_OPERATOR_CONFIG_PATH)
+//  3. environment variables, named GM_OPERATOR_ and the flag name in upper snake case
+//     (e.g. GM_OPERATOR_SSH_PRIVATE_KEY_PATH for -sshPrivateKeyPath)
+//  4. command-line flags
+//
+// The bootstrap file is YAML with flag names as keys, plus a `config` object whose keys override the CUE's
+// `config` (e.g. `cluster_ingress_name`). The CUE config can likewise be overridden with JSON in the
+// GM_OPERATOR_CONFIG environment variable and the -config flag, which are merged over the file's in that order.
+package bootstrap
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/yaml"
+)
+
+var logger = ctrl.Log.WithName("bootstrap")
+
+const (
+	// EnvPrefix prefixes the environment variable for each flag.
+	EnvPrefix = "GM_OPERATOR_"
+	// PathFlag is the name of the flag with the path to the bootstrap file.
+	PathFlag = "configPath"
+	// ConfigKey is the key of the CUE config overrides in the bootstrap file, and the name of the flag setting them.
+	ConfigKey = "config"
+
+	redacted = "<redacted>"
+)
+
+// Where a setting's effective value came from.
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+// Setting is the effective value of a flag and the layer it came from.
+type Setting struct {
+	Name   string
+	Value  string
+	Source string
+}
+
+// Config is the effective startup configuration.
+type Config struct {
+	Settings []Setting
+	// JSON overriding the CUE's config, or nil if there are no overrides
+	ConfigOverrides json.RawMessage
+
+	secrets map[string]bool
+}
+
+// EnvName returns the name of the environment variable for a flag, e.g. GM_OPERATOR_SSH_PRIVATE_KEY_PATH
+// for sshPrivateKeyPath.
+func EnvName(flagName string) string {
+	var b strings.Builder
+	b.WriteString(EnvPrefix)
+	runes := []rune(flagName)
+	for idx, r := range runes {
+		switch {
+		case r == '-' || r == '.':
+			b.WriteRune('_')
+			continue
+		case unicode.IsUpper(r) && idx > 0 && (unicode.IsLower(runes[idx-1]) || unicode.IsDigit(runes[idx-1])):
+			b.WriteRune('_')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+// overrideLayer is a layer's JSON overriding the CUE's config, and where it came from.
+type overrideLayer struct {
+	source string
+	raw    json.RawMessage
+}
+
+// Load applies the bootstrap file named by the PathFlag flag (if any) and then environment variables to the
+// flags in fs that weren't set on the command line, which must already have been parsed. The flags named in
+// secrets are redacted when the configuration is logged.
+func Load(fs *flag.FlagSet, secrets ...string) (*Config, error) {
+	fromFlags := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { fromFlags[f.Name] = true })
+
+	var path string
+	if f := fs.Lookup(PathFlag); f != nil {
+		path = f.Value.String()
+		if value, ok := os.LookupEnv(EnvName(PathFlag)); ok && !fromFlags[PathFlag] {
+			path = value
+		}
+	}
+	file, err := readFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &Config{secrets: make(map[string]bool)}
+	for _, name := range secrets {
+		config.secrets[name] = true
+	}
+
+	var errs Problems
+	for key := range file {
+		if key != ConfigKey && (key == PathFlag || fs.Lookup(key) == nil) {
+			errs.Addf("unknown key %q in bootstrap file %s", key, path)
+		}
+	}
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == ConfigKey {
+			return // merged below
+		}
+		source := SourceDefault
+		if fromFlags[f.Name] {
+			source = SourceFlag
+		} else if value, ok := os.LookupEnv(EnvName(f.Name)); ok {
+			if f.Name == PathFlag {
+				config.Settings = append(config.Settings, Setting{Name: f.Name, Value: path, Source: SourceEnv})
+				return
+			}
+			if err := fs.Set(f.Name, value); err != nil {
+				errs.Addf("invalid %s: %v", EnvName(f.Name), err)
+			}
+			source = SourceEnv
+		} else if raw, ok := file[f.Name]; ok {
+			value, err := flagValue(raw)
+			if err == nil {
+				err = fs.Set(f.Name, value)
+			}
+			if err != nil {
+				errs.Addf("invalid %q in bootstrap file %s: %v", f.Name, path, err)
+			}
+			source = SourceFile
+		}
+		config.Settings = append(config.Settings, Setting{Name: f.Name, Value: f.Value.String(), Source: source})
+	})
+
+	// CUE config overrides are merged rather than replaced, so each layer can set different keys
+	overrides := make(map[string]interface{})
+	layers := []overrideLayer{
+		{"bootstrap file " + path, file[ConfigKey]},
+		{EnvName(ConfigKey), json.RawMessage(os.Getenv(EnvName(ConfigKey)))},
+	}
+	if f := fs.Lookup(ConfigKey); f != nil {
+		layers = append(layers, overrideLayer{"-" + ConfigKey, json.RawMessage(f.Value.String())})
+	}
+	for _, layer := range layers {
+		if len(layer.raw) == 0 {
+			continue
+		}
+		var values map[string]interface{}
+		if err := json.Unmarshal(layer.raw, &values); err != nil {
+			errs.Addf("invalid config overrides in %s: %v", layer.source, err)
+			continue
+		}
+		merge(overrides, values)
+	}
+	if len(overrides) > 0 {
+		config.ConfigOverrides, _ = json.Marshal(overrides)
+	}
+
+	if err := errs.Err(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// readFile returns the top-level keys of the bootstrap file as JSON, or nothing if path is empty or the file is.
+func readFile(path string) (map[string]json.RawMessage, error) {
+	if path == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bootstrap file: %w", err)
+	}
+	file := make(map[string]json.RawMessage)
+	if err := yaml.Unmarshal(b, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse bootstrap file %s: %w", path, err)
+	}
+	return file, nil
+}
+
+// flagValue returns the flag value for a bootstrap file value: strings as-is, lists joined with commas,
+// and anything else as JSON.
+func flagValue(raw json.RawMessage) (string, error) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s, nil
+	}
+	var list []string
+	if err := json.Unmarshal(raw, &list); err == nil {
+		return strings.Join(list, ","), nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return "", err
+	}
+	switch v.(type) {
+	case map[string]interface{}, []interface{}:
+		return "", fmt.Errorf("expected a scalar or a list of strings")
+	}
+	return string(raw), nil
+}
+
+// merge recursively merges src into dst, replacing all but nested objects.
+func merge(dst, src map[string]interface{}) {
+	for k, v := range src {
+		srcObj, srcIsObj := v.(map[string]interface{})
+		dstObj, dstIsObj := dst[k].(map[string]interface{})
+		if srcIsObj && dstIsObj {
+			merge(dstObj, srcObj)
+			continue
+		}
+		dst[k] = v
+	}
+}
+
+// Log logs the effective value and source of each setting, with secrets redacted.
+func (c *Config) Log() {
+	redactedConfig := c.Redacted()
+	keysAndValues := make([]interface{}, 0, 2*len(redactedConfig.Settings)+2)
+	for _, s := range redactedConfig.Settings {
+		keysAndValues = append(keysAndValues, s.Name, fmt.Sprintf("%s (%s)", s.Value, s.Source))
+	}
+	if len(redactedConfig.ConfigOverrides) > 0 {
+		keysAndValues = append(keysAndValues, ConfigKey, string(redactedConfig.ConfigOverrides))
+	}
+	logger.Info("Effective operator configuration", keysAndValues...)
+}
+
+// Redacted returns a copy of the configuration with secrets redacted, and its settings sorted by name, e.g. to log or
+// export it.
+func (c *Config) Redacted() Config {
+	settings := make([]Setting, len(c.Settings))
+	for idx, s := range c.Settings {
+		s.Value = c.redact(s.Name, s.Value)
+		settings[idx] = s
+	}
+	sort.Slice(settings, func(i, j int) bool { return settings[i].Name < settings[j].Name })
+	redactedConfig := Config{Settings: settings}
+	if len(c.ConfigOverrides) > 0 {
+		redactedConfig.ConfigOverrides = redactJSON(c.ConfigOverrides)
+	}
+	return redactedConfig
+}
+
+// redact hides the value of secret settings, and any passwords in URLs (e.g. proxies or webhooks).
+func (c *Config) redact(name, value string) string {
+	if value == "" {
+		return value
+	}
+	if c.secrets[name] {
+		return redacted
+	}
+	parts := strings.Split(value, ",")
+	for idx, part := range parts {
+		parts[idx] = redactURL(part)
+	}
+	return strings.Join(parts, ",")
+}
+
+// redactURL hides the password in a URL, if it has one. Other values are returned as they are.
+func redactURL(value string) string {
+	prefix := ""
+	if eq := strings.Index(value, "="); eq >= 0 && eq < strings.Index(value, "://") {
+		prefix, value = value[:eq+1], value[eq+1:] // e.g. slack=https://...
+	}
+	u, err := url.Parse(value)
+	if err != nil || u.User == nil {
+		return prefix + value
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "xxxxx")
+		return prefix + u.String()
+	}
+	return prefix + value
+}
+
+// redactJSON hides the values of object keys that look like they hold secrets.
+func redactJSON(raw json.RawMessage) json.RawMessage {
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return raw
+	}
+	b, _ := json.Marshal(redactValue(v))
+	return b
+}
+
+func redactValue(v interface{}) interface{} {
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return v
+	}
+	for k, value := range obj {
+		lower := strings.ToLower(k)
+		if strings.Contains(lower, "password") || strings.Contains(lower, "secret_key") || strings.Contains(lower, "token") {
+			obj[k] = redacted
+		} else {
+			obj[k] = redactValue(value)
+		}
+	}
+	return obj
+}
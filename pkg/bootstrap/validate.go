@@ -0,0 +1,158 @@
+package bootstrap
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Problems collects what's wrong with the operator's configuration, so every problem is reported at startup
+// at once rather than one per restart, or not until the operator degrades at runtime.
+type Problems []string
+
+// Addf adds a problem.
+func (p *Problems) Addf(format string, args ...interface{}) {
+	*p = append(*p, fmt.Sprintf(format, args...))
+}
+
+// Namespace adds a problem if name, the value of field, isn't a valid namespace name.
+func (p *Problems) Namespace(field, name string) {
+	for _, msg := range validation.IsDNS1123Label(name) {
+		p.Addf("%s: %q is not a valid namespace name: %s", field, name, msg)
+	}
+}
+
+// URL adds a problem if value, the value of field, is set and isn't an absolute URL.
+func (p *Problems) URL(field, value string) {
+	if value == "" {
+		return
+	}
+	if u, err := url.Parse(value); err != nil || u.Scheme == "" || u.Host == "" {
+		p.Addf("%s: %q is not an absolute URL (e.g. http://proxy:3128)", field, redactURL(value))
+	}
+}
+
+// Err returns an error listing every problem, or nil if there are none.
+func (p Problems) Err() error {
+	if len(p) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid operator configuration:\n  %s", strings.Join(p, "\n  "))
+}
+
+// Flags are the startup flags checked by Validate.
+type Flags struct {
+	// Identity of the operator, labeling the objects it applies
+	OperatorID string
+	Repo       string
+	Branch     string
+	Tag        string
+	// Seconds between fetches of the config repo
+	Interval int
+	// Public keys that must have signed an OCI artifact, and how many of them
+	ArtifactKeys    string
+	ArtifactSigners int
+	// Environment whose values file is unified over the CUE
+	Environment string
+	// Proxies for git and the greymatter CLI
+	HTTPProxy  string
+	HTTPSProxy string
+	// The external-secrets store and the remote keys sourced from it
+	ExternalSecretStore     string
+	ExternalSecretStoreKind string
+	RemoteKeys              map[string]string
+	// Whether the operator deploys its own Redis for its state, and the size of its PersistentVolumeClaim
+	ManagedRedis        bool
+	ManagedRedisStorage string
+	// BoltDB file the operator keeps its state in instead of Redis
+	StateFile string
+	// What the operator does when preflight checks fail: strict or degrade
+	PreflightPolicy string
+	// TLS certificate, key, and client CAs of the admin API
+	AdminTLSCert  string
+	AdminTLSKey   string
+	AdminClientCA string
+	// Address of the xDS server, and its TLS certificate, key, and client CAs
+	XDSAddr     string
+	XDSTLSCert  string
+	XDSTLSKey   string
+	XDSClientCA string
+}
+
+// Validate checks the startup flags for values that are invalid or conflict with each other.
+func Validate(f Flags) Problems {
+	var p Problems
+	if f.Branch != "" && f.Tag != "" {
+		p.Addf("-branch and -tag are mutually exclusive; set one or the other (branch %q, tag %q)", f.Branch, f.Tag)
+	}
+	if strings.HasPrefix(f.Repo, "oci://") && f.Branch != "" {
+		p.Addf("-branch: OCI artifacts have no branches; set their tag or digest with -tag")
+	}
+	if f.Repo != "" && f.Interval <= 0 {
+		p.Addf("-interval: must be a positive number of seconds, not %d", f.Interval)
+	}
+	if f.OperatorID == "" {
+		p.Addf("-operatorID: must be set, since it labels the objects the operator applies and prunes")
+	}
+	for _, msg := range validation.IsValidLabelValue(f.OperatorID) {
+		p.Addf("-operatorID: %q is not a valid label value: %s", f.OperatorID, msg)
+	}
+	if f.ArtifactKeys != "" && f.ArtifactSigners < 1 {
+		p.Addf("-verifyArtifactSigners: must be at least 1, not %d", f.ArtifactSigners)
+	}
+	if f.Environment != "" {
+		for _, msg := range validation.IsDNS1123Label(f.Environment) {
+			p.Addf("-environment: %q is not a valid environment name: %s", f.Environment, msg)
+		}
+	}
+	p.URL("-httpProxy", f.HTTPProxy)
+	p.URL("-httpsProxy", f.HTTPSProxy)
+
+	switch f.ExternalSecretStoreKind {
+	case "SecretStore", "ClusterSecretStore":
+	default:
+		p.Addf("-externalSecretStoreKind: must be SecretStore or ClusterSecretStore, not %q", f.ExternalSecretStoreKind)
+	}
+	if f.ManagedRedis {
+		if _, err := resource.ParseQuantity(f.ManagedRedisStorage); err != nil {
+			p.Addf("-managedRedisStorage: %q is not a quantity of storage (e.g. 1Gi)", f.ManagedRedisStorage)
+		}
+		if f.RemoteKeys["redisPasswordRemoteKey"] != "" {
+			p.Addf("-managedRedis and -redisPasswordRemoteKey are mutually exclusive; the managed Redis's password is generated")
+		}
+		if f.StateFile != "" {
+			p.Addf("-managedRedis and -stateFile are mutually exclusive; state is kept in one or the other")
+		}
+	}
+	if (f.AdminTLSCert == "") != (f.AdminTLSKey == "") {
+		p.Addf("-adminTLSCert and -adminTLSKey must be set together")
+	}
+	if f.AdminClientCA != "" && f.AdminTLSCert == "" {
+		p.Addf("-adminClientCA: requires -adminTLSCert, since client certificates are only presented over TLS")
+	}
+	if f.XDSAddr != "" && (f.XDSTLSCert == "" || f.XDSTLSKey == "" || f.XDSClientCA == "") {
+		p.Addf("-xdsAddr: requires -xdsTLSCert, -xdsTLSKey, and -xdsClientCA, since sidecars must authenticate with a client certificate")
+	}
+	switch f.PreflightPolicy {
+	case "strict", "degrade":
+	default:
+		p.Addf("-preflightPolicy: must be strict or degrade, not %q", f.PreflightPolicy)
+	}
+	if f.ExternalSecretStore == "" {
+		names := make([]string, 0, len(f.RemoteKeys))
+		for name, key := range f.RemoteKeys {
+			if key != "" {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			p.Addf("-%s: requires -externalSecretStore", name)
+		}
+	}
+	return p
+}
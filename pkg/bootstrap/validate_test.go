@@ -0,0 +1,98 @@
+package bootstrap
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	valid := Flags{
+		OperatorID:              "gm-operator",
+		Repo:                    "git@github.com:greymatter-io/gitops-core.git",
+		Branch:                  "main",
+		Interval:                30,
+		Environment:             "prod",
+		HTTPSProxy:              "http://proxy:3128",
+		ExternalSecretStore:     "vault",
+		ExternalSecretStoreKind: "ClusterSecretStore",
+		RemoteKeys:              map[string]string{"gitCredentialsRemoteKey": "operator/git"},
+		PreflightPolicy:         "strict",
+	}
+	assert.Empty(t, Validate(valid))
+	assert.NoError(t, Validate(valid).Err())
+
+	problems := Validate(Flags{
+		OperatorID:              "gm-operator",
+		Repo:                    "git@github.com:greymatter-io/gitops-core.git",
+		Branch:                  "main",
+		Tag:                     "v1.0.0",
+		HTTPProxy:               "proxy:3128",
+		ExternalSecretStoreKind: "Vault",
+		PreflightPolicy:         "lenient",
+		AdminTLSKey:             "/certs/tls.key",
+		AdminClientCA:           "/certs/ca.crt",
+		XDSAddr:                 ":18000",
+		XDSTLSCert:              "/certs/tls.crt",
+		RemoteKeys: map[string]string{
+			"redisPasswordRemoteKey":   "operator/redis",
+			"imagePullSecretRemoteKey": "operator/docker",
+			"gitCredentialsRemoteKey":  "",
+		},
+	})
+	assert.Equal(t, Problems{
+		`-branch and -tag are mutually exclusive; set one or the other (branch "main", tag "v1.0.0")`,
+		"-interval: must be a positive number of seconds, not 0",
+		`-httpProxy: "proxy:3128" is not an absolute URL (e.g. http://proxy:3128)`,
+		`-externalSecretStoreKind: must be SecretStore or ClusterSecretStore, not "Vault"`,
+		"-adminTLSCert and -adminTLSKey must be set together",
+		"-adminClientCA: requires -adminTLSCert, since client certificates are only presented over TLS",
+		"-xdsAddr: requires -xdsTLSCert, -xdsTLSKey, and -xdsClientCA, since sidecars must authenticate with a client certificate",
+		`-preflightPolicy: must be strict or degrade, not "lenient"`,
+		"-imagePullSecretRemoteKey: requires -externalSecretStore",
+		"-redisPasswordRemoteKey: requires -externalSecretStore",
+	}, problems)
+
+	problems = Validate(Flags{OperatorID: "gm-operator", Repo: "oci://ghcr.io/greymatter-io/gitops-core", Branch: "main", Interval: 30,
+		ArtifactKeys: "cosign.pub", ExternalSecretStoreKind: "SecretStore", PreflightPolicy: "degrade"})
+	assert.Equal(t, Problems{
+		"-branch: OCI artifacts have no branches; set their tag or digest with -tag",
+		"-verifyArtifactSigners: must be at least 1, not 0",
+	}, problems)
+
+	problems = Validate(Flags{OperatorID: "gm-operator", ExternalSecretStoreKind: "SecretStore", ManagedRedis: true, ManagedRedisStorage: "lots", StateFile: "/state/gm-operator.db",
+		PreflightPolicy: "degrade", ExternalSecretStore: "vault", RemoteKeys: map[string]string{"redisPasswordRemoteKey": "operator/redis"}})
+	assert.Equal(t, Problems{
+		`-managedRedisStorage: "lots" is not a quantity of storage (e.g. 1Gi)`,
+		"-managedRedis and -redisPasswordRemoteKey are mutually exclusive; the managed Redis's password is generated",
+		"-managedRedis and -stateFile are mutually exclusive; state is kept in one or the other",
+	}, problems)
+
+	problems = Validate(Flags{OperatorID: "gm-operator", Environment: "../prod", ExternalSecretStoreKind: "SecretStore", PreflightPolicy: "degrade"})
+	if assert.Len(t, problems, 1) {
+		assert.Contains(t, problems[0], `-environment: "../prod" is not a valid environment name`)
+	}
+
+	problems = Validate(Flags{OperatorID: "gm operator", ExternalSecretStoreKind: "SecretStore", PreflightPolicy: "degrade"})
+	if assert.Len(t, problems, 1) {
+		assert.Contains(t, problems[0], `-operatorID: "gm operator" is not a valid label value`)
+	}
+	problems = Validate(Flags{ExternalSecretStoreKind: "SecretStore", PreflightPolicy: "degrade"})
+	assert.Equal(t, Problems{"-operatorID: must be set, since it labels the objects the operator applies and prunes"}, problems)
+
+	problems = Validate(Flags{OperatorID: "gm-operator", Environment: "../prod", ExternalSecretStoreKind: "SecretStore", PreflightPolicy: "degrade"})
+	err := problems.Err()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "invalid operator configuration:\n  -environment")
+	}
+}
+
+func TestProblemsNamespace(t *testing.T) {
+	var p Problems
+	p.Namespace("mesh.install_namespace", "greymatter")
+	assert.Empty(t, p)
+
+	p.Namespace("mesh.install_namespace", "Grey_Matter")
+	p.Namespace("mesh.watch_namespaces", "")
+	assert.Len(t, p, 2)
+}
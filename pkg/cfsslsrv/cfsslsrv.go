@@ -28,6 +28,9 @@ var (
 	logger = ctrl.Log.WithName("cfssl")
 )
 
+// WorkloadCertExpiry is how long certificates issued by RequestWorkloadCert are valid.
+const WorkloadCertExpiry = 24 * time.Hour
+
 // CFSSLServer exposes methods for launching an embedded CFSSL server,
 // retrieving its CA info, and requesting signed certs from it.
 type CFSSLServer struct {
@@ -129,6 +132,15 @@ func (cs *CFSSLServer) Start() error {
 								"client auth",
 							},
 						},
+						"workload": {
+							Expiry: WorkloadCertExpiry,
+							Usage: []string{
+								"signing",
+								"key encipherment",
+								"server auth",
+								"client auth",
+							},
+						},
 					},
 				},
 				OCSP: &ocspconfig.Config{},
@@ -206,15 +218,25 @@ func (cs *CFSSLServer) RequestIntermediateCA(req csr.CertificateRequest) ([]byte
 
 // RequestCert returns a new certificate signed by the CFSSL server.
 func (cs *CFSSLServer) RequestCert(req csr.CertificateRequest) ([]byte, []byte, error) {
+	return cs.requestCert(req, "server")
+}
+
+// RequestWorkloadCert returns a new short-lived certificate for a workload identity, signed by the CFSSL server.
+// It expires after WorkloadCertExpiry.
+func (cs *CFSSLServer) RequestWorkloadCert(req csr.CertificateRequest) ([]byte, []byte, error) {
+	return cs.requestCert(req, "workload")
+}
+
+func (cs *CFSSLServer) requestCert(req csr.CertificateRequest, profile string) ([]byte, []byte, error) {
 	reqBytes, err := json.Marshal(req)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	logger.Info("Requesting certificate", "CN", req.CN)
+	logger.Info("Requesting certificate", "CN", req.CN, "Profile", profile)
 
 	c := http.Client{Timeout: time.Second * 10}
-	resp, err := getCFSSLResponse(c, "newcert", fmt.Sprintf(`{"request":%s,"profile":"server"}`, string(reqBytes)))
+	resp, err := getCFSSLResponse(c, "newcert", fmt.Sprintf(`{"request":%s,"profile":"%s"}`, string(reqBytes), profile))
 	if err != nil {
 		return nil, nil, err
 	}
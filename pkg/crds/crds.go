@@ -0,0 +1,220 @@
+// Package crds installs and upgrades the operator's CustomResourceDefinitions from those embedded in it, so they
+// needn't be created separately before the operator starts.
+package crds
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/greymatter-io/operator/config"
+	"github.com/greymatter-io/operator/pkg/wellknown"
+
+	extv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/version"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	logger = ctrl.Log.WithName("crds")
+)
+
+// How often, and for how long, Apply checks whether the CRDs are established.
+var (
+	establishPollInterval = time.Second
+	establishTimeout      = 30 * time.Second
+)
+
+// Embedded returns the CustomResourceDefinitions embedded in the operator.
+func Embedded() ([]*extv1.CustomResourceDefinition, error) {
+	docs, err := config.CRDs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read embedded CustomResourceDefinitions: %w", err)
+	}
+	var crds []*extv1.CustomResourceDefinition
+	for _, doc := range docs {
+		crd := &extv1.CustomResourceDefinition{}
+		if err := yaml.Unmarshal(doc, crd); err != nil {
+			return nil, fmt.Errorf("failed to parse embedded CustomResourceDefinition: %w", err)
+		}
+		crds = append(crds, crd)
+	}
+	return crds, nil
+}
+
+// Names returns the names of the CustomResourceDefinitions.
+func Names(crds []*extv1.CustomResourceDefinition) []string {
+	names := make([]string, 0, len(crds))
+	for _, crd := range crds {
+		names = append(names, crd.Name)
+	}
+	return names
+}
+
+// Apply installs each of the CRDs that doesn't exist, and upgrades each that was installed from a different
+// definition, then waits for them to be established. An upgrade is refused if it would change the CRD's scope or drop
+// a version that objects are still stored in, and skipped if the installed CRD stores a newer version, e.g. because
+// it was installed by a newer operator. It returns an error listing every CRD that couldn't be applied.
+func Apply(ctx context.Context, c client.Client, crds []*extv1.CustomResourceDefinition) error {
+	var problems []string
+	for _, crd := range crds {
+		if err := apply(ctx, c, crd); err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		if err := waitEstablished(ctx, c, crd.Name); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("failed to apply CustomResourceDefinitions:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+func apply(ctx context.Context, c client.Client, crd *extv1.CustomResourceDefinition) error {
+	hash, err := schemaHash(crd)
+	if err != nil {
+		return fmt.Errorf("%s: %w", crd.Name, err)
+	}
+	desired := &extv1.CustomResourceDefinition{}
+	desired.Name = crd.Name
+	desired.Labels = crd.Labels
+	desired.Annotations = map[string]string{wellknown.ANNOTATION_CRD_SCHEMA_HASH: hash}
+	for k, v := range crd.Annotations {
+		desired.Annotations[k] = v
+	}
+	crd.Spec.DeepCopyInto(&desired.Spec)
+
+	existing := &extv1.CustomResourceDefinition{}
+	if err := c.Get(ctx, client.ObjectKey{Name: crd.Name}, existing); errors.IsNotFound(err) {
+		logger.Info("Installing CustomResourceDefinition", "Name", crd.Name)
+		if err := c.Create(ctx, desired); err != nil {
+			return fmt.Errorf("%s: failed to create: %w", crd.Name, err)
+		}
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("%s: failed to get: %w", crd.Name, err)
+	}
+	if existing.Annotations[wellknown.ANNOTATION_CRD_SCHEMA_HASH] == hash {
+		return nil
+	}
+
+	if newer, err := checkUpgrade(existing, desired); err != nil {
+		return fmt.Errorf("%s: refusing to upgrade: %w", crd.Name, err)
+	} else if newer {
+		logger.Info("Not upgrading CustomResourceDefinition, which stores a newer version than the operator's",
+			"Name", crd.Name, "Installed", storageVersion(existing), "Operator", storageVersion(desired))
+		return nil
+	}
+
+	// Keep what was added to the installed CRD, such as a conversion webhook patched in by kustomize
+	for k, v := range existing.Annotations {
+		if _, ok := desired.Annotations[k]; !ok {
+			desired.Annotations[k] = v
+		}
+	}
+	if len(existing.Labels) > 0 {
+		labels := make(map[string]string, len(existing.Labels)+len(desired.Labels))
+		for k, v := range existing.Labels {
+			labels[k] = v
+		}
+		for k, v := range desired.Labels {
+			labels[k] = v
+		}
+		desired.Labels = labels
+	}
+	if desired.Spec.Conversion == nil {
+		desired.Spec.Conversion = existing.Spec.Conversion
+	}
+	desired.ResourceVersion = existing.ResourceVersion
+	logger.Info("Upgrading CustomResourceDefinition", "Name", crd.Name, "Versions", servedVersions(desired))
+	if err := c.Update(ctx, desired); err != nil {
+		return fmt.Errorf("%s: failed to update: %w", crd.Name, err)
+	}
+	return nil
+}
+
+// checkUpgrade returns an error if the installed CRD can't safely be replaced by the desired one, or true if the
+// installed one stores a newer version and shouldn't be.
+func checkUpgrade(installed, desired *extv1.CustomResourceDefinition) (bool, error) {
+	if installed.Spec.Scope != desired.Spec.Scope {
+		return false, fmt.Errorf("its scope would change from %s to %s", installed.Spec.Scope, desired.Spec.Scope)
+	}
+	if version.CompareKubeAwareVersionStrings(storageVersion(installed), storageVersion(desired)) > 0 {
+		return true, nil
+	}
+	for _, stored := range installed.Status.StoredVersions {
+		found := false
+		for _, v := range desired.Spec.Versions {
+			found = found || v.Name == stored
+		}
+		if !found {
+			return false, fmt.Errorf("version %s would be dropped, but objects are still stored in it; migrate them and remove it from status.storedVersions first", stored)
+		}
+	}
+	return false, nil
+}
+
+// schemaHash returns a hash of the CRD's spec, identifying the definition it was installed from.
+func schemaHash(crd *extv1.CustomResourceDefinition) (string, error) {
+	b, err := json.Marshal(crd.Spec)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func storageVersion(crd *extv1.CustomResourceDefinition) string {
+	for _, v := range crd.Spec.Versions {
+		if v.Storage {
+			return v.Name
+		}
+	}
+	return ""
+}
+
+func servedVersions(crd *extv1.CustomResourceDefinition) []string {
+	var versions []string
+	for _, v := range crd.Spec.Versions {
+		if v.Served {
+			versions = append(versions, v.Name)
+		}
+	}
+	return versions
+}
+
+// waitEstablished waits until the named CRD is established, or returns why it isn't.
+func waitEstablished(ctx context.Context, c client.Client, name string) error {
+	ctx, cancel := context.WithTimeout(ctx, establishTimeout)
+	defer cancel()
+	ticker := time.NewTicker(establishPollInterval)
+	defer ticker.Stop()
+	for {
+		crd := &extv1.CustomResourceDefinition{}
+		if err := c.Get(ctx, client.ObjectKey{Name: name}, crd); err != nil {
+			return fmt.Errorf("%s: failed to get: %w", name, err)
+		}
+		for _, cond := range crd.Status.Conditions {
+			switch {
+			case cond.Type == extv1.Established && cond.Status == extv1.ConditionTrue:
+				return nil
+			case cond.Type == extv1.NamesAccepted && cond.Status == extv1.ConditionFalse:
+				return fmt.Errorf("%s: names not accepted: %s", name, cond.Message)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s: not established after %s", name, establishTimeout)
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package crds
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/greymatter-io/operator/pkg/wellknown"
+	"github.com/stretchr/testify/assert"
+	extv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// establishingClient establishes the CRDs it creates and updates, as the API server would.
+type establishingClient struct {
+	client.Client
+}
+
+func (c establishingClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	establish(obj)
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func (c establishingClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	establish(obj)
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func establish(obj client.Object) {
+	crd := obj.(*extv1.CustomResourceDefinition)
+	crd.Status.Conditions = []extv1.CustomResourceDefinitionCondition{{Type: extv1.Established, Status: extv1.ConditionTrue}}
+	crd.Status.StoredVersions = []string{storageVersion(crd)}
+}
+
+func newClient(t *testing.T, objs ...client.Object) client.Client {
+	scheme := runtime.NewScheme()
+	assert.NoError(t, extv1.AddToScheme(scheme))
+	return establishingClient{fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()}
+}
+
+func testCRD(versions ...string) *extv1.CustomResourceDefinition {
+	crd := &extv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: "widgets.greymatter.io"},
+		Spec: extv1.CustomResourceDefinitionSpec{
+			Group: "greymatter.io",
+			Names: extv1.CustomResourceDefinitionNames{Kind: "Widget", ListKind: "WidgetList", Plural: "widgets", Singular: "widget"},
+			Scope: extv1.ClusterScoped,
+		},
+	}
+	for idx, v := range versions {
+		crd.Spec.Versions = append(crd.Spec.Versions, extv1.CustomResourceDefinitionVersion{
+			Name:    v,
+			Served:  true,
+			Storage: idx == len(versions)-1,
+			Schema:  &extv1.CustomResourceValidation{OpenAPIV3Schema: &extv1.JSONSchemaProps{Type: "object"}},
+		})
+	}
+	return crd
+}
+
+func getCRD(t *testing.T, c client.Client) *extv1.CustomResourceDefinition {
+	crd := &extv1.CustomResourceDefinition{}
+	assert.NoError(t, c.Get(context.TODO(), client.ObjectKey{Name: "widgets.greymatter.io"}, crd))
+	return crd
+}
+
+func TestEmbedded(t *testing.T) {
+	crds, err := Embedded()
+	assert.NoError(t, err)
+	assert.Contains(t, Names(crds), "meshes.greymatter.io")
+	for _, crd := range crds {
+		assert.NotEmpty(t, storageVersion(crd), crd.Name)
+	}
+}
+
+func TestApply(t *testing.T) {
+	// Installed if missing
+	c := newClient(t)
+	assert.NoError(t, Apply(context.TODO(), c, []*extv1.CustomResourceDefinition{testCRD("v1alpha1")}))
+	installed := getCRD(t, c)
+	hash := installed.Annotations[wellknown.ANNOTATION_CRD_SCHEMA_HASH]
+	assert.NotEmpty(t, hash)
+
+	// Left alone if unchanged
+	assert.NoError(t, Apply(context.TODO(), c, []*extv1.CustomResourceDefinition{testCRD("v1alpha1")}))
+	assert.Equal(t, installed.ResourceVersion, getCRD(t, c).ResourceVersion)
+
+	// Upgraded if changed, keeping what was added to it
+	installed.Annotations["example.com/note"] = "kept"
+	installed.Spec.Conversion = &extv1.CustomResourceConversion{Strategy: extv1.WebhookConverter}
+	assert.NoError(t, c.Update(context.TODO(), installed))
+	assert.NoError(t, Apply(context.TODO(), c, []*extv1.CustomResourceDefinition{testCRD("v1alpha1", "v1beta1")}))
+	upgraded := getCRD(t, c)
+	assert.NotEqual(t, hash, upgraded.Annotations[wellknown.ANNOTATION_CRD_SCHEMA_HASH])
+	assert.Equal(t, "kept", upgraded.Annotations["example.com/note"])
+	assert.Equal(t, extv1.WebhookConverter, upgraded.Spec.Conversion.Strategy)
+	assert.Equal(t, []string{"v1alpha1", "v1beta1"}, servedVersions(upgraded))
+
+	// Not downgraded if it stores a newer version
+	assert.NoError(t, Apply(context.TODO(), c, []*extv1.CustomResourceDefinition{testCRD("v1alpha1")}))
+	assert.Equal(t, upgraded.ResourceVersion, getCRD(t, c).ResourceVersion)
+}
+
+func TestApplyRefused(t *testing.T) {
+	stored := testCRD("v1alpha1")
+	stored.Status.StoredVersions = []string{"v1alpha1"}
+	c := newClient(t, stored)
+	err := Apply(context.TODO(), c, []*extv1.CustomResourceDefinition{testCRD("v1beta1")})
+	assert.Contains(t, fmt.Sprint(err), "widgets.greymatter.io: refusing to upgrade: version v1alpha1 would be dropped")
+
+	namespaced := testCRD("v1beta1")
+	namespaced.Spec.Scope = extv1.NamespaceScoped
+	err = Apply(context.TODO(), c, []*extv1.CustomResourceDefinition{namespaced})
+	assert.Contains(t, fmt.Sprint(err), "its scope would change from Cluster to Namespaced")
+	assert.Equal(t, []string{"v1alpha1"}, servedVersions(getCRD(t, c)))
+}
+
+func TestApplyNotEstablished(t *testing.T) {
+	interval, timeout := establishPollInterval, establishTimeout
+	establishPollInterval, establishTimeout = 10*time.Millisecond, 50*time.Millisecond
+	defer func() { establishPollInterval, establishTimeout = interval, timeout }()
+
+	scheme := runtime.NewScheme()
+	assert.NoError(t, extv1.AddToScheme(scheme))
+	c := fake.NewClientBuilder().WithScheme(scheme).Build()
+	err := Apply(context.TODO(), c, []*extv1.CustomResourceDefinition{testCRD("v1alpha1")})
+	assert.Contains(t, fmt.Sprint(err), "widgets.greymatter.io: not established after 50ms")
+}
@@ -0,0 +1,269 @@
+// Package credentials sources the operator's own credentials (its image pull secret, the Redis password, and
+// the git SSH key or token for its config repo) from ExternalSecrets, so they needn't be created as literal
+// Secrets in the gm-operator namespace. The external-secrets operator materializes each one into a Secret,
+// which the operator waits on before use and watches for rotation.
+package credentials
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/greymatter-io/operator/pkg/gitops"
+	"github.com/greymatter-io/operator/pkg/k8sapi"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var logger = ctrl.Log.WithName("credentials")
+
+const (
+	// Namespace is where the operator's credentials live.
+	Namespace = "gm-operator"
+
+	// Names of the Secrets materialized from ExternalSecrets.
+	ImagePullSecretName      = "gm-docker-secret"
+	RedisPasswordSecretName  = "gm-redis-password"
+	GitCredentialsSecretName = "gm-git-credentials"
+
+	// Keys of the Redis password and git credentials in their Secrets.
+	// The git credentials are either an SSH private key (with an optional passphrase and known_hosts) or a token
+	// (with an optional username, e.g. for an OCI registry).
+	RedisPasswordKey    = "password"
+	RedisUsernameKey    = "username"
+	GitSSHPrivateKeyKey = corev1.SSHAuthPrivateKey
+	GitSSHPassphraseKey = "passphrase"
+	GitTokenKey         = "token"
+	GitUsernameKey      = "username"
+	GitKnownHostsKey    = "known_hosts"
+
+	// How often materialized Secrets are checked while waiting for them, and for changes afterwards.
+	pollInterval  = 5 * time.Second
+	watchInterval = 30 * time.Second
+)
+
+// Source configures which credentials are pulled from which keys of an external secret store.
+// Credentials without a remote key are left for the user to create as literal Secrets.
+type Source struct {
+	// Name and kind (SecretStore or ClusterSecretStore) of the store to pull from
+	SecretStore     string
+	SecretStoreKind string
+	// Remote key of a docker config JSON for pulling Grey Matter images
+	ImagePullSecretKey string
+	// Remote key of the Redis password
+	RedisPasswordKey string
+	// Remote key with an ssh-privatekey (and optional passphrase) or token property for the config repo
+	GitCredentialsKey string
+}
+
+// Enabled reports whether any credentials are pulled from an external secret store.
+func (s Source) Enabled() bool {
+	return s.SecretStore != "" && (s.ImagePullSecretKey != "" || s.RedisPasswordKey != "" || s.GitCredentialsKey != "")
+}
+
+// ExternalSecrets returns an ExternalSecret for each credential with a remote key.
+func (s Source) ExternalSecrets() []*unstructured.Unstructured {
+	var externalSecrets []*unstructured.Unstructured
+	if s.ImagePullSecretKey != "" {
+		externalSecrets = append(externalSecrets, s.mkExternalSecret(ImagePullSecretName, corev1.SecretTypeDockerConfigJson,
+			map[string]interface{}{
+				"data": []interface{}{map[string]interface{}{
+					"secretKey": corev1.DockerConfigJsonKey,
+					"remoteRef": map[string]interface{}{"key": s.ImagePullSecretKey},
+				}},
+			}))
+	}
+	if s.RedisPasswordKey != "" {
+		externalSecrets = append(externalSecrets, s.mkExternalSecret(RedisPasswordSecretName, corev1.SecretTypeOpaque,
+			map[string]interface{}{
+				"data": []interface{}{map[string]interface{}{
+					"secretKey": RedisPasswordKey,
+					"remoteRef": map[string]interface{}{"key": s.RedisPasswordKey},
+				}},
+			}))
+	}
+	if s.GitCredentialsKey != "" {
+		externalSecrets = append(externalSecrets, s.mkExternalSecret(GitCredentialsSecretName, corev1.SecretTypeOpaque,
+			map[string]interface{}{
+				"dataFrom": []interface{}{map[string]interface{}{
+					"extract": map[string]interface{}{"key": s.GitCredentialsKey},
+				}},
+			}))
+	}
+	return externalSecrets
+}
+
+func (s Source) mkExternalSecret(name string, secretType corev1.SecretType, spec map[string]interface{}) *unstructured.Unstructured {
+	storeKind := s.SecretStoreKind
+	if storeKind == "" {
+		storeKind = "SecretStore"
+	}
+	spec["refreshInterval"] = "1h"
+	spec["secretStoreRef"] = map[string]interface{}{"name": s.SecretStore, "kind": storeKind}
+	spec["target"] = map[string]interface{}{
+		"name":           name,
+		"creationPolicy": "Owner",
+		"template":       map[string]interface{}{"type": string(secretType)},
+	}
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "external-secrets.io/v1beta1",
+		"kind":       "ExternalSecret",
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": Namespace,
+		},
+		"spec": spec,
+	}}
+}
+
+// Watcher waits for credentials to be materialized from their ExternalSecrets and notifies listeners
+// when they change, e.g. after rotation in the external store.
+type Watcher struct {
+	client client.Client
+	source Source
+
+	// Last seen resourceVersion by Secret name
+	versions map[string]string
+	// Callbacks by Secret name
+	listeners map[string][]func(*corev1.Secret)
+}
+
+// NewWatcher returns a Watcher for the credentials configured in the given source.
+func NewWatcher(c client.Client, source Source) *Watcher {
+	return &Watcher{
+		client:    c,
+		source:    source,
+		versions:  make(map[string]string),
+		listeners: make(map[string][]func(*corev1.Secret)),
+	}
+}
+
+// Apply creates or updates the ExternalSecrets for the configured credentials.
+func (w *Watcher) Apply(ctx context.Context) error {
+	for _, externalSecret := range w.source.ExternalSecrets() {
+		if err := k8sapi.Apply(ctx, w.client, externalSecret, nil, k8sapi.CreateOrUpdate); err != nil {
+			return fmt.Errorf("failed to apply ExternalSecret %s/%s: %w", Namespace, externalSecret.GetName(), err)
+		}
+	}
+	return nil
+}
+
+// Wait blocks until the named Secret has been materialized with data, or the context is cancelled.
+func (w *Watcher) Wait(ctx context.Context, name string) (*corev1.Secret, error) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		secret := &corev1.Secret{}
+		err := w.client.Get(ctx, client.ObjectKey{Name: name, Namespace: Namespace}, secret)
+		if err == nil && len(secret.Data) > 0 {
+			w.versions[name] = secret.ResourceVersion
+			return secret, nil
+		}
+		if err != nil && !errors.IsNotFound(err) {
+			logger.Error(err, "Failed to get credentials - will retry", "Secret", name)
+		} else {
+			logger.Info("Waiting for credentials from ExternalSecret", "Secret", name, "Status", w.externalSecretStatus(ctx, name))
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("stopped waiting for credentials %s/%s: %w", Namespace, name, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// externalSecretStatus returns the message of the named ExternalSecret's Ready condition, for logging.
+func (w *Watcher) externalSecretStatus(ctx context.Context, name string) string {
+	externalSecret := &unstructured.Unstructured{}
+	externalSecret.SetAPIVersion("external-secrets.io/v1beta1")
+	externalSecret.SetKind("ExternalSecret")
+	if err := w.client.Get(ctx, client.ObjectKey{Name: name, Namespace: Namespace}, externalSecret); err != nil {
+		return err.Error()
+	}
+	conditions, _, _ := unstructured.NestedSlice(externalSecret.Object, "status", "conditions")
+	return readyMessage(conditions)
+}
+
+// readyMessage returns the message of the Ready condition among ExternalSecret status conditions.
+func readyMessage(conditions []interface{}) string {
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if !ok || condition["type"] != "Ready" {
+			continue
+		}
+		message, _ := condition["message"].(string)
+		return message
+	}
+	return "not yet reconciled"
+}
+
+// OnChange registers a callback invoked with the named Secret whenever it changes after it was first seen.
+// Callbacks must be registered before Watch is started.
+func (w *Watcher) OnChange(name string, callback func(*corev1.Secret)) {
+	w.listeners[name] = append(w.listeners[name], callback)
+}
+
+// Watch periodically checks the Secrets with registered callbacks for changes, until the context is cancelled.
+func (w *Watcher) Watch(ctx context.Context) {
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for name, callbacks := range w.listeners {
+			secret := &corev1.Secret{}
+			if err := w.client.Get(ctx, client.ObjectKey{Name: name, Namespace: Namespace}, secret); err != nil {
+				if !errors.IsNotFound(err) {
+					logger.Error(err, "Failed to check credentials for changes", "Secret", name)
+				}
+				continue
+			}
+			if !w.changed(secret) {
+				continue
+			}
+			logger.Info("Credentials changed", "Secret", name)
+			for _, callback := range callbacks {
+				callback(secret)
+			}
+		}
+	}
+}
+
+// changed records the Secret's resourceVersion and reports whether it differs from the last one seen.
+func (w *Watcher) changed(secret *corev1.Secret) bool {
+	last, seen := w.versions[secret.Name]
+	w.versions[secret.Name] = secret.ResourceVersion
+	return seen && last != secret.ResourceVersion
+}
+
+// GitCredentials returns the git credentials in a Secret materialized from their ExternalSecret.
+func GitCredentials(secret *corev1.Secret) gitops.GitCredentials {
+	return gitops.GitCredentials{
+		SSHPrivateKey: secret.Data[GitSSHPrivateKeyKey],
+		SSHPassphrase: string(secret.Data[GitSSHPassphraseKey]),
+		Token:         string(secret.Data[GitTokenKey]),
+		Username:      string(secret.Data[GitUsernameKey]),
+		KnownHosts:    secret.Data[GitKnownHostsKey],
+	}
+}
+
+// RedisCredentials returns the optional username and the password of a Redis, in the named Secret in the gm-operator
+// namespace.
+func RedisCredentials(ctx context.Context, c client.Client, name string) (username, password string, err error) {
+	secret := &corev1.Secret{}
+	if err := c.Get(ctx, client.ObjectKey{Name: name, Namespace: Namespace}, secret); err != nil {
+		return "", "", fmt.Errorf("failed to read Redis credentials from Secret %s/%s: %w", Namespace, name, err)
+	}
+	password = string(secret.Data[RedisPasswordKey])
+	if password == "" {
+		return "", "", fmt.Errorf("secret %s/%s must have a %s key", Namespace, name, RedisPasswordKey)
+	}
+	return string(secret.Data[RedisUsernameKey]), password, nil
+}
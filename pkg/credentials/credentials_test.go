@@ -0,0 +1,106 @@
+package credentials
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestExternalSecrets(t *testing.T) {
+	source := Source{
+		SecretStore:        "vault",
+		ImagePullSecretKey: "operator/docker",
+		GitCredentialsKey:  "operator/git",
+	}
+	assert.True(t, source.Enabled())
+	assert.False(t, Source{ImagePullSecretKey: "operator/docker"}.Enabled())
+	assert.False(t, Source{SecretStore: "vault"}.Enabled())
+
+	externalSecrets := source.ExternalSecrets()
+	if !assert.Len(t, externalSecrets, 2) {
+		return
+	}
+
+	docker := externalSecrets[0]
+	assert.Equal(t, ImagePullSecretName, docker.GetName())
+	assert.Equal(t, Namespace, docker.GetNamespace())
+	storeRef, _, _ := unstructured.NestedStringMap(docker.Object, "spec", "secretStoreRef")
+	assert.Equal(t, map[string]string{"name": "vault", "kind": "SecretStore"}, storeRef)
+	secretType, _, _ := unstructured.NestedString(docker.Object, "spec", "target", "template", "type")
+	assert.Equal(t, string(corev1.SecretTypeDockerConfigJson), secretType)
+	data, _, _ := unstructured.NestedSlice(docker.Object, "spec", "data")
+	assert.Equal(t, []interface{}{map[string]interface{}{
+		"secretKey": corev1.DockerConfigJsonKey,
+		"remoteRef": map[string]interface{}{"key": "operator/docker"},
+	}}, data)
+
+	git := externalSecrets[1]
+	assert.Equal(t, GitCredentialsSecretName, git.GetName())
+	dataFrom, _, _ := unstructured.NestedSlice(git.Object, "spec", "dataFrom")
+	assert.Equal(t, []interface{}{map[string]interface{}{
+		"extract": map[string]interface{}{"key": "operator/git"},
+	}}, dataFrom)
+}
+
+func TestReadyMessage(t *testing.T) {
+	assert.Equal(t, "not yet reconciled", readyMessage(nil))
+	assert.Equal(t, "could not get secret data from provider", readyMessage([]interface{}{
+		map[string]interface{}{"type": "Deleted", "message": "ignored"},
+		map[string]interface{}{"type": "Ready", "status": "False", "message": "could not get secret data from provider"},
+	}))
+}
+
+func TestWatcherChanged(t *testing.T) {
+	w := NewWatcher(nil, Source{})
+	mkSecret := func(version string) *corev1.Secret {
+		return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: RedisPasswordSecretName, ResourceVersion: version}}
+	}
+
+	assert.False(t, w.changed(mkSecret("1")), "first sighting is not a change")
+	assert.False(t, w.changed(mkSecret("1")))
+	assert.True(t, w.changed(mkSecret("2")))
+	assert.False(t, w.changed(mkSecret("2")))
+}
+
+func TestGitCredentials(t *testing.T) {
+	creds := GitCredentials(&corev1.Secret{Data: map[string][]byte{
+		GitSSHPrivateKeyKey: []byte("key"),
+		GitSSHPassphraseKey: []byte("passphrase"),
+	}})
+	assert.Equal(t, []byte("key"), creds.SSHPrivateKey)
+	assert.Equal(t, "passphrase", creds.SSHPassphrase)
+	assert.Empty(t, creds.Token)
+
+	creds = GitCredentials(&corev1.Secret{Data: map[string][]byte{GitTokenKey: []byte("token"), GitUsernameKey: []byte("robot")}})
+	assert.Equal(t, "token", creds.Token)
+	assert.Equal(t, "robot", creds.Username)
+	assert.False(t, creds.IsZero())
+}
+
+func TestRedisCredentials(t *testing.T) {
+	c := fake.NewClientBuilder().WithObjects(
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "state-redis", Namespace: Namespace}, Data: map[string][]byte{
+			RedisUsernameKey: []byte("gm-operator"),
+			RedisPasswordKey: []byte("hunter2"),
+		}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "no-password", Namespace: Namespace}, Data: map[string][]byte{
+			RedisUsernameKey: []byte("gm-operator"),
+		}},
+	).Build()
+
+	username, password, err := RedisCredentials(context.Background(), c, "state-redis")
+	assert.NoError(t, err)
+	assert.Equal(t, "gm-operator", username)
+	assert.Equal(t, "hunter2", password)
+
+	_, _, err = RedisCredentials(context.Background(), c, "no-password")
+	assert.Contains(t, fmt.Sprint(err), "must have a password key")
+	_, _, err = RedisCredentials(context.Background(), c, "missing")
+	assert.Contains(t, fmt.Sprint(err), "failed to read Redis credentials from Secret gm-operator/missing")
+}
@@ -0,0 +1,166 @@
+package cuemodule
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// GMConfigProblem is something about a Grey Matter config object that Control would reject, or that would leave the
+// mesh misrouted, found by AuditGMConfigObjects.
+type GMConfigProblem struct {
+	Kind string
+	// The object's zone_key
+	Zone string
+	// The object's key (service_id for catalogservices)
+	Key     string
+	Problem string
+}
+
+func (p GMConfigProblem) String() string {
+	return fmt.Sprintf("%s %s/%s: %s", p.Kind, p.Zone, p.Key, p.Problem)
+}
+
+// gmObject has the fields of Grey Matter config objects that identify them and refer to other objects.
+type gmObject struct {
+	ZoneKey      string   `json:"zone_key"`
+	ProxyKey     string   `json:"proxy_key"`
+	ListenerKey  string   `json:"listener_key"`
+	ClusterKey   string   `json:"cluster_key"`
+	RouteKey     string   `json:"route_key"`
+	DomainKey    string   `json:"domain_key"`
+	ServiceID    string   `json:"service_id"`
+	MeshID       string   `json:"mesh_id"`
+	Port         int      `json:"port"`
+	ListenerKeys []string `json:"listener_keys"`
+	DomainKeys   []string `json:"domain_keys"`
+	Rules        []struct {
+		Constraints struct {
+			Light []struct {
+				ClusterKey string `json:"cluster_key"`
+			} `json:"light"`
+			Dark []struct {
+				ClusterKey string `json:"cluster_key"`
+			} `json:"dark"`
+			Tap []struct {
+				ClusterKey string `json:"cluster_key"`
+			} `json:"tap"`
+		} `json:"constraints"`
+	} `json:"rules"`
+}
+
+// key returns the key identifying an object of the given kind.
+func (o gmObject) key(kind string) string {
+	switch kind {
+	case "proxy":
+		return o.ProxyKey
+	case "listener":
+		return o.ListenerKey
+	case "cluster":
+		return o.ClusterKey
+	case "route":
+		return o.RouteKey
+	case "domain":
+		return o.DomainKey
+	case "catalogservice":
+		return o.ServiceID
+	}
+	return ""
+}
+
+// AuditGMConfigObjects checks the Grey Matter config objects (with kinds as identified by IdentifyGMConfigObjects)
+// extracted for a sync, before any are applied, for differing objects of the same kind and key (of which only one
+// would survive), listeners of the same proxy on the same port, and references to domains, listeners, and clusters
+// that aren't among the objects. Zones aren't checked, since Control may already have them.
+func AuditGMConfigObjects(objects []json.RawMessage, kinds []string) []GMConfigProblem {
+	var problems []GMConfigProblem
+	parsed := make([]gmObject, len(objects))
+	seen := make(map[string]map[string]int)
+	for idx, kind := range kinds {
+		if kind == "" || kind == "zone" {
+			continue
+		}
+		if err := json.Unmarshal(objects[idx], &parsed[idx]); err != nil {
+			problems = append(problems, GMConfigProblem{Kind: kind, Problem: fmt.Sprintf("invalid object: %v", err)})
+			continue
+		}
+		obj := parsed[idx]
+		scope, key := obj.ZoneKey, obj.key(kind)
+		if kind == "catalogservice" {
+			scope = obj.MeshID
+		}
+		id := scope + "/" + key
+		if seen[kind] == nil {
+			seen[kind] = make(map[string]int)
+		}
+		if prev, ok := seen[kind][id]; ok {
+			if !jsonEqual(objects[prev], objects[idx]) {
+				problems = append(problems, GMConfigProblem{Kind: kind, Zone: scope, Key: key,
+					Problem: "defined more than once, differently; only one definition would be applied"})
+			}
+			continue
+		}
+		seen[kind][id] = idx
+	}
+
+	exists := func(kind, zone, key string) bool {
+		_, ok := seen[kind][zone+"/"+key]
+		return ok
+	}
+	for idx, kind := range kinds {
+		obj := parsed[idx]
+		problem := func(format string, args ...interface{}) {
+			problems = append(problems, GMConfigProblem{Kind: kind, Zone: obj.ZoneKey, Key: obj.key(kind), Problem: fmt.Sprintf(format, args...)})
+		}
+		refer := func(refKind, field string, keys ...string) {
+			for _, key := range keys {
+				if key != "" && !exists(refKind, obj.ZoneKey, key) {
+					problem("%s refers to %s %q, which doesn't exist", field, refKind, key)
+				}
+			}
+		}
+		switch kind {
+		case "proxy":
+			refer("domain", "domain_keys", obj.DomainKeys...)
+			refer("listener", "listener_keys", obj.ListenerKeys...)
+			ports := make(map[int]string)
+			for _, listenerKey := range obj.ListenerKeys {
+				listenerIdx, ok := seen["listener"][obj.ZoneKey+"/"+listenerKey]
+				if !ok {
+					continue
+				}
+				port := parsed[listenerIdx].Port
+				if other, ok := ports[port]; ok && other != listenerKey {
+					problem("listeners %q and %q both listen on port %d", other, listenerKey, port)
+					continue
+				}
+				ports[port] = listenerKey
+			}
+		case "listener":
+			refer("domain", "domain_keys", obj.DomainKeys...)
+		case "route":
+			refer("domain", "domain_key", obj.DomainKey)
+			for _, rule := range obj.Rules {
+				for _, c := range rule.Constraints.Light {
+					refer("cluster", "rules.constraints.light", c.ClusterKey)
+				}
+				for _, c := range rule.Constraints.Dark {
+					refer("cluster", "rules.constraints.dark", c.ClusterKey)
+				}
+				for _, c := range rule.Constraints.Tap {
+					refer("cluster", "rules.constraints.tap", c.ClusterKey)
+				}
+			}
+		}
+	}
+	return problems
+}
+
+// jsonEqual reports whether two JSON documents have the same content, regardless of formatting and key order.
+func jsonEqual(a, b json.RawMessage) bool {
+	var va, vb interface{}
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		return false
+	}
+	return reflect.DeepEqual(va, vb)
+}
@@ -0,0 +1,58 @@
+package cuemodule
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditGMConfigObjects(t *testing.T) {
+	objects := func(raw ...string) ([]json.RawMessage, []string) {
+		var configs []json.RawMessage
+		for _, r := range raw {
+			configs = append(configs, json.RawMessage(r))
+		}
+		return configs, IdentifyGMConfigObjects(configs)
+	}
+
+	valid, kinds := objects(
+		`{"zone_key":"default-zone","name":"default-zone"}`,
+		`{"domain_key":"edge","zone_key":"default-zone","port":10808}`,
+		`{"listener_key":"edge","zone_key":"default-zone","port":10808,"domain_keys":["edge"]}`,
+		`{"listener_key":"edge-egress","zone_key":"default-zone","port":10909,"domain_keys":["edge"]}`,
+		`{"proxy_key":"edge","zone_key":"default-zone","domain_keys":["edge"],"listener_keys":["edge","edge-egress"]}`,
+		`{"cluster_key":"catalog","zone_key":"default-zone","name":"catalog"}`,
+		`{"cluster_key":"edge-to-catalog","zone_key":"default-zone","name":"catalog"}`,
+		`{"route_key":"catalog","domain_key":"edge","zone_key":"default-zone","rules":[{"constraints":{"light":[{"cluster_key":"catalog","weight":1}]}}]}`,
+		// Identical definitions are harmless
+		`{"cluster_key": "catalog", "name": "catalog", "zone_key": "default-zone"}`,
+		`{"service_id":"catalog","mesh_id":"greymatter-mesh","name":"Catalog"}`,
+	)
+	assert.Empty(t, AuditGMConfigObjects(valid, kinds))
+
+	invalid, kinds := objects(
+		`{"domain_key":"edge","zone_key":"default-zone","port":10808}`,
+		`{"listener_key":"edge","zone_key":"default-zone","port":10808,"domain_keys":["edge"]}`,
+		`{"listener_key":"edge-egress","zone_key":"default-zone","port":10808,"domain_keys":["edge-egress"]}`,
+		`{"proxy_key":"edge","zone_key":"default-zone","domain_keys":["edge"],"listener_keys":["edge","edge-egress","edge-metrics"]}`,
+		`{"cluster_key":"catalog","zone_key":"default-zone","name":"catalog"}`,
+		`{"cluster_key":"catalog","zone_key":"default-zone","name":"dashboard"}`,
+		// Clusters are only found in their own zone
+		`{"route_key":"catalog","domain_key":"edge","zone_key":"default-zone","rules":[{"constraints":{"light":[{"cluster_key":"catalog"}],"dark":[{"cluster_key":"catalog-v2"}]}}]}`,
+		`{"route_key":"other","domain_key":"edge","zone_key":"other-zone","rules":[{"constraints":{"light":[{"cluster_key":"catalog"}]}}]}`,
+	)
+	var problems []string
+	for _, p := range AuditGMConfigObjects(invalid, kinds) {
+		problems = append(problems, p.String())
+	}
+	assert.Equal(t, []string{
+		"cluster default-zone/catalog: defined more than once, differently; only one definition would be applied",
+		`listener default-zone/edge-egress: domain_keys refers to domain "edge-egress", which doesn't exist`,
+		`proxy default-zone/edge: listener_keys refers to listener "edge-metrics", which doesn't exist`,
+		`proxy default-zone/edge: listeners "edge" and "edge-egress" both listen on port 10808`,
+		`route default-zone/catalog: rules.constraints.dark refers to cluster "catalog-v2", which doesn't exist`,
+		`route other-zone/other: domain_key refers to domain "edge", which doesn't exist`,
+		`route other-zone/other: rules.constraints.light refers to cluster "catalog", which doesn't exist`,
+	}, problems)
+}
@@ -0,0 +1,126 @@
+package cuemodule
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/greymatter-io/operator/pkg/wellknown"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// OptionalComponents maps each core component in v1alpha1.CoreComponent to the name its K8s manifests and Grey Matter
+// config objects have in the CUE. They're its objects if they have the name, are prefixed with it and a dash, or (for
+// K8s manifests) are labeled with it as their greymatter.io/cluster.
+var OptionalComponents = map[v1alpha1.CoreComponent]string{
+	"catalog":      ComponentWorkloads["catalog"],
+	"dashboard":    "dashboard",
+	"jwt_security": "jwt-security",
+	"redis":        ComponentWorkloads["redis"],
+	"prometheus":   "prometheus",
+}
+
+// SetDisabledComponents sets THE mesh's disabled core components, left out wherever the K8s manifests and Grey
+// Matter config are extracted. Unknown components are ignored, since they're validated by the Mesh CRD.
+func (operatorCUE *OperatorCUE) SetDisabledComponents(components []v1alpha1.CoreComponent) {
+	names := make(map[string]bool)
+	for _, component := range components {
+		if name, ok := OptionalComponents[component]; ok {
+			names[name] = true
+		}
+	}
+	operatorCUE.mesh.disabledComponents = names
+}
+
+// ComponentDisabled returns whether one of the mesh's core components is disabled.
+func ComponentDisabled(mesh *v1alpha1.Mesh, component v1alpha1.CoreComponent) bool {
+	if mesh == nil {
+		return false
+	}
+	for _, disabled := range mesh.Spec.DisabledComponents {
+		if disabled == component {
+			return true
+		}
+	}
+	return false
+}
+
+// componentObject returns whether an object's name makes it one of the named components' objects.
+func componentObject(disabled map[string]bool, name string) bool {
+	if disabled[name] {
+		return true
+	}
+	for component := range disabled {
+		if strings.HasPrefix(name, component+"-") {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutDisabledManifests leaves the disabled components' K8s manifests out of those extracted.
+func withoutDisabledManifests(disabled map[string]bool, manifestObjects []client.Object) []client.Object {
+	if len(disabled) == 0 {
+		return manifestObjects
+	}
+	var kept []client.Object
+	for _, obj := range manifestObjects {
+		if componentObject(disabled, obj.GetName()) || disabled[obj.GetLabels()[wellknown.LABEL_CLUSTER]] {
+			continue
+		}
+		kept = append(kept, obj)
+	}
+	return kept
+}
+
+// withoutDisabledConfigs leaves the disabled components' Grey Matter config objects out of those extracted (with kinds
+// as identified by IdentifyGMConfigObjects), along with routes that only lead to their clusters, and every
+// catalogservice if Catalog is disabled.
+func withoutDisabledConfigs(disabled map[string]bool, configs []json.RawMessage, kinds []string) ([]json.RawMessage, []string) {
+	if len(disabled) == 0 {
+		return configs, kinds
+	}
+	catalogDisabled := disabled[OptionalComponents["catalog"]]
+	dropped := func(obj gmObject, kind string) bool {
+		if kind == "catalogservice" && catalogDisabled {
+			return true
+		}
+		if componentObject(disabled, obj.key(kind)) {
+			return true
+		}
+		if kind != "route" {
+			return false
+		}
+		var clusters []string
+		for _, rule := range obj.Rules {
+			for _, c := range rule.Constraints.Light {
+				clusters = append(clusters, c.ClusterKey)
+			}
+			for _, c := range rule.Constraints.Dark {
+				clusters = append(clusters, c.ClusterKey)
+			}
+			for _, c := range rule.Constraints.Tap {
+				clusters = append(clusters, c.ClusterKey)
+			}
+		}
+		for _, key := range clusters {
+			if !componentObject(disabled, key) {
+				return false
+			}
+		}
+		return len(clusters) > 0
+	}
+
+	var keptConfigs []json.RawMessage
+	var keptKinds []string
+	for idx, config := range configs {
+		var obj gmObject
+		_ = json.Unmarshal(config, &obj)
+		if dropped(obj, kinds[idx]) {
+			continue
+		}
+		keptConfigs = append(keptConfigs, config)
+		keptKinds = append(keptKinds, kinds[idx])
+	}
+	return keptConfigs, keptKinds
+}
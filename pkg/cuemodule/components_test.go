@@ -0,0 +1,52 @@
+package cuemodule
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/greymatter-io/operator/pkg/wellknown"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestWithoutDisabledComponents(t *testing.T) {
+	operatorCUE := &OperatorCUE{}
+	operatorCUE.SetDisabledComponents([]v1alpha1.CoreComponent{"dashboard", "catalog", "unknown"})
+	disabled := operatorCUE.mesh.disabledComponents
+	assert.Equal(t, map[string]bool{"dashboard": true, "catalog": true}, disabled)
+
+	manifests := []client.Object{
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "controlensemble"}},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "dashboard"}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "dashboard-config"}},
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "ui", Labels: map[string]string{wellknown.LABEL_CLUSTER: "catalog"}}},
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "catalogue"}},
+	}
+	var names []string
+	for _, obj := range withoutDisabledManifests(disabled, manifests) {
+		names = append(names, obj.GetName())
+	}
+	assert.Equal(t, []string{"controlensemble", "catalogue"}, names)
+
+	configs := []json.RawMessage{
+		json.RawMessage(`{"cluster_key":"dashboard","zone_key":"default-zone","name":"dashboard"}`),
+		json.RawMessage(`{"listener_key":"dashboard-listener","zone_key":"default-zone","port":10808}`),
+		json.RawMessage(`{"cluster_key":"control","zone_key":"default-zone","name":"control"}`),
+		json.RawMessage(`{"route_key":"edge-to-dashboard","domain_key":"edge","zone_key":"default-zone","rules":[{"constraints":{"light":[{"cluster_key":"dashboard"}]}}]}`),
+		json.RawMessage(`{"route_key":"edge-split","domain_key":"edge","zone_key":"default-zone","rules":[{"constraints":{"light":[{"cluster_key":"dashboard"},{"cluster_key":"control"}]}}]}`),
+		json.RawMessage(`{"service_id":"control","mesh_id":"mesh","name":"Control"}`),
+	}
+	kept, kinds := withoutDisabledConfigs(disabled, configs, IdentifyGMConfigObjects(configs))
+	assert.Equal(t, []json.RawMessage{configs[2], configs[4]}, kept, "routes that also lead elsewhere are kept, and Catalog gets no services")
+	assert.Equal(t, []string{"cluster", "route"}, kinds)
+
+	// Nothing is left out unless a component is disabled
+	kept, _ = withoutDisabledConfigs(nil, configs, IdentifyGMConfigObjects(configs))
+	assert.Equal(t, configs, kept)
+	assert.True(t, ComponentDisabled(&v1alpha1.Mesh{Spec: v1alpha1.MeshSpec{DisabledComponents: []v1alpha1.CoreComponent{"redis"}}}, "redis"))
+	assert.False(t, ComponentDisabled(nil, "redis"))
+}
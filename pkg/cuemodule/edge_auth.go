@@ -0,0 +1,222 @@
+package cuemodule
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+)
+
+// Keys of the clusters the edge reaches the OIDC provider and the external authorization service through.
+const (
+	oidcProviderClusterKey = "edge-oidc-provider"
+	extAuthzClusterKey     = "edge-ext-authz"
+)
+
+// secretConfigFields are the fields of Grey Matter config objects that hold credentials, such as the OIDC client
+// secret in the edge's gm.oidc-authentication filter.
+var secretConfigFields = map[string]bool{"clientSecret": true, "client_secret": true}
+
+// EdgeAuth is the authentication and authorization of requests at the edge set in the mesh's spec.edge_auth,
+// with the OIDC client's credentials read from their Secret.
+type EdgeAuth struct {
+	v1alpha1.EdgeAuth
+	ClientID     string
+	ClientSecret string
+}
+
+// SetEdgeAuth sets THE mesh's authentication and authorization, added to the edge listener wherever the Grey Matter
+// config is extracted, or removes it if nil.
+func (operatorCUE *OperatorCUE) SetEdgeAuth(auth *EdgeAuth) {
+	operatorCUE.mesh.edgeAuth = auth
+}
+
+// addEdgeAuth adds the filters of the edge auth to the edge listener among the Grey Matter config objects, along
+// with clusters for the OIDC provider and external authorization service they call.
+func addEdgeAuth(auth *EdgeAuth, ingress IngressConfig, configs []json.RawMessage, kinds []string) ([]json.RawMessage, []string, error) {
+	if auth == nil || (auth.OIDC == nil && auth.ExtAuthz == nil) {
+		return configs, kinds, nil
+	}
+	listenerIdx, listenerKey, err := edgeListener(ingress, configs, kinds)
+	if err != nil {
+		return nil, nil, fmt.Errorf("mesh spec.edge_auth is set, but %w", err)
+	}
+	var listener map[string]interface{}
+	if err := json.Unmarshal(configs[listenerIdx], &listener); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse edge listener %q: %w", listenerKey, err)
+	}
+	zone, _ := listener["zone_key"].(string)
+	active, _ := listener["active_http_filters"].([]interface{})
+	filters, _ := listener["http_filters"].(map[string]interface{})
+	if filters == nil {
+		filters = make(map[string]interface{})
+	}
+	// Filters are configured under their name with underscores
+	activate := func(name string, config interface{}) {
+		filters[strings.ReplaceAll(name, ".", "_")] = config
+		for _, f := range active {
+			if f == name {
+				return
+			}
+		}
+		active = append(active, name)
+	}
+
+	configs = append([]json.RawMessage{}, configs...)
+	kinds = append([]string{}, kinds...)
+	addCluster := func(key, rawURL string) error {
+		cluster, err := mkURLCluster(key, zone, rawURL)
+		if err != nil {
+			return err
+		}
+		configs = append(configs, cluster)
+		kinds = append(kinds, "cluster")
+		return nil
+	}
+
+	if oidc := auth.OIDC; oidc != nil {
+		jwksURL := oidc.JWKSURL
+		if jwksURL == "" {
+			jwksURL = strings.TrimSuffix(oidc.IssuerURL, "/") + "/protocol/openid-connect/certs"
+		}
+		if err := addCluster(oidcProviderClusterKey, jwksURL); err != nil {
+			return nil, nil, fmt.Errorf("mesh spec.edge_auth.oidc: %w", err)
+		}
+		callbackPath := oidc.CallbackPath
+		if callbackPath == "" {
+			callbackPath = "/oauth"
+		}
+		audiences := oidc.Audiences
+		if len(audiences) == 0 {
+			audiences = []string{auth.ClientID}
+		}
+		cookie := func(key string) map[string]interface{} {
+			return map[string]interface{}{
+				"location":      "cookie",
+				"key":           key,
+				"cookieOptions": map[string]interface{}{"httpOnly": true, "secure": true, "path": "/"},
+			}
+		}
+		activate("gm.oidc-authentication", map[string]interface{}{
+			"provider":         oidc.IssuerURL,
+			"clientId":         auth.ClientID,
+			"clientSecret":     auth.ClientSecret,
+			"serviceUrl":       oidc.ServiceURL,
+			"callbackPath":     callbackPath,
+			"additionalScopes": oidc.Scopes,
+			"accessToken":      cookie("access_token"),
+			"idToken":          cookie("authz_token"),
+		})
+		activate("envoy.jwt_authn", map[string]interface{}{
+			"providers": map[string]interface{}{
+				"oidc": map[string]interface{}{
+					"issuer":       oidc.IssuerURL,
+					"audiences":    audiences,
+					"from_cookies": []string{"access_token"},
+					"forward":      true,
+					"remote_jwks": map[string]interface{}{
+						"http_uri":       map[string]interface{}{"uri": jwksURL, "cluster": oidcProviderClusterKey, "timeout": "1s"},
+						"cache_duration": "300s",
+					},
+				},
+			},
+			// The provider redirects back to the callback before there's a token
+			"rules": []interface{}{
+				map[string]interface{}{"match": map[string]interface{}{"prefix": callbackPath}},
+				map[string]interface{}{"match": map[string]interface{}{"prefix": "/"}, "requires": map[string]interface{}{"provider_name": "oidc"}},
+			},
+		})
+	}
+
+	if authz := auth.ExtAuthz; authz != nil {
+		if err := addCluster(extAuthzClusterKey, authz.URL); err != nil {
+			return nil, nil, fmt.Errorf("mesh spec.edge_auth.ext_authz: %w", err)
+		}
+		timeout := authz.TimeoutMillis
+		if timeout <= 0 {
+			timeout = 1000
+		}
+		activate("envoy.ext_authz", map[string]interface{}{
+			"http_service": map[string]interface{}{
+				"server_uri": map[string]interface{}{"uri": authz.URL, "cluster": extAuthzClusterKey, "timeout": fmt.Sprintf("%dms", timeout)},
+			},
+			"failure_mode_allow": authz.FailureModeAllow,
+		})
+	}
+
+	listener["active_http_filters"] = active
+	listener["http_filters"] = filters
+	patched, err := json.Marshal(listener)
+	if err != nil {
+		return nil, nil, err
+	}
+	configs[listenerIdx] = patched
+	return configs, kinds, nil
+}
+
+// mkURLCluster returns a cluster of the host of a URL, over TLS if it's https.
+func mkURLCluster(key, zone, rawURL string) (json.RawMessage, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid URL %q: expected http or https", rawURL)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	portNum, err := net.LookupPort("tcp", port)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL %q: %w", rawURL, err)
+	}
+	return json.Marshal(map[string]interface{}{
+		"cluster_key": key,
+		"zone_key":    zone,
+		"name":        key,
+		"instances":   []interface{}{map[string]interface{}{"host": u.Hostname(), "port": portNum}},
+		"require_tls": u.Scheme == "https",
+	})
+}
+
+// RedactMeshConfigs returns the Grey Matter config objects with the values of fields holding credentials replaced,
+// so they can be exported for debugging. Objects that can't be parsed are left out rather than risk exposing them.
+func RedactMeshConfigs(configs []json.RawMessage) []json.RawMessage {
+	redacted := make([]json.RawMessage, len(configs))
+	for idx, config := range configs {
+		var obj interface{}
+		if err := json.Unmarshal(config, &obj); err != nil {
+			redacted[idx] = json.RawMessage("null")
+			continue
+		}
+		if raw, err := json.Marshal(redactFields(obj)); err == nil {
+			redacted[idx] = raw
+		} else {
+			redacted[idx] = json.RawMessage("null")
+		}
+	}
+	return redacted
+}
+
+// redactFields replaces the values of the secretConfigFields anywhere in a parsed JSON value.
+func redactFields(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if secretConfigFields[key] {
+				v[key] = "<redacted>"
+			} else {
+				v[key] = redactFields(value)
+			}
+		}
+	case []interface{}:
+		for idx, value := range v {
+			v[idx] = redactFields(value)
+		}
+	}
+	return v
+}
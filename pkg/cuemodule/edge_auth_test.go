@@ -0,0 +1,88 @@
+package cuemodule
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestAddEdgeAuth(t *testing.T) {
+	configs := []json.RawMessage{
+		json.RawMessage(`{"listener_key":"edge","zone_key":"default-zone","active_http_filters":["gm.metrics"],"http_filters":{"gm_metrics":{"metrics_port":8081}}}`),
+		json.RawMessage(`{"cluster_key":"catalog","zone_key":"default-zone"}`),
+	}
+	kinds := IdentifyGMConfigObjects(configs)
+
+	same, sameKinds, err := addEdgeAuth(nil, IngressConfig{}, configs, kinds)
+	assert.NoError(t, err)
+	assert.Equal(t, configs, same)
+	assert.Equal(t, kinds, sameKinds)
+
+	auth := &EdgeAuth{
+		EdgeAuth: v1alpha1.EdgeAuth{
+			OIDC: &v1alpha1.OIDCAuth{
+				IssuerURL:  "https://keycloak.example.com/auth/realms/greymatter",
+				ServiceURL: "https://edge.example.com",
+				Scopes:     []string{"email"},
+			},
+			ExtAuthz: &v1alpha1.ExtAuthz{URL: "http://opa.authz.svc:8181", FailureModeAllow: true},
+		},
+		ClientID:     "edge",
+		ClientSecret: "s3cret",
+	}
+	authed, authedKinds, err := addEdgeAuth(auth, IngressConfig{}, configs, kinds)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"listener", "cluster", "cluster", "cluster"}, authedKinds)
+
+	listener := authed[0]
+	assert.Equal(t, `["gm.metrics","gm.oidc-authentication","envoy.jwt_authn","envoy.ext_authz"]`, gjson.GetBytes(listener, "active_http_filters").Raw)
+	assert.Equal(t, int64(8081), gjson.GetBytes(listener, "http_filters.gm_metrics.metrics_port").Int())
+	assert.Equal(t, "edge", gjson.GetBytes(listener, "http_filters.gm_oidc-authentication.clientId").String())
+	assert.Equal(t, "s3cret", gjson.GetBytes(listener, "http_filters.gm_oidc-authentication.clientSecret").String())
+	assert.Equal(t, "/oauth", gjson.GetBytes(listener, "http_filters.gm_oidc-authentication.callbackPath").String())
+	provider := gjson.GetBytes(listener, "http_filters.envoy_jwt_authn.providers.oidc")
+	assert.Equal(t, `["edge"]`, provider.Get("audiences").Raw)
+	assert.Equal(t, "https://keycloak.example.com/auth/realms/greymatter/protocol/openid-connect/certs", provider.Get("remote_jwks.http_uri.uri").String())
+	assert.Equal(t, "edge-ext-authz", gjson.GetBytes(listener, "http_filters.envoy_ext_authz.http_service.server_uri.cluster").String())
+	assert.True(t, gjson.GetBytes(listener, "http_filters.envoy_ext_authz.failure_mode_allow").Bool())
+
+	// The OIDC provider and authorization service are reached through clusters of their own
+	assert.Equal(t, "edge-oidc-provider", gjson.GetBytes(authed[2], "cluster_key").String())
+	assert.Equal(t, `[{"host":"keycloak.example.com","port":443}]`, gjson.GetBytes(authed[2], "instances").Raw)
+	assert.True(t, gjson.GetBytes(authed[2], "require_tls").Bool())
+	assert.Equal(t, `[{"host":"opa.authz.svc","port":8181}]`, gjson.GetBytes(authed[3], "instances").Raw)
+	assert.False(t, gjson.GetBytes(authed[3], "require_tls").Bool())
+
+	// Adding it again doesn't repeat filters
+	again, _, err := addEdgeAuth(auth, IngressConfig{}, authed[:2], authedKinds[:2])
+	assert.NoError(t, err)
+	assert.Equal(t, gjson.GetBytes(listener, "active_http_filters").Raw, gjson.GetBytes(again[0], "active_http_filters").Raw)
+
+	_, _, err = addEdgeAuth(auth, IngressConfig{EdgeListenerKey: "ingress"}, configs, kinds)
+	assert.Error(t, err)
+	auth.ExtAuthz.URL = "opa:8181"
+	_, _, err = addEdgeAuth(auth, IngressConfig{}, configs, kinds)
+	assert.Error(t, err)
+}
+
+func TestRedactMeshConfigs(t *testing.T) {
+	configs := []json.RawMessage{
+		json.RawMessage(`{"listener_key":"edge","http_filters":{"gm_oidc-authentication":{"clientId":"edge","clientSecret":"hunter2"}}}`),
+		json.RawMessage(`{"cluster_key":"edge","secret":{"secret_name":"spiffe://greymatter.io/mesh.edge"}}`),
+		json.RawMessage(`[{"client_secret":"hunter2"}]`),
+		json.RawMessage(`not json hunter2`),
+	}
+	redacted := RedactMeshConfigs(configs)
+	if assert.Len(t, redacted, 4) {
+		assert.Equal(t, "<redacted>", gjson.GetBytes(redacted[0], "http_filters.gm_oidc-authentication.clientSecret").String())
+		assert.Equal(t, "edge", gjson.GetBytes(redacted[0], "http_filters.gm_oidc-authentication.clientId").String())
+		assert.JSONEq(t, string(configs[1]), string(redacted[1]))
+		assert.JSONEq(t, `[{"client_secret":"<redacted>"}]`, string(redacted[2]))
+		assert.Equal(t, "null", string(redacted[3]))
+	}
+	// The original objects are untouched, since they're still applied
+	assert.Contains(t, string(configs[0]), "hunter2")
+}
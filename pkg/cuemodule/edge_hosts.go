@@ -0,0 +1,179 @@
+package cuemodule
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// Key of the edge listener whose domains route each catalogued service's host, if config.ingress.edge_listener_key
+// is unset.
+const defaultEdgeListenerKey = "edge"
+
+// Template of the Secret with each generated host's certificate, if certificates are requested without
+// config.ingress.host_tls_secret or tls_secret_name.
+const defaultEdgeHostTLSSecret = "edge-host-{service}-tls"
+
+// EdgeHost is a host at which the edge routes to a catalogued service, generated from config.ingress.host_template.
+type EdgeHost struct {
+	// The catalogued service's service_id, which is also the key of its cluster
+	Service string
+	Host    string
+	// Secret with the host's TLS certificate, if any
+	TLSSecret string
+}
+
+// SetClusterDomain sets the domain under which the cluster's ingress controller serves hosts, for {clusterDomain}
+// in config.ingress.host_template, used by EdgeHosts unless config.ingress.domain is set. It's derived from the
+// cluster on start.
+func (operatorCUE *OperatorCUE) SetClusterDomain(domain string) {
+	operatorCUE.mesh.clusterDomain = domain
+}
+
+// EdgeHosts returns a host for each service catalogued in the extracted Grey Matter config, generated from
+// config.ingress.host_template, or nil if it's unset.
+func (operatorCUE *OperatorCUE) EdgeHosts() ([]EdgeHost, error) {
+	ingress, mesh, err := operatorCUE.extractEdgeHostSettings()
+	if err != nil || ingress.HostTemplate == "" {
+		return nil, err
+	}
+	configs, kinds, err := operatorCUE.extractMeshConfigs()
+	if err != nil {
+		return nil, err
+	}
+	return edgeHosts(ingress, operatorCUE.mesh.clusterDomain, mesh, configs, kinds), nil
+}
+
+// extractEdgeHostSettings returns the ingress config (with any overrides) and the name of the mesh, which
+// config.ingress.host_template is rendered with.
+func (operatorCUE *OperatorCUE) extractEdgeHostSettings() (IngressConfig, string, error) {
+	var extracted struct {
+		Config Config `json:"config"`
+		Mesh   struct {
+			Metadata struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+		} `json:"mesh"`
+	}
+	if err := Extract(operatorCUE.K8s, &extracted); err != nil {
+		return IngressConfig{}, "", err
+	}
+	operatorCUE.overrideConfig(&extracted.Config)
+	return extracted.Config.Ingress, extracted.Mesh.Metadata.Name, nil
+}
+
+// edgeHosts renders config.ingress.host_template and config.ingress.host_tls_secret (or tls_secret_name, or if
+// certificates are requested, a Secret of the host's own) for each catalogued service.
+func edgeHosts(ingress IngressConfig, clusterDomain, mesh string, configs []json.RawMessage, kinds []string) []EdgeHost {
+	if ingress.HostTemplate == "" {
+		return nil
+	}
+	domain := ingress.Domain
+	if domain == "" {
+		domain = clusterDomain
+	}
+	if domain == "" && strings.Contains(ingress.HostTemplate, "{clusterDomain}") {
+		logger.Info("Not generating edge hosts because config.ingress.domain is unset and the cluster's domain is unknown")
+		return nil
+	}
+
+	secret := ingress.HostTLSSecret
+	if secret == "" {
+		secret = ingress.TLSSecretName
+	}
+	if secret == "" && ingress.Certificates.Issuer != "" {
+		secret = defaultEdgeHostTLSSecret
+	}
+
+	var hosts []EdgeHost
+	for idx, kind := range kinds {
+		if kind != "catalogservice" {
+			continue
+		}
+		service := gjson.GetBytes(configs[idx], "service_id").String()
+		render := strings.NewReplacer("{service}", service, "{mesh}", mesh, "{clusterDomain}", domain)
+		hosts = append(hosts, EdgeHost{
+			Service:   service,
+			Host:      render.Replace(ingress.HostTemplate),
+			TLSSecret: render.Replace(secret),
+		})
+	}
+	return hosts
+}
+
+// addEdgeRouting adds a domain for each edge host to the edge listener among the Grey Matter config objects, with
+// a route to the catalogued service's cluster, so the edge routes requests for the host to the service.
+func addEdgeRouting(ingress IngressConfig, hosts []EdgeHost, configs []json.RawMessage, kinds []string) ([]json.RawMessage, []string, error) {
+	if len(hosts) == 0 {
+		return configs, kinds, nil
+	}
+	listenerIdx, listenerKey, err := edgeListener(ingress, configs, kinds)
+	if err != nil {
+		return nil, nil, fmt.Errorf("config.ingress.host_template is set, but %w", err)
+	}
+
+	var listener map[string]interface{}
+	if err := json.Unmarshal(configs[listenerIdx], &listener); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse edge listener %q: %w", listenerKey, err)
+	}
+	zone, _ := listener["zone_key"].(string)
+	port := listener["port"]
+	domainKeys, _ := listener["domain_keys"].([]interface{})
+
+	configs = append([]json.RawMessage{}, configs...)
+	kinds = append([]string{}, kinds...)
+	for _, host := range hosts {
+		key := fmt.Sprintf("edge-host-%s", host.Service)
+		domain, err := json.Marshal(map[string]interface{}{
+			"domain_key": key,
+			"zone_key":   zone,
+			"name":       host.Host,
+			"port":       port,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		route, err := json.Marshal(map[string]interface{}{
+			"route_key":   key,
+			"domain_key":  key,
+			"zone_key":    zone,
+			"route_match": map[string]interface{}{"path": "/", "match_type": "prefix"},
+			"rules": []interface{}{map[string]interface{}{
+				"constraints": map[string]interface{}{
+					"light": []interface{}{map[string]interface{}{"cluster_key": host.Service, "weight": 1}},
+				},
+			}},
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		configs = append(configs, domain, route)
+		kinds = append(kinds, "domain", "route")
+		domainKeys = append(domainKeys, key)
+	}
+
+	listener["domain_keys"] = domainKeys
+	patched, err := json.Marshal(listener)
+	if err != nil {
+		return nil, nil, err
+	}
+	configs[listenerIdx] = patched
+	return configs, kinds, nil
+}
+
+// edgeListener returns the index and key of the edge listener (config.ingress.edge_listener_key) among the Grey
+// Matter config objects.
+func edgeListener(ingress IngressConfig, configs []json.RawMessage, kinds []string) (int, string, error) {
+	listenerKey := ingress.EdgeListenerKey
+	if listenerKey == "" {
+		listenerKey = defaultEdgeListenerKey
+	}
+	for idx, kind := range kinds {
+		if kind == "listener" && gjson.GetBytes(configs[idx], "listener_key").String() == listenerKey {
+			return idx, listenerKey, nil
+		}
+	}
+	return -1, listenerKey, fmt.Errorf("there's no edge listener %q", listenerKey)
+}
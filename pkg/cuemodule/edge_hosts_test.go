@@ -0,0 +1,52 @@
+package cuemodule
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestEdgeHosts(t *testing.T) {
+	configs := []json.RawMessage{
+		json.RawMessage(`{"listener_key":"edge","zone_key":"default-zone","port":10808,"domain_keys":["edge"]}`),
+		json.RawMessage(`{"service_id":"catalog","zone_key":"default-zone"}`),
+		json.RawMessage(`{"cluster_key":"catalog","zone_key":"default-zone"}`),
+		json.RawMessage(`{"service_id":"dashboard","zone_key":"default-zone"}`),
+	}
+	kinds := IdentifyGMConfigObjects(configs)
+
+	assert.Empty(t, edgeHosts(IngressConfig{}, "", "mesh-sample", configs, kinds))
+	// {clusterDomain} can't be rendered until the cluster's domain is known
+	ingress := IngressConfig{HostTemplate: "{service}.{mesh}.{clusterDomain}", HostTLSSecret: "{service}-tls"}
+	assert.Empty(t, edgeHosts(ingress, "", "mesh-sample", configs, kinds))
+
+	hosts := edgeHosts(ingress, "apps.example.com", "mesh-sample", configs, kinds)
+	assert.Equal(t, []EdgeHost{
+		{Service: "catalog", Host: "catalog.mesh-sample.apps.example.com", TLSSecret: "catalog-tls"},
+		{Service: "dashboard", Host: "dashboard.mesh-sample.apps.example.com", TLSSecret: "dashboard-tls"},
+	}, hosts)
+
+	// config.ingress.domain takes precedence, and tls_secret_name is the default secret
+	ingress = IngressConfig{HostTemplate: "{service}.{clusterDomain}", Domain: "mesh.example.com", TLSSecretName: "mesh-tls"}
+	assert.Equal(t, EdgeHost{Service: "catalog", Host: "catalog.mesh.example.com", TLSSecret: "mesh-tls"},
+		edgeHosts(ingress, "apps.example.com", "mesh-sample", configs, kinds)[0])
+
+	// Each host gets a domain on the edge listener routed to the service's cluster
+	routed, routedKinds, err := addEdgeRouting(ingress, hosts, configs, kinds)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"listener", "catalogservice", "cluster", "catalogservice", "domain", "route", "domain", "route"}, routedKinds)
+	assert.Equal(t, `["edge","edge-host-catalog","edge-host-dashboard"]`, gjson.GetBytes(routed[0], "domain_keys").Raw)
+	assert.Equal(t, "catalog.mesh-sample.apps.example.com", gjson.GetBytes(routed[4], "name").String())
+	assert.Equal(t, int64(10808), gjson.GetBytes(routed[4], "port").Int())
+	assert.Equal(t, "default-zone", gjson.GetBytes(routed[4], "zone_key").String())
+	assert.Equal(t, "edge-host-catalog", gjson.GetBytes(routed[5], "domain_key").String())
+	assert.Equal(t, "catalog", gjson.GetBytes(routed[5], "rules.0.constraints.light.0.cluster_key").String())
+	assert.Equal(t, IdentifyGMConfigObjects(routed), routedKinds)
+	// The extracted objects are left alone
+	assert.Equal(t, `["edge"]`, gjson.GetBytes(configs[0], "domain_keys").Raw)
+
+	_, _, err = addEdgeRouting(IngressConfig{EdgeListenerKey: "ingress"}, hosts, configs, kinds)
+	assert.Error(t, err)
+}
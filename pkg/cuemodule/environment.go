@@ -0,0 +1,81 @@
+package cuemodule
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	"cuelang.org/go/encoding/yaml"
+)
+
+// EnvironmentsDir is the directory of the CUE module with a values file for each environment
+// (e.g. environments/prod.yaml), so one repo can drive many clusters.
+const EnvironmentsDir = "environments"
+
+// environmentExtensions are the supported values file formats, in the order they're looked for.
+// JSON is read as YAML, which is a superset of it.
+var environmentExtensions = []string{".cue", ".yaml", ".yml", ".json"}
+
+// WithEnvironment has LoadAll unify the named environment's values file over the CUE, or none if name is empty.
+// The values file's top-level fields are the same as the CUE's (e.g. `config`, `defaults`, and `mesh`), so any
+// field the CUE leaves open to override, such as one with a default, can differ per environment.
+func WithEnvironment(name string) func(*OperatorCUE) {
+	return func(operatorCUE *OperatorCUE) {
+		operatorCUE.environment = name
+	}
+}
+
+// loadEnvironment returns the values of the named environment in the CUE module at root,
+// and the path of the file they came from.
+func loadEnvironment(root, name string) (cue.Value, string, error) {
+	for _, ext := range environmentExtensions {
+		path := filepath.Join(root, EnvironmentsDir, name+ext)
+		b, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return cue.Value{}, path, fmt.Errorf("failed to read values for environment %q: %w", name, err)
+		}
+
+		var value cue.Value
+		if ext == ".cue" {
+			value = cuecontext.New().CompileBytes(b, cue.Filename(path))
+		} else {
+			f, err := yaml.Extract(path, b)
+			if err != nil {
+				return cue.Value{}, path, fmt.Errorf("failed to parse values for environment %q: %w", name, err)
+			}
+			value = cuecontext.New().BuildFile(f)
+		}
+		if err := value.Err(); err != nil {
+			return cue.Value{}, path, fmt.Errorf("invalid values for environment %q in %s: %w", name, path, err)
+		}
+		return value, path, nil
+	}
+	return cue.Value{}, "", fmt.Errorf("no values file for environment %q in %s (expected %s.cue, .yaml, .yml, or .json)",
+		name, filepath.Join(root, EnvironmentsDir), name)
+}
+
+// unifyEnvironment unifies the selected environment's values, if any, over the loaded CUE.
+func (operatorCUE *OperatorCUE) unifyEnvironment(root string) error {
+	environment := operatorCUE.environment
+	if environment == "" {
+		return nil
+	}
+	values, path, err := loadEnvironment(root, environment)
+	if err != nil {
+		return err
+	}
+	operatorCUE.K8s = operatorCUE.K8s.Unify(values)
+	operatorCUE.GM = operatorCUE.GM.Unify(values)
+	for _, v := range []cue.Value{operatorCUE.K8s, operatorCUE.GM} {
+		if err := v.Err(); err != nil {
+			return fmt.Errorf("values for environment %q in %s conflict with the CUE: %w", environment, path, err)
+		}
+	}
+	logger.Info("Unified environment values over the CUE", "Environment", environment, "Path", path)
+	return nil
+}
@@ -0,0 +1,58 @@
+package cuemodule
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadAllWithEnvironment(t *testing.T) {
+	root := t.TempDir()
+	for path, contents := range map[string]string{
+		"cue.mod/module.cue":        `module: "greymatter.io/operator/test"`,
+		"k8s/outputs/outputs.cue":   "package outputs\n\nconfig: spire: *false | bool\nmesh: spec: install_namespace: *\"greymatter\" | string\n",
+		"gm/outputs/outputs.cue":    "package outputs\n\nmesh_configs: []\n",
+		"environments/prod.yaml":    "config:\n  spire: true\nmesh:\n  spec:\n    install_namespace: greymatter-prod\n",
+		"environments/stage.cue":    "config: spire: true\n",
+		"environments/conflict.cue": "config: spire: \"yes\"\n",
+	} {
+		path = filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	operatorCUE, mesh, err := LoadAll(root)
+	if assert.NoError(t, err) {
+		config, _ := operatorCUE.ExtractConfig()
+		assert.False(t, config.Spire)
+		assert.Equal(t, "greymatter", mesh.Spec.InstallNamespace)
+	}
+
+	operatorCUE, mesh, err = LoadAll(root, WithEnvironment("prod"))
+	if assert.NoError(t, err) {
+		config, _ := operatorCUE.ExtractConfig()
+		assert.True(t, config.Spire)
+		assert.Equal(t, "greymatter-prod", mesh.Spec.InstallNamespace)
+	}
+
+	operatorCUE, _, err = LoadAll(root, WithEnvironment("stage"))
+	if assert.NoError(t, err) {
+		config, _ := operatorCUE.ExtractConfig()
+		assert.True(t, config.Spire)
+	}
+
+	_, _, err = LoadAll(root, WithEnvironment("conflict"))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `values for environment "conflict"`)
+	}
+
+	_, _, err = LoadAll(root, WithEnvironment("dev"))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `no values file for environment "dev"`)
+	}
+}
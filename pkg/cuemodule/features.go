@@ -0,0 +1,72 @@
+package cuemodule
+
+import "sort"
+
+// Names of the feature flags in the config's features, each gating an experimental behavior.
+const (
+	// Sidecars are injected into the pods admitted by the workload webhook. On unless switched off.
+	FeatureWebhookInjection = "webhook_injection"
+	// With -xdsAddr set, the config for Control is served to sidecars over xDS instead of sent to Control. On unless
+	// switched off, in which case it's sent to Control as if -xdsAddr weren't set.
+	FeatureXDS = "xds"
+	// The mesh is reapplied periodically, and every Kubernetes manifest applied in a sync cycle, so live objects
+	// changed out of band are restored. Off unless switched on.
+	FeatureDriftEnforcement = "drift_enforcement"
+)
+
+// featureDefaults are whether each known feature is on when the config doesn't set it.
+var featureDefaults = map[string]bool{
+	FeatureWebhookInjection: true,
+	FeatureXDS:              true,
+	FeatureDriftEnforcement: false,
+}
+
+// Features are the feature flags set in a mesh's config, switching experimental behaviors on or off by name (see
+// FeatureWebhookInjection, FeatureXDS, and FeatureDriftEnforcement). Unlike the rest of the config, they're read from
+// the CUE of each sync cycle, so they can be switched in a GitOps commit without restarting the operator.
+type Features map[string]bool
+
+// Enabled reports whether the named feature is on: as set, or else by default.
+func (f Features) Enabled(name string) bool {
+	if on, ok := f[name]; ok {
+		return on
+	}
+	return featureDefaults[name]
+}
+
+// Resolved returns whether each known feature is on, along with any other features set.
+func (f Features) Resolved() map[string]bool {
+	resolved := make(map[string]bool, len(featureDefaults)+len(f))
+	for name := range featureDefaults {
+		resolved[name] = f.Enabled(name)
+	}
+	for name, on := range f {
+		resolved[name] = on
+	}
+	return resolved
+}
+
+// KnownFeatures returns the names of the feature flags the operator has, sorted.
+func KnownFeatures() []string {
+	var names []string
+	for name := range featureDefaults {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ExtractFeatures pulls the feature flags from the CUE's config, overridden like the rest of it (see
+// WithConfigOverrides). Unlike ExtractConfig, it returns an error rather than panicking if they can't be extracted.
+func (operatorCUE *OperatorCUE) ExtractFeatures() (Features, error) {
+	var extracted struct {
+		Config struct {
+			Features Features `json:"features"`
+		} `json:"config"`
+	}
+	if err := Extract(operatorCUE.K8s, &extracted); err != nil {
+		return nil, err
+	}
+	operatorCUE.overrideConfig(&extracted.Config)
+	return extracted.Config.Features, nil
+}
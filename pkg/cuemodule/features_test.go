@@ -0,0 +1,60 @@
+package cuemodule
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFeatures(t *testing.T) {
+	var none Features
+	assert.True(t, none.Enabled(FeatureWebhookInjection))
+	assert.True(t, none.Enabled(FeatureXDS))
+	assert.False(t, none.Enabled(FeatureDriftEnforcement))
+	assert.False(t, none.Enabled("unknown"))
+
+	features := Features{FeatureXDS: false, FeatureDriftEnforcement: true, "future": true}
+	assert.False(t, features.Enabled(FeatureXDS))
+	assert.True(t, features.Enabled(FeatureDriftEnforcement))
+	assert.True(t, features.Enabled("future"))
+	assert.Equal(t, map[string]bool{FeatureWebhookInjection: true, FeatureXDS: false, FeatureDriftEnforcement: true, "future": true}, features.Resolved())
+	assert.Equal(t, []string{FeatureDriftEnforcement, FeatureWebhookInjection, FeatureXDS}, KnownFeatures())
+}
+
+func TestExtractFeatures(t *testing.T) {
+	root := t.TempDir()
+	for path, contents := range map[string]string{
+		"cue.mod/module.cue":      `module: "greymatter.io/operator/test"`,
+		"k8s/outputs/outputs.cue": "package outputs\n\nconfig: features: {xds: false, drift_enforcement: true}\n",
+		"gm/outputs/outputs.cue":  "package outputs\n\nmesh_configs: []\n",
+	} {
+		path = filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	operatorCUE, _, err := LoadAll(root)
+	if !assert.NoError(t, err) {
+		return
+	}
+	features, err := operatorCUE.ExtractFeatures()
+	assert.NoError(t, err)
+	assert.Equal(t, Features{FeatureXDS: false, FeatureDriftEnforcement: true}, features)
+	config, _ := operatorCUE.ExtractConfig()
+	assert.Equal(t, features, config.Features)
+
+	// Overridden like the rest of the config
+	operatorCUE, _, err = LoadAll(root, WithConfigOverrides([]byte(`{"features":{"drift_enforcement":false}}`)))
+	if !assert.NoError(t, err) {
+		return
+	}
+	features, err = operatorCUE.ExtractFeatures()
+	assert.NoError(t, err)
+	assert.False(t, features.Enabled(FeatureDriftEnforcement))
+	assert.False(t, features.Enabled(FeatureXDS))
+}
@@ -0,0 +1,178 @@
+// The top-level fields of each kind of Grey Matter config object accepted by Control, for each release_version of
+// the Mesh. Each version's definitions are closed, so fields Control doesn't know are caught before they're applied;
+// only latest is left open, since the newest Control may accept fields this operator doesn't know yet.
+
+#Zone16: {
+	zone_key:  string
+	name?:     string
+	checksum?: string
+}
+
+#Domain16: {
+	domain_key:     string
+	zone_key:       string
+	name:           string
+	port?:          int & >0 & <65536
+	ssl_config?:    {...}
+	redirects?:     [...{...}]
+	gzip_enabled?:  bool
+	cors_config?:   {...}
+	aliases?:       [...string]
+	force_https?:   bool
+	custom_headers?: [...{...}]
+	checksum?:      string
+}
+
+#Listener16: {
+	listener_key:            string
+	zone_key:                string
+	name?:                   string
+	ip?:                     string
+	port:                    int & >0 & <65536
+	protocol?:               string
+	domain_keys?:            [...string]
+	active_http_filters?:    [...string]
+	http_filters?:           {...}
+	active_network_filters?: [...string]
+	network_filters?:        {...}
+	stream_idle_timeout?:    string
+	request_timeout?:        string
+	drain_timeout?:          string
+	delayed_close_timeout?:  string
+	use_remote_address?:     bool
+	http_protocol_options?:  {...}
+	http2_protocol_options?: {...}
+	secret?:                 {...}
+	tracing_config?:         {...}
+	checksum?:               string
+}
+
+#Proxy16: {
+	proxy_key:             string
+	zone_key:              string
+	name:                  string
+	domain_keys?:          [...string]
+	listener_keys?:        [...string]
+	listeners?:            [...{...}]
+	active_proxy_filters?: [...string]
+	proxy_filters?:        {...}
+	upgrades?:             string
+	checksum?:             string
+}
+
+#Cluster16: {
+	cluster_key:        string
+	zone_key:           string
+	name:               string
+	instances?:         [...{host: string, port: int, metadata?: [...{...}]}]
+	require_tls?:       bool
+	secret?:            {...}
+	ssl_config?:        {...}
+	circuit_breakers?:  {...}
+	outlier_detection?: {...}
+	health_checks?:     [...{...}]
+	protocol?:          string
+	checksum?:          string
+}
+
+#Route16: {
+	route_key:                   string
+	domain_key:                  string
+	zone_key:                    string
+	route_match?:                {...}
+	path?:                       string
+	prefix_rewrite?:             string
+	redirects?:                  [...{...}]
+	shared_rules_key?:           string
+	rules?:                      [...{...}]
+	response_data?:              {...}
+	cohort_seed?:                {...}
+	retry_policy?:               {...}
+	high_priority?:              bool
+	timeout?:                    string
+	idle_timeout?:               string
+	filter_metadata?:            {...}
+	request_headers_to_add?:     [...{...}]
+	response_headers_to_add?:    [...{...}]
+	request_headers_to_remove?:  [...string]
+	response_headers_to_remove?: [...string]
+	checksum?:                   string
+}
+
+#CatalogService16: {
+	service_id:         string
+	mesh_id:            string
+	name:               string
+	description?:       string
+	api_endpoint?:      string
+	api_spec_endpoint?: string
+	business_impact?:   string
+	capability?:        string
+	documentation?:     string
+	owner?:             string
+	owner_url?:         string
+	version?:           string
+}
+
+// 1.7 added DNS discovery and connection tuning to clusters, access logging and per-route filter config, and
+// metrics settings to catalog entries.
+
+#Cluster17: {
+	#Cluster16
+	dns_type?:                    string
+	dns_lookup_family?:           string
+	dns_refresh_rate?:            int
+	connect_timeout?:             string
+	lb_policy?:                   string
+	upstream_connection_options?: {...}
+	http_protocol_options?:       {...}
+	http2_protocol_options?:      {...}
+}
+
+#Listener17: {
+	#Listener16
+	access_loggers?: {...}
+}
+
+#Route17: {
+	#Route16
+	filter_configs?: {...}
+}
+
+#CatalogService17: {
+	#CatalogService16
+	enable_instance_metrics?:   bool
+	enable_historical_metrics?: bool
+	metrics_template?:          string
+	metrics_port?:              int
+}
+
+versions: {
+	"1.6": {
+		zone:           #Zone16
+		domain:         #Domain16
+		listener:       #Listener16
+		proxy:          #Proxy16
+		cluster:        #Cluster16
+		route:          #Route16
+		catalogservice: #CatalogService16
+	}
+	"1.7": {
+		zone:           #Zone16
+		domain:         #Domain16
+		listener:       #Listener17
+		proxy:          #Proxy16
+		cluster:        #Cluster17
+		route:          #Route17
+		catalogservice: #CatalogService17
+	}
+	latest: {
+		zone: {#Zone16, ...}
+		domain: {#Domain16, ...}
+		listener: {#Listener17, ...}
+		proxy: {#Proxy16, ...}
+		cluster: {#Cluster17, ...}
+		route: {#Route17, ...}
+		catalogservice: {#CatalogService17, ...}
+	}
+}
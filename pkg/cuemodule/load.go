@@ -1,8 +1,10 @@
 package cuemodule
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"errors"
 
@@ -14,6 +16,7 @@ import (
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -30,10 +33,37 @@ type OperatorCUE struct {
 
 	// cue.Value for all of gm/outputs containing Grey Matter config objects
 	GM cue.Value
+
+	// The environment whose values are unified over the CUE when it's loaded (see WithEnvironment)
+	environment string
+	// JSON from the operator's bootstrap configuration that overrides the CUE's config (see WithConfigOverrides)
+	configOverrides json.RawMessage
+	// What's extracted for THE mesh besides the CUE itself, set with the Set methods (see CopyMeshSettings)
+	mesh meshSettings
+}
+
+// meshSettings are the settings of THE mesh and the cluster that change what's extracted from the CUE, which the
+// installer sets each time it unifies the mesh with a fresh copy.
+type meshSettings struct {
+	// The cluster's ingress domain (see SetClusterDomain)
+	clusterDomain string
+	// Added to the edge listener (see SetEdgeAuth)
+	edgeAuth *EdgeAuth
+	// The release version the Grey Matter config is written for (see SetGMConfigVersion)
+	gmConfigVersion string
+	// Names of the disabled components (see SetDisabledComponents)
+	disabledComponents map[string]bool
 }
 
-// LoadAll loads the provided CUE for configuring the operator into an OperatorCUE and a Mesh
-func LoadAll(cuemoduleRoot string) (*OperatorCUE, *v1alpha1.Mesh, error) {
+// CopyMeshSettings sets the settings made with the Set methods from another OperatorCUE, such as the one last
+// unified with THE mesh, so a fresh copy is extracted as it would be.
+func (operatorCUE *OperatorCUE) CopyMeshSettings(from *OperatorCUE) {
+	operatorCUE.mesh = from.mesh
+}
+
+// LoadAll loads the provided CUE for configuring the operator into an OperatorCUE and a Mesh,
+// with options such as WithEnvironment and WithConfigOverrides.
+func LoadAll(cuemoduleRoot string, options ...func(*OperatorCUE)) (*OperatorCUE, *v1alpha1.Mesh, error) {
 	//cwd, _ := os.Getwd()
 	allCUEInstances := load.Instances([]string{
 		"./k8s/outputs",
@@ -42,6 +72,9 @@ func LoadAll(cuemoduleRoot string) (*OperatorCUE, *v1alpha1.Mesh, error) {
 		Dir: cuemoduleRoot, // "If Dir is empty, the tool is run in the current directory"
 	})
 	operatorCUE := &OperatorCUE{}
+	for _, option := range options {
+		option(operatorCUE)
+	}
 	operatorCUE.K8s = cuecontext.New().BuildInstance(allCUEInstances[0])
 	operatorCUE.GM = cuecontext.New().BuildInstance(allCUEInstances[1])
 	if err := operatorCUE.K8s.Err(); err != nil {
@@ -50,6 +83,9 @@ func LoadAll(cuemoduleRoot string) (*OperatorCUE, *v1alpha1.Mesh, error) {
 	if err := operatorCUE.GM.Err(); err != nil {
 		return nil, nil, err
 	}
+	if err := operatorCUE.unifyEnvironment(cuemoduleRoot); err != nil {
+		return nil, nil, err
+	}
 
 	// load default mesh and store it in mesh_install. Later, one operator, one mesh.
 	var extracted struct {
@@ -70,9 +106,407 @@ type Config struct {
 	AutoApplyMesh           bool `json:"auto_apply_mesh"`
 	GenerateWebhookCerts    bool `json:"generate_webhook_certs"`
 	AutoCopyImagePullSecret bool `json:"auto_copy_image_pull_secret"`
+	PruneOrphans            bool `json:"prune_orphans"`
 
 	// Values
 	ClusterIngressName string `json:"cluster_ingress_name"`
+	// How long a greymatter CLI command may run before it is killed; defaults to 30
+	CommandTimeoutSeconds int `json:"command_timeout_seconds"`
+	// How many times a timed-out greymatter CLI command is retried; defaults to 3
+	CommandTimeoutRetries int `json:"command_timeout_retries"`
+	// Commands per second sent to each of the Control and Catalog APIs; unlimited if unset
+	APIRateLimit float64 `json:"api_rate_limit"`
+	// How many commands may be sent at once above the rate limit; defaults to the rate limit (at least 1)
+	APIRateBurst int `json:"api_rate_burst"`
+	// External access to core services
+	Ingress IngressConfig `json:"ingress"`
+	// Tuning of the operator's periodic reconciliation loops
+	Reconcile ReconcileConfig `json:"reconcile"`
+	// How the Deployments and StatefulSets applied in a sync cycle are checked for a successful rollout
+	Rollout RolloutConfig `json:"rollout"`
+	// How SPIRE is installed when the spire flag is set
+	SpireInstall SpireConfig `json:"spire_install"`
+	// Where sidecars get their mTLS identity: IdentityModeSPIRE (the default) or IdentityModeServiceAccount
+	IdentityMode string `json:"identity_mode"`
+	// How generated private keys are written to the cluster
+	KeyDelivery KeyDeliveryConfig `json:"key_delivery"`
+	// Prometheus scraping of sidecars and core components
+	Monitoring MonitoringConfig `json:"monitoring"`
+	// How the operator connects to Catalog, e.g. when it's only reachable through the mesh's edge
+	CatalogAPI CatalogAPIConfig `json:"catalog_api"`
+	// How the operator logs in to Control and Catalog, when they're behind an edge that requires session tokens
+	APIAuth APIAuthConfig `json:"api_auth"`
+	// A Redis for the operator's state, separate from the mesh's own
+	StateRedis StateRedisConfig `json:"state_redis"`
+	// Roles created in each of the mesh's watched namespaces
+	WatchedNamespaceRBAC []NamespaceRole `json:"watched_namespace_rbac"`
+	// Reconcilers run on pods and workloads admitted into the mesh's namespaces, after the operator's own changes
+	CustomReconcilers []CustomReconciler `json:"custom_reconcilers"`
+	// How sidecars and core components are scheduled on clusters with Windows nodes or nodes of several architectures
+	NodePlatforms NodePlatformConfig `json:"node_platforms"`
+	// What happens when core components request more CPU or memory than their namespaces' ResourceQuotas or the
+	// cluster's nodes have left: ResourceBudgetWarn (the default), ResourceBudgetEnforce, or ResourceBudgetOff
+	ResourceBudget string `json:"resource_budget"`
+	// How core components are kept running through cluster autoscaling and node drains
+	Availability AvailabilityConfig `json:"availability"`
+	// Collection of sidecars' resource usage, and the requests recommended for them from it
+	Rightsizing RightsizingConfig `json:"rightsizing"`
+	// Experimental behaviors switched on or off for the mesh by name, e.g. {"drift_enforcement": true}; read from
+	// the CUE of each sync cycle (see Features)
+	Features Features `json:"features"`
+	// Registration of the operator itself into the mesh, with a sidecar of its own
+	SelfRegistration SelfRegistrationConfig `json:"self_registration"`
+}
+
+// SelfRegistrationConfig has the operator add a sidecar to its own pods and Grey Matter config routing the edge to
+// it, so its admin API (with its metrics) is reachable through the mesh's edge over mTLS, like the mesh's workloads.
+type SelfRegistrationConfig struct {
+	// Whether the operator registers itself into the mesh. Its admin API must be served (-adminAddr).
+	Enabled bool `json:"enabled"`
+	// The name of the operator's cluster and Catalog service; "gm-operator" if unset
+	Name string `json:"name"`
+}
+
+// RightsizingConfig has the CPU and memory used by each workload's sidecars sampled periodically, and a request of
+// each recommended from their usage: the 90th percentile of the sampled CPU and the peak sampled memory, plus
+// headroom, within bounds. Recommendations are reported in the Mesh's status and the admin API, and optionally
+// requested by the sidecars injected from then on.
+type RightsizingConfig struct {
+	// Whether sidecars' usage is sampled
+	Enabled bool `json:"enabled"`
+	// Where usage is read from: RightsizingMetricsServer (the default) or RightsizingPrometheus
+	Source string `json:"source"`
+	// Base URL of the Prometheus HTTP API queried with RightsizingPrometheus, e.g. "http://prometheus.monitoring:9090"
+	PrometheusURL string `json:"prometheus_url"`
+	// Samples of each workload's sidecars kept, taken once per config.reconcile.interval_seconds (or a minute);
+	// defaults to 1440
+	Window int `json:"window"`
+	// Samples needed before a workload's sidecars get a recommendation; defaults to 60
+	MinSamples int `json:"min_samples"`
+	// Percentage added to the sampled usage; defaults to 20
+	HeadroomPercent int `json:"headroom_percent"`
+	// Bounds of recommended requests, as quantities (e.g. "10m" and "1" CPU, "32Mi" and "1Gi" memory)
+	MinCPU    string `json:"min_cpu"`
+	MaxCPU    string `json:"max_cpu"`
+	MinMemory string `json:"min_memory"`
+	MaxMemory string `json:"max_memory"`
+	// Whether sidecars injected into workloads with a recommendation request it, instead of the CUE's requests
+	AutoApply bool `json:"auto_apply"`
+}
+
+const (
+	// Sidecars' usage is read from the metrics-server's PodMetrics (kubectl top).
+	RightsizingMetricsServer = "metrics_server"
+	// Sidecars' usage is read from the cAdvisor metrics in Prometheus.
+	RightsizingPrometheus = "prometheus"
+)
+
+// AvailabilityConfig has the pods of the core components in ComponentWorkloads scheduled ahead of, and able to
+// preempt, workloads of lower priority, and limits how many of them voluntary disruptions such as node drains and
+// autoscaler scale-downs take down at once. A Mesh's overrides can replace both for each component.
+type AvailabilityConfig struct {
+	// Whether core components get a PriorityClass and PodDisruptionBudgets
+	Enabled bool `json:"enabled"`
+	// PriorityClass of core components that don't set their own; "greymatter-core" if unset. It's created unless
+	// it's a built-in class, such as "system-cluster-critical".
+	PriorityClassName string `json:"priority_class_name"`
+	// Value of the created PriorityClass; 1000000 if unset
+	PriorityValue int32 `json:"priority_value"`
+	// Pods of each core component that may be unavailable, as a number or a percentage; 1 if neither this nor
+	// min_available is set
+	MaxUnavailable *intstr.IntOrString `json:"max_unavailable"`
+	// Pods of each core component that must stay available, as a number or a percentage, instead of max_unavailable
+	MinAvailable *intstr.IntOrString `json:"min_available"`
+}
+
+const (
+	// The PriorityClass of core components if config.availability doesn't name one.
+	DefaultPriorityClassName = "greymatter-core"
+	// The value of the created PriorityClass if config.availability doesn't set one.
+	DefaultPriorityValue = 1000000
+)
+
+// NodePlatformConfig keeps sidecars and core components off nodes they can't run on, in mixed-OS and
+// mixed-architecture clusters. Workloads are pinned to an OS or architecture by spec.os, a kubernetes.io/os or
+// kubernetes.io/arch nodeSelector, or required node affinity on those labels.
+type NodePlatformConfig struct {
+	// Whether core components, and pods injected with a sidecar, that don't select an OS are pinned to Linux nodes
+	PinLinux bool `json:"pin_linux"`
+	// Sidecar image for workloads pinned to Windows nodes, which aren't injected with a sidecar if it's unset
+	WindowsSidecarImage string `json:"windows_sidecar_image"`
+	// Architectures the sidecar image is built for; workloads pinned to others aren't injected with a sidecar.
+	// Defaults to amd64 and arm64.
+	SidecarArchitectures []string `json:"sidecar_architectures"`
+	// Architectures of the cluster's Linux nodes, most preferred first; detected from their kubernetes.io/arch
+	// labels, most common first, if empty
+	NodeArchitectures []string `json:"node_architectures"`
+	// Images of core components and sidecars that are each built for a single architecture, keyed by the image the
+	// CUE gives, with the image built for each architecture (which may be the key itself). Images that aren't
+	// listed are assumed to be multi-arch.
+	ArchImages map[string]map[string]string `json:"arch_images"`
+}
+
+// DefaultSidecarArchitectures are the architectures of the sidecar image if config.node_platforms doesn't list them.
+var DefaultSidecarArchitectures = []string{"amd64", "arm64"}
+
+// CustomReconciler sets labels and annotations on the pods, or the pod templates of the Deployments and
+// StatefulSets, admitted into the mesh's namespaces, e.g. company-specific labels. Values the object already has are
+// left alone. It's registered alongside reconcilers registered in Go (see webhooks.RegisterPodReconciler).
+type CustomReconciler struct {
+	Name string `json:"name"`
+	// Pod, Deployment, or StatefulSet; Deployment and StatefulSet if empty
+	Kinds []string `json:"kinds"`
+	// Only objects in these namespaces, if any
+	Namespaces []string `json:"namespaces"`
+	// Only objects whose pods ask for a sidecar with the inject-sidecar-to annotation
+	SidecarOnly bool              `json:"sidecar_only"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// NamespaceRole is a Role the operator creates in each of the mesh's watched namespaces, bound to ServiceAccounts, e.g.
+// so that Control can discover the pods in them without cluster-wide permissions. It's deleted from namespaces that
+// are no longer watched.
+type NamespaceRole struct {
+	// Name of the Role and of its RoleBinding
+	Name  string              `json:"name"`
+	Rules []rbacv1.PolicyRule `json:"rules"`
+	// ServiceAccounts bound to the Role, in the mesh's install namespace unless given as "namespace/name"
+	ServiceAccounts []string `json:"service_accounts"`
+}
+
+// StateRedisConfig configures a Redis for the operator's state separate from the one the mesh uses internally (e.g. for
+// health checks and ingress lists), so the operator's state doesn't share a datastore with the data plane. When it's
+// set, defaults.redis_* only configure the mesh's Redis.
+type StateRedisConfig struct {
+	// Host of the Redis; the operator's state is kept in the mesh's Redis if unset
+	Host string `json:"host"`
+	// Defaults to 6379
+	Port int `json:"port"`
+	DB   int `json:"db"`
+	// Optional; Secret in the gm-operator namespace with a "password" key, and an optional "username" key
+	CredentialsSecret string `json:"credentials_secret"`
+}
+
+// StateRedisPort is the port of config.state_redis if it's unset.
+const StateRedisPort = 6379
+
+// APIAuthConfig configures the OAuth2 client credentials the operator logs in to Control and Catalog with. Its
+// session token is renewed before it expires, and whenever an API rejects it.
+type APIAuthConfig struct {
+	// URL of the OAuth2 token endpoint; the operator doesn't log in if unset
+	TokenURL string `json:"token_url"`
+	// Secret in the gm-operator namespace with "client_id" and "client_secret" keys
+	CredentialsSecret string `json:"credentials_secret"`
+	// Scopes requested for the session token
+	Scopes []string `json:"scopes"`
+}
+
+// CatalogAPIConfig configures how the operator connects to Catalog and authenticates to it, for a Catalog that
+// requires TLS, such as one behind the mesh's edge.
+type CatalogAPIConfig struct {
+	// URL of the Catalog API; defaults to the catalog Service in the mesh's install namespace
+	URL string `json:"url"`
+	// Whether to present a client certificate issued by the operator's CA, which also signs the mesh's identities
+	ClientCertificate bool `json:"client_certificate"`
+	// PEM bundle of the CAs trusted to sign Catalog's certificate; the system's are trusted if unset
+	CABundle string `json:"ca_bundle"`
+	// Secret in the gm-operator namespace whose "token" key is sent to Catalog as a bearer token
+	TokenSecret string `json:"token_secret"`
+}
+
+// MonitoringConfig configures how Prometheus is told to scrape the metrics of injected sidecars and core components.
+type MonitoringConfig struct {
+	// Whether scrape targets are generated
+	Enabled bool `json:"enabled"`
+	// MonitoringPrometheusOperator, MonitoringAnnotations, or MonitoringNone; detected from the cluster if unset
+	Mode string `json:"mode"`
+	// Name of the container port serving metrics; defaults to "metrics"
+	Port string `json:"port"`
+	// Path metrics are served at; defaults to "/prometheus"
+	Path string `json:"path"`
+	// Seconds between scrapes of PodMonitors; Prometheus's default if unset
+	IntervalSeconds int `json:"interval_seconds"`
+	// Labels of generated PodMonitors, e.g. to match a Prometheus's podMonitorSelector
+	Labels map[string]string `json:"labels"`
+}
+
+const (
+	// Scrape targets are PodMonitors of the Prometheus Operator; detected if its CRDs are installed.
+	MonitoringPrometheusOperator = "prometheus_operator"
+	// Scrape targets are pod template annotations (prometheus.io/scrape, port, and path), as understood by the
+	// Prometheus community Helm chart's kubernetes-pods job.
+	MonitoringAnnotations = "annotations"
+	// No scrape targets are generated.
+	MonitoringNone = "none"
+)
+
+// KeyDeliveryConfig configures how private keys generated by the operator, such as SPIRE's intermediate CA and
+// sidecar identities, are written to the cluster.
+type KeyDeliveryConfig struct {
+	// KeyDeliverySecret (the default), KeyDeliverySealedSecret, or KeyDeliveryExternalSecret
+	Mode string `json:"mode"`
+	// PEM-encoded certificate of the sealed-secrets controller's active key. If unset, it's fetched from the
+	// controller's Service (sealing_controller_service in sealing_controller_namespace, by default
+	// sealed-secrets-controller in kube-system), as kubeseal --fetch-cert does.
+	SealingCert                string `json:"sealing_cert"`
+	SealingControllerNamespace string `json:"sealing_controller_namespace"`
+	SealingControllerService   string `json:"sealing_controller_service"`
+	// The external-secrets SecretStore holding SPIRE's intermediate CA, and its kind; defaults to SecretStore
+	SecretStore     string `json:"secret_store"`
+	SecretStoreKind string `json:"secret_store_kind"`
+	// Key in the SecretStore whose root.crt, intermediate.crt, and intermediate.key properties hold the CA
+	RemoteKey string `json:"remote_key"`
+}
+
+const (
+	// Keys are written to plain Secrets.
+	KeyDeliverySecret = "secret"
+	// Keys are written to SealedSecrets, encrypted to the sealed-secrets controller's key, which decrypts them
+	// into Secrets.
+	KeyDeliverySealedSecret = "sealed_secret"
+	// SPIRE's intermediate CA is pulled from an external store by the external-secrets operator instead of being
+	// generated, and other keys are written to plain Secrets.
+	KeyDeliveryExternalSecret = "external_secret"
+)
+
+const (
+	// Sidecar identities are issued by SPIRE, if the spire flag is set.
+	IdentityModeSPIRE = "spire"
+	// Sidecar identities are derived from their pods' ServiceAccounts, with certificates issued by the operator.
+	IdentityModeServiceAccount = "service_account"
+)
+
+const (
+	// Core components that don't fit are logged and reported in an event on the Mesh, and applied anyway.
+	ResourceBudgetWarn = "warn"
+	// The sync is refused, before anything is applied, if core components don't fit.
+	ResourceBudgetEnforce = "enforce"
+	// Core components' requests aren't checked.
+	ResourceBudgetOff = "off"
+)
+
+// SpireConfig configures the operator's management of the SPIRE server and agents.
+type SpireConfig struct {
+	// SPIRE is installed separately, so the operator only creates its server-ca secret
+	External bool `json:"external"`
+	// Secret in the spire namespace whose keys are set as environment variables in the SPIRE server,
+	// for the datastore settings in its config (e.g. a connection_string of "${CONNECTION_STRING}")
+	DatastoreSecret string `json:"datastore_secret"`
+	// Namespaces the trust bundle is published to, in addition to the mesh's install and watched namespaces
+	TrustBundleNamespaces []string `json:"trust_bundle_namespaces"`
+}
+
+// ReconcileConfig tunes the operator's periodic reconciliation loops, e.g. to limit apiserver load on large clusters.
+type ReconcileConfig struct {
+	// Seconds between cycles of each loop; defaults to 10
+	IntervalSeconds int `json:"interval_seconds"`
+	// Maximum objects returned per List request to the apiserver; defaults to 500
+	PageSize int64 `json:"page_size"`
+	// Names of loops to disable ("catalog_api_specs", "catalog_prune", "certificates", "identity",
+	// "namespace_labels", "sidecar_list", "sidecar_removal", "spire", or "sync_report")
+	Disabled []string `json:"disabled"`
+	// Maximum workloads whose sidecar configuration is applied or removed at once; defaults to 4
+	Workers int `json:"workers"`
+	// Sync cycles an object may be missing from the config before its state entry is compacted and the object
+	// is deleted; defaults to 1 (deleted as soon as it's missing)
+	StateRetentionCycles int `json:"state_retention_cycles"`
+	// Maximum percentage of known objects deleted in one sync cycle; more are held until released through the
+	// admin API (e.g. if a bad commit empties the config). 0 means no limit.
+	MaxDeletePercent int `json:"max_delete_percent"`
+	// Hold all deletions until released through the admin API
+	HoldDeletions bool `json:"hold_deletions"`
+	// Seconds the operator's state may have changes not persisted to Redis before the mesh's StatePersisted
+	// condition reports it; defaults to 300
+	StateDivergenceSeconds int `json:"state_divergence_seconds"`
+	// Fetch each changed K8s object before applying it, and skip the update if the live object already matches
+	SkipUnchangedLive bool `json:"skip_unchanged_live"`
+	// Seconds each request to the apiserver (or page of a List request) may take before it's abandoned; defaults
+	// to 30
+	APITimeoutSeconds int `json:"api_timeout_seconds"`
+}
+
+// Interval returns the configured time between cycles, or the given default if unset.
+func (rc ReconcileConfig) Interval(defaultInterval time.Duration) time.Duration {
+	if rc.IntervalSeconds > 0 {
+		return time.Duration(rc.IntervalSeconds) * time.Second
+	}
+	return defaultInterval
+}
+
+// APITimeout returns the configured time each request to the apiserver may take, or 0 for the default.
+func (rc ReconcileConfig) APITimeout() time.Duration {
+	return time.Duration(rc.APITimeoutSeconds) * time.Second
+}
+
+// Enabled reports whether the named loop is enabled.
+func (rc ReconcileConfig) Enabled(name string) bool {
+	for _, disabled := range rc.Disabled {
+		if disabled == name {
+			return false
+		}
+	}
+	return true
+}
+
+// RolloutConfig configures how the operator waits for the Deployments and StatefulSets it updates in a sync cycle
+// to roll out.
+type RolloutConfig struct {
+	// Wait for the workloads to roll out, and restore the previous version of every manifest applied in the cycle
+	// if any of them fail (e.g. crash-loop, or aren't ready within the timeout)
+	Rollback bool `json:"rollback"`
+	// Seconds a workload may take to roll out before it's considered failed; defaults to 300
+	TimeoutSeconds int `json:"timeout_seconds"`
+}
+
+// Timeout returns the configured time a workload may take to roll out, or the default.
+func (rc RolloutConfig) Timeout() time.Duration {
+	if rc.TimeoutSeconds > 0 {
+		return time.Duration(rc.TimeoutSeconds) * time.Second
+	}
+	return 5 * time.Minute
+}
+
+// IngressConfig configures the OpenShift Routes (or, elsewhere, Ingresses) created for external access to core services.
+type IngressConfig struct {
+	Enabled bool `json:"enabled"`
+	// How services are exposed: "openshift", "nginx", "traefik", "ingress" (for any other ingress controller), or
+	// "none" to leave it to the user; detected from the cluster if unset
+	Provider string `json:"provider"`
+	// Core services to expose; defaults to edge, dashboard, and catalog
+	Services []string `json:"services"`
+	// Domain under which each service gets a host; defaults to the OpenShift cluster ingress domain, or the domain an
+	// nginx or Traefik IngressClass is annotated with (greymatter.io/ingress-domain)
+	Domain string `json:"domain"`
+	// IngressClass of created Ingresses (ignored on OpenShift)
+	IngressClass string `json:"ingress_class"`
+	// Route TLS termination ("edge", "passthrough", or "reencrypt"); plain HTTP if unset (ignored off OpenShift)
+	TLSTermination string `json:"tls_termination"`
+	// Secret with the TLS certificate for created Ingresses; plain HTTP if unset (ignored on OpenShift)
+	TLSSecretName string `json:"tls_secret_name"`
+	// Template of a host for each catalogued service, e.g. "{service}.{mesh}.{clusterDomain}", at which the edge
+	// routes to it; {clusterDomain} is the domain above. No hosts are generated if unset.
+	HostTemplate string `json:"host_template"`
+	// Template of the Secret with each generated host's TLS certificate, e.g. "{service}-tls"; defaults to
+	// tls_secret_name
+	HostTLSSecret string `json:"host_tls_secret"`
+	// Key of the listener that generated hosts are added to as domains; defaults to "edge"
+	EdgeListenerKey string `json:"edge_listener_key"`
+	// cert-manager Certificates for the generated hosts' TLS Secrets
+	Certificates CertificatesConfig `json:"certificates"`
+}
+
+// CertificatesConfig configures the cert-manager Certificates requested for the TLS Secrets of generated edge hosts.
+type CertificatesConfig struct {
+	// Issuer of the certificates; none are requested if unset
+	Issuer string `json:"issuer"`
+	// "ClusterIssuer" (the default) or "Issuer"
+	IssuerKind string `json:"issuer_kind"`
+	// How long before they expire certificates are renewed; cert-manager's default if unset
+	RenewBeforeHours int `json:"renew_before_hours"`
+	// Whether the edge is restarted when a certificate is renewed, for edge proxies that only read certificates from
+	// a mounted Secret on start
+	RestartEdge bool `json:"restart_edge"`
 }
 
 type Defaults struct {
@@ -84,6 +518,12 @@ type Defaults struct {
 	RedisPassword     string   `json:"redis_password"`
 	GitOpsStateKeyGM  string   `json:"gitops_state_key_gm"`
 	GitOpsStateKeyK8s string   `json:"gitops_state_key_k8s"`
+	// Optional; defaults to "gm-operator-derived-defaults"
+	GitOpsStateKeyDefaults string `json:"gitops_state_key_defaults"`
+	// Optional; a BoltDB file (e.g. on a PersistentVolumeClaim) to keep state in instead of Redis
+	StateFile string `json:"state_file"`
+	// Image for the init container that installs iptables rules for transparent traffic capture
+	TrafficRedirectImage string `json:"traffic_redirect_image"`
 }
 
 // ExtractConfig pulls the values from the CUE into the Config struct in Go
@@ -98,14 +538,59 @@ func (operatorCUE *OperatorCUE) ExtractConfig() (Config, Defaults) {
 	if err != nil {
 		panic(err)
 	}
+	operatorCUE.overrideConfig(&extracted.Config)
 
 	return extracted.Config, extracted.Defaults
 }
 
+// ValidateConfigOverrides checks JSON meant to override keys of the CUE's config (see WithConfigOverrides).
+func ValidateConfigOverrides(overrides json.RawMessage) error {
+	if len(overrides) > 0 {
+		decoder := json.NewDecoder(bytes.NewReader(overrides))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&Config{}); err != nil {
+			return fmt.Errorf("invalid config overrides: %w", err)
+		}
+	}
+	return nil
+}
+
+// WithConfigOverrides has LoadAll load the CUE with JSON that overrides keys of its config wherever it's extracted,
+// which must be checked with ValidateConfigOverrides. Nested objects are merged with the CUE's, and other values
+// replace them.
+func WithConfigOverrides(overrides json.RawMessage) func(*OperatorCUE) {
+	return func(operatorCUE *OperatorCUE) {
+		operatorCUE.configOverrides = overrides
+	}
+}
+
+// overrideConfig applies the config overrides, if any, to config extracted from the CUE.
+func (operatorCUE *OperatorCUE) overrideConfig(config interface{}) {
+	if len(operatorCUE.configOverrides) > 0 {
+		// Validated by ValidateConfigOverrides
+		_ = json.Unmarshal(operatorCUE.configOverrides, config)
+	}
+}
+
 // TODO who should be responsible for logging errors - these, or the calling functions? I've been inconsistent about it
 
 // UnifyWithMesh unifies the operatorCUE with a Mesh CR to fill in values
 func (operatorCUE *OperatorCUE) UnifyWithMesh(mesh *v1alpha1.Mesh) error {
+	// Component overrides, the injection policy, additional zones, the GitOps source, edge auth, the config version,
+	// and disabled components are handled in Go (see ApplyComponentOverrides, mesh_install.Installer.InjectionAllowed,
+	// gmapi, gitops.Sync.Reconfigure, SetEdgeAuth, SetGMConfigVersion, and SetDisabledComponents), so they're left
+	// out of the CUE mesh schema.
+	if mesh.Spec.Overrides != nil || mesh.Spec.Injection != nil || mesh.Spec.Zones != nil || mesh.Spec.GitOps != nil || mesh.Spec.EdgeAuth != nil ||
+		mesh.Spec.ConfigVersion != "" || mesh.Spec.DisabledComponents != nil {
+		mesh = mesh.DeepCopy()
+		mesh.Spec.Overrides = nil
+		mesh.Spec.Injection = nil
+		mesh.Spec.Zones = nil
+		mesh.Spec.GitOps = nil
+		mesh.Spec.EdgeAuth = nil
+		mesh.Spec.ConfigVersion = ""
+		mesh.Spec.DisabledComponents = nil
+	}
 	meshValue, err := FromStruct("mesh", mesh)
 	if err != nil {
 		return err
@@ -148,12 +633,15 @@ func (operatorCUE *OperatorCUE) TempGMValueUnifiedWithDefaults(defaults Defaults
 			"Unification Result", meshConfigsValue)
 		return OperatorCUE{}, err
 	}
-	return OperatorCUE{GM: meshConfigsValue, K8s: operatorCUE.K8s}, nil
+	unified := *operatorCUE
+	unified.GM = meshConfigsValue
+	return unified, nil
 }
 
 // K8s Manifests
 
-// ExtractCoreK8sManifests extracts the K8s manifests for a mesh from the top-level array in the k8s/outputs/EXTRACTME.cue
+// ExtractCoreK8sManifests extracts the K8s manifests for a mesh from the top-level array in the k8s/outputs/EXTRACTME.cue,
+// except those of its disabled components (see SetDisabledComponents)
 func (operatorCUE *OperatorCUE) ExtractCoreK8sManifests() (manifestObjects []client.Object, err error) {
 
 	// Extract correct K8s config for options - for now there's only one
@@ -167,13 +655,55 @@ func (operatorCUE *OperatorCUE) ExtractCoreK8sManifests() (manifestObjects []cli
 	}
 
 	manifestObjects = ExtractAndTypeK8sManifestObjects(extracted.K8sManifests)
+	return withoutDisabledManifests(operatorCUE.mesh.disabledComponents, manifestObjects), nil
+}
+
+// ExtractSpireK8sManifests extracts the K8s manifests for the SPIRE server and agents from the top-level array in the
+// k8s/outputs/EXTRACTME.cue
+func (operatorCUE *OperatorCUE) ExtractSpireK8sManifests() (manifestObjects []client.Object, err error) {
+	var extracted struct {
+		SpireManifests []json.RawMessage `json:"spire_manifests"`
+	}
+	err = Extract(operatorCUE.K8s, &extracted)
+	if err != nil {
+		return nil, err
+	}
+
+	manifestObjects = ExtractAndTypeK8sManifestObjects(extracted.SpireManifests)
 	return manifestObjects, nil
 }
 
 // Mesh Configs
 
-// ExtractCoreMeshConfigs extracts the GM config objects for a mesh from the top-level array in the gm/outputs/EXTRACTME.cue
+// ExtractCoreMeshConfigs extracts the GM config objects for a mesh from the top-level array in the gm/outputs/EXTRACTME.cue,
+// except those of its disabled components (see SetDisabledComponents), migrated from the mesh's spec.config_version
+// to its spec.release_version (see SetGMConfigVersion), along with
+// the edge domains and routes generated from config.ingress.host_template (see EdgeHosts) and the edge listener's
+// filters for the mesh's spec.edge_auth (see SetEdgeAuth)
 func (operatorCUE *OperatorCUE) ExtractCoreMeshConfigs() (meshConfigs []json.RawMessage, kinds []string, err error) {
+	meshConfigs, kinds, err = operatorCUE.extractMeshConfigs()
+	if err != nil {
+		return nil, nil, err
+	}
+	meshConfigs, kinds = withoutDisabledConfigs(operatorCUE.mesh.disabledComponents, meshConfigs, kinds)
+	meshConfigs, err = operatorCUE.migrateMeshConfigs(meshConfigs, kinds)
+	if err != nil {
+		return nil, nil, err
+	}
+	ingress, mesh, err := operatorCUE.extractEdgeHostSettings()
+	if err != nil {
+		return nil, nil, err
+	}
+	hosts := edgeHosts(ingress, operatorCUE.mesh.clusterDomain, mesh, meshConfigs, kinds)
+	meshConfigs, kinds, err = addEdgeRouting(ingress, hosts, meshConfigs, kinds)
+	if err != nil {
+		return nil, nil, err
+	}
+	return addEdgeAuth(operatorCUE.mesh.edgeAuth, ingress, meshConfigs, kinds)
+}
+
+// extractMeshConfigs extracts the GM config objects from the CUE alone.
+func (operatorCUE *OperatorCUE) extractMeshConfigs() (meshConfigs []json.RawMessage, kinds []string, err error) {
 	var extracted struct {
 		MeshConfigs []json.RawMessage `json:"mesh_configs"`
 	}
@@ -189,8 +719,9 @@ func (operatorCUE *OperatorCUE) ExtractCoreMeshConfigs() (meshConfigs []json.Raw
 // Deployment assist sidecar K8s and GM
 
 // UnifyAndExtractSidecar unifies the cluster meant for a deployment with the CUE for a to-be-injected sidecar,
-// and extracts the K8s manifest components to be injected
-func (operatorCUE *OperatorCUE) UnifyAndExtractSidecar(clusterLabel string) (container corev1.Container, volumes []corev1.Volume, err error) {
+// and extracts the K8s manifest components to be injected. A workload's envoy bootstrap override (YAML or JSON), if
+// any, is merged into the sidecar's (see MergeBootstrapOverride).
+func (operatorCUE *OperatorCUE) UnifyAndExtractSidecar(clusterLabel string, bootstrapOverride string) (container corev1.Container, volumes []corev1.Volume, err error) {
 	// By this point, we can assume GM has *already* been unified with THE mesh that this operator manages,
 	// when the mesh was created.
 
@@ -218,6 +749,9 @@ func (operatorCUE *OperatorCUE) UnifyAndExtractSidecar(clusterLabel string) (con
 	}
 	// TODO handle extraction error by exploding loudly
 	err = Extract(unifiedValue, &extracted)
+	if err == nil && bootstrapOverride != "" {
+		err = MergeBootstrapOverride(&extracted.SidecarContainer.Container, bootstrapOverride)
+	}
 
 	return extracted.SidecarContainer.Container, extracted.SidecarContainer.Volumes, err
 }
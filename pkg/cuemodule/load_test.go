@@ -2,7 +2,9 @@ package cuemodule
 
 import (
 	"testing"
+	"time"
 
+	"github.com/stretchr/testify/assert"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 )
@@ -21,3 +23,29 @@ func TestLoadRedisListener(t *testing.T) {
 	logger.Info("blurp", "listener", redisListener)
 	//logger.Info("LoadAll sidecarList", "SidecarList", defaults.SidecarList)
 }
+
+func TestReconcileConfig(t *testing.T) {
+	var rc ReconcileConfig
+	assert.Equal(t, 10*time.Second, rc.Interval(10*time.Second))
+	assert.True(t, rc.Enabled("sidecar_list"))
+
+	rc = ReconcileConfig{IntervalSeconds: 60, Disabled: []string{"sidecar_list"}}
+	assert.Equal(t, time.Minute, rc.Interval(10*time.Second))
+	assert.False(t, rc.Enabled("sidecar_list"))
+	assert.True(t, rc.Enabled("sync_report"))
+}
+
+func TestConfigOverrides(t *testing.T) {
+	overrides := []byte(`{"prune_orphans":true,"reconcile":{"interval_seconds":60}}`)
+	assert.NoError(t, ValidateConfigOverrides(overrides))
+	operatorCUE := &OperatorCUE{}
+	WithConfigOverrides(overrides)(operatorCUE)
+	config := Config{Spire: true, Reconcile: ReconcileConfig{Disabled: []string{"spire"}}}
+	operatorCUE.overrideConfig(&config)
+	assert.True(t, config.Spire)
+	assert.True(t, config.PruneOrphans)
+	assert.Equal(t, ReconcileConfig{IntervalSeconds: 60, Disabled: []string{"spire"}}, config.Reconcile)
+
+	assert.Error(t, ValidateConfigOverrides([]byte(`{"prune_orphan":true}`)))
+	assert.Error(t, ValidateConfigOverrides([]byte(`{"spire":"yes"}`)))
+}
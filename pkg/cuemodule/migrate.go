@@ -0,0 +1,181 @@
+package cuemodule
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// gmMigration is a step that rewrites Grey Matter config objects of one kind, written for the release before To, into
+// the form Control in To expects.
+type gmMigration struct {
+	To   string
+	Kind string
+	// What the step changes, as reported for each object it changes
+	Change string
+	// Migrate rewrites the object in place, returning false if it didn't need migrating
+	Migrate func(obj map[string]interface{}) bool
+}
+
+// gmMigrations are the migration steps between the release versions in gm_schema.cue, in the order they're applied.
+var gmMigrations = []gmMigration{
+	{
+		To:     "1.7",
+		Kind:   "cluster",
+		Change: `replaced protocol "http2" with http2_protocol_options, since Control 1.7 ignores protocol`,
+		Migrate: func(obj map[string]interface{}) bool {
+			if obj["protocol"] != "http2" {
+				return false
+			}
+			delete(obj, "protocol")
+			if _, ok := obj["http2_protocol_options"]; !ok {
+				obj["http2_protocol_options"] = map[string]interface{}{}
+			}
+			return true
+		},
+	},
+}
+
+// GMConfigMigration is a change made to a Grey Matter config object by MigrateGMConfigObjects.
+type GMConfigMigration struct {
+	Kind string
+	// The object's zone_key (mesh_id for catalogservices)
+	Zone string
+	// The object's key (service_id for catalogservices)
+	Key    string
+	To     string
+	Change string
+}
+
+func (m GMConfigMigration) String() string {
+	return fmt.Sprintf("%s %s/%s: %s (%s)", m.Kind, m.Zone, m.Key, m.Change, m.To)
+}
+
+// MigrateGMConfigObjects rewrites the Grey Matter config objects (with kinds as identified by IdentifyGMConfigObjects)
+// written for Control in the from release version into the form expected by Control in the to release version (latest
+// if unset), applying each migration step for the releases after from, up to and including to. Objects that don't need
+// migrating are returned as they were, so their hashes don't change. It returns the changes it made, and an error if
+// from is unknown or later than to.
+func MigrateGMConfigObjects(from, to string, objects []json.RawMessage, kinds []string) ([]json.RawMessage, []GMConfigMigration, error) {
+	if from == "" || from == to {
+		return objects, nil, nil
+	}
+	if to == "" {
+		to = "latest"
+	}
+	fromIdx, toIdx := -1, -1
+	for idx, version := range gmSchemaVersions {
+		if version == from {
+			fromIdx = idx
+		}
+		if version == to {
+			toIdx = idx
+		}
+	}
+	if fromIdx < 0 {
+		return nil, nil, fmt.Errorf("unknown config_version %q; must be one of %s", from, strings.Join(gmSchemaVersions[:len(gmSchemaVersions)-1], ", "))
+	}
+	if toIdx < 0 {
+		// Validated by the Mesh CRD, so an unknown release is newer than this operator knows
+		toIdx = len(gmSchemaVersions) - 1
+	}
+	if fromIdx > toIdx {
+		return nil, nil, fmt.Errorf("config_version %s is later than release_version %s; Grey Matter config can't be migrated to an earlier release", from, to)
+	}
+	steps := make(map[string][]gmMigration)
+	for _, step := range gmMigrations {
+		for _, version := range gmSchemaVersions[fromIdx+1 : toIdx+1] {
+			if step.To == version {
+				steps[step.Kind] = append(steps[step.Kind], step)
+			}
+		}
+	}
+
+	migrated := make([]json.RawMessage, len(objects))
+	var changes []GMConfigMigration
+	for idx, object := range objects {
+		migrated[idx] = object
+		kind := kinds[idx]
+		if len(steps[kind]) == 0 {
+			continue
+		}
+		var obj map[string]interface{}
+		if err := json.Unmarshal(object, &obj); err != nil {
+			// Reported by ValidateGMSchema
+			continue
+		}
+		var ids gmObject
+		_ = json.Unmarshal(object, &ids)
+		scope := ids.ZoneKey
+		if kind == "catalogservice" {
+			scope = ids.MeshID
+		}
+		changed := false
+		for _, step := range steps[kind] {
+			if step.Migrate(obj) {
+				changed = true
+				changes = append(changes, GMConfigMigration{Kind: kind, Zone: scope, Key: ids.key(kind), To: step.To, Change: step.Change})
+			}
+		}
+		if !changed {
+			continue
+		}
+		out, err := json.Marshal(obj)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to encode migrated %s %s/%s: %w", kind, scope, ids.key(kind), err)
+		}
+		migrated[idx] = out
+	}
+	return migrated, changes, nil
+}
+
+// SetGMConfigVersion sets the release version the Grey Matter config is written for (THE mesh's
+// spec.config_version), from which it's migrated to the mesh's release version wherever it's extracted, or stops
+// migrating it if empty.
+func (operatorCUE *OperatorCUE) SetGMConfigVersion(version string) {
+	operatorCUE.mesh.gmConfigVersion = version
+}
+
+// The migrations last logged by migrateMeshConfigs, so they're logged when they change rather than every extraction.
+var (
+	loggedMigrationsMu sync.Mutex
+	loggedMigrations   string
+)
+
+// migrateMeshConfigs migrates the GM config objects extracted from the CUE from the mesh's spec.config_version (see
+// SetGMConfigVersion) to its spec.release_version.
+func (operatorCUE *OperatorCUE) migrateMeshConfigs(meshConfigs []json.RawMessage, kinds []string) ([]json.RawMessage, error) {
+	from := operatorCUE.mesh.gmConfigVersion
+	if from == "" {
+		return meshConfigs, nil
+	}
+	var extracted struct {
+		Mesh struct {
+			Spec struct {
+				ReleaseVersion string `json:"release_version"`
+			} `json:"spec"`
+		} `json:"mesh"`
+	}
+	if err := Extract(operatorCUE.K8s, &extracted); err != nil {
+		return nil, err
+	}
+	to := extracted.Mesh.Spec.ReleaseVersion
+	migrated, changes, err := MigrateGMConfigObjects(from, to, meshConfigs, kinds)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	for _, change := range changes {
+		lines = append(lines, change.String())
+	}
+	summary := strings.Join(lines, "\n")
+	loggedMigrationsMu.Lock()
+	defer loggedMigrationsMu.Unlock()
+	if summary != loggedMigrations && len(changes) > 0 {
+		logger.Info("Migrated Grey Matter config objects", "From", from, "To", to, "Changes", lines)
+	}
+	loggedMigrations = summary
+	return migrated, nil
+}
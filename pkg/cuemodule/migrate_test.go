@@ -0,0 +1,54 @@
+package cuemodule
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrateGMConfigObjects(t *testing.T) {
+	configs := []json.RawMessage{
+		json.RawMessage(`{"zone_key":"default-zone","name":"default-zone"}`),
+		json.RawMessage(`{"cluster_key":"catalog","zone_key":"default-zone","name":"catalog","protocol":"http2","instances":[]}`),
+		json.RawMessage(`{"cluster_key":"control","zone_key":"default-zone","name":"control","protocol":"http"}`),
+	}
+	kinds := IdentifyGMConfigObjects(configs)
+
+	migrated, changes, err := MigrateGMConfigObjects("1.6", "1.7", configs, kinds)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.JSONEq(t, `{"cluster_key":"catalog","zone_key":"default-zone","name":"catalog","http2_protocol_options":{},"instances":[]}`, string(migrated[1]))
+	var lines []string
+	for _, change := range changes {
+		lines = append(lines, change.String())
+	}
+	assert.Equal(t, []string{
+		`cluster default-zone/catalog: replaced protocol "http2" with http2_protocol_options, since Control 1.7 ignores protocol (1.7)`,
+	}, lines)
+	// Objects that don't need migrating are left byte for byte, so their hashes don't change
+	assert.Equal(t, configs[0], migrated[0])
+	assert.Equal(t, configs[2], migrated[2])
+	// The migrated config passes the target release's schema
+	assert.Empty(t, ValidateGMSchema("1.7", migrated, kinds))
+
+	// Migrating to latest applies every later release's steps
+	_, changes, err = MigrateGMConfigObjects("1.6", "", configs, kinds)
+	assert.NoError(t, err)
+	assert.Len(t, changes, 1)
+
+	// Config written for the release it's applied to isn't migrated
+	migrated, changes, err = MigrateGMConfigObjects("1.7", "1.7", configs, kinds)
+	assert.NoError(t, err)
+	assert.Empty(t, changes)
+	assert.Equal(t, configs, migrated)
+	migrated, _, _ = MigrateGMConfigObjects("", "1.7", configs, kinds)
+	assert.Equal(t, configs, migrated)
+
+	_, _, err = MigrateGMConfigObjects("1.7", "1.6", configs, kinds)
+	assert.Contains(t, fmt.Sprint(err), "config_version 1.7 is later than release_version 1.6")
+	_, _, err = MigrateGMConfigObjects("1.5", "1.7", configs, kinds)
+	assert.Contains(t, fmt.Sprint(err), `unknown config_version "1.5"`)
+}
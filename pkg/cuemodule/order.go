@@ -0,0 +1,67 @@
+package cuemodule
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// gmKindDependencies lists the Grey Matter config kinds in the order they're applied,
+// along with the kinds each one references by key. Every kind must come after its dependencies.
+var gmKindDependencies = []struct {
+	kind      string
+	dependsOn []string
+}{
+	{kind: "zone"},
+	{kind: "domain", dependsOn: []string{"zone"}},
+	{kind: "cluster", dependsOn: []string{"zone"}},
+	{kind: "listener", dependsOn: []string{"zone", "domain"}},
+	{kind: "proxy", dependsOn: []string{"zone", "domain", "listener"}},
+	{kind: "route", dependsOn: []string{"zone", "domain", "cluster"}},
+	// Catalog entries refer to clusters by name, which Catalog only needs to exist to report on them.
+	{kind: "catalogservice", dependsOn: []string{"cluster"}},
+}
+
+// GMKinds returns the recognized Grey Matter config kinds in apply order.
+func GMKinds() []string {
+	kinds := make([]string, len(gmKindDependencies))
+	for i, dep := range gmKindDependencies {
+		kinds[i] = dep.kind
+	}
+	return kinds
+}
+
+// GMKindRank returns the position of a Grey Matter config kind in apply order.
+// Unrecognized kinds are ranked after all known kinds.
+func GMKindRank(kind string) int {
+	for i, dep := range gmKindDependencies {
+		if dep.kind == kind {
+			return i
+		}
+	}
+	return len(gmKindDependencies)
+}
+
+// OrderGMConfigObjects returns copies of the given objects and their kinds (as identified by IdentifyGMConfigObjects),
+// sorted so each object comes after the kinds it depends on. If reverse is set, dependents come first instead,
+// for deleting objects without leaving dangling references. Objects of the same kind keep their relative order.
+func OrderGMConfigObjects(objects []json.RawMessage, kinds []string, reverse bool) ([]json.RawMessage, []string) {
+	indices := make([]int, len(kinds))
+	for i := range indices {
+		indices[i] = i
+	}
+	sort.SliceStable(indices, func(a, b int) bool {
+		rankA, rankB := GMKindRank(kinds[indices[a]]), GMKindRank(kinds[indices[b]])
+		if reverse {
+			return rankA > rankB
+		}
+		return rankA < rankB
+	})
+
+	orderedObjects := make([]json.RawMessage, len(indices))
+	orderedKinds := make([]string, len(indices))
+	for i, idx := range indices {
+		orderedObjects[i] = objects[idx]
+		orderedKinds[i] = kinds[idx]
+	}
+	return orderedObjects, orderedKinds
+}
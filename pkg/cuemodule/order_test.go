@@ -0,0 +1,39 @@
+package cuemodule
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGMKindDependencies(t *testing.T) {
+	for _, dep := range gmKindDependencies {
+		for _, d := range dep.dependsOn {
+			assert.Less(t, GMKindRank(d), GMKindRank(dep.kind), "%s must be applied before %s", d, dep.kind)
+		}
+	}
+}
+
+func TestOrderGMConfigObjects(t *testing.T) {
+	objects := []json.RawMessage{
+		json.RawMessage(`{"route_key":"r1"}`),
+		json.RawMessage(`{"catalog_service_id":"c1"}`),
+		json.RawMessage(`{"cluster_key":"cl1"}`),
+		json.RawMessage(`{"route_key":"r2"}`),
+		json.RawMessage(`{"domain_key":"d1"}`),
+		json.RawMessage(`{"zone_key":"z1"}`),
+	}
+	kinds := []string{"route", "catalogservice", "cluster", "route", "domain", "zone"}
+
+	orderedObjects, orderedKinds := OrderGMConfigObjects(objects, kinds, false)
+	assert.Equal(t, []string{"zone", "domain", "cluster", "route", "route", "catalogservice"}, orderedKinds)
+	assert.JSONEq(t, `{"route_key":"r1"}`, string(orderedObjects[3]))
+	assert.JSONEq(t, `{"route_key":"r2"}`, string(orderedObjects[4]))
+
+	_, reversedKinds := OrderGMConfigObjects(objects, kinds, true)
+	assert.Equal(t, []string{"catalogservice", "route", "route", "cluster", "domain", "zone"}, reversedKinds)
+
+	// The inputs are left alone
+	assert.Equal(t, "route", kinds[0])
+}
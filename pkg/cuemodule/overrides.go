@@ -0,0 +1,81 @@
+package cuemodule
+
+import (
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ComponentWorkloads maps each core component in v1alpha1.ComponentOverrides
+// to the name of the Deployment or StatefulSet extracted for it from the K8s CUE.
+var ComponentWorkloads = map[string]string{
+	"control": "controlensemble",
+	"catalog": "catalog",
+	"edge":    "edge",
+	"redis":   "greymatter-datastore",
+}
+
+// ApplyComponentOverrides replaces the CUE defaults of extracted core component workloads with
+// any values set in a Mesh's overrides. Resources apply to every container in the pod except
+// an injected sidecar, unless the sidecar is the pod's only container (as for edge).
+func ApplyComponentOverrides(manifestObjects []client.Object, overrides *v1alpha1.ComponentOverrides) {
+	if overrides == nil {
+		return
+	}
+	for _, obj := range manifestObjects {
+		override := WorkloadOverride(overrides, obj.GetName())
+		if override == nil {
+			continue
+		}
+		switch workload := obj.(type) {
+		case *appsv1.Deployment:
+			if override.Replicas != nil {
+				workload.Spec.Replicas = override.Replicas
+			}
+			applyPodOverride(&workload.Spec.Template.Spec, override)
+		case *appsv1.StatefulSet:
+			if override.Replicas != nil {
+				workload.Spec.Replicas = override.Replicas
+			}
+			applyPodOverride(&workload.Spec.Template.Spec, override)
+		}
+	}
+}
+
+// WorkloadOverride returns the override of the core component whose Deployment or StatefulSet has the given name,
+// if any.
+func WorkloadOverride(overrides *v1alpha1.ComponentOverrides, workload string) *v1alpha1.ComponentOverride {
+	if overrides == nil {
+		return nil
+	}
+	return map[string]*v1alpha1.ComponentOverride{
+		ComponentWorkloads["control"]: overrides.Control,
+		ComponentWorkloads["catalog"]: overrides.Catalog,
+		ComponentWorkloads["edge"]:    overrides.Edge,
+		ComponentWorkloads["redis"]:   overrides.Redis,
+	}[workload]
+}
+
+func applyPodOverride(pod *corev1.PodSpec, override *v1alpha1.ComponentOverride) {
+	if override.Resources != nil {
+		for i := range pod.Containers {
+			if pod.Containers[i].Name == "sidecar" && len(pod.Containers) > 1 {
+				continue
+			}
+			pod.Containers[i].Resources = *override.Resources.DeepCopy()
+		}
+	}
+	if override.NodeSelector != nil {
+		pod.NodeSelector = override.NodeSelector
+	}
+	if override.Tolerations != nil {
+		pod.Tolerations = override.Tolerations
+	}
+	if override.Affinity != nil {
+		pod.Affinity = override.Affinity.DeepCopy()
+	}
+	if override.PriorityClassName != "" {
+		pod.PriorityClassName = override.PriorityClassName
+	}
+}
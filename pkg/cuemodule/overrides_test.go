@@ -0,0 +1,65 @@
+package cuemodule
+
+import (
+	"testing"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestApplyComponentOverrides(t *testing.T) {
+	one, three := int32(1), int32(3)
+	catalog := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "catalog"},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &one,
+			Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "catalog"}, {Name: "sidecar"}},
+			}},
+		},
+	}
+	edge := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "edge"},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &one,
+			Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "sidecar"}},
+			}},
+		},
+	}
+	control := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "controlensemble"},
+		Spec:       appsv1.StatefulSetSpec{Replicas: &one},
+	}
+
+	resources := &corev1.ResourceRequirements{
+		Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1Gi")},
+	}
+	ApplyComponentOverrides([]client.Object{catalog, edge, control}, &v1alpha1.ComponentOverrides{
+		Catalog: &v1alpha1.ComponentOverride{
+			Replicas:          &three,
+			Resources:         resources,
+			NodeSelector:      map[string]string{"pool": "mesh"},
+			Tolerations:       []corev1.Toleration{{Key: "dedicated", Operator: corev1.TolerationOpExists}},
+			PriorityClassName: "mesh-critical",
+		},
+		Edge: &v1alpha1.ComponentOverride{Resources: resources},
+	})
+
+	assert.Equal(t, three, *catalog.Spec.Replicas)
+	assert.Equal(t, *resources, catalog.Spec.Template.Spec.Containers[0].Resources)
+	assert.Empty(t, catalog.Spec.Template.Spec.Containers[1].Resources.Limits, "sidecar should keep its defaults")
+	assert.Equal(t, map[string]string{"pool": "mesh"}, catalog.Spec.Template.Spec.NodeSelector)
+	assert.Len(t, catalog.Spec.Template.Spec.Tolerations, 1)
+	assert.Equal(t, "mesh-critical", catalog.Spec.Template.Spec.PriorityClassName)
+
+	assert.Equal(t, one, *edge.Spec.Replicas)
+	assert.Equal(t, *resources, edge.Spec.Template.Spec.Containers[0].Resources, "a lone sidecar container is the component")
+
+	assert.Equal(t, one, *control.Spec.Replicas)
+}
@@ -0,0 +1,184 @@
+package cuemodule
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Node labels a pod can select its nodes' OS and architecture with, including the beta labels older clusters use.
+var (
+	nodeOSLabels   = []string{corev1.LabelOSStable, "beta.kubernetes.io/os"}
+	nodeArchLabels = []string{corev1.LabelArchStable, "beta.kubernetes.io/arch"}
+)
+
+// PodPlatform returns the OS and architecture of the nodes a pod can be scheduled on, as pinned by its spec.os, its
+// nodeSelector, or its required node affinity; each is "" if the pod isn't pinned to a single one.
+func PodPlatform(spec corev1.PodSpec) (os, arch string) {
+	os = pinnedValue(spec, nodeOSLabels)
+	if os == "" && spec.OS != nil {
+		os = string(spec.OS.Name)
+	}
+	return os, pinnedValue(spec, nodeArchLabels)
+}
+
+// pinnedValue returns the single value a pod requires of any of the given node labels, or "" if there isn't one.
+func pinnedValue(spec corev1.PodSpec, labels []string) string {
+	for _, label := range labels {
+		if value := spec.NodeSelector[label]; value != "" {
+			return value
+		}
+	}
+	if spec.Affinity == nil || spec.Affinity.NodeAffinity == nil || spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return ""
+	}
+	// Terms are ORed, so every one of them must pin the same value
+	var pinned string
+	terms := spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	for _, term := range terms {
+		var value string
+		for _, expr := range term.MatchExpressions {
+			for _, label := range labels {
+				if expr.Key == label && expr.Operator == corev1.NodeSelectorOpIn && len(expr.Values) == 1 {
+					value = expr.Values[0]
+				}
+			}
+		}
+		if value == "" || (pinned != "" && value != pinned) {
+			return ""
+		}
+		pinned = value
+	}
+	return pinned
+}
+
+// PinToLinux adds a nodeSelector for Linux nodes to extracted core component workloads that don't already select an
+// OS, so they aren't scheduled on the Windows nodes of mixed-OS clusters.
+func PinToLinux(manifestObjects []client.Object) {
+	for _, obj := range manifestObjects {
+		switch workload := obj.(type) {
+		case *appsv1.Deployment:
+			PinPodToLinux(&workload.Spec.Template.Spec)
+		case *appsv1.StatefulSet:
+			PinPodToLinux(&workload.Spec.Template.Spec)
+		}
+	}
+}
+
+// PinPodToLinux adds a nodeSelector for Linux nodes to a pod spec that doesn't already select an OS.
+func PinPodToLinux(spec *corev1.PodSpec) {
+	if os, _ := PodPlatform(*spec); os != "" {
+		return
+	}
+	// The selector may be shared with a Mesh's overrides, so it's copied rather than changed in place
+	selector := map[string]string{corev1.LabelOSStable: "linux"}
+	for k, v := range spec.NodeSelector {
+		selector[k] = v
+	}
+	spec.NodeSelector = selector
+}
+
+// ApplyArchImages selects the builds of single-architecture images in extracted core component workloads, as
+// SelectArchImages does. Workloads whose images have no builds in common for the nodes are left as they are.
+func ApplyArchImages(manifestObjects []client.Object, archImages map[string]map[string]string, nodeArchs []string) {
+	for _, obj := range manifestObjects {
+		var spec *corev1.PodSpec
+		switch workload := obj.(type) {
+		case *appsv1.Deployment:
+			spec = &workload.Spec.Template.Spec
+		case *appsv1.StatefulSet:
+			spec = &workload.Spec.Template.Spec
+		default:
+			continue
+		}
+		if err := SelectArchImages(spec, archImages, nodeArchs); err != nil {
+			logger.Error(err, "Not selecting architecture-specific images", "Kind", obj.GetObjectKind().GroupVersionKind().Kind, "Name", obj.GetName())
+		}
+	}
+}
+
+// SelectArchImages replaces the images of a pod spec's containers that are built for a single architecture (those
+// in archImages) with their builds for the architecture the pod is pinned to, or if it isn't pinned to one, for the
+// first of the nodes' architectures that every such image is built for. When the nodes have more than one
+// architecture, an unpinned pod is then pinned to that one with required node affinity. It returns an error, and
+// leaves the pod as it is, if no such architecture is found.
+func SelectArchImages(spec *corev1.PodSpec, archImages map[string]map[string]string, nodeArchs []string) error {
+	var containers []*corev1.Container
+	for idx := range spec.InitContainers {
+		containers = append(containers, &spec.InitContainers[idx])
+	}
+	for idx := range spec.Containers {
+		containers = append(containers, &spec.Containers[idx])
+	}
+	var singleArch []*corev1.Container
+	for _, container := range containers {
+		if _, ok := archImages[container.Image]; ok {
+			singleArch = append(singleArch, container)
+		}
+	}
+	if len(singleArch) == 0 {
+		return nil
+	}
+
+	_, pinned := PodPlatform(*spec)
+	candidates := nodeArchs
+	if pinned != "" {
+		candidates = []string{pinned}
+	}
+	var arch string
+	for _, candidate := range candidates {
+		built := true
+		for _, container := range singleArch {
+			built = built && archImages[container.Image][candidate] != ""
+		}
+		if built {
+			arch = candidate
+			break
+		}
+	}
+	if arch == "" {
+		var images []string
+		for _, container := range singleArch {
+			images = append(images, container.Image)
+		}
+		sort.Strings(images)
+		return fmt.Errorf("none of the architectures %v has builds of %s", candidates, strings.Join(images, ", "))
+	}
+
+	for _, container := range singleArch {
+		container.Image = archImages[container.Image][arch]
+	}
+	if pinned == "" && len(nodeArchs) > 1 {
+		pinArch(spec, arch)
+	}
+	return nil
+}
+
+// pinArch requires the nodes a pod is scheduled on to have the given architecture, in every term of its required
+// node affinity, since they're ORed.
+func pinArch(spec *corev1.PodSpec, arch string) {
+	requirement := corev1.NodeSelectorRequirement{Key: corev1.LabelArchStable, Operator: corev1.NodeSelectorOpIn, Values: []string{arch}}
+	if spec.Affinity == nil {
+		spec.Affinity = &corev1.Affinity{}
+	} else {
+		// The affinity may be shared with a Mesh's overrides, so it's copied rather than changed in place
+		spec.Affinity = spec.Affinity.DeepCopy()
+	}
+	if spec.Affinity.NodeAffinity == nil {
+		spec.Affinity.NodeAffinity = &corev1.NodeAffinity{}
+	}
+	required := spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+	if required == nil || len(required.NodeSelectorTerms) == 0 {
+		spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution = &corev1.NodeSelector{
+			NodeSelectorTerms: []corev1.NodeSelectorTerm{{MatchExpressions: []corev1.NodeSelectorRequirement{requirement}}},
+		}
+		return
+	}
+	for idx := range required.NodeSelectorTerms {
+		required.NodeSelectorTerms[idx].MatchExpressions = append(required.NodeSelectorTerms[idx].MatchExpressions, requirement)
+	}
+}
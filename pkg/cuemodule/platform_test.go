@@ -0,0 +1,115 @@
+package cuemodule
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func requiredNodeAffinity(terms ...corev1.NodeSelectorTerm) *corev1.Affinity {
+	return &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{NodeSelectorTerms: terms},
+	}}
+}
+
+func TestPodPlatform(t *testing.T) {
+	in := func(key string, values ...string) corev1.NodeSelectorTerm {
+		return corev1.NodeSelectorTerm{MatchExpressions: []corev1.NodeSelectorRequirement{{Key: key, Operator: corev1.NodeSelectorOpIn, Values: values}}}
+	}
+	cases := map[string]struct {
+		spec     corev1.PodSpec
+		os, arch string
+	}{
+		"unpinned":           {},
+		"node selector":      {spec: corev1.PodSpec{NodeSelector: map[string]string{"kubernetes.io/os": "windows", "kubernetes.io/arch": "arm64"}}, os: "windows", arch: "arm64"},
+		"beta node selector": {spec: corev1.PodSpec{NodeSelector: map[string]string{"beta.kubernetes.io/os": "linux"}}, os: "linux"},
+		"spec.os":            {spec: corev1.PodSpec{OS: &corev1.PodOS{Name: corev1.Windows}}, os: "windows"},
+		"node affinity":      {spec: corev1.PodSpec{Affinity: requiredNodeAffinity(in("kubernetes.io/arch", "s390x"), in("kubernetes.io/arch", "s390x"))}, arch: "s390x"},
+		"either arch":        {spec: corev1.PodSpec{Affinity: requiredNodeAffinity(in("kubernetes.io/arch", "amd64", "arm64"))}},
+		"differing terms":    {spec: corev1.PodSpec{Affinity: requiredNodeAffinity(in("kubernetes.io/arch", "amd64"), in("kubernetes.io/arch", "arm64"))}},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			os, arch := PodPlatform(tc.spec)
+			assert.Equal(t, tc.os, os)
+			assert.Equal(t, tc.arch, arch)
+		})
+	}
+}
+
+func TestPinToLinux(t *testing.T) {
+	overridden := map[string]string{"pool": "core"}
+	catalog := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "catalog"},
+		Spec:       appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{NodeSelector: overridden}}},
+	}
+	edge := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "edge"},
+		Spec:       appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{OS: &corev1.PodOS{Name: corev1.Linux}}}},
+	}
+	control := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "controlensemble"}}
+
+	PinToLinux([]client.Object{catalog, edge, control, &corev1.Service{}})
+	assert.Equal(t, map[string]string{"pool": "core", "kubernetes.io/os": "linux"}, catalog.Spec.Template.Spec.NodeSelector)
+	// Without changing the selector it was given
+	assert.Equal(t, map[string]string{"pool": "core"}, overridden)
+	// Workloads that already choose their OS are left alone
+	assert.Nil(t, edge.Spec.Template.Spec.NodeSelector)
+	assert.Equal(t, map[string]string{"kubernetes.io/os": "linux"}, control.Spec.Template.Spec.NodeSelector)
+}
+
+func TestSelectArchImages(t *testing.T) {
+	archImages := map[string]map[string]string{
+		"greymatter/control:1.8": {"amd64": "greymatter/control:1.8", "arm64": "greymatter/control:1.8-arm64"},
+		"greymatter/proxy:1.8":   {"amd64": "greymatter/proxy:1.8-amd64"},
+	}
+	pod := func(images ...string) *corev1.PodSpec {
+		spec := &corev1.PodSpec{}
+		for _, image := range images {
+			spec.Containers = append(spec.Containers, corev1.Container{Image: image})
+		}
+		return spec
+	}
+
+	// Multi-arch images are left alone
+	spec := pod("redis:6")
+	assert.NoError(t, SelectArchImages(spec, archImages, []string{"arm64", "amd64"}))
+	assert.Equal(t, pod("redis:6"), spec)
+
+	// On a single-arch cluster, the build for its nodes is selected without pinning the pod
+	spec = pod("greymatter/control:1.8", "redis:6")
+	assert.NoError(t, SelectArchImages(spec, archImages, []string{"arm64"}))
+	assert.Equal(t, pod("greymatter/control:1.8-arm64", "redis:6"), spec)
+
+	// On a mixed cluster, the first architecture every image is built for is selected and pinned
+	spec = pod("greymatter/control:1.8", "greymatter/proxy:1.8")
+	assert.NoError(t, SelectArchImages(spec, archImages, []string{"arm64", "amd64"}))
+	assert.Equal(t, "greymatter/control:1.8", spec.Containers[0].Image)
+	assert.Equal(t, "greymatter/proxy:1.8-amd64", spec.Containers[1].Image)
+	_, arch := PodPlatform(*spec)
+	assert.Equal(t, "amd64", arch)
+
+	// The architecture a pod is pinned to is used, or none if an image isn't built for it
+	spec = pod("greymatter/control:1.8")
+	spec.NodeSelector = map[string]string{"kubernetes.io/arch": "arm64"}
+	assert.NoError(t, SelectArchImages(spec, archImages, []string{"amd64", "arm64"}))
+	assert.Equal(t, "greymatter/control:1.8-arm64", spec.Containers[0].Image)
+	spec = pod("greymatter/proxy:1.8")
+	spec.NodeSelector = map[string]string{"kubernetes.io/arch": "arm64"}
+	err := SelectArchImages(spec, archImages, []string{"amd64", "arm64"})
+	assert.Contains(t, fmt.Sprint(err), "none of the architectures [arm64] has builds of greymatter/proxy:1.8")
+	assert.Equal(t, "greymatter/proxy:1.8", spec.Containers[0].Image)
+
+	// Existing required node affinity terms each get the architecture
+	affinity := requiredNodeAffinity(corev1.NodeSelectorTerm{MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "pool", Operator: corev1.NodeSelectorOpExists}}})
+	spec = pod("greymatter/proxy:1.8")
+	spec.Affinity = affinity
+	assert.NoError(t, SelectArchImages(spec, archImages, []string{"amd64", "arm64"}))
+	assert.Len(t, spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].MatchExpressions, 2)
+	assert.Len(t, affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].MatchExpressions, 1)
+}
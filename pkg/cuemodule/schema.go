@@ -0,0 +1,119 @@
+package cuemodule
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	"cuelang.org/go/cue/errors"
+)
+
+// gmSchema defines the Grey Matter config objects accepted by Control for each release_version of the Mesh.
+//
+//go:embed gm_schema.cue
+var gmSchema string
+
+// gmSchemaVersions are the release versions in gm_schema.cue, oldest first, followed by latest.
+var gmSchemaVersions = []string{"1.6", "1.7", "latest"}
+
+// ValidateGMSchema checks the Grey Matter config objects (with kinds as identified by IdentifyGMConfigObjects)
+// against the schema of Control in the given release version (latest if unset), for fields it doesn't have, fields
+// of the wrong type, and missing required fields. Fields that only exist in a later release come with guidance to
+// upgrade to it.
+func ValidateGMSchema(releaseVersion string, objects []json.RawMessage, kinds []string) []GMConfigProblem {
+	ctx := cuecontext.New()
+	schema := ctx.CompileString(gmSchema)
+	if err := schema.Err(); err != nil {
+		// The embedded schema is tested, so this would be a bug
+		return []GMConfigProblem{{Problem: fmt.Sprintf("invalid Grey Matter schema: %v", err)}}
+	}
+	if releaseVersion == "" || !schema.LookupPath(cue.MakePath(cue.Str("versions"), cue.Str(releaseVersion))).Exists() {
+		releaseVersion = "latest"
+	}
+	kindSchema := func(version, kind string) cue.Value {
+		return schema.LookupPath(cue.MakePath(cue.Str("versions"), cue.Str(version), cue.Str(kind)))
+	}
+	// Versions after the target, in which an unknown field may have been added
+	var later []string
+	for idx, version := range gmSchemaVersions {
+		if version == releaseVersion {
+			later = gmSchemaVersions[idx+1:]
+		}
+	}
+
+	var problems []GMConfigProblem
+	for idx, kind := range kinds {
+		target := kindSchema(releaseVersion, kind)
+		if !target.Exists() {
+			continue
+		}
+		var ids gmObject
+		_ = json.Unmarshal(objects[idx], &ids)
+		problem := func(format string, args ...interface{}) {
+			scope := ids.ZoneKey
+			if kind == "catalogservice" {
+				scope = ids.MeshID
+			}
+			problems = append(problems, GMConfigProblem{Kind: kind, Zone: scope, Key: ids.key(kind), Problem: fmt.Sprintf(format, args...)})
+		}
+		obj := ctx.CompileBytes(objects[idx])
+		if obj.Err() != nil {
+			problem("invalid object: %v", obj.Err())
+			continue
+		}
+
+		// Fields Control doesn't have
+		fields, _ := obj.Fields()
+		for fields.Next() {
+			field := fields.Label()
+			if target.Allows(cue.Str(field)) {
+				continue
+			}
+			since := ""
+			for _, version := range later {
+				if kindSchema(version, kind).Allows(cue.Str(field)) {
+					since = version
+					break
+				}
+			}
+			if since != "" && since != "latest" {
+				problem("field %q requires Grey Matter %s, but the Mesh's release_version is %s; upgrade Control and set spec.release_version to %q, or remove the field",
+					field, since, releaseVersion, since)
+			} else {
+				problem("field %q doesn't exist in Grey Matter %s", field, releaseVersion)
+			}
+		}
+
+		// Missing required fields
+		required, _ := target.Fields()
+		for required.Next() {
+			if !obj.LookupPath(cue.MakePath(cue.Str(required.Label()))).Exists() {
+				problem("required field %q is missing", required.Label())
+			}
+		}
+
+		// Fields of the wrong type
+		var mismatched []string
+		for _, e := range errors.Errors(target.Unify(obj).Validate()) {
+			format, args := e.Msg()
+			if strings.HasPrefix(format, "field not allowed") {
+				continue
+			}
+			// Paths are relative to the schema
+			path := e.Path()
+			if len(path) > 3 {
+				path = path[3:]
+			}
+			mismatched = append(mismatched, fmt.Sprintf("%s: %s", strings.Join(path, "."), fmt.Sprintf(format, args...)))
+		}
+		sort.Strings(mismatched)
+		for _, m := range mismatched {
+			problem("%s", m)
+		}
+	}
+	return problems
+}
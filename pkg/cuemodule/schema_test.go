@@ -0,0 +1,45 @@
+package cuemodule
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateGMSchema(t *testing.T) {
+	configs := []json.RawMessage{
+		json.RawMessage(`{"zone_key":"default-zone","name":"default-zone"}`),
+		json.RawMessage(`{"cluster_key":"catalog","zone_key":"default-zone","name":"catalog","dns_type":"strict_dns","instances":[{"host":"catalog","port":8080}]}`),
+		json.RawMessage(`{"listener_key":"edge","zone_key":"default-zone","port":"10808","max_connections":100}`),
+		json.RawMessage(`{"service_id":"catalog","mesh_id":"greymatter-mesh","enable_instance_metrics":true}`),
+	}
+	kinds := IdentifyGMConfigObjects(configs)
+	validate := func(version string) []string {
+		var problems []string
+		for _, p := range ValidateGMSchema(version, configs, kinds) {
+			problems = append(problems, p.String())
+		}
+		return problems
+	}
+
+	assert.Equal(t, []string{
+		`cluster default-zone/catalog: field "dns_type" requires Grey Matter 1.7, but the Mesh's release_version is 1.6; upgrade Control and set spec.release_version to "1.7", or remove the field`,
+		`listener default-zone/edge: field "max_connections" doesn't exist in Grey Matter 1.6`,
+		`listener default-zone/edge: port: conflicting values int and "10808" (mismatched types int and string)`,
+		`catalogservice greymatter-mesh/catalog: field "enable_instance_metrics" requires Grey Matter 1.7, but the Mesh's release_version is 1.6; upgrade Control and set spec.release_version to "1.7", or remove the field`,
+		`catalogservice greymatter-mesh/catalog: required field "name" is missing`,
+	}, validate("1.6"))
+
+	assert.Equal(t, []string{
+		`listener default-zone/edge: field "max_connections" doesn't exist in Grey Matter 1.7`,
+		`listener default-zone/edge: port: conflicting values int and "10808" (mismatched types int and string)`,
+		`catalogservice greymatter-mesh/catalog: required field "name" is missing`,
+	}, validate("1.7"))
+
+	// The latest Control may have fields the operator doesn't know
+	assert.Equal(t, []string{
+		`listener default-zone/edge: port: conflicting values int and "10808" (mismatched types int and string)`,
+		`catalogservice greymatter-mesh/catalog: required field "name" is missing`,
+	}, validate(""))
+}
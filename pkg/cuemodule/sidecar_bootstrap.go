@@ -0,0 +1,80 @@
+package cuemodule
+
+import (
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// The sidecar's envoy flag whose YAML is merged over its bootstrap config.
+const configYAMLFlag = "--config-yaml"
+
+// MergeBootstrapOverride merges an envoy bootstrap config fragment (YAML or JSON), e.g. for custom tracing or access
+// log sinks, into the sidecar container's --config-yaml flag, which envoy merges over the bootstrap config it's
+// generated with. Objects are merged with any fragment the sidecar CUE already passes; other values are replaced.
+func MergeBootstrapOverride(container *corev1.Container, override string) error {
+	overrideConfig, err := parseBootstrapConfig(override)
+	if err != nil {
+		return fmt.Errorf("invalid envoy bootstrap override: %w", err)
+	}
+
+	flagIdx := -1
+	for idx, arg := range container.Args {
+		if arg == configYAMLFlag && idx+1 < len(container.Args) {
+			flagIdx = idx
+		}
+	}
+	merged := overrideConfig
+	if flagIdx >= 0 {
+		base, err := parseBootstrapConfig(container.Args[flagIdx+1])
+		if err != nil {
+			return fmt.Errorf("invalid %s of the sidecar container: %w", configYAMLFlag, err)
+		}
+		merged = mergeBootstrapConfig(base, overrideConfig)
+	}
+
+	// JSON is YAML, and keeps the flag on one line
+	rendered, err := json.Marshal(merged)
+	if err != nil {
+		return err
+	}
+	args := append([]string{}, container.Args...)
+	if flagIdx >= 0 {
+		args[flagIdx+1] = string(rendered)
+	} else {
+		args = append(args, configYAMLFlag, string(rendered))
+	}
+	container.Args = args
+	return nil
+}
+
+func parseBootstrapConfig(config string) (map[string]interface{}, error) {
+	var parsed map[string]interface{}
+	if err := yaml.Unmarshal([]byte(config), &parsed); err != nil {
+		return nil, err
+	}
+	if parsed == nil {
+		return nil, fmt.Errorf("expected an object of bootstrap fields")
+	}
+	return parsed, nil
+}
+
+// mergeBootstrapConfig returns base with the fields of override merged over it, recursively for objects.
+func mergeBootstrapConfig(base, override map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(base))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range override {
+		baseObj, baseIsObj := merged[k].(map[string]interface{})
+		overrideObj, overrideIsObj := v.(map[string]interface{})
+		if baseIsObj && overrideIsObj {
+			merged[k] = mergeBootstrapConfig(baseObj, overrideObj)
+		} else {
+			merged[k] = v
+		}
+	}
+	return merged
+}
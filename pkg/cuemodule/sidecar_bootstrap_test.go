@@ -0,0 +1,25 @@
+package cuemodule
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestMergeBootstrapOverride(t *testing.T) {
+	sidecar := corev1.Container{Name: "sidecar", Args: []string{"--log-level", "info"}}
+	assert.NoError(t, MergeBootstrapOverride(&sidecar, "tracing:\n  http:\n    name: envoy.tracers.zipkin\n"))
+	assert.Equal(t, []string{"--log-level", "info", "--config-yaml", `{"tracing":{"http":{"name":"envoy.tracers.zipkin"}}}`}, sidecar.Args)
+
+	// Merged with the fragment already passed, rather than passed twice
+	assert.NoError(t, MergeBootstrapOverride(&sidecar, `{"tracing": {"http": {"typed_config": {"collector_cluster": "zipkin"}}}, "stats_flush_interval": "10s"}`))
+	assert.Equal(t, []string{"--log-level", "info", "--config-yaml",
+		`{"stats_flush_interval":"10s","tracing":{"http":{"name":"envoy.tracers.zipkin","typed_config":{"collector_cluster":"zipkin"}}}}`}, sidecar.Args)
+
+	for _, invalid := range []string{"", "- a list", "tracing: ["} {
+		unchanged := sidecar
+		assert.Error(t, MergeBootstrapOverride(&unchanged, invalid), invalid)
+		assert.Equal(t, sidecar.Args, unchanged.Args)
+	}
+}
@@ -0,0 +1,86 @@
+package cuemodule
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// CatalogEntry is how a workload describes itself in Catalog, from its catalog annotations. Empty fields are left as
+// the sidecar CUE sets them.
+type CatalogEntry struct {
+	Name          string
+	Description   string
+	Owner         string
+	Documentation string
+	APIEndpoint   string
+	Version       string
+	// URL of the service's OpenAPI (or Swagger) spec
+	APISpecEndpoint string
+}
+
+// fields returns the catalogservice fields the entry sets.
+func (e CatalogEntry) fields() map[string]string {
+	fields := make(map[string]string)
+	for field, value := range map[string]string{
+		"name":              e.Name,
+		"description":       e.Description,
+		"owner":             e.Owner,
+		"documentation":     e.Documentation,
+		"api_endpoint":      e.APIEndpoint,
+		"version":           e.Version,
+		"api_spec_endpoint": e.APISpecEndpoint,
+	} {
+		if value != "" {
+			fields[field] = value
+		}
+	}
+	return fields
+}
+
+// ApplyCatalogEntry sets the fields of the named workload's catalogservice among its sidecar's Grey Matter config
+// objects from its catalog entry, adding a catalogservice in the given mesh if the sidecar CUE has none.
+func ApplyCatalogEntry(workload, meshID string, entry CatalogEntry, configs []json.RawMessage, kinds []string) ([]json.RawMessage, []string, error) {
+	fields := entry.fields()
+	if len(fields) == 0 {
+		return configs, kinds, nil
+	}
+
+	configs = append([]json.RawMessage{}, configs...)
+	kinds = append([]string{}, kinds...)
+	serviceIdx := -1
+	for idx, kind := range kinds {
+		if kind != "catalogservice" {
+			continue
+		}
+		var obj gmObject
+		if json.Unmarshal(configs[idx], &obj) == nil && obj.ServiceID == workload {
+			serviceIdx = idx
+			break
+		}
+	}
+	service := make(map[string]interface{})
+	if serviceIdx >= 0 {
+		if err := json.Unmarshal(configs[serviceIdx], &service); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse catalogservice %q: %w", workload, err)
+		}
+	} else {
+		if meshID == "" {
+			return nil, nil, fmt.Errorf("no catalogservice %q in the sidecar config, and no mesh to add one to", workload)
+		}
+		service["service_id"] = workload
+		service["mesh_id"] = meshID
+		service["name"] = workload
+		configs = append(configs, nil)
+		kinds = append(kinds, "catalogservice")
+		serviceIdx = len(configs) - 1
+	}
+	for field, value := range fields {
+		service[field] = value
+	}
+	patched, err := json.Marshal(service)
+	if err != nil {
+		return nil, nil, err
+	}
+	configs[serviceIdx] = patched
+	return configs, kinds, nil
+}
@@ -0,0 +1,46 @@
+package cuemodule
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestApplyCatalogEntry(t *testing.T) {
+	configs := []json.RawMessage{
+		json.RawMessage(`{"cluster_key":"example","zone_key":"default-zone"}`),
+		json.RawMessage(`{"service_id":"example","mesh_id":"mesh-sample","name":"example","owner":"Grey Matter","capability":"Apps"}`),
+	}
+	kinds := IdentifyGMConfigObjects(configs)
+
+	same, sameKinds, err := ApplyCatalogEntry("example", "mesh-sample", CatalogEntry{}, configs, kinds)
+	assert.NoError(t, err)
+	assert.Equal(t, configs, same)
+	assert.Equal(t, kinds, sameKinds)
+
+	entry := CatalogEntry{Name: "Example", Owner: "Payments", Documentation: "https://docs.example.com", APIEndpoint: "/services/example/",
+		Version: "1.2.0", APISpecEndpoint: "https://example.com/openapi.json"}
+	applied, appliedKinds, err := ApplyCatalogEntry("example", "mesh-sample", entry, configs, kinds)
+	assert.NoError(t, err)
+	assert.Equal(t, kinds, appliedKinds)
+	service := gjson.ParseBytes(applied[1])
+	assert.Equal(t, "Example", service.Get("name").String())
+	assert.Equal(t, "Payments", service.Get("owner").String())
+	assert.Equal(t, "https://docs.example.com", service.Get("documentation").String())
+	assert.Equal(t, "/services/example/", service.Get("api_endpoint").String())
+	assert.Equal(t, "1.2.0", service.Get("version").String())
+	assert.Equal(t, "https://example.com/openapi.json", service.Get("api_spec_endpoint").String())
+	assert.Equal(t, "Apps", service.Get("capability").String(), "fields without annotations are left as they were")
+	assert.Contains(t, string(configs[1]), `"owner":"Grey Matter"`, "the given objects are unchanged")
+
+	// A sidecar without a catalogservice gets one
+	added, addedKinds, err := ApplyCatalogEntry("example", "mesh-sample", CatalogEntry{Description: "An example"}, configs[:1], kinds[:1])
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"cluster", "catalogservice"}, addedKinds)
+	assert.JSONEq(t, `{"service_id":"example","mesh_id":"mesh-sample","name":"example","description":"An example"}`, string(added[1]))
+
+	_, _, err = ApplyCatalogEntry("example", "", CatalogEntry{Description: "An example"}, configs[:1], kinds[:1])
+	assert.Error(t, err)
+}
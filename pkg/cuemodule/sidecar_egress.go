@@ -0,0 +1,228 @@
+package cuemodule
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultSidecarEgressPort is the port of the sidecar listener that external dependencies are reached through, which
+// is also where outbound traffic is captured to by traffic redirection.
+const DefaultSidecarEgressPort = 10909
+
+// EgressTarget is an external host a workload's sidecar routes to.
+type EgressTarget struct {
+	Host string
+	Port int
+	TLS  bool
+}
+
+// ParseEgressTargets parses a comma-separated list of external dependencies, each an http:// or https:// URL or a
+// host:port reached without TLS. Duplicates are dropped, and the targets are returned sorted by host and port.
+func ParseEgressTargets(value string) ([]EgressTarget, error) {
+	seen := make(map[EgressTarget]bool)
+	var targets []EgressTarget
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		target, err := parseEgressTarget(entry)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[target] {
+			seen[target] = true
+			targets = append(targets, target)
+		}
+	}
+	sort.Slice(targets, func(a, b int) bool {
+		if targets[a].Host != targets[b].Host {
+			return targets[a].Host < targets[b].Host
+		}
+		return targets[a].Port < targets[b].Port
+	})
+	return targets, nil
+}
+
+func parseEgressTarget(entry string) (EgressTarget, error) {
+	if strings.Contains(entry, "://") {
+		u, err := url.Parse(entry)
+		if err != nil || u.Hostname() == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			return EgressTarget{}, fmt.Errorf("invalid egress %q: expected an http or https URL", entry)
+		}
+		if u.Path != "" && u.Path != "/" {
+			return EgressTarget{}, fmt.Errorf("invalid egress %q: paths aren't supported", entry)
+		}
+		target := EgressTarget{Host: strings.ToLower(u.Hostname()), Port: 80, TLS: u.Scheme == "https"}
+		if target.TLS {
+			target.Port = 443
+		}
+		if u.Port() != "" {
+			port, err := strconv.Atoi(u.Port())
+			if err != nil || port <= 0 || port > 65535 {
+				return EgressTarget{}, fmt.Errorf("invalid egress %q: bad port", entry)
+			}
+			target.Port = port
+		}
+		return target, nil
+	}
+	host, portStr, err := net.SplitHostPort(entry)
+	if err != nil || host == "" {
+		return EgressTarget{}, fmt.Errorf("invalid egress %q: expected a URL or host:port", entry)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return EgressTarget{}, fmt.Errorf("invalid egress %q: bad port", entry)
+	}
+	return EgressTarget{Host: strings.ToLower(host), Port: port}, nil
+}
+
+// key returns the key of the Grey Matter config objects routing a workload's sidecar to the target.
+func (t EgressTarget) key(workload string) string {
+	return fmt.Sprintf("%s-egress-%s-%d", workload, strings.ReplaceAll(t.Host, ".", "-"), t.Port)
+}
+
+// AddSidecarEgress adds a domain, route, and cluster for each external dependency of the named workload to its
+// sidecar's Grey Matter config objects. Each domain matches the target's host, so requests are routed by their Host
+// header, and is served by the sidecar's listener on the egress port, which is added to its proxy if it has none.
+func AddSidecarEgress(workload string, targets []EgressTarget, egressPort int, configs []json.RawMessage, kinds []string) ([]json.RawMessage, []string, error) {
+	if len(targets) == 0 {
+		return configs, kinds, nil
+	}
+	if egressPort == 0 {
+		egressPort = DefaultSidecarEgressPort
+	}
+
+	// The sidecar's proxy, and any listener it already has on the egress port
+	proxyIdx, listenerIdx := -1, -1
+	var proxy gmObject
+	for idx, kind := range kinds {
+		if kind != "proxy" {
+			continue
+		}
+		var obj gmObject
+		if json.Unmarshal(configs[idx], &obj) == nil && obj.ProxyKey == workload {
+			proxyIdx, proxy = idx, obj
+			break
+		}
+	}
+	if proxyIdx < 0 {
+		return nil, nil, fmt.Errorf("no proxy %q in the sidecar config to add egress to", workload)
+	}
+	zone := proxy.ZoneKey
+	for idx, kind := range kinds {
+		if kind != "listener" {
+			continue
+		}
+		var obj gmObject
+		if json.Unmarshal(configs[idx], &obj) != nil || obj.ZoneKey != zone || obj.Port != egressPort {
+			continue
+		}
+		for _, key := range proxy.ListenerKeys {
+			if key == obj.ListenerKey {
+				listenerIdx = idx
+			}
+		}
+	}
+
+	configs = append([]json.RawMessage{}, configs...)
+	kinds = append([]string{}, kinds...)
+	add := func(kind string, obj map[string]interface{}) error {
+		raw, err := json.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		configs = append(configs, raw)
+		kinds = append(kinds, kind)
+		return nil
+	}
+
+	var domainKeys []string
+	for _, target := range targets {
+		key := target.key(workload)
+		domainKeys = append(domainKeys, key)
+		if err := add("cluster", map[string]interface{}{
+			"cluster_key": key,
+			"zone_key":    zone,
+			"name":        key,
+			"instances":   []interface{}{map[string]interface{}{"host": target.Host, "port": target.Port}},
+			"require_tls": target.TLS,
+		}); err != nil {
+			return nil, nil, err
+		}
+		if err := add("domain", map[string]interface{}{
+			"domain_key": key,
+			"zone_key":   zone,
+			"name":       target.Host,
+			"port":       egressPort,
+		}); err != nil {
+			return nil, nil, err
+		}
+		if err := add("route", map[string]interface{}{
+			"route_key":   key,
+			"domain_key":  key,
+			"zone_key":    zone,
+			"route_match": map[string]interface{}{"path": "/", "match_type": "prefix"},
+			"rules": []interface{}{map[string]interface{}{
+				"constraints": map[string]interface{}{
+					"light": []interface{}{map[string]interface{}{"cluster_key": key, "weight": 1}},
+				},
+			}},
+		}); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if listenerIdx >= 0 {
+		// Serve the domains on the sidecar's existing egress listener
+		if err := patchKeys(configs, listenerIdx, "domain_keys", domainKeys...); err != nil {
+			return nil, nil, fmt.Errorf("failed to patch sidecar egress listener: %w", err)
+		}
+	} else {
+		listenerKey := workload + "-egress"
+		if err := add("listener", map[string]interface{}{
+			"listener_key":        listenerKey,
+			"zone_key":            zone,
+			"name":                listenerKey,
+			"ip":                  "0.0.0.0",
+			"port":                egressPort,
+			"protocol":            "http_auto",
+			"domain_keys":         domainKeys,
+			"active_http_filters": []string{},
+			"http_filters":        map[string]interface{}{},
+		}); err != nil {
+			return nil, nil, err
+		}
+		if err := patchKeys(configs, proxyIdx, "listener_keys", listenerKey); err != nil {
+			return nil, nil, fmt.Errorf("failed to patch sidecar proxy %q: %w", workload, err)
+		}
+	}
+	if err := patchKeys(configs, proxyIdx, "domain_keys", domainKeys...); err != nil {
+		return nil, nil, fmt.Errorf("failed to patch sidecar proxy %q: %w", workload, err)
+	}
+	return configs, kinds, nil
+}
+
+// patchKeys appends keys to a list field of one of the Grey Matter config objects, in place.
+func patchKeys(configs []json.RawMessage, idx int, field string, keys ...string) error {
+	var obj map[string]interface{}
+	if err := json.Unmarshal(configs[idx], &obj); err != nil {
+		return err
+	}
+	existing, _ := obj[field].([]interface{})
+	for _, key := range keys {
+		existing = append(existing, key)
+	}
+	obj[field] = existing
+	patched, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	configs[idx] = patched
+	return nil
+}
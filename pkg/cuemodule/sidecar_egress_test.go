@@ -0,0 +1,68 @@
+package cuemodule
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestParseEgressTargets(t *testing.T) {
+	targets, err := ParseEgressTargets("https://API.Stripe.com, http://metadata.internal:8080,db.example.com:5432,,https://api.stripe.com/")
+	assert.NoError(t, err)
+	assert.Equal(t, []EgressTarget{
+		{Host: "api.stripe.com", Port: 443, TLS: true},
+		{Host: "db.example.com", Port: 5432},
+		{Host: "metadata.internal", Port: 8080},
+	}, targets)
+
+	for _, invalid := range []string{"ftp://example.com", "example.com", "https://example.com/v1", "example.com:http", "https://example.com:99999"} {
+		_, err := ParseEgressTargets(invalid)
+		assert.Contains(t, fmt.Sprint(err), "invalid egress", invalid)
+	}
+}
+
+func TestAddSidecarEgress(t *testing.T) {
+	configs := []json.RawMessage{
+		json.RawMessage(`{"proxy_key":"example","zone_key":"default-zone","name":"example","domain_keys":["example"],"listener_keys":["example"]}`),
+		json.RawMessage(`{"listener_key":"example","zone_key":"default-zone","port":10808,"domain_keys":["example"]}`),
+		json.RawMessage(`{"domain_key":"example","zone_key":"default-zone","name":"*","port":10808}`),
+	}
+	kinds := IdentifyGMConfigObjects(configs)
+	targets := []EgressTarget{{Host: "api.stripe.com", Port: 443, TLS: true}, {Host: "db.example.com", Port: 5432}}
+
+	same, sameKinds, err := AddSidecarEgress("example", nil, 0, configs, kinds)
+	assert.NoError(t, err)
+	assert.Equal(t, configs, same)
+	assert.Equal(t, kinds, sameKinds)
+
+	added, addedKinds, err := AddSidecarEgress("example", targets, 0, configs, kinds)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"proxy", "listener", "domain", "cluster", "domain", "route", "cluster", "domain", "route", "listener"}, addedKinds)
+	assert.Empty(t, AuditGMConfigObjects(added, addedKinds))
+
+	proxy := gjson.ParseBytes(added[0])
+	assert.Equal(t, `["example","example-egress"]`, proxy.Get("listener_keys").Raw)
+	assert.Equal(t, `["example","example-egress-api-stripe-com-443","example-egress-db-example-com-5432"]`, proxy.Get("domain_keys").Raw)
+	listener := gjson.ParseBytes(added[9])
+	assert.Equal(t, int64(DefaultSidecarEgressPort), listener.Get("port").Int())
+	assert.Equal(t, `["example-egress-api-stripe-com-443","example-egress-db-example-com-5432"]`, listener.Get("domain_keys").Raw)
+	cluster := gjson.ParseBytes(added[3])
+	assert.Equal(t, "api.stripe.com", cluster.Get("instances.0.host").String())
+	assert.True(t, cluster.Get("require_tls").Bool())
+	assert.Equal(t, "api.stripe.com", gjson.GetBytes(added[4], "name").String())
+	assert.Equal(t, "example-egress-api-stripe-com-443", gjson.GetBytes(added[5], "rules.0.constraints.light.0.cluster_key").String())
+	assert.False(t, gjson.GetBytes(added[6], "require_tls").Bool())
+
+	// The sidecar's own listener on the egress port serves the domains instead
+	added, addedKinds, err = AddSidecarEgress("example", targets, 10808, configs, kinds)
+	assert.NoError(t, err)
+	assert.Len(t, addedKinds, 9)
+	assert.Equal(t, `["example","example-egress-api-stripe-com-443","example-egress-db-example-com-5432"]`, gjson.GetBytes(added[1], "domain_keys").Raw)
+	assert.Equal(t, `["example"]`, gjson.GetBytes(added[0], "listener_keys").Raw)
+
+	_, _, err = AddSidecarEgress("other", targets, 0, configs, kinds)
+	assert.Error(t, err)
+}
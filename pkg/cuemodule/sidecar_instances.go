@@ -0,0 +1,34 @@
+package cuemodule
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// SetClusterInstances sets the instances of the cluster with the given key among a sidecar's Grey Matter config
+// objects to a single host and port, for a workload whose pods Control doesn't discover, e.g. because they're outside
+// of the mesh's watched namespaces.
+func SetClusterInstances(clusterKey, host string, port int, configs []json.RawMessage, kinds []string) ([]json.RawMessage, error) {
+	for idx, kind := range kinds {
+		if kind != "cluster" {
+			continue
+		}
+		var obj gmObject
+		if json.Unmarshal(configs[idx], &obj) != nil || obj.ClusterKey != clusterKey {
+			continue
+		}
+		var cluster map[string]interface{}
+		if err := json.Unmarshal(configs[idx], &cluster); err != nil {
+			return nil, fmt.Errorf("failed to parse cluster %q: %w", clusterKey, err)
+		}
+		cluster["instances"] = []interface{}{map[string]interface{}{"host": host, "port": port}}
+		patched, err := json.Marshal(cluster)
+		if err != nil {
+			return nil, err
+		}
+		configs = append([]json.RawMessage{}, configs...)
+		configs[idx] = patched
+		return configs, nil
+	}
+	return nil, fmt.Errorf("no cluster %q in the sidecar config to set instances of", clusterKey)
+}
@@ -0,0 +1,28 @@
+package cuemodule
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestSetClusterInstances(t *testing.T) {
+	configs := []json.RawMessage{
+		json.RawMessage(`{"cluster_key":"example-local","zone_key":"default-zone","instances":[{"host":"127.0.0.1","port":9090}]}`),
+		json.RawMessage(`{"cluster_key":"example","zone_key":"default-zone","require_tls":true}`),
+	}
+	kinds := IdentifyGMConfigObjects(configs)
+
+	set, err := SetClusterInstances("example", "example.apps.svc", 10808, configs, kinds)
+	assert.NoError(t, err)
+	assert.Equal(t, `[{"host":"example.apps.svc","port":10808}]`, gjson.GetBytes(set[1], "instances").Raw)
+	assert.True(t, gjson.GetBytes(set[1], "require_tls").Bool())
+	assert.Equal(t, configs[0], set[0])
+	// The given objects are left as they were
+	assert.False(t, gjson.GetBytes(configs[1], "instances").Exists())
+
+	_, err = SetClusterInstances("other", "other.apps.svc", 10808, configs, kinds)
+	assert.Error(t, err)
+}
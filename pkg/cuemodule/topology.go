@@ -0,0 +1,210 @@
+package cuemodule
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Topology describes the mesh's structure as its Grey Matter config defines it, for dashboards and CMDBs: the
+// services (proxies) in it, the clusters they route to, their routes, and the edges between services that follow.
+type Topology struct {
+	// The commit of the config it was built from, if known
+	Commit   string            `json:"commit,omitempty"`
+	Services []TopologyService `json:"services"`
+	Clusters []TopologyCluster `json:"clusters"`
+	Routes   []TopologyRoute   `json:"routes"`
+	Edges    []TopologyEdge    `json:"edges"`
+}
+
+// TopologyService is a proxy, with what its catalog entry says about it, if it has one.
+type TopologyService struct {
+	Name    string   `json:"name"`
+	Zone    string   `json:"zone"`
+	Domains []string `json:"domains,omitempty"`
+	// The ports of its listeners
+	Ports       []int  `json:"ports,omitempty"`
+	DisplayName string `json:"display_name,omitempty"`
+	Description string `json:"description,omitempty"`
+	Owner       string `json:"owner,omitempty"`
+	Version     string `json:"version,omitempty"`
+}
+
+// TopologyCluster is a cluster, which leads to the service it's named for.
+type TopologyCluster struct {
+	Key  string `json:"key"`
+	Zone string `json:"zone"`
+	Name string `json:"name"`
+	// Each "host:port"; empty if they're discovered by Control
+	Instances []string `json:"instances,omitempty"`
+}
+
+// TopologyRoute is a route of a domain to the clusters of its rules.
+type TopologyRoute struct {
+	Key      string   `json:"key"`
+	Zone     string   `json:"zone"`
+	Domain   string   `json:"domain"`
+	Path     string   `json:"path,omitempty"`
+	Clusters []string `json:"clusters"`
+}
+
+// TopologyEdge is traffic a service routes to a cluster, and so to the service the cluster leads to.
+type TopologyEdge struct {
+	// The service's name
+	From string `json:"from"`
+	// The cluster's name
+	To      string `json:"to"`
+	Zone    string `json:"zone"`
+	Cluster string `json:"cluster"`
+	Route   string `json:"route"`
+	// Dark (mirrored) and tap traffic is "dark" or "tap"
+	Traffic string `json:"traffic"`
+	Weight  int    `json:"weight,omitempty"`
+}
+
+// topologyObject has the fields of Grey Matter config objects a Topology is built from.
+type topologyObject struct {
+	ZoneKey      string   `json:"zone_key"`
+	ProxyKey     string   `json:"proxy_key"`
+	ListenerKey  string   `json:"listener_key"`
+	ClusterKey   string   `json:"cluster_key"`
+	RouteKey     string   `json:"route_key"`
+	DomainKey    string   `json:"domain_key"`
+	ServiceID    string   `json:"service_id"`
+	Name         string   `json:"name"`
+	Port         int      `json:"port"`
+	ListenerKeys []string `json:"listener_keys"`
+	DomainKeys   []string `json:"domain_keys"`
+	Instances    []struct {
+		Host string `json:"host"`
+		Port int    `json:"port"`
+	} `json:"instances"`
+	Path       string `json:"path"`
+	RouteMatch struct {
+		Path string `json:"path"`
+	} `json:"route_match"`
+	Rules []struct {
+		Constraints map[string][]struct {
+			ClusterKey string `json:"cluster_key"`
+			Weight     int    `json:"weight"`
+		} `json:"constraints"`
+	} `json:"rules"`
+	Description string `json:"description"`
+	Owner       string `json:"owner"`
+	Version     string `json:"version"`
+}
+
+// BuildTopology returns the Topology of the Grey Matter config objects (with kinds as identified by
+// IdentifyGMConfigObjects), sorted by zone and key. Objects that can't be parsed are left out.
+func BuildTopology(objects []json.RawMessage, kinds []string) Topology {
+	byKind := make(map[string][]topologyObject)
+	for idx, kind := range kinds {
+		var obj topologyObject
+		if kind == "" || json.Unmarshal(objects[idx], &obj) != nil {
+			continue
+		}
+		byKind[kind] = append(byKind[kind], obj)
+	}
+	id := func(zone, key string) string { return zone + "/" + key }
+
+	topology := Topology{Services: []TopologyService{}, Clusters: []TopologyCluster{}, Routes: []TopologyRoute{}, Edges: []TopologyEdge{}}
+	catalog := make(map[string]topologyObject)
+	for _, entry := range byKind["catalogservice"] {
+		catalog[entry.ServiceID] = entry
+	}
+	listenerPorts := make(map[string]int)
+	listenerDomains := make(map[string][]string)
+	for _, listener := range byKind["listener"] {
+		listenerPorts[id(listener.ZoneKey, listener.ListenerKey)] = listener.Port
+		listenerDomains[id(listener.ZoneKey, listener.ListenerKey)] = listener.DomainKeys
+	}
+	clusterNames := make(map[string]string)
+	for _, cluster := range byKind["cluster"] {
+		clusterNames[id(cluster.ZoneKey, cluster.ClusterKey)] = cluster.Name
+		var instances []string
+		for _, instance := range cluster.Instances {
+			instances = append(instances, fmt.Sprintf("%s:%d", instance.Host, instance.Port))
+		}
+		topology.Clusters = append(topology.Clusters, TopologyCluster{Key: cluster.ClusterKey, Zone: cluster.ZoneKey, Name: cluster.Name, Instances: instances})
+	}
+
+	// Each domain is served by the proxies that list it, themselves or on their listeners
+	domainServices := make(map[string][]string)
+	for _, proxy := range byKind["proxy"] {
+		service := TopologyService{Name: proxy.Name, Zone: proxy.ZoneKey}
+		if service.Name == "" {
+			service.Name = proxy.ProxyKey
+		}
+		domains := map[string]bool{}
+		for _, domain := range proxy.DomainKeys {
+			domains[domain] = true
+		}
+		for _, listener := range proxy.ListenerKeys {
+			if port, ok := listenerPorts[id(proxy.ZoneKey, listener)]; ok {
+				service.Ports = append(service.Ports, port)
+			}
+			for _, domain := range listenerDomains[id(proxy.ZoneKey, listener)] {
+				domains[domain] = true
+			}
+		}
+		for domain := range domains {
+			service.Domains = append(service.Domains, domain)
+			domainServices[id(proxy.ZoneKey, domain)] = append(domainServices[id(proxy.ZoneKey, domain)], service.Name)
+		}
+		sort.Strings(service.Domains)
+		sort.Ints(service.Ports)
+		if entry, ok := catalog[proxy.ProxyKey]; ok {
+			service.DisplayName, service.Description, service.Owner, service.Version = entry.Name, entry.Description, entry.Owner, entry.Version
+		}
+		topology.Services = append(topology.Services, service)
+	}
+
+	for _, route := range byKind["route"] {
+		path := route.RouteMatch.Path
+		if path == "" {
+			path = route.Path
+		}
+		r := TopologyRoute{Key: route.RouteKey, Zone: route.ZoneKey, Domain: route.DomainKey, Path: path, Clusters: []string{}}
+		seen := map[string]bool{}
+		for _, rule := range route.Rules {
+			for _, traffic := range []string{"light", "dark", "tap"} {
+				for _, target := range rule.Constraints[traffic] {
+					if !seen[target.ClusterKey] {
+						seen[target.ClusterKey] = true
+						r.Clusters = append(r.Clusters, target.ClusterKey)
+					}
+					to := clusterNames[id(route.ZoneKey, target.ClusterKey)]
+					if to == "" {
+						to = target.ClusterKey
+					}
+					for _, from := range domainServices[id(route.ZoneKey, route.DomainKey)] {
+						topology.Edges = append(topology.Edges, TopologyEdge{From: from, To: to, Zone: route.ZoneKey,
+							Cluster: target.ClusterKey, Route: route.RouteKey, Traffic: traffic, Weight: target.Weight})
+					}
+				}
+			}
+		}
+		topology.Routes = append(topology.Routes, r)
+	}
+
+	sort.SliceStable(topology.Services, func(i, j int) bool {
+		return id(topology.Services[i].Zone, topology.Services[i].Name) < id(topology.Services[j].Zone, topology.Services[j].Name)
+	})
+	sort.SliceStable(topology.Clusters, func(i, j int) bool {
+		return id(topology.Clusters[i].Zone, topology.Clusters[i].Key) < id(topology.Clusters[j].Zone, topology.Clusters[j].Key)
+	})
+	sort.SliceStable(topology.Routes, func(i, j int) bool {
+		return id(topology.Routes[i].Zone, topology.Routes[i].Key) < id(topology.Routes[j].Zone, topology.Routes[j].Key)
+	})
+	sort.SliceStable(topology.Edges, func(i, j int) bool {
+		a, b := topology.Edges[i], topology.Edges[j]
+		if a.Zone != b.Zone {
+			return a.Zone < b.Zone
+		}
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		return a.Route+"/"+a.Cluster < b.Route+"/"+b.Cluster
+	})
+	return topology
+}
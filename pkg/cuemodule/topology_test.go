@@ -0,0 +1,43 @@
+package cuemodule
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildTopology(t *testing.T) {
+	var objects []json.RawMessage
+	var kinds []string
+	for _, obj := range []struct{ kind, json string }{
+		{"zone", `{"zone_key":"default-zone"}`},
+		{"proxy", `{"proxy_key":"edge","zone_key":"default-zone","name":"edge","listener_keys":["edge"]}`},
+		{"listener", `{"listener_key":"edge","zone_key":"default-zone","port":10808,"domain_keys":["edge"]}`},
+		{"domain", `{"domain_key":"edge","zone_key":"default-zone","name":"*","port":10808}`},
+		{"route", `{"route_key":"edge-to-catalog","domain_key":"edge","zone_key":"default-zone","route_match":{"path":"/services/catalog/","match_type":"prefix"},"rules":[{"constraints":{"light":[{"cluster_key":"edge-to-catalog","weight":1}],"dark":[{"cluster_key":"catalog-v2"}]}}]}`},
+		{"cluster", `{"cluster_key":"edge-to-catalog","zone_key":"default-zone","name":"catalog","instances":[{"host":"catalog.greymatter.svc","port":8080}]}`},
+		{"cluster", `{"cluster_key":"catalog-v2","zone_key":"default-zone","name":"catalog-v2"}`},
+		{"catalogservice", `{"service_id":"edge","mesh_id":"mesh","name":"Edge","owner":"Platform"}`},
+		{"cluster", `not json`},
+	} {
+		objects, kinds = append(objects, json.RawMessage(obj.json)), append(kinds, obj.kind)
+	}
+
+	topology := BuildTopology(objects, kinds)
+	assert.Equal(t, []TopologyService{{Name: "edge", Zone: "default-zone", Domains: []string{"edge"}, Ports: []int{10808}, DisplayName: "Edge", Owner: "Platform"}}, topology.Services)
+	assert.Equal(t, []TopologyCluster{
+		{Key: "catalog-v2", Zone: "default-zone", Name: "catalog-v2"},
+		{Key: "edge-to-catalog", Zone: "default-zone", Name: "catalog", Instances: []string{"catalog.greymatter.svc:8080"}},
+	}, topology.Clusters)
+	assert.Equal(t, []TopologyRoute{{Key: "edge-to-catalog", Zone: "default-zone", Domain: "edge", Path: "/services/catalog/", Clusters: []string{"edge-to-catalog", "catalog-v2"}}}, topology.Routes)
+	assert.Equal(t, []TopologyEdge{
+		{From: "edge", To: "catalog-v2", Zone: "default-zone", Cluster: "catalog-v2", Route: "edge-to-catalog", Traffic: "dark"},
+		{From: "edge", To: "catalog", Zone: "default-zone", Cluster: "edge-to-catalog", Route: "edge-to-catalog", Traffic: "light", Weight: 1},
+	}, topology.Edges)
+
+	// An empty mesh has empty lists rather than nulls
+	b, err := json.Marshal(BuildTopology(nil, nil))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"services":[],"clusters":[],"routes":[],"edges":[]}`, string(b))
+}
@@ -0,0 +1,469 @@
+package cuemodule
+
+import (
+	"crypto/x509"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/greymatter-io/operator/pkg/bootstrap"
+	"github.com/greymatter-io/operator/pkg/sealedsecrets"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// reconcileLoops are the names of the loops that can be disabled in the config's reconcile.disabled.
+var reconcileLoops = map[string]bool{"catalog_api_specs": true, "catalog_prune": true, "certificates": true, "drift": true, "identity": true, "namespace_labels": true, "sidecar_list": true, "sidecar_removal": true, "spire": true, "sync_report": true, "rightsizing": true, "topology": true, "watched_namespaces": true}
+
+// nodeArchitectures are the values of the kubernetes.io/arch label of nodes Grey Matter images can be built for.
+var nodeArchitectures = map[string]bool{"amd64": true, "arm": true, "arm64": true, "ppc64le": true, "s390x": true}
+
+// digestReference matches an image reference pinned by a sha256 digest.
+var digestReference = regexp.MustCompile(`@sha256:[0-9a-f]{64}$`)
+
+// PinnedByDigest reports whether an image reference is pinned by digest, so it can't change under a tag.
+func PinnedByDigest(image string) bool {
+	return digestReference.MatchString(image)
+}
+
+// Validate checks the CUE's config and defaults, and its default mesh, for values the operator would otherwise
+// fail on or silently ignore at runtime. Fields are named by their path in the CUE.
+func Validate(config Config, defaults Defaults, mesh *v1alpha1.Mesh) bootstrap.Problems {
+	var p bootstrap.Problems
+
+	// State is backed up to Redis unless it's kept in a file, so its connection settings are required
+	if stateRedis := config.StateRedis; defaults.StateFile == "" && stateRedis.Host != "" {
+		if stateRedis.Port < 0 || stateRedis.Port > 65535 {
+			p.Addf("config.state_redis.port: must be a port between 1 and 65535 (leave unset for %d), not %d", StateRedisPort, stateRedis.Port)
+		}
+		if stateRedis.DB < 0 {
+			p.Addf("config.state_redis.db: must not be negative, not %d", stateRedis.DB)
+		}
+	} else if defaults.StateFile == "" {
+		if defaults.RedisHost == "" {
+			p.Addf("defaults.redis_host: required for state backup")
+		}
+		if defaults.RedisPort < 1 || defaults.RedisPort > 65535 {
+			p.Addf("defaults.redis_int: must be a port between 1 and 65535, not %d", defaults.RedisPort)
+		}
+		if defaults.RedisDB < 0 {
+			p.Addf("defaults.redis_db: must not be negative, not %d", defaults.RedisDB)
+		}
+	}
+	if defaults.GitOpsStateKeyGM == "" {
+		p.Addf("defaults.gitops_state_key_gm: required for state backup")
+	}
+	if defaults.GitOpsStateKeyK8s == "" {
+		p.Addf("defaults.gitops_state_key_k8s: required for state backup")
+	}
+	if image := defaults.TrafficRedirectImage; image != "" && !PinnedByDigest(image) {
+		p.Addf("defaults.traffic_redirect_image: %q must be pinned by digest (e.g. image@sha256:...)", image)
+	}
+
+	for _, tuning := range []struct {
+		field string
+		value float64
+	}{
+		{"config.command_timeout_seconds", float64(config.CommandTimeoutSeconds)},
+		{"config.command_timeout_retries", float64(config.CommandTimeoutRetries)},
+		{"config.api_rate_limit", config.APIRateLimit},
+		{"config.api_rate_burst", float64(config.APIRateBurst)},
+		{"config.reconcile.interval_seconds", float64(config.Reconcile.IntervalSeconds)},
+		{"config.reconcile.page_size", float64(config.Reconcile.PageSize)},
+		{"config.reconcile.workers", float64(config.Reconcile.Workers)},
+		{"config.reconcile.state_retention_cycles", float64(config.Reconcile.StateRetentionCycles)},
+		{"config.reconcile.max_delete_percent", float64(config.Reconcile.MaxDeletePercent)},
+		{"config.reconcile.state_divergence_seconds", float64(config.Reconcile.StateDivergenceSeconds)},
+		{"config.reconcile.api_timeout_seconds", float64(config.Reconcile.APITimeoutSeconds)},
+		{"config.rollout.timeout_seconds", float64(config.Rollout.TimeoutSeconds)},
+	} {
+		if tuning.value < 0 {
+			p.Addf("%s: must not be negative (leave unset for the default), not %v", tuning.field, tuning.value)
+		}
+	}
+	if config.Reconcile.MaxDeletePercent > 100 {
+		p.Addf("config.reconcile.max_delete_percent: must be at most 100, not %d", config.Reconcile.MaxDeletePercent)
+	}
+	for _, name := range config.Reconcile.Disabled {
+		if !reconcileLoops[name] {
+			p.Addf("config.reconcile.disabled: unknown loop %q; expected catalog_api_specs, catalog_prune, certificates, drift, identity, namespace_labels, rightsizing, sidecar_list, sidecar_removal, spire, sync_report, topology, or watched_namespaces", name)
+		}
+	}
+
+	switch config.IdentityMode {
+	case "", IdentityModeSPIRE, IdentityModeServiceAccount:
+	default:
+		p.Addf("config.identity_mode: must be %q or %q, not %q", IdentityModeSPIRE, IdentityModeServiceAccount, config.IdentityMode)
+	}
+
+	var features []string
+	for name := range config.Features {
+		features = append(features, name)
+	}
+	sort.Strings(features)
+	for _, name := range features {
+		if _, ok := featureDefaults[name]; !ok {
+			p.Addf("config.features: unknown feature %q; expected one of %s", name, strings.Join(KnownFeatures(), ", "))
+		}
+	}
+
+	if name := config.SelfRegistration.Name; name != "" && len(validation.IsDNS1123Label(name)) > 0 {
+		p.Addf("config.self_registration.name: %q is not a valid cluster name", name)
+	}
+
+	switch config.ResourceBudget {
+	case "", ResourceBudgetWarn, ResourceBudgetEnforce, ResourceBudgetOff:
+	default:
+		p.Addf("config.resource_budget: must be %q, %q, or %q, not %q", ResourceBudgetWarn, ResourceBudgetEnforce, ResourceBudgetOff, config.ResourceBudget)
+	}
+
+	switch config.KeyDelivery.Mode {
+	case "", KeyDeliverySecret, KeyDeliverySealedSecret:
+	case KeyDeliveryExternalSecret:
+		if config.KeyDelivery.SecretStore == "" {
+			p.Addf("config.key_delivery.secret_store: required when mode is %q", KeyDeliveryExternalSecret)
+		}
+		if config.KeyDelivery.RemoteKey == "" {
+			p.Addf("config.key_delivery.remote_key: required when mode is %q", KeyDeliveryExternalSecret)
+		}
+	default:
+		p.Addf("config.key_delivery.mode: must be %q, %q, or %q, not %q",
+			KeyDeliverySecret, KeyDeliverySealedSecret, KeyDeliveryExternalSecret, config.KeyDelivery.Mode)
+	}
+	switch config.KeyDelivery.SecretStoreKind {
+	case "", "SecretStore", "ClusterSecretStore":
+	default:
+		p.Addf("config.key_delivery.secret_store_kind: must be SecretStore or ClusterSecretStore, not %q", config.KeyDelivery.SecretStoreKind)
+	}
+	if config.KeyDelivery.SealingControllerNamespace != "" {
+		p.Namespace("config.key_delivery.sealing_controller_namespace", config.KeyDelivery.SealingControllerNamespace)
+	}
+	if service := config.KeyDelivery.SealingControllerService; service != "" {
+		for _, msg := range validation.IsDNS1035Label(service) {
+			p.Addf("config.key_delivery.sealing_controller_service: %q is not a valid Service name: %s", service, msg)
+		}
+	}
+	if config.KeyDelivery.SealingCert != "" {
+		if _, err := sealedsecrets.ParsePublicKey([]byte(config.KeyDelivery.SealingCert)); err != nil {
+			p.Addf("config.key_delivery.sealing_cert: must be a PEM certificate with an RSA key: %v", err)
+		}
+	}
+	for _, ns := range config.SpireInstall.TrustBundleNamespaces {
+		p.Namespace("config.spire_install.trust_bundle_namespaces", ns)
+	}
+
+	switch config.Monitoring.Mode {
+	case "", MonitoringPrometheusOperator, MonitoringAnnotations, MonitoringNone:
+	default:
+		p.Addf("config.monitoring.mode: must be %q, %q, or %q, not %q",
+			MonitoringPrometheusOperator, MonitoringAnnotations, MonitoringNone, config.Monitoring.Mode)
+	}
+	if config.Monitoring.Path != "" && !strings.HasPrefix(config.Monitoring.Path, "/") {
+		p.Addf("config.monitoring.path: must start with /, not %q", config.Monitoring.Path)
+	}
+
+	switch config.Ingress.Provider {
+	case "", "openshift", "nginx", "traefik", "ingress", "none":
+	default:
+		p.Addf("config.ingress.provider: must be openshift, nginx, traefik, ingress, or none, not %q", config.Ingress.Provider)
+	}
+	switch config.Ingress.TLSTermination {
+	case "", "edge", "passthrough", "reencrypt":
+	default:
+		p.Addf("config.ingress.tls_termination: must be edge, passthrough, or reencrypt, not %q", config.Ingress.TLSTermination)
+	}
+	switch config.Ingress.Certificates.IssuerKind {
+	case "", "ClusterIssuer", "Issuer":
+	default:
+		p.Addf("config.ingress.certificates.issuer_kind: must be ClusterIssuer or Issuer, not %q", config.Ingress.Certificates.IssuerKind)
+	}
+	if config.Ingress.Certificates.RenewBeforeHours < 0 {
+		p.Addf("config.ingress.certificates.renew_before_hours: must not be negative")
+	}
+	if config.Ingress.HostTemplate != "" && !strings.Contains(config.Ingress.HostTemplate, "{service}") {
+		p.Addf("config.ingress.host_template: must contain {service}, not %q", config.Ingress.HostTemplate)
+	}
+
+	// The Catalog Service is reached over plain HTTP, so TLS settings need Catalog's URL over HTTPS
+	if config.CatalogAPI.URL != "" {
+		p.URL("config.catalog_api.url", config.CatalogAPI.URL)
+	}
+	if (config.CatalogAPI.ClientCertificate || config.CatalogAPI.CABundle != "") && !strings.HasPrefix(config.CatalogAPI.URL, "https://") {
+		p.Addf("config.catalog_api.url: must be an https URL when client_certificate or ca_bundle is set, not %q", config.CatalogAPI.URL)
+	}
+	if config.CatalogAPI.CABundle != "" && !x509.NewCertPool().AppendCertsFromPEM([]byte(config.CatalogAPI.CABundle)) {
+		p.Addf("config.catalog_api.ca_bundle: must contain PEM certificates")
+	}
+	if config.APIAuth.TokenURL != "" {
+		p.URL("config.api_auth.token_url", config.APIAuth.TokenURL)
+		if config.APIAuth.CredentialsSecret == "" {
+			p.Addf("config.api_auth.credentials_secret: required")
+		}
+		// Both would set Catalog's Authorization header
+		if config.CatalogAPI.TokenSecret != "" {
+			p.Addf("config.api_auth: token_url and config.catalog_api.token_secret are mutually exclusive")
+		}
+	}
+
+	roles := make(map[string]bool)
+	for idx, role := range config.WatchedNamespaceRBAC {
+		field := fmt.Sprintf("config.watched_namespace_rbac[%d]", idx)
+		for _, msg := range validation.IsDNS1123Subdomain(role.Name) {
+			p.Addf("%s.name: %q is not a valid Role name: %s", field, role.Name, msg)
+		}
+		if roles[role.Name] {
+			p.Addf("%s.name: %q is used by more than one Role", field, role.Name)
+		}
+		roles[role.Name] = true
+		if len(role.Rules) == 0 {
+			p.Addf("%s.rules: required", field)
+		}
+		if len(role.ServiceAccounts) == 0 {
+			p.Addf("%s.service_accounts: required", field)
+		}
+		for _, sa := range role.ServiceAccounts {
+			if parts := strings.SplitN(sa, "/", 2); len(parts) == 2 {
+				p.Namespace(field+".service_accounts", parts[0])
+				sa = parts[1]
+			}
+			for _, msg := range validation.IsDNS1123Subdomain(sa) {
+				p.Addf("%s.service_accounts: %q is not a valid ServiceAccount name: %s", field, sa, msg)
+			}
+		}
+	}
+
+	reconcilers := make(map[string]bool)
+	for idx, reconciler := range config.CustomReconcilers {
+		field := fmt.Sprintf("config.custom_reconcilers[%d]", idx)
+		if reconciler.Name == "" {
+			p.Addf("%s.name: required", field)
+		} else if reconcilers[reconciler.Name] {
+			p.Addf("%s.name: %q is used by more than one reconciler", field, reconciler.Name)
+		}
+		reconcilers[reconciler.Name] = true
+		for _, kind := range reconciler.Kinds {
+			if kind != "Pod" && kind != "Deployment" && kind != "StatefulSet" {
+				p.Addf("%s.kinds: must be Pod, Deployment, or StatefulSet, not %q", field, kind)
+			}
+		}
+		for _, ns := range reconciler.Namespaces {
+			p.Namespace(field+".namespaces", ns)
+		}
+		if len(reconciler.Labels) == 0 && len(reconciler.Annotations) == 0 {
+			p.Addf("%s: sets neither labels nor annotations", field)
+		}
+		for key, value := range reconciler.Labels {
+			for _, msg := range validation.IsQualifiedName(key) {
+				p.Addf("%s.labels: %q is not a valid label key: %s", field, key, msg)
+			}
+			for _, msg := range validation.IsValidLabelValue(value) {
+				p.Addf("%s.labels[%q]: %q is not a valid label value: %s", field, key, value, msg)
+			}
+		}
+		for key := range reconciler.Annotations {
+			for _, msg := range validation.IsQualifiedName(strings.ToLower(key)) {
+				p.Addf("%s.annotations: %q is not a valid annotation key: %s", field, key, msg)
+			}
+		}
+	}
+
+	for _, arch := range config.NodePlatforms.SidecarArchitectures {
+		if !nodeArchitectures[arch] {
+			p.Addf("config.node_platforms.sidecar_architectures: %q is not a node architecture (amd64, arm, arm64, ppc64le, or s390x)", arch)
+		}
+	}
+	for _, arch := range config.NodePlatforms.NodeArchitectures {
+		if !nodeArchitectures[arch] {
+			p.Addf("config.node_platforms.node_architectures: %q is not a node architecture (amd64, arm, arm64, ppc64le, or s390x)", arch)
+		}
+	}
+	var archImages []string
+	for image := range config.NodePlatforms.ArchImages {
+		archImages = append(archImages, image)
+	}
+	sort.Strings(archImages)
+	for _, image := range archImages {
+		builds := config.NodePlatforms.ArchImages[image]
+		if len(builds) == 0 {
+			p.Addf("config.node_platforms.arch_images[%q]: lists no architectures", image)
+		}
+		var archs []string
+		for arch := range builds {
+			archs = append(archs, arch)
+		}
+		sort.Strings(archs)
+		for _, arch := range archs {
+			if build := builds[arch]; !nodeArchitectures[arch] {
+				p.Addf("config.node_platforms.arch_images[%q]: %q is not a node architecture (amd64, arm, arm64, ppc64le, or s390x)", image, arch)
+			} else if build == "" {
+				p.Addf("config.node_platforms.arch_images[%q][%q]: required", image, arch)
+			}
+		}
+	}
+
+	if availability := config.Availability; availability.Enabled {
+		if name := availability.PriorityClassName; name != "" {
+			for _, msg := range validation.IsDNS1123Subdomain(name) {
+				p.Addf("config.availability.priority_class_name: %q is not a valid PriorityClass name: %s", name, msg)
+			}
+		}
+		if availability.PriorityValue < 0 || availability.PriorityValue > 1000000000 {
+			p.Addf("config.availability.priority_value: must be between 0 and 1000000000, not %d", availability.PriorityValue)
+		}
+		validateDisruptionBudget(&p, "config.availability", availability.MinAvailable, availability.MaxUnavailable)
+	}
+
+	if rightsizing := config.Rightsizing; rightsizing.Enabled {
+		switch rightsizing.Source {
+		case "", RightsizingMetricsServer:
+		case RightsizingPrometheus:
+			if rightsizing.PrometheusURL == "" {
+				p.Addf("config.rightsizing.prometheus_url: required")
+			}
+		default:
+			p.Addf("config.rightsizing.source: must be %q or %q, not %q", RightsizingMetricsServer, RightsizingPrometheus, rightsizing.Source)
+		}
+		p.URL("config.rightsizing.prometheus_url", rightsizing.PrometheusURL)
+		for _, n := range []struct {
+			name  string
+			value int
+		}{{"window", rightsizing.Window}, {"min_samples", rightsizing.MinSamples}, {"headroom_percent", rightsizing.HeadroomPercent}} {
+			if n.value < 0 {
+				p.Addf("config.rightsizing.%s: must not be negative", n.name)
+			}
+		}
+		if rightsizing.Window > 0 && rightsizing.MinSamples > rightsizing.Window {
+			p.Addf("config.rightsizing.min_samples: must not exceed window (got %d > %d)", rightsizing.MinSamples, rightsizing.Window)
+		}
+		for _, bounds := range []struct{ resource, min, max string }{
+			{"cpu", rightsizing.MinCPU, rightsizing.MaxCPU}, {"memory", rightsizing.MinMemory, rightsizing.MaxMemory},
+		} {
+			min, minOK := parseQuantity(&p, "config.rightsizing.min_"+bounds.resource, bounds.min)
+			max, maxOK := parseQuantity(&p, "config.rightsizing.max_"+bounds.resource, bounds.max)
+			if minOK && maxOK && min.Cmp(max) > 0 {
+				p.Addf("config.rightsizing.min_%s: must not exceed max_%s (got %s > %s)", bounds.resource, bounds.resource, bounds.min, bounds.max)
+			}
+		}
+	}
+
+	if mesh != nil {
+		p.Namespace("mesh.install_namespace", mesh.Spec.InstallNamespace)
+		for _, ns := range mesh.Spec.WatchNamespaces {
+			p.Namespace("mesh.watch_namespaces", ns)
+		}
+		if selector := mesh.Spec.WatchNamespaceSelector; selector != nil {
+			if _, err := metav1.LabelSelectorAsSelector(selector); err != nil {
+				p.Addf("mesh.watch_namespace_selector: %v", err)
+			}
+		}
+		if overrides := mesh.Spec.Overrides; overrides != nil {
+			for _, c := range []struct {
+				name     string
+				override *v1alpha1.ComponentOverride
+			}{{"control", overrides.Control}, {"catalog", overrides.Catalog}, {"edge", overrides.Edge}, {"redis", overrides.Redis}} {
+				if c.override != nil && c.override.PodDisruptionBudget != nil {
+					budget := c.override.PodDisruptionBudget
+					validateDisruptionBudget(&p, fmt.Sprintf("mesh.overrides.%s.pod_disruption_budget", c.name), budget.MinAvailable, budget.MaxUnavailable)
+				}
+			}
+		}
+		if autoscaling := mesh.Spec.Autoscaling; autoscaling != nil {
+			for _, c := range []struct {
+				name        string
+				autoscaling *v1alpha1.ComponentAutoscaling
+			}{{"control", autoscaling.Control}, {"edge", autoscaling.Edge}} {
+				if c.autoscaling != nil {
+					validateAutoscaling(&p, "mesh.autoscaling."+c.name, c.autoscaling)
+				}
+			}
+		}
+		if src := mesh.Spec.GitOps; src != nil {
+			if src.Remote == "" {
+				p.Addf("mesh.gitops.remote: required")
+			}
+			if src.Branch != "" && src.Tag != "" {
+				p.Addf("mesh.gitops: branch and tag are mutually exclusive (branch %q, tag %q)", src.Branch, src.Tag)
+			}
+		}
+		if auth := mesh.Spec.EdgeAuth; auth != nil && auth.OIDC != nil {
+			if auth.OIDC.IssuerURL == "" {
+				p.Addf("mesh.edge_auth.oidc.issuer_url: required")
+			}
+			if auth.OIDC.CredentialsSecret == "" {
+				p.Addf("mesh.edge_auth.oidc.credentials_secret: required")
+			}
+			if auth.OIDC.ServiceURL == "" {
+				p.Addf("mesh.edge_auth.oidc.service_url: required")
+			}
+			p.URL("mesh.edge_auth.oidc.issuer_url", auth.OIDC.IssuerURL)
+			p.URL("mesh.edge_auth.oidc.jwks_url", auth.OIDC.JWKSURL)
+			p.URL("mesh.edge_auth.oidc.service_url", auth.OIDC.ServiceURL)
+		}
+		if auth := mesh.Spec.EdgeAuth; auth != nil && auth.ExtAuthz != nil {
+			if auth.ExtAuthz.URL == "" {
+				p.Addf("mesh.edge_auth.ext_authz.url: required")
+			}
+			p.URL("mesh.edge_auth.ext_authz.url", auth.ExtAuthz.URL)
+		}
+	}
+
+	return p
+}
+
+// validateDisruptionBudget checks that at most one of a disruption budget's min_available and max_unavailable is set,
+// each to a number or a percentage.
+func validateDisruptionBudget(p *bootstrap.Problems, field string, minAvailable, maxUnavailable *intstr.IntOrString) {
+	if minAvailable != nil && maxUnavailable != nil {
+		p.Addf("%s: min_available and max_unavailable are mutually exclusive", field)
+	}
+	for _, budget := range []struct {
+		name  string
+		value *intstr.IntOrString
+	}{{"min_available", minAvailable}, {"max_unavailable", maxUnavailable}} {
+		if budget.value == nil {
+			continue
+		}
+		if scaled, err := intstr.GetScaledValueFromIntOrPercent(budget.value, 100, false); err != nil || scaled < 0 {
+			p.Addf("%s.%s: must be a non-negative number or a percentage, not %q", field, budget.name, budget.value.String())
+		}
+	}
+}
+
+// validateAutoscaling checks a core component's replica bounds and target utilizations.
+func validateAutoscaling(p *bootstrap.Problems, field string, autoscaling *v1alpha1.ComponentAutoscaling) {
+	if autoscaling.MaxReplicas < 1 {
+		p.Addf("%s.max_replicas: must be at least 1 (got %d)", field, autoscaling.MaxReplicas)
+	}
+	if min := autoscaling.MinReplicas; min != nil {
+		if *min < 1 {
+			p.Addf("%s.min_replicas: must be at least 1 (got %d)", field, *min)
+		} else if *min > autoscaling.MaxReplicas && autoscaling.MaxReplicas >= 1 {
+			p.Addf("%s.min_replicas: must not exceed max_replicas (got %d > %d)", field, *min, autoscaling.MaxReplicas)
+		}
+	}
+	for _, target := range []struct {
+		name        string
+		utilization *int32
+	}{{"target_cpu_utilization", autoscaling.TargetCPUUtilization}, {"target_memory_utilization", autoscaling.TargetMemoryUtilization}} {
+		if target.utilization != nil && *target.utilization < 1 {
+			p.Addf("%s.%s: must be a positive percentage (got %d)", field, target.name, *target.utilization)
+		}
+	}
+}
+
+// parseQuantity parses value, the value of field, if it's set, adding a problem if it isn't a quantity.
+func parseQuantity(p *bootstrap.Problems, field, value string) (resource.Quantity, bool) {
+	if value == "" {
+		return resource.Quantity{}, false
+	}
+	q, err := resource.ParseQuantity(value)
+	if err != nil {
+		p.Addf("%s: %q is not a quantity", field, value)
+		return q, false
+	}
+	return q, true
+}
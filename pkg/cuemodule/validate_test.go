@@ -0,0 +1,179 @@
+package cuemodule
+
+import (
+	"testing"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestValidate(t *testing.T) {
+	defaults := Defaults{RedisHost: "greymatter-datastore.greymatter.svc", RedisPort: 6379, GitOpsStateKeyGM: "gm", GitOpsStateKeyK8s: "k8s"}
+	two, zero := int32(2), int32(0)
+	mesh := &v1alpha1.Mesh{Spec: v1alpha1.MeshSpec{InstallNamespace: "greymatter", WatchNamespaces: []string{"apps"},
+		Autoscaling: &v1alpha1.Autoscaling{Edge: &v1alpha1.ComponentAutoscaling{MinReplicas: &two, MaxReplicas: 2}}}}
+
+	assert.Empty(t, Validate(Config{}, defaults, mesh))
+	halfBudget := intstr.FromString("50%")
+	assert.Empty(t, Validate(Config{
+		IdentityMode:   IdentityModeServiceAccount,
+		ResourceBudget: ResourceBudgetEnforce,
+		Availability:   AvailabilityConfig{Enabled: true, PriorityClassName: "system-cluster-critical", MinAvailable: &halfBudget},
+		Rightsizing:    RightsizingConfig{Enabled: true, Source: RightsizingPrometheus, PrometheusURL: "http://prometheus.monitoring:9090", MinCPU: "10m", MaxCPU: "1", AutoApply: true},
+		KeyDelivery:    KeyDeliveryConfig{Mode: KeyDeliveryExternalSecret, SecretStore: "vault", RemoteKey: "spire/ca"},
+		Reconcile:      ReconcileConfig{IntervalSeconds: 60, Disabled: []string{"sync_report"}},
+		CatalogAPI:     CatalogAPIConfig{URL: "https://edge.example.com/services/catalog/", ClientCertificate: true, TokenSecret: "catalog-token"},
+		WatchedNamespaceRBAC: []NamespaceRole{{
+			Name:            "greymatter-control",
+			Rules:           []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get", "list", "watch"}}},
+			ServiceAccounts: []string{"control", "monitoring/prometheus"},
+		}},
+		CustomReconcilers: []CustomReconciler{{
+			Name:        "cost-center",
+			Kinds:       []string{"Deployment", "Pod"},
+			Namespaces:  []string{"apps"},
+			Labels:      map[string]string{"example.com/cost-center": "1234"},
+			Annotations: map[string]string{"example.com/Owner": "payments team"},
+		}},
+		NodePlatforms: NodePlatformConfig{PinLinux: true, WindowsSidecarImage: "greymatter/proxy:1.8.1-windows", SidecarArchitectures: []string{"amd64"},
+			NodeArchitectures: []string{"arm64", "amd64"}, ArchImages: map[string]map[string]string{"greymatter/proxy:1.8.1": {"amd64": "greymatter/proxy:1.8.1-amd64"}}},
+	}, defaults, mesh))
+	assert.Empty(t, Validate(Config{
+		APIAuth: APIAuthConfig{TokenURL: "https://keycloak.example.com/realms/greymatter/protocol/openid-connect/token", CredentialsSecret: "gm-api-client"},
+	}, defaults, mesh))
+	// Redis isn't needed when state is kept in a file
+	assert.Empty(t, Validate(Config{}, Defaults{StateFile: "/state/gm-operator.db", GitOpsStateKeyGM: "gm", GitOpsStateKeyK8s: "k8s"}, mesh))
+	// Nor the mesh's, when state is kept in its own Redis
+	stateKeys := Defaults{GitOpsStateKeyGM: "gm", GitOpsStateKeyK8s: "k8s"}
+	assert.Empty(t, Validate(Config{StateRedis: StateRedisConfig{Host: "operator-redis.gm-operator.svc"}}, stateKeys, mesh))
+	assert.Equal(t, []string{
+		"config.state_redis.port: must be a port between 1 and 65535 (leave unset for 6379), not 70000",
+		"config.state_redis.db: must not be negative, not -1",
+	}, []string(Validate(Config{StateRedis: StateRedisConfig{Host: "operator-redis.gm-operator.svc", Port: 70000, DB: -1}}, stateKeys, mesh)))
+
+	badBudget, oneBudget := intstr.FromString("half"), intstr.FromInt(1)
+	problems := Validate(Config{
+		CommandTimeoutSeconds: -1,
+		Reconcile:             ReconcileConfig{Workers: -2, APITimeoutSeconds: -1, Disabled: []string{"identities"}},
+		IdentityMode:          "x509",
+		ResourceBudget:        "strict",
+		Features:              Features{FeatureXDS: false, "drift_enforcment": true},
+		SelfRegistration:      SelfRegistrationConfig{Enabled: true, Name: "GM Operator"},
+		Availability:          AvailabilityConfig{Enabled: true, PriorityClassName: "Mesh Critical", PriorityValue: 2000000000, MaxUnavailable: &badBudget},
+		Rightsizing:           RightsizingConfig{Enabled: true, Source: RightsizingPrometheus, Window: 10, MinSamples: 20, MinCPU: "1", MaxCPU: "500m", MaxMemory: "lots"},
+		KeyDelivery:           KeyDeliveryConfig{Mode: KeyDeliveryExternalSecret, SealingControllerNamespace: "Kube-System", SealingControllerService: "sealed.secrets", SealingCert: "not a certificate"},
+		SpireInstall:          SpireConfig{TrustBundleNamespaces: []string{"ok", "not ok"}},
+		Monitoring:            MonitoringConfig{Mode: "datadog", Path: "metrics"},
+		Ingress: IngressConfig{
+			Provider:       "haproxy",
+			TLSTermination: "mutual",
+			HostTemplate:   "{mesh}.example.com",
+			Certificates:   CertificatesConfig{IssuerKind: "Vault", RenewBeforeHours: -1},
+		},
+		CatalogAPI: CatalogAPIConfig{URL: "http://catalog.example.com", ClientCertificate: true, CABundle: "not a certificate", TokenSecret: "catalog-token"},
+		APIAuth:    APIAuthConfig{TokenURL: "keycloak/token"},
+		WatchedNamespaceRBAC: []NamespaceRole{
+			{Name: "pods", Rules: []rbacv1.PolicyRule{{Verbs: []string{"list"}}}, ServiceAccounts: []string{"Control"}},
+			{Name: "pods"},
+		},
+		CustomReconcilers: []CustomReconciler{
+			{Name: "labels", Kinds: []string{"Job"}, Labels: map[string]string{"cost center": "1234"}},
+			{Name: "labels", SidecarOnly: true},
+		},
+		NodePlatforms: NodePlatformConfig{
+			SidecarArchitectures: []string{"x86_64"},
+			ArchImages:           map[string]map[string]string{"greymatter/control:1.8": {"aarch64": "greymatter/control:1.8-arm64", "amd64": ""}},
+		},
+	}, Defaults{RedisPort: 70000, TrafficRedirectImage: "docker.io/library/alpine:3.16"}, &v1alpha1.Mesh{Spec: v1alpha1.MeshSpec{
+		WatchNamespaces: []string{"apps_1"},
+		Overrides: &v1alpha1.ComponentOverrides{Edge: &v1alpha1.ComponentOverride{PodDisruptionBudget: &v1alpha1.PodDisruptionBudgetOverride{
+			MinAvailable: &oneBudget, MaxUnavailable: &oneBudget,
+		}}},
+		Autoscaling: &v1alpha1.Autoscaling{
+			Control: &v1alpha1.ComponentAutoscaling{MinReplicas: &two, MaxReplicas: 1},
+			Edge:    &v1alpha1.ComponentAutoscaling{TargetCPUUtilization: &zero},
+		},
+		WatchNamespaceSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "team", Operator: "Matches"}}},
+		GitOps:                 &v1alpha1.GitOpsSource{Branch: "main", Tag: "v1"},
+		EdgeAuth: &v1alpha1.EdgeAuth{
+			OIDC:     &v1alpha1.OIDCAuth{IssuerURL: "keycloak/realms/greymatter", CredentialsSecret: "edge-oidc"},
+			ExtAuthz: &v1alpha1.ExtAuthz{},
+		},
+	}})
+
+	for _, want := range []string{
+		"defaults.redis_host: required",
+		"defaults.redis_int: must be a port between 1 and 65535, not 70000",
+		"defaults.gitops_state_key_gm: required",
+		"defaults.gitops_state_key_k8s: required",
+		`defaults.traffic_redirect_image: "docker.io/library/alpine:3.16" must be pinned by digest`,
+		"config.command_timeout_seconds: must not be negative",
+		"config.reconcile.workers: must not be negative",
+		"config.reconcile.api_timeout_seconds: must not be negative",
+		`config.reconcile.disabled: unknown loop "identities"`,
+		`config.identity_mode: must be "spire" or "service_account", not "x509"`,
+		`config.features: unknown feature "drift_enforcment"; expected one of drift_enforcement, webhook_injection, xds`,
+		`config.self_registration.name: "GM Operator" is not a valid cluster name`,
+		`config.resource_budget: must be "warn", "enforce", or "off", not "strict"`,
+		`config.availability.priority_class_name: "Mesh Critical" is not a valid PriorityClass name`,
+		"config.availability.priority_value: must be between 0 and 1000000000, not 2000000000",
+		`config.availability.max_unavailable: must be a non-negative number or a percentage, not "half"`,
+		"config.rightsizing.prometheus_url: required",
+		"config.rightsizing.min_samples: must not exceed window (got 20 > 10)",
+		"config.rightsizing.min_cpu: must not exceed max_cpu (got 1 > 500m)",
+		`config.rightsizing.max_memory: "lots" is not a quantity`,
+		"mesh.overrides.edge.pod_disruption_budget: min_available and max_unavailable are mutually exclusive",
+		"mesh.autoscaling.control.min_replicas: must not exceed max_replicas (got 2 > 1)",
+		"mesh.autoscaling.edge.max_replicas: must be at least 1 (got 0)",
+		"mesh.autoscaling.edge.target_cpu_utilization: must be a positive percentage (got 0)",
+		"config.key_delivery.secret_store: required",
+		"config.key_delivery.remote_key: required",
+		`config.key_delivery.sealing_controller_namespace: "Kube-System" is not a valid namespace name`,
+		`config.key_delivery.sealing_controller_service: "sealed.secrets" is not a valid Service name`,
+		"config.key_delivery.sealing_cert: must be a PEM certificate with an RSA key",
+		`config.spire_install.trust_bundle_namespaces: "not ok" is not a valid namespace name`,
+		`config.monitoring.mode: must be "prometheus_operator", "annotations", or "none", not "datadog"`,
+		`config.monitoring.path: must start with /, not "metrics"`,
+		`config.ingress.provider: must be openshift, nginx, traefik, ingress, or none, not "haproxy"`,
+		`config.ingress.tls_termination: must be edge, passthrough, or reencrypt, not "mutual"`,
+		`config.ingress.certificates.issuer_kind: must be ClusterIssuer or Issuer, not "Vault"`,
+		"config.ingress.certificates.renew_before_hours: must not be negative",
+		`config.ingress.host_template: must contain {service}, not "{mesh}.example.com"`,
+		`config.catalog_api.url: must be an https URL when client_certificate or ca_bundle is set, not "http://catalog.example.com"`,
+		"config.catalog_api.ca_bundle: must contain PEM certificates",
+		`config.api_auth.token_url: "keycloak/token" is not an absolute URL`,
+		"config.api_auth.credentials_secret: required",
+		"config.api_auth: token_url and config.catalog_api.token_secret are mutually exclusive",
+		`config.watched_namespace_rbac[0].service_accounts: "Control" is not a valid ServiceAccount name`,
+		`config.watched_namespace_rbac[1].name: "pods" is used by more than one Role`,
+		"config.watched_namespace_rbac[1].rules: required",
+		"config.watched_namespace_rbac[1].service_accounts: required",
+		`config.custom_reconcilers[0].kinds: must be Pod, Deployment, or StatefulSet, not "Job"`,
+		`config.custom_reconcilers[0].labels: "cost center" is not a valid label key`,
+		`config.custom_reconcilers[1].name: "labels" is used by more than one reconciler`,
+		"config.custom_reconcilers[1]: sets neither labels nor annotations",
+		`config.node_platforms.sidecar_architectures: "x86_64" is not a node architecture`,
+		`config.node_platforms.arch_images["greymatter/control:1.8"]: "aarch64" is not a node architecture`,
+		`config.node_platforms.arch_images["greymatter/control:1.8"]["amd64"]: required`,
+		`mesh.watch_namespace_selector: "Matches" is not a valid pod selector operator`,
+		`mesh.install_namespace: "" is not a valid namespace name`,
+		`mesh.watch_namespaces: "apps_1" is not a valid namespace name`,
+		"mesh.gitops.remote: required",
+		"mesh.gitops: branch and tag are mutually exclusive",
+		"mesh.edge_auth.oidc.service_url: required",
+		`mesh.edge_auth.oidc.issuer_url: "keycloak/realms/greymatter" is not an absolute URL`,
+		"mesh.edge_auth.ext_authz.url: required",
+	} {
+		found := false
+		for _, problem := range problems {
+			if len(problem) >= len(want) && problem[:len(want)] == want {
+				found = true
+			}
+		}
+		assert.True(t, found, "missing problem %q in %v", want, problems)
+	}
+	assert.Len(t, problems, 61)
+}
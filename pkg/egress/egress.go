@@ -0,0 +1,128 @@
+// Package egress configures how the operator reaches servers outside the cluster, such as its git remote and the
+// Control APIs of other zones: through an HTTP(S) proxy, and trusting extra CAs, so GitOps works in air-gapped
+// or TLS-intercepting environments without disabling verification.
+package egress
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// In-cluster hosts that are never proxied when a proxy is configured, so the operator still reaches the
+// mesh's own Control and Catalog services directly.
+var clusterNoProxy = []string{".svc", ".cluster.local"}
+
+// Config is an HTTP(S) proxy and CA bundle for outbound requests.
+// Proxy settings left empty fall back to the HTTP_PROXY, HTTPS_PROXY, and NO_PROXY environment variables.
+type Config struct {
+	HTTPProxy  string
+	HTTPSProxy string
+	NoProxy    string
+	// Path to PEM-encoded CA certificates trusted in addition to the system roots
+	CABundlePath string
+}
+
+// Enabled reports whether a proxy or CA bundle is configured.
+func (c Config) Enabled() bool {
+	return c.HTTPProxy != "" || c.HTTPSProxy != "" || c.NoProxy != "" || c.CABundlePath != ""
+}
+
+// Transport returns an HTTP transport that uses the configured proxy and trusts the CA bundle.
+func (c Config) Transport() (*http.Transport, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+
+	proxyFunc := c.proxyConfig().ProxyFunc()
+	t.Proxy = func(req *http.Request) (*url.URL, error) {
+		return proxyFunc(req.URL)
+	}
+
+	if c.CABundlePath != "" {
+		rootCAs, err := c.rootCAs()
+		if err != nil {
+			return nil, err
+		}
+		t.TLSClientConfig = &tls.Config{RootCAs: rootCAs, MinVersion: tls.VersionTLS12}
+	}
+	return t, nil
+}
+
+// Env returns environment variables that have subprocesses such as the greymatter CLI use the configured proxy
+// and trust the CA bundle. Since SSL_CERT_FILE replaces the system bundle file, the system's certificate
+// directories still provide its roots.
+func (c Config) Env() []string {
+	var env []string
+	if c.HTTPProxy != "" {
+		env = append(env, "HTTP_PROXY="+c.HTTPProxy, "http_proxy="+c.HTTPProxy)
+	}
+	if c.HTTPSProxy != "" {
+		env = append(env, "HTTPS_PROXY="+c.HTTPSProxy, "https_proxy="+c.HTTPSProxy)
+	}
+	if c.HTTPProxy != "" || c.HTTPSProxy != "" || c.NoProxy != "" {
+		noProxy := c.proxyConfig().NoProxy
+		env = append(env, "NO_PROXY="+noProxy, "no_proxy="+noProxy)
+	}
+	if c.CABundlePath != "" {
+		env = append(env, "SSL_CERT_FILE="+c.CABundlePath)
+	}
+	return env
+}
+
+// proxyConfig returns the proxy settings from the environment, overridden by any that are configured,
+// with in-cluster hosts excluded.
+func (c Config) proxyConfig() *httpproxy.Config {
+	config := httpproxy.FromEnvironment()
+	if c.HTTPProxy != "" {
+		config.HTTPProxy = c.HTTPProxy
+	}
+	if c.HTTPSProxy != "" {
+		config.HTTPSProxy = c.HTTPSProxy
+	}
+	if c.NoProxy != "" {
+		config.NoProxy = c.NoProxy
+	}
+
+	var noProxy []string
+	if config.NoProxy != "" {
+		noProxy = append(noProxy, config.NoProxy)
+	}
+	for _, host := range clusterNoProxy {
+		if !containsHost(config.NoProxy, host) {
+			noProxy = append(noProxy, host)
+		}
+	}
+	config.NoProxy = strings.Join(noProxy, ",")
+	return config
+}
+
+func containsHost(noProxy, host string) bool {
+	for _, h := range strings.Split(noProxy, ",") {
+		if strings.TrimSpace(h) == host {
+			return true
+		}
+	}
+	return false
+}
+
+// rootCAs returns the system roots with the CA bundle added.
+func (c Config) rootCAs() (*x509.CertPool, error) {
+	bundle, err := os.ReadFile(c.CABundlePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+	}
+	rootCAs, err := x509.SystemCertPool()
+	if err != nil || rootCAs == nil {
+		rootCAs = x509.NewCertPool()
+	}
+	if !rootCAs.AppendCertsFromPEM(bundle) {
+		return nil, errors.New("no PEM-encoded certificates found in CA bundle " + c.CABundlePath)
+	}
+	return rootCAs, nil
+}
@@ -0,0 +1,107 @@
+package egress
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransportProxy(t *testing.T) {
+	t.Setenv("HTTP_PROXY", "http://env-proxy:3128")
+	t.Setenv("HTTPS_PROXY", "")
+	t.Setenv("NO_PROXY", "")
+
+	transport, err := Config{HTTPSProxy: "http://proxy:3128", NoProxy: "git.internal"}.Transport()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for url, want := range map[string]string{
+		"https://github.com/greymatter-io/gitops-core.git":    "http://proxy:3128",
+		"http://example.com/":                                 "http://env-proxy:3128",
+		"https://git.internal/gitops-core.git":                "",
+		"http://catalog.greymatter.svc:8080":                  "",
+		"http://controlensemble.greymatter.svc.cluster.local": "",
+	} {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		proxy, err := transport.Proxy(req)
+		if assert.NoError(t, err, url) {
+			if want == "" {
+				assert.Nil(t, proxy, url)
+			} else if assert.NotNil(t, proxy, url) {
+				assert.Equal(t, want, proxy.String(), url)
+			}
+		}
+	}
+}
+
+func TestTransportCABundle(t *testing.T) {
+	dir := t.TempDir()
+	bundlePath := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(bundlePath, mkCACert(t), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	transport, err := Config{CABundlePath: bundlePath}.Transport()
+	if assert.NoError(t, err) && assert.NotNil(t, transport.TLSClientConfig) {
+		assert.NotNil(t, transport.TLSClientConfig.RootCAs)
+	}
+
+	emptyPath := filepath.Join(dir, "empty.pem")
+	if err := os.WriteFile(emptyPath, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = Config{CABundlePath: emptyPath}.Transport()
+	assert.Error(t, err)
+
+	_, err = Config{CABundlePath: filepath.Join(dir, "missing.pem")}.Transport()
+	assert.Error(t, err)
+}
+
+func TestEnv(t *testing.T) {
+	t.Setenv("NO_PROXY", "")
+
+	assert.Empty(t, Config{}.Env())
+	assert.Equal(t, []string{"SSL_CERT_FILE=/etc/gm/ca.pem"}, Config{CABundlePath: "/etc/gm/ca.pem"}.Env())
+	assert.Equal(t, []string{
+		"HTTPS_PROXY=http://proxy:3128",
+		"https_proxy=http://proxy:3128",
+		"NO_PROXY=.svc,.cluster.local",
+		"no_proxy=.svc,.cluster.local",
+	}, Config{HTTPSProxy: "http://proxy:3128"}.Env())
+	assert.Equal(t, []string{
+		"NO_PROXY=.cluster.local,.svc",
+		"no_proxy=.cluster.local,.svc",
+	}, Config{NoProxy: ".cluster.local"}.Env())
+}
+
+func mkCACert(t *testing.T) []byte {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
@@ -0,0 +1,165 @@
+package gitops
+
+import (
+	"crypto/tls"
+	"fmt"
+	gohttp "net/http"
+	"os"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// GitCredentials are in-memory credentials for the config repo, e.g. materialized from an ExternalSecret.
+// When set, they take precedence over the SSH private key file.
+type GitCredentials struct {
+	// PEM-encoded SSH private key and its optional passphrase
+	SSHPrivateKey []byte
+	SSHPassphrase string
+	// Token for HTTPS remotes and OCI registries, used as the password with basic auth
+	Token string
+	// Optional username for the token; defaults to git for HTTPS remotes
+	Username string
+	// Optional known_hosts entries for verifying the SSH remote's host key
+	KnownHosts []byte
+}
+
+// IsZero reports whether no credentials are set.
+func (gc GitCredentials) IsZero() bool {
+	return len(gc.SSHPrivateKey) == 0 && gc.Token == ""
+}
+
+// WithHostKeyVerification will verify SSH remotes' host keys against the given
+// known_hosts file. Without one, the file in $SSH_KNOWN_HOSTS or ~/.ssh/known_hosts is used.
+// If insecure is set, host keys and HTTPS certificates are not verified at all.
+func WithHostKeyVerification(knownHostsPath string, insecure bool) func(*Sync) {
+	return func(s *Sync) {
+		s.KnownHostsPath = knownHostsPath
+		s.InsecureSkipVerify = insecure
+	}
+}
+
+// WithHTTPTransport will reach HTTP(S) remotes through the given
+// transport, e.g. one with a proxy and extra trusted CAs.
+func WithHTTPTransport(t *gohttp.Transport) func(*Sync) {
+	return func(s *Sync) {
+		s.httpTransport = t
+	}
+}
+
+// installHTTPTransport has go-git use the configured transport for HTTP(S) remotes.
+// go-git's own InsecureSkipTLS handling would bypass it, so verification is skipped in the transport instead.
+func (s *Sync) installHTTPTransport() {
+	t := s.httpTransport.Clone()
+	if s.InsecureSkipVerify {
+		if t.TLSClientConfig == nil {
+			t.TLSClientConfig = &tls.Config{}
+		}
+		t.TLSClientConfig.InsecureSkipVerify = true
+	}
+	c := http.NewClient(&gohttp.Client{Transport: t})
+	client.InstallProtocol("http", c)
+	client.InstallProtocol("https", c)
+}
+
+// insecureSkipTLS reports whether go-git should skip HTTPS certificate verification itself.
+func (s *Sync) insecureSkipTLS() bool {
+	return s.InsecureSkipVerify && s.httpTransport == nil
+}
+
+// WithGitCredentials will authenticate with the config repo
+// using the given in-memory credentials.
+func WithGitCredentials(creds GitCredentials) func(*Sync) {
+	return func(s *Sync) {
+		s.credentials = creds
+	}
+}
+
+// SetGitCredentials replaces the in-memory credentials used from the next fetch on, e.g. after rotation.
+func (s *Sync) SetGitCredentials(creds GitCredentials) {
+	s.credentialsMu.Lock()
+	defer s.credentialsMu.Unlock()
+	s.credentials = creds
+}
+
+// auth returns the auth method for the config repo: in-memory credentials if set,
+// then the SSH private key file if set, and otherwise nil for no auth.
+func (s *Sync) auth() (transport.AuthMethod, error) {
+	s.credentialsMu.Lock()
+	creds := s.credentials
+	s.credentialsMu.Unlock()
+
+	var auth *ssh.PublicKeys
+	var err error
+	switch {
+	case creds.Token != "":
+		username := creds.Username
+		if username == "" {
+			username = "git"
+		}
+		return &http.BasicAuth{Username: username, Password: creds.Token}, nil
+	case len(creds.SSHPrivateKey) > 0:
+		if auth, err = ssh.NewPublicKeys("git", creds.SSHPrivateKey, creds.SSHPassphrase); err != nil {
+			return nil, fmt.Errorf("failed to parse ssh private key: %w", err)
+		}
+	case s.SSHPrivateKey != "":
+		if auth, err = ssh.NewPublicKeysFromFile("git", s.SSHPrivateKey, s.SSHPassphrase); err != nil {
+			return nil, fmt.Errorf("failed to find private key from file: %w", err)
+		}
+	default:
+		return nil, nil
+	}
+
+	if auth.HostKeyCallback, err = s.hostKeyCallback(creds.KnownHosts); err != nil {
+		return nil, err
+	}
+	return auth, nil
+}
+
+// hostKeyCallback returns the callback verifying SSH remotes' host keys against, in order of precedence,
+// the known_hosts entries from in-memory credentials, the configured known_hosts file, or the default one.
+// Host keys are only left unverified if InsecureSkipVerify is set.
+func (s *Sync) hostKeyCallback(knownHosts []byte) (gossh.HostKeyCallback, error) {
+	if s.InsecureSkipVerify {
+		return gossh.InsecureIgnoreHostKey(), nil
+	}
+
+	if len(knownHosts) > 0 {
+		// knownhosts only reads files, and reads them fully up front
+		f, err := os.CreateTemp("", "known_hosts")
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(f.Name())
+		_, err = f.Write(knownHosts)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return nil, err
+		}
+		callback, err := knownhosts.New(f.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse known_hosts from git credentials: %w", err)
+		}
+		return callback, nil
+	}
+
+	if s.KnownHostsPath != "" {
+		callback, err := knownhosts.New(s.KnownHostsPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load known_hosts file %s: %w", s.KnownHostsPath, err)
+		}
+		return callback, nil
+	}
+
+	callback, err := ssh.NewKnownHostsCallback()
+	if err != nil {
+		return nil, fmt.Errorf("no known_hosts to verify the config repo's SSH host key (set -sshKnownHostsPath, or -gitInsecureSkipVerify for lab use): %w", err)
+	}
+	return callback, nil
+}
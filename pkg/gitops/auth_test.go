@@ -0,0 +1,102 @@
+package gitops
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	gohttp "net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/stretchr/testify/assert"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestHostKeyCallback(t *testing.T) {
+	known, other := mkHostKey(t), mkHostKey(t)
+	knownHosts := []byte(knownhosts.Line([]string{"github.com"}, known) + "\n")
+	remote := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 22}
+
+	knownHostsPath := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(knownHostsPath, knownHosts, 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SSH_KNOWN_HOSTS", filepath.Join(t.TempDir(), "missing"))
+
+	cases := map[string]struct {
+		sync        *Sync
+		knownHosts  []byte
+		rejectOther bool
+		wantErr     bool
+	}{
+		"from-credentials": {sync: &Sync{}, knownHosts: knownHosts, rejectOther: true},
+		"from-file":        {sync: &Sync{KnownHostsPath: knownHostsPath}, rejectOther: true},
+		"credentials-over-file": {
+			sync:        &Sync{KnownHostsPath: filepath.Join(t.TempDir(), "missing")},
+			knownHosts:  knownHosts,
+			rejectOther: true,
+		},
+		"insecure":         {sync: &Sync{InsecureSkipVerify: true}},
+		"no-known-hosts":   {sync: &Sync{}, wantErr: true},
+		"missing-file":     {sync: &Sync{KnownHostsPath: filepath.Join(t.TempDir(), "missing")}, wantErr: true},
+		"invalid-contents": {sync: &Sync{}, knownHosts: []byte("github.com not-a-key\n"), wantErr: true},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			callback, err := tc.sync.hostKeyCallback(tc.knownHosts)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.NoError(t, callback("github.com:22", remote, known))
+			if tc.rejectOther {
+				assert.Error(t, callback("github.com:22", remote, other))
+			} else {
+				assert.NoError(t, callback("github.com:22", remote, other))
+			}
+		})
+	}
+}
+
+func TestAuth(t *testing.T) {
+	s := &Sync{}
+	auth, err := s.auth()
+	assert.NoError(t, err)
+	assert.Nil(t, auth)
+
+	s.SetGitCredentials(GitCredentials{Token: "token"})
+	auth, err = s.auth()
+	if assert.NoError(t, err) {
+		assert.Equal(t, &http.BasicAuth{Username: "git", Password: "token"}, auth)
+	}
+
+	s.SetGitCredentials(GitCredentials{SSHPrivateKey: []byte("not a key")})
+	_, err = s.auth()
+	assert.Error(t, err)
+}
+
+func mkHostKey(t *testing.T) gossh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := gossh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestInsecureSkipTLS(t *testing.T) {
+	assert.False(t, (&Sync{}).insecureSkipTLS())
+	assert.True(t, (&Sync{InsecureSkipVerify: true}).insecureSkipTLS())
+	// With a custom transport, verification is skipped in the transport, which go-git would otherwise bypass
+	assert.False(t, (&Sync{InsecureSkipVerify: true, httpTransport: &gohttp.Transport{}}).insecureSkipTLS())
+}
@@ -0,0 +1,178 @@
+package gitops
+
+import (
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/format/objfile"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+)
+
+// Fresh clones are made beside the checkout, and the checkout they replace is moved aside,
+// in directories named after it with these suffixes.
+const (
+	freshSuffix = ".fresh-"
+	staleSuffix = ".stale-"
+)
+
+// corruptionErrors show that the checkout itself is damaged, rather than that the remote is unreachable
+// or a ref is missing from it.
+var corruptionErrors = []error{
+	git.ErrRepositoryNotExists,
+	git.ErrRepositoryIncomplete,
+	plumbing.ErrObjectNotFound,
+	objfile.ErrHeader,
+	objfile.ErrNegativeSize,
+	index.ErrMalformedSignature,
+	index.ErrInvalidChecksum,
+	packfile.ErrInvalidDelta,
+	packfile.ErrReferenceDeltaNotFound,
+	zlib.ErrHeader,
+	zlib.ErrChecksum,
+}
+
+// corrupt reports whether err shows that the checkout is damaged, so it must be cloned again to recover.
+func corrupt(err error) bool {
+	for _, target := range corruptionErrors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// damaged reports whether a failure to update the checkout was because it's damaged: either the error says so,
+// or the checkout no longer opens to an intact HEAD commit. Transports report missing objects inconsistently,
+// so the checkout itself is checked rather than relying on the error alone.
+func (s *Sync) damaged(err error) bool {
+	// A damaged artifact checkout is replaced by the next pull, once its digest no longer matches
+	if IsOCI(s.Remote) {
+		return false
+	}
+	if corrupt(err) {
+		return true
+	}
+	_, err = s.openCheckout()
+	return err != nil
+}
+
+// openCheckout opens the checkout, if it's a clone of the current remote with an intact HEAD commit.
+func (s *Sync) openCheckout() (*git.Repository, error) {
+	repo, err := git.PlainOpen(s.GitDir)
+	if err != nil {
+		return nil, err
+	}
+	remote, err := repo.Remote("origin")
+	if err != nil {
+		return nil, err
+	}
+	if urls := remote.Config().URLs; len(urls) == 0 || urls[0] != s.Remote {
+		return nil, fmt.Errorf("checkout is of %v rather than %s", urls, s.Remote)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := repo.CommitObject(head.Hash()); err != nil {
+		return nil, err
+	}
+	return repo, nil
+}
+
+// reuseCheckout updates a checkout kept from before a restart (e.g. on a PersistentVolumeClaim) instead of
+// cloning it again, and reports whether it could. A checkout that is damaged or of another remote can't be reused.
+func (s *Sync) reuseCheckout() (bool, error) {
+	if _, err := s.openCheckout(); err != nil {
+		logger.Info("Existing GitOps checkout can't be reused; cloning again", "Dir", s.GitDir, "Reason", err.Error())
+		return false, nil
+	}
+	if _, err := gitUpdate(s); err != nil {
+		if s.damaged(err) {
+			logger.Info("Existing GitOps checkout is damaged; cloning again", "Dir", s.GitDir, "Reason", err.Error())
+			checkoutRecoveries.Inc()
+			return false, nil
+		}
+		return false, err
+	}
+	logger.Info("Reusing existing GitOps checkout", "Dir", s.GitDir)
+	return true, nil
+}
+
+// reclone replaces the checkout with a fresh clone of the current source. The clone is made in a new directory
+// beside the checkout and swapped in with renames once it's complete, so a failed clone leaves the checkout as it
+// was, and the checkout is never seen partly cloned. An OCI artifact is pulled again the same way.
+func (s *Sync) reclone() error {
+	if IsOCI(s.Remote) {
+		_, err := s.artifactUpdate(true)
+		return err
+	}
+	if s.GitDir == "" {
+		return errors.New("no directory configured for the GitOps checkout")
+	}
+	logger.Info("Replacing GitOps checkout", "Remote", s.Remote, "Branch", s.Branch, "Tag", s.Tag, "Dir", s.GitDir)
+	dir := filepath.Clean(s.GitDir)
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return err
+	}
+	fresh, err := os.MkdirTemp(filepath.Dir(dir), filepath.Base(dir)+freshSuffix)
+	if err != nil {
+		return fmt.Errorf("failed to create directory for a fresh clone: %w", err)
+	}
+	if err := cloneInto(s, fresh); err != nil {
+		os.RemoveAll(fresh)
+		return err
+	}
+	return swapCheckout(dir, fresh)
+}
+
+// swapCheckout replaces the checkout at dir with the one at fresh, then removes the old one.
+func swapCheckout(dir, fresh string) error {
+	stale := ""
+	if _, err := os.Lstat(dir); err == nil {
+		stale = dir + staleSuffix + strconv.FormatInt(time.Now().UnixNano(), 36)
+		if err := os.Rename(dir, stale); err != nil {
+			os.RemoveAll(fresh)
+			return fmt.Errorf("failed to move aside checkout %s: %w", dir, err)
+		}
+	} else if !os.IsNotExist(err) {
+		os.RemoveAll(fresh)
+		return err
+	}
+	if err := os.Rename(fresh, dir); err != nil {
+		if stale != "" {
+			os.Rename(stale, dir) // put the old checkout back
+		}
+		os.RemoveAll(fresh)
+		return fmt.Errorf("failed to swap in fresh clone at %s: %w", dir, err)
+	}
+	if stale != "" {
+		os.RemoveAll(stale)
+	}
+	return nil
+}
+
+// removeLeftovers removes any fresh clones or old checkouts left beside the checkout at dir by an interrupted swap.
+func removeLeftovers(dir string) {
+	dir = filepath.Clean(dir)
+	for _, suffix := range []string{freshSuffix, staleSuffix} {
+		leftovers, _ := filepath.Glob(dir + suffix + "*")
+		for _, leftover := range leftovers {
+			logger.Info("Removing leftover GitOps checkout", "Dir", leftover)
+			os.RemoveAll(leftover)
+		}
+	}
+}
+
+// isEmptyDir reports whether dir is an existing, empty directory, such as a newly mounted volume.
+func isEmptyDir(dir string) bool {
+	entries, err := os.ReadDir(dir)
+	return err == nil && len(entries) == 0
+}
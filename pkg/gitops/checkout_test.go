@@ -0,0 +1,94 @@
+package gitops
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCorrupt(t *testing.T) {
+	assert.True(t, corrupt(fmt.Errorf("unable to open local repository: %w", git.ErrRepositoryNotExists)))
+	assert.True(t, corrupt(fmt.Errorf("failed to fetch remote: %w", plumbing.ErrObjectNotFound)))
+	assert.False(t, corrupt(fmt.Errorf("failed to fetch remote: %w", git.ErrRemoteNotFound)))
+	assert.False(t, corrupt(nil))
+}
+
+func TestBootstrapReusesCheckout(t *testing.T) {
+	remote := initRepo(t, "gm/outputs/mesh.cue", "zone: \"first\"")
+	gitDir := filepath.Join(t.TempDir(), "checkout")
+
+	s := New(remote, context.Background(), nil)
+	s.GitDir = gitDir
+	assert.NoError(t, s.Bootstrap())
+	// Only a checkout kept across the restart has this
+	marker := filepath.Join(gitDir, ".git", "marker")
+	assert.NoError(t, os.WriteFile(marker, nil, 0644))
+
+	restarted := New(remote, context.Background(), nil)
+	restarted.GitDir = gitDir
+	assert.NoError(t, restarted.Bootstrap())
+	assert.FileExists(t, marker)
+
+	// A checkout of another remote is replaced, and leftovers of an interrupted swap are removed
+	other := initRepo(t, "gm/outputs/mesh.cue", "zone: \"other\"")
+	assert.NoError(t, os.Mkdir(gitDir+staleSuffix+"1", 0755))
+	restarted = New(other, context.Background(), nil)
+	restarted.GitDir = gitDir
+	assert.NoError(t, restarted.Bootstrap())
+	assert.NoFileExists(t, marker)
+	assert.NoDirExists(t, gitDir+staleSuffix+"1")
+	b, err := os.ReadFile(filepath.Join(gitDir, "gm/outputs/mesh.cue"))
+	assert.NoError(t, err)
+	assert.Equal(t, "zone: \"other\"", string(b))
+}
+
+func TestWatchRecoversCorruptCheckout(t *testing.T) {
+	remote := initRepo(t, "gm/outputs/mesh.cue", "zone: \"first\"")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := New(remote, ctx, cancel)
+	s.GitDir = filepath.Join(t.TempDir(), "checkout")
+	s.Interval = 1
+	assert.NoError(t, s.Bootstrap())
+
+	synced := make(chan string, 1)
+	s.OnSyncCompleted = func() error {
+		b, err := os.ReadFile(filepath.Join(s.GitDir, "gm/outputs/mesh.cue"))
+		synced <- string(b)
+		return err
+	}
+	assert.NoError(t, os.RemoveAll(filepath.Join(s.GitDir, ".git", "objects")))
+	go s.Watch()
+
+	select {
+	case contents := <-synced:
+		assert.Equal(t, "zone: \"first\"", contents)
+	case <-time.After(10 * time.Second):
+		t.Fatal("the damaged checkout was not cloned again")
+	}
+	_, err := git.PlainOpen(s.GitDir)
+	assert.NoError(t, err)
+	leftovers, _ := filepath.Glob(s.GitDir + ".*")
+	assert.Empty(t, leftovers)
+}
+
+func TestRecloneKeepsCheckoutOnFailure(t *testing.T) {
+	remote := initRepo(t, "gm/outputs/mesh.cue", "zone: \"first\"")
+	s := New(remote, context.Background(), nil)
+	s.GitDir = filepath.Join(t.TempDir(), "checkout")
+	assert.NoError(t, s.Bootstrap())
+
+	s.Remote = filepath.Join(t.TempDir(), "missing")
+	assert.Error(t, s.reclone())
+	assert.FileExists(t, filepath.Join(s.GitDir, "gm/outputs/mesh.cue"))
+	leftovers, _ := filepath.Glob(s.GitDir + ".*")
+	assert.Empty(t, leftovers)
+}
@@ -0,0 +1,180 @@
+package gitops
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ErrUnverifiedArtifact is returned when a fetched OCI artifact isn't signed by enough of the configured keys.
+var ErrUnverifiedArtifact = errors.New("artifact signature could not be verified")
+
+// Cosign's annotation with a signature's base64-encoded bytes, and the type of the payload it signs.
+const (
+	cosignSignatureAnnotation = "dev.cosignproject.cosign/signature"
+	cosignPayloadType         = "cosign container image signature"
+)
+
+// maxPayloadSize limits the size of the signed payloads read from a registry.
+const maxPayloadSize = 1 << 20
+
+// ArtifactVerification configures which keys must have signed an OCI artifact, with `cosign sign --key`,
+// before the operator unpacks and applies it. If no keys are configured, artifacts are applied without verification.
+type ArtifactVerification struct {
+	// Path to PEM-encoded public keys, such as the cosign.pub from `cosign generate-key-pair`, one or more per file.
+	// ECDSA, RSA, and Ed25519 keys are supported.
+	PublicKeysPath string
+	// Number of the keys that must have signed an artifact. Defaults to 1, any of them.
+	RequiredSigners int
+}
+
+// Enabled reports whether any keys have been configured.
+func (av ArtifactVerification) Enabled() bool {
+	return av.PublicKeysPath != ""
+}
+
+// WithArtifactVerification will require fetched OCI artifacts to be signed with cosign
+// by at least requiredSigners of the public keys in the given file.
+func WithArtifactVerification(publicKeysPath string, requiredSigners int) func(*Sync) {
+	return func(s *Sync) {
+		s.ArtifactVerification = ArtifactVerification{
+			PublicKeysPath:  publicKeysPath,
+			RequiredSigners: requiredSigners,
+		}
+	}
+}
+
+// cosignPayload is the simple signing payload cosign signs, identifying the manifest it vouches for.
+type cosignPayload struct {
+	Critical struct {
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+}
+
+// verifySignatures checks that the cosign signatures stored beside the artifact with the given manifest digest
+// include ones from enough of the configured keys. It returns an error wrapping ErrUnverifiedArtifact if they don't.
+func (r *registry) verifySignatures(ctx context.Context, av ArtifactVerification, digest string) error {
+	keys, err := loadPublicKeys(av.PublicKeysPath)
+	if err != nil {
+		return err
+	}
+	required := av.RequiredSigners
+	if required <= 0 {
+		required = 1
+	}
+	if required > len(keys) {
+		return fmt.Errorf("%d signers are required, but only %d keys are configured in %s", required, len(keys), av.PublicKeysPath)
+	}
+
+	// Cosign stores an artifact's signatures as the layers of another artifact, tagged after its digest
+	sigs := *r
+	sigs.ref.Reference = strings.Replace(digest, ":", "-", 1) + ".sig"
+	manifest, _, err := sigs.manifest(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: no signatures found for %s: %v", ErrUnverifiedArtifact, digest, err)
+	}
+	r.basic, r.token = sigs.basic, sigs.token
+
+	signers := map[int]bool{}
+	for _, layer := range manifest.Layers {
+		sig, err := base64.StdEncoding.DecodeString(layer.Annotations[cosignSignatureAnnotation])
+		if err != nil || len(sig) == 0 {
+			continue
+		}
+		payload, err := sigs.payload(ctx, layer.Digest)
+		if err != nil {
+			return err
+		}
+		var p cosignPayload
+		if err := json.Unmarshal(payload, &p); err != nil || p.Critical.Type != cosignPayloadType ||
+			p.Critical.Image.DockerManifestDigest != digest {
+			// A signature of another artifact, e.g. one copied alongside it
+			continue
+		}
+		for i, key := range keys {
+			if !signers[i] && verifySignature(key, payload, sig) {
+				signers[i] = true
+			}
+		}
+	}
+	if len(signers) < required {
+		return fmt.Errorf("%w: %s is signed by %d of the configured keys, but %d are required", ErrUnverifiedArtifact, digest, len(signers), required)
+	}
+	logger.Info("Verified OCI artifact signatures", "Digest", digest, "Signers", len(signers))
+	return nil
+}
+
+// payload downloads a signed payload, verifying its digest.
+func (r *registry) payload(ctx context.Context, digest string) ([]byte, error) {
+	blob, err := r.blob(ctx, digest)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(blob.Name())
+	defer blob.Close()
+	b, err := io.ReadAll(io.LimitReader(blob, maxPayloadSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxPayloadSize {
+		return nil, fmt.Errorf("signed payload %s of %s is larger than %d bytes", digest, r, maxPayloadSize)
+	}
+	return b, nil
+}
+
+// verifySignature reports whether sig is key's signature of payload: over its SHA-256 for ECDSA and RSA keys,
+// as cosign signs, or over the payload itself for Ed25519 keys.
+func verifySignature(key crypto.PublicKey, payload, sig []byte) bool {
+	sum := sha256.Sum256(payload)
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(key, sum[:], sig)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, payload, sig)
+	}
+	return false
+}
+
+// loadPublicKeys reads the PEM-encoded public keys in a file.
+func loadPublicKeys(path string) ([]crypto.PublicKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public keys %s: %w", path, err)
+	}
+	var keys []crypto.PublicKey
+	for {
+		var block *pem.Block
+		if block, b = pem.Decode(b); block == nil {
+			break
+		}
+		if block.Type != "PUBLIC KEY" {
+			continue
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse public key in %s: %w", path, err)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no public keys found in %s", path)
+	}
+	return keys, nil
+}
@@ -0,0 +1,128 @@
+package gitops
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// sign stores cosign signatures by each key of the artifact with the given digest, as `cosign sign --key` would.
+// The payload signed can vouch for another digest, to sign the wrong artifact.
+func (r *fakeRegistry) sign(t *testing.T, digest, payloadDigest string, keys ...crypto.Signer) {
+	payload := []byte(fmt.Sprintf(`{"critical":{"identity":{"docker-reference":"mesh/config"},"image":{"docker-manifest-digest":%q},"type":%q},"optional":null}`,
+		payloadDigest, cosignPayloadType))
+	sum := sha256.Sum256(payload)
+	payloadSum := sumPrefix + hex.EncodeToString(sum[:])
+
+	var layers []ociDescriptor
+	for _, key := range keys {
+		var sig []byte
+		var err error
+		if _, ok := key.(ed25519.PrivateKey); ok {
+			sig, err = key.Sign(rand.Reader, payload, crypto.Hash(0))
+		} else {
+			sig, err = key.Sign(rand.Reader, sum[:], crypto.SHA256)
+		}
+		assert.NoError(t, err)
+		layers = append(layers, ociDescriptor{
+			MediaType:   "application/vnd.dev.cosign.simplesigning.v1+json",
+			Digest:      payloadSum,
+			Size:        int64(len(payload)),
+			Annotations: map[string]string{cosignSignatureAnnotation: base64.StdEncoding.EncodeToString(sig)},
+		})
+	}
+	manifest, err := json.Marshal(ociManifest{MediaType: ociManifestMediaType, Layers: layers})
+	assert.NoError(t, err)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.blobs[payloadSum] = payload
+	r.manifests[strings.Replace(digest, ":", "-", 1)+".sig"] = manifest
+}
+
+// writePublicKeys writes the keys' public halves to a PEM file, and returns its path.
+func writePublicKeys(t *testing.T, keys ...crypto.Signer) string {
+	var pems []byte
+	for _, key := range keys {
+		der, err := x509.MarshalPKIXPublicKey(key.Public())
+		assert.NoError(t, err)
+		pems = append(pems, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})...)
+	}
+	path := filepath.Join(t.TempDir(), "cosign.pub")
+	assert.NoError(t, os.WriteFile(path, pems, 0644))
+	return path
+}
+
+func TestArtifactVerification(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	reg := newFakeRegistry(t)
+	digest := reg.push(t, "v1", map[string]string{"gm/outputs/mesh.cue": `zone: "signed"`})
+	s := New(reg.remote(), context.Background(), nil, WithRepoInfo(reg.remote(), "", "v1"),
+		WithHostKeyVerification("", true), WithGitCredentials(GitCredentials{Username: "robot", Token: "secret"}),
+		WithArtifactVerification(writePublicKeys(t, ecKey, edKey), 2))
+	s.GitDir = filepath.Join(t.TempDir(), "checkout")
+
+	// Unsigned artifacts are never unpacked
+	err = s.Bootstrap()
+	assert.True(t, errors.Is(err, ErrUnverifiedArtifact), err)
+	assert.NoDirExists(t, s.GitDir)
+
+	// Nor are artifacts signed by too few of the keys, or by unknown keys
+	reg.sign(t, digest, digest, ecKey, otherKey)
+	err = s.Bootstrap()
+	assert.Contains(t, fmt.Sprint(err), "signed by 1 of the configured keys, but 2 are required")
+
+	// A signature vouching for another artifact doesn't count
+	reg.sign(t, digest, sumPrefix+strings.Repeat("0", 64), ecKey, edKey)
+	assert.True(t, errors.Is(s.Bootstrap(), ErrUnverifiedArtifact))
+
+	reg.sign(t, digest, digest, ecKey, edKey)
+	assert.NoError(t, s.Bootstrap())
+	assert.Equal(t, digest, headSHA(s.GitDir))
+
+	// A new artifact must be signed too, and the checkout is kept until it is
+	next := reg.push(t, "v1", map[string]string{"gm/outputs/mesh.cue": `zone: "next"`})
+	_, err = s.update()
+	assert.True(t, errors.Is(err, ErrUnverifiedArtifact))
+	assert.Equal(t, digest, headSHA(s.GitDir))
+	reg.sign(t, next, next, edKey, ecKey)
+	got, err := s.update()
+	assert.NoError(t, err)
+	assert.Equal(t, next, got)
+}
+
+func TestLoadPublicKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.pem")
+	assert.NoError(t, os.WriteFile(path, []byte("not a key"), 0644))
+	_, err := loadPublicKeys(path)
+	assert.Contains(t, fmt.Sprint(err), "no public keys found")
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	keys, err := loadPublicKeys(writePublicKeys(t, key))
+	assert.NoError(t, err)
+	assert.Len(t, keys, 1)
+}
@@ -0,0 +1,215 @@
+package gitops
+
+import (
+	"fmt"
+	"sync"
+)
+
+// DeletionPolicy protects against mass deletion, e.g. when a bad commit empties the config, by holding back a
+// sync cycle's deletions until they're released (see SyncState.ReleaseDeletions).
+type DeletionPolicy struct {
+	// Maximum percentage of known objects deleted in one cycle; more are held. 0 means no limit.
+	MaxPercent int
+	// Hold all deletions.
+	Hold bool
+}
+
+// holds reports whether the policy holds the deletion of n of the known objects.
+func (p DeletionPolicy) holds(n, known int) bool {
+	if n == 0 {
+		return false
+	}
+	if p.Hold {
+		return true
+	}
+	return p.MaxPercent > 0 && n*100 > p.MaxPercent*known
+}
+
+// HeldDeletions are the deletions held back by the DeletionPolicy.
+type HeldDeletions struct {
+	K8s []K8sObjectRef `json:"k8s"`
+	GM  []GMObjectRef  `json:"gm"`
+}
+
+// Len returns the number of held deletions.
+func (hd HeldDeletions) Len() int {
+	return len(hd.K8s) + len(hd.GM)
+}
+
+// deletionGuard holds deletions for a SyncState.
+type deletionGuard struct {
+	policy DeletionPolicy
+	held   HeldDeletions
+	// K8s objects held by Prune rather than FilterChangedK8s, which are re-held after each cycle
+	heldOrphans     []K8sObjectRef
+	onDeletionsHeld func(HeldDeletions)
+	mu              sync.Mutex
+}
+
+// SetDeletionPolicy sets the policy for holding deletions. By default none are held.
+func (ss *SyncState) SetDeletionPolicy(p DeletionPolicy) {
+	ss.deletions.mu.Lock()
+	defer ss.deletions.mu.Unlock()
+	ss.deletions.policy = p
+}
+
+// HeldDeletions returns the deletions currently held back by the DeletionPolicy.
+func (ss *SyncState) HeldDeletions() HeldDeletions {
+	ss.deletions.mu.Lock()
+	defer ss.deletions.mu.Unlock()
+	return ss.deletions.current()
+}
+
+// OnDeletionsHeld registers a callback that is called with the held deletions whenever their number changes,
+// including when they're released.
+func (ss *SyncState) OnDeletionsHeld(cb func(HeldDeletions)) {
+	ss.deletions.mu.Lock()
+	defer ss.deletions.mu.Unlock()
+	ss.deletions.onDeletionsHeld = cb
+}
+
+// ReleaseDeletions confirms the held deletions, returning them for the caller to delete. Their state entries are
+// compacted, so they aren't deleted again.
+func (ss *SyncState) ReleaseDeletions() HeldDeletions {
+	ss.deletions.mu.Lock()
+	released := ss.deletions.current()
+	ss.deletions.held = HeldDeletions{}
+	ss.deletions.heldOrphans = nil
+	ss.deletions.mu.Unlock()
+
+	ss.hashesMu.Lock()
+	if len(released.GM) > 0 {
+		remaining := make(map[string]GMObjectRef, len(ss.previousGMHashes))
+		for key, ref := range ss.previousGMHashes {
+			remaining[key] = ref
+		}
+		var keys []string
+		for _, ref := range released.GM {
+			delete(remaining, ref.HashKey())
+			keys = append(keys, ref.HashKey())
+		}
+		ss.markChanged(stateGM, keys...)
+		ss.previousGMHashes = remaining
+		ss.recordObjects(stateGM)
+		if ss.saveChans != nil {
+			go func() { ss.saveChans[stateGM] <- struct{}{} }()
+		}
+	}
+	if len(released.K8s) > 0 {
+		remaining := make(map[string]K8sObjectRef, len(ss.previousK8sHashes))
+		for key, ref := range ss.previousK8sHashes {
+			remaining[key] = ref
+		}
+		var keys []string
+		for _, ref := range released.K8s {
+			delete(remaining, ref.HashKey())
+			keys = append(keys, ref.HashKey())
+		}
+		ss.markChanged(stateK8s, keys...)
+		ss.previousK8sHashes = remaining
+		ss.recordObjects(stateK8s)
+		if ss.saveChans != nil {
+			go func() { ss.saveChans[stateK8s] <- struct{}{} }()
+		}
+	}
+	ss.hashesMu.Unlock()
+	stateLogger.Info("Released held deletions", "K8s", len(released.K8s), "GM", len(released.GM))
+	ss.notifyDeletionsHeld(released.Len())
+	return released
+}
+
+// GuardOrphans returns the orphaned K8s objects found by pruning that may be deleted, out of the given number of
+// objects managed by the operator, holding them all back if the DeletionPolicy holds their deletion.
+// Objects whose deletion is already held are never returned.
+func (ss *SyncState) GuardOrphans(orphans []K8sObjectRef, managed int) []K8sObjectRef {
+	ss.deletions.mu.Lock()
+	held := make(map[string]bool, len(ss.deletions.held.K8s))
+	for _, ref := range ss.deletions.held.K8s {
+		held[ref.HashKey()] = true
+	}
+	var remaining []K8sObjectRef
+	for _, ref := range orphans {
+		if !held[ref.HashKey()] {
+			remaining = append(remaining, ref)
+		}
+	}
+	before := ss.deletions.current().Len()
+	hold := ss.deletions.policy.holds(len(remaining), managed)
+	if hold {
+		ss.deletions.heldOrphans = remaining
+	} else {
+		ss.deletions.heldOrphans = nil
+	}
+	ss.deletions.mu.Unlock()
+
+	ss.notifyDeletionsHeld(before)
+	if hold {
+		stateLogger.Info(fmt.Sprintf("Holding deletion of %d of %d managed K8s objects found orphaned", len(remaining), managed))
+		return nil
+	}
+	return remaining
+}
+
+// guardK8s holds the K8s objects deleted in a sync cycle if the DeletionPolicy holds their deletion, out of the
+// number known before the cycle, returning those that may be deleted.
+func (ss *SyncState) guardK8s(deleted []K8sObjectRef, known int) []K8sObjectRef {
+	ss.deletions.mu.Lock()
+	before := ss.deletions.current().Len()
+	hold := ss.deletions.policy.holds(len(deleted), known)
+	if hold {
+		ss.deletions.held.K8s = deleted
+	} else {
+		ss.deletions.held.K8s = nil
+	}
+	ss.deletions.mu.Unlock()
+
+	ss.notifyDeletionsHeld(before)
+	if hold {
+		stateLogger.Info(fmt.Sprintf("Holding deletion of %d of %d known K8s objects", len(deleted), known))
+		return nil
+	}
+	return deleted
+}
+
+// guardGM holds the Grey Matter config objects deleted in a sync cycle if the DeletionPolicy holds their deletion,
+// out of the number known before the cycle, returning those that may be deleted.
+func (ss *SyncState) guardGM(deleted []GMObjectRef, known int) []GMObjectRef {
+	ss.deletions.mu.Lock()
+	before := ss.deletions.current().Len()
+	hold := ss.deletions.policy.holds(len(deleted), known)
+	if hold {
+		ss.deletions.held.GM = deleted
+	} else {
+		ss.deletions.held.GM = nil
+	}
+	ss.deletions.mu.Unlock()
+
+	ss.notifyDeletionsHeld(before)
+	if hold {
+		stateLogger.Info(fmt.Sprintf("Holding deletion of %d of %d known Grey Matter config objects", len(deleted), known))
+		return nil
+	}
+	return deleted
+}
+
+// notifyDeletionsHeld updates the held deletions metric, and calls the OnDeletionsHeld callback if their number
+// has changed from the given one.
+func (ss *SyncState) notifyDeletionsHeld(before int) {
+	ss.deletions.mu.Lock()
+	held := ss.deletions.current()
+	cb := ss.deletions.onDeletionsHeld
+	ss.deletions.mu.Unlock()
+
+	heldDeletions.WithLabelValues("k8s").Set(float64(len(held.K8s)))
+	heldDeletions.WithLabelValues("gm").Set(float64(len(held.GM)))
+	if cb != nil && held.Len() != before {
+		cb(held)
+	}
+}
+
+// current returns the held deletions, including orphans. The caller holds the lock.
+func (g *deletionGuard) current() HeldDeletions {
+	held := HeldDeletions{GM: g.held.GM}
+	held.K8s = append(append(held.K8s, g.held.K8s...), g.heldOrphans...)
+	return held
+}
@@ -0,0 +1,103 @@
+package gitops
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestDeletionPolicy(t *testing.T) {
+	assert.False(t, DeletionPolicy{}.holds(10, 10))
+	assert.False(t, DeletionPolicy{Hold: true}.holds(0, 10))
+	assert.True(t, DeletionPolicy{Hold: true}.holds(1, 10))
+	assert.False(t, DeletionPolicy{MaxPercent: 50}.holds(5, 10))
+	assert.True(t, DeletionPolicy{MaxPercent: 50}.holds(6, 10))
+}
+
+func TestHoldGMDeletions(t *testing.T) {
+	var configs []json.RawMessage
+	var kinds []string
+	for i := 0; i < 4; i++ {
+		configs = append(configs, json.RawMessage(fmt.Sprintf(`{"cluster_key": "c%d", "zone_key": "default-zone"}`, i)))
+		kinds = append(kinds, "cluster")
+	}
+	ss := &SyncState{previousGMHashes: make(map[string]GMObjectRef)}
+	ss.SetDeletionPolicy(DeletionPolicy{MaxPercent: 25})
+	var notified []HeldDeletions
+	ss.OnDeletionsHeld(func(held HeldDeletions) { notified = append(notified, held) })
+	ss.FilterChangedGM(configs, kinds)
+
+	// Deleting one of four objects is within the threshold
+	_, _, deleted := ss.FilterChangedGM(configs[1:], kinds[1:])
+	assert.Len(t, deleted, 1)
+	assert.Empty(t, notified)
+
+	// But an emptied config deletes them all, so they're held and still known
+	_, _, deleted = ss.FilterChangedGM(nil, nil)
+	assert.Empty(t, deleted)
+	assert.Len(t, ss.HeldDeletions().GM, 3)
+	assert.Len(t, ss.previousGMHashes, 3)
+	if assert.Len(t, notified, 1) {
+		assert.Equal(t, 3, notified[0].Len())
+	}
+
+	// And held again each cycle until they're released...
+	_, _, deleted = ss.FilterChangedGM(nil, nil)
+	assert.Empty(t, deleted)
+	assert.Len(t, notified, 1)
+	released := ss.ReleaseDeletions()
+	assert.Len(t, released.GM, 3)
+	assert.Empty(t, ss.previousGMHashes)
+	assert.Equal(t, 0, ss.HeldDeletions().Len())
+	if assert.Len(t, notified, 2) {
+		assert.Equal(t, 0, notified[1].Len())
+	}
+
+	// ...or they reappear in the config
+	ss.FilterChangedGM(configs, kinds)
+	ss.FilterChangedGM(nil, nil)
+	assert.Len(t, ss.HeldDeletions().GM, 4)
+	filtered, _, deleted := ss.FilterChangedGM(configs, kinds)
+	assert.Empty(t, filtered)
+	assert.Empty(t, deleted)
+	assert.Equal(t, 0, ss.HeldDeletions().Len())
+}
+
+func TestHoldK8sDeletions(t *testing.T) {
+	configMap := func(name string) client.Object {
+		return &corev1.ConfigMap{
+			TypeMeta:   metav1.TypeMeta{Kind: "ConfigMap", APIVersion: "v1"},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: defaultNamespace},
+		}
+	}
+	ss := &SyncState{previousK8sHashes: make(map[string]K8sObjectRef)}
+	ss.SetDeletionPolicy(DeletionPolicy{Hold: true})
+	ss.FilterChangedK8s([]client.Object{configMap("a"), configMap("b")})
+
+	_, deleted := ss.FilterChangedK8s([]client.Object{configMap("a")})
+	assert.Empty(t, deleted)
+	if held := ss.HeldDeletions(); assert.Len(t, held.K8s, 1) {
+		assert.Equal(t, "b", held.K8s[0].Name)
+	}
+
+	// Pruning doesn't delete objects already held, and holds the rest
+	orphan := K8sObjectRef{Namespace: defaultNamespace, Kind: configMap("c").GetObjectKind().GroupVersionKind(), Name: "c"}
+	assert.Empty(t, ss.GuardOrphans([]K8sObjectRef{ss.HeldDeletions().K8s[0], orphan}, 3))
+	assert.Len(t, ss.HeldDeletions().K8s, 2)
+
+	released := ss.ReleaseDeletions()
+	assert.Len(t, released.K8s, 2)
+	assert.Len(t, ss.previousK8sHashes, 1)
+	_, deleted = ss.FilterChangedK8s([]client.Object{configMap("a")})
+	assert.Empty(t, deleted)
+
+	// Without a policy, orphans are deleted
+	ss.SetDeletionPolicy(DeletionPolicy{})
+	assert.Len(t, ss.GuardOrphans([]K8sObjectRef{orphan}, 2), 1)
+	assert.Equal(t, 0, ss.HeldDeletions().Len())
+}
@@ -0,0 +1,265 @@
+package gitops
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"sigs.k8s.io/yaml"
+)
+
+// CUEDependenciesFile lists the CUE modules that the config repo depends on. The operator fetches them,
+// verifies their checksums, and vendors them into cue.mod/pkg, so the repo needn't use git submodules.
+const CUEDependenciesFile = "cue.mod/dependencies.yaml"
+
+// sumPrefix prefixes checksums computed by DirSum, identifying the algorithm.
+const sumPrefix = "sha256:"
+
+// CUEDependency is a CUE module pinned to a git ref and the checksum of its files.
+type CUEDependency struct {
+	// Import path of the module, under which it's vendored in cue.mod/pkg (e.g. greymatter.io/gitops-core)
+	Module string `json:"module"`
+	// Git repository and tag or commit holding the module
+	Repo string `json:"repo"`
+	Ref  string `json:"ref"`
+	// Optional subdirectory of the repository with the module's files
+	Dir string `json:"dir,omitempty"`
+	// Checksum of the module's files, as computed by DirSum
+	Sum string `json:"sum"`
+}
+
+type cueDependencies struct {
+	Dependencies []CUEDependency `json:"dependencies"`
+}
+
+// WithCUEDependencies sets the directory where fetched CUE dependencies are cached, which can be pre-populated
+// for offline use, and whether git submodules are left alone in favor of CUEDependenciesFile.
+func WithCUEDependencies(cacheDir string, skipSubmodules bool) func(*Sync) {
+	return func(s *Sync) {
+		s.CUEModuleCache = cacheDir
+		s.SkipSubmodules = skipSubmodules
+	}
+}
+
+// submoduleRecursion returns how deep git submodules are cloned and pulled.
+func (s *Sync) submoduleRecursion() git.SubmoduleRescursivity {
+	if s.SkipSubmodules {
+		return git.NoRecurseSubmodules
+	}
+	return git.DefaultSubmoduleRecursionDepth // we need this to pull the cue config submodules
+}
+
+// vendorCUEDependencies vendors each dependency listed in the CUEDependenciesFile of the checkout at root,
+// if it has one, into its cue.mod/pkg. Dependencies come from the cache if it has a copy with the pinned checksum,
+// and are otherwise fetched and verified before being cached.
+func (s *Sync) vendorCUEDependencies(root string) error {
+	b, err := os.ReadFile(filepath.Join(root, CUEDependenciesFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", CUEDependenciesFile, err)
+	}
+	var deps cueDependencies
+	if err := yaml.UnmarshalStrict(b, &deps); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", CUEDependenciesFile, err)
+	}
+
+	for _, dep := range deps.Dependencies {
+		module, ok := localPath(dep.Module)
+		if !ok || module == "." {
+			return fmt.Errorf("invalid module %q in %s", dep.Module, CUEDependenciesFile)
+		}
+		src, err := s.cachedCUEDependency(dep)
+		if err != nil {
+			return fmt.Errorf("CUE dependency %s: %w", dep.Module, err)
+		}
+		dst := filepath.Join(root, "cue.mod", "pkg", module)
+		if err := os.RemoveAll(dst); err != nil {
+			return err
+		}
+		if err := copyDir(src, dst); err != nil {
+			return fmt.Errorf("failed to vendor CUE dependency %s: %w", dep.Module, err)
+		}
+		logger.Info("Vendored CUE dependency", "Module", dep.Module, "Ref", dep.Ref, "Sum", dep.Sum)
+	}
+	return nil
+}
+
+// cachedCUEDependency returns the cache directory holding a verified copy of the dependency, fetching it first
+// if it isn't already cached.
+func (s *Sync) cachedCUEDependency(dep CUEDependency) (string, error) {
+	if dep.Sum != "" && !strings.HasPrefix(dep.Sum, sumPrefix) {
+		return "", fmt.Errorf("unsupported checksum %q; expected one starting with %q", dep.Sum, sumPrefix)
+	}
+	dir := "."
+	if dep.Dir != "" {
+		var ok bool
+		if dir, ok = localPath(dep.Dir); !ok {
+			return "", fmt.Errorf("invalid dir %q in %s", dep.Dir, CUEDependenciesFile)
+		}
+	}
+	cacheDir := s.CUEModuleCache
+	if cacheDir == "" {
+		cacheDir = filepath.Join(os.TempDir(), "gm-operator-cue-modules")
+	}
+	// Copies are cached by checksum, so a copy is only used if it's still the version that was pinned
+	cached := filepath.Join(cacheDir, strings.TrimPrefix(dep.Sum, sumPrefix))
+	if dep.Sum != "" {
+		if sum, err := DirSum(cached); err == nil && sum == dep.Sum {
+			return cached, nil
+		}
+	}
+
+	fetched, err := os.MkdirTemp(cacheDir, ".fetch-")
+	if errors.Is(err, fs.ErrNotExist) {
+		if err = os.MkdirAll(cacheDir, 0755); err == nil {
+			fetched, err = os.MkdirTemp(cacheDir, ".fetch-")
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	defer os.RemoveAll(fetched)
+
+	if err := s.fetchCUEDependency(dep, fetched); err != nil {
+		return "", err
+	}
+	moduleDir := filepath.Join(fetched, dir)
+	if err := os.RemoveAll(filepath.Join(moduleDir, ".git")); err != nil {
+		return "", err
+	}
+	sum, err := DirSum(moduleDir)
+	if err != nil {
+		return "", err
+	}
+	if dep.Sum == "" {
+		return "", fmt.Errorf("unpinned; pin %s at %s with sum: %s", dep.Repo, dep.Ref, sum)
+	}
+	if sum != dep.Sum {
+		return "", fmt.Errorf("checksum mismatch for %s at %s: got %s, expected %s", dep.Repo, dep.Ref, sum, dep.Sum)
+	}
+
+	os.RemoveAll(cached)
+	if err := os.Rename(moduleDir, cached); err != nil {
+		return "", fmt.Errorf("failed to cache CUE dependency: %w", err)
+	}
+	return cached, nil
+}
+
+// fetchCUEDependency clones the dependency's repo into dir and checks out its ref,
+// using the same credentials and transport as the config repo.
+func (s *Sync) fetchCUEDependency(dep CUEDependency, dir string) error {
+	auth, err := s.auth()
+	if err != nil {
+		return err
+	}
+	repo, err := git.PlainClone(dir, false, &git.CloneOptions{
+		URL:             dep.Repo,
+		Auth:            auth,
+		Tags:            git.AllTags,
+		InsecureSkipTLS: s.insecureSkipTLS(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to clone %s: %w", dep.Repo, err)
+	}
+	hash, err := repo.ResolveRevision(plumbing.Revision(dep.Ref))
+	if err != nil {
+		return fmt.Errorf("unable to resolve %q in %s: %w", dep.Ref, dep.Repo, err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	if err := wt.Checkout(&git.CheckoutOptions{Hash: peelTag(repo, *hash), Force: true}); err != nil {
+		return fmt.Errorf("unable to checkout %q in %s: %w", dep.Ref, dep.Repo, err)
+	}
+	return nil
+}
+
+// DirSum returns a checksum of the files under dir (excluding any .git directory), covering their paths and
+// contents: the SHA-256 of a line per file, in path order, with the SHA-256 of its contents and its path.
+func DirSum(dir string) (string, error) {
+	var lines []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return err
+		}
+		lines = append(lines, fmt.Sprintf("%x  %s\n", h.Sum(nil), filepath.ToSlash(rel)))
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(lines)
+	h := sha256.New()
+	for _, line := range lines {
+		io.WriteString(h, line)
+	}
+	return sumPrefix + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// copyDir copies the regular files under src to dst.
+func copyDir(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, in); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	})
+}
@@ -0,0 +1,127 @@
+package gitops
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVendorCUEDependencies(t *testing.T) {
+	// A dependency's repo, with its module under core/ at tag v1.0.0
+	depDir := t.TempDir()
+	repo, err := git.PlainInit(depDir, false)
+	assert.NoError(t, err)
+	wt, err := repo.Worktree()
+	assert.NoError(t, err)
+	for path, contents := range map[string]string{
+		"core/defaults.cue":       "package core\nport: 10808\n",
+		"core/cue.mod/module.cue": "module: \"greymatter.io/gitops-core\"\n",
+		"README.md":               "not part of the module",
+	} {
+		full := filepath.Join(depDir, path)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(full), 0755))
+		assert.NoError(t, os.WriteFile(full, []byte(contents), 0644))
+		_, err := wt.Add(path)
+		assert.NoError(t, err)
+	}
+	hash, err := wt.Commit("core", &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@greymatter.io", When: time.Now()},
+	})
+	assert.NoError(t, err)
+	_, err = repo.CreateTag("v1.0.0", hash, nil)
+	assert.NoError(t, err)
+
+	sum, err := DirSum(filepath.Join(depDir, "core"))
+	assert.NoError(t, err)
+
+	// A config repo checkout listing the dependency
+	s := New(gitRemote, context.Background(), nil, WithCUEDependencies(t.TempDir(), true))
+	s.GitDir = t.TempDir()
+	writeDeps := func(sum string) {
+		deps := fmt.Sprintf("dependencies:\n- module: greymatter.io/gitops-core\n  repo: %s\n  ref: v1.0.0\n  dir: core\n  sum: %q\n", depDir, sum)
+		assert.NoError(t, os.MkdirAll(filepath.Join(s.GitDir, "cue.mod"), 0755))
+		assert.NoError(t, os.WriteFile(filepath.Join(s.GitDir, CUEDependenciesFile), []byte(deps), 0644))
+	}
+	vendored := filepath.Join(s.GitDir, "cue.mod", "pkg", "greymatter.io", "gitops-core")
+
+	writeDeps(sum)
+	assert.NoError(t, s.vendorCUEDependencies(s.GitDir))
+	assert.FileExists(t, filepath.Join(vendored, "defaults.cue"))
+	assert.NoFileExists(t, filepath.Join(vendored, "README.md"))
+	got, err := DirSum(vendored)
+	assert.NoError(t, err)
+	assert.Equal(t, sum, got)
+
+	// An unpinned dependency isn't vendored, but its checksum is reported for pinning
+	writeDeps("")
+	assert.Contains(t, fmt.Sprint(s.vendorCUEDependencies(s.GitDir)), "with sum: "+sum)
+	writeDeps(sum)
+
+	// The cached copy is used once the dependency's repo is unreachable
+	assert.NoError(t, os.RemoveAll(vendored))
+	assert.NoError(t, os.RemoveAll(depDir))
+	assert.NoError(t, s.vendorCUEDependencies(s.GitDir))
+	assert.FileExists(t, filepath.Join(vendored, "defaults.cue"))
+
+	// Without a cached copy, the dependency can't be vendored
+	writeDeps(sumPrefix + "0000")
+	assert.Contains(t, fmt.Sprint(s.vendorCUEDependencies(s.GitDir)), "failed to clone")
+}
+
+func TestVendorCUEDependenciesChecksumMismatch(t *testing.T) {
+	depDir := t.TempDir()
+	repo, err := git.PlainInit(depDir, false)
+	assert.NoError(t, err)
+	wt, err := repo.Worktree()
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(depDir, "core.cue"), []byte("package core\n"), 0644))
+	_, err = wt.Add("core.cue")
+	assert.NoError(t, err)
+	hash, err := wt.Commit("core", &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@greymatter.io", When: time.Now()},
+	})
+	assert.NoError(t, err)
+
+	s := New(gitRemote, context.Background(), nil, WithCUEDependencies(t.TempDir(), true))
+	s.GitDir = t.TempDir()
+	deps := fmt.Sprintf("dependencies:\n- module: greymatter.io/gitops-core\n  repo: %s\n  ref: %s\n  sum: %s0000\n", depDir, hash, sumPrefix)
+	assert.NoError(t, os.MkdirAll(filepath.Join(s.GitDir, "cue.mod"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(s.GitDir, CUEDependenciesFile), []byte(deps), 0644))
+
+	assert.Contains(t, fmt.Sprint(s.vendorCUEDependencies(s.GitDir)), "checksum mismatch")
+	assert.NoDirExists(t, filepath.Join(s.GitDir, "cue.mod", "pkg", "greymatter.io"))
+}
+
+func TestVendorCUEDependenciesRejectsEscapingPaths(t *testing.T) {
+	s := New(gitRemote, context.Background(), nil, WithCUEDependencies(t.TempDir(), true))
+	s.GitDir = t.TempDir()
+	for _, tc := range []struct {
+		module, dir, wantErr string
+	}{
+		{module: "/etc/greymatter", wantErr: `invalid module "/etc/greymatter"`},
+		{module: "../../escape", wantErr: `invalid module "../../escape"`},
+		{module: ".", wantErr: `invalid module "."`},
+		{module: "greymatter.io/gitops-core", dir: "/etc", wantErr: `invalid dir "/etc"`},
+		{module: "greymatter.io/gitops-core", dir: "../..", wantErr: `invalid dir "../.."`},
+		{module: "greymatter.io/gitops-core", dir: "core/../../outside", wantErr: `invalid dir "core/../../outside"`},
+	} {
+		deps := fmt.Sprintf("dependencies:\n- module: %q\n  repo: https://example.com/core.git\n  ref: v1.0.0\n  dir: %q\n  sum: %s0000\n",
+			tc.module, tc.dir, sumPrefix)
+		assert.NoError(t, os.MkdirAll(filepath.Join(s.GitDir, "cue.mod"), 0755))
+		assert.NoError(t, os.WriteFile(filepath.Join(s.GitDir, CUEDependenciesFile), []byte(deps), 0644))
+		assert.Contains(t, fmt.Sprint(s.vendorCUEDependencies(s.GitDir)), tc.wantErr, "module %q dir %q", tc.module, tc.dir)
+	}
+}
+
+func TestSubmoduleRecursion(t *testing.T) {
+	assert.Equal(t, git.DefaultSubmoduleRecursionDepth, New(gitRemote, context.Background(), nil).submoduleRecursion())
+	s := New(gitRemote, context.Background(), nil, WithCUEDependencies("", true))
+	assert.Equal(t, git.NoRecurseSubmodules, s.submoduleRecursion())
+}
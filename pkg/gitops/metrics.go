@@ -0,0 +1,57 @@
+package gitops
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var stateBackupDegraded = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "gm_operator_state_backup_degraded",
+	Help: "1 if Redis is unavailable and the operator's state is only kept in memory, otherwise 0.",
+})
+
+var checkoutRecoveries = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "gm_operator_checkout_recoveries_total",
+	Help: "Number of times the GitOps checkout was found damaged and cloned again.",
+})
+
+var syncCyclesResumed = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "gm_operator_sync_cycles_resumed_total",
+	Help: "Number of sync cycles interrupted when the operator stopped that were re-run on startup.",
+})
+
+var heldDeletions = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "gm_operator_held_deletions",
+	Help: "Number of deletions held back by the deletion policy until they're released, by source (k8s or gm).",
+}, []string{"source"})
+
+var stateObjects = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "gm_operator_state_objects",
+	Help: "Number of objects tracked in the operator's state, by source (k8s or gm).",
+}, []string{"source"})
+
+var stateChangedObjects = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "gm_operator_state_changed_objects",
+	Help: "Number of objects changed in the latest sync cycle, by source (k8s or gm).",
+}, []string{"source"})
+
+var stateDeletedObjects = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "gm_operator_state_deleted_objects",
+	Help: "Number of objects deleted in the latest sync cycle, by source (k8s or gm).",
+}, []string{"source"})
+
+var statePersistedAge = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+	Name: "gm_operator_state_persisted_age_seconds",
+	Help: "Seconds since the operator's state was last persisted to Redis, or since the operator started if it hasn't been.",
+}, func() float64 { return persistedAge().Seconds() })
+
+var stateDiverged = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "gm_operator_state_diverged",
+	Help: "1 if the operator's state has had changes not persisted to Redis for longer than the divergence threshold, otherwise 0.",
+})
+
+func init() {
+	// Served by the manager's metrics endpoint
+	metrics.Registry.MustRegister(stateBackupDegraded, checkoutRecoveries, syncCyclesResumed, heldDeletions,
+		stateObjects, stateChangedObjects, stateDeletedObjects, statePersistedAge, stateDiverged)
+}
@@ -0,0 +1,523 @@
+package gitops
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// OCIScheme prefixes a remote that is an OCI artifact in a registry (e.g. oci://ghcr.io/org/mesh-config),
+// such as one pushed with `oras push`, rather than a git repository. Its tag or digest is set as the sync's tag.
+const OCIScheme = "oci://"
+
+// Media types of the manifests accepted for an OCI artifact, and annotations ORAS sets on its layers:
+// the name of the file or directory a layer holds, and whether it's a directory to unpack.
+const (
+	ociManifestMediaType    = "application/vnd.oci.image.manifest.v1+json"
+	dockerManifestMediaType = "application/vnd.docker.distribution.manifest.v2+json"
+	ociTitleAnnotation      = "org.opencontainers.image.title"
+	orasUnpackAnnotation    = "io.deis.oras.content.unpack"
+)
+
+// artifactDigestFile records, in a checkout of an OCI artifact, the digest of the manifest it was unpacked from.
+const artifactDigestFile = ".oci-digest"
+
+// maxManifestSize limits the size of the manifests read from a registry.
+const maxManifestSize = 4 << 20
+
+// IsOCI reports whether remote is an OCI artifact rather than a git repository.
+func IsOCI(remote string) bool {
+	return strings.HasPrefix(remote, OCIScheme)
+}
+
+// ociReference is an OCI artifact's registry host, repository, and tag or digest.
+type ociReference struct {
+	Registry   string
+	Repository string
+	Reference  string
+}
+
+// parseOCIReference parses an oci:// remote and the tag or digest of the artifact, which defaults to latest.
+func parseOCIReference(remote, tag string) (ociReference, error) {
+	name := strings.TrimPrefix(remote, OCIScheme)
+	i := strings.Index(name, "/")
+	if i <= 0 || i == len(name)-1 {
+		return ociReference{}, fmt.Errorf("invalid OCI artifact %q; expected %sregistry/repository", remote, OCIScheme)
+	}
+	ref := ociReference{Registry: name[:i], Repository: name[i+1:], Reference: tag}
+	if strings.ContainsAny(ref.Repository, ":@") {
+		return ociReference{}, fmt.Errorf("invalid OCI artifact %q; set its tag or digest as the tag, not in the remote", remote)
+	}
+	if ref.Reference == "" {
+		ref.Reference = "latest"
+	}
+	return ref, nil
+}
+
+// pinned reports whether the reference is a digest rather than a tag.
+func (r ociReference) pinned() bool {
+	return strings.HasPrefix(r.Reference, sumPrefix)
+}
+
+// ociDescriptor describes a blob in a registry.
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type ociManifest struct {
+	MediaType string          `json:"mediaType"`
+	Layers    []ociDescriptor `json:"layers"`
+}
+
+// artifactDigest returns the digest of the artifact unpacked in dir, or "" if it isn't a checkout of one.
+func artifactDigest(dir string) string {
+	b, err := os.ReadFile(filepath.Join(dir, artifactDigestFile))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// artifactUpdate fetches the manifest of the sync's OCI artifact and returns its digest. If the digest differs from
+// the checkout's, or force is set, the artifact is unpacked into a fresh directory beside the checkout, which is
+// swapped in once complete, as with reclone. If artifact verification is configured, each new digest's signatures
+// are verified first.
+func (s *Sync) artifactUpdate(force bool) (string, error) {
+	if s.GitDir == "" {
+		return "", errors.New("no directory configured for the OCI artifact's checkout")
+	}
+	if s.Verification.Enabled() {
+		// Fail closed, rather than applying config that can't be verified as configured
+		return "", fmt.Errorf("%w: OCI artifacts have no commit signatures to verify; verify their cosign signatures instead", ErrUnverifiedCommit)
+	}
+	reg, err := s.registry()
+	if err != nil {
+		return "", err
+	}
+	manifest, digest, err := reg.manifest(s.ctx)
+	if err != nil {
+		return "", err
+	}
+	// Verify the artifact before anything is unpacked from it, or before a checkout kept across a restart is used
+	if s.ArtifactVerification.Enabled() && digest != s.verifiedDigest {
+		if err := reg.verifySignatures(s.ctx, s.ArtifactVerification, digest); err != nil {
+			return "", err
+		}
+		s.verifiedDigest = digest
+	}
+	if !force && digest == artifactDigest(s.GitDir) {
+		return digest, nil
+	}
+
+	logger.Info("Pulling OCI artifact", "Remote", s.Remote, "Reference", reg.ref.Reference, "Digest", digest, "Dir", s.GitDir)
+	dir := filepath.Clean(s.GitDir)
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return "", err
+	}
+	fresh, err := os.MkdirTemp(filepath.Dir(dir), filepath.Base(dir)+freshSuffix)
+	if err != nil {
+		return "", fmt.Errorf("failed to create directory for the OCI artifact: %w", err)
+	}
+	if err := reg.unpack(s.ctx, manifest, fresh); err == nil {
+		if err = s.vendorCUEDependencies(fresh); err == nil {
+			err = os.WriteFile(filepath.Join(fresh, artifactDigestFile), []byte(digest+"\n"), 0644)
+		}
+	}
+	if err != nil {
+		os.RemoveAll(fresh)
+		return "", err
+	}
+	if err := swapCheckout(dir, fresh); err != nil {
+		return "", err
+	}
+	return digest, nil
+}
+
+// registry returns a client for the registry of the sync's OCI artifact, authenticating with the username and token
+// of the in-memory credentials, if set.
+func (s *Sync) registry() (*registry, error) {
+	ref, err := parseOCIReference(s.Remote, s.Tag)
+	if err != nil {
+		return nil, err
+	}
+	if s.Branch != "" {
+		return nil, fmt.Errorf("OCI artifacts have no branches; set a tag or digest instead of branch '%s'", s.Branch)
+	}
+
+	var t *http.Transport
+	if s.httpTransport != nil {
+		t = s.httpTransport.Clone()
+	} else {
+		t = http.DefaultTransport.(*http.Transport).Clone()
+	}
+	if s.InsecureSkipVerify {
+		if t.TLSClientConfig == nil {
+			t.TLSClientConfig = &tls.Config{}
+		}
+		t.TLSClientConfig.InsecureSkipVerify = true
+	}
+
+	s.credentialsMu.Lock()
+	creds := s.credentials
+	s.credentialsMu.Unlock()
+	return &registry{
+		client:   &http.Client{Transport: t, Timeout: 5 * time.Minute},
+		ref:      ref,
+		username: creds.Username,
+		password: creds.Token,
+	}, nil
+}
+
+// registry pulls an artifact over the OCI distribution API, with basic auth or a bearer token as the registry asks.
+type registry struct {
+	client             *http.Client
+	ref                ociReference
+	username, password string
+	// Set once the registry has challenged for it
+	basic bool
+	token string
+}
+
+// manifest fetches the artifact's manifest and returns it with its digest.
+func (r *registry) manifest(ctx context.Context) (ociManifest, string, error) {
+	var m ociManifest
+	resp, err := r.get(ctx, "/manifests/"+r.ref.Reference, ociManifestMediaType+", "+dockerManifestMediaType)
+	if err != nil {
+		return m, "", err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxManifestSize+1))
+	if err != nil {
+		return m, "", fmt.Errorf("failed to read manifest of %s: %w", r, err)
+	}
+	if len(b) > maxManifestSize {
+		return m, "", fmt.Errorf("manifest of %s is larger than %d bytes", r, maxManifestSize)
+	}
+	sum := sha256.Sum256(b)
+	digest := sumPrefix + hex.EncodeToString(sum[:])
+	if r.ref.pinned() && digest != r.ref.Reference {
+		return m, "", fmt.Errorf("digest mismatch for manifest of %s: got %s", r, digest)
+	}
+
+	if err := json.Unmarshal(b, &m); err != nil {
+		return m, "", fmt.Errorf("failed to parse manifest of %s: %w", r, err)
+	}
+	mediaType := m.MediaType
+	if mediaType == "" {
+		mediaType = strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0])
+	}
+	if mediaType != ociManifestMediaType && mediaType != dockerManifestMediaType {
+		return m, "", fmt.Errorf("%s is a %q rather than an artifact manifest", r, mediaType)
+	}
+	if len(m.Layers) == 0 {
+		return m, "", fmt.Errorf("%s has no layers", r)
+	}
+	return m, digest, nil
+}
+
+// unpack writes the artifact's layers into dir: tar layers are extracted, and any other layer is written
+// as the file its title annotation names.
+func (r *registry) unpack(ctx context.Context, m ociManifest, dir string) error {
+	for _, layer := range m.Layers {
+		if !strings.HasPrefix(layer.Digest, sumPrefix) {
+			return fmt.Errorf("unsupported digest %q for layer of %s", layer.Digest, r)
+		}
+		blob, err := r.blob(ctx, layer.Digest)
+		if err != nil {
+			return err
+		}
+		err = unpackLayer(blob, layer, dir)
+		blob.Close()
+		os.Remove(blob.Name())
+		if err != nil {
+			return fmt.Errorf("failed to unpack layer %s of %s: %w", layer.Digest, r, err)
+		}
+	}
+	return nil
+}
+
+// blob downloads a blob to a temporary file, verifies its digest, and returns the file rewound for reading.
+func (r *registry) blob(ctx context.Context, digest string) (*os.File, error) {
+	resp, err := r.get(ctx, "/blobs/"+digest, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	f, err := os.CreateTemp("", "oci-blob-")
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	if _, err = io.Copy(io.MultiWriter(f, h), resp.Body); err == nil {
+		if got := sumPrefix + hex.EncodeToString(h.Sum(nil)); got != digest {
+			err = fmt.Errorf("digest mismatch for blob %s of %s: got %s", digest, r, got)
+		} else {
+			_, err = f.Seek(0, io.SeekStart)
+		}
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return f, nil
+}
+
+// unpackLayer extracts a tar layer into dir, or writes any other layer as the file named by its title.
+func unpackLayer(blob io.Reader, layer ociDescriptor, dir string) error {
+	title := layer.Annotations[ociTitleAnnotation]
+	isTar := strings.Contains(layer.MediaType, ".tar") || layer.Annotations[orasUnpackAnnotation] == "true"
+	if !isTar {
+		name, ok := localPath(title)
+		if !ok {
+			return fmt.Errorf("invalid file name %q", title)
+		}
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, blob); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	}
+
+	if strings.HasSuffix(layer.MediaType, "+gzip") || strings.HasSuffix(layer.MediaType, ".gzip") {
+		gz, err := gzip.NewReader(blob)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		blob = gz
+	}
+	return unpackTar(blob, dir)
+}
+
+// unpackTar extracts the files and directories of a tarball into dir.
+func unpackTar(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name, ok := localPath(hdr.Name)
+		if !ok {
+			return fmt.Errorf("invalid path %q in tarball", hdr.Name)
+		}
+		path := filepath.Join(dir, name)
+		// Only files and directories are unpacked; links could point outside the checkout
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(out, tr); err != nil {
+				out.Close()
+				return err
+			}
+			if err := out.Close(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// localPath cleans a slash-separated name from an artifact, reporting whether it stays within the directory it's
+// unpacked into.
+func localPath(name string) (string, bool) {
+	path := filepath.Clean(filepath.FromSlash(name))
+	if name == "" || filepath.IsAbs(path) || path == ".." || strings.HasPrefix(path, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return path, true
+}
+
+// get requests a path under the artifact's repository, answering the registry's auth challenge if it makes one.
+func (r *registry) get(ctx context.Context, path, accept string) (*http.Response, error) {
+	u := fmt.Sprintf("https://%s/v2/%s%s", r.ref.Registry, r.ref.Repository, path)
+	resp, err := r.do(ctx, u, accept)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized && !r.basic && r.token == "" {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		if err := r.authorize(ctx, challenge); err != nil {
+			return nil, err
+		}
+		if resp, err = r.do(ctx, u, accept); err != nil {
+			return nil, err
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to fetch %s from %s: %s", path, r, resp.Status)
+	}
+	return resp, nil
+}
+
+func (r *registry) do(ctx context.Context, u, accept string) (*http.Response, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	switch {
+	case r.token != "":
+		req.Header.Set("Authorization", "Bearer "+r.token)
+	case r.basic:
+		req.SetBasicAuth(r.username, r.password)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach %s: %w", r, err)
+	}
+	return resp, nil
+}
+
+// authorize answers a registry's auth challenge: with basic auth, or by fetching a bearer token
+// for pulling the repository from the registry's token service.
+func (r *registry) authorize(ctx context.Context, challenge string) error {
+	scheme, params := parseChallenge(challenge)
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if r.password == "" {
+			return fmt.Errorf("%s requires credentials", r)
+		}
+		r.basic = true
+		return nil
+	case "bearer":
+	default:
+		return fmt.Errorf("unsupported auth challenge %q from %s", challenge, r)
+	}
+
+	realm, err := url.Parse(params["realm"])
+	if err != nil || realm.Scheme == "" {
+		return fmt.Errorf("invalid token realm %q from %s", params["realm"], r)
+	}
+	q := realm.Query()
+	if params["service"] != "" {
+		q.Set("service", params["service"])
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = fmt.Sprintf("repository:%s:pull", r.ref.Repository)
+	}
+	q.Set("scope", scope)
+	realm.RawQuery = q.Encode()
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return err
+	}
+	if r.password != "" {
+		req.SetBasicAuth(r.username, r.password)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to get token for %s: %w", r, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to get token for %s: %s", r, resp.Status)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return fmt.Errorf("failed to parse token for %s: %w", r, err)
+	}
+	if r.token = token.Token; r.token == "" {
+		r.token = token.AccessToken
+	}
+	if r.token == "" {
+		return fmt.Errorf("no token for %s", r)
+	}
+	return nil
+}
+
+func (r *registry) String() string {
+	sep := ":"
+	if r.ref.pinned() {
+		sep = "@"
+	}
+	return OCIScheme + r.ref.Registry + "/" + r.ref.Repository + sep + r.ref.Reference
+}
+
+// parseChallenge parses a WWW-Authenticate header into its scheme and parameters,
+// e.g. `Bearer realm="https://auth.example.com/token",service="registry.example.com"`.
+func parseChallenge(challenge string) (string, map[string]string) {
+	params := map[string]string{}
+	challenge = strings.TrimSpace(challenge)
+	i := strings.IndexByte(challenge, ' ')
+	if i < 0 {
+		return challenge, params
+	}
+	scheme, rest := challenge[:i], challenge[i+1:]
+	for rest != "" {
+		rest = strings.TrimLeft(rest, " ,")
+		eq := strings.IndexByte(rest, '=')
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(rest[:eq]))
+		rest = rest[eq+1:]
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else if comma := strings.IndexByte(rest, ','); comma >= 0 {
+			value, rest = rest[:comma], rest[comma+1:]
+		} else {
+			value, rest = rest, ""
+		}
+		params[key] = value
+	}
+	return scheme, params
+}
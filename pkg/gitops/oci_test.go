@@ -0,0 +1,217 @@
+package gitops
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeRegistry serves artifacts over the OCI distribution API, requiring a bearer token from its token service.
+type fakeRegistry struct {
+	*httptest.Server
+	mu        sync.Mutex
+	manifests map[string][]byte
+	blobs     map[string][]byte
+	// Requests for manifests and blobs
+	resolves, pulls int
+}
+
+func newFakeRegistry(t *testing.T) *fakeRegistry {
+	r := &fakeRegistry{manifests: map[string][]byte{}, blobs: map[string][]byte{}}
+	r.Server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if req.URL.Path == "/token" {
+			if user, pass, _ := req.BasicAuth(); user != "robot" || pass != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"token": "pull"}`)
+			return
+		}
+		if req.Header.Get("Authorization") != "Bearer pull" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="fake",scope="repository:mesh/config:pull"`, r.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch path := strings.TrimPrefix(req.URL.Path, "/v2/mesh/config"); {
+		case strings.HasPrefix(path, "/manifests/"):
+			if b, ok := r.manifests[strings.TrimPrefix(path, "/manifests/")]; ok {
+				r.resolves++
+				w.Header().Set("Content-Type", ociManifestMediaType)
+				w.Write(b)
+				return
+			}
+		case strings.HasPrefix(path, "/blobs/"):
+			if b, ok := r.blobs[strings.TrimPrefix(path, "/blobs/")]; ok {
+				r.pulls++
+				w.Write(b)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+// push stores an artifact with a tar+gzip layer of the given files under tag, and returns its digest.
+func (r *fakeRegistry) push(t *testing.T, tag string, files map[string]string) string {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, contents := range files {
+		assert.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(contents)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write([]byte(contents))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, tw.Close())
+	assert.NoError(t, gz.Close())
+
+	digest := func(b []byte) string {
+		sum := sha256.Sum256(b)
+		return sumPrefix + hex.EncodeToString(sum[:])
+	}
+	layer := buf.Bytes()
+	manifest, err := json.Marshal(ociManifest{
+		MediaType: ociManifestMediaType,
+		Layers: []ociDescriptor{{
+			MediaType:   "application/vnd.oci.image.layer.v1.tar+gzip",
+			Digest:      digest(layer),
+			Size:        int64(len(layer)),
+			Annotations: map[string]string{ociTitleAnnotation: "config", orasUnpackAnnotation: "true"},
+		}},
+	})
+	assert.NoError(t, err)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.blobs[digest(layer)] = layer
+	r.manifests[tag] = manifest
+	r.manifests[digest(manifest)] = manifest
+	return digest(manifest)
+}
+
+func (r *fakeRegistry) remote() string {
+	return OCIScheme + strings.TrimPrefix(r.URL, "https://") + "/mesh/config"
+}
+
+func TestParseOCIReference(t *testing.T) {
+	ref, err := parseOCIReference("oci://ghcr.io/greymatter-io/gitops-core", "")
+	assert.NoError(t, err)
+	assert.Equal(t, ociReference{Registry: "ghcr.io", Repository: "greymatter-io/gitops-core", Reference: "latest"}, ref)
+
+	ref, err = parseOCIReference("oci://localhost:5000/mesh", "sha256:abc")
+	assert.NoError(t, err)
+	assert.True(t, ref.pinned())
+
+	for _, remote := range []string{"oci://ghcr.io", "oci://ghcr.io/", "oci://ghcr.io/mesh:v1"} {
+		_, err := parseOCIReference(remote, "")
+		assert.Error(t, err, remote)
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	scheme, params := parseChallenge(`Bearer realm="https://auth.example.com/token",service=registry,scope="repository:a:pull,push"`)
+	assert.Equal(t, "Bearer", scheme)
+	assert.Equal(t, map[string]string{
+		"realm":   "https://auth.example.com/token",
+		"service": "registry",
+		"scope":   "repository:a:pull,push",
+	}, params)
+}
+
+func TestArtifactUpdate(t *testing.T) {
+	reg := newFakeRegistry(t)
+	first := reg.push(t, "v1", map[string]string{"config/gm/outputs/mesh.cue": `zone: "first"`})
+
+	s := New(reg.remote(), context.Background(), nil, WithRepoInfo(reg.remote(), "", "v1"),
+		WithHostKeyVerification("", true), WithGitCredentials(GitCredentials{Username: "robot", Token: "secret"}))
+	s.GitDir = filepath.Join(t.TempDir(), "checkout")
+	assert.NoError(t, s.Bootstrap())
+	b, err := os.ReadFile(filepath.Join(s.GitDir, "config/gm/outputs/mesh.cue"))
+	assert.NoError(t, err)
+	assert.Equal(t, `zone: "first"`, string(b))
+	assert.Equal(t, first, headSHA(s.GitDir))
+
+	// An unchanged artifact isn't pulled again
+	digest, err := s.update()
+	assert.NoError(t, err)
+	assert.Equal(t, first, digest)
+	assert.Equal(t, 1, reg.pulls)
+
+	// A retagged artifact replaces the checkout
+	second := reg.push(t, "v1", map[string]string{"config/gm/outputs/mesh.cue": `zone: "second"`})
+	digest, err = s.update()
+	assert.NoError(t, err)
+	assert.Equal(t, second, digest)
+	b, err = os.ReadFile(filepath.Join(s.GitDir, "config/gm/outputs/mesh.cue"))
+	assert.NoError(t, err)
+	assert.Equal(t, `zone: "second"`, string(b))
+
+	// Pinning a digest verifies the manifest against it
+	s.Tag = first
+	digest, err = s.update()
+	assert.NoError(t, err)
+	assert.Equal(t, first, digest)
+	s.Tag = sumPrefix + strings.Repeat("0", 64)
+	_, err = s.update()
+	assert.Error(t, err)
+
+	// Without credentials, no token is granted
+	s.Tag = "v1"
+	s.SetGitCredentials(GitCredentials{})
+	_, err = s.update()
+	assert.Contains(t, fmt.Sprint(err), "401")
+	assert.Equal(t, first, headSHA(s.GitDir), "a failed pull leaves the checkout in place")
+}
+
+func TestWatchArtifact(t *testing.T) {
+	reg := newFakeRegistry(t)
+	reg.push(t, "latest", map[string]string{"gm/outputs/mesh.cue": `zone: "first"`})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := New(reg.remote(), ctx, cancel, WithHostKeyVerification("", true),
+		WithGitCredentials(GitCredentials{Username: "robot", Token: "secret"}))
+	s.GitDir = filepath.Join(t.TempDir(), "checkout")
+	s.Interval = 1
+	assert.NoError(t, s.Bootstrap())
+
+	synced := make(chan string, 1)
+	s.OnSyncCompleted = func() error {
+		b, err := os.ReadFile(filepath.Join(s.GitDir, "gm/outputs/mesh.cue"))
+		synced <- string(b)
+		return err
+	}
+	go s.Watch()
+
+	// Once Watch has resolved the first artifact, push another
+	assert.Eventually(t, func() bool {
+		reg.mu.Lock()
+		defer reg.mu.Unlock()
+		return reg.resolves > 1
+	}, 10*time.Second, 10*time.Millisecond)
+	reg.push(t, "latest", map[string]string{"gm/outputs/mesh.cue": `zone: "second"`})
+	select {
+	case contents := <-synced:
+		assert.Equal(t, `zone: "second"`, contents)
+	case <-time.After(10 * time.Second):
+		t.Fatal("the new artifact was not synced")
+	}
+}
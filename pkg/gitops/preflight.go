@@ -0,0 +1,87 @@
+package gitops
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/go-redis/redis/v9"
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+)
+
+// CheckRemote reports whether the config source can be reached with the sync's credentials, without fetching it: a
+// git remote must list the branch or tag, and an OCI registry must serve the artifact's manifest.
+func (s *Sync) CheckRemote(ctx context.Context) error {
+	if s.Remote == "" {
+		return nil
+	}
+	if IsOCI(s.Remote) {
+		r, err := s.registry()
+		if err != nil {
+			return err
+		}
+		if _, _, err := r.manifest(ctx); err != nil {
+			return fmt.Errorf("failed to fetch the manifest of %s: %w", r, err)
+		}
+		return nil
+	}
+
+	auth, err := s.auth()
+	if err != nil {
+		return err
+	}
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{Name: "origin", URLs: []string{s.Remote}})
+	refs, err := remote.ListContext(ctx, &git.ListOptions{Auth: auth, InsecureSkipTLS: s.insecureSkipTLS()})
+	if err != nil {
+		return fmt.Errorf("failed to list the refs of %s: %w", s.Remote, err)
+	}
+	want := plumbing.NewBranchReferenceName(s.Branch)
+	if s.Tag != "" {
+		want = plumbing.NewTagReferenceName(s.Tag)
+	}
+	for _, ref := range refs {
+		if ref.Name() == want {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s has no %s", s.Remote, want)
+}
+
+// CheckStateStore reports whether the state store set by the sync's options and the CUE defaults can be used: Redis
+// must answer a ping, or the state file's directory must be writable.
+func (s *Sync) CheckStateStore(ctx context.Context, operatorCUE *cuemodule.OperatorCUE) error {
+	_, defaults := s.stateDefaults(operatorCUE)
+	if defaults.StateFile != "" {
+		dir := filepath.Dir(defaults.StateFile)
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return fmt.Errorf("failed to create directory of state file %s: %w", defaults.StateFile, err)
+		}
+		f, err := os.CreateTemp(dir, ".preflight-")
+		if err != nil {
+			return fmt.Errorf("directory of state file %s is not writable: %w", defaults.StateFile, err)
+		}
+		f.Close()
+		return os.Remove(f.Name())
+	}
+
+	addr := fmt.Sprintf("%s:%d", defaults.RedisHost, defaults.RedisPort)
+	rdb := redis.NewClient(&redis.Options{
+		Addr:        addr,
+		DB:          defaults.RedisDB,
+		Username:    defaults.RedisUsername,
+		Password:    defaults.RedisPassword,
+		MaxRetries:  -1,
+		DialTimeout: 5 * time.Second,
+	})
+	defer rdb.Close()
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("failed to reach Redis at %s: %w", addr, err)
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package gitops
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SyncReport collects the outcome of every object applied or deleted during a single sync cycle,
+// so config authors can see which of their objects landed without reading operator logs.
+// It is safe for concurrent use, since Grey Matter objects are applied asynchronously.
+type SyncReport struct {
+	mu      sync.Mutex
+	commit  string
+	started time.Time
+	results map[string]ObjectResult
+	version uint64
+}
+
+// ObjectResult is the latest outcome of applying or deleting a single object.
+type ObjectResult struct {
+	// "k8s" or "gm"
+	Source string `json:"source"`
+	// The K8s kind (e.g. Deployment) or GM kind (e.g. cluster)
+	Kind string `json:"kind"`
+	// The K8s namespace, or the GM zone (mesh ID for catalogservices)
+	Scope string `json:"scope,omitempty"`
+	// The K8s name or GM object key
+	Name string `json:"name"`
+	// "apply" or "delete"
+	Action  string    `json:"action"`
+	Success bool      `json:"success"`
+	Error   string    `json:"error,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// NewSyncReport returns an empty report for the given commit SHA.
+func NewSyncReport(commit string) *SyncReport {
+	return &SyncReport{
+		commit:  commit,
+		started: time.Now(),
+		results: make(map[string]ObjectResult),
+	}
+}
+
+// Record stores the outcome of an action on an object, replacing any earlier outcome
+// for the same object (e.g. when a failed Grey Matter command is requeued and succeeds).
+func (r *SyncReport) Record(source, kind, scope, name, action string, err error) {
+	if r == nil {
+		return
+	}
+	res := ObjectResult{
+		Source:  source,
+		Kind:    kind,
+		Scope:   scope,
+		Name:    name,
+		Action:  action,
+		Success: err == nil,
+		Time:    time.Now(),
+	}
+	if err != nil {
+		res.Error = err.Error()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.results[fmt.Sprintf("%s/%s/%s/%s", source, kind, scope, name)] = res
+	r.version++
+}
+
+// Version returns a counter that increases every time a result is recorded.
+func (r *SyncReport) Version() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.version
+}
+
+// Commit returns the SHA of the commit this report describes.
+func (r *SyncReport) Commit() string {
+	return r.commit
+}
+
+// Summary counts the applied, deleted, and failed objects in the report,
+// and describes each failure.
+func (r *SyncReport) Summary() (applied, deleted, failed int, failures []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, res := range r.results {
+		if !res.Success {
+			failed++
+			failures = append(failures, fmt.Sprintf("%s %s %s/%s: %s", res.Action, res.Kind, res.Scope, res.Name, res.Error))
+			continue
+		}
+		switch res.Action {
+		case "apply":
+			applied++
+		case "delete":
+			deleted++
+		}
+	}
+	sort.Strings(failures)
+	return
+}
+
+// MarshalJSON renders the report with results in a stable order.
+func (r *SyncReport) MarshalJSON() ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	out := struct {
+		Commit    string         `json:"commit"`
+		Started   time.Time      `json:"started"`
+		Succeeded int            `json:"succeeded"`
+		Failed    int            `json:"failed"`
+		Objects   []ObjectResult `json:"objects"`
+	}{
+		Commit:  r.commit,
+		Started: r.started,
+		Objects: make([]ObjectResult, 0, len(r.results)),
+	}
+
+	keys := make([]string, 0, len(r.results))
+	for k := range r.results {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		res := r.results[k]
+		if res.Success {
+			out.Succeeded++
+		} else {
+			out.Failed++
+		}
+		out.Objects = append(out.Objects, res)
+	}
+
+	return json.Marshal(out)
+}
+
+// BeginReport starts a fresh report for a new sync cycle and returns it.
+func (s *Sync) BeginReport(commit string) *SyncReport {
+	s.reportMu.Lock()
+	defer s.reportMu.Unlock()
+	s.report = NewSyncReport(commit)
+	return s.report
+}
+
+// CurrentReport returns the report for the latest sync cycle, or nil if none has started.
+func (s *Sync) CurrentReport() *SyncReport {
+	s.reportMu.Lock()
+	defer s.reportMu.Unlock()
+	return s.report
+}
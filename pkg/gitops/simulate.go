@@ -0,0 +1,126 @@
+package gitops
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"sort"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// SimulatedChanges are the objects a sync cycle would create, change, and delete, as computed by SyncState.Simulate.
+type SimulatedChanges struct {
+	K8s SimulatedK8sChanges `json:"k8s"`
+	GM  SimulatedGMChanges  `json:"gm"`
+}
+
+// SimulatedK8sChanges are the K8s objects a sync cycle would create, change, and delete.
+type SimulatedK8sChanges struct {
+	Created []K8sObjectRef `json:"created"`
+	Changed []K8sObjectRef `json:"changed"`
+	Deleted []K8sObjectRef `json:"deleted"`
+	// Whether the deletion policy would hold the deletions back until they're released
+	DeletionsHeld bool `json:"deletions_held"`
+}
+
+// SimulatedGMChanges are the Grey Matter config objects a sync cycle would create, change, and delete.
+type SimulatedGMChanges struct {
+	Created []GMObjectRef `json:"created"`
+	Changed []GMObjectRef `json:"changed"`
+	Deleted []GMObjectRef `json:"deleted"`
+	// Whether the deletion policy would hold the deletions back until they're released
+	DeletionsHeld bool `json:"deletions_held"`
+}
+
+// Empty reports whether the sync cycle would change nothing.
+func (sc SimulatedChanges) Empty() bool {
+	return len(sc.K8s.Created)+len(sc.K8s.Changed)+len(sc.K8s.Deleted)+
+		len(sc.GM.Created)+len(sc.GM.Changed)+len(sc.GM.Deleted) == 0
+}
+
+// Simulate returns what the next sync cycle would create, change, and delete if it applied the given K8s manifests
+// and Grey Matter config objects (with kinds as identified by cuemodule.IdentifyGMConfigObjects), as
+// FilterChangedK8s and FilterChangedGM would find them, but without changing the state.
+func (ss *SyncState) Simulate(manifestObjects []client.Object, configObjects []json.RawMessage, kinds []string) SimulatedChanges {
+	ss.deletions.mu.Lock()
+	policy := ss.deletions.policy
+	ss.deletions.mu.Unlock()
+	sc := SimulatedChanges{
+		K8s: SimulatedK8sChanges{Created: []K8sObjectRef{}, Changed: []K8sObjectRef{}, Deleted: []K8sObjectRef{}},
+		GM:  SimulatedGMChanges{Created: []GMObjectRef{}, Changed: []GMObjectRef{}, Deleted: []GMObjectRef{}},
+	}
+
+	ss.hashesMu.RLock()
+	previousK8s, previousGM := ss.previousK8sHashes, ss.previousGMHashes
+	k8sCycle, gmCycle := ss.k8sCycle, ss.gmCycle
+	ss.hashesMu.RUnlock()
+
+	seenK8s := make(map[string]bool, len(manifestObjects))
+	for _, manifestObject := range manifestObjects {
+		ref := NewK8sObjectRef(manifestObject)
+		key := ref.HashKey()
+		seenK8s[key] = true
+		if prev, ok := previousK8s[key]; !ok {
+			sc.K8s.Created = append(sc.K8s.Created, *ref)
+		} else if !prev.unchanged(manifestObject, ref) {
+			sc.K8s.Changed = append(sc.K8s.Changed, *ref)
+		}
+	}
+	for key, prev := range previousK8s {
+		if !seenK8s[key] && ss.expired(prev.LastSeen, k8sCycle+1) {
+			sc.K8s.Deleted = append(sc.K8s.Deleted, prev)
+		}
+	}
+	sc.K8s.DeletionsHeld = policy.holds(len(sc.K8s.Deleted), len(previousK8s))
+
+	seenGM := make(map[string]bool, len(configObjects))
+	for idx, objBytes := range configObjects {
+		ref := NewGMObjectRef(objBytes, kinds[idx])
+		key := ref.HashKey()
+		seenGM[key] = true
+		if prev, ok := previousGM[key]; !ok {
+			sc.GM.Created = append(sc.GM.Created, *ref)
+		} else if !prev.unchanged(objBytes, ref) {
+			sc.GM.Changed = append(sc.GM.Changed, *ref)
+		}
+	}
+	for key, prev := range previousGM {
+		if !seenGM[key] && ss.expired(prev.LastSeen, gmCycle+1) {
+			sc.GM.Deleted = append(sc.GM.Deleted, prev)
+		}
+	}
+	sc.GM.DeletionsHeld = policy.holds(len(sc.GM.Deleted), len(previousGM))
+
+	for _, refs := range [][]K8sObjectRef{sc.K8s.Created, sc.K8s.Changed, sc.K8s.Deleted} {
+		sort.Slice(refs, func(i, j int) bool { return refs[i].HashKey() < refs[j].HashKey() })
+	}
+	for _, refs := range [][]GMObjectRef{sc.GM.Created, sc.GM.Changed, sc.GM.Deleted} {
+		sort.Slice(refs, func(i, j int) bool { return refs[i].HashKey() < refs[j].HashKey() })
+	}
+	return sc
+}
+
+// UnpackTree unpacks a config tree uploaded as a tarball (gzipped or not), e.g. by `git archive`, into dir, vendoring
+// its CUE dependencies as they would be for a checkout.
+func (s *Sync) UnpackTree(r io.Reader, dir string) error {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		return s.unpackTree(gz, dir)
+	}
+	return s.unpackTree(br, dir)
+}
+
+func (s *Sync) unpackTree(r io.Reader, dir string) error {
+	if err := unpackTar(r, dir); err != nil {
+		return err
+	}
+	return s.vendorCUEDependencies(dir)
+}
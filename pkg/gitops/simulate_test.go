@@ -0,0 +1,90 @@
+package gitops
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestSimulate(t *testing.T) {
+	edge := json.RawMessage(`{"cluster_key": "edge", "zone_key": "default-zone"}`)
+	catalog := json.RawMessage(`{"cluster_key": "catalog", "zone_key": "default-zone"}`)
+	control := json.RawMessage(`{"cluster_key": "control", "zone_key": "default-zone"}`)
+	mkService := func(name string, port int32) client.Object {
+		svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "greymatter"}}
+		svc.Spec.Ports = []corev1.ServicePort{{Port: port}}
+		return svc
+	}
+	ss := &SyncState{previousGMHashes: make(map[string]GMObjectRef), previousK8sHashes: make(map[string]K8sObjectRef)}
+	ss.FilterChangedGM([]json.RawMessage{edge, catalog}, []string{"cluster", "cluster"})
+	ss.FilterChangedK8s([]client.Object{mkService("edge", 10808), mkService("catalog", 8080)})
+	ss.SetDeletionPolicy(DeletionPolicy{MaxPercent: 40})
+
+	changedEdge := json.RawMessage(`{"cluster_key": "edge", "zone_key": "default-zone", "name": "edge"}`)
+	sc := ss.Simulate([]client.Object{mkService("edge", 10809)}, []json.RawMessage{changedEdge, control}, []string{"cluster", "cluster"})
+	assert.False(t, sc.Empty())
+	if assert.Len(t, sc.GM.Created, 1) && assert.Len(t, sc.GM.Changed, 1) && assert.Len(t, sc.GM.Deleted, 1) {
+		assert.Equal(t, "control", sc.GM.Created[0].ID)
+		assert.Equal(t, "edge", sc.GM.Changed[0].ID)
+		assert.Equal(t, "catalog", sc.GM.Deleted[0].ID)
+	}
+	assert.True(t, sc.GM.DeletionsHeld, "deleting half the objects exceeds the policy")
+	assert.Empty(t, sc.K8s.Created)
+	if assert.Len(t, sc.K8s.Changed, 1) && assert.Len(t, sc.K8s.Deleted, 1) {
+		assert.Equal(t, "edge", sc.K8s.Changed[0].Name)
+		assert.Equal(t, "catalog", sc.K8s.Deleted[0].Name)
+	}
+
+	// The state is left as it was, so the applied config changes nothing
+	assert.True(t, ss.Simulate([]client.Object{mkService("edge", 10808), mkService("catalog", 8080)}, []json.RawMessage{edge, catalog}, []string{"cluster", "cluster"}).Empty())
+	filtered, _, deleted := ss.FilterChangedGM([]json.RawMessage{edge, catalog}, []string{"cluster", "cluster"})
+	assert.Empty(t, filtered)
+	assert.Empty(t, deleted)
+}
+
+func TestUnpackTree(t *testing.T) {
+	mkTarball := func(files map[string]string, compress bool) []byte {
+		var buf bytes.Buffer
+		var gz *gzip.Writer
+		tw := tar.NewWriter(&buf)
+		if compress {
+			gz = gzip.NewWriter(&buf)
+			tw = tar.NewWriter(gz)
+		}
+		for name, content := range files {
+			assert.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+			_, err := tw.Write([]byte(content))
+			assert.NoError(t, err)
+		}
+		assert.NoError(t, tw.Close())
+		if gz != nil {
+			assert.NoError(t, gz.Close())
+		}
+		return buf.Bytes()
+	}
+	s := &Sync{}
+
+	for _, compress := range []bool{true, false} {
+		dir := t.TempDir()
+		tree := mkTarball(map[string]string{"cue.mod/module.cue": `module: "greymatter.io/gitops-core"`, "inputs.cue": "package only"}, compress)
+		if !assert.NoError(t, s.UnpackTree(bytes.NewReader(tree), dir)) {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(dir, "inputs.cue"))
+		assert.NoError(t, err)
+		assert.Equal(t, "package only", string(b))
+	}
+
+	err := s.UnpackTree(bytes.NewReader(mkTarball(map[string]string{"../escape.cue": "package only"}, true)), t.TempDir())
+	assert.Contains(t, fmt.Sprint(err), `invalid path "../escape.cue" in tarball`)
+}
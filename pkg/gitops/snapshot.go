@@ -0,0 +1,120 @@
+package gitops
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+)
+
+// StateSnapshotVersion is the format version written by ExportState and accepted by ImportState.
+const StateSnapshotVersion = 1
+
+// StateSnapshot is a portable copy of the operator's internal state, for restoring
+// onto a fresh operator instance (e.g. when rebuilding a cluster) without a blind re-apply
+// of every object or losing track of Grey Matter config that should later be deleted.
+type StateSnapshot struct {
+	Version    int       `json:"version"`
+	ExportedAt time.Time `json:"exported_at"`
+	// The commit checked out in the GitOps working tree, if any.
+	SHA string `json:"sha,omitempty"`
+	// Hashes of the applied Grey Matter objects, keyed by GMObjectRef.HashKey.
+	GM map[string]GMObjectRef `json:"gm"`
+	// Hashes of the applied K8s objects (the operator's inventory), keyed by K8sObjectRef.HashKey.
+	K8s map[string]K8sObjectRef `json:"k8s"`
+	// Defaults derived from the environment, such as the sidecars allowed to reach Redis.
+	Defaults DerivedDefaults `json:"defaults"`
+}
+
+// ExportState returns a snapshot of the current sync state.
+func (s *Sync) ExportState() (StateSnapshot, error) {
+	if s.SyncState == nil {
+		return StateSnapshot{}, errors.New("sync state has not been initialized")
+	}
+	sha := headSHA(s.GitDir)
+	s.SyncState.hashesMu.RLock()
+	snap := StateSnapshot{
+		Version:    StateSnapshotVersion,
+		ExportedAt: time.Now().UTC(),
+		SHA:        sha,
+		GM:         s.SyncState.previousGMHashes,
+		K8s:        s.SyncState.previousK8sHashes,
+		Defaults:   s.SyncState.derivedDefaults,
+	}
+	s.SyncState.hashesMu.RUnlock()
+	if snap.GM == nil {
+		snap.GM = make(map[string]GMObjectRef)
+	}
+	if snap.K8s == nil {
+		snap.K8s = make(map[string]K8sObjectRef)
+	}
+	return snap, nil
+}
+
+// ImportState replaces the current sync state with a snapshot and persists it to Redis,
+// so that the next sync cycle only applies what differs from the snapshot.
+func (s *Sync) ImportState(snap StateSnapshot) error {
+	if s.SyncState == nil {
+		return errors.New("sync state has not been initialized")
+	}
+	if snap.Version != StateSnapshotVersion {
+		return fmt.Errorf("unsupported state snapshot version %d (expected %d)", snap.Version, StateSnapshotVersion)
+	}
+	if snap.GM == nil {
+		snap.GM = make(map[string]GMObjectRef)
+	}
+	if snap.K8s == nil {
+		snap.K8s = make(map[string]K8sObjectRef)
+	}
+
+	if head := headSHA(s.GitDir); snap.SHA != "" && head != "" && head != snap.SHA {
+		stateLogger.Info("Imported state was exported at a different commit; differences will be applied on the next sync",
+			"snapshot", snap.SHA, "head", head)
+	}
+
+	s.SyncState.hashesMu.Lock()
+	s.SyncState.previousGMHashes = snap.GM
+	s.SyncState.previousK8sHashes = snap.K8s
+	s.SyncState.restoreCycles()
+	s.SyncState.recordObjects(stateGM)
+	s.SyncState.recordObjects(stateK8s)
+	s.SyncState.markRewrite(stateGM)
+	s.SyncState.markRewrite(stateK8s)
+	s.SyncState.hashesMu.Unlock()
+	if s.SyncState.saveChans != nil {
+		go func() { s.SyncState.saveChans[stateGM] <- struct{}{} }()
+		go func() { s.SyncState.saveChans[stateK8s] <- struct{}{} }()
+	}
+	s.SyncState.SetDerivedDefaults(snap.Defaults)
+	stateLogger.Info("Imported operator state", "gm", len(snap.GM), "k8s", len(snap.K8s), "sha", snap.SHA)
+	return nil
+}
+
+// ResetState purges the sync state and persists the purge to Redis, so the next sync cycle rebuilds it by applying
+// every object.
+func (s *Sync) ResetState() error {
+	if s.SyncState == nil {
+		return errors.New("sync state has not been initialized")
+	}
+	s.SyncState.Reset()
+	stateLogger.Info("Purged operator state; it will be rebuilt on the next sync")
+	return nil
+}
+
+// headSHA returns the commit checked out in gitDir, or the digest of the OCI artifact unpacked there,
+// or "" if it's neither.
+func headSHA(gitDir string) string {
+	if gitDir == "" {
+		return ""
+	}
+	repo, err := git.PlainOpen(gitDir)
+	if err != nil {
+		return artifactDigest(gitDir)
+	}
+	ref, err := repo.Head()
+	if err != nil {
+		return ""
+	}
+	return ref.Hash().String()
+}
@@ -0,0 +1,231 @@
+package gitops
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// defaultInterval is the number of seconds between fetches if no interval is configured.
+const defaultInterval = 30
+
+// Source is the git repository and ref, or OCI artifact and tag or digest, a Sync fetches, how often,
+// and which changes trigger a reload.
+type Source struct {
+	Remote      string   `json:"remote"`
+	Branch      string   `json:"branch,omitempty"`
+	Tag         string   `json:"tag,omitempty"`
+	Interval    int      `json:"interval,omitempty"`
+	PathFilters []string `json:"path_filters,omitempty"`
+}
+
+// sourceChange is how a source set by Reconfigure differs from the one before it.
+type sourceChange int
+
+const (
+	// At most the interval or path filters changed, so the checkout is kept as is
+	sourceUnchanged sourceChange = iota
+	// The branch or tag changed, so the existing checkout is re-pointed at it
+	sourceRefChanged
+	// The remote changed (or the ref was unset, leaving it to the remote's default branch), so it's cloned afresh
+	sourceRemoteChanged
+)
+
+// Source returns the source the sync fetches, including any change pending from Reconfigure.
+func (s *Sync) Source() Source {
+	s.sourceMu.Lock()
+	defer s.sourceMu.Unlock()
+	if s.pendingSource != nil {
+		return *s.pendingSource
+	}
+	return s.currentSource()
+}
+
+func (s *Sync) currentSource() Source {
+	return Source{Remote: s.Remote, Branch: s.Branch, Tag: s.Tag, Interval: s.Interval, PathFilters: s.PathFilters}
+}
+
+// Reconfigure points the sync at a new source, and reports whether it differs from the current one.
+// The change takes effect at the start of Watch's next cycle, which is started right away. If only the branch or tag
+// changed, the existing checkout is re-pointed at the new ref; if the remote changed, the checkout is replaced with a
+// fresh clone. Either way, the new ref's config is then diffed against the applied state and applied in full with
+// OnSyncCompleted. An empty remote stops fetching, leaving the last checkout in place.
+func (s *Sync) Reconfigure(src Source) (bool, error) {
+	if src.Branch != "" && src.Tag != "" {
+		return false, fmt.Errorf("a GitOps source has a branch or a tag, not both (branch %q, tag %q)", src.Branch, src.Tag)
+	}
+	if IsOCI(src.Remote) {
+		if src.Branch != "" {
+			return false, fmt.Errorf("OCI artifacts have no branches; set a tag or digest instead of branch '%s'", src.Branch)
+		}
+		if _, err := parseOCIReference(src.Remote, src.Tag); err != nil {
+			return false, err
+		}
+	}
+	if len(src.PathFilters) == 0 {
+		src.PathFilters = nil
+	}
+
+	s.sourceMu.Lock()
+	current := s.currentSource()
+	if s.pendingSource != nil {
+		current = *s.pendingSource
+	}
+	if reflect.DeepEqual(src, current) {
+		s.sourceMu.Unlock()
+		return false, nil
+	}
+	s.pendingSource = &src
+	s.sourceMu.Unlock()
+
+	logger.Info("Reconfiguring GitOps source", "Remote", src.Remote, "Branch", src.Branch, "Tag", src.Tag, "Interval", src.Interval)
+	s.wakeup()
+	return true, nil
+}
+
+// applyPendingSource switches to the source set by Reconfigure, if any,
+// and reports what the checkout needs for it.
+func (s *Sync) applyPendingSource() sourceChange {
+	s.sourceMu.Lock()
+	defer s.sourceMu.Unlock()
+	if s.pendingSource == nil {
+		return sourceUnchanged
+	}
+	src := *s.pendingSource
+	s.pendingSource = nil
+
+	change := sourceUnchanged
+	switch {
+	case src.Remote == "":
+	case src.Remote != s.Remote, src.Branch == "" && src.Tag == "" && (s.Branch != "" || s.Tag != ""):
+		change = sourceRemoteChanged
+	case src.Branch != s.Branch || src.Tag != s.Tag:
+		change = sourceRefChanged
+	}
+	s.Remote, s.Branch, s.Tag = src.Remote, src.Branch, src.Tag
+	s.Interval = src.Interval
+	s.PathFilters = src.PathFilters
+	return change
+}
+
+// repoint switches the existing checkout to the current branch or tag: it fetches, verifies the ref's commit if
+// required, checks it out, and cleans the tree. If the ref can't be resolved or verified, the checkout is left as it was.
+func (s *Sync) repoint() error {
+	// An artifact's tag or digest names a different artifact, so there's no checkout to re-point
+	if IsOCI(s.Remote) {
+		_, err := s.artifactUpdate(false)
+		return err
+	}
+	logger.Info("Re-pointing GitOps checkout", "Remote", s.Remote, "Branch", s.Branch, "Tag", s.Tag, "Dir", s.GitDir)
+	repo, err := git.PlainOpen(s.GitDir)
+	if err != nil {
+		return fmt.Errorf("unable to open local repository %s: %w", s.GitDir, err)
+	}
+	auth, err := s.auth()
+	if err != nil {
+		return err
+	}
+	if err := repo.Fetch(&git.FetchOptions{
+		Auth:            auth,
+		InsecureSkipTLS: s.insecureSkipTLS(),
+		Tags:            git.AllTags,
+		Force:           true,
+	}); err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return fmt.Errorf("failed to fetch remote %s: %w", s.Remote, err)
+	}
+
+	var hash plumbing.Hash
+	if s.Tag != "" {
+		tagRef, err := storer.ResolveReference(repo.Storer, plumbing.NewTagReferenceName(s.Tag))
+		if err != nil {
+			return fmt.Errorf("unable to resolve tag '%s': %w", s.Tag, err)
+		}
+		hash = peelTag(repo, tagRef.Hash())
+	} else {
+		remoteRef, err := repo.Reference(plumbing.NewRemoteReferenceName("origin", s.Branch), true)
+		if err != nil {
+			return fmt.Errorf("unable to resolve remote branch '%s': %w", s.Branch, err)
+		}
+		hash = remoteRef.Hash()
+	}
+	if s.Verification.Enabled() {
+		if err := s.Verification.Verify(repo, hash); err != nil {
+			return err
+		}
+	}
+
+	wt, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	checkout := &git.CheckoutOptions{Hash: hash, Force: true}
+	if s.Tag == "" {
+		// Track the branch locally at the remote's commit, as a fresh clone of it would
+		branch := plumbing.NewBranchReferenceName(s.Branch)
+		if err := repo.Storer.SetReference(plumbing.NewHashReference(branch, hash)); err != nil {
+			return fmt.Errorf("failed to create branch '%s': %w", s.Branch, err)
+		}
+		checkout = &git.CheckoutOptions{Branch: branch, Force: true}
+	}
+	if err := wt.Checkout(checkout); err != nil {
+		return fmt.Errorf("failed to checkout %s: %w", s.ref(), err)
+	}
+	if !s.SkipSubmodules {
+		submodules, err := wt.Submodules()
+		if err != nil {
+			return err
+		}
+		if err := submodules.Update(&git.SubmoduleUpdateOptions{Init: true, Auth: auth, RecurseSubmodules: s.submoduleRecursion()}); err != nil {
+			return fmt.Errorf("failed to update submodules: %w", err)
+		}
+	}
+	if err := wt.Clean(&git.CleanOptions{Dir: true}); err != nil {
+		return fmt.Errorf("failed to run git clean: %w", err)
+	}
+	return s.vendorCUEDependencies(s.GitDir)
+}
+
+// ref describes the current branch or tag.
+func (s *Sync) ref() string {
+	switch {
+	case s.Tag != "":
+		return fmt.Sprintf("tag '%s'", s.Tag)
+	case s.Branch != "":
+		return fmt.Sprintf("branch '%s'", s.Branch)
+	}
+	return "the default branch"
+}
+
+// wakeup starts Watch's next cycle without waiting out the interval.
+func (s *Sync) wakeup() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// wait blocks for the configured interval, until woken up, or until the sync is cancelled or stopped,
+// and reports whether it was.
+func (s *Sync) wait() bool {
+	interval := s.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	timer := time.NewTimer(time.Duration(interval) * time.Second)
+	defer timer.Stop()
+	select {
+	case <-s.ctx.Done():
+		return true
+	case <-s.stopped():
+		return true
+	case <-s.wake:
+		return false
+	case <-timer.C:
+		return false
+	}
+}
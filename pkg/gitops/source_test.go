@@ -0,0 +1,151 @@
+package gitops
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+)
+
+// initRepo creates a repo with a single commit of the given file, and returns its path.
+func initRepo(t *testing.T, path, contents string) string {
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	assert.NoError(t, err)
+	wt, err := repo.Worktree()
+	assert.NoError(t, err)
+	full := filepath.Join(dir, path)
+	assert.NoError(t, os.MkdirAll(filepath.Dir(full), 0755))
+	assert.NoError(t, os.WriteFile(full, []byte(contents), 0644))
+	_, err = wt.Add(path)
+	assert.NoError(t, err)
+	_, err = wt.Commit("add "+path, &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@greymatter.io", When: time.Now()},
+	})
+	assert.NoError(t, err)
+	return dir
+}
+
+func TestReconfigure(t *testing.T) {
+	s := New("", context.Background(), nil, WithPathFilters("gm/"))
+	s.Interval = 30
+
+	changed, err := s.Reconfigure(Source{Remote: "git@example.com:mesh.git", Branch: "main", Interval: 30, PathFilters: []string{"gm/"}})
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, "git@example.com:mesh.git", s.Source().Remote)
+	assert.Empty(t, s.Remote, "a new source only takes effect in Watch's next cycle")
+
+	changed, err = s.Reconfigure(s.Source())
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	_, err = s.Reconfigure(Source{Remote: "git@example.com:mesh.git", Branch: "main", Tag: "v1"})
+	assert.Error(t, err)
+
+	assert.Equal(t, sourceRemoteChanged, s.applyPendingSource())
+	assert.Equal(t, "main", s.Branch)
+	assert.Equal(t, sourceUnchanged, s.applyPendingSource())
+
+	// Only the interval changes, so the checkout is kept
+	_, err = s.Reconfigure(Source{Remote: "git@example.com:mesh.git", Branch: "main", Interval: 5})
+	assert.NoError(t, err)
+	assert.Equal(t, sourceUnchanged, s.applyPendingSource())
+	assert.Equal(t, 5, s.Interval)
+	assert.Empty(t, s.PathFilters)
+
+	// Switching refs re-points the checkout, unless it's to the remote's default branch
+	_, err = s.Reconfigure(Source{Remote: "git@example.com:mesh.git", Tag: "v1"})
+	assert.NoError(t, err)
+	assert.Equal(t, sourceRefChanged, s.applyPendingSource())
+	_, err = s.Reconfigure(Source{Remote: "git@example.com:mesh.git"})
+	assert.NoError(t, err)
+	assert.Equal(t, sourceRemoteChanged, s.applyPendingSource())
+}
+
+func TestWatchReconfigured(t *testing.T) {
+	first := initRepo(t, "gm/outputs/mesh.cue", "zone: \"first\"")
+	second := initRepo(t, "gm/outputs/mesh.cue", "zone: \"second\"")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := New(first, ctx, cancel)
+	s.GitDir = filepath.Join(t.TempDir(), "checkout")
+	s.Interval = 60
+	assert.NoError(t, s.Bootstrap())
+
+	synced := make(chan string, 1)
+	s.OnSyncCompleted = func() error {
+		b, err := os.ReadFile(filepath.Join(s.GitDir, "gm/outputs/mesh.cue"))
+		synced <- string(b)
+		return err
+	}
+	go s.Watch()
+
+	_, err := s.Reconfigure(Source{Remote: second, Interval: 60})
+	assert.NoError(t, err)
+	select {
+	case contents := <-synced:
+		assert.Equal(t, "zone: \"second\"", contents)
+	case <-time.After(10 * time.Second):
+		t.Fatal("the new source was not synced")
+	}
+}
+
+func TestWatchSwitchesRef(t *testing.T) {
+	dir := initRepo(t, "gm/outputs/mesh.cue", "zone: \"main\"")
+	repo, err := git.PlainOpen(dir)
+	assert.NoError(t, err)
+	head, err := repo.Head()
+	assert.NoError(t, err)
+	_, err = repo.CreateTag("v1", head.Hash(), nil)
+	assert.NoError(t, err)
+	wt, err := repo.Worktree()
+	assert.NoError(t, err)
+	assert.NoError(t, wt.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName("release"), Create: true}))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "gm/outputs/mesh.cue"), []byte("zone: \"release\""), 0644))
+	_, err = wt.Add("gm/outputs/mesh.cue")
+	assert.NoError(t, err)
+	_, err = wt.Commit("release", &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@greymatter.io", When: time.Now()},
+	})
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := New(dir, ctx, cancel, WithRepoInfo(dir, "master", ""))
+	s.GitDir = filepath.Join(t.TempDir(), "checkout")
+	s.Interval = 60
+	assert.NoError(t, s.Bootstrap())
+
+	synced := make(chan string, 1)
+	s.OnSyncCompleted = func() error {
+		b, err := os.ReadFile(filepath.Join(s.GitDir, "gm/outputs/mesh.cue"))
+		synced <- string(b)
+		return err
+	}
+	go s.Watch()
+
+	for _, tc := range []struct {
+		branch, tag, expected string
+	}{
+		{"release", "", "zone: \"release\""},
+		{"", "v1", "zone: \"main\""},
+		{"release", "", "zone: \"release\""},
+	} {
+		_, err := s.Reconfigure(Source{Remote: dir, Branch: tc.branch, Tag: tc.tag, Interval: 60})
+		assert.NoError(t, err)
+		select {
+		case contents := <-synced:
+			assert.Equal(t, tc.expected, contents, "branch %q, tag %q", tc.branch, tc.tag)
+		case <-time.After(10 * time.Second):
+			t.Fatalf("branch %q, tag %q was not synced", tc.branch, tc.tag)
+		}
+	}
+}
@@ -4,16 +4,22 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-redis/redis/v9"
 	"github.com/greymatter-io/operator/pkg/cuemodule"
-	"github.com/mitchellh/hashstructure/v2"
 	"github.com/tidwall/gjson"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+// Logs of the operator's state, whose verbosity can be set apart from the rest of gitops.
+var stateLogger = logger.WithName("state")
+
 // SyncState is the machinery responsible for managing
 // operator internal state.
 //
@@ -24,14 +30,100 @@ import (
 // with redis given hashes of objects it receives from its git
 // repos. If it detects changes in hashes, it updates the state and
 // the subsequent control-plane with ONLY the changed objects.
+//
+// If redis is unavailable, the hashes are kept in memory (degraded
+// mode) and reconciled with redis once it can be reached.
+//
+// With defaults.state_file set, a BoltDB file is used instead of redis.
 type SyncState struct {
 	ctx       context.Context
 	redisOpts *redis.Options
-	redis     *redis.Client
+	// BoltDB file the state is kept in instead of Redis, if set
+	stateFile string
+	store     StateStore
+	// Guards the store and the Redis options. Reads hold it for as long as they use the store, so it isn't closed
+	// under them when it's replaced; they don't take hashesMu while holding it, since FilterChanged* take it the
+	// other way around (recording deletions in progress).
+	storeMu   sync.RWMutex
 	saveChans map[string]chan interface{}
 
-	previousGMHashes  map[string]GMObjectRef  // no lock because we only replace the whole map at once
-	previousK8sHashes map[string]K8sObjectRef // no lock because we only replace the whole map at once
+	previousGMHashes  map[string]GMObjectRef
+	previousK8sHashes map[string]K8sObjectRef
+
+	derivedDefaults DerivedDefaults // no lock for reads because we only replace the whole struct at once
+	// Serializes read-modify-write updates of the derived defaults
+	derivedMu sync.Mutex
+
+	// Sync cycles counted by each of FilterChangedGM and FilterChangedK8s, which stamp the objects they see
+	gmCycle  int
+	k8sCycle int
+	// Guards the hashes and the sync cycles. The maps are only ever replaced whole, so one read under the lock may
+	// still be ranged over after it's released.
+	hashesMu sync.RWMutex
+	// Cycles an object may be missing from the config before its entry is compacted and it's deleted
+	retentionCycles int
+	// Deletions held back to protect against mass deletion
+	deletions deletionGuard
+	// The sync cycle in progress, and the one interrupted when the operator last stopped
+	progress progressTracker
+	// Catalog registrations not yet made
+	catalog catalogQueue
+
+	defaults cuemodule.Defaults
+	// Prefix of the Redis keys of this operator's state for its mesh, so operators and meshes sharing a Redis
+	// don't overwrite each other's state (see StateNamespace)
+	namespace string
+	// Kinds of state changed while Redis was unavailable, saved once it's available again
+	// (only accessed by the backup loop)
+	unsaved map[string]bool
+	// Entries changed since they were last persisted to Redis
+	changes stateChanges
+	// How long the state has had changes not persisted to Redis
+	divergence divergenceTracker
+
+	// Why Redis is unavailable, or nil if it's available
+	degradedErr          error
+	onAvailabilityChange func(err error)
+	degradedMu           sync.Mutex
+
+	// Stops the backup loop, which closes loopDone once it has saved the latest state
+	stopLoop  context.CancelFunc
+	loopDone  chan struct{}
+	closeOnce sync.Once
+}
+
+// Redis key for the derived defaults if the CUE doesn't specify one.
+const defaultStateKeyDefaults = "gm-operator-derived-defaults"
+
+// DerivedDefaults are the cuemodule.Defaults that the operator derives from the environment at runtime,
+// rather than loading from CUE. They're persisted so a restarted operator starts from the last known
+// view of the environment instead of churning the config that depends on them.
+type DerivedDefaults struct {
+	// Sidecar cluster names allowed to reach Redis
+	SidecarList []string `json:"sidecar_list,omitempty"`
+	// Workloads whose sidecar configuration added a service to Catalog, so a service left behind by a workload
+	// deleted while the operator wasn't watching can be found and deleted
+	CatalogWorkloads []string `json:"catalog_workloads,omitempty"`
+	// Pre-existing workloads adopted into the mesh, so their adoption can be rolled back
+	AdoptedWorkloads []AdoptedWorkload `json:"adopted_workloads,omitempty"`
+}
+
+// AdoptedWorkload records a pre-existing Deployment or StatefulSet adopted into the mesh, and the pod template
+// annotations the adoption added, which are removed when it's rolled back.
+type AdoptedWorkload struct {
+	Kind        string    `json:"kind"`
+	Namespace   string    `json:"namespace"`
+	Name        string    `json:"name"`
+	Port        string    `json:"port"`
+	Annotations []string  `json:"annotations,omitempty"`
+	Adopted     time.Time `json:"adopted"`
+}
+
+// ApplyTo overwrites the given defaults with any derived values that have been set.
+func (dd DerivedDefaults) ApplyTo(defaults *cuemodule.Defaults) {
+	if len(dd.SidecarList) > 0 {
+		defaults.SidecarList = dd.SidecarList
+	}
 }
 
 // GMObjectRef contains enough information to know whether an object has changed, and delete it if removed
@@ -45,11 +137,13 @@ type GMObjectRef struct {
 	ID string `json:"id"`
 	// A deterministic hash of the source object content
 	Hash uint64 `json:"hash"`
+	// The StateSchemaVersion the hash was computed by; unset before state was versioned
+	HashVersion int `json:"hash_version,omitempty"`
+	// The sync cycle in which the object was last in the config
+	LastSeen int `json:"last_seen,omitempty"`
 }
 
 func NewGMObjectRef(objBytes []byte, kind string) *GMObjectRef {
-	// TODO confirm that the []byte representation maps 1-1 with the original object (i.e., no key rearrangement)
-	//      if it *doesn't*, then we need to rehydrate the object before hashing below
 	keyName := cuemodule.KindToKeyName[kind] // One of listener_key, proxy_key, etc., so we can look up the ID
 	var zoneLookupKey string
 	if kind == "catalogservice" {
@@ -59,12 +153,12 @@ func NewGMObjectRef(objBytes []byte, kind string) *GMObjectRef {
 	}
 	zoneResult := gjson.GetBytes(objBytes, zoneLookupKey)
 	idResult := gjson.GetBytes(objBytes, keyName)
-	hash, _ := hashstructure.Hash(objBytes, hashstructure.FormatV2, nil)
 	return &GMObjectRef{
-		Zone: zoneResult.String(),
-		Kind: kind,
-		ID:   idResult.String(),
-		Hash: hash,
+		Zone:        zoneResult.String(),
+		Kind:        kind,
+		ID:          idResult.String(),
+		Hash:        gmHashers[StateSchemaVersion](objBytes),
+		HashVersion: StateSchemaVersion,
 	}
 }
 
@@ -76,29 +170,53 @@ func (obj *GMObjectRef) HashKey() (key string) {
 // FilterChangedGM takes Grey Matter config objects and their kinds, and returned filtered versions of those lists
 // which don't contain any objects that are the same since the last update, as well as updating the stored hashes as a
 // side effect. The purpose is to return only objects that need to be applied to the environment.
+// Objects missing from the config are returned as deleted once they've been missing for the retention period,
+// unless the DeletionPolicy holds their deletion; held objects are kept in the state until they're released.
 func (ss *SyncState) FilterChangedGM(configObjects []json.RawMessage, kinds []string) (filteredConf []json.RawMessage, filteredKinds []string, deleted []GMObjectRef) {
+	ss.hashesMu.Lock()
+	defer ss.hashesMu.Unlock()
+	ss.gmCycle++
 	newHashes := make(map[string]GMObjectRef)
 	for i, objBytes := range configObjects {
 		val := NewGMObjectRef(objBytes, kinds[i])
+		val.LastSeen = ss.gmCycle
 		key := val.HashKey()
 
 		newHashes[key] = *val
-		if prevVal, ok := ss.previousGMHashes[key]; !ok || prevVal.Hash != val.Hash {
+		if prevVal, ok := ss.previousGMHashes[key]; !ok || !prevVal.unchanged(objBytes, val) {
 			filteredConf = append(filteredConf, objBytes)
 			filteredKinds = append(filteredKinds, val.Kind)
 		}
 	}
 
-	// find deleted
+	// find deleted, compacting their entries once they've been missing long enough
 	for oldKey, oldVal := range ss.previousGMHashes {
-		if _, ok := newHashes[oldKey]; !ok {
+		if _, ok := newHashes[oldKey]; ok {
+			continue
+		}
+		if ss.expired(oldVal.LastSeen, ss.gmCycle) {
 			deleted = append(deleted, oldVal)
+		} else {
+			newHashes[oldKey] = oldVal
+		}
+	}
+
+	// keep the entries of held deletions, so they're still known until released
+	candidates := deleted
+	if deleted = ss.guardGM(candidates, len(ss.previousGMHashes)); deleted == nil {
+		for _, held := range candidates {
+			newHashes[held.HashKey()] = held
 		}
 	}
 
 	// save new hash table
+	stateChangedObjects.WithLabelValues(stateGM).Set(float64(len(filteredConf)))
+	stateDeletedObjects.WithLabelValues(stateGM).Set(float64(len(deleted)))
+	ss.recordDeleting(deleted, nil)
+	ss.markChanged(stateGM, changedGM(ss.previousGMHashes, newHashes, ss.gmCycle-1, ss.gmCycle)...)
 	ss.previousGMHashes = newHashes
-	go func() { ss.saveChans["gm"] <- struct{}{} }() // asynchronously kick-off asynchronous persistence
+	ss.recordObjects(stateGM)
+	go func() { ss.saveChans[stateGM] <- struct{}{} }() // asynchronously kick-off asynchronous persistence
 	return
 }
 
@@ -107,15 +225,19 @@ type K8sObjectRef struct {
 	Kind      schema.GroupVersionKind `json:"kind"`
 	Name      string                  `json:"name"`
 	Hash      uint64                  `json:"hash"`
+	// The StateSchemaVersion the hash was computed by; unset before state was versioned
+	HashVersion int `json:"hash_version,omitempty"`
+	// The sync cycle in which the object was last in the config
+	LastSeen int `json:"last_seen,omitempty"`
 }
 
 func NewK8sObjectRef(object client.Object) *K8sObjectRef {
-	hash, _ := hashstructure.Hash(object, hashstructure.FormatV2, nil)
 	return &K8sObjectRef{
-		Namespace: object.GetNamespace(),
-		Kind:      object.GetObjectKind().GroupVersionKind(),
-		Name:      object.GetName(),
-		Hash:      hash,
+		Namespace:   object.GetNamespace(),
+		Kind:        object.GetObjectKind().GroupVersionKind(),
+		Name:        object.GetName(),
+		Hash:        k8sHashers[StateSchemaVersion](object),
+		HashVersion: StateSchemaVersion,
 	}
 }
 
@@ -127,33 +249,211 @@ func (obj *K8sObjectRef) HashKey() (key string) {
 // FilterChangedK8s takes Grey Matter config objects, and returns a filtered version of that list, updating the stored
 // hashes as a side effect which don't contain any objects that are the same since the last update. The purpose is to
 // return only objects that need to be applied to the environment.
+// Objects missing from the manifests are returned as deleted once they've been missing for the retention period,
+// unless the DeletionPolicy holds their deletion; held objects are kept in the state until they're released.
 func (ss *SyncState) FilterChangedK8s(manifestObjects []client.Object) (filtered []client.Object, deleted []K8sObjectRef) {
+	ss.hashesMu.Lock()
+	defer ss.hashesMu.Unlock()
+	ss.k8sCycle++
 	newHashes := make(map[string]K8sObjectRef)
 	for _, manifestObject := range manifestObjects {
 		val := NewK8sObjectRef(manifestObject)
+		val.LastSeen = ss.k8sCycle
 		key := val.HashKey()
 		newHashes[key] = *val // store *all* of them in newHashes, to replace previousGMHashes
 		// if the hashes don't match, the object has changed, and it should be in the filtered list
-		if prevVal, ok := ss.previousK8sHashes[key]; !ok || prevVal.Hash != val.Hash {
+		if prevVal, ok := ss.previousK8sHashes[key]; !ok || !prevVal.unchanged(manifestObject, val) {
 			filtered = append(filtered, manifestObject)
 		}
 	}
-	// find deleted
+	// find deleted, compacting their entries once they've been missing long enough
 	for oldKey, oldVal := range ss.previousK8sHashes {
-		if _, ok := newHashes[oldKey]; !ok {
+		if _, ok := newHashes[oldKey]; ok {
+			continue
+		}
+		if ss.expired(oldVal.LastSeen, ss.k8sCycle) {
 			deleted = append(deleted, oldVal)
+		} else {
+			newHashes[oldKey] = oldVal
+		}
+	}
+
+	// keep the entries of held deletions, so they're still known until released
+	candidates := deleted
+	if deleted = ss.guardK8s(candidates, len(ss.previousK8sHashes)); deleted == nil {
+		for _, held := range candidates {
+			newHashes[held.HashKey()] = held
 		}
 	}
 
 	// save new hash table
+	stateChangedObjects.WithLabelValues(stateK8s).Set(float64(len(filtered)))
+	stateDeletedObjects.WithLabelValues(stateK8s).Set(float64(len(deleted)))
+	ss.recordDeleting(nil, deleted)
+	ss.markChanged(stateK8s, changedK8s(ss.previousK8sHashes, newHashes, ss.k8sCycle-1, ss.k8sCycle)...)
 	ss.previousK8sHashes = newHashes
-	go func() { ss.saveChans["k8s"] <- struct{}{} }() // asynchronously kick-off asynchronous persistence
+	ss.recordObjects(stateK8s)
+	go func() { ss.saveChans[stateK8s] <- struct{}{} }() // asynchronously kick-off asynchronous persistence
 	return
 }
 
-func NewSyncState(ctx context.Context, defaults cuemodule.Defaults) *SyncState {
+// expired reports whether an object last seen in the given sync cycle has been missing from the config for the
+// retention period, as of the current cycle.
+func (ss *SyncState) expired(lastSeen, cycle int) bool {
+	return cycle-lastSeen >= ss.retention()
+}
+
+// retention returns how many sync cycles an object may be missing from the config before it's deleted.
+func (ss *SyncState) retention() int {
+	if ss.retentionCycles < 1 {
+		return 1
+	}
+	return ss.retentionCycles
+}
+
+// SetRetentionCycles sets how many sync cycles an object may be missing from the config before its entry is
+// compacted and it's deleted. Defaults to 1, so objects are deleted as soon as they're missing.
+func (ss *SyncState) SetRetentionCycles(cycles int) {
+	ss.retentionCycles = cycles
+}
+
+// Reset purges all object hashes, so the next sync cycle applies every object and rebuilds them.
+// Objects removed from the config before then aren't deleted, since there's no record of them.
+func (ss *SyncState) Reset() {
+	ss.deletions.mu.Lock()
+	ss.deletions.held, ss.deletions.heldOrphans = HeldDeletions{}, nil
+	ss.deletions.mu.Unlock()
+	ss.hashesMu.Lock()
+	ss.previousGMHashes = make(map[string]GMObjectRef)
+	ss.previousK8sHashes = make(map[string]K8sObjectRef)
+	ss.recordObjects(stateGM)
+	ss.recordObjects(stateK8s)
+	ss.hashesMu.Unlock()
+	ss.markRewrite(stateGM)
+	ss.markRewrite(stateK8s)
+	if ss.saveChans != nil {
+		go func() { ss.saveChans[stateGM] <- struct{}{} }()
+		go func() { ss.saveChans[stateK8s] <- struct{}{} }()
+	}
+}
+
+// restoreCycles resumes counting sync cycles from the latest in the loaded hashes. The caller holds hashesMu.
+func (ss *SyncState) restoreCycles() {
+	for _, ref := range ss.previousGMHashes {
+		if ref.LastSeen > ss.gmCycle {
+			ss.gmCycle = ref.LastSeen
+		}
+	}
+	for _, ref := range ss.previousK8sHashes {
+		if ref.LastSeen > ss.k8sCycle {
+			ss.k8sCycle = ref.LastSeen
+		}
+	}
+}
+
+// K8sInventory returns a reference to every K8s object applied as of the last call to FilterChangedK8s.
+func (ss *SyncState) K8sInventory() []K8sObjectRef {
+	ss.hashesMu.RLock()
+	defer ss.hashesMu.RUnlock()
+	inventory := make([]K8sObjectRef, 0, len(ss.previousK8sHashes))
+	for _, ref := range ss.previousK8sHashes {
+		inventory = append(inventory, ref)
+	}
+	return inventory
+}
+
+// ForgetK8s removes the entries of the referenced K8s objects, so the next sync cycle applies them again
+// (e.g. after they're rolled back).
+func (ss *SyncState) ForgetK8s(refs []K8sObjectRef) {
+	ss.hashesMu.Lock()
+	defer ss.hashesMu.Unlock()
+	remaining := make(map[string]K8sObjectRef, len(ss.previousK8sHashes))
+	for key, ref := range ss.previousK8sHashes {
+		remaining[key] = ref
+	}
+	var keys []string
+	for _, ref := range refs {
+		delete(remaining, ref.HashKey())
+		keys = append(keys, ref.HashKey())
+	}
+	ss.markChanged(stateK8s, keys...)
+	ss.previousK8sHashes = remaining
+	ss.recordObjects(stateK8s)
+	if ss.saveChans != nil {
+		go func() { ss.saveChans[stateK8s] <- struct{}{} }()
+	}
+}
+
+// DerivedDefaults returns the last known defaults derived from the environment.
+func (ss *SyncState) DerivedDefaults() DerivedDefaults {
+	return ss.derivedDefaults
+}
+
+// SetDerivedDefaults replaces the known defaults derived from the environment and persists them.
+func (ss *SyncState) SetDerivedDefaults(dd DerivedDefaults) {
+	ss.derivedMu.Lock()
+	defer ss.derivedMu.Unlock()
+	ss.setDerivedDefaults(dd)
+}
+
+// UpdateDerivedDefaults changes the known defaults derived from the environment with the given function, and
+// persists them if it reports a change. Concurrent updates are applied one at a time, so none is lost.
+func (ss *SyncState) UpdateDerivedDefaults(update func(dd *DerivedDefaults) bool) {
+	ss.derivedMu.Lock()
+	defer ss.derivedMu.Unlock()
+	dd := ss.derivedDefaults
+	if update(&dd) {
+		ss.setDerivedDefaults(dd)
+	}
+}
+
+func (ss *SyncState) setDerivedDefaults(dd DerivedDefaults) {
+	ss.derivedDefaults = dd
+	if ss.saveChans != nil {
+		go func() { ss.saveChans[stateDefaults] <- struct{}{} }() // asynchronously kick-off asynchronous persistence
+	}
+}
+
+// State kinds persisted to Redis, each under its own key, and signalled on the matching save channel.
+// The GM and K8s state are Redis hashes with a field for each object; the derived defaults are a single JSON value.
+const (
+	stateGM       = "gm"
+	stateK8s      = "k8s"
+	stateDefaults = "defaults"
+)
+
+var stateKinds = []string{stateGM, stateK8s, stateDefaults}
+
+// How often an unreachable Redis is retried in degraded mode.
+const redisRetryInterval = 30 * time.Second
+
+// How long the backup loop collects changes before saving them, so state is persisted at most this often however
+// fast it changes during a busy sync.
+var stateSaveInterval = time.Second
+
+// StateNamespace returns the namespace of the Redis keys of an operator's state for a mesh, e.g.
+// "gm-operator:mesh-sample:", which prefixes the keys given in the CUE defaults. Either part may be empty.
+func StateNamespace(operatorID, meshName string) string {
+	var parts []string
+	for _, part := range []string{operatorID, meshName} {
+		if part != "" {
+			parts = append(parts, part+":")
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+// NewSyncState connects to Redis and loads the saved state under the given namespace (see StateNamespace).
+// If Redis is unavailable, it starts in degraded mode: hashes are kept only in memory while Redis is retried in the
+// background, and reconciled with it once connected.
+func NewSyncState(ctx context.Context, defaults cuemodule.Defaults, namespace string) *SyncState {
+	ctx, stopLoop := context.WithCancel(ctx)
 	ss := &SyncState{
-		ctx: ctx,
+		ctx:       ctx,
+		stopLoop:  stopLoop,
+		loopDone:  make(chan struct{}),
+		namespace: namespace,
+		stateFile: defaults.StateFile,
 		redisOpts: &redis.Options{
 			Addr:       fmt.Sprintf("%s:%d", defaults.RedisHost, defaults.RedisPort),
 			DB:         defaults.RedisDB,
@@ -162,118 +462,600 @@ func NewSyncState(ctx context.Context, defaults cuemodule.Defaults) *SyncState {
 			MaxRetries: -1,
 		},
 		saveChans: map[string]chan interface{}{
-			"gm":  make(chan interface{}, 1),
-			"k8s": make(chan interface{}, 1),
+			stateGM:       make(chan interface{}, 1),
+			stateK8s:      make(chan interface{}, 1),
+			stateDefaults: make(chan interface{}, 1),
 		},
 		previousGMHashes:  make(map[string]GMObjectRef),
 		previousK8sHashes: make(map[string]K8sObjectRef),
+		defaults:          defaults,
+		unsaved:           make(map[string]bool),
+	}
+	atomic.StoreInt64(&lastPersisted, time.Now().UnixNano())
+
+	// immediately attempt to connect to Redis and load saved state, including any sync cycle it was interrupted in and
+	// any Catalog registrations it hadn't yet made
+	err := ss.connect()
+	for _, kind := range stateKinds {
+		if err != nil {
+			break
+		}
+		err = ss.load(kind)
+	}
+	if err == nil {
+		err = ss.loadProgress()
+	}
+	if err == nil {
+		err = ss.loadCatalogQueue()
+	}
+	if err != nil {
+		ss.setDegraded(err)
+	} else {
+		ss.setAvailable()
+	}
+
+	// Whether or not we've loaded, we launch our async backup loop
+	// to continue reconciliation with redis.
+	ss.launchAsyncStateBackupLoop(ctx)
+
+	return ss
+}
+
+// redisKey returns the Redis key a kind of state is saved under, in the state's namespace.
+func (ss *SyncState) redisKey(kind string) string {
+	return ss.namespace + ss.sharedKey(kind)
+}
+
+// sharedKey returns the Redis key a kind of state was saved under before state was namespaced, which every operator
+// sharing a Redis used.
+func (ss *SyncState) sharedKey(kind string) string {
+	switch kind {
+	case stateGM:
+		return ss.defaults.GitOpsStateKeyGM
+	case stateK8s:
+		return ss.defaults.GitOpsStateKeyK8s
+	default:
+		return derivedDefaultsKey(ss.defaults)
+	}
+}
+
+// load replaces a kind of state with the one saved in Redis, if any. Only an unreachable Redis is an error:
+// missing or unreadable state is logged and left empty, since it's rebuilt as config is applied.
+// State saved before it was namespaced is migrated: it's loaded from the shared key if there's none in the namespace,
+// and written to the namespace on the next save. The shared key is left for any other operator still using it.
+func (ss *SyncState) load(kind string) error {
+	key, shared := ss.redisKey(kind), ss.sharedKey(kind)
+	found, err := ss.loadKey(kind, key)
+	if err == nil && !found && key != shared {
+		if found, err = ss.loadKey(kind, shared); found {
+			stateLogger.Info("Migrating saved state to its namespaced key", "from", shared, "to", key)
+			if kind != stateDefaults {
+				ss.markRewrite(kind)
+			}
+			if ss.saveChans != nil {
+				go func() { ss.saveChans[kind] <- struct{}{} }()
+			}
+		}
+	}
+	if err == nil && !found {
+		stateLogger.Info("No saved state found in Redis", "key", key)
+	}
+	return err
+}
+
+// loadKey replaces a kind of state with the one saved in Redis under the given key, reporting whether there was one.
+func (ss *SyncState) loadKey(kind, key string) (bool, error) {
+	ss.storeMu.RLock()
+	if kind == stateDefaults {
+		b, err := ss.store.Get(ss.ctx, key)
+		ss.storeMu.RUnlock()
+		if err == errStateNotFound {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to load %s state from Redis: %w", kind, err)
+		}
+		var loaded DerivedDefaults
+		if err := json.Unmarshal(b, &loaded); err != nil {
+			stateLogger.Error(err, "Problem unmarshaling saved state from Redis", "key", key)
+			return true, nil
+		}
+		ss.derivedDefaults = loaded
+		stateLogger.Info("Successfully loaded saved state from Redis", "key", key)
+		return true, nil
 	}
 
-	// immediately attempt to connect to Redis
-	err := ss.redisConnect()
+	entries, err := ss.store.HGetAll(ss.ctx, key)
+	legacy := err != nil && strings.HasPrefix(err.Error(), "WRONGTYPE")
+	if legacy {
+		// Saved by an operator that wrote each kind of state as a single JSON object; rewritten in full on the next save
+		entries, err = ss.loadLegacy(key)
+		ss.markRewrite(kind)
+	}
+	var cycle int
+	if err == nil && !legacy {
+		var b []byte
+		if b, err = ss.store.Get(ss.ctx, cycleKey(key)); err == nil {
+			cycle, err = strconv.Atoi(string(b))
+		} else if err == errStateNotFound {
+			err = nil
+		}
+	}
+	ss.storeMu.RUnlock()
 	if err != nil {
-		logger.Error(err, "Didn't successfully connect to redis...")
-		return &SyncState{}
+		return false, fmt.Errorf("failed to load %s state from Redis: %w", kind, err)
+	}
+	if len(entries) == 0 {
+		return false, nil
 	}
 
-	// if we're able to connect immediately, try to load saved GM hashes
-	loadedGMHashes := make(map[string]GMObjectRef)
-	resultGM := ss.redis.Get(ctx, defaults.GitOpsStateKeyGM)
-	bsGM, err := resultGM.Bytes()
+	// Entries seen in the persisted cycle were saved without it
+	ss.hashesMu.Lock()
+	defer ss.hashesMu.Unlock()
+	switch kind {
+	case stateGM:
+		loaded := make(map[string]GMObjectRef, len(entries))
+		if err = decodeEntries(entries, &loaded); err == nil {
+			var versions []int
+			for hashKey, ref := range loaded {
+				if ref.LastSeen == 0 {
+					ref.LastSeen = cycle
+					loaded[hashKey] = ref
+				}
+				versions = append(versions, ref.HashVersion)
+			}
+			logSchemaVersions(kind, versions)
+			ss.previousGMHashes = loaded
+			if cycle > ss.gmCycle {
+				ss.gmCycle = cycle
+			}
+		}
+	case stateK8s:
+		loaded := make(map[string]K8sObjectRef, len(entries))
+		if err = decodeEntries(entries, &loaded); err == nil {
+			var versions []int
+			for hashKey, ref := range loaded {
+				if ref.LastSeen == 0 {
+					ref.LastSeen = cycle
+					loaded[hashKey] = ref
+				}
+				versions = append(versions, ref.HashVersion)
+			}
+			logSchemaVersions(kind, versions)
+			ss.previousK8sHashes = loaded
+			if cycle > ss.k8sCycle {
+				ss.k8sCycle = cycle
+			}
+		}
+	}
 	if err != nil {
-		logger.Error(err, "Failed to retrieve greymatter configs...")
-		return &SyncState{}
+		stateLogger.Error(err, "Problem unmarshaling saved state from Redis", "key", key)
+		ss.markRewrite(kind)
+		return true, nil
 	}
-	if err = json.Unmarshal(bsGM, &loadedGMHashes); err != nil {
-		logger.Error(err, "Problem unmarshaling GM hashes from Redis", "key", defaults.GitOpsStateKeyGM)
-		return &SyncState{}
+	ss.restoreCycles()
+	ss.recordObjects(kind)
+	stateLogger.Info("Successfully loaded saved state from Redis", "key", key, "entries", len(entries))
+	return true, nil
+}
+
+// loadLegacy returns the entries of state saved as a single JSON object, as JSON by hash key. The caller holds
+// storeMu for reading.
+func (ss *SyncState) loadLegacy(key string) (map[string]string, error) {
+	b, err := ss.store.Get(ss.ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		stateLogger.Error(err, "Problem unmarshaling saved state from Redis", "key", key)
+		return nil, nil
 	}
-	ss.previousGMHashes = loadedGMHashes
-	logger.Info("Successfully loaded GM object hashes from Redis", "key", defaults.GitOpsStateKeyGM)
+	entries := make(map[string]string, len(raw))
+	for hashKey, entry := range raw {
+		entries[hashKey] = string(entry)
+	}
+	return entries, nil
+}
 
-	// if we're able to connect immediately, try to load saved K8s hashes
-	loadedK8sHashes := make(map[string]K8sObjectRef)
-	resultK8s := ss.redis.Get(ctx, defaults.GitOpsStateKeyK8s)
-	bsK8s, err := resultK8s.Bytes()
+// decodeEntries unmarshals the JSON entries of a Redis hash into a map of refs by hash key.
+func decodeEntries(entries map[string]string, into interface{}) error {
+	raw := make(map[string]json.RawMessage, len(entries))
+	for hashKey, entry := range entries {
+		raw[hashKey] = json.RawMessage(entry)
+	}
+	b, err := json.Marshal(raw)
 	if err != nil {
-		logger.Error(err, "Failed to retrieve kubernetes configs...")
-		return &SyncState{}
+		return err
 	}
-	if err = json.Unmarshal(bsK8s, &loadedK8sHashes); err != nil {
-		logger.Error(err, "Problem unmarshaling GM hashes from Redis", "key", defaults.GitOpsStateKeyK8s)
-		return &SyncState{}
+	return json.Unmarshal(b, into)
+}
+
+// cycleKey returns the Redis key of the sync cycle the state under the given key was persisted in.
+func cycleKey(key string) string {
+	return key + ":cycle"
+}
+
+// save writes the given kinds of state to Redis in a single transaction. Only the entries of the GM and K8s state
+// that changed since they were last persisted are written, unless they're to be written in full.
+func (ss *SyncState) save(ctx context.Context, kinds ...string) error {
+	type taken struct {
+		keys    []string
+		rewrite bool
+	}
+	// The changes are taken along with the hashes and cycles they're written from, so a change made since can't be
+	// taken before the hashes it's in are replaced
+	changes := make(map[string]taken)
+	ss.hashesMu.RLock()
+	gmRefs, gmCycle := ss.previousGMHashes, ss.gmCycle
+	k8sRefs, k8sCycle := ss.previousK8sHashes, ss.k8sCycle
+	for _, kind := range kinds {
+		if kind != stateDefaults {
+			keys, rewrite := ss.takeChanges(kind)
+			changes[kind] = taken{keys, rewrite}
+		}
 	}
-	ss.previousK8sHashes = loadedK8sHashes
-	logger.Info("Successfully loaded K8s object hashes from Redis", "key", defaults.GitOpsStateKeyK8s)
+	ss.hashesMu.RUnlock()
 
-	// After we've successfully loaded we launch our async backup loop
-	// to continue reconciliation with redis.
-	ss.launchAsyncStateBackupLoop(ctx, defaults)
+	ss.storeMu.RLock()
+	defer ss.storeMu.RUnlock()
+	err := ss.store.Update(ctx, func(tx StateTx) {
+		for _, kind := range kinds {
+			key := ss.redisKey(kind)
+			if kind == stateDefaults {
+				b, err := json.Marshal(ss.derivedDefaults)
+				if err != nil {
+					stateLogger.Error(err, "Failed to serialize state for backup to Redis", "kind", kind)
+					continue // retrying won't help
+				}
+				tx.Set(key, b)
+				continue
+			}
 
-	return ss
+			keys, rewrite := changes[kind].keys, changes[kind].rewrite
+			// Look up how each entry is persisted as of the current cycle
+			var entry func(hashKey string) (interface{}, bool)
+			var all []string
+			var cycle int
+			switch kind {
+			case stateGM:
+				refs, c := gmRefs, gmCycle
+				entry = func(hashKey string) (interface{}, bool) {
+					ref, ok := refs[hashKey]
+					return persistedGM(ref, c), ok
+				}
+				if rewrite {
+					for hashKey := range refs {
+						all = append(all, hashKey)
+					}
+				}
+				cycle = c
+			case stateK8s:
+				refs, c := k8sRefs, k8sCycle
+				entry = func(hashKey string) (interface{}, bool) {
+					ref, ok := refs[hashKey]
+					return persistedK8s(ref, c), ok
+				}
+				if rewrite {
+					for hashKey := range refs {
+						all = append(all, hashKey)
+					}
+				}
+				cycle = c
+			}
+			if rewrite {
+				tx.Del(key)
+				keys = all
+			}
+
+			set := make(map[string]string)
+			var removed []string
+			for _, hashKey := range keys {
+				persisted, ok := entry(hashKey)
+				if !ok {
+					removed = append(removed, hashKey)
+					continue
+				}
+				b, err := json.Marshal(persisted)
+				if err != nil {
+					stateLogger.Error(err, "Failed to serialize state for backup to Redis", "kind", kind, "entry", hashKey)
+					continue // retrying won't help
+				}
+				set[hashKey] = string(b)
+			}
+			if len(set) > 0 {
+				tx.HSet(key, set)
+			}
+			if len(removed) > 0 {
+				tx.HDel(key, removed...)
+			}
+			tx.Set(cycleKey(key), []byte(strconv.Itoa(cycle)))
+		}
+	})
+	if err != nil {
+		// Written again on the next save
+		for kind, c := range changes {
+			if c.rewrite {
+				ss.markRewrite(kind)
+			} else {
+				ss.markChanged(kind, c.keys...)
+			}
+		}
+		return fmt.Errorf("failed to save %s state to Redis: %w", strings.Join(kinds, ", "), err)
+	}
+	return nil
 }
 
-func (ss *SyncState) redisConnect() error {
-	if ss.redis != nil {
+// Flush saves the changes to the state not yet persisted, rather than waiting for the backup loop to, e.g. before
+// the operator shuts down. It fails if the store is unavailable, in which case they're only kept in memory.
+func (ss *SyncState) Flush(ctx context.Context) error {
+	if degraded, err := ss.Degraded(); degraded {
+		return fmt.Errorf("state not saved: %w", err)
+	}
+	if ss.currentStore() == nil {
+		return nil
+	}
+	return ss.save(ctx, stateKinds...)
+}
+
+// Close stops the backup loop once it has saved the latest state, then closes the store. It's safe to call more
+// than once; only the first call closes anything.
+func (ss *SyncState) Close() error {
+	var err error
+	ss.closeOnce.Do(func() {
+		if ss.stopLoop != nil {
+			ss.stopLoop()
+			<-ss.loopDone
+		}
+		ss.storeMu.Lock()
+		defer ss.storeMu.Unlock()
+		if ss.store != nil {
+			err = ss.store.Close()
+		}
+	})
+	return err
+}
+
+// connect opens the state file, or connects to Redis, if that hasn't been done yet, and checks it can be used.
+func (ss *SyncState) connect() error {
+	ss.storeMu.Lock()
+	defer ss.storeMu.Unlock()
+	if ss.store != nil {
+		return ss.store.Ping(ss.ctx)
+	}
+
+	if ss.stateFile != "" {
+		store, err := openBoltStore(ss.stateFile)
+		if err != nil {
+			return err
+		}
+		ss.store = store
+		stateLogger.Info("Opened state file for state backup", "path", ss.stateFile)
 		return nil
 	}
 
 	rdb := redis.NewClient(ss.redisOpts)
 	err := rdb.Ping(ss.ctx).Err()
 	if err == nil { // if NO error save the client
-		ss.redis = rdb
-		logger.Info("Connected to Redis for state backup")
+		ss.store = &redisStore{client: rdb}
+		stateLogger.Info("Connected to Redis for state backup")
+	} else {
+		rdb.Close()
 	}
 
 	return err
 }
 
-func (ss *SyncState) launchAsyncStateBackupLoop(ctx context.Context, defaults cuemodule.Defaults) {
+// currentStore returns the store the state is kept in, or nil if it hasn't been opened yet.
+func (ss *SyncState) currentStore() StateStore {
+	ss.storeMu.RLock()
+	defer ss.storeMu.RUnlock()
+	return ss.store
+}
 
-	go func() {
-		// first, wait for a Redis connection
-	RetryRedis:
-		err := ss.redisConnect()
+// SetRedisPassword reconnects to Redis with a new password, e.g. after rotation.
+// If no connection has been made yet, the next attempt uses it.
+func (ss *SyncState) SetRedisPassword(password string) {
+	ss.storeMu.Lock()
+	defer ss.storeMu.Unlock()
+	if ss.redisOpts == nil || ss.redisOpts.Password == password || ss.stateFile != "" {
+		return
+	}
+	opts := *ss.redisOpts
+	opts.Password = password
+	ss.redisOpts = &opts
+
+	previous, ok := ss.store.(*redisStore)
+	if !ok {
+		return
+	}
+	rdb := redis.NewClient(ss.redisOpts)
+	if err := rdb.Ping(ss.ctx).Err(); err != nil {
+		stateLogger.Error(err, "Failed to reconnect to Redis with the new password - keeping the existing connection")
+		rdb.Close()
+		return
+	}
+	ss.store = &redisStore{client: rdb}
+	previous.Close()
+	stateLogger.Info("Reconnected to Redis with the new password")
+}
+
+// Degraded reports whether Redis is unavailable, so state is only kept in memory, and why.
+func (ss *SyncState) Degraded() (bool, error) {
+	ss.degradedMu.Lock()
+	defer ss.degradedMu.Unlock()
+	return ss.degradedErr != nil, ss.degradedErr
+}
+
+// OnAvailabilityChange registers a callback that is called with the current availability of Redis,
+// and again whenever it changes. err is why Redis is unavailable, or nil when it is available.
+func (ss *SyncState) OnAvailabilityChange(cb func(err error)) {
+	ss.degradedMu.Lock()
+	ss.onAvailabilityChange = cb
+	err := ss.degradedErr
+	ss.degradedMu.Unlock()
+	cb(err)
+}
+
+func (ss *SyncState) setDegraded(err error) {
+	ss.degradedMu.Lock()
+	wasDegraded := ss.degradedErr != nil
+	ss.degradedErr = err
+	cb := ss.onAvailabilityChange
+	ss.degradedMu.Unlock()
+
+	stateBackupDegraded.Set(1)
+	if !wasDegraded {
+		stateLogger.Error(err, fmt.Sprintf("Redis is unavailable; keeping state in memory and retrying every %s", redisRetryInterval))
+		if cb != nil {
+			cb(err)
+		}
+	}
+}
+
+func (ss *SyncState) setAvailable() {
+	ss.degradedMu.Lock()
+	wasDegraded := ss.degradedErr != nil
+	ss.degradedErr = nil
+	cb := ss.onAvailabilityChange
+	ss.degradedMu.Unlock()
+
+	stateBackupDegraded.Set(0)
+	if wasDegraded {
+		stateLogger.Info("Redis is available again; state backup resumed")
+		if cb != nil {
+			cb(nil)
+		}
+	}
+}
+
+// reconnect retries Redis in degraded mode. Once connected, state changed in memory since Redis became
+// unavailable is saved, and the rest is loaded from what was saved before.
+func (ss *SyncState) reconnect() {
+	if err := ss.connect(); err != nil {
+		ss.setDegraded(err)
+		stateLogger.Info(fmt.Sprintf("Waiting another %s for Redis availability (%v)", redisRetryInterval, err))
+		return
+	}
+	for _, kind := range stateKinds {
+		var err error
+		if ss.unsaved[kind] {
+			// Redis may have lost what was persisted, e.g. if it restarted, so it's written in full
+			if kind != stateDefaults {
+				ss.markRewrite(kind)
+			}
+			err = ss.save(ss.ctx, kind)
+		} else {
+			err = ss.load(kind)
+		}
 		if err != nil {
-			time.Sleep(30 * time.Second)
-			logger.Info(fmt.Sprintf("Waiting another 30 seconds for Redis availability (%v)", err))
-			goto RetryRedis
+			ss.setDegraded(err)
+			return
 		}
+		delete(ss.unsaved, kind)
+	}
+	ss.setAvailable()
+}
+
+func (ss *SyncState) launchAsyncStateBackupLoop(ctx context.Context) {
 
-		// then watch the update signal channels and persist the associated key to Redis
+	go func() {
+		defer close(ss.loopDone)
+		retry := time.NewTicker(redisRetryInterval)
+		defer retry.Stop()
+
+		// watch the update signal channels and collect the changed kinds of state, persisting them to Redis
+		// together once stateSaveInterval has passed, or marking them unsaved until Redis is available
+		pending := make(map[string]bool)
+		var flush <-chan time.Time
+		// Fires when the state will have diverged from what's persisted for longer than the threshold
+		var alert <-chan time.Time
 		for {
+			var kind string
 			select {
 			case <-ctx.Done():
-				logger.Info("Received done signal, closing asynchronous state backup loop...")
+				stateLogger.Info("Received done signal, closing asynchronous state backup loop...")
+				if degraded, _ := ss.Degraded(); !degraded {
+					// The sync's context is done, so the last changes are saved with one of their own. Every kind is
+					// saved, since a change may not have been signaled yet.
+					saveCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+					if err := ss.save(saveCtx, stateKinds...); err != nil {
+						stateLogger.Error(err, "Failed to save the latest state before closing")
+					}
+					cancel()
+				}
 				return
-			case <-ss.saveChans["gm"]:
-				ss.persistGMHashesToRedis(ss.previousGMHashes, defaults.GitOpsStateKeyGM)
-			case <-ss.saveChans["k8s"]:
-				ss.persistK8sHashesToRedis(ss.previousK8sHashes, defaults.GitOpsStateKeyK8s)
+			case <-retry.C:
+				if degraded, _ := ss.Degraded(); degraded {
+					ss.reconnect()
+					if degraded, _ := ss.Degraded(); !degraded && len(pending) == 0 {
+						ss.converge(time.Now())
+					}
+				}
+				continue
+			case <-alert:
+				alert = nil
+				if wait := ss.checkDivergence(time.Now()); wait > 0 {
+					alert = time.After(wait)
+				}
+				continue
+			case <-flush:
+				flush = nil
+				kinds := sortedKinds(pending)
+				pending = make(map[string]bool)
+				if degraded, _ := ss.Degraded(); degraded {
+					for _, kind := range kinds {
+						ss.unsaved[kind] = true
+					}
+					continue
+				}
+				if err := ss.save(ctx, kinds...); err != nil {
+					for _, kind := range kinds {
+						ss.unsaved[kind] = true
+					}
+					ss.setDegraded(err)
+					continue
+				}
+				if len(ss.unsaved) == 0 {
+					ss.converge(time.Now())
+				}
+				continue
+			case <-ss.saveChans[stateGM]:
+				kind = stateGM
+			case <-ss.saveChans[stateK8s]:
+				kind = stateK8s
+			case <-ss.saveChans[stateDefaults]:
+				kind = stateDefaults
+			}
+
+			pending[kind] = true
+			if flush == nil {
+				flush = time.After(stateSaveInterval)
+			}
+			if ss.diverge(time.Now()) && alert == nil {
+				if wait := ss.checkDivergence(time.Now()); wait > 0 {
+					alert = time.After(wait)
+				}
 			}
 		}
 
 	}()
 }
 
-func (ss *SyncState) persistGMHashesToRedis(hashes map[string]GMObjectRef, key string) {
-	b, err := json.Marshal(hashes)
-	if err != nil {
-		logger.Error(err, "Failed to serialize GM environment state hashes (for backup to Redis)", "hashes", hashes)
-		return
-	}
-	if err := ss.redis.Set(ss.ctx, key, b, 0).Err(); err != nil {
-		logger.Error(err, "Failed to save GM environment state hashes to Redis", "hashes", hashes)
+// sortedKinds returns the kinds of state in a set in the order of stateKinds.
+func sortedKinds(set map[string]bool) []string {
+	var kinds []string
+	for _, kind := range stateKinds {
+		if set[kind] {
+			kinds = append(kinds, kind)
+		}
 	}
+	return kinds
 }
 
-func (ss *SyncState) persistK8sHashesToRedis(hashes map[string]K8sObjectRef, key string) {
-	b, err := json.Marshal(hashes)
-	if err != nil {
-		logger.Error(err, "Failed to serialize K8s environment state hashes (for backup to Redis)", "hashes", hashes)
-		return
-	}
-	if err := ss.redis.Set(ss.ctx, key, b, 0).Err(); err != nil {
-		logger.Error(err, "Failed to save K8s environment state hashes to Redis", "hashes", hashes)
+func derivedDefaultsKey(defaults cuemodule.Defaults) string {
+	if defaults.GitOpsStateKeyDefaults != "" {
+		return defaults.GitOpsStateKeyDefaults
 	}
+	return defaultStateKeyDefaults
 }
@@ -0,0 +1,173 @@
+package gitops
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Key of the Catalog registrations not yet made, in the state's namespace (see StateNamespace).
+const stateKeyCatalogQueue = "gm-operator-catalog-queue"
+
+// CatalogRegistration is a Catalog mesh or service the operator is creating or updating, persisted until Catalog
+// accepts it so that registrations which fail, or are cut short by the operator stopping, are made eventually.
+type CatalogRegistration struct {
+	Mesh string `json:"mesh"`
+	// catalogmesh or catalogservice
+	Kind string `json:"kind"`
+	// The service ID of a catalogservice, or the mesh ID of a catalogmesh
+	Key string `json:"key"`
+	// The catalogservice to apply, or the zone sessions to add to a catalogmesh
+	Object json.RawMessage `json:"object"`
+	Queued time.Time       `json:"queued"`
+	// How many times the registration has failed, and the last error it failed with
+	Failures  int    `json:"failures,omitempty"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// ID identifies the registration within the queue; a later registration of the same object replaces it.
+func (reg CatalogRegistration) ID() string {
+	return reg.Mesh + "/" + reg.Kind + "/" + reg.Key
+}
+
+// catalogQueue holds the Catalog registrations not yet made, by ID.
+type catalogQueue struct {
+	pending map[string]CatalogRegistration
+	mu      sync.Mutex
+}
+
+// catalogQueueKey returns the key the Catalog registrations not yet made are saved under.
+func (ss *SyncState) catalogQueueKey() string {
+	return ss.namespace + stateKeyCatalogQueue
+}
+
+// QueueCatalogRegistration records a Catalog registration that's about to be sent, replacing any earlier one of the
+// same object, until CatalogRegistrationDone. It returns the registration as queued.
+func (ss *SyncState) QueueCatalogRegistration(reg CatalogRegistration) CatalogRegistration {
+	ss.catalog.mu.Lock()
+	defer ss.catalog.mu.Unlock()
+	if ss.catalog.pending == nil {
+		ss.catalog.pending = make(map[string]CatalogRegistration)
+	}
+	reg.Queued, reg.Failures, reg.LastError = time.Now().UTC(), 0, ""
+	ss.catalog.pending[reg.ID()] = reg
+	ss.persistCatalogRegistration(reg.ID(), &reg)
+	return reg
+}
+
+// CatalogRegistrationFailed records that an attempt at a queued Catalog registration failed. It's ignored if the
+// registration has since been replaced.
+func (ss *SyncState) CatalogRegistrationFailed(reg CatalogRegistration, err error) {
+	ss.catalog.mu.Lock()
+	defer ss.catalog.mu.Unlock()
+	current, ok := ss.catalog.pending[reg.ID()]
+	if !ok || !current.Queued.Equal(reg.Queued) {
+		return
+	}
+	current.Failures++
+	current.LastError = err.Error()
+	ss.catalog.pending[reg.ID()] = current
+	ss.persistCatalogRegistration(reg.ID(), &current)
+}
+
+// CatalogRegistrationDone removes a Catalog registration from the queue once it's been made or given up on, unless it
+// has since been replaced.
+func (ss *SyncState) CatalogRegistrationDone(reg CatalogRegistration) {
+	ss.catalog.mu.Lock()
+	defer ss.catalog.mu.Unlock()
+	if current, ok := ss.catalog.pending[reg.ID()]; ok && current.Queued.Equal(reg.Queued) {
+		ss.dropCatalogRegistration(reg.ID())
+	}
+}
+
+// DropCatalogRegistrations removes the given mesh's Catalog registrations of the given kind and key from the queue,
+// e.g. once their object is deleted, or all of the mesh's registrations if kind is empty.
+func (ss *SyncState) DropCatalogRegistrations(mesh, kind, key string) {
+	ss.catalog.mu.Lock()
+	defer ss.catalog.mu.Unlock()
+	for id, reg := range ss.catalog.pending {
+		if reg.Mesh == mesh && (kind == "" || reg.Kind == kind && reg.Key == key) {
+			ss.dropCatalogRegistration(id)
+		}
+	}
+}
+
+func (ss *SyncState) dropCatalogRegistration(id string) {
+	delete(ss.catalog.pending, id)
+	ss.persistCatalogRegistration(id, nil)
+}
+
+// PendingCatalogRegistrations returns the given mesh's Catalog registrations not yet made, oldest first.
+func (ss *SyncState) PendingCatalogRegistrations(mesh string) []CatalogRegistration {
+	ss.catalog.mu.Lock()
+	defer ss.catalog.mu.Unlock()
+	var regs []CatalogRegistration
+	for _, reg := range ss.catalog.pending {
+		if reg.Mesh == mesh {
+			regs = append(regs, reg)
+		}
+	}
+	sort.Slice(regs, func(i, j int) bool {
+		if !regs[i].Queued.Equal(regs[j].Queued) {
+			return regs[i].Queued.Before(regs[j].Queued)
+		}
+		return regs[i].ID() < regs[j].ID()
+	})
+	return regs
+}
+
+// persistCatalogRegistration saves a queued Catalog registration, or deletes it if nil. Like the sync cycle in
+// progress it's saved immediately, and not at all while the store is unavailable.
+func (ss *SyncState) persistCatalogRegistration(id string, reg *CatalogRegistration) {
+	ss.storeMu.RLock()
+	defer ss.storeMu.RUnlock()
+	if degraded, _ := ss.Degraded(); degraded || ss.store == nil {
+		return
+	}
+	key := ss.catalogQueueKey()
+	var b []byte
+	if reg != nil {
+		var err error
+		if b, err = json.Marshal(reg); err != nil {
+			stateLogger.Error(err, "Failed to serialize Catalog registration", "key", key, "id", id)
+			return
+		}
+	}
+	if err := ss.store.Update(ss.ctx, func(tx StateTx) {
+		if reg == nil {
+			tx.HDel(key, id)
+		} else {
+			tx.HSet(key, map[string]string{id: string(b)})
+		}
+	}); err != nil {
+		stateLogger.Error(err, "Failed to save Catalog registration", "key", key, "id", id)
+	}
+}
+
+// loadCatalogQueue loads the Catalog registrations not yet made when the operator last stopped.
+func (ss *SyncState) loadCatalogQueue() error {
+	key := ss.catalogQueueKey()
+	ss.storeMu.RLock()
+	entries, err := ss.store.HGetAll(ss.ctx, key)
+	ss.storeMu.RUnlock()
+	if err != nil {
+		return err
+	}
+	pending := make(map[string]CatalogRegistration, len(entries))
+	for id, entry := range entries {
+		var reg CatalogRegistration
+		if err := json.Unmarshal([]byte(entry), &reg); err != nil {
+			stateLogger.Error(err, "Problem unmarshaling Catalog registration", "key", key, "id", id)
+			continue
+		}
+		pending[id] = reg
+	}
+	ss.catalog.mu.Lock()
+	defer ss.catalog.mu.Unlock()
+	ss.catalog.pending = pending
+	if len(pending) > 0 {
+		stateLogger.Info("Found Catalog registrations not yet made when the operator last stopped", "count", len(pending))
+	}
+	return nil
+}
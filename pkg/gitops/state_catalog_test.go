@@ -0,0 +1,46 @@
+package gitops
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCatalogRegistrationQueue(t *testing.T) {
+	defaults := cuemodule.Defaults{StateFile: filepath.Join(t.TempDir(), "state.db")}
+	ss := NewSyncState(context.Background(), defaults, "gm-operator:")
+
+	edge := ss.QueueCatalogRegistration(CatalogRegistration{Mesh: "mesh", Kind: "catalogservice", Key: "edge", Object: json.RawMessage(`{"service_id":"edge","mesh_id":"mesh"}`)})
+	example := ss.QueueCatalogRegistration(CatalogRegistration{Mesh: "mesh", Kind: "catalogservice", Key: "example", Object: json.RawMessage(`{"service_id":"example","mesh_id":"mesh"}`)})
+	ss.QueueCatalogRegistration(CatalogRegistration{Mesh: "other", Kind: "catalogmesh", Key: "other", Object: json.RawMessage(`{"west":"control.west:50000"}`)})
+	ss.CatalogRegistrationDone(example)
+
+	// A registration replaced by a later one of the same object stays queued until the later one is made
+	replaced := ss.QueueCatalogRegistration(edge)
+	ss.CatalogRegistrationDone(edge)
+	ss.CatalogRegistrationFailed(edge, errors.New("stale"))
+	ss.CatalogRegistrationFailed(replaced, errors.New("catalog unavailable"))
+	assert.Len(t, ss.PendingCatalogRegistrations("mesh"), 1)
+	assert.NoError(t, ss.store.Close())
+
+	// Registrations not yet made are picked up again after a restart
+	restarted := NewSyncState(context.Background(), defaults, "gm-operator:")
+	defer restarted.store.Close()
+	pending := restarted.PendingCatalogRegistrations("mesh")
+	if assert.Len(t, pending, 1) {
+		assert.Equal(t, "mesh/catalogservice/edge", pending[0].ID())
+		assert.Equal(t, 1, pending[0].Failures)
+		assert.Equal(t, "catalog unavailable", pending[0].LastError)
+		assert.True(t, replaced.Queued.Equal(pending[0].Queued))
+	}
+	restarted.DropCatalogRegistrations("mesh", "catalogservice", "edge")
+	assert.Empty(t, restarted.PendingCatalogRegistrations("mesh"))
+	assert.Len(t, restarted.PendingCatalogRegistrations("other"), 1)
+	restarted.DropCatalogRegistrations("other", "", "")
+	assert.Empty(t, restarted.PendingCatalogRegistrations("other"))
+}
@@ -0,0 +1,107 @@
+package gitops
+
+import "sync"
+
+// stateChanges tracks the entries of the GM and K8s state changed since they were last persisted to Redis, so only
+// those are written (with HSET and HDEL) rather than the whole state.
+//
+// Entries are persisted without the sync cycle stamped on every object in the config (see persistedGM), so an object
+// only changes when its hash does, or when it goes missing from the config or comes back.
+type stateChanges struct {
+	// Hash keys of the changed entries, by kind of state
+	dirty map[string]map[string]bool
+	// Kinds of state to write in full, e.g. after a reset or when Redis may have lost them
+	rewrite map[string]bool
+	mu      sync.Mutex
+}
+
+// markChanged records changed entries of a kind of state.
+func (ss *SyncState) markChanged(kind string, keys ...string) {
+	if len(keys) == 0 {
+		return
+	}
+	ss.changes.mu.Lock()
+	defer ss.changes.mu.Unlock()
+	if ss.changes.dirty == nil {
+		ss.changes.dirty = make(map[string]map[string]bool)
+	}
+	if ss.changes.dirty[kind] == nil {
+		ss.changes.dirty[kind] = make(map[string]bool)
+	}
+	for _, key := range keys {
+		ss.changes.dirty[kind][key] = true
+	}
+}
+
+// markRewrite records that a kind of state is to be written in full.
+func (ss *SyncState) markRewrite(kind string) {
+	ss.changes.mu.Lock()
+	defer ss.changes.mu.Unlock()
+	if ss.changes.rewrite == nil {
+		ss.changes.rewrite = make(map[string]bool)
+	}
+	ss.changes.rewrite[kind] = true
+	delete(ss.changes.dirty, kind)
+}
+
+// takeChanges returns and clears the changed entries of a kind of state, and whether it's to be written in full.
+func (ss *SyncState) takeChanges(kind string) (keys []string, rewrite bool) {
+	ss.changes.mu.Lock()
+	defer ss.changes.mu.Unlock()
+	for key := range ss.changes.dirty[kind] {
+		keys = append(keys, key)
+	}
+	rewrite = ss.changes.rewrite[kind]
+	delete(ss.changes.dirty, kind)
+	delete(ss.changes.rewrite, kind)
+	return keys, rewrite
+}
+
+// persistedGM returns an entry of the GM state as it's persisted as of a sync cycle: without its LastSeen cycle if
+// it was seen in that one, since it's restored from the cycle persisted alongside.
+func persistedGM(ref GMObjectRef, cycle int) GMObjectRef {
+	if ref.LastSeen == cycle {
+		ref.LastSeen = 0
+	}
+	return ref
+}
+
+// persistedK8s is persistedGM for an entry of the K8s state.
+func persistedK8s(ref K8sObjectRef, cycle int) K8sObjectRef {
+	if ref.LastSeen == cycle {
+		ref.LastSeen = 0
+	}
+	return ref
+}
+
+// changedGM returns the hash keys of the entries of the GM state persisted differently after a sync cycle.
+func changedGM(prev, next map[string]GMObjectRef, prevCycle, cycle int) []string {
+	var keys []string
+	for key, ref := range next {
+		if prevRef, ok := prev[key]; !ok || persistedGM(prevRef, prevCycle) != persistedGM(ref, cycle) {
+			keys = append(keys, key)
+		}
+	}
+	for key := range prev {
+		if _, ok := next[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// changedK8s is changedGM for the K8s state.
+func changedK8s(prev, next map[string]K8sObjectRef, prevCycle, cycle int) []string {
+	var keys []string
+	for key, ref := range next {
+		if prevRef, ok := prev[key]; !ok || persistedK8s(prevRef, prevCycle) != persistedK8s(ref, cycle) {
+			keys = append(keys, key)
+		}
+	}
+	for key := range prev {
+		if _, ok := next[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
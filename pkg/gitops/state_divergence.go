@@ -0,0 +1,125 @@
+package gitops
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// How long the in-memory state may have changes not persisted to Redis before it's reported as diverged,
+// if not configured.
+const defaultDivergenceThreshold = 5 * time.Minute
+
+// divergenceTracker tracks how long the in-memory state has had changes not yet persisted to Redis, e.g. while
+// Redis is unavailable or rejects writes, so it can be alerted on before a restart would lose them.
+type divergenceTracker struct {
+	// How long the state may diverge before it's reported
+	threshold time.Duration
+	// When the state first diverged from what's persisted, or zero if it hasn't
+	since time.Time
+	// Whether the divergence has been reported
+	reported bool
+	onChange func(since time.Time)
+	mu       sync.Mutex
+}
+
+// When the state was last persisted to Redis (or the operator started), in Unix nanoseconds, for the persisted age
+// metric.
+var lastPersisted int64
+
+// SetDivergenceThreshold sets how long the in-memory state may have changes not persisted to Redis before it's
+// reported as diverged. Defaults to 5 minutes.
+func (ss *SyncState) SetDivergenceThreshold(threshold time.Duration) {
+	ss.divergence.mu.Lock()
+	defer ss.divergence.mu.Unlock()
+	ss.divergence.threshold = threshold
+}
+
+// OnDivergenceChange registers a callback that is called with the time the in-memory state diverged from the state
+// persisted to Redis once it's been diverged longer than the threshold, and with the zero time once the state is
+// persisted again. It's immediately called with the current divergence.
+func (ss *SyncState) OnDivergenceChange(cb func(since time.Time)) {
+	ss.divergence.mu.Lock()
+	ss.divergence.onChange = cb
+	var since time.Time
+	if ss.divergence.reported {
+		since = ss.divergence.since
+	}
+	ss.divergence.mu.Unlock()
+	cb(since)
+}
+
+// diverge records that the in-memory state has changes not yet persisted, reporting whether it had none before.
+func (ss *SyncState) diverge(now time.Time) bool {
+	ss.divergence.mu.Lock()
+	defer ss.divergence.mu.Unlock()
+	if !ss.divergence.since.IsZero() {
+		return false
+	}
+	ss.divergence.since = now
+	return true
+}
+
+// checkDivergence reports the divergence if the state has diverged for longer than the threshold, otherwise
+// returning how long until it will have, or 0 if it isn't diverged or was already reported.
+func (ss *SyncState) checkDivergence(now time.Time) time.Duration {
+	ss.divergence.mu.Lock()
+	if ss.divergence.since.IsZero() || ss.divergence.reported {
+		ss.divergence.mu.Unlock()
+		return 0
+	}
+	threshold := ss.divergence.threshold
+	if threshold <= 0 {
+		threshold = defaultDivergenceThreshold
+	}
+	if wait := ss.divergence.since.Add(threshold).Sub(now); wait > 0 {
+		ss.divergence.mu.Unlock()
+		return wait
+	}
+	ss.divergence.reported = true
+	since, cb := ss.divergence.since, ss.divergence.onChange
+	ss.divergence.mu.Unlock()
+
+	stateDiverged.Set(1)
+	stateLogger.Info("Operator state has changes not persisted to Redis for longer than the threshold", "since", since, "threshold", threshold)
+	if cb != nil {
+		cb(since)
+	}
+	return 0
+}
+
+// converge records that the in-memory state is persisted, reporting it if its divergence was reported.
+func (ss *SyncState) converge(now time.Time) {
+	atomic.StoreInt64(&lastPersisted, now.UnixNano())
+	ss.divergence.mu.Lock()
+	reported, cb := ss.divergence.reported, ss.divergence.onChange
+	ss.divergence.since, ss.divergence.reported = time.Time{}, false
+	ss.divergence.mu.Unlock()
+
+	if reported {
+		stateDiverged.Set(0)
+		stateLogger.Info("Operator state is persisted to Redis again")
+		if cb != nil {
+			cb(time.Time{})
+		}
+	}
+}
+
+// persistedAge returns the time since the state was last persisted to Redis (or the operator started).
+func persistedAge() time.Duration {
+	at := atomic.LoadInt64(&lastPersisted)
+	if at == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, at))
+}
+
+// recordObjects updates the metric of the objects tracked in a kind of state. The caller holds hashesMu.
+func (ss *SyncState) recordObjects(kind string) {
+	switch kind {
+	case stateGM:
+		stateObjects.WithLabelValues(stateGM).Set(float64(len(ss.previousGMHashes)))
+	case stateK8s:
+		stateObjects.WithLabelValues(stateK8s).Set(float64(len(ss.previousK8sHashes)))
+	}
+}
@@ -0,0 +1,163 @@
+package gitops
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Key of the sync cycle in progress, in the state's namespace (see StateNamespace).
+const stateKeyProgress = "gm-operator-sync-in-progress"
+
+// SyncProgress is a sync cycle in progress, persisted while it's applied so that an operator stopped mid-cycle (e.g.
+// by a crash) re-runs it on startup, rather than leaving the mesh partially applied until the next commit.
+type SyncProgress struct {
+	// The commit being applied
+	SHA     string    `json:"sha"`
+	Started time.Time `json:"started"`
+	// Objects the cycle found removed from the config, which may not all have been deleted
+	DeletingGM  []GMObjectRef  `json:"deleting_gm,omitempty"`
+	DeletingK8s []K8sObjectRef `json:"deleting_k8s,omitempty"`
+}
+
+// progressTracker tracks the sync cycle in progress, and the one found interrupted on startup.
+type progressTracker struct {
+	current     *SyncProgress
+	interrupted *SyncProgress
+	mu          sync.Mutex
+}
+
+// progressKey returns the key the sync cycle in progress is saved under.
+func (ss *SyncState) progressKey() string {
+	return ss.namespace + stateKeyProgress
+}
+
+// BeginCycle records that a sync cycle applying the given commit has started, until EndCycle.
+func (ss *SyncState) BeginCycle(sha string) {
+	ss.progress.mu.Lock()
+	defer ss.progress.mu.Unlock()
+	ss.progress.current = &SyncProgress{SHA: sha, Started: time.Now().UTC()}
+	ss.persistProgress(ss.progress.current)
+}
+
+// EndCycle records that the sync cycle in progress has finished, whether or not every object was applied.
+func (ss *SyncState) EndCycle() {
+	ss.progress.mu.Lock()
+	defer ss.progress.mu.Unlock()
+	if ss.progress.current == nil {
+		return
+	}
+	ss.progress.current = nil
+	ss.persistProgress(nil)
+}
+
+// recordDeleting adds objects found removed from the config to the sync cycle in progress, if any, so they're deleted
+// again if it's interrupted.
+func (ss *SyncState) recordDeleting(gm []GMObjectRef, k8s []K8sObjectRef) {
+	if len(gm) == 0 && len(k8s) == 0 {
+		return
+	}
+	ss.progress.mu.Lock()
+	defer ss.progress.mu.Unlock()
+	if ss.progress.current == nil {
+		return
+	}
+	progress := *ss.progress.current
+	progress.DeletingGM = append(append([]GMObjectRef(nil), progress.DeletingGM...), gm...)
+	progress.DeletingK8s = append(append([]K8sObjectRef(nil), progress.DeletingK8s...), k8s...)
+	ss.progress.current = &progress
+	ss.persistProgress(ss.progress.current)
+}
+
+// persistProgress saves the sync cycle in progress, or deletes it if nil. Unlike the rest of the state it's saved
+// immediately rather than by the backup loop, and not at all while the store is unavailable.
+func (ss *SyncState) persistProgress(progress *SyncProgress) {
+	ss.storeMu.RLock()
+	defer ss.storeMu.RUnlock()
+	if degraded, _ := ss.Degraded(); degraded || ss.store == nil {
+		return
+	}
+	key := ss.progressKey()
+	var b []byte
+	if progress != nil {
+		var err error
+		if b, err = json.Marshal(progress); err != nil {
+			stateLogger.Error(err, "Failed to serialize sync cycle in progress", "key", key)
+			return
+		}
+	}
+	if err := ss.store.Update(ss.ctx, func(tx StateTx) {
+		if progress == nil {
+			tx.Del(key)
+		} else {
+			tx.Set(key, b)
+		}
+	}); err != nil {
+		stateLogger.Error(err, "Failed to save sync cycle in progress", "key", key)
+	}
+}
+
+// loadProgress looks for a sync cycle that was in progress when the operator last stopped.
+func (ss *SyncState) loadProgress() error {
+	key := ss.progressKey()
+	ss.storeMu.RLock()
+	b, err := ss.store.Get(ss.ctx, key)
+	ss.storeMu.RUnlock()
+	if err == errStateNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var interrupted SyncProgress
+	if err := json.Unmarshal(b, &interrupted); err != nil {
+		stateLogger.Error(err, "Problem unmarshaling sync cycle in progress", "key", key)
+		return nil
+	}
+	ss.progress.mu.Lock()
+	defer ss.progress.mu.Unlock()
+	ss.progress.interrupted = &interrupted
+	stateLogger.Info("Found a sync cycle interrupted when the operator last stopped", "Commit", interrupted.SHA, "Started", interrupted.Started)
+	return nil
+}
+
+// takeInterrupted returns the sync cycle found interrupted on startup, if any, only once.
+func (ss *SyncState) takeInterrupted() (SyncProgress, bool) {
+	ss.progress.mu.Lock()
+	defer ss.progress.mu.Unlock()
+	if ss.progress.interrupted == nil {
+		return SyncProgress{}, false
+	}
+	interrupted := *ss.progress.interrupted
+	ss.progress.interrupted = nil
+	return interrupted, true
+}
+
+// resume makes the next sync cycle re-run an interrupted one in full. Every object is applied again, since the
+// interrupted cycle saved the hashes of objects before it applied them, and the objects it was deleting are deleted
+// again unless they're back in the config.
+func (ss *SyncState) resume(interrupted SyncProgress) {
+	ss.hashesMu.Lock()
+	defer ss.hashesMu.Unlock()
+	gm := make(map[string]GMObjectRef, len(ss.previousGMHashes))
+	for key, ref := range ss.previousGMHashes {
+		ref.Hash = 0
+		gm[key] = ref
+	}
+	for _, ref := range interrupted.DeletingGM {
+		ref.Hash, ref.LastSeen = 0, ss.gmCycle+1-ss.retention()
+		gm[ref.HashKey()] = ref
+	}
+	k8s := make(map[string]K8sObjectRef, len(ss.previousK8sHashes))
+	for key, ref := range ss.previousK8sHashes {
+		ref.Hash = 0
+		k8s[key] = ref
+	}
+	for _, ref := range interrupted.DeletingK8s {
+		ref.Hash, ref.LastSeen = 0, ss.k8sCycle+1-ss.retention()
+		k8s[ref.HashKey()] = ref
+	}
+	ss.previousGMHashes = gm
+	ss.previousK8sHashes = k8s
+	syncCyclesResumed.Inc()
+}
@@ -0,0 +1,63 @@
+package gitops
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResumeInterruptedCycle(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	defaults := cuemodule.Defaults{StateFile: path, GitOpsStateKeyGM: "gm-state", GitOpsStateKeyK8s: "k8s-state"}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	kinds := []string{"cluster", "cluster"}
+	configObjects := []json.RawMessage{
+		[]byte(`{"cluster_key": "grapefruit", "zone_key": "default-zone"}`),
+		[]byte(`{"cluster_key": "lemon", "zone_key": "default-zone"}`),
+	}
+	ss := NewSyncState(ctx, defaults, "gm-operator:")
+	ss.FilterChangedGM(configObjects, kinds)
+	assert.NoError(t, ss.save(ss.ctx, stateGM))
+
+	// A finished cycle leaves nothing to resume
+	ss.BeginCycle("aaa")
+	ss.EndCycle()
+	assert.NoError(t, ss.loadProgress())
+	_, found := ss.takeInterrupted()
+	assert.False(t, found)
+
+	// The operator stops after the next cycle saved its hashes, but before it applied or deleted anything
+	ss.BeginCycle("bbb")
+	changed := []json.RawMessage{[]byte(`{"cluster_key": "grapefruit", "zone_key": "default-zone", "name": "pink"}`)}
+	filtered, _, deleted := ss.FilterChangedGM(changed, kinds[:1])
+	assert.Len(t, filtered, 1)
+	assert.Len(t, deleted, 1)
+	assert.NoError(t, ss.save(ss.ctx, stateGM))
+	cancel()
+	assert.NoError(t, ss.store.Close())
+
+	restarted := NewSyncState(context.Background(), defaults, "gm-operator:")
+	defer restarted.store.Close()
+	interrupted, found := restarted.takeInterrupted()
+	if !assert.True(t, found) {
+		return
+	}
+	assert.Equal(t, "bbb", interrupted.SHA)
+	assert.Equal(t, []GMObjectRef{deleted[0]}, interrupted.DeletingGM)
+	_, found = restarted.takeInterrupted()
+	assert.False(t, found, "an interrupted cycle is only resumed once")
+
+	// Without resuming, the interrupted cycle's changes would never be applied
+	restarted.resume(interrupted)
+	filtered, _, deleted = restarted.FilterChangedGM(changed, kinds[:1])
+	assert.Equal(t, changed, filtered)
+	if assert.Len(t, deleted, 1) {
+		assert.Equal(t, "lemon", deleted[0].ID)
+	}
+}
@@ -0,0 +1,103 @@
+package gitops
+
+import (
+	"encoding/json"
+
+	"github.com/mitchellh/hashstructure/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// StateSchemaVersion is the version of the rules objects are hashed by in the operator's state. Each entry is stamped
+// with the version it was hashed by, so an upgraded operator still tells which objects changed since an earlier one
+// hashed them, by hashing them again by the earlier rules, rather than applying every object again. Entries are
+// rehashed by the current rules as their objects are next seen.
+//
+//  1. Grey Matter config objects hashed as extracted (the unversioned state of earlier operators)
+//  2. Grey Matter config objects hashed as canonical JSON, so reordering their fields in the CUE doesn't change them
+const StateSchemaVersion = 2
+
+// gmHashers hash a Grey Matter config object by the rules of each state schema version.
+var gmHashers = map[int]func(objBytes []byte) uint64{
+	1: hashRaw,
+	2: hashCanonicalJSON,
+}
+
+// k8sHashers hash a K8s object by the rules of each state schema version.
+var k8sHashers = map[int]func(object client.Object) uint64{
+	1: hashK8sObject,
+	2: hashK8sObject,
+}
+
+func hashRaw(objBytes []byte) uint64 {
+	hash, _ := hashstructure.Hash(objBytes, hashstructure.FormatV2, nil)
+	return hash
+}
+
+// hashCanonicalJSON hashes a JSON object compacted with its keys sorted, or as it is if it isn't valid JSON.
+func hashCanonicalJSON(objBytes []byte) uint64 {
+	var obj interface{}
+	if err := json.Unmarshal(objBytes, &obj); err != nil {
+		return hashRaw(objBytes)
+	}
+	canonical, err := json.Marshal(obj)
+	if err != nil {
+		return hashRaw(objBytes)
+	}
+	return hashRaw(canonical)
+}
+
+func hashK8sObject(object client.Object) uint64 {
+	hash, _ := hashstructure.Hash(object, hashstructure.FormatV2, nil)
+	return hash
+}
+
+// schemaVersion returns the state schema version an entry was hashed by; unstamped entries predate versioning.
+func schemaVersion(hashVersion int) int {
+	if hashVersion == 0 {
+		return 1
+	}
+	return hashVersion
+}
+
+// unchanged reports whether a config object, referenced by ref, is the one this entry was hashed from. An entry
+// hashed by a newer operator's rules is never unchanged, so the object is applied again.
+func (prev GMObjectRef) unchanged(objBytes []byte, ref *GMObjectRef) bool {
+	version := schemaVersion(prev.HashVersion)
+	if version == ref.HashVersion {
+		return prev.Hash == ref.Hash
+	}
+	hasher, ok := gmHashers[version]
+	return ok && prev.Hash == hasher(objBytes)
+}
+
+// unchanged is GMObjectRef.unchanged for a K8s object.
+func (prev K8sObjectRef) unchanged(object client.Object, ref *K8sObjectRef) bool {
+	version := schemaVersion(prev.HashVersion)
+	if version == ref.HashVersion {
+		return prev.Hash == ref.Hash
+	}
+	hasher, ok := k8sHashers[version]
+	return ok && prev.Hash == hasher(object)
+}
+
+// logSchemaVersions logs how many of the loaded entries of a kind of state were hashed by earlier or later rules
+// than this operator's, if any.
+func logSchemaVersions(kind string, hashVersions []int) {
+	var earlier, later int
+	for _, hashVersion := range hashVersions {
+		switch version := schemaVersion(hashVersion); {
+		case version < StateSchemaVersion:
+			earlier++
+		case version > StateSchemaVersion:
+			later++
+		}
+	}
+	if earlier > 0 {
+		stateLogger.Info("Saved state was hashed by an earlier operator; its entries are rehashed as their objects are next seen",
+			"kind", kind, "entries", earlier, "version", StateSchemaVersion)
+	}
+	if later > 0 {
+		stateLogger.Info("Saved state was hashed by a later operator; its objects are applied again when next seen",
+			"kind", kind, "entries", later, "version", StateSchemaVersion)
+	}
+}
@@ -0,0 +1,38 @@
+package gitops
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStateSchemaMigration(t *testing.T) {
+	kinds := []string{"cluster"}
+	grapefruit := json.RawMessage(`{"cluster_key": "grapefruit", "zone_key": "default-zone"}`)
+	ref := NewGMObjectRef(grapefruit, "cluster")
+	assert.Equal(t, StateSchemaVersion, ref.HashVersion)
+
+	// State saved before it was versioned hashed objects as extracted
+	unversioned := *ref
+	unversioned.Hash, unversioned.HashVersion = gmHashers[1](grapefruit), 0
+	ss := &SyncState{previousGMHashes: map[string]GMObjectRef{ref.HashKey(): unversioned}, previousK8sHashes: map[string]K8sObjectRef{}}
+	assert.True(t, ss.Simulate(nil, []json.RawMessage{grapefruit}, kinds).Empty())
+	filtered, _, deleted := ss.FilterChangedGM([]json.RawMessage{grapefruit}, kinds)
+	assert.Empty(t, filtered, "an upgrade doesn't re-apply unchanged objects")
+	assert.Empty(t, deleted)
+	assert.Equal(t, StateSchemaVersion, ss.previousGMHashes[ref.HashKey()].HashVersion, "entries are rehashed by the current rules")
+
+	// Reordering an object's fields doesn't change it
+	reordered := json.RawMessage(`{"zone_key": "default-zone", "cluster_key": "grapefruit"}`)
+	filtered, _, _ = ss.FilterChangedGM([]json.RawMessage{reordered}, kinds)
+	assert.Empty(t, filtered)
+
+	// State saved by a later operator's rules can't be compared, so its objects are applied again
+	later := *ref
+	later.HashVersion = StateSchemaVersion + 1
+	ss.previousGMHashes = map[string]GMObjectRef{ref.HashKey(): later}
+	filtered, _, deleted = ss.FilterChangedGM([]json.RawMessage{grapefruit}, kinds)
+	assert.Equal(t, []json.RawMessage{grapefruit}, filtered)
+	assert.Empty(t, deleted)
+}
@@ -0,0 +1,92 @@
+package gitops
+
+import (
+	"context"
+	"errors"
+
+	"github.com/go-redis/redis/v9"
+)
+
+// errStateNotFound is returned by a StateStore's Get for a key with nothing saved under it.
+var errStateNotFound = errors.New("no state saved under key")
+
+// StateStore persists the operator's state: single values, and hashes of entries by field, under string keys.
+// Redis is the default; a BoltDB file can stand in for it when a single operator replica owns its state.
+type StateStore interface {
+	// Ping reports whether the store can be used.
+	Ping(ctx context.Context) error
+	// Get returns the value saved under a key, or errStateNotFound.
+	Get(ctx context.Context, key string) ([]byte, error)
+	// HGetAll returns the entries of the hash saved under a key, which is empty if there's none.
+	HGetAll(ctx context.Context, key string) (map[string]string, error)
+	// Update makes the writes of the given function in a single transaction.
+	Update(ctx context.Context, writes func(tx StateTx)) error
+	Close() error
+}
+
+// StateTx collects the writes made in a StateStore transaction.
+type StateTx interface {
+	Set(key string, value []byte)
+	// Del deletes the value or hash saved under a key.
+	Del(key string)
+	HSet(key string, entries map[string]string)
+	HDel(key string, fields ...string)
+}
+
+// redisStore is a StateStore in Redis, with hashes as Redis hashes.
+type redisStore struct {
+	client *redis.Client
+}
+
+func (r *redisStore) Ping(ctx context.Context) error {
+	return r.client.Ping(ctx).Err()
+}
+
+func (r *redisStore) Get(ctx context.Context, key string) ([]byte, error) {
+	b, err := r.client.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return nil, errStateNotFound
+	}
+	return b, err
+}
+
+func (r *redisStore) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	return r.client.HGetAll(ctx, key).Result()
+}
+
+func (r *redisStore) Update(ctx context.Context, writes func(tx StateTx)) error {
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		writes(redisTx{ctx: ctx, pipe: pipe})
+		return nil
+	})
+	return err
+}
+
+func (r *redisStore) Close() error {
+	return r.client.Close()
+}
+
+type redisTx struct {
+	ctx  context.Context
+	pipe redis.Pipeliner
+}
+
+func (tx redisTx) Set(key string, value []byte) {
+	tx.pipe.Set(tx.ctx, key, value, 0)
+}
+
+func (tx redisTx) Del(key string) {
+	tx.pipe.Del(tx.ctx, key)
+}
+
+func (tx redisTx) HSet(key string, entries map[string]string) {
+	values := make([]interface{}, 0, 2*len(entries))
+	for field, value := range entries {
+		values = append(values, field, value)
+	}
+	tx.pipe.HSet(tx.ctx, key, values...)
+}
+
+func (tx redisTx) HDel(key string, fields ...string) {
+	tx.pipe.HDel(tx.ctx, key, fields...)
+}
@@ -0,0 +1,150 @@
+package gitops
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Buckets of a boltStore's file: values by key, and a nested bucket of entries by field for each hash.
+var (
+	boltValuesBucket = []byte("values")
+	boltHashesBucket = []byte("hashes")
+)
+
+// How long opening a boltStore waits for another process (e.g. a previous operator pod) to release the file.
+const boltOpenTimeout = time.Second
+
+// boltStore is a StateStore in a BoltDB file, e.g. on a PersistentVolumeClaim. The file is locked while it's open,
+// so only one operator replica can use it.
+type boltStore struct {
+	db *bolt.DB
+}
+
+// openBoltStore opens (or creates) the BoltDB file at the given path, creating its directory if needed.
+func openBoltStore(path string) (*boltStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create directory of state file %s: %w", path, err)
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: boltOpenTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open state file %s: %w", path, err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltValuesBucket, boltHashesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize state file %s: %w", path, err)
+	}
+	return &boltStore{db: db}, nil
+}
+
+func (b *boltStore) Ping(ctx context.Context) error {
+	return b.db.View(func(tx *bolt.Tx) error { return nil })
+}
+
+func (b *boltStore) Get(ctx context.Context, key string) ([]byte, error) {
+	var value []byte
+	err := b.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(boltValuesBucket).Get([]byte(key))
+		if v == nil {
+			return errStateNotFound
+		}
+		// Only valid during the transaction
+		value = append([]byte{}, v...)
+		return nil
+	})
+	return value, err
+}
+
+func (b *boltStore) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	entries := make(map[string]string)
+	err := b.db.View(func(tx *bolt.Tx) error {
+		hash := tx.Bucket(boltHashesBucket).Bucket([]byte(key))
+		if hash == nil {
+			return nil
+		}
+		return hash.ForEach(func(field, value []byte) error {
+			entries[string(field)] = string(value)
+			return nil
+		})
+	})
+	return entries, err
+}
+
+func (b *boltStore) Update(ctx context.Context, writes func(tx StateTx)) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		btx := &boltTx{tx: tx}
+		writes(btx)
+		return btx.err
+	})
+}
+
+func (b *boltStore) Close() error {
+	return b.db.Close()
+}
+
+// boltTx makes a StateTx's writes in a BoltDB transaction, keeping the first error to roll it back with.
+type boltTx struct {
+	tx  *bolt.Tx
+	err error
+}
+
+func (b *boltTx) Set(key string, value []byte) {
+	if b.err == nil {
+		b.err = b.tx.Bucket(boltValuesBucket).Put([]byte(key), value)
+	}
+}
+
+func (b *boltTx) Del(key string) {
+	if b.err != nil {
+		return
+	}
+	if b.err = b.tx.Bucket(boltValuesBucket).Delete([]byte(key)); b.err != nil {
+		return
+	}
+	hashes := b.tx.Bucket(boltHashesBucket)
+	if hashes.Bucket([]byte(key)) != nil {
+		b.err = hashes.DeleteBucket([]byte(key))
+	}
+}
+
+func (b *boltTx) HSet(key string, entries map[string]string) {
+	if b.err != nil {
+		return
+	}
+	hash, err := b.tx.Bucket(boltHashesBucket).CreateBucketIfNotExists([]byte(key))
+	if err != nil {
+		b.err = err
+		return
+	}
+	for field, value := range entries {
+		if b.err = hash.Put([]byte(field), []byte(value)); b.err != nil {
+			return
+		}
+	}
+}
+
+func (b *boltTx) HDel(key string, fields ...string) {
+	if b.err != nil {
+		return
+	}
+	hash := b.tx.Bucket(boltHashesBucket).Bucket([]byte(key))
+	if hash == nil {
+		return
+	}
+	for _, field := range fields {
+		if b.err = hash.Delete([]byte(field)); b.err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package gitops
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBoltStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "gm-operator.db")
+	store, err := openBoltStore(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer store.Close()
+	ctx := context.Background()
+
+	_, err = store.Get(ctx, "missing")
+	assert.Equal(t, errStateNotFound, err)
+	entries, err := store.HGetAll(ctx, "missing")
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+
+	assert.NoError(t, store.Update(ctx, func(tx StateTx) {
+		tx.Set("defaults", []byte(`{}`))
+		tx.HSet("gm", map[string]string{"a": "1", "b": "2", "c": "3"})
+		tx.HDel("gm", "b")
+	}))
+	value, err := store.Get(ctx, "defaults")
+	assert.NoError(t, err)
+	assert.Equal(t, `{}`, string(value))
+	entries, err = store.HGetAll(ctx, "gm")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "1", "c": "3"}, entries)
+
+	assert.NoError(t, store.Update(ctx, func(tx StateTx) {
+		tx.Del("gm")
+		tx.HSet("gm", map[string]string{"d": "4"})
+	}))
+	entries, _ = store.HGetAll(ctx, "gm")
+	assert.Equal(t, map[string]string{"d": "4"}, entries)
+}
+
+func TestSyncStateFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gm-operator.db")
+	defaults := cuemodule.Defaults{StateFile: path, GitOpsStateKeyGM: "gm-state", GitOpsStateKeyK8s: "k8s-state"}
+	newSyncState := func() *SyncState {
+		return &SyncState{
+			ctx:               context.Background(),
+			stateFile:         path,
+			defaults:          defaults,
+			namespace:         "gm-operator:",
+			previousGMHashes:  make(map[string]GMObjectRef),
+			previousK8sHashes: make(map[string]K8sObjectRef),
+			saveChans:         map[string]chan interface{}{stateGM: make(chan interface{}, 1), stateK8s: make(chan interface{}, 1)},
+			unsaved:           make(map[string]bool),
+		}
+	}
+
+	ss := newSyncState()
+	assert.NoError(t, ss.connect())
+	configObjects := []json.RawMessage{[]byte(`{"cluster_key": "grapefruit", "zone_key": "default-zone"}`)}
+	filtered, _, _ := ss.FilterChangedGM(configObjects, []string{"cluster"})
+	assert.Len(t, filtered, 1)
+	assert.NoError(t, ss.save(ss.ctx, stateGM))
+
+	// The file is locked while it's open
+	locked := NewSyncState(context.Background(), defaults, "gm-operator:")
+	degraded, _ := locked.Degraded()
+	assert.True(t, degraded)
+
+	// A restarted operator finds the state it saved
+	assert.NoError(t, ss.store.Close())
+	restarted := newSyncState()
+	assert.NoError(t, restarted.connect())
+	defer restarted.store.Close()
+	assert.NoError(t, restarted.load(stateGM))
+	assert.Equal(t, ss.previousGMHashes, restarted.previousGMHashes)
+	filtered, _, _ = restarted.FilterChangedGM(configObjects, []string{"cluster"})
+	assert.Empty(t, filtered)
+}
+
+func TestSyncStateCloseSaves(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gm-operator.db")
+	defaults := cuemodule.Defaults{StateFile: path, GitOpsStateKeyGM: "gm-state", GitOpsStateKeyK8s: "k8s-state"}
+	configObjects := []json.RawMessage{[]byte(`{"cluster_key": "grapefruit", "zone_key": "default-zone"}`)}
+
+	// Changes not yet saved by the backup loop are saved when the state is closed, however soon after they're made
+	ss := NewSyncState(context.Background(), defaults, "gm-operator:")
+	filtered, _, _ := ss.FilterChangedGM(configObjects, []string{"cluster"})
+	assert.Len(t, filtered, 1)
+	assert.NoError(t, ss.Close())
+	assert.NoError(t, ss.Close(), "closing again is a no-op")
+
+	restarted := NewSyncState(context.Background(), defaults, "gm-operator:")
+	defer restarted.Close()
+	degraded, _ := restarted.Degraded()
+	assert.False(t, degraded)
+	assert.Equal(t, ss.previousGMHashes, restarted.previousGMHashes)
+	assert.NoError(t, restarted.Flush(context.Background()))
+}
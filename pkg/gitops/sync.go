@@ -5,15 +5,18 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
 	ctrl "sigs.k8s.io/controller-runtime"
 
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/plumbing/storer"
-	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
 	"github.com/greymatter-io/operator/api/v1alpha1"
 	"github.com/greymatter-io/operator/pkg/cuemodule"
 )
@@ -30,11 +33,86 @@ type Sync struct {
 	Interval      int
 	SyncState     *SyncState
 
+	// Optional allow-list of keys that must have signed a commit before it is applied.
+	Verification CommitVerification
+	// Optional keys that must have signed an OCI artifact before it is unpacked and applied.
+	ArtifactVerification ArtifactVerification
+	// Digest of the last OCI artifact whose signatures were verified.
+	verifiedDigest string
+	// Optional path prefixes (e.g. "gm/", "k8s/"). If set, OnSyncCompleted is only
+	// invoked when a file under one of these prefixes changed between commits.
+	PathFilters []string
+
 	// Internal callback that is executed at the end
 	// of every sync iteration.
 	OnSyncCompleted func() error
-	ctx             context.Context
-	cancel          func()
+	// Internal callback that is executed when a fetched commit
+	// fails signature verification and is not applied.
+	OnSyncRejected func(error)
+	ctx            context.Context
+	cancel         func()
+
+	// Outcomes of the objects applied in the latest sync cycle.
+	report   *SyncReport
+	reportMu sync.Mutex
+
+	// External observers of each sync cycle's outcome (e.g. chat notifications).
+	listeners []SyncListener
+
+	// Optional known_hosts file for verifying SSH remotes' host keys, instead of the default one.
+	KnownHostsPath string
+	// Skips verification of SSH host keys and HTTPS certificates. Only for lab use.
+	InsecureSkipVerify bool
+	// Optional transport for HTTP(S) remotes, e.g. with a proxy and extra trusted CAs.
+	httpTransport *http.Transport
+
+	// Optional in-memory credentials for the config repo, replaced on rotation.
+	credentials   GitCredentials
+	credentialsMu sync.Mutex
+	// Optional Redis password overriding the one in the CUE defaults.
+	redisPassword string
+	// Optional Redis address overriding the one in the CUE defaults, e.g. of the operator-managed Redis.
+	redisHost string
+	redisPort int
+	// Optional BoltDB file to keep state in instead of Redis, overriding the one in the CUE defaults.
+	stateFile string
+	// Credentials of the Redis in the CUE's config.state_redis, read from its Secret.
+	stateRedisUsername string
+	stateRedisPassword string
+	// Identifies this operator among those sharing a Redis, namespacing its state keys.
+	operatorID string
+
+	// Optional cache of the CUE dependencies listed in CUEDependenciesFile, which can be pre-populated for offline use.
+	CUEModuleCache string
+	// Leaves git submodules out of clones and pulls, for config repos that list their CUE dependencies instead.
+	SkipSubmodules bool
+
+	// Source set by Reconfigure, switched to at the start of Watch's next cycle.
+	pendingSource *Source
+	sourceMu      sync.Mutex
+	// Starts Watch's next cycle early.
+	wake chan struct{}
+	// Closed by Stop, so Watch starts no new cycles, and when a running Watch returns. Both are guarded by
+	// lifecycleMu.
+	stopping    chan struct{}
+	watchDone   chan struct{}
+	lifecycleMu sync.Mutex
+}
+
+// SyncListener is notified of the outcome of each sync cycle.
+type SyncListener interface {
+	// SyncFinished is called after a new commit has been applied. The report may still be
+	// filling in, since Grey Matter objects are applied asynchronously.
+	SyncFinished(commit CommitInfo, report *SyncReport, err error)
+	// SyncRejected is called when a fetched commit is refused, e.g. for failing signature verification.
+	SyncRejected(err error)
+}
+
+// CommitInfo describes the commit applied in a sync cycle.
+type CommitInfo struct {
+	SHA     string `json:"sha"`
+	Author  string `json:"author"`
+	Message string `json:"message"`
 }
 
 // New will build a sync with provided constructor options.
@@ -46,6 +124,7 @@ func New(remote string, ctx context.Context, cancel func(), options ...func(*Syn
 		Remote: remote,
 		ctx:    ctx,
 		cancel: cancel,
+		wake:   make(chan struct{}, 1),
 	}
 
 	// iterate through our options and do overrides.
@@ -53,6 +132,10 @@ func New(remote string, ctx context.Context, cancel func(), options ...func(*Syn
 		o(s)
 	}
 
+	if s.httpTransport != nil {
+		s.installHTTPTransport()
+	}
+
 	return s
 }
 
@@ -80,6 +163,71 @@ func WithRepoInfo(remote, branch string, tag string) func(*Sync) {
 	}
 }
 
+// WithCommitVerification will require fetched commits to be signed
+// by a key in the given GPG keyring or SSH allowed signers file.
+func WithCommitVerification(gpgKeyringPath, sshAllowedSignersPath string) func(*Sync) {
+	return func(s *Sync) {
+		s.Verification = CommitVerification{
+			GPGKeyringPath:        gpgKeyringPath,
+			SSHAllowedSignersPath: sshAllowedSignersPath,
+		}
+	}
+}
+
+// WithPathFilters will limit sync callbacks to commits
+// that change files under one of the given path prefixes.
+func WithPathFilters(prefixes ...string) func(*Sync) {
+	return func(s *Sync) {
+		for _, p := range prefixes {
+			if p = strings.TrimSpace(p); p != "" {
+				s.PathFilters = append(s.PathFilters, p)
+			}
+		}
+	}
+}
+
+// WithListener will register an observer of sync outcomes.
+func WithListener(l SyncListener) func(*Sync) {
+	return func(s *Sync) {
+		if l != nil {
+			s.listeners = append(s.listeners, l)
+		}
+	}
+}
+
+// WithRedisPassword will connect to Redis for state backup
+// with the given password instead of the one in the CUE defaults.
+func WithRedisPassword(password string) func(*Sync) {
+	return func(s *Sync) {
+		s.redisPassword = password
+	}
+}
+
+// WithRedisAddress will connect to Redis for state backup
+// at the given host and port instead of those in the CUE defaults.
+func WithRedisAddress(host string, port int) func(*Sync) {
+	return func(s *Sync) {
+		s.redisHost = host
+		s.redisPort = port
+	}
+}
+
+// WithStateFile will keep state in the given BoltDB file instead of Redis,
+// overriding any file in the CUE defaults.
+func WithStateFile(path string) func(*Sync) {
+	return func(s *Sync) {
+		s.stateFile = path
+	}
+}
+
+// WithOperatorID will namespace the operator's state keys in Redis
+// by the given identity, so operators sharing a Redis keep separate state.
+func WithOperatorID(id string) func(*Sync) {
+	return func(s *Sync) {
+		s.operatorID = id
+	}
+}
+
 // WithOnSyncCompleted will inject a callback
 // function in the sync configuration.
 func WithOnSyncCompleted(callback func() error) func(*Sync) {
@@ -94,21 +242,40 @@ func WithOnSyncCompleted(callback func() error) func(*Sync) {
 // If no bootstrap flags were provided on startup, we ignore and
 // use a bundled local configuration tree for defaults.
 func (s *Sync) Bootstrap() error {
-	if s.Remote != "" {
-		err := clone(s)
-		if err != nil {
-			return err
-		}
+	if s.Remote == "" {
+		return nil
+	}
+	if IsOCI(s.Remote) {
+		removeLeftovers(s.GitDir)
+		_, err := s.artifactUpdate(false)
+		return err
+	}
+	if s.GitDir == "" || isEmptyDir(s.GitDir) {
+		return clone(s)
 	}
 
-	return nil
+	removeLeftovers(s.GitDir)
+	if _, err := os.Stat(s.GitDir); os.IsNotExist(err) {
+		return clone(s)
+	}
+	if reused, err := s.reuseCheckout(); reused || err != nil {
+		return err
+	}
+	return s.reclone()
 }
 
 // StartStateBackup creates and maintains the SyncState object and connection to Redis, which is responsible for
 // ensuring that we only apply objects that have actually *changed* during GitOps updates.
 func (s *Sync) StartStateBackup(ctx context.Context, operatorCUE *cuemodule.OperatorCUE, mesh *v1alpha1.Mesh) {
-	_, defaults := operatorCUE.ExtractConfig()
-	ss := NewSyncState(ctx, defaults)
+	config, defaults := s.stateDefaults(operatorCUE)
+	var meshName string
+	if mesh != nil {
+		meshName = mesh.Name
+	}
+	ss := NewSyncState(ctx, defaults, StateNamespace(s.operatorID, meshName))
+	ss.SetRetentionCycles(config.Reconcile.StateRetentionCycles)
+	ss.SetDeletionPolicy(DeletionPolicy{MaxPercent: config.Reconcile.MaxDeletePercent, Hold: config.Reconcile.HoldDeletions})
+	ss.SetDivergenceThreshold(time.Duration(config.Reconcile.StateDivergenceSeconds) * time.Second)
 	s.SyncState = ss
 
 	// cleanup routine that is executed
@@ -124,8 +291,43 @@ func (s *Sync) StartStateBackup(ctx context.Context, operatorCUE *cuemodule.Oper
 	}()
 }
 
+// SetStateRedisCredentials sets the credentials of the Redis in the CUE's config.state_redis. They're only used by
+// the state backup started after they're set.
+func (s *Sync) SetStateRedisCredentials(username, password string) {
+	s.stateRedisUsername, s.stateRedisPassword = username, password
+}
+
+// stateDefaults returns the CUE config and defaults, with the state store set by the sync's options.
+func (s *Sync) stateDefaults(operatorCUE *cuemodule.OperatorCUE) (cuemodule.Config, cuemodule.Defaults) {
+	config, defaults := operatorCUE.ExtractConfig()
+	return config, s.withStateStore(config, defaults)
+}
+
+// withStateStore returns the defaults with the state store used in place of the mesh's Redis: the Redis in the CUE's
+// config.state_redis if set, unless the sync's options set another Redis address, and otherwise overridden by them.
+func (s *Sync) withStateStore(config cuemodule.Config, defaults cuemodule.Defaults) cuemodule.Defaults {
+	if stateRedis := config.StateRedis; stateRedis.Host != "" && s.redisHost == "" {
+		defaults.RedisHost, defaults.RedisPort, defaults.RedisDB = stateRedis.Host, stateRedis.Port, stateRedis.DB
+		if defaults.RedisPort == 0 {
+			defaults.RedisPort = cuemodule.StateRedisPort
+		}
+		defaults.RedisUsername, defaults.RedisPassword = s.stateRedisUsername, s.stateRedisPassword
+	}
+	if s.redisPassword != "" {
+		defaults.RedisPassword = s.redisPassword
+	}
+	if s.redisHost != "" {
+		defaults.RedisHost, defaults.RedisPort = s.redisHost, s.redisPort
+	}
+	if s.stateFile != "" {
+		defaults.StateFile = s.stateFile
+	}
+	return defaults
+}
+
 // Close cleans up open sync connections when the operator dies so it
-// doesn't linger and waste resources.
+// doesn't linger and waste resources. The state is saved one last time
+// before its store is closed.
 func (s *Sync) Close() error {
 	// Close any open watches
 	if s.cancel != nil {
@@ -138,47 +340,292 @@ func (s *Sync) Close() error {
 	if s.SyncState == nil {
 		return nil
 	}
+	return s.SyncState.Close()
+}
 
-	// Cleanup our open channels and close the go routines
-	for _, ch := range s.SyncState.saveChans {
-		close(ch)
+// Stop keeps Watch from starting any more sync cycles, letting the one in progress, if any, finish.
+func (s *Sync) Stop() {
+	s.lifecycleMu.Lock()
+	defer s.lifecycleMu.Unlock()
+	if s.stopping == nil {
+		s.stopping = make(chan struct{})
+	}
+	select {
+	case <-s.stopping:
+	default:
+		close(s.stopping)
 	}
+}
 
-	return s.SyncState.redis.Close()
+// Drain stops Watch like Stop, and waits for it to return once the sync cycle in progress, if any, has finished. If
+// ctx is done first, the sync's context is cancelled, so the cycle is left in progress to be re-run when the operator
+// next starts, and ctx's error is returned.
+func (s *Sync) Drain(ctx context.Context) error {
+	s.Stop()
+	s.lifecycleMu.Lock()
+	done := s.watchDone
+	s.lifecycleMu.Unlock()
+	if done == nil {
+		return nil
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		if s.cancel != nil {
+			s.cancel()
+		}
+		return fmt.Errorf("sync cycle still in progress: %w", ctx.Err())
+	}
+}
+
+// stopped returns a channel closed once Stop is called.
+func (s *Sync) stopped() <-chan struct{} {
+	s.lifecycleMu.Lock()
+	defer s.lifecycleMu.Unlock()
+	if s.stopping == nil {
+		s.stopping = make(chan struct{})
+	}
+	return s.stopping
+}
+
+// beginWatch records that Watch is running, returning the channel to close when it returns.
+func (s *Sync) beginWatch() chan struct{} {
+	s.lifecycleMu.Lock()
+	defer s.lifecycleMu.Unlock()
+	s.watchDone = make(chan struct{})
+	return s.watchDone
 }
 
 // Watch will kick off a loop that will pull a git project for changes on an interval
-// provided by the users configuration. The default watch interval is 10s. A callback is exposed
+// provided by the users configuration. The default watch interval is 30s. A callback is exposed
 // in the sync configuration object that is called on a successful completion of a pull.
 // This can be used to reconcile mesh changes internally to the operator.
 // Watch uses the internal sync context to handle routine cancellation. This means that
-// the callback can also cancel this routine.
+// the callback can also cancel this routine. Without a remote, it idles until one is set with Reconfigure.
+// It returns without starting another cycle once Stop is called.
 func (s *Sync) Watch() {
-	if s.Remote == "" {
-		return
-	}
-
+	done := s.beginWatch()
+	defer close(done)
+	stopped := s.stopped()
 	lastSHA := ""
+	lastRejection := ""
+	// Set once the checkout is switched to a new source, so its config is applied in full even though there's no
+	// prior SHA of the same ref to diff against
+	reload := false
+	reclone, repoint := false, false
+	// A sync cycle interrupted when the operator last stopped is re-run as soon as there's a commit to apply
+	interrupted, resume := s.interruptedCycle()
 	for {
 		select {
 		case <-s.ctx.Done():
 			return
+		case <-stopped:
+			return
 		default:
-			currentSHA, err := gitUpdate(s)
+		}
+
+		switch s.applyPendingSource() {
+		case sourceRemoteChanged:
+			reclone, repoint = true, false
+		case sourceRefChanged:
+			repoint = !reclone
+		}
+		// Without a source, idle until one is configured
+		if s.Remote == "" {
+			if s.wait() {
+				return
+			}
+			continue
+		}
+		if reclone || repoint {
+			var err error
+			if reclone {
+				err = s.reclone()
+			} else {
+				err = s.repoint()
+			}
 			if err != nil {
-				logger.Error(err, fmt.Sprintf("failed while watching repo %s", s.Remote))
+				logger.Error(err, fmt.Sprintf("failed to switch to %s of repo %s", s.ref(), s.Remote))
+				s.rejected(err, &lastRejection)
+				if repoint && s.damaged(err) {
+					checkoutRecoveries.Inc()
+					reclone = true
+				}
+				if s.wait() {
+					return
+				}
+				continue
+			}
+			reclone, repoint = false, false
+			reload = true
+			lastSHA = ""
+		}
+
+		currentSHA, err := s.update()
+		if err != nil {
+			logger.Error(err, fmt.Sprintf("failed while watching repo %s", s.Remote))
+			s.rejected(err, &lastRejection)
+			// Clone a damaged checkout again, rather than failing on it forever
+			if s.damaged(err) {
+				logger.Info("GitOps checkout is damaged; it will be cloned again", "Dir", s.GitDir)
+				checkoutRecoveries.Inc()
+				reclone = true
 			}
+			// Keep the last applied SHA so a rejected commit never counts as a change.
+			currentSHA = lastSHA
+		}
+		// Stopped while pulling, so no cycle is started for what was pulled
+		select {
+		case <-stopped:
+			return
+		default:
+		}
 
-			if s.OnSyncCompleted != nil && lastSHA != "" && lastSHA != currentSHA {
-				err = s.OnSyncCompleted()
-				if err != nil {
-					logger.Error(err, "failed during callback execution OnSyncCompleted()")
+		changed := lastSHA != "" && lastSHA != currentSHA && s.matchesPathFilters(lastSHA, currentSHA)
+		if s.OnSyncCompleted != nil && currentSHA != "" && (reload || changed || resume) {
+			reload = false
+			if resume {
+				resume = false
+				s.resumeCycle(interrupted, currentSHA)
+			}
+			report := s.BeginReport(currentSHA)
+			if s.SyncState != nil {
+				s.SyncState.BeginCycle(currentSHA)
+			}
+			err = s.OnSyncCompleted()
+			if err != nil {
+				logger.Error(err, "failed during callback execution OnSyncCompleted()")
+			}
+			// A cycle cut short by the operator stopping is left in progress, to be re-run when it starts again
+			if s.SyncState != nil && s.ctx.Err() == nil {
+				s.SyncState.EndCycle()
+			}
+			if len(s.listeners) > 0 {
+				info := commitInfo(s.GitDir, currentSHA)
+				for _, l := range s.listeners {
+					l.SyncFinished(info, report, err)
 				}
 			}
-			lastSHA = currentSHA
-			time.Sleep(time.Second * time.Duration(s.Interval))
 		}
+		lastSHA = currentSHA
+		if s.wait() {
+			return
+		}
+	}
+}
+
+// interruptedCycle returns the sync cycle found interrupted when the state was loaded on startup, if any.
+func (s *Sync) interruptedCycle() (SyncProgress, bool) {
+	if s.SyncState == nil {
+		return SyncProgress{}, false
+	}
+	return s.SyncState.takeInterrupted()
+}
+
+// resumeCycle prepares to re-run an interrupted sync cycle in full at the given commit: the interrupted one if it's
+// still checked out, or else the latest, which supersedes it.
+func (s *Sync) resumeCycle(interrupted SyncProgress, sha string) {
+	if sha == interrupted.SHA {
+		logger.Info("Re-running the sync cycle interrupted when the operator last stopped", "Commit", sha, "Started", interrupted.Started)
+	} else {
+		logger.Info("Re-running the sync cycle interrupted when the operator last stopped at the latest commit", "Interrupted", interrupted.SHA, "Commit", sha, "Started", interrupted.Started)
+	}
+	s.SyncState.resume(interrupted)
+}
+
+// rejected reports an error fetching a commit or artifact if it's because it failed verification.
+// Each rejected commit or artifact is only reported once, rather than on every poll.
+func (s *Sync) rejected(err error, lastRejection *string) {
+	if !errors.Is(err, ErrUnverifiedCommit) && !errors.Is(err, ErrUnverifiedArtifact) || err.Error() == *lastRejection {
+		return
+	}
+	*lastRejection = err.Error()
+	if s.OnSyncRejected != nil {
+		s.OnSyncRejected(err)
+	}
+	for _, l := range s.listeners {
+		l.SyncRejected(err)
+	}
+}
+
+// matchesPathFilters reports whether any file under the configured path prefixes
+// differs between the two commits. With no filters configured, every change matches.
+// If the diff can't be computed, it errs on the side of reloading, as it always does for
+// OCI artifacts, which keep no history to diff.
+func (s *Sync) matchesPathFilters(fromSHA, toSHA string) bool {
+	if len(s.PathFilters) == 0 || IsOCI(s.Remote) {
+		return true
+	}
+
+	changed, err := changedPaths(s.GitDir, fromSHA, toSHA)
+	if err != nil {
+		logger.Error(err, "failed to diff commits for path filters; reloading anyway", "from", fromSHA, "to", toSHA)
+		return true
+	}
+
+	for _, path := range changed {
+		for _, prefix := range s.PathFilters {
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+		}
+	}
+
+	logger.Info("No changes under filtered paths; skipping reload", "from", fromSHA, "to", toSHA, "filters", s.PathFilters)
+	return false
+}
+
+// commitInfo looks up the author and message of a commit, returning just the SHA if it can't be read.
+func commitInfo(gitDir, sha string) CommitInfo {
+	info := CommitInfo{SHA: sha}
+	repo, err := git.PlainOpen(gitDir)
+	if err != nil {
+		return info
+	}
+	commit, err := repo.CommitObject(plumbing.NewHash(sha))
+	if err != nil {
+		return info
+	}
+	info.Author = fmt.Sprintf("%s <%s>", commit.Author.Name, commit.Author.Email)
+	info.Message = strings.TrimSpace(commit.Message)
+	return info
+}
+
+// changedPaths returns the paths of all files added, removed, or modified between two commits.
+func changedPaths(gitDir, fromSHA, toSHA string) ([]string, error) {
+	repo, err := git.PlainOpen(gitDir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open local repository %s: %w", gitDir, err)
+	}
+
+	var trees [2]*object.Tree
+	for i, sha := range []string{fromSHA, toSHA} {
+		commit, err := repo.CommitObject(plumbing.NewHash(sha))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read commit %s: %w", sha, err)
+		}
+		if trees[i], err = commit.Tree(); err != nil {
+			return nil, fmt.Errorf("failed to read tree for commit %s: %w", sha, err)
+		}
+	}
+
+	changes, err := object.DiffTree(trees[0], trees[1])
+	if err != nil {
+		return nil, err
 	}
+
+	var paths []string
+	for _, change := range changes {
+		// A rename or modification has both names; an add or delete has only one.
+		if change.From.Name != "" {
+			paths = append(paths, change.From.Name)
+		}
+		if change.To.Name != "" && change.To.Name != change.From.Name {
+			paths = append(paths, change.To.Name)
+		}
+	}
+	return paths, nil
 }
 
 // clone will clone a repository given a singular sync config instance.
@@ -187,6 +634,11 @@ func clone(s *Sync) error {
 	if s.GitDir == "" {
 		s.GitDir, _ = os.Getwd()
 	}
+	return cloneInto(s, s.GitDir)
+}
+
+// cloneInto clones the sync's repository into dir.
+func cloneInto(s *Sync, dir string) error {
 
 	var refName plumbing.ReferenceName
 	if s.Branch != "" {
@@ -199,28 +651,45 @@ func clone(s *Sync) error {
 	opts := &git.CloneOptions{
 		URL:               s.Remote,
 		ReferenceName:     refName,
-		RecurseSubmodules: git.DefaultSubmoduleRecursionDepth, // we need this to pull the cue config submodules
+		RecurseSubmodules: s.submoduleRecursion(),
+		InsecureSkipTLS:   s.insecureSkipTLS(),
 	}
 
-	if s.SSHPrivateKey != "" {
-		auth, err := ssh.NewPublicKeysFromFile("git", s.SSHPrivateKey, s.SSHPassphrase)
-		if err != nil {
-			return fmt.Errorf("failed to find private key from file: %w ", err)
+	auth, err := s.auth()
+	if err != nil {
+		return err
+	}
+	opts.Auth = auth
+
+	repo, err := git.PlainClone(dir, false, opts)
+	if err != nil {
+		if opts.Auth != nil {
+			return fmt.Errorf("failed to clone with %s: %w", opts.Auth.Name(), err)
 		}
-		opts.Auth = auth
-		//opts.InsecureSkipTLS = true
+		return fmt.Errorf("failed to clone without auth: %w", err)
+	}
 
-		_, err = git.PlainClone(s.GitDir, false, opts)
+	if s.Verification.Enabled() {
+		head, err := repo.Head()
 		if err != nil {
-			return fmt.Errorf("failed to clone with ssh: %w", err)
+			return fmt.Errorf("failed to get repo HEAD: %w", err)
 		}
-	} else {
-		if _, err := git.PlainClone(s.GitDir, false, opts); err != nil {
-			return fmt.Errorf("failed to clone without auth: %w", err)
+		if err := s.Verification.Verify(repo, head.Hash()); err != nil {
+			// Never leave an unverified checkout behind for the CUE loader to pick up.
+			os.RemoveAll(dir)
+			return err
 		}
 	}
 
-	return nil
+	return s.vendorCUEDependencies(dir)
+}
+
+// update fetches the source and applies any changes to the checkout, returning the commit SHA or artifact digest.
+func (s *Sync) update() (string, error) {
+	if IsOCI(s.Remote) {
+		return s.artifactUpdate(false)
+	}
+	return gitUpdate(s)
 }
 
 // gitUpdate will do automatic fetching of the upstream repo
@@ -231,18 +700,15 @@ func gitUpdate(sc *Sync) (string, error) {
 		return "", fmt.Errorf("unable to open local repository %s: %w", sc.GitDir, err)
 	}
 
-	// FetchOptions configured with: 1) ssh private key, or 2) no auth
+	// FetchOptions configured with: 1) in-memory credentials, 2) ssh private key, or 3) no auth
 	opts := &git.FetchOptions{
 		Auth:            nil,
-		InsecureSkipTLS: true,
+		InsecureSkipTLS: sc.insecureSkipTLS(),
 		Tags:            git.AllTags,
 	}
 
-	if sc.SSHPrivateKey != "" {
-		opts.Auth, err = ssh.NewPublicKeysFromFile("git", sc.SSHPrivateKey, sc.SSHPassphrase)
-		if err != nil {
-			return "", fmt.Errorf("failed to read in ssh private key: %w", err)
-		}
+	if opts.Auth, err = sc.auth(); err != nil {
+		return "", err
 	}
 	if err := repo.Fetch(opts); err != nil {
 		if !errors.Is(git.NoErrAlreadyUpToDate, err) {
@@ -260,6 +726,17 @@ func gitUpdate(sc *Sync) (string, error) {
 	if sc.Branch != "" {
 		refName = plumbing.NewBranchReferenceName(sc.Branch)
 
+		// Verify the fetched commit before anything is checked out into the working tree.
+		if sc.Verification.Enabled() {
+			remoteRef, err := repo.Reference(plumbing.NewRemoteReferenceName("origin", sc.Branch), true)
+			if err != nil {
+				return "", fmt.Errorf("unable to resolve remote branch '%s': %w", sc.Branch, err)
+			}
+			if err := sc.Verification.Verify(repo, remoteRef.Hash()); err != nil {
+				return "", err
+			}
+		}
+
 		// Attempt a checkout WITH create, but throw away the error. :(
 		// NOTE(cm): we throw this error away, because we haven't figured out
 		// how to reliably continue when a harmless "branch exists" error is
@@ -287,8 +764,8 @@ func gitUpdate(sc *Sync) (string, error) {
 			SingleBranch:      true,
 			Auth:              opts.Auth,
 			Force:             true,
-			InsecureSkipTLS:   true,
-			RecurseSubmodules: git.DefaultSubmoduleRecursionDepth,
+			InsecureSkipTLS:   sc.insecureSkipTLS(),
+			RecurseSubmodules: sc.submoduleRecursion(),
 		}); err != nil {
 			if !errors.Is(err, git.NoErrAlreadyUpToDate) {
 				return "", fmt.Errorf("failed to pull changes from remote: %w", err)
@@ -301,6 +778,11 @@ func gitUpdate(sc *Sync) (string, error) {
 		if err != nil {
 			return "", fmt.Errorf("unable to resolve tag '%s': %w", sc.Tag, err)
 		}
+		if sc.Verification.Enabled() {
+			if err := sc.Verification.Verify(repo, peelTag(repo, tagRef.Hash())); err != nil {
+				return "", err
+			}
+		}
 		err = wt.Checkout(&git.CheckoutOptions{
 			Hash:  tagRef.Hash(),
 			Force: true,
@@ -316,6 +798,10 @@ func gitUpdate(sc *Sync) (string, error) {
 	}); err != nil {
 		return "", fmt.Errorf("failed to run git clean: %w", err)
 	}
+	// The clean removed any vendored CUE dependencies, which are untracked
+	if err := sc.vendorCUEDependencies(sc.GitDir); err != nil {
+		return "", err
+	}
 
 	// Extract the hash from this pull
 	ref, err := repo.Head()
@@ -324,3 +810,17 @@ func gitUpdate(sc *Sync) (string, error) {
 	}
 	return ref.Hash().String(), nil
 }
+
+// peelTag returns the commit hash an annotated tag points to,
+// or the hash unchanged if it already refers to a commit.
+func peelTag(repo *git.Repository, hash plumbing.Hash) plumbing.Hash {
+	tag, err := repo.TagObject(hash)
+	if err != nil {
+		return hash
+	}
+	commit, err := tag.Commit()
+	if err != nil {
+		return hash
+	}
+	return commit.Hash
+}
@@ -2,8 +2,11 @@ package gitops
 
 import (
 	"context"
+	"encoding/json"
 	"testing"
+	"time"
 
+	"github.com/go-redis/redis/v9"
 	"github.com/greymatter-io/operator/pkg/cuemodule"
 	"github.com/stretchr/testify/assert"
 	appsv1 "k8s.io/api/apps/v1"
@@ -26,27 +29,27 @@ func TestNewGMObjectRef(t *testing.T) {
 		"cluster": {
 			"cluster",
 			[]byte(`{"cluster_key": "grapefruit", "zone_key": "default-zone"}`),
-			GMObjectRef{Zone: defaultZone, Kind: "cluster", ID: "grapefruit", Hash: 11431995707094721787},
+			GMObjectRef{Zone: defaultZone, Kind: "cluster", ID: "grapefruit", Hash: 11692517401923198985, HashVersion: 2},
 		},
 		"listener": {
 			"listener",
 			[]byte(`{"listener_key": "banana", "zone_key": "default-zone"}`),
-			GMObjectRef{Zone: defaultZone, Kind: "listener", ID: "banana", Hash: 9137765789731928109},
+			GMObjectRef{Zone: defaultZone, Kind: "listener", ID: "banana", Hash: 9161938267355390121, HashVersion: 2},
 		},
 		"proxy": {
 			"proxy",
 			[]byte(`{"proxy_key": "kiwi", "zone_key": "default-zone"}`),
-			GMObjectRef{Zone: defaultZone, Kind: "proxy", ID: "kiwi", Hash: 2560221913592643480},
+			GMObjectRef{Zone: defaultZone, Kind: "proxy", ID: "kiwi", Hash: 2180922956935518637, HashVersion: 2},
 		},
 		"route": {
 			"route",
 			[]byte(`{"route_key": "strawberry", "zone_key": "default-zone"}`),
-			GMObjectRef{Zone: defaultZone, Kind: "route", ID: "strawberry", Hash: 5208631178048549669},
+			GMObjectRef{Zone: defaultZone, Kind: "route", ID: "strawberry", Hash: 12348724568808046048, HashVersion: 2},
 		},
 		"domain": {
 			"domain",
 			[]byte(`{"domain_key": "pineapple", "zone_key": "default-zone"}`),
-			GMObjectRef{Zone: defaultZone, Kind: "domain", ID: "pineapple", Hash: 17031460684138845760},
+			GMObjectRef{Zone: defaultZone, Kind: "domain", ID: "pineapple", Hash: 2048968776196091604, HashVersion: 2},
 		},
 	}
 
@@ -68,19 +71,19 @@ func TestGMHashKey(t *testing.T) {
 		"cluster": {
 			"cluster",
 			[]byte(`{"cluster_key": "grapefruit", "zone_key": "default-zone"}`),
-			GMObjectRef{Zone: defaultZone, Kind: "cluster", ID: "grapefruit", Hash: 11431995707094721787},
+			GMObjectRef{Zone: defaultZone, Kind: "cluster", ID: "grapefruit", Hash: 11692517401923198985, HashVersion: 2},
 			"default-zone-cluster-grapefruit",
 		},
 		"listener": {
 			"listener",
 			[]byte(`{"listener_key": "banana", "zone_key": "default-zone"}`),
-			GMObjectRef{Zone: defaultZone, Kind: "listener", ID: "banana", Hash: 9137765789731928109},
+			GMObjectRef{Zone: defaultZone, Kind: "listener", ID: "banana", Hash: 9161938267355390121, HashVersion: 2},
 			"default-zone-listener-banana",
 		},
 		"proxy": {
 			"proxy",
 			[]byte(`{"proxy_key": "kiwi", "zone_key": "default-zone"}`),
-			GMObjectRef{Zone: defaultZone, Kind: "proxy", ID: "kiwi", Hash: 2560221913592643480},
+			GMObjectRef{Zone: defaultZone, Kind: "proxy", ID: "kiwi", Hash: 2180922956935518637, HashVersion: 2},
 			"default-zone-proxy-kiwi",
 		},
 	}
@@ -113,10 +116,11 @@ func TestNewK8sObjectRef(t *testing.T) {
 				},
 			},
 			K8sObjectRef{
-				Namespace: defaultNamespace,
-				Name:      "test-deployment",
-				Kind:      schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"},
-				Hash:      16988695845095796670,
+				Namespace:   defaultNamespace,
+				Name:        "test-deployment",
+				Kind:        schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"},
+				Hash:        16988695845095796670,
+				HashVersion: 2,
 			},
 		},
 		"statefulset": {
@@ -131,10 +135,11 @@ func TestNewK8sObjectRef(t *testing.T) {
 				},
 			},
 			K8sObjectRef{
-				Namespace: defaultNamespace,
-				Name:      "test-sts",
-				Kind:      schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Statefulset"},
-				Hash:      8522595552864184581,
+				Namespace:   defaultNamespace,
+				Name:        "test-sts",
+				Kind:        schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Statefulset"},
+				Hash:        8522595552864184581,
+				HashVersion: 2,
 			},
 		},
 	}
@@ -168,10 +173,11 @@ func TestK8sHashKey(t *testing.T) {
 				},
 			},
 			K8sObjectRef{
-				Namespace: defaultNamespace,
-				Name:      "test-deployment",
-				Kind:      schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"},
-				Hash:      16988695845095796670,
+				Namespace:   defaultNamespace,
+				Name:        "test-deployment",
+				Kind:        schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"},
+				Hash:        16988695845095796670,
+				HashVersion: 2,
 			},
 			"gm-operator-apps/v1, Kind=Deployment-test-deployment",
 		},
@@ -187,10 +193,11 @@ func TestK8sHashKey(t *testing.T) {
 				},
 			},
 			K8sObjectRef{
-				Namespace: defaultNamespace,
-				Name:      "test-sts",
-				Kind:      schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Statefulset"},
-				Hash:      8522595552864184581,
+				Namespace:   defaultNamespace,
+				Name:        "test-sts",
+				Kind:        schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Statefulset"},
+				Hash:        8522595552864184581,
+				HashVersion: 2,
 			},
 			"gm-operator-apps/v1, Kind=Statefulset-test-sts",
 		},
@@ -208,8 +215,174 @@ func TestK8sHashKey(t *testing.T) {
 }
 
 func TestNewSyncState(t *testing.T) {
-	// We should see an error message and empty sync state because we couldn't
-	// connect to redis
-	ss := NewSyncState(context.Background(), cuemodule.Defaults{})
-	assert.Equal(t, &SyncState{}, ss)
+	// We should see an error message and a degraded sync state, keeping hashes
+	// in memory, because we couldn't connect to redis
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ss := NewSyncState(ctx, cuemodule.Defaults{RedisHost: "127.0.0.1", RedisPort: 1}, "")
+
+	degraded, err := ss.Degraded()
+	assert.True(t, degraded)
+	assert.Error(t, err)
+
+	var availabilityErr error
+	ss.OnAvailabilityChange(func(err error) { availabilityErr = err })
+	assert.Error(t, availabilityErr)
+
+	configObjects := []json.RawMessage{[]byte(`{"cluster_key": "grapefruit", "zone_key": "default-zone"}`)}
+	filtered, _, _ := ss.FilterChangedGM(configObjects, []string{"cluster"})
+	assert.Len(t, filtered, 1)
+	filtered, _, _ = ss.FilterChangedGM(configObjects, []string{"cluster"})
+	assert.Empty(t, filtered)
+
+}
+
+func TestSyncStateReconnect(t *testing.T) {
+	// Redis is still unavailable, so changes stay unsaved
+	ss := &SyncState{
+		ctx:       context.Background(),
+		redisOpts: &redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1},
+		unsaved:   map[string]bool{stateGM: true},
+	}
+	ss.reconnect()
+	degraded, _ := ss.Degraded()
+	assert.True(t, degraded)
+	assert.True(t, ss.unsaved[stateGM])
+}
+
+func TestStateCompaction(t *testing.T) {
+	edge := json.RawMessage(`{"cluster_key": "edge", "zone_key": "default-zone"}`)
+	catalog := json.RawMessage(`{"cluster_key": "catalog", "zone_key": "default-zone"}`)
+	ss := &SyncState{previousGMHashes: make(map[string]GMObjectRef)}
+	ss.SetRetentionCycles(2)
+
+	filtered, _, deleted := ss.FilterChangedGM([]json.RawMessage{edge, catalog}, []string{"cluster", "cluster"})
+	assert.Len(t, filtered, 2)
+	assert.Empty(t, deleted)
+
+	// catalog is retained for one cycle while it's missing...
+	filtered, _, deleted = ss.FilterChangedGM([]json.RawMessage{edge}, []string{"cluster"})
+	assert.Empty(t, filtered)
+	assert.Empty(t, deleted)
+	assert.Len(t, ss.previousGMHashes, 2)
+
+	// ...and compacted and deleted after the second
+	_, _, deleted = ss.FilterChangedGM([]json.RawMessage{edge}, []string{"cluster"})
+	if assert.Len(t, deleted, 1) {
+		assert.Equal(t, "catalog", deleted[0].ID)
+		assert.Equal(t, 1, deleted[0].LastSeen)
+	}
+	assert.Len(t, ss.previousGMHashes, 1)
+
+	// By default, missing objects are deleted immediately
+	ss = &SyncState{previousGMHashes: make(map[string]GMObjectRef)}
+	ss.FilterChangedGM([]json.RawMessage{edge, catalog}, []string{"cluster", "cluster"})
+	_, _, deleted = ss.FilterChangedGM([]json.RawMessage{edge}, []string{"cluster"})
+	assert.Len(t, deleted, 1)
+
+	// Counting resumes from restored state
+	ss = &SyncState{previousGMHashes: map[string]GMObjectRef{"default-zone-cluster-edge": {LastSeen: 7}}}
+	ss.restoreCycles()
+	assert.Equal(t, 7, ss.gmCycle)
+}
+
+func TestStateChanges(t *testing.T) {
+	edge := json.RawMessage(`{"cluster_key": "edge", "zone_key": "default-zone"}`)
+	catalog := json.RawMessage(`{"cluster_key": "catalog", "zone_key": "default-zone"}`)
+	ss := &SyncState{previousGMHashes: make(map[string]GMObjectRef)}
+	ss.SetRetentionCycles(2)
+	changed := func() []string {
+		keys, rewrite := ss.takeChanges(stateGM)
+		assert.False(t, rewrite)
+		return keys
+	}
+
+	ss.FilterChangedGM([]json.RawMessage{edge, catalog}, []string{"cluster", "cluster"})
+	assert.ElementsMatch(t, []string{"default-zone-cluster-edge", "default-zone-cluster-catalog"}, changed())
+
+	// Entries seen again are persisted the same, without the cycle they were last seen in
+	ss.FilterChangedGM([]json.RawMessage{edge, catalog}, []string{"cluster", "cluster"})
+	assert.Empty(t, changed())
+	assert.Equal(t, 0, persistedGM(ss.previousGMHashes["default-zone-cluster-edge"], ss.gmCycle).LastSeen)
+
+	// An entry changes when it goes missing, and again when it's compacted
+	ss.FilterChangedGM([]json.RawMessage{edge}, []string{"cluster"})
+	assert.Equal(t, []string{"default-zone-cluster-catalog"}, changed())
+	assert.Equal(t, 2, persistedGM(ss.previousGMHashes["default-zone-cluster-catalog"], ss.gmCycle).LastSeen)
+	ss.FilterChangedGM([]json.RawMessage{edge}, []string{"cluster"})
+	assert.Equal(t, []string{"default-zone-cluster-catalog"}, changed())
+
+	// Changes accumulate until they're taken, and a reset writes everything
+	ss.FilterChangedGM([]json.RawMessage{edge, catalog}, []string{"cluster", "cluster"})
+	ss.Reset()
+	keys, rewrite := ss.takeChanges(stateGM)
+	assert.Empty(t, keys)
+	assert.True(t, rewrite)
+	_, rewrite = ss.takeChanges(stateGM)
+	assert.False(t, rewrite)
+
+	// Saved entries are read back by hash key
+	decoded := make(map[string]GMObjectRef)
+	assert.NoError(t, decodeEntries(map[string]string{"default-zone-cluster-edge": `{"zone":"default-zone","kind":"cluster","id":"edge","hash":1}`}, &decoded))
+	assert.Equal(t, uint64(1), decoded["default-zone-cluster-edge"].Hash)
+	assert.Error(t, decodeEntries(map[string]string{"default-zone-cluster-edge": "{"}, &decoded))
+}
+
+func TestSortedKinds(t *testing.T) {
+	assert.Equal(t, []string{stateGM, stateDefaults}, sortedKinds(map[string]bool{stateDefaults: true, stateGM: true}))
+	assert.Empty(t, sortedKinds(map[string]bool{}))
+}
+
+func TestDerivedDefaultsApplyTo(t *testing.T) {
+	defaults := cuemodule.Defaults{SidecarList: []string{"edge"}}
+	DerivedDefaults{}.ApplyTo(&defaults)
+	assert.Equal(t, []string{"edge"}, defaults.SidecarList)
+
+	DerivedDefaults{SidecarList: []string{"catalog", "edge"}}.ApplyTo(&defaults)
+	assert.Equal(t, []string{"catalog", "edge"}, defaults.SidecarList)
+}
+
+func TestStateNamespace(t *testing.T) {
+	assert.Equal(t, "gm-operator:mesh-sample:", StateNamespace("gm-operator", "mesh-sample"))
+	assert.Equal(t, "mesh-sample:", StateNamespace("", "mesh-sample"))
+	assert.Equal(t, "", StateNamespace("", ""))
+
+	defaults := cuemodule.Defaults{GitOpsStateKeyGM: "gm-state", GitOpsStateKeyK8s: "k8s-state"}
+	ss := &SyncState{defaults: defaults, namespace: StateNamespace("gm-operator", "mesh-sample")}
+	assert.Equal(t, "gm-operator:mesh-sample:gm-state", ss.redisKey(stateGM))
+	assert.Equal(t, "gm-operator:mesh-sample:k8s-state", ss.redisKey(stateK8s))
+	assert.Equal(t, "gm-operator:mesh-sample:"+defaultStateKeyDefaults, ss.redisKey(stateDefaults))
+	assert.Equal(t, "gm-state", ss.sharedKey(stateGM))
+}
+
+func TestStateDivergence(t *testing.T) {
+	ss := &SyncState{}
+	ss.SetDivergenceThreshold(time.Minute)
+	var reported []time.Time
+	ss.OnDivergenceChange(func(since time.Time) { reported = append(reported, since) })
+	if assert.Len(t, reported, 1) {
+		assert.True(t, reported[0].IsZero())
+	}
+
+	// Diverging starts the clock, which isn't restarted by further changes
+	start := time.Now()
+	assert.True(t, ss.diverge(start))
+	assert.False(t, ss.diverge(start.Add(time.Second)))
+	assert.Equal(t, 30*time.Second, ss.checkDivergence(start.Add(30*time.Second)))
+	assert.Len(t, reported, 1)
+
+	// Past the threshold it's reported once
+	assert.Zero(t, ss.checkDivergence(start.Add(time.Minute)))
+	assert.Zero(t, ss.checkDivergence(start.Add(2*time.Minute)))
+	if assert.Len(t, reported, 2) {
+		assert.Equal(t, start, reported[1])
+	}
+
+	// And once persisted, it's reported as converged
+	ss.converge(start.Add(3 * time.Minute))
+	assert.Zero(t, ss.checkDivergence(start.Add(4*time.Minute)))
+	if assert.Len(t, reported, 3) {
+		assert.True(t, reported[2].IsZero())
+	}
+	assert.InDelta(t, time.Since(start.Add(3*time.Minute)).Seconds(), persistedAge().Seconds(), 1)
 }
@@ -2,11 +2,16 @@ package gitops
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/greymatter-io/operator/pkg/cuemodule"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -114,3 +119,122 @@ func TestSyncLifecycle(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NoDirExists(t, s.GitDir)
 }
+
+func TestDrain(t *testing.T) {
+	// Without a Watch, there's nothing to wait for
+	s := New("", context.Background(), nil)
+	assert.NoError(t, s.Drain(context.Background()))
+
+	// An idle Watch returns once drained, without starting another cycle
+	s = New("", context.Background(), nil)
+	s.Interval = 60
+	returned := make(chan struct{})
+	go func() {
+		s.Watch()
+		close(returned)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, s.Drain(ctx))
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Fatal("Watch didn't return after it was drained")
+	}
+}
+
+func TestWithPathFilters(t *testing.T) {
+	s := New(gitRemote, context.Background(), nil, WithPathFilters("gm/", " k8s/", ""))
+	assert.Equal(t, []string{"gm/", "k8s/"}, s.PathFilters)
+}
+
+func TestMatchesPathFilters(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	assert.NoError(t, err)
+	wt, err := repo.Worktree()
+	assert.NoError(t, err)
+
+	commit := func(path, contents string) string {
+		full := filepath.Join(dir, path)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(full), 0755))
+		assert.NoError(t, os.WriteFile(full, []byte(contents), 0644))
+		_, err := wt.Add(path)
+		assert.NoError(t, err)
+		hash, err := wt.Commit("update "+path, &git.CommitOptions{
+			Author: &object.Signature{Name: "test", Email: "test@greymatter.io", When: time.Now()},
+		})
+		assert.NoError(t, err)
+		return hash.String()
+	}
+
+	first := commit("gm/outputs/mesh.cue", "a: 1")
+	docs := commit("docs/README.md", "hello")
+	gm := commit("gm/outputs/mesh.cue", "a: 2")
+
+	s := New(gitRemote, context.Background(), nil, WithPathFilters("gm/", "k8s/"))
+	s.GitDir = dir
+
+	assert.False(t, s.matchesPathFilters(first, docs))
+	assert.True(t, s.matchesPathFilters(docs, gm))
+	assert.True(t, s.matchesPathFilters(first, gm))
+
+	unfiltered := New(gitRemote, context.Background(), nil)
+	unfiltered.GitDir = dir
+	assert.True(t, unfiltered.matchesPathFilters(first, docs))
+}
+
+func TestSyncReport(t *testing.T) {
+	r := NewSyncReport("abc123")
+	r.Record("gm", "cluster", defaultZone, "edge", "apply", errors.New("boom"))
+	r.Record("k8s", "Deployment", "greymatter", "control", "apply", nil)
+	// A requeued command that later succeeds replaces its earlier failure
+	r.Record("gm", "cluster", defaultZone, "edge", "apply", nil)
+	assert.Equal(t, uint64(3), r.Version())
+
+	b, err := json.Marshal(r)
+	assert.NoError(t, err)
+
+	var got struct {
+		Commit    string         `json:"commit"`
+		Succeeded int            `json:"succeeded"`
+		Failed    int            `json:"failed"`
+		Objects   []ObjectResult `json:"objects"`
+	}
+	assert.NoError(t, json.Unmarshal(b, &got))
+	assert.Equal(t, "abc123", got.Commit)
+	assert.Equal(t, 2, got.Succeeded)
+	assert.Equal(t, 0, got.Failed)
+	assert.Len(t, got.Objects, 2)
+	assert.Equal(t, "cluster", got.Objects[0].Kind)
+
+	// A nil report ignores records
+	var nilReport *SyncReport
+	nilReport.Record("gm", "cluster", defaultZone, "edge", "apply", nil)
+}
+
+func TestWithStateStore(t *testing.T) {
+	defaults := cuemodule.Defaults{RedisHost: "greymatter-datastore.greymatter.svc", RedisPort: 6379, RedisDB: 0, RedisPassword: "mesh"}
+	config := cuemodule.Config{StateRedis: cuemodule.StateRedisConfig{Host: "operator-redis.gm-operator.svc", DB: 2}}
+
+	// The mesh's Redis is shared unless the operator has its own
+	s := New("", context.Background(), nil)
+	assert.Equal(t, defaults, s.withStateStore(cuemodule.Config{}, defaults))
+
+	s.SetStateRedisCredentials("gm-operator", "operator")
+	got := s.withStateStore(config, defaults)
+	assert.Equal(t, cuemodule.Defaults{RedisHost: "operator-redis.gm-operator.svc", RedisPort: 6379, RedisDB: 2, RedisUsername: "gm-operator", RedisPassword: "operator"}, got)
+
+	// A password from the flags still overrides its Secret's
+	got = New("", context.Background(), nil, WithRedisPassword("rotated")).withStateStore(config, defaults)
+	assert.Equal(t, "operator-redis.gm-operator.svc", got.RedisHost)
+	assert.Equal(t, "rotated", got.RedisPassword)
+
+	// And the managed Redis replaces it
+	got = New("", context.Background(), nil, WithRedisAddress("gm-operator-redis.gm-operator.svc", 6380)).withStateStore(config, defaults)
+	assert.Equal(t, "gm-operator-redis.gm-operator.svc", got.RedisHost)
+	assert.Equal(t, 6380, got.RedisPort)
+	assert.Equal(t, 0, got.RedisDB)
+	assert.Equal(t, "mesh", got.RedisPassword)
+}
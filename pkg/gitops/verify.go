@@ -0,0 +1,188 @@
+package gitops
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrUnverifiedCommit is returned when a fetched commit is not signed by a key in the configured allow-list.
+var ErrUnverifiedCommit = errors.New("commit signature could not be verified")
+
+// CommitVerification configures which keys may sign commits that the operator applies.
+// If both paths are empty, commits are applied without verification.
+type CommitVerification struct {
+	// Path to an ASCII-armored GPG public keyring. Any key in the ring is allowed to sign.
+	GPGKeyringPath string
+	// Path to an allowed signers file (authorized_keys format, as used by `git config gpg.ssh.allowedSignersFile`).
+	// An optional leading principal field on each line is ignored.
+	SSHAllowedSignersPath string
+}
+
+// Enabled reports whether any allow-list has been configured.
+func (cv CommitVerification) Enabled() bool {
+	return cv.GPGKeyringPath != "" || cv.SSHAllowedSignersPath != ""
+}
+
+// Verify checks that the commit at hash carries a signature from an allowed key.
+// It returns an error wrapping ErrUnverifiedCommit if the commit is unsigned or the signer is unknown.
+func (cv CommitVerification) Verify(repo *git.Repository, hash plumbing.Hash) error {
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		return fmt.Errorf("failed to read commit %s: %w", hash, err)
+	}
+
+	if commit.PGPSignature == "" {
+		return fmt.Errorf("%w: %s is not signed", ErrUnverifiedCommit, hash)
+	}
+
+	if strings.HasPrefix(commit.PGPSignature, "-----BEGIN SSH SIGNATURE-----") {
+		if cv.SSHAllowedSignersPath == "" {
+			return fmt.Errorf("%w: %s has an SSH signature but no allowed signers are configured", ErrUnverifiedCommit, hash)
+		}
+		return verifySSHCommit(commit, cv.SSHAllowedSignersPath)
+	}
+
+	if cv.GPGKeyringPath == "" {
+		return fmt.Errorf("%w: %s has a GPG signature but no keyring is configured", ErrUnverifiedCommit, hash)
+	}
+	keyring, err := os.ReadFile(cv.GPGKeyringPath)
+	if err != nil {
+		return fmt.Errorf("failed to read GPG keyring %s: %w", cv.GPGKeyringPath, err)
+	}
+	if _, err := commit.Verify(string(keyring)); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrUnverifiedCommit, hash, err)
+	}
+
+	return nil
+}
+
+// verifySSHCommit verifies an SSHSIG-formatted commit signature against an allowed signers file.
+// ref: https://github.com/openssh/openssh-portable/blob/master/PROTOCOL.sshsig
+func verifySSHCommit(commit *object.Commit, allowedSignersPath string) error {
+	allowed, err := loadAllowedSigners(allowedSignersPath)
+	if err != nil {
+		return err
+	}
+
+	block, _ := pem.Decode([]byte(commit.PGPSignature))
+	if block == nil {
+		return fmt.Errorf("%w: %s has a malformed SSH signature", ErrUnverifiedCommit, commit.Hash)
+	}
+
+	if !bytes.HasPrefix(block.Bytes, []byte("SSHSIG")) {
+		return fmt.Errorf("%w: %s has a malformed SSH signature", ErrUnverifiedCommit, commit.Hash)
+	}
+	var sig struct {
+		Version   uint32
+		PublicKey []byte
+		Namespace string
+		Reserved  string
+		HashAlg   string
+		Signature []byte
+	}
+	if err := ssh.Unmarshal(block.Bytes[len("SSHSIG"):], &sig); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrUnverifiedCommit, commit.Hash, err)
+	}
+	if sig.Version != 1 {
+		return fmt.Errorf("%w: %s uses unsupported SSHSIG version %d", ErrUnverifiedCommit, commit.Hash, sig.Version)
+	}
+
+	if sig.Namespace != "git" {
+		return fmt.Errorf("%w: %s was signed for namespace %q, not \"git\"", ErrUnverifiedCommit, commit.Hash, sig.Namespace)
+	}
+
+	pub, err := ssh.ParsePublicKey(sig.PublicKey)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrUnverifiedCommit, commit.Hash, err)
+	}
+	if _, ok := allowed[string(pub.Marshal())]; !ok {
+		return fmt.Errorf("%w: %s was signed by %s, which is not an allowed signer", ErrUnverifiedCommit, commit.Hash, ssh.FingerprintSHA256(pub))
+	}
+
+	var h hash.Hash
+	switch sig.HashAlg {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return fmt.Errorf("%w: %s uses unsupported hash algorithm %q", ErrUnverifiedCommit, commit.Hash, sig.HashAlg)
+	}
+
+	// The signed payload is the commit encoded without its signature header.
+	encoded := &plumbing.MemoryObject{}
+	if err := commit.EncodeWithoutSignature(encoded); err != nil {
+		return err
+	}
+	r, err := encoded.Reader()
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(h, r); err != nil {
+		return err
+	}
+
+	signed := ssh.Marshal(struct {
+		Namespace string
+		Reserved  string
+		HashAlg   string
+		Hash      []byte
+	}{sig.Namespace, sig.Reserved, sig.HashAlg, h.Sum(nil)})
+	signed = append([]byte("SSHSIG"), signed...)
+
+	signature := &ssh.Signature{}
+	if err := ssh.Unmarshal(sig.Signature, signature); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrUnverifiedCommit, commit.Hash, err)
+	}
+	if err := pub.Verify(signed, signature); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrUnverifiedCommit, commit.Hash, err)
+	}
+
+	return nil
+}
+
+// loadAllowedSigners reads an allowed signers file into a set keyed by the wire-format public key.
+func loadAllowedSigners(path string) (map[string]struct{}, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read allowed signers %s: %w", path, err)
+	}
+	defer f.Close()
+
+	allowed := make(map[string]struct{})
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// Lines may be "principal key-type base64" or plain "key-type base64".
+		pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			if fields := strings.SplitN(line, " ", 2); len(fields) == 2 {
+				pub, _, _, _, err = ssh.ParseAuthorizedKey([]byte(fields[1]))
+			}
+		}
+		if err != nil {
+			logger.Info("Skipping unparseable allowed signer", "path", path, "line", line)
+			continue
+		}
+		allowed[string(pub.Marshal())] = struct{}{}
+	}
+
+	return allowed, scanner.Err()
+}
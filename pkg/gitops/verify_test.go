@@ -0,0 +1,150 @@
+package gitops
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/pem"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+// sshSigner returns a new ed25519 key for signing commits.
+func sshSigner(t *testing.T) ssh.Signer {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(key)
+	assert.NoError(t, err)
+	return signer
+}
+
+// storeCommit writes a commit to the repo, signed by the signer for the given namespace as `git commit -S` with
+// gpg.format=ssh would, or unsigned if the signer is nil. It returns the commit's hash.
+func storeCommit(t *testing.T, repo *git.Repository, signer ssh.Signer, namespace string) plumbing.Hash {
+	sig := object.Signature{Name: "GitOps", Email: "gitops@greymatter.io", When: time.Unix(1700000000, 0).UTC()}
+	commit := &object.Commit{Author: sig, Committer: sig, Message: "Update mesh\n", TreeHash: plumbing.ZeroHash}
+	if signer != nil {
+		unsigned := &plumbing.MemoryObject{}
+		assert.NoError(t, commit.EncodeWithoutSignature(unsigned))
+		r, err := unsigned.Reader()
+		assert.NoError(t, err)
+		h := sha512.New()
+		_, err = io.Copy(h, r)
+		assert.NoError(t, err)
+
+		signed := append([]byte("SSHSIG"), ssh.Marshal(struct {
+			Namespace string
+			Reserved  string
+			HashAlg   string
+			Hash      []byte
+		}{namespace, "", "sha512", h.Sum(nil)})...)
+		signature, err := signer.Sign(rand.Reader, signed)
+		assert.NoError(t, err)
+		blob := append([]byte("SSHSIG"), ssh.Marshal(struct {
+			Version   uint32
+			PublicKey []byte
+			Namespace string
+			Reserved  string
+			HashAlg   string
+			Signature []byte
+		}{1, signer.PublicKey().Marshal(), namespace, "", "sha512", ssh.Marshal(signature)})...)
+		commit.PGPSignature = string(pem.EncodeToMemory(&pem.Block{Type: "SSH SIGNATURE", Bytes: blob}))
+	}
+
+	obj := repo.Storer.NewEncodedObject()
+	assert.NoError(t, commit.Encode(obj))
+	hash, err := repo.Storer.SetEncodedObject(obj)
+	assert.NoError(t, err)
+	return hash
+}
+
+// storeTag writes an annotated tag of the commit to the repo, and returns the tag's hash.
+func storeTag(t *testing.T, repo *git.Repository, commit plumbing.Hash) plumbing.Hash {
+	tag := &object.Tag{
+		Name:       "v1.2.0",
+		Tagger:     object.Signature{Name: "GitOps", Email: "gitops@greymatter.io", When: time.Unix(1700000000, 0).UTC()},
+		Message:    "Release v1.2.0\n",
+		TargetType: plumbing.CommitObject,
+		Target:     commit,
+	}
+	obj := repo.Storer.NewEncodedObject()
+	assert.NoError(t, tag.Encode(obj))
+	hash, err := repo.Storer.SetEncodedObject(obj)
+	assert.NoError(t, err)
+	return hash
+}
+
+func TestVerifySSHCommit(t *testing.T) {
+	allowed, other := sshSigner(t), sshSigner(t)
+	authorized := func(signer ssh.Signer) string {
+		return string(ssh.MarshalAuthorizedKey(signer.PublicKey()))
+	}
+
+	for _, tc := range []struct {
+		name           string
+		allowedSigners string
+		signer         ssh.Signer
+		namespace      string
+		tagged         bool
+		wantErr        string
+	}{
+		{name: "valid signature", allowedSigners: authorized(allowed), signer: allowed, namespace: "git"},
+		{name: "valid signature with principal", allowedSigners: "# release keys\n\nops@greymatter.io " + authorized(allowed),
+			signer: allowed, namespace: "git"},
+		{name: "annotated tag peeled to its commit", allowedSigners: authorized(allowed), signer: allowed, namespace: "git",
+			tagged: true},
+		{name: "unsigned commit", allowedSigners: authorized(allowed), namespace: "git", wantErr: "is not signed"},
+		{name: "wrong key", allowedSigners: authorized(allowed), signer: other, namespace: "git",
+			wantErr: "which is not an allowed signer"},
+		{name: "wrong namespace", allowedSigners: authorized(allowed), signer: allowed, namespace: "file",
+			wantErr: `was signed for namespace "file", not "git"`},
+		{name: "malformed allowed signers", allowedSigners: "ops@greymatter.io ssh-ed25519 not-base64\ngarbage\n",
+			signer: allowed, namespace: "git", wantErr: "which is not an allowed signer"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "allowed_signers")
+			assert.NoError(t, os.WriteFile(path, []byte(tc.allowedSigners), 0600))
+			cv := CommitVerification{SSHAllowedSignersPath: path}
+			assert.True(t, cv.Enabled())
+
+			repo, err := git.Init(memory.NewStorage(), nil)
+			assert.NoError(t, err)
+			hash := storeCommit(t, repo, tc.signer, tc.namespace)
+			if tc.tagged {
+				tag := storeTag(t, repo, hash)
+				assert.Error(t, cv.Verify(repo, tag), "a tag isn't a commit")
+				hash = peelTag(repo, tag)
+			}
+
+			err = cv.Verify(repo, hash)
+			if tc.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, ErrUnverifiedCommit)
+			assert.Contains(t, err.Error(), tc.wantErr)
+		})
+	}
+}
+
+func TestVerifyWithoutAllowList(t *testing.T) {
+	assert.False(t, CommitVerification{}.Enabled())
+
+	// An SSH-signed commit can't be verified with only a GPG keyring
+	repo, err := git.Init(memory.NewStorage(), nil)
+	assert.NoError(t, err)
+	hash := storeCommit(t, repo, sshSigner(t), "git")
+	err = CommitVerification{GPGKeyringPath: "keyring.asc"}.Verify(repo, hash)
+	assert.ErrorIs(t, err, ErrUnverifiedCommit)
+	assert.Contains(t, err.Error(), "no allowed signers are configured")
+}
@@ -0,0 +1,208 @@
+package gmapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/greymatter-io/operator/pkg/gitops"
+	"github.com/tidwall/gjson"
+)
+
+// How often Catalog registrations not yet made are sent again.
+const catalogRetryInterval = 5 * time.Minute
+
+// DeleteCatalogService deletes the mesh's service with the given ID from Catalog.
+func (client *Client) DeleteCatalogService(ctx context.Context, serviceID string) error {
+	if _, err := client.exec(ctx, apiCatalog, Cmd{
+		args: fmt.Sprintf("delete catalogservice --service-id %s --mesh-id %s", serviceID, client.mesh),
+	}); err != nil {
+		return fmt.Errorf("failed to delete catalogservice %s: %w", serviceID, err)
+	}
+	client.dropCatalogRegistrations("catalogservice", serviceID)
+	logger.Info("delete", "type", "catalogservice", "key", serviceID)
+	return nil
+}
+
+// DeleteCatalogMesh deletes all of the mesh's services from Catalog, then the mesh itself, so a Catalog that
+// outlives the mesh (e.g. one shared by several meshes) isn't left with entries for it. It carries on past
+// failures, and returns the first. It does nothing if the mesh runs without Catalog.
+func (client *Client) DeleteCatalogMesh(ctx context.Context) error {
+	if client.catalogDisabled {
+		return nil
+	}
+	services, err := client.List(ctx, "catalogservice")
+	if err != nil {
+		return err
+	}
+	var firstErr error
+	for _, service := range services {
+		if err := client.DeleteCatalogService(ctx, gjson.GetBytes(service, "service_id").String()); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if _, err := client.exec(ctx, apiCatalog, Cmd{
+		args: fmt.Sprintf("delete catalogmesh --mesh-id %s", client.mesh),
+	}); err != nil && firstErr == nil {
+		firstErr = fmt.Errorf("failed to delete catalogmesh %s: %w", client.mesh, err)
+	}
+	if firstErr == nil {
+		client.trackCatalogWorkloads(func(tracked map[string]bool) {
+			for name := range tracked {
+				delete(tracked, name)
+			}
+		})
+		client.dropCatalogRegistrations("", "")
+		logger.Info("delete", "type", "catalogmesh", "key", client.mesh)
+	}
+	return firstErr
+}
+
+// PruneCatalogWorkloads deletes the Catalog services added by the sidecar configuration of workloads that no
+// longer exist, given the names of those that do, and returns the names of the workloads whose services were
+// deleted. These are left behind when a workload is deleted while the operator isn't watching. Nothing is pruned
+// if the mesh runs without Catalog.
+func (client *Client) PruneCatalogWorkloads(ctx context.Context, workloads map[string]bool) []string {
+	if client.catalogDisabled || client.sync == nil || client.sync.SyncState == nil {
+		return nil
+	}
+	var pruned []string
+	for _, name := range client.sync.SyncState.DerivedDefaults().CatalogWorkloads {
+		if workloads[name] {
+			continue
+		}
+		if err := client.DeleteCatalogService(ctx, name); err != nil {
+			logger.Error(err, "Failed to delete Catalog service of deleted workload - will retry", "name", name)
+			continue
+		}
+		pruned = append(pruned, name)
+	}
+	client.trackCatalogWorkloads(func(tracked map[string]bool) {
+		for _, name := range pruned {
+			delete(tracked, name)
+		}
+	})
+	return pruned
+}
+
+// trackCatalogWorkloads updates the persisted set of workloads whose sidecar configuration added a Catalog service.
+func (client *Client) trackCatalogWorkloads(update func(tracked map[string]bool)) {
+	if client.sync == nil || client.sync.SyncState == nil {
+		return
+	}
+	client.sync.SyncState.UpdateDerivedDefaults(func(dd *gitops.DerivedDefaults) bool {
+		tracked := make(map[string]bool, len(dd.CatalogWorkloads))
+		for _, name := range dd.CatalogWorkloads {
+			tracked[name] = true
+		}
+		update(tracked)
+		var names []string
+		for name := range tracked {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if reflect.DeepEqual(names, dd.CatalogWorkloads) {
+			return false
+		}
+		dd.CatalogWorkloads = names
+		return true
+	})
+}
+
+// syncState returns the state the client's Catalog registrations are persisted in, or nil if it has none.
+func (client *Client) syncState() *gitops.SyncState {
+	if client.sync == nil {
+		return nil
+	}
+	return client.sync.SyncState
+}
+
+// queueCatalogRegistration persists a Catalog registration until it's made, and returns the Cmd that makes it,
+// reporting each attempt's outcome to report if it's set.
+func (client *Client) queueCatalogRegistration(reg gitops.CatalogRegistration, report func(error)) Cmd {
+	if ss := client.syncState(); ss != nil && !client.catalogDisabled {
+		reg = ss.QueueCatalogRegistration(reg)
+	}
+	return client.mkCatalogRegistration(reg, report)
+}
+
+// mkCatalogRegistration returns the Cmd that makes a queued Catalog registration, removing it from the queue once it
+// succeeds.
+func (client *Client) mkCatalogRegistration(reg gitops.CatalogRegistration, report func(error)) Cmd {
+	var cmd Cmd
+	if reg.Kind == "catalogmesh" {
+		var sessions map[string]string
+		_ = json.Unmarshal(reg.Object, &sessions)
+		cmd = mkAddCatalogSessions(reg.Key, sessions)
+	} else {
+		cmd = MkApply(reg.Kind, reg.Object)
+	}
+	cmd.registration = &reg
+	ss := client.syncState()
+	cmd.report = func(err error) {
+		if ss != nil {
+			if err == nil {
+				ss.CatalogRegistrationDone(reg)
+			} else {
+				ss.CatalogRegistrationFailed(reg, err)
+			}
+		}
+		if report != nil {
+			report(err)
+		}
+	}
+	// A chained Cmd reports the outcome once its parent succeeds
+	if cmd.then != nil {
+		then := *cmd.then
+		then.report = cmd.report
+		cmd.then = &then
+	}
+	return cmd
+}
+
+// dropCatalogRegistration stops retrying the Catalog registration made by a Cmd that's flushed or cancelled, if any.
+func (client *Client) dropCatalogRegistration(cmd Cmd) {
+	if ss := client.syncState(); ss != nil && cmd.registration != nil {
+		ss.CatalogRegistrationDone(*cmd.registration)
+	}
+}
+
+// dropCatalogRegistrations stops retrying the mesh's Catalog registrations of an object, e.g. once it's deleted, or
+// all of them if kind is empty.
+func (client *Client) dropCatalogRegistrations(kind, key string) {
+	if ss := client.syncState(); ss != nil {
+		ss.DropCatalogRegistrations(client.mesh, kind, key)
+	}
+}
+
+// retryCatalogRegistrations sends the mesh's Catalog registrations not yet made, e.g. those queued before the
+// operator last stopped or given up on after timing out, then again every catalogRetryInterval until ctx is done.
+// Registrations already waiting to be sent are skipped.
+func (client *Client) retryCatalogRegistrations(ctx context.Context) {
+	ss := client.syncState()
+	if ss == nil {
+		return
+	}
+	for {
+		for _, reg := range ss.PendingCatalogRegistrations(client.mesh) {
+			if client.queues[apiCatalog].hasRegistration(reg.ID()) {
+				continue
+			}
+			logger.Info("Retrying Catalog registration", "type", reg.Kind, "key", reg.Key, "failures", reg.Failures, "error", reg.LastError)
+			commandRetries.WithLabelValues(apiCatalog, "registration").Inc()
+			select {
+			case <-ctx.Done():
+				return
+			case client.CatalogCmds <- client.mkCatalogRegistration(reg, nil):
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(catalogRetryInterval):
+		}
+	}
+}
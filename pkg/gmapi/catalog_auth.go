@@ -0,0 +1,78 @@
+package gmapi
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CatalogAuth is how the greymatter CLI connects to Catalog when it's reached over TLS, e.g. through the mesh's
+// edge, and authenticates to it.
+type CatalogAuth struct {
+	// The Catalog API's URL, replacing the catalog Service in the mesh's install namespace if set
+	URL string
+	// PEM client certificate and key presented to Catalog
+	Cert, Key []byte
+	// PEM bundle of the CAs trusted to sign Catalog's certificate
+	CABundle []byte
+	// Sent to Catalog as a bearer token
+	Token string
+}
+
+// catalogAuthFiles is a CatalogAuth as written for the greymatter CLI, with its certificates in files.
+type catalogAuthFiles struct {
+	url                 string
+	cert, key, caBundle string
+	token               string
+}
+
+// toml returns the settings of the CLI config's catalog section for connecting to Catalog.
+func (f catalogAuthFiles) toml() string {
+	var b strings.Builder
+	for _, setting := range []struct{ key, value string }{
+		{"cert", f.cert},
+		{"key", f.key},
+		{"cacert", f.caBundle},
+	} {
+		if setting.value != "" {
+			fmt.Fprintf(&b, "\t%s = %q\n", setting.key, setting.value)
+		}
+	}
+	if f.token != "" {
+		fmt.Fprintf(&b, "\t[catalog.headers]\n\tAuthorization = %q\n", "Bearer "+f.token)
+	}
+	return b.String()
+}
+
+// SetCatalogAuth sets how CLI commands connect to Catalog, used by clients configured from then on. The
+// certificates are written to files in the given directory for the CLI to read.
+func (c *CLI) SetCatalogAuth(auth CatalogAuth, dir string) error {
+	files := catalogAuthFiles{url: auth.URL, token: auth.Token}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create directory for Catalog client certificates: %w", err)
+	}
+	for _, file := range []struct {
+		path     *string
+		name     string
+		contents []byte
+	}{
+		{&files.cert, "catalog.crt", auth.Cert},
+		{&files.key, "catalog.key", auth.Key},
+		{&files.caBundle, "catalog-ca.crt", auth.CABundle},
+	} {
+		if len(file.contents) == 0 {
+			continue
+		}
+		path := filepath.Join(dir, file.name)
+		if err := os.WriteFile(path, file.contents, 0o600); err != nil {
+			return fmt.Errorf("failed to write Catalog client certificate: %w", err)
+		}
+		*file.path = path
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	c.catalogAuth = files
+	return nil
+}
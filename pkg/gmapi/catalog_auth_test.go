@@ -0,0 +1,46 @@
+package gmapi
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetCatalogAuth(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := NewUnchecked(ctx, nil)
+	dir := filepath.Join(t.TempDir(), "catalog")
+
+	assert.NoError(t, c.SetCatalogAuth(CatalogAuth{
+		URL:      "https://edge.example.com/services/catalog/",
+		Cert:     []byte("client cert"),
+		Key:      []byte("client key"),
+		CABundle: []byte("ca bundle"),
+		Token:    "s3cret",
+	}, dir))
+	key, err := os.ReadFile(filepath.Join(dir, "catalog.key"))
+	assert.NoError(t, err)
+	assert.Equal(t, "client key", string(key))
+
+	conf := mkCLIConfig("http://control:5555", c.catalogAuth.url, "mesh", c.catalogAuth)
+	_, catalogAPI := parseCLIConfig(conf)
+	assert.Equal(t, "https://edge.example.com/services/catalog/", catalogAPI)
+	decoded, _ := base64.StdEncoding.DecodeString(conf)
+	for _, want := range []string{
+		`cert = "` + filepath.Join(dir, "catalog.crt") + `"`,
+		`key = "` + filepath.Join(dir, "catalog.key") + `"`,
+		`cacert = "` + filepath.Join(dir, "catalog-ca.crt") + `"`,
+		"[catalog.headers]",
+		`Authorization = "Bearer s3cret"`,
+	} {
+		assert.Contains(t, string(decoded), want)
+	}
+
+	// Without TLS or a token, the catalog section only has its URL and mesh
+	assert.Empty(t, catalogAuthFiles{url: "http://catalog:8080"}.toml())
+}
@@ -0,0 +1,110 @@
+package gmapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	"github.com/greymatter-io/operator/pkg/gitops"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
+)
+
+func TestPruneCatalogWorkloads(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sync := gitops.New("", ctx, cancel)
+	sync.SyncState = gitops.NewSyncState(ctx, cuemodule.Defaults{RedisHost: "127.0.0.1", RedisPort: 1}, "")
+
+	m := NewMockAPI()
+	defer m.Close()
+	client := &Client{
+		mesh:   "mesh",
+		flags:  []string{"--base64-config", mkCLIConfig(m.Control.URL, m.Catalog.URL, "mesh", catalogAuthFiles{})},
+		policy: commandPolicy{timeout: 5 * time.Second},
+		run:    runMock,
+		sync:   sync,
+	}
+	for _, service := range []string{
+		`{"service_id":"edge","mesh_id":"mesh","name":"Edge"}`,
+		`{"service_id":"example","mesh_id":"mesh","name":"Example"}`,
+		`{"service_id":"deleted","mesh_id":"mesh","name":"Deleted"}`,
+		`{"service_id":"other","mesh_id":"other-mesh","name":"Other"}`,
+	} {
+		_, err := client.exec(ctx, apiCatalog, MkApply("catalogservice", json.RawMessage(service)))
+		assert.NoError(t, err)
+	}
+	client.trackCatalogWorkloads(func(tracked map[string]bool) { tracked["example"], tracked["deleted"] = true, true })
+	assert.Equal(t, []string{"deleted", "example"}, sync.SyncState.DerivedDefaults().CatalogWorkloads)
+
+	// Only services added for workloads that no longer exist are deleted
+	pruned := client.PruneCatalogWorkloads(ctx, map[string]bool{"example": true})
+	assert.Equal(t, []string{"deleted"}, pruned)
+	assert.Equal(t, []string{"edge", "example", "other"}, m.Keys("catalogservice"))
+	assert.Equal(t, []string{"example"}, sync.SyncState.DerivedDefaults().CatalogWorkloads)
+
+	// Removing the mesh deletes all of its services from Catalog, but not other meshes'
+	_, err := client.exec(ctx, apiCatalog, MkApply("catalogmesh", json.RawMessage(`{"mesh_id":"mesh"}`)))
+	assert.NoError(t, err)
+	assert.NoError(t, client.DeleteCatalogMesh(ctx))
+	assert.Equal(t, []string{"other"}, m.Keys("catalogservice"))
+	assert.Empty(t, m.Keys("catalogmesh"))
+	assert.Empty(t, sync.SyncState.DerivedDefaults().CatalogWorkloads)
+}
+
+func TestRetryCatalogRegistrations(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sync := gitops.New("", ctx, cancel)
+	sync.SyncState = gitops.NewSyncState(ctx, cuemodule.Defaults{RedisHost: "127.0.0.1", RedisPort: 1}, "")
+
+	m := NewMockAPI()
+	defer m.Close()
+	client := &Client{
+		mesh:        "mesh",
+		flags:       []string{"--base64-config", mkCLIConfig(m.Control.URL, m.Catalog.URL, "mesh", catalogAuthFiles{})},
+		policy:      commandPolicy{timeout: 5 * time.Second, limit: rate.Inf},
+		run:         runMock,
+		sync:        sync,
+		CatalogCmds: make(chan Cmd, commandQueueSize),
+		queues:      map[string]*cmdQueue{apiCatalog: newCmdQueue()},
+	}
+
+	// A registration that fails stays queued
+	failing := func(ctx context.Context, args []string, stdin []byte, env []string) ([]byte, error) {
+		return []byte("catalog unavailable"), errors.New("exit status 1")
+	}
+	cmd := client.queueCatalogRegistration(gitops.CatalogRegistration{
+		Mesh: "mesh", Kind: "catalogservice", Key: "edge", Object: json.RawMessage(`{"service_id":"edge","mesh_id":"mesh","name":"Edge"}`),
+	}, nil)
+	_, err := cmd.run(ctx, failing, nil, nil)
+	assert.Error(t, err)
+	pending := sync.SyncState.PendingCatalogRegistrations("mesh")
+	if assert.Len(t, pending, 1) {
+		assert.Equal(t, 1, pending[0].Failures)
+	}
+
+	// Once Catalog is reachable, registrations not yet made are sent again, and leave the queue when they succeed
+	go client.queues[apiCatalog].fill(ctx, client.CatalogCmds)
+	go client.retryCatalogRegistrations(ctx)
+	go client.consume(ctx, apiCatalog)
+	assert.Eventually(t, func() bool {
+		return len(sync.SyncState.PendingCatalogRegistrations("mesh")) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"edge"}, m.Keys("catalogservice"))
+
+	// Deleting a service stops retrying its registration, as does dropping its command from the queue
+	reg := gitops.CatalogRegistration{Mesh: "mesh", Kind: "catalogservice", Key: "edge", Object: json.RawMessage(`{"service_id":"edge","mesh_id":"mesh","name":"Edge"}`)}
+	client.queueCatalogRegistration(reg, nil)
+	assert.NoError(t, client.DeleteCatalogService(ctx, "edge"))
+	assert.Empty(t, sync.SyncState.PendingCatalogRegistrations("mesh"))
+	queue := newCmdQueue()
+	queue.dropped = client.dropCatalogRegistration
+	queue.push(&queuedCmd{cmd: client.queueCatalogRegistration(reg, nil)})
+	assert.True(t, queue.hasRegistration(reg.ID()))
+	assert.Equal(t, 1, queue.flush())
+	assert.Empty(t, sync.SyncState.PendingCatalogRegistrations("mesh"))
+}
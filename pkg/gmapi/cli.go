@@ -6,10 +6,13 @@ package gmapi
 import (
 	"context"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"reflect"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
-	"time"
 
 	"github.com/greymatter-io/operator/api/v1alpha1"
 	"github.com/greymatter-io/operator/pkg/cuemodule"
@@ -23,10 +26,25 @@ var (
 )
 
 // CLI exposes methods for configuring clients that execute greymatter CLI commands.
+// It keeps a client for each mesh, created when the mesh is added, recreated when its API endpoints change, and
+// removed when the mesh is deleted.
 type CLI struct {
 	*sync.RWMutex
-	Client      *Client
+	// The client of the mesh most recently configured, which the operator currently manages.
+	Client *Client
+	// The client of each mesh by name.
+	clients     map[string]*Client
 	operatorCUE *cuemodule.OperatorCUE
+	// Runs each command; the greymatter CLI unless the APIs are mocked.
+	run runFunc
+	// Additional environment variables for the CLI, e.g. proxy settings.
+	env []string
+	// If set, the Control and Catalog APIs used instead of the mesh's own, e.g. a MockAPI's.
+	controlAPI, catalogAPI string
+	// How commands connect to Catalog over TLS and authenticate to it, if it requires them.
+	catalogAuth catalogAuthFiles
+	// Logs in to Control and Catalog for session tokens, if they require them.
+	session *apiSession
 }
 
 // New returns a new *CLI instance.
@@ -40,10 +58,19 @@ func New(ctx context.Context, operatorCUE *cuemodule.OperatorCUE) (*CLI, error)
 
 	logger.Info("Using greymatter CLI", "Version", v)
 
+	return NewUnchecked(ctx, operatorCUE), nil
+}
+
+// NewUnchecked returns a new *CLI like New, without first checking that the greymatter CLI is installed.
+// Without it, its clients wait indefinitely to connect to Control and Catalog, so no Grey Matter config is sent,
+// e.g. when testing the Kubernetes side of an install.
+func NewUnchecked(ctx context.Context, operatorCUE *cuemodule.OperatorCUE) *CLI {
 	gmcli := &CLI{
 		RWMutex:     &sync.RWMutex{},
 		Client:      nil,
+		clients:     make(map[string]*Client),
 		operatorCUE: operatorCUE,
+		run:         execCLI,
 	}
 
 	// Cancel all Client goroutines if package context is done.
@@ -52,73 +79,201 @@ func New(ctx context.Context, operatorCUE *cuemodule.OperatorCUE) (*CLI, error)
 		c.RLock()
 		defer c.RUnlock()
 		logger.Info("Cancelling Client goroutines")
-		if c.Client != nil {
-			c.Client.Cancel()
+		for _, cl := range c.clients {
+			cl.Cancel()
 		}
 	}(gmcli)
 
-	return gmcli, nil
+	return gmcli
+}
+
+// SetEnv sets additional environment variables for CLI commands, such as proxy settings,
+// used by clients configured from then on.
+func (c *CLI) SetEnv(env []string) {
+	c.Lock()
+	defer c.Unlock()
+	c.env = env
 }
 
 // ConfigureMeshClient initializes or updates a greymatter CLI client utilizing a base64 encoded
-// config.toml file.
-func (c *CLI) ConfigureMeshClient(mesh *v1alpha1.Mesh, sync *gitops.Sync) {
-	conf := mkCLIConfig( // TODO this should come from config
-		// control
-		fmt.Sprintf("http://controlensemble.%s.svc.cluster.local:5555", mesh.Spec.InstallNamespace),
-		// catalog
-		fmt.Sprintf("http://catalog.%s.svc.cluster.local:8080", mesh.Spec.InstallNamespace),
-		mesh.Name,
-	)
+// config.toml file, and applies the mesh's core Grey Matter config from the given CUE unified with it.
+func (c *CLI) ConfigureMeshClient(operatorCUE *cuemodule.OperatorCUE, mesh *v1alpha1.Mesh, sync *gitops.Sync) {
+	// TODO these should come from config
+	controlAPI := fmt.Sprintf("http://controlensemble.%s.svc.cluster.local:5555", mesh.Spec.InstallNamespace)
+	catalogAPI := fmt.Sprintf("http://catalog.%s.svc.cluster.local:8080", mesh.Spec.InstallNamespace)
+	c.RLock()
+	catalogAuth := c.catalogAuth
+	c.RUnlock()
+	if catalogAuth.url != "" {
+		catalogAPI = catalogAuth.url
+	}
+	if c.controlAPI != "" {
+		controlAPI, catalogAPI = c.controlAPI, c.catalogAPI
+	}
+	conf := mkCLIConfig(controlAPI, catalogAPI, mesh.Name, catalogAuth)
 	flags := []string{"--base64-config", conf}
 
-	if err := c.configureMeshClient(mesh, sync, flags...); err != nil {
+	// Commands for additional zones served by another Control API are sent to it instead
+	zoneFlags := make(map[string][]string)
+	for _, zone := range mesh.Spec.Zones {
+		if zone.APIURL != "" && zone.APIURL != controlAPI {
+			zoneFlags[zone.Name] = []string{"--base64-config", mkCLIConfig(zone.APIURL, catalogAPI, mesh.Name, catalogAuth)}
+		}
+	}
+
+	if err := c.configureMeshClient(operatorCUE, mesh, sync, zoneFlags, flags...); err != nil {
 		logger.Error(err, "failed to configure Client", "Mesh", mesh.Name)
 	}
 }
 
-func mkCLIConfig(apiHost, catalogHost, catalogMesh string) string {
+func mkCLIConfig(apiHost, catalogHost, catalogMesh string, catalogAuth catalogAuthFiles) string {
 	return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf(`
 	[api]
 	url = "%s"
 	[catalog]
 	url = "%s"
 	mesh = "%s"
-	`, apiHost, catalogHost, catalogMesh)))
+%s	`, apiHost, catalogHost, catalogMesh, catalogAuth.toml())))
 }
 
-func (c *CLI) configureMeshClient(mesh *v1alpha1.Mesh, sync *gitops.Sync, flags ...string) error {
+// configureMeshClient creates the mesh's client, or recreates it if its API endpoints changed. If they didn't, the
+// existing client and its queued commands are kept, and the mesh's zones and core config are applied with it.
+func (c *CLI) configureMeshClient(operatorCUE *cuemodule.OperatorCUE, mesh *v1alpha1.Mesh, sync *gitops.Sync, zoneFlags map[string][]string, flags ...string) error {
 	c.Lock()
 	defer c.Unlock()
+	c.operatorCUE = operatorCUE
+
+	existing, ok := c.clients[mesh.Name]
+	if ok && reflect.DeepEqual(existing.flags, flags) && reflect.DeepEqual(existing.zoneFlags, zoneFlags) &&
+		existing.catalogDisabled == cuemodule.ComponentDisabled(mesh, "catalog") {
+		logger.Info("Reusing mesh Client", "Mesh", mesh.Name)
+		c.Client = existing
+		go func(mesh *v1alpha1.Mesh) {
+			ApplyZones(existing, mesh)
+			ApplyCoreMeshConfigs(existing, c.operatorCUE)
+		}(mesh.DeepCopy())
+		return nil
+	}
 
 	// Close an existing cmds channel if updating
-	if c.Client != nil {
+	if ok {
 		logger.Info("Updating mesh Client", "Mesh", mesh.Name)
-		c.Client.Cancel()
+		existing.Cancel()
+		delete(c.clients, mesh.Name)
 	} else {
 		logger.Info("Initializing mesh Client", "Mesh", mesh.Name)
 	}
 
-	cl, err := newClient(c.operatorCUE, mesh, sync, flags...)
+	cl, err := newClient(c.operatorCUE, mesh, sync, c.run, c.env, c.session, zoneFlags, flags...)
 	if err != nil {
 		return err
 	}
 
+	if c.clients == nil {
+		c.clients = make(map[string]*Client) // a CLI not made by New
+	}
+	c.clients[mesh.Name] = cl
 	c.Client = cl
 
 	return nil
 }
 
-// RemoveMeshClient cleans up a Client's goroutines before removing it from the *CLI.
-func (c *CLI) RemoveMeshClient() {
-	if c.Client != nil {
-		c.Client.Cancel()
+// MeshClient returns the client of the named mesh, if it has one.
+func (c *CLI) MeshClient(name string) (*Client, bool) {
+	c.RLock()
+	defer c.RUnlock()
+	cl, ok := c.clients[name]
+	return cl, ok
+}
+
+// MeshClients returns the names of the meshes with a client, sorted.
+func (c *CLI) MeshClients() []string {
+	c.RLock()
+	defer c.RUnlock()
+	names := make([]string, 0, len(c.clients))
+	for name := range c.clients {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Queue describes the commands of each mesh's client that haven't yet been sent to Control and Catalog
+// successfully, ordered by mesh.
+func (c *CLI) Queue() []QueuedCommand {
+	cmds := []QueuedCommand{}
+	for _, name := range c.MeshClients() {
+		if cl, ok := c.MeshClient(name); ok {
+			cmds = append(cmds, cl.Queue()...)
+		}
+	}
+	return cmds
+}
+
+// FlushQueue drops the commands waiting to be sent to the given API ("control" or "catalog", or both if empty) by
+// the named mesh's client, or every mesh's if empty, and returns how many were dropped.
+func (c *CLI) FlushQueue(mesh, api string) int {
+	flushed := 0
+	for _, name := range c.MeshClients() {
+		if cl, ok := c.MeshClient(name); ok && (mesh == "" || mesh == name) {
+			flushed += cl.FlushQueue(api)
+		}
 	}
+	return flushed
+}
+
+// CancelCommand drops the queued command with the given ID from whichever mesh's client has it, interrupting it if
+// it's in flight. It returns false if none has it.
+func (c *CLI) CancelCommand(id uint64) bool {
+	for _, name := range c.MeshClients() {
+		if cl, ok := c.MeshClient(name); ok && cl.CancelCommand(id) {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveMeshClient removes the named mesh's client from the *CLI, then deletes the mesh from Catalog and cleans up
+// the client's goroutines.
+func (c *CLI) RemoveMeshClient(name string) {
+	c.Lock()
+	cl, ok := c.clients[name]
+	delete(c.clients, name)
+	if ok && c.Client == cl {
+		c.Client = nil
+	}
+	c.Unlock()
+	if !ok {
+		return
+	}
+
+	if err := cl.DeleteCatalogMesh(cl.Ctx); err != nil {
+		logger.Error(err, "Failed to delete removed mesh from Catalog", "Mesh", name)
+	}
+	cl.Cancel()
+}
+
+// Close closes each mesh's client in turn, waiting for the commands it has queued to be sent (see Client.Close). Once
+// ctx is done, the rest are stopped without waiting.
+func (c *CLI) Close(ctx context.Context) error {
+	var errs []string
+	for _, name := range c.MeshClients() {
+		if cl, ok := c.MeshClient(name); ok {
+			if err := cl.Close(ctx); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to send all queued commands: %s", strings.Join(errs, "; "))
+	}
+	return nil
 }
 
 // ConfigureSidecar applies fabric objects that add a workload to the mesh specified
-// given the workload's annotations and a list of its corev1.Containers.
-func (c *CLI) ConfigureSidecar(operatorCUE *cuemodule.OperatorCUE, name string, annotations map[string]string) {
+// given the workload's annotations and a list of its corev1.Containers. It returns an error if they couldn't be
+// extracted from CUE or there's no mesh yet, so the change can be retried.
+func (c *CLI) ConfigureSidecar(operatorCUE *cuemodule.OperatorCUE, name string, annotations map[string]string) error {
 	//annotations := metadata.Annotations
 	injectedSidecarPortString, injectSidecar := annotations[wellknown.ANNOTATION_INJECT_SIDECAR_TO_PORT]
 	var injectedSidecarPort int
@@ -126,40 +281,67 @@ func (c *CLI) ConfigureSidecar(operatorCUE *cuemodule.OperatorCUE, name string,
 		parsedPort, err := strconv.Atoi(injectedSidecarPortString)
 		if err != nil {
 			logger.Error(err, "provided port for sidecar upstream could not be parsed as int", wellknown.ANNOTATION_INJECT_SIDECAR_TO_PORT, injectedSidecarPortString)
-			return
+			return nil
 		}
 		injectedSidecarPort = parsedPort
 	} else { // if we're not injecting a sidecar, skip configuration
-		return
+		return nil
 	}
 
 	// we skip configuration if we're explicitly told to
 	configureSidecar, configureSidecarPresent := annotations[wellknown.ANNOTATION_CONFIGURE_SIDECAR]
 	if !configureSidecarPresent || configureSidecar == "false" {
-		return
+		return nil
 	}
 
 	configObjects, kinds, err := operatorCUE.UnifyAndExtractSidecarConfig(name, injectedSidecarPort)
 	if err != nil {
-		logger.Error(err, "Failed to unify or extract CUE", "name", name, "injectedSidecarPort", injectedSidecarPort)
+		return fmt.Errorf("failed to unify or extract CUE for %s: %w", name, err)
 	}
+	configObjects, kinds = addSidecarEgress(name, annotations, configObjects, kinds)
 
-	c.EnsureClient("ConfigureSidecar")
+	if c.Client == nil {
+		return fmt.Errorf("no mesh to add %s to yet", name)
+	}
+	configObjects, kinds = applyCatalogAnnotations(name, c.Client.mesh, annotations, configObjects, kinds)
 	ApplyAll(c.Client, configObjects, kinds)
+	for _, kind := range kinds {
+		if kind == "catalogservice" {
+			c.Client.trackCatalogWorkloads(func(tracked map[string]bool) { tracked[name] = true })
+			break
+		}
+	}
+	return nil
 }
 
-func (c *CLI) EnsureClient(in string) {
-	for {
-		if c.Client != nil {
+// ConfigureStaticSidecar applies the fabric objects that add a workload whose pods Control doesn't discover, such as
+// the operator itself, to the mesh, like ConfigureSidecar with a sidecar injected to the given port. The edge reaches
+// its sidecar at the given host and port instead. Only the annotations describing it in Catalog are used.
+func (c *CLI) ConfigureStaticSidecar(operatorCUE *cuemodule.OperatorCUE, name string, port int, host string, proxyPort int, annotations map[string]string) error {
+	configObjects, kinds, err := operatorCUE.UnifyAndExtractSidecarConfig(name, port)
+	if err != nil {
+		return err
+	}
+	if configObjects, err = cuemodule.SetClusterInstances(name, host, proxyPort, configObjects, kinds); err != nil {
+		return err
+	}
+	if c.Client == nil {
+		return fmt.Errorf("no mesh to add %s to", name)
+	}
+	configObjects, kinds = applyCatalogAnnotations(name, c.Client.mesh, annotations, configObjects, kinds)
+	ApplyAll(c.Client, configObjects, kinds)
+	for _, kind := range kinds {
+		if kind == "catalogservice" {
+			c.Client.trackCatalogWorkloads(func(tracked map[string]bool) { tracked[name] = true })
 			break
 		}
-		logger.Info(fmt.Sprintf("(in %s) greymatter client does not yet exist, will retry in 10 seconds", in))
-		time.Sleep(10 * time.Second)
 	}
+	return nil
 }
 
-// UnconfigureSidecar removes fabric objects, disconnecting the workload from the mesh specified
-func (c *CLI) UnconfigureSidecar(operatorCUE *cuemodule.OperatorCUE, name string, annotations map[string]string) {
+// UnconfigureSidecar removes fabric objects, disconnecting the workload from the mesh specified. It returns an error if
+// they couldn't be extracted from CUE, so the change can be retried.
+func (c *CLI) UnconfigureSidecar(operatorCUE *cuemodule.OperatorCUE, name string, annotations map[string]string) error {
 	//annotations := metadata.Annotations
 	logger.Info("Unconfiguring sidecar with values", "name", name, "annotations", annotations)
 	injectedSidecarPortString, injectSidecar := annotations[wellknown.ANNOTATION_INJECT_SIDECAR_TO_PORT]
@@ -168,23 +350,141 @@ func (c *CLI) UnconfigureSidecar(operatorCUE *cuemodule.OperatorCUE, name string
 		parsedPort, err := strconv.Atoi(injectedSidecarPortString)
 		if err != nil {
 			logger.Error(err, "provided port for sidecar upstream could not be parsed as int", wellknown.ANNOTATION_INJECT_SIDECAR_TO_PORT, injectedSidecarPortString)
-			return
+			return nil
 		}
 		injectedSidecarPort = parsedPort
 	} else { // if we're not injecting a sidecar, skip configuration
-		return
+		return nil
 	}
 
 	// we also skip configuration if we're explicitly told to
 	configureSidecar := annotations[wellknown.ANNOTATION_CONFIGURE_SIDECAR]
 	if configureSidecar == "false" {
-		return
+		return nil
 	}
 
 	configObjects, kinds, err := operatorCUE.UnifyAndExtractSidecarConfig(name, injectedSidecarPort)
 	if err != nil {
-		logger.Error(err, "Failed to unify or extract CUE", "name", name, "injectedSidecarPort", injectedSidecarPort)
+		return fmt.Errorf("failed to unify or extract CUE for %s: %w", name, err)
 	}
+	configObjects, kinds = addSidecarEgress(name, annotations, configObjects, kinds)
+	if c.Client == nil {
+		// No mesh, so nothing to remove the workload from
+		return nil
+	}
+	// Deletes any catalogservice added for the annotations
+	configObjects, kinds = applyCatalogAnnotations(name, c.Client.mesh, annotations, configObjects, kinds)
 
 	UnApplyAll(c.Client, configObjects, kinds)
+	c.Client.trackCatalogWorkloads(func(tracked map[string]bool) { delete(tracked, name) })
+	return nil
+}
+
+// addSidecarEgress adds routes for the external dependencies in a workload's egress annotation to its sidecar's
+// config objects, through the listener its outbound traffic is redirected to. They're left out if they're invalid.
+func addSidecarEgress(name string, annotations map[string]string, configObjects []json.RawMessage, kinds []string) ([]json.RawMessage, []string) {
+	egress, ok := annotations[wellknown.ANNOTATION_EGRESS]
+	if !ok || len(configObjects) == 0 {
+		return configObjects, kinds
+	}
+	targets, err := cuemodule.ParseEgressTargets(egress)
+	if err != nil {
+		logger.Error(err, "Not configuring sidecar egress", "name", name, wellknown.ANNOTATION_EGRESS, egress)
+		return configObjects, kinds
+	}
+	var egressPort int
+	if v, ok := annotations[wellknown.ANNOTATION_TRAFFIC_REDIRECT_OUTBOUND_PORT]; ok {
+		if egressPort, err = strconv.Atoi(v); err != nil {
+			logger.Error(err, "Not configuring sidecar egress", "name", name, wellknown.ANNOTATION_TRAFFIC_REDIRECT_OUTBOUND_PORT, v)
+			return configObjects, kinds
+		}
+	}
+	withEgress, withEgressKinds, err := cuemodule.AddSidecarEgress(name, targets, egressPort, configObjects, kinds)
+	if err != nil {
+		logger.Error(err, "Not configuring sidecar egress", "name", name)
+		return configObjects, kinds
+	}
+	return withEgress, withEgressKinds
+}
+
+// applyCatalogAnnotations sets the fields of a workload's catalogservice from its catalog annotations, adding one to
+// its sidecar's config objects if they have none.
+func applyCatalogAnnotations(name, meshID string, annotations map[string]string, configObjects []json.RawMessage, kinds []string) ([]json.RawMessage, []string) {
+	if len(configObjects) == 0 {
+		return configObjects, kinds
+	}
+	entry := cuemodule.CatalogEntry{
+		Name:          annotations[wellknown.ANNOTATION_CATALOG_NAME],
+		Description:   annotations[wellknown.ANNOTATION_CATALOG_DESCRIPTION],
+		Owner:         annotations[wellknown.ANNOTATION_CATALOG_OWNER],
+		Documentation: annotations[wellknown.ANNOTATION_CATALOG_DOCS],
+		APIEndpoint:   annotations[wellknown.ANNOTATION_CATALOG_API_ENDPOINT],
+		Version:       annotations[wellknown.ANNOTATION_CATALOG_VERSION],
+		// Specs read from a ConfigMap have no URL to link to
+		APISpecEndpoint: annotations[wellknown.ANNOTATION_CATALOG_API_SPEC],
+	}
+	withEntry, withEntryKinds, err := cuemodule.ApplyCatalogEntry(name, meshID, entry, configObjects, kinds)
+	if err != nil {
+		logger.Error(err, "Not applying catalog annotations", "name", name)
+		return configObjects, kinds
+	}
+	return withEntry, withEntryKinds
+}
+
+// RemoveStaleSidecarEgress deletes the config objects routing a workload's sidecar to the external dependencies in its
+// previous egress annotation that its current annotations no longer declare, along with its egress listener if none
+// are left. It's called after the current configuration is applied, so the sidecar's proxy no longer refers to them.
+// It returns an error if they couldn't be extracted from CUE or there's no mesh yet, so the change can be retried.
+func (c *CLI) RemoveStaleSidecarEgress(operatorCUE *cuemodule.OperatorCUE, name string, previousEgress string, annotations map[string]string) error {
+	previous, err := cuemodule.ParseEgressTargets(previousEgress)
+	if err != nil {
+		return nil // never configured
+	}
+	current, err := cuemodule.ParseEgressTargets(annotations[wellknown.ANNOTATION_EGRESS])
+	if err != nil {
+		current = nil // not configured, so everything previous is stale
+	}
+	declared := make(map[cuemodule.EgressTarget]bool)
+	for _, target := range current {
+		declared[target] = true
+	}
+	var stale []cuemodule.EgressTarget
+	for _, target := range previous {
+		if !declared[target] {
+			stale = append(stale, target)
+		}
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+
+	injectedSidecarPort, err := strconv.Atoi(annotations[wellknown.ANNOTATION_INJECT_SIDECAR_TO_PORT])
+	if err != nil {
+		return nil
+	}
+	configObjects, kinds, err := operatorCUE.UnifyAndExtractSidecarConfig(name, injectedSidecarPort)
+	if err != nil {
+		return fmt.Errorf("failed to unify or extract CUE for %s: %w", name, err)
+	}
+	egressPort, _ := strconv.Atoi(annotations[wellknown.ANNOTATION_TRAFFIC_REDIRECT_OUTBOUND_PORT])
+	withStale, withStaleKinds, err := cuemodule.AddSidecarEgress(name, stale, egressPort, configObjects, kinds)
+	if err != nil {
+		return fmt.Errorf("failed to remove stale sidecar egress of %s: %w", name, err)
+	}
+	// Only the objects added for the stale dependencies are deleted, and the egress listener if it's now unused
+	var staleObjects []json.RawMessage
+	var staleKinds []string
+	for idx := len(configObjects); idx < len(withStale); idx++ {
+		if withStaleKinds[idx] == "listener" && len(current) > 0 {
+			continue
+		}
+		staleObjects = append(staleObjects, withStale[idx])
+		staleKinds = append(staleKinds, withStaleKinds[idx])
+	}
+	logger.Info("Removing stale sidecar egress", "name", name, "dependencies", len(stale))
+	if c.Client == nil {
+		return fmt.Errorf("no mesh to remove the stale egress of %s from yet", name)
+	}
+	UnApplyAll(c.Client, staleObjects, staleKinds)
+	return nil
 }
@@ -2,44 +2,123 @@ package gmapi
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/greymatter-io/operator/api/v1alpha1"
 	"github.com/greymatter-io/operator/pkg/cuemodule"
 	"github.com/greymatter-io/operator/pkg/gitops"
+	"golang.org/x/time/rate"
+	"math"
+	"sync/atomic"
 	"time"
 )
 
 type Client struct {
-	mesh        string
-	flags       []string
+	mesh  string
+	flags []string
+	// CLI flags for additional zones served by a different Control API than the mesh's.
+	zoneFlags   map[string][]string
+	policy      commandPolicy
 	ControlCmds chan Cmd
 	CatalogCmds chan Cmd
 	Ctx         context.Context
 	Cancel      context.CancelFunc
 	sync        *gitops.Sync
+	// Runs each command, normally with the greymatter CLI.
+	run runFunc
+	// Additional environment variables for the CLI, e.g. proxy settings.
+	env []string
+	// If set, the session token for Control and Catalog sent with each command.
+	session *apiSession
+	// The commands of each API that haven't been sent successfully yet, by API.
+	queues map[string]*cmdQueue
+	// Whether the mesh runs without Catalog, so commands for it are dropped rather than sent.
+	catalogDisabled bool
 }
 
-func newClient(operatorCUE *cuemodule.OperatorCUE, mesh *v1alpha1.Mesh, sync *gitops.Sync, flags ...string) (*Client, error) {
+// errCatalogDisabled is returned for commands sent to Catalog when the mesh runs without it.
+var errCatalogDisabled = errors.New("catalog is disabled for the mesh")
+
+// commandPolicy limits how long each command may run, how often timed-out commands are retried,
+// and how fast commands are sent to each API.
+type commandPolicy struct {
+	timeout    time.Duration
+	maxRetries int
+	limit      rate.Limit
+	burst      int
+}
+
+const (
+	defaultCommandTimeout        = 30 * time.Second
+	defaultCommandTimeoutRetries = 3
+	// How long a failed command waits before it is requeued.
+	requeueDelay = 10 * time.Second
+	// How many commands each API's channel holds before senders block.
+	commandQueueSize = 1024
+)
+
+func newCommandPolicy(config cuemodule.Config) commandPolicy {
+	policy := commandPolicy{
+		timeout:    defaultCommandTimeout,
+		maxRetries: defaultCommandTimeoutRetries,
+		limit:      rate.Inf,
+	}
+	if config.CommandTimeoutSeconds > 0 {
+		policy.timeout = time.Duration(config.CommandTimeoutSeconds) * time.Second
+	}
+	if config.CommandTimeoutRetries > 0 {
+		policy.maxRetries = config.CommandTimeoutRetries
+	}
+	if config.APIRateLimit > 0 {
+		policy.limit = rate.Limit(config.APIRateLimit)
+		policy.burst = config.APIRateBurst
+		if policy.burst <= 0 {
+			policy.burst = int(math.Ceil(config.APIRateLimit))
+		}
+	}
+	return policy
+}
+
+func newClient(operatorCUE *cuemodule.OperatorCUE, mesh *v1alpha1.Mesh, sync *gitops.Sync, run runFunc, env []string, session *apiSession, zoneFlags map[string][]string, flags ...string) (*Client, error) {
 
 	ctxt, cancel := context.WithCancel(context.Background())
 
+	config, _ := operatorCUE.ExtractConfig()
 	client := &Client{
 		mesh:        mesh.Name,
 		flags:       flags,
-		ControlCmds: make(chan Cmd),
-		CatalogCmds: make(chan Cmd),
+		zoneFlags:   zoneFlags,
+		policy:      newCommandPolicy(config),
+		run:         run,
+		env:         env,
+		session:     session,
+		ControlCmds: make(chan Cmd, commandQueueSize),
+		CatalogCmds: make(chan Cmd, commandQueueSize),
 		Ctx:         ctxt,
 		Cancel:      cancel,
 		sync:        sync,
+		queues:      map[string]*cmdQueue{apiControl: newCmdQueue(), apiCatalog: newCmdQueue()},
+
+		catalogDisabled: cuemodule.ComponentDisabled(mesh, "catalog"),
 	}
 
-	// Apply core Grey Matter components from CUE
+	// Queue commands as they're sent, so those waiting for an API can be inspected. Catalog registrations dropped from
+	// the queue aren't retried.
+	client.queues[apiCatalog].dropped = client.dropCatalogRegistration
+	go client.queues[apiControl].fill(client.Ctx, client.ControlCmds)
+	go client.queues[apiCatalog].fill(client.Ctx, client.CatalogCmds)
+
+	// Create additional zones, then apply core Grey Matter components from CUE
 	// This just dumps them on the channel, so it will block until the consumer is ready
-	go ApplyCoreMeshConfigs(client, operatorCUE)
+	go func() {
+		ApplyZones(client, mesh)
+		ApplyCoreMeshConfigs(client, operatorCUE)
+	}()
 
 	// Consumer of commands to send to Control
-	go func(ctx context.Context, controlCmds chan Cmd) {
+	go func(ctx context.Context) {
 		start := time.Now()
 
 		// Generate a random shared_rules object key to create a dummy object that ensures we can write to Control.
@@ -53,11 +132,11 @@ func newClient(operatorCUE *cuemodule.OperatorCUE, mesh *v1alpha1.Mesh, sync *gi
 			case <-ctx.Done():
 				return
 			default:
-				if _, err := (Cmd{
+				if _, err := client.exec(ctx, apiControl, Cmd{
 					// Create a NOOP shared_rules object to ensure that we can write to Control.
 					// Using `greymatter create` is required because `greymatter apply` does not exit with an error code on failed actions.
 					args: fmt.Sprintf("create sharedrules --zone-key %s --shared-rules-key %s --name %s", mesh.Spec.Zone, srKey, srKey),
-				}).run(client.flags); err != nil {
+				}); err != nil {
 					logger.Info("Waiting to connect to Control API", "Mesh", mesh.Name, "Issue", err)
 					time.Sleep(time.Second * 10)
 					continue PING_CONTROL_LOOP
@@ -70,31 +149,16 @@ func newClient(operatorCUE *cuemodule.OperatorCUE, mesh *v1alpha1.Mesh, sync *gi
 		}
 
 		// Then consume additional commands for control objects
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case c := <-controlCmds:
-				// Requeue failed commands, since there are likely object dependencies (TODO: check)
-				if response, err := c.run(client.flags); err != nil && c.requeue {
-					logger.Info("command failed, will reattempt in 10 seconds", "args", c.args, "error", err, "response", response)
-					go func(args string) {
-						time.Sleep(10 * time.Second)
-						select {
-						case <-ctx.Done():
-							return
-						default:
-							logger.Info("requeuing failed command", "args", args)
-							controlCmds <- c
-						}
-					}(c.args)
-				}
-			}
-		}
-	}(client.Ctx, client.ControlCmds)
+		client.consume(ctx, apiControl)
+	}(client.Ctx)
 
 	// Consumer of commands to send to Catalog
-	go func(ctx context.Context, catalogCmds chan Cmd) {
+	go func(ctx context.Context) {
+		if client.catalogDisabled {
+			logger.Info("Catalog is disabled - dropping commands for it", "Mesh", mesh.Name)
+			client.discard(ctx, apiCatalog)
+			return
+		}
 		start := time.Now()
 
 		// Ping Catalog every 5s until responsive (getting the Mesh's session status with Control).
@@ -104,9 +168,9 @@ func newClient(operatorCUE *cuemodule.OperatorCUE, mesh *v1alpha1.Mesh, sync *gi
 			case <-ctx.Done():
 				return
 			default:
-				if _, err := (Cmd{
+				if _, err := client.exec(ctx, apiCatalog, Cmd{
 					args: fmt.Sprintf("get catalogmesh --mesh-id %s", mesh.Name),
-				}).run(client.flags); err != nil {
+				}); err != nil {
 					logger.Info("Waiting to connect to Catalog API", "Mesh", mesh.Name, "Issue", err)
 					time.Sleep(time.Second * 10)
 					continue PING_CATALOG_LOOP
@@ -118,31 +182,177 @@ func newClient(operatorCUE *cuemodule.OperatorCUE, mesh *v1alpha1.Mesh, sync *gi
 			}
 		}
 
-		// Then consume additional commands for catalog objects
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case c := <-catalogCmds:
-				// Requeue failed commands, since there are likely object dependencies (TODO: check)
-				if response, err := c.run(client.flags); err != nil && c.requeue {
-					logger.Info("command failed, will reattempt in 10 seconds", "args", c.args, "error", err, "response", response)
-					go func(args string) {
-						time.Sleep(10 * time.Second)
-						select {
-						case <-ctx.Done():
-							return
-						default:
-							logger.Info("requeuing failed command", "args", args)
-							catalogCmds <- c
-						}
-					}(c.args)
-				}
+		// Then consume additional commands for catalog objects, including registrations not yet made
+		go client.retryCatalogRegistrations(ctx)
+		client.consume(ctx, apiCatalog)
+	}(client.Ctx)
+
+	return client, nil
+}
+
+// exec runs a command against the given API within the client's command timeout, and records its outcome in metrics.
+// If the API rejects the client's session token, it logs in again and retries the command once.
+func (client *Client) exec(ctx context.Context, api string, c Cmd) (string, error) {
+	if api == apiCatalog && client.catalogDisabled {
+		return errCatalogDisabled.Error(), errCatalogDisabled
+	}
+	response, err := client.execOnce(ctx, api, c)
+	if err != nil && client.session != nil && ctx.Err() == nil && unauthorized(response) {
+		logger.Info("Session token rejected, logging in again", "api", api)
+		client.session.invalidate()
+		commandRetries.WithLabelValues(api, "unauthorized").Inc()
+		response, err = client.execOnce(ctx, api, c)
+	}
+	return response, err
+}
+
+func (client *Client) execOnce(ctx context.Context, api string, c Cmd) (string, error) {
+	cmdCtx, cancel := context.WithTimeout(ctx, client.policy.timeout)
+	defer cancel()
+
+	flags := client.flags
+	if zoneFlags, ok := client.zoneFlags[c.zone]; ok {
+		flags = zoneFlags
+	}
+	if client.session != nil {
+		token, err := client.session.token()
+		if err != nil {
+			commandsTotal.WithLabelValues(api, result(err)).Inc()
+			return err.Error(), err
+		}
+		flags = withSessionToken(flags, token)
+	}
+
+	start := time.Now()
+	response, err := c.run(cmdCtx, client.run, flags, client.env)
+	commandDuration.WithLabelValues(api).Observe(time.Since(start).Seconds())
+	commandsTotal.WithLabelValues(api, result(err)).Inc()
+	return response, err
+}
+
+// List returns the objects of the given kind currently in Control, or in Catalog for catalogservice objects.
+func (client *Client) List(ctx context.Context, kind string) ([]json.RawMessage, error) {
+	api, args := apiControl, fmt.Sprintf("list %s", kind)
+	if kind == "catalogservice" {
+		api, args = apiCatalog, fmt.Sprintf("list catalogservice --mesh-id %s", client.mesh)
+	}
+	response, err := client.exec(ctx, api, Cmd{args: args})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s objects: %w", kind, err)
+	}
+	var objects []json.RawMessage
+	if err := json.Unmarshal([]byte(response), &objects); err != nil {
+		return nil, fmt.Errorf("unexpected output listing %s objects: %w", kind, err)
+	}
+	return objects, nil
+}
+
+// consume runs the API's queued commands until ctx is done, no faster than the client's rate limit.
+// Timed-out commands are retried up to the client's retry limit, and other failed commands are requeued if they ask to be.
+func (client *Client) consume(ctx context.Context, api string) {
+	queue := client.queues[api]
+	atomic.StoreInt32(&queue.consuming, 1)
+	limiter := rate.NewLimiter(client.policy.limit, client.policy.burst)
+	for {
+		if err := limiter.Wait(ctx); err != nil {
+			return // ctx is done
+		}
+		qc, cmdCtx, ok := queue.next(ctx)
+		if !ok {
+			return
+		}
+		queueDepth.WithLabelValues(api).Set(float64(queue.depth()))
+		response, err := client.exec(cmdCtx, api, qc.cmd)
+		if queue.done(qc, err) {
+			logger.Info("command cancelled", "args", qc.cmd.args)
+			continue
+		}
+		if err == nil || ctx.Err() != nil {
+			continue
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			if qc.cmd.timeouts >= client.policy.maxRetries {
+				logger.Error(err, "command timed out too many times, giving up", "args", qc.cmd.args, "timeout", client.policy.timeout, "attempts", qc.cmd.timeouts+1)
+				continue
 			}
+			qc.cmd.timeouts++
+			logger.Info("command timed out, will reattempt in 10 seconds", "args", qc.cmd.args, "timeout", client.policy.timeout, "attempt", qc.cmd.timeouts)
+			commandRetries.WithLabelValues(api, "timeout").Inc()
+		} else if qc.cmd.requeue {
+			// Requeue failed commands, since there are likely object dependencies (TODO: check)
+			logger.Info("command failed, will reattempt in 10 seconds", "args", qc.cmd.args, "error", err, "response", response)
+			commandRetries.WithLabelValues(api, "error").Inc()
+		} else {
+			continue
 		}
-	}(client.Ctx, client.CatalogCmds)
+		queue.retry(ctx, qc, requeueDelay)
+	}
+}
 
-	return client, nil
+// discard drops the commands queued for an API without sending them, until ctx is done.
+func (client *Client) discard(ctx context.Context, api string) {
+	queue := client.queues[api]
+	atomic.StoreInt32(&queue.consuming, 1)
+	for {
+		qc, _, ok := queue.next(ctx)
+		if !ok {
+			return
+		}
+		queue.done(qc, nil)
+		queueDepth.WithLabelValues(api).Set(float64(queue.depth()))
+	}
+}
+
+// Close stops the client once the commands queued for Control, and then those for Catalog, have been sent, so the
+// Catalog registrations referring to objects in Control go last, or once ctx is done, leaving the rest unsent.
+// Queues of an API the client hasn't connected to aren't waited for.
+func (client *Client) Close(ctx context.Context) error {
+	defer client.Cancel()
+	for _, api := range []string{apiControl, apiCatalog} {
+		cmds := client.ControlCmds
+		if api == apiCatalog {
+			cmds = client.CatalogCmds
+		}
+		if err := client.queues[api].settle(ctx, cmds); err != nil {
+			return fmt.Errorf("mesh %s: %s: %w", client.mesh, api, err)
+		}
+	}
+	return nil
+}
+
+// Queue describes the commands that haven't yet been sent to Control and Catalog successfully.
+func (client *Client) Queue() []QueuedCommand {
+	now := time.Now()
+	var cmds []QueuedCommand
+	for _, api := range []string{apiControl, apiCatalog} {
+		if queue, ok := client.queues[api]; ok {
+			cmds = append(cmds, queue.snapshot(client.mesh, api, now)...)
+		}
+	}
+	return cmds
+}
+
+// FlushQueue drops the commands waiting to be sent to the given API, or to both if api is empty, and returns how
+// many were dropped. Commands in flight are left to finish.
+func (client *Client) FlushQueue(api string) int {
+	flushed := 0
+	for name, queue := range client.queues {
+		if api == "" || api == name {
+			flushed += queue.flush()
+		}
+	}
+	return flushed
+}
+
+// CancelCommand drops the queued command with the given ID, interrupting it if it's in flight. It returns false if
+// the client has no such command.
+func (client *Client) CancelCommand(id uint64) bool {
+	for _, queue := range client.queues {
+		if queue.cancel(id) {
+			return true
+		}
+	}
+	return false
 }
 
 func ApplyCoreMeshConfigs(client *Client, operatorCUE *cuemodule.OperatorCUE) {
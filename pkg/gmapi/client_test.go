@@ -1,7 +1,15 @@
 package gmapi
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"testing"
+	"time"
+
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
 )
 
 //func TestNewClient(t *testing.T) {
@@ -72,3 +80,27 @@ func TestCLIVersion(t *testing.T) {
 	}
 	t.Log(v)
 }
+
+func TestNewCommandPolicy(t *testing.T) {
+	policy := newCommandPolicy(cuemodule.Config{})
+	assert.Equal(t, defaultCommandTimeout, policy.timeout)
+	assert.Equal(t, defaultCommandTimeoutRetries, policy.maxRetries)
+
+	policy = newCommandPolicy(cuemodule.Config{CommandTimeoutSeconds: 5, CommandTimeoutRetries: 1})
+	assert.Equal(t, 5*time.Second, policy.timeout)
+	assert.Equal(t, 1, policy.maxRetries)
+	assert.Equal(t, rate.Inf, policy.limit)
+
+	policy = newCommandPolicy(cuemodule.Config{APIRateLimit: 2.5})
+	assert.Equal(t, rate.Limit(2.5), policy.limit)
+	assert.Equal(t, 3, policy.burst)
+
+	policy = newCommandPolicy(cuemodule.Config{APIRateLimit: 20, APIRateBurst: 100})
+	assert.Equal(t, 100, policy.burst)
+}
+
+func TestCommandResult(t *testing.T) {
+	assert.Equal(t, "success", result(nil))
+	assert.Equal(t, "error", result(errors.New("no such zone")))
+	assert.Equal(t, "timeout", result(fmt.Errorf("command interrupted: %w", context.DeadlineExceeded)))
+}
@@ -2,41 +2,69 @@ package gmapi
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"os"
 	"os/exec"
 	"strings"
+
+	"github.com/greymatter-io/operator/pkg/gitops"
 )
 
 type Cmd struct {
 	args  string
 	stdin json.RawMessage
+	// The zone of the object the Cmd acts on, for routing it to the zone's Control API.
+	zone string
 	// Notifies the caller to requeue the Cmd if it fails.
 	requeue bool
 	// A custom logger; if not set, nothing is logged.
 	log func(string, error)
+	// If set, receives the final error (or nil) of each run, e.g. for recording in a sync report.
+	report func(error)
 	// If set, modifies the output before it is returned.
 	modify func([]byte) ([]byte, error)
 	// If set, is run with the stdout of a successful parent Cmd piped in.
 	then *Cmd
+	// How many times the Cmd has timed out, for limiting retries.
+	timeouts int
+	// The Catalog registration the Cmd makes, if any.
+	registration *gitops.CatalogRegistration
 }
 
-func (c Cmd) run(flags []string) (string, error) {
-	args := strings.Split(c.args, " ")
-	if len(flags) > 0 {
-		args = append(flags, args...)
+// runFunc runs a greymatter CLI command with the given args and stdin, and returns its combined output.
+type runFunc func(ctx context.Context, args []string, stdin []byte, env []string) ([]byte, error)
+
+// execCLI runs the greymatter CLI installed alongside the operator, killing it if ctx is done first.
+// The CLI inherits the operator's environment, plus any given variables.
+func execCLI(ctx context.Context, args []string, stdin []byte, env []string) ([]byte, error) {
+	command := exec.CommandContext(ctx, "greymatter", args...)
+	if len(env) > 0 {
+		command.Env = append(os.Environ(), env...)
+	}
+	if len(stdin) > 0 {
+		command.Stdin = bytes.NewReader(stdin)
 	}
+	return command.CombinedOutput()
+}
 
-	command := exec.Command("greymatter", args...)
-	if len(c.stdin) > 0 {
-		command.Stdin = bytes.NewReader(c.stdin)
+// run executes the Cmd (and any Cmd chained after it) with the given runFunc.
+func (c Cmd) run(ctx context.Context, run runFunc, flags, env []string) (string, error) {
+	args := strings.Split(c.args, " ")
+	if len(flags) > 0 {
+		args = append(append([]string(nil), flags...), args...)
 	}
 
-	out, err := command.CombinedOutput()
+	out, err := run(ctx, args, c.stdin, env)
 	outStr := string(out)
 
-	// If err is a bad exit code, capture stderr as the error.
-	if err != nil {
+	// If the context ended the command, say so, so it can be retried as a timeout.
+	// Otherwise if err is a bad exit code, capture stderr as the error.
+	if err != nil && ctx.Err() != nil {
+		err = fmt.Errorf("command interrupted: %w", ctx.Err())
+		outStr = err.Error()
+	} else if err != nil {
 		err = fmt.Errorf(outStr)
 	}
 
@@ -55,7 +83,7 @@ func (c Cmd) run(flags []string) (string, error) {
 		// If Cmd.then is defined, run it next.
 		if err == nil && c.then != nil {
 			c.then.stdin = out
-			return c.then.run(flags)
+			return c.then.run(ctx, run, flags, env)
 		}
 	}
 
@@ -63,12 +91,15 @@ func (c Cmd) run(flags []string) (string, error) {
 	if c.log != nil {
 		c.log(outStr, err)
 	}
+	if c.report != nil {
+		c.report(err)
+	}
 
 	return outStr, err
 }
 
 func cliversion() (string, error) {
-	output, err := (Cmd{args: "--version"}).run(nil)
+	output, err := (Cmd{args: "--version"}).run(context.Background(), execCLI, nil, nil)
 	if err != nil {
 		return "", err
 	}
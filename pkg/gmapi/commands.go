@@ -3,14 +3,17 @@ package gmapi
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/greymatter-io/operator/pkg/cuemodule"
 	"github.com/greymatter-io/operator/pkg/gitops"
 	"github.com/tidwall/gjson"
+	"sort"
 )
 
 func MkApply(kind string, data json.RawMessage) Cmd {
 	key := objKey(kind, data)
 	return Cmd{
 		args:    fmt.Sprintf("apply -t %s -f -", kind),
+		zone:    objZone(kind, data),
 		requeue: true,
 		stdin:   data,
 		log: func(out string, err error) {
@@ -23,22 +26,35 @@ func MkApply(kind string, data json.RawMessage) Cmd {
 	}
 }
 
+// ApplyAll applies the given objects in dependency order, e.g. domains and clusters before the routes that reference them.
 func ApplyAll(client *Client, objects []json.RawMessage, kinds []string) {
+	objects, kinds = cuemodule.OrderGMConfigObjects(objects, kinds, false)
+	report := client.sync.CurrentReport()
 	for i, kind := range kinds {
-		if kind == "catalogservice" { // Catalog is special, because it goes on a different channel
-			client.CatalogCmds <- MkApply(kind, objects[i])
-		} else if kind != "" { // Everything else goes to Control
-			client.ControlCmds <- MkApply(kind, objects[i])
-		} else {
+		if kind == "" {
 			// TODO explode
 			logger.Error(nil, "Loaded unexpected object, not recognizable as Grey Matter config", "Object", string(objects[i]))
+			continue
+		}
+		key := objKey(kind, objects[i])
+		reportFunc := mkReportFunc(report, kind, objScope(kind, objects[i]), key, "apply")
+		if kind == "catalogservice" { // Catalog is special, because it goes on a different channel
+			reg := gitops.CatalogRegistration{Mesh: client.mesh, Kind: kind, Key: key, Object: objects[i]}
+			client.CatalogCmds <- client.queueCatalogRegistration(reg, reportFunc)
+		} else { // Everything else goes to Control
+			cmd := MkApply(kind, objects[i])
+			cmd.report = reportFunc
+			client.ControlCmds <- cmd
 		}
 	}
 }
 
+// UnApplyAll deletes the given objects in reverse dependency order, so nothing is left referencing a deleted object.
 func UnApplyAll(client *Client, objects []json.RawMessage, kinds []string) {
+	objects, kinds = cuemodule.OrderGMConfigObjects(objects, kinds, true)
 	for i, kind := range kinds {
 		if kind == "catalogservice" { // Catalog is special, because it goes on a different channel
+			client.dropCatalogRegistrations(kind, objKey(kind, objects[i]))
 			client.CatalogCmds <- mkDelete(kind, objects[i])
 		} else if kind != "" { // Everything else goes to Control
 			client.ControlCmds <- mkDelete(kind, objects[i])
@@ -49,12 +65,21 @@ func UnApplyAll(client *Client, objects []json.RawMessage, kinds []string) {
 	}
 }
 
+// DeleteAllByGMObjectRefs deletes the referenced objects in reverse dependency order.
 func DeleteAllByGMObjectRefs(client *Client, objectsToDelete []gitops.GMObjectRef) {
+	objectsToDelete = append([]gitops.GMObjectRef(nil), objectsToDelete...)
+	sort.SliceStable(objectsToDelete, func(a, b int) bool {
+		return cuemodule.GMKindRank(objectsToDelete[a].Kind) > cuemodule.GMKindRank(objectsToDelete[b].Kind)
+	})
+	report := client.sync.CurrentReport()
 	for _, objRef := range objectsToDelete {
+		cmd := mkDeleteByGMObjectRef(objRef)
+		cmd.report = mkReportFunc(report, objRef.Kind, objRef.Zone, objRef.ID, "delete")
 		if objRef.Kind == "catalogservice" { // Catalog is special, because it goes on a different channel
-			client.CatalogCmds <- mkDeleteByGMObjectRef(objRef)
+			client.dropCatalogRegistrations(objRef.Kind, objRef.ID)
+			client.CatalogCmds <- cmd
 		} else if objRef.Kind != "" { // Everything else goes to Control
-			client.ControlCmds <- mkDeleteByGMObjectRef(objRef)
+			client.ControlCmds <- cmd
 		} else {
 			// TODO explode
 			logger.Error(nil, "Loaded unexpected object, not recognizable as Grey Matter config - ignoring", "ref", objRef)
@@ -68,8 +93,13 @@ func mkDeleteByGMObjectRef(objRef gitops.GMObjectRef) Cmd {
 		// In a catalogservice object, we interpret the zone as the mesh ID
 		args += fmt.Sprintf(" --mesh-id %s", objRef.Zone)
 	}
+	zone := objRef.Zone
+	if objRef.Kind == "catalogservice" {
+		zone = ""
+	}
 	return Cmd{
 		args: args,
+		zone: zone,
 		log: func(out string, err error) {
 			if err != nil {
 				logger.Error(fmt.Errorf(out), "failed delete", "type", objRef.Kind, "key", objRef.ID)
@@ -93,6 +123,7 @@ func mkDelete(kind string, data json.RawMessage) Cmd {
 	}
 	return Cmd{
 		args: args,
+		zone: objZone(kind, data),
 		log: func(out string, err error) {
 			if err != nil {
 				logger.Error(fmt.Errorf(out), "failed delete", "type", kind, "key", key)
@@ -103,6 +134,37 @@ func mkDelete(kind string, data json.RawMessage) Cmd {
 	}
 }
 
+// mkReportFunc returns a Cmd.report hook that records the command's outcome in a sync report.
+func mkReportFunc(report *gitops.SyncReport, kind, scope, key, action string) func(error) {
+	if report == nil {
+		return nil
+	}
+	return func(err error) {
+		report.Record("gm", kind, scope, key, action, err)
+	}
+}
+
+// objScope returns the zone of a GM object, or the mesh ID of a catalogservice.
+func objScope(kind string, data json.RawMessage) string {
+	if kind == "catalogservice" {
+		return gjson.GetBytes(data, "mesh_id").String()
+	}
+	return gjson.GetBytes(data, "zone_key").String()
+}
+
+// objZone returns the zone of a GM object in Control, or "" for a catalogservice (which lives in Catalog).
+func objZone(kind string, data json.RawMessage) string {
+	if kind == "catalogservice" {
+		return ""
+	}
+	return objScope(kind, data)
+}
+
+// ObjectKey returns the key identifying a Grey Matter config object of the given kind.
+func ObjectKey(kind string, data json.RawMessage) string {
+	return objKey(kind, data)
+}
+
 func objKey(kind string, data json.RawMessage) string {
 	key := kindKey(kind)
 	value := gjson.Get(string(data), key)
@@ -0,0 +1,55 @@
+package gmapi
+
+import (
+	"context"
+	"errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// Labels for the API a command was sent to.
+const (
+	apiControl = "control"
+	apiCatalog = "catalog"
+)
+
+var (
+	commandsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gm_operator_gmapi_commands_total",
+		Help: "Number of greymatter CLI commands run, by API and result (success, error, or timeout).",
+	}, []string{"api", "result"})
+
+	commandDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gm_operator_gmapi_command_duration_seconds",
+		Help:    "Time taken by greymatter CLI commands, by API.",
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 12),
+	}, []string{"api"})
+
+	commandRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gm_operator_gmapi_command_retries_total",
+		Help: "Number of greymatter CLI commands requeued for another attempt, by API and reason (error, timeout, unauthorized, or registration for Catalog registrations not yet made).",
+	}, []string{"api", "reason"})
+
+	queueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gm_operator_gmapi_queue_depth",
+		Help: "Number of greymatter CLI commands waiting to be sent, by API.",
+	}, []string{"api"})
+)
+
+func init() {
+	// Served by the manager's metrics endpoint
+	metrics.Registry.MustRegister(commandsTotal, commandDuration, commandRetries, queueDepth)
+}
+
+// result returns the metrics label for a command's outcome.
+func result(err error) string {
+	switch {
+	case err == nil:
+		return "success"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	default:
+		return "error"
+	}
+}
@@ -0,0 +1,309 @@
+package gmapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	"github.com/tidwall/gjson"
+)
+
+// MockAPI is an in-memory stand-in for a mesh's Control and Catalog APIs, so the operator's Grey Matter config
+// can be applied end-to-end in tests and local development without a Grey Matter control plane.
+// Its servers store objects of any kind by key, with a simple REST API rather than Control's and Catalog's own:
+// GET /{kind} lists objects (of a mesh's catalogservices with ?mesh_id=), POST /{kind} creates one, and
+// GET, PUT, and DELETE /{kind}/{key} get, apply, and delete one. Objects aren't validated, nor are their
+// references to each other checked.
+type MockAPI struct {
+	// Serves catalogmesh and catalogservice objects.
+	Catalog *httptest.Server
+	// Serves all other kinds.
+	Control *httptest.Server
+
+	mu      sync.Mutex
+	objects map[string]map[string]json.RawMessage
+}
+
+// NewMockAPI starts the servers of an empty MockAPI.
+func NewMockAPI() *MockAPI {
+	m := &MockAPI{objects: make(map[string]map[string]json.RawMessage)}
+	m.Control = httptest.NewServer(http.HandlerFunc(m.serve))
+	m.Catalog = httptest.NewServer(http.HandlerFunc(m.serve))
+	return m
+}
+
+// Close shuts down the servers.
+func (m *MockAPI) Close() {
+	m.Control.Close()
+	m.Catalog.Close()
+}
+
+// NewCLI returns a new *CLI like New, whose mesh clients send commands to the MockAPI's servers in-process
+// instead of running the greymatter CLI against the mesh's own APIs.
+func (m *MockAPI) NewCLI(ctx context.Context, operatorCUE *cuemodule.OperatorCUE) *CLI {
+	gmcli := NewUnchecked(ctx, operatorCUE)
+	gmcli.run = runMock
+	gmcli.controlAPI, gmcli.catalogAPI = m.Control.URL, m.Catalog.URL
+	return gmcli
+}
+
+// Object returns the stored object of the given kind with the given key.
+func (m *MockAPI) Object(kind, key string) (json.RawMessage, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	obj, ok := m.objects[kind][key]
+	return obj, ok
+}
+
+// Keys returns the sorted keys of the stored objects of the given kind.
+func (m *MockAPI) Keys(kind string) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := make([]string, 0, len(m.objects[kind]))
+	for key := range m.objects[kind] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (m *MockAPI) serve(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.Trim(r.URL.Path, "/"), "/", 2)
+	kind := parts[0]
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.objects[kind] == nil {
+		m.objects[kind] = make(map[string]json.RawMessage)
+	}
+	objects := m.objects[kind]
+
+	if len(parts) == 1 {
+		switch r.Method {
+		case http.MethodGet:
+			keys := make([]string, 0, len(objects))
+			for key, obj := range objects {
+				if meshID := r.URL.Query().Get("mesh_id"); meshID == "" || gjson.GetBytes(obj, "mesh_id").String() == meshID {
+					keys = append(keys, key)
+				}
+			}
+			sort.Strings(keys)
+			list := make([]json.RawMessage, 0, len(keys))
+			for _, key := range keys {
+				list = append(list, objects[key])
+			}
+			writeJSON(w, list)
+		case http.MethodPost:
+			key := gjson.GetBytes(body, mockKeyField(kind)).String()
+			if !json.Valid(body) || key == "" {
+				http.Error(w, fmt.Sprintf("invalid %s: %s is required", kind, mockKeyField(kind)), http.StatusBadRequest)
+				return
+			}
+			if _, ok := objects[key]; ok {
+				http.Error(w, fmt.Sprintf("%s %s already exists", kind, key), http.StatusConflict)
+				return
+			}
+			objects[key] = body
+			writeJSON(w, json.RawMessage(body))
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	key := parts[1]
+	obj, ok := objects[key]
+	switch r.Method {
+	case http.MethodGet:
+		if !ok && kind == "catalogmesh" {
+			// As if Catalog were configured for every mesh, one it hasn't stored has no sessions yet
+			obj, ok = json.RawMessage(fmt.Sprintf(`{"mesh_id":%q,"sessions":{}}`, key)), true
+		}
+		if !ok {
+			http.Error(w, fmt.Sprintf("%s %s not found", kind, key), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, obj)
+	case http.MethodPut:
+		if !json.Valid(body) {
+			http.Error(w, fmt.Sprintf("invalid %s", kind), http.StatusBadRequest)
+			return
+		}
+		objects[key] = body
+		writeJSON(w, json.RawMessage(body))
+	case http.MethodDelete:
+		if !ok {
+			http.Error(w, fmt.Sprintf("%s %s not found", kind, key), http.StatusNotFound)
+			return
+		}
+		delete(objects, key)
+		writeJSON(w, obj)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// mockKeyField returns the field holding the key of an object of the given kind.
+func mockKeyField(kind string) string {
+	switch kind {
+	case "catalogmesh":
+		return "mesh_id"
+	case "sharedrules":
+		return "shared_rules_key"
+	}
+	return kindKey(kind)
+}
+
+// cliCommand is a greymatter CLI command sent by the operator, parsed.
+type cliCommand struct {
+	// From the command's --base64-config flag, if any
+	controlAPI, catalogAPI string
+	// e.g. apply, create, get, delete, or list
+	verb, kind string
+	// Without their dashes
+	flags map[string]string
+}
+
+// parseCommand parses the args of a greymatter CLI command sent by the operator.
+func parseCommand(args []string) (cliCommand, bool) {
+	var cmd cliCommand
+	if len(args) >= 2 && args[0] == "--base64-config" {
+		cmd.controlAPI, cmd.catalogAPI = parseCLIConfig(args[1])
+		args = args[2:]
+	}
+	if len(args) < 2 {
+		return cmd, false
+	}
+	// e.g. `delete cluster --cluster-key edge` or `apply -t cluster -f -`
+	cmd.verb = args[0]
+	rest := args[1:]
+	if !strings.HasPrefix(rest[0], "-") {
+		cmd.kind, rest = rest[0], rest[1:]
+	}
+	cmd.flags = make(map[string]string)
+	for i := 0; i+1 < len(rest); i += 2 {
+		cmd.flags[strings.TrimLeft(rest[i], "-")] = rest[i+1]
+	}
+	if cmd.verb == "apply" {
+		cmd.kind = cmd.flags["t"]
+	}
+	return cmd, cmd.kind != ""
+}
+
+// key returns the key of the object a get or delete command acts on.
+func (cmd cliCommand) key() string {
+	return cmd.flags[strings.ReplaceAll(mockKeyField(cmd.kind), "_", "-")]
+}
+
+// created returns the object a create command creates from its flags.
+func (cmd cliCommand) created() []byte {
+	obj := make(map[string]string)
+	for flag, value := range cmd.flags {
+		obj[strings.ReplaceAll(flag, "-", "_")] = value
+	}
+	b, _ := json.Marshal(obj)
+	return b
+}
+
+// runMock runs the greymatter CLI commands sent by the operator as requests to a MockAPI's servers,
+// found in the command's --base64-config flag.
+func runMock(ctx context.Context, args []string, stdin []byte, _ []string) ([]byte, error) {
+	cmd, ok := parseCommand(args)
+	if !ok {
+		return unsupported(args)
+	}
+	kind := cmd.kind
+	api := cmd.controlAPI
+	if strings.HasPrefix(kind, "catalog") {
+		api = cmd.catalogAPI
+	}
+	var method, path string
+	var body []byte
+	switch cmd.verb {
+	case "apply":
+		method, path, body = http.MethodPut, fmt.Sprintf("/%s/%s", kind, gjson.GetBytes(stdin, mockKeyField(kind)).String()), stdin
+	case "create":
+		method, path, body = http.MethodPost, "/"+kind, cmd.created()
+	case "get":
+		method, path = http.MethodGet, fmt.Sprintf("/%s/%s", kind, cmd.key())
+	case "delete":
+		method, path = http.MethodDelete, fmt.Sprintf("/%s/%s", kind, cmd.key())
+	case "list":
+		method, path = http.MethodGet, "/"+kind
+		if meshID := cmd.flags["mesh-id"]; meshID != "" {
+			path += "?mesh_id=" + meshID
+		}
+	default:
+		return unsupported(args)
+	}
+	if api == "" {
+		return unsupported(args)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, api+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return []byte(err.Error()), err
+	}
+	defer resp.Body.Close()
+	out, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return out, fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	return out, nil
+}
+
+// unsupported fails a command runMock doesn't implement, with the error as its output like the CLI's.
+func unsupported(args []string) ([]byte, error) {
+	err := fmt.Errorf("unsupported command: %s", strings.Join(args, " "))
+	return []byte(err.Error()), err
+}
+
+// parseCLIConfig returns the Control and Catalog API URLs in a base64-encoded config from mkCLIConfig.
+func parseCLIConfig(conf string) (controlAPI, catalogAPI string) {
+	b, _ := base64.StdEncoding.DecodeString(conf)
+	section := ""
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			section = strings.Trim(line, "[]")
+			continue
+		}
+		if !strings.HasPrefix(line, "url") {
+			continue
+		}
+		url := strings.Trim(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(strings.TrimPrefix(line, "url")), "=")), `"`)
+		switch section {
+		case "api":
+			controlAPI = url
+		case "catalog":
+			catalogAPI = url
+		}
+	}
+	return
+}
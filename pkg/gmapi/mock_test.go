@@ -0,0 +1,178 @@
+package gmapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	"github.com/greymatter-io/operator/pkg/gitops"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMockAPI(t *testing.T) {
+	root := t.TempDir()
+	for path, contents := range map[string]string{
+		"cue.mod/module.cue":      `module: "greymatter.io/operator/test"`,
+		"k8s/outputs/outputs.cue": "package outputs\n\nmesh: {...}\n",
+		"gm/outputs/outputs.cue": `package outputs
+
+mesh_configs: [
+	{cluster_key: "edge", zone_key: "default-zone", name: "edge"},
+	{domain_key: "edge", zone_key: "default-zone", port: 10808},
+	{service_id: "edge", mesh_id: "mesh", name: "Edge"},
+]
+`,
+	} {
+		path = filepath.Join(root, path)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, os.WriteFile(path, []byte(contents), 0600))
+	}
+	operatorCUE, _, err := cuemodule.LoadAll(root)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sync := gitops.New("", ctx, cancel)
+	sync.SyncState = gitops.NewSyncState(ctx, cuemodule.Defaults{RedisHost: "127.0.0.1", RedisPort: 1}, "")
+	report := sync.BeginReport("")
+
+	m := NewMockAPI()
+	defer m.Close()
+	gmcli := m.NewCLI(ctx, operatorCUE)
+	gmcli.ConfigureMeshClient(operatorCUE, &v1alpha1.Mesh{
+		ObjectMeta: metav1.ObjectMeta{Name: "mesh"},
+		Spec: v1alpha1.MeshSpec{
+			InstallNamespace: "greymatter",
+			Zone:             "default-zone",
+			Zones:            []v1alpha1.Zone{{Name: "west"}},
+		},
+	}, sync)
+
+	// The core config, additional zones, and Catalog sessions for them are applied once both APIs respond
+	assert.Eventually(t, func() bool {
+		_, applied, _, _ := report.Summary()
+		return applied == 0 && len(m.Keys("catalogservice")) == 1 && len(m.Keys("zone")) == 1 && len(m.Keys("catalogmesh")) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"edge"}, m.Keys("cluster"))
+	assert.Equal(t, []string{"edge"}, m.Keys("domain"))
+	assert.Len(t, m.Keys("sharedrules"), 1, "Control is written to before commands are sent to it")
+	catalogMesh, _ := m.Object("catalogmesh", "mesh")
+	assert.Equal(t, "west", gjson.GetBytes(catalogMesh, "sessions.west.zone").String())
+	assert.Equal(t, "controlensemble.greymatter.svc.cluster.local:50000", gjson.GetBytes(catalogMesh, "sessions.west.url").String())
+	assert.Eventually(t, func() bool {
+		applied, _, failed, _ := report.Summary()
+		return applied == 3 && failed == 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	clusters, err := gmcli.Client.List(ctx, "cluster")
+	assert.NoError(t, err)
+	assert.Len(t, clusters, 1)
+	services, err := gmcli.Client.List(ctx, "catalogservice")
+	assert.NoError(t, err)
+	assert.Len(t, services, 1)
+
+	objects, kinds, err := operatorCUE.ExtractCoreMeshConfigs()
+	assert.NoError(t, err)
+	UnApplyAll(gmcli.Client, objects, kinds)
+	assert.Eventually(t, func() bool {
+		return len(m.Keys("cluster"))+len(m.Keys("domain"))+len(m.Keys("catalogservice")) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Reconfiguring the mesh keeps its client unless its API endpoints change. Each client is given its own sync
+	// state, since its core config is applied concurrently.
+	newSync := func() *gitops.Sync {
+		sync := gitops.New("", ctx, cancel)
+		sync.SyncState = gitops.NewSyncState(ctx, cuemodule.Defaults{RedisHost: "127.0.0.1", RedisPort: 1}, "")
+		return sync
+	}
+	mesh := &v1alpha1.Mesh{
+		ObjectMeta: metav1.ObjectMeta{Name: "mesh"},
+		Spec: v1alpha1.MeshSpec{
+			InstallNamespace: "greymatter",
+			Zone:             "default-zone",
+			Zones:            []v1alpha1.Zone{{Name: "west"}},
+		},
+	}
+	first := gmcli.Client
+	gmcli.ConfigureMeshClient(operatorCUE, mesh, sync)
+	assert.Same(t, first, gmcli.Client)
+	assert.NoError(t, first.Ctx.Err())
+	mesh.Spec.Zones = append(mesh.Spec.Zones, v1alpha1.Zone{Name: "east", APIURL: "http://control.east:5555"})
+	gmcli.ConfigureMeshClient(operatorCUE, mesh, newSync())
+	assert.NotSame(t, first, gmcli.Client)
+	assert.Error(t, first.Ctx.Err())
+
+	// As does disabling Catalog, after which commands for it are dropped
+	second := gmcli.Client
+	mesh.Spec.DisabledComponents = []v1alpha1.CoreComponent{"catalog"}
+	gmcli.ConfigureMeshClient(operatorCUE, mesh, newSync())
+	assert.NotSame(t, second, gmcli.Client)
+	assert.Eventually(t, func() bool {
+		for _, cmd := range gmcli.Client.Queue() {
+			if cmd.API == apiCatalog {
+				return false
+			}
+		}
+		return len(m.Keys("cluster")) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Empty(t, m.Keys("catalogservice"))
+	_, err = gmcli.Client.List(ctx, "catalogservice")
+	assert.ErrorIs(t, err, errCatalogDisabled)
+	assert.NoError(t, gmcli.Client.DeleteCatalogMesh(ctx))
+
+	// Each mesh has its own client, and removing one leaves the others
+	gmcli.ConfigureMeshClient(operatorCUE, &v1alpha1.Mesh{
+		ObjectMeta: metav1.ObjectMeta{Name: "other"},
+		Spec:       v1alpha1.MeshSpec{InstallNamespace: "other", Zone: "default-zone"},
+	}, newSync())
+	assert.Equal(t, []string{"mesh", "other"}, gmcli.MeshClients())
+	other, ok := gmcli.MeshClient("other")
+	assert.True(t, ok)
+	assert.Same(t, other, gmcli.Client)
+	meshClient, _ := gmcli.MeshClient("mesh")
+	gmcli.RemoveMeshClient("mesh")
+	assert.Equal(t, []string{"other"}, gmcli.MeshClients())
+	assert.Error(t, meshClient.Ctx.Err())
+	assert.Same(t, other, gmcli.Client)
+	gmcli.RemoveMeshClient("other")
+	assert.Empty(t, gmcli.MeshClients())
+	assert.Nil(t, gmcli.Client)
+}
+
+func TestRunMock(t *testing.T) {
+	m := NewMockAPI()
+	defer m.Close()
+	flags := []string{"--base64-config", mkCLIConfig(m.Control.URL, m.Catalog.URL, "mesh", catalogAuthFiles{})}
+	run := func(args string, stdin string) (string, error) {
+		return Cmd{args: args, stdin: json.RawMessage(stdin)}.run(context.Background(), runMock, flags, nil)
+	}
+
+	_, err := run("apply -t listener -f -", `{"listener_key":"ingress","port":10808}`)
+	assert.NoError(t, err)
+	out, err := run("get listener --listener-key ingress", "")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10808), gjson.Get(out, "port").Int())
+
+	_, err = run("create sharedrules --zone-key default-zone --shared-rules-key probe --name probe", "")
+	assert.NoError(t, err)
+	_, err = run("create sharedrules --zone-key default-zone --shared-rules-key probe --name probe", "")
+	assert.Contains(t, fmt.Sprint(err), "already exists")
+
+	_, err = run("delete listener --listener-key ingress", "")
+	assert.NoError(t, err)
+	_, err = run("delete listener --listener-key ingress", "")
+	assert.Contains(t, fmt.Sprint(err), "not found")
+
+	_, err = run("edit listener ingress", "")
+	assert.Contains(t, fmt.Sprint(err), "unsupported command")
+}
@@ -0,0 +1,348 @@
+package gmapi
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// States of a QueuedCommand.
+const (
+	CommandPending  = "pending"
+	CommandInFlight = "in_flight"
+	CommandRetrying = "retrying"
+)
+
+// QueuedCommand describes a command that hasn't yet been sent to Control or Catalog successfully, for inspecting
+// commands that seem stuck.
+type QueuedCommand struct {
+	// Identifies the command for CancelCommand; unique across all meshes' clients.
+	ID   uint64 `json:"id"`
+	Mesh string `json:"mesh"`
+	// apiControl or apiCatalog
+	API string `json:"api"`
+	// CommandPending, CommandInFlight, or CommandRetrying (waiting out the delay before it's requeued)
+	State string `json:"state"`
+	Args  string `json:"args"`
+	// The kind and key of the object the command acts on, if known.
+	Kind string `json:"kind,omitempty"`
+	Key  string `json:"key,omitempty"`
+	// How many times the command has been attempted, and how many of those timed out.
+	Attempts int `json:"attempts"`
+	Timeouts int `json:"timeouts"`
+	// When the command was first queued, and how long ago that was.
+	Queued time.Time `json:"queued"`
+	Age    string    `json:"age"`
+	// The last error the command failed with, if any.
+	LastError string `json:"last_error,omitempty"`
+}
+
+// lastCmdID is the ID of the most recently queued command.
+var lastCmdID uint64
+
+// queuedCmd is a command in a cmdQueue.
+type queuedCmd struct {
+	id       uint64
+	cmd      Cmd
+	queued   time.Time
+	attempts int
+	lastErr  string
+	// Cancels the command while it's in flight.
+	cancel context.CancelFunc
+}
+
+// cmdQueue holds the commands for one API from when they're sent to the client's channel until they succeed or are
+// given up on, so they can be inspected, flushed, or cancelled.
+type cmdQueue struct {
+	mu       sync.Mutex
+	pending  []*queuedCmd
+	retrying map[uint64]*queuedCmd
+	inFlight *queuedCmd
+	// Signaled when commands are added, or room is made for them.
+	added, freed chan struct{}
+	// If set, called with each command that's flushed or cancelled.
+	dropped func(Cmd)
+	// Set once the API's consumer has connected and takes commands from the queue, so closing the client only waits
+	// for queues that will be emptied.
+	consuming int32
+}
+
+// How often a closing client checks whether its queues have been emptied.
+const settlePollInterval = 100 * time.Millisecond
+
+func newCmdQueue() *cmdQueue {
+	return &cmdQueue{
+		retrying: make(map[uint64]*queuedCmd),
+		added:    make(chan struct{}, 1),
+		freed:    make(chan struct{}, 1),
+	}
+}
+
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// fill moves commands from the channel to the queue until ctx is done. Once commandQueueSize commands are waiting,
+// it waits for room, so senders block as they would on a full channel.
+func (q *cmdQueue) fill(ctx context.Context, cmds chan Cmd) {
+	for {
+		q.mu.Lock()
+		full := len(q.pending)+len(q.retrying) >= commandQueueSize
+		q.mu.Unlock()
+		if full {
+			select {
+			case <-ctx.Done():
+				return
+			case <-q.freed:
+			}
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case c := <-cmds:
+			q.push(&queuedCmd{id: atomic.AddUint64(&lastCmdID, 1), cmd: c, queued: time.Now()})
+		}
+	}
+}
+
+func (q *cmdQueue) push(qc *queuedCmd) {
+	q.mu.Lock()
+	q.pending = append(q.pending, qc)
+	q.mu.Unlock()
+	signal(q.added)
+}
+
+// next waits for the oldest pending command and marks it in flight, cancellable by the returned context. It returns
+// false once ctx is done.
+func (q *cmdQueue) next(ctx context.Context) (*queuedCmd, context.Context, bool) {
+	for {
+		q.mu.Lock()
+		if len(q.pending) > 0 {
+			qc := q.pending[0]
+			q.pending = q.pending[1:]
+			cmdCtx, cancel := context.WithCancel(ctx)
+			qc.cancel = cancel
+			qc.attempts++
+			q.inFlight = qc
+			q.mu.Unlock()
+			signal(q.freed)
+			return qc, cmdCtx, true
+		}
+		q.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return nil, nil, false
+		case <-q.added:
+		}
+	}
+}
+
+// settle waits until no command is pending or in flight, counting those not yet moved from the channel, or until
+// ctx is done. It returns at once if nothing consumes the queue. Commands waiting to be retried aren't waited for.
+func (q *cmdQueue) settle(ctx context.Context, cmds chan Cmd) error {
+	ticker := time.NewTicker(settlePollInterval)
+	defer ticker.Stop()
+	for {
+		q.mu.Lock()
+		settled := len(q.pending) == 0 && q.inFlight == nil && len(cmds) == 0
+		q.mu.Unlock()
+		if settled || atomic.LoadInt32(&q.consuming) == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%d commands left unsent: %w", q.depth()+len(cmds), ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// depth returns how many commands are waiting to be sent.
+func (q *cmdQueue) depth() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+// done marks the in-flight command as finished, returning whether it was cancelled.
+func (q *cmdQueue) done(qc *queuedCmd, err error) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.inFlight = nil
+	if err != nil {
+		qc.lastErr = err.Error()
+	}
+	cancelled := qc.cancel == nil
+	if !cancelled {
+		qc.cancel()
+		qc.cancel = nil
+	}
+	return cancelled
+}
+
+// retry requeues a failed command after the delay, unless it's cancelled or flushed first.
+func (q *cmdQueue) retry(ctx context.Context, qc *queuedCmd, delay time.Duration) {
+	q.mu.Lock()
+	q.retrying[qc.id] = qc
+	q.mu.Unlock()
+	go func() {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		q.mu.Lock()
+		_, ok := q.retrying[qc.id]
+		delete(q.retrying, qc.id)
+		q.mu.Unlock()
+		if ok {
+			logger.Info("requeuing failed command", "args", qc.cmd.args)
+			q.push(qc)
+		}
+	}()
+}
+
+// hasRegistration reports whether the queue holds a command making the Catalog registration with the given ID.
+func (q *cmdQueue) hasRegistration(id string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	makes := func(qc *queuedCmd) bool {
+		return qc != nil && qc.cmd.registration != nil && qc.cmd.registration.ID() == id
+	}
+	if makes(q.inFlight) {
+		return true
+	}
+	for _, qc := range q.pending {
+		if makes(qc) {
+			return true
+		}
+	}
+	for _, qc := range q.retrying {
+		if makes(qc) {
+			return true
+		}
+	}
+	return false
+}
+
+// snapshot describes the queue's commands: the one in flight, then the pending ones in order, then those waiting to
+// be retried.
+func (q *cmdQueue) snapshot(mesh, api string, now time.Time) []QueuedCommand {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var cmds []QueuedCommand
+	if q.inFlight != nil {
+		cmds = append(cmds, q.inFlight.describe(mesh, api, CommandInFlight, now))
+	}
+	for _, qc := range q.pending {
+		cmds = append(cmds, qc.describe(mesh, api, CommandPending, now))
+	}
+	for _, qc := range q.retrying {
+		cmds = append(cmds, qc.describe(mesh, api, CommandRetrying, now))
+	}
+	return cmds
+}
+
+// flush drops the pending commands and those waiting to be retried, returning how many were dropped.
+func (q *cmdQueue) flush() int {
+	q.mu.Lock()
+	flushed := q.pending
+	for _, qc := range q.retrying {
+		flushed = append(flushed, qc)
+	}
+	q.pending = nil
+	q.retrying = make(map[uint64]*queuedCmd)
+	q.mu.Unlock()
+	signal(q.freed)
+	q.notifyDropped(flushed...)
+	return len(flushed)
+}
+
+// cancel drops the command with the given ID, interrupting it if it's in flight. It returns false if the queue
+// doesn't have it.
+func (q *cmdQueue) cancel(id uint64) bool {
+	qc := q.take(id)
+	if qc == nil {
+		return false
+	}
+	q.notifyDropped(qc)
+	return true
+}
+
+// take removes the command with the given ID and returns it, interrupting it if it's in flight, or returns nil if the
+// queue doesn't have it.
+func (q *cmdQueue) take(id uint64) *queuedCmd {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.inFlight != nil && q.inFlight.id == id {
+		if q.inFlight.cancel != nil {
+			q.inFlight.cancel()
+			q.inFlight.cancel = nil
+		}
+		return q.inFlight
+	}
+	if qc, ok := q.retrying[id]; ok {
+		delete(q.retrying, id)
+		signal(q.freed)
+		return qc
+	}
+	for idx, qc := range q.pending {
+		if qc.id == id {
+			q.pending = append(q.pending[:idx:idx], q.pending[idx+1:]...)
+			signal(q.freed)
+			return qc
+		}
+	}
+	return nil
+}
+
+func (q *cmdQueue) notifyDropped(dropped ...*queuedCmd) {
+	if q.dropped == nil {
+		return
+	}
+	for _, qc := range dropped {
+		q.dropped(qc.cmd)
+	}
+}
+
+func (qc *queuedCmd) describe(mesh, api, state string, now time.Time) QueuedCommand {
+	kind, key := qc.cmd.object()
+	return QueuedCommand{
+		ID:        qc.id,
+		Mesh:      mesh,
+		API:       api,
+		State:     state,
+		Args:      qc.cmd.args,
+		Kind:      kind,
+		Key:       key,
+		Attempts:  qc.attempts,
+		Timeouts:  qc.cmd.timeouts,
+		Queued:    qc.queued,
+		Age:       now.Sub(qc.queued).Round(time.Second).String(),
+		LastError: qc.lastErr,
+	}
+}
+
+// object returns the kind and key of the object a Cmd acts on, from its args and stdin, if it's an apply or delete.
+func (c Cmd) object() (kind, key string) {
+	args := strings.Fields(c.args)
+	switch {
+	case len(args) >= 3 && args[0] == "apply" && args[1] == "-t":
+		kind = args[2]
+		if value := gjson.GetBytes(c.stdin, kindKey(kind)); value.Exists() {
+			key = value.String()
+		}
+	case len(args) >= 4 && args[0] == "delete":
+		kind, key = args[1], args[3]
+	}
+	return kind, key
+}
@@ -0,0 +1,93 @@
+package gmapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCmdQueue(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cmds := make(chan Cmd, commandQueueSize)
+	queue := newCmdQueue()
+	go queue.fill(ctx, cmds)
+
+	cmds <- MkApply("cluster", json.RawMessage(`{"cluster_key":"edge","zone_key":"default-zone"}`))
+	cmds <- mkDelete("domain", json.RawMessage(`{"domain_key":"edge","zone_key":"default-zone"}`))
+	cmds <- Cmd{args: "get catalogmesh --mesh-id mesh"}
+	assert.Eventually(t, func() bool { return queue.depth() == 3 }, time.Second, time.Millisecond)
+
+	// Commands are described in order, with the objects they act on
+	queued := queue.snapshot("mesh", apiControl, time.Now())
+	if assert.Len(t, queued, 3) {
+		assert.Equal(t, []string{"cluster", "domain", ""}, []string{queued[0].Kind, queued[1].Kind, queued[2].Kind})
+		assert.Equal(t, []string{"edge", "edge", ""}, []string{queued[0].Key, queued[1].Key, queued[2].Key})
+		assert.Equal(t, CommandPending, queued[0].State)
+		assert.Less(t, queued[0].ID, queued[1].ID)
+	}
+
+	// An in-flight command can be cancelled
+	first, cmdCtx, ok := queue.next(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, CommandInFlight, queue.snapshot("mesh", apiControl, time.Now())[0].State)
+	assert.True(t, queue.cancel(first.id))
+	assert.Error(t, cmdCtx.Err())
+	assert.True(t, queue.done(first, cmdCtx.Err()))
+
+	// A failed command waits to be retried, unless it's cancelled first
+	second, _, _ := queue.next(ctx)
+	assert.False(t, queue.done(second, errors.New("no such zone")))
+	queue.retry(ctx, second, time.Hour)
+	retrying := queue.snapshot("mesh", apiControl, time.Now())
+	if assert.Len(t, retrying, 2) {
+		assert.Equal(t, CommandRetrying, retrying[1].State)
+		assert.Equal(t, 1, retrying[1].Attempts)
+		assert.Equal(t, "no such zone", retrying[1].LastError)
+	}
+	assert.True(t, queue.cancel(second.id))
+	assert.False(t, queue.cancel(second.id))
+
+	// Flushing drops everything waiting
+	cmds <- Cmd{args: "list cluster"}
+	assert.Eventually(t, func() bool { return queue.depth() == 2 }, time.Second, time.Millisecond)
+	queue.retry(ctx, &queuedCmd{id: 99}, time.Hour)
+	assert.Equal(t, 3, queue.flush())
+	assert.Empty(t, queue.snapshot("mesh", apiControl, time.Now()))
+
+	// Retried commands are queued again after the delay
+	third := &queuedCmd{id: 100, cmd: Cmd{args: "list domain"}}
+	queue.retry(ctx, third, time.Millisecond)
+	assert.Eventually(t, func() bool { return queue.depth() == 1 }, time.Second, time.Millisecond)
+}
+
+func TestCmdQueueSettle(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cmds := make(chan Cmd, commandQueueSize)
+	queue := newCmdQueue()
+	go queue.fill(ctx, cmds)
+	cmds <- Cmd{args: "list cluster"}
+	assert.Eventually(t, func() bool { return queue.depth() == 1 }, time.Second, time.Millisecond)
+
+	// A queue nothing consumes isn't waited for
+	assert.NoError(t, queue.settle(ctx, cmds))
+
+	// Once it's consumed, the commands pending and in flight are
+	queue.consuming = 1
+	timeout, cancelTimeout := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancelTimeout()
+	err := queue.settle(timeout, cmds)
+	assert.Contains(t, fmt.Sprint(err), "1 commands left unsent")
+	qc, _, _ := queue.next(ctx)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		queue.done(qc, nil)
+	}()
+	assert.NoError(t, queue.settle(ctx, cmds))
+}
@@ -0,0 +1,98 @@
+package gmapi
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// APICredentials are the OAuth2 client credentials that session tokens for Control and Catalog are requested with,
+// for APIs behind an edge that requires them.
+type APICredentials struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+}
+
+// How long a request for a session token may take.
+const sessionLoginTimeout = 10 * time.Second
+
+// apiSession logs in to Control and Catalog with the client credentials grant, and caches the session token until
+// shortly before it expires or an API rejects it, when it logs in again.
+type apiSession struct {
+	config clientcredentials.Config
+	mu     sync.Mutex
+	// Caches the current token; nil until the first login, and after the token is rejected
+	source oauth2.TokenSource
+}
+
+func newAPISession(creds APICredentials) *apiSession {
+	return &apiSession{config: clientcredentials.Config{
+		ClientID:     creds.ClientID,
+		ClientSecret: creds.ClientSecret,
+		TokenURL:     creds.TokenURL,
+		Scopes:       creds.Scopes,
+	}}
+}
+
+// token returns the current session token, logging in if there's none or it has expired.
+func (s *apiSession) token() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.source == nil {
+		// The token source keeps the context for every later login, so it mustn't be a command's
+		ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Timeout: sessionLoginTimeout})
+		s.source = oauth2.ReuseTokenSource(nil, s.config.TokenSource(ctx))
+	}
+	tok, err := s.source.Token()
+	if err != nil {
+		s.source = nil
+		return "", fmt.Errorf("failed to log in to the Grey Matter APIs: %w", err)
+	}
+	return tok.AccessToken, nil
+}
+
+// invalidate drops the current session token, so the next command logs in again.
+func (s *apiSession) invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.source = nil
+}
+
+// withSessionToken returns a copy of CLI flags whose config also sends the session token to Control and Catalog.
+func withSessionToken(flags []string, token string) []string {
+	flags = append([]string(nil), flags...)
+	for i := 0; i+1 < len(flags); i++ {
+		if flags[i] != "--base64-config" {
+			continue
+		}
+		conf, err := base64.StdEncoding.DecodeString(flags[i+1])
+		if err != nil {
+			continue
+		}
+		headers := fmt.Sprintf("\n[api.headers]\nAuthorization = %q\n[catalog.headers]\nAuthorization = %q\n", "Bearer "+token, "Bearer "+token)
+		flags[i+1] = base64.StdEncoding.EncodeToString(append(conf, headers...))
+	}
+	return flags
+}
+
+// unauthorized reports whether a failed command's output says the API rejected its session token.
+func unauthorized(out string) bool {
+	return strings.Contains(out, "401") || strings.Contains(strings.ToLower(out), "unauthorized")
+}
+
+// SetAPICredentials sets the client credentials that session tokens for Control and Catalog are requested with,
+// used by clients configured from then on.
+func (c *CLI) SetAPICredentials(creds APICredentials) {
+	c.Lock()
+	defer c.Unlock()
+	c.session = newAPISession(creds)
+}
@@ -0,0 +1,79 @@
+package gmapi
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionTokenRenewal(t *testing.T) {
+	// Issues a new token on each login
+	var logins int32
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if id != "gm-operator" || secret != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		n := atomic.AddInt32(&logins, 1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"bearer","expires_in":3600}`, n)
+	}))
+	defer tokenServer.Close()
+
+	// Rejects the first token, as if it had been revoked
+	var seen []string
+	run := func(ctx context.Context, args []string, stdin []byte, env []string) ([]byte, error) {
+		conf, err := base64.StdEncoding.DecodeString(args[1])
+		if err != nil {
+			return nil, err
+		}
+		for _, token := range []string{"token-1", "token-2"} {
+			if strings.Contains(string(conf), `Authorization = "Bearer `+token+`"`) {
+				seen = append(seen, token)
+				if token == "token-1" {
+					return []byte("401 Unauthorized"), errors.New("exit status 1")
+				}
+				return []byte("[]"), nil
+			}
+		}
+		return []byte("no session token"), errors.New("exit status 1")
+	}
+
+	client := &Client{
+		flags:  []string{"--base64-config", mkCLIConfig("http://control", "http://catalog", "mesh", catalogAuthFiles{})},
+		policy: commandPolicy{timeout: 5 * time.Second},
+		run:    run,
+		session: newAPISession(APICredentials{
+			TokenURL:     tokenServer.URL,
+			ClientID:     "gm-operator",
+			ClientSecret: "s3cret",
+		}),
+	}
+
+	// A rejected token is renewed and the command retried once
+	_, err := client.List(context.Background(), "cluster")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"token-1", "token-2"}, seen)
+
+	// Until it expires, the renewed token is reused
+	_, err = client.List(context.Background(), "cluster")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"token-1", "token-2", "token-2"}, seen)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&logins))
+
+	// Commands fail without running if the operator can't log in
+	client.session = newAPISession(APICredentials{TokenURL: tokenServer.URL, ClientID: "gm-operator", ClientSecret: "wrong"})
+	_, err = client.List(context.Background(), "cluster")
+	assert.Contains(t, fmt.Sprint(err), "failed to log in")
+	assert.Len(t, seen, 3)
+}
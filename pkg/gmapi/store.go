@@ -0,0 +1,86 @@
+package gmapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// ObjectStore receives the Grey Matter config objects the operator would otherwise send to Control, e.g. to serve
+// them to sidecars directly over xDS.
+type ObjectStore interface {
+	// Apply creates or replaces the object of the given kind with the given key.
+	Apply(kind, key string, obj json.RawMessage)
+	// Delete deletes the object of the given kind with the given key, returning whether it existed.
+	Delete(kind, key string) bool
+	// Get returns the object of the given kind with the given key.
+	Get(kind, key string) (json.RawMessage, bool)
+	// List returns the objects of the given kind, sorted by key.
+	List(kind string) []json.RawMessage
+}
+
+// DeliverControlConfig sends the commands for Control of the mesh clients configured from then on to the store
+// instead, so Control isn't needed. Commands for Catalog are still run as before. If enabled is set, it's checked for
+// each command, which is sent to Control as before while it returns false.
+func (c *CLI) DeliverControlConfig(store ObjectStore, enabled func() bool) {
+	c.Lock()
+	defer c.Unlock()
+	c.run = runStore(store, c.run, enabled)
+}
+
+// runStore returns a runFunc that runs the greymatter CLI commands for Control against the store, unless enabled is
+// set and returns false, and all others with next.
+func runStore(store ObjectStore, next runFunc, enabled func() bool) runFunc {
+	return func(ctx context.Context, args []string, stdin []byte, env []string) ([]byte, error) {
+		cmd, ok := parseCommand(args)
+		if !ok || strings.HasPrefix(cmd.kind, "catalog") || (enabled != nil && !enabled()) {
+			return next(ctx, args, stdin, env)
+		}
+		switch cmd.verb {
+		case "apply":
+			key := gjson.GetBytes(stdin, mockKeyField(cmd.kind)).String()
+			if !json.Valid(stdin) || key == "" {
+				return failed("invalid %s: %s is required", cmd.kind, mockKeyField(cmd.kind))
+			}
+			store.Apply(cmd.kind, key, stdin)
+			return stdin, nil
+		case "create":
+			obj := cmd.created()
+			key := gjson.GetBytes(obj, mockKeyField(cmd.kind)).String()
+			if key == "" {
+				return failed("invalid %s: %s is required", cmd.kind, mockKeyField(cmd.kind))
+			}
+			if _, ok := store.Get(cmd.kind, key); ok {
+				return failed("%s %s already exists", cmd.kind, key)
+			}
+			store.Apply(cmd.kind, key, obj)
+			return obj, nil
+		case "get":
+			if obj, ok := store.Get(cmd.kind, cmd.key()); ok {
+				return obj, nil
+			}
+			return failed("%s %s not found", cmd.kind, cmd.key())
+		case "delete":
+			if store.Delete(cmd.kind, cmd.key()) {
+				return []byte("{}"), nil
+			}
+			return failed("%s %s not found", cmd.kind, cmd.key())
+		case "list":
+			list := store.List(cmd.kind)
+			if list == nil {
+				list = []json.RawMessage{}
+			}
+			return json.Marshal(list)
+		}
+		return unsupported(args)
+	}
+}
+
+// failed fails a command run against an ObjectStore, with the error as its output like the CLI's.
+func failed(format string, args ...interface{}) ([]byte, error) {
+	err := fmt.Errorf(format, args...)
+	return []byte(err.Error()), err
+}
@@ -0,0 +1,75 @@
+package gmapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/greymatter-io/operator/pkg/xds"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunStore(t *testing.T) {
+	store := xds.New("")
+	var passed []string
+	run := runStore(store, func(_ context.Context, args []string, _ []byte, _ []string) ([]byte, error) {
+		passed = append(passed, strings.Join(args, " "))
+		return nil, nil
+	}, nil)
+	exec := func(args string, stdin string) (string, error) {
+		out, err := run(context.Background(), strings.Split(args, " "), []byte(stdin), nil)
+		return string(out), err
+	}
+
+	_, err := exec("apply -t cluster -f -", `{"cluster_key":"edge","zone_key":"default-zone"}`)
+	assert.NoError(t, err)
+	_, err = exec("create sharedrules --zone-key default-zone --shared-rules-key ping --name ping", "")
+	assert.NoError(t, err)
+	_, err = exec("create sharedrules --zone-key default-zone --shared-rules-key ping --name ping", "")
+	assert.Contains(t, fmt.Sprint(err), "sharedrules ping already exists")
+	out, err := exec("get cluster --cluster-key edge", "")
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"cluster_key":"edge","zone_key":"default-zone"}`, out)
+	out, err = exec("list cluster", "")
+	assert.NoError(t, err)
+	var list []json.RawMessage
+	assert.NoError(t, json.Unmarshal([]byte(out), &list))
+	assert.Len(t, list, 1)
+	_, err = exec("delete cluster --cluster-key edge", "")
+	assert.NoError(t, err)
+	_, err = exec("get cluster --cluster-key edge", "")
+	assert.Contains(t, fmt.Sprint(err), "cluster edge not found")
+
+	// Commands for Catalog are still run
+	_, err = exec("apply -t catalogservice -f -", `{"service_id":"edge","mesh_id":"mesh"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"apply -t catalogservice -f -"}, passed)
+	_, ok := store.Get("catalogservice", "edge")
+	assert.False(t, ok)
+}
+
+func TestRunStoreSwitchedOff(t *testing.T) {
+	store := xds.New("")
+	enabled := false
+	var passed []string
+	run := runStore(store, func(_ context.Context, args []string, _ []byte, _ []string) ([]byte, error) {
+		passed = append(passed, strings.Join(args, " "))
+		return nil, nil
+	}, func() bool { return enabled })
+
+	// Switched off, commands for Control are run as before
+	_, err := run(context.Background(), strings.Split("apply -t cluster -f -", " "), []byte(`{"cluster_key":"edge"}`), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"apply -t cluster -f -"}, passed)
+	_, ok := store.Get("cluster", "edge")
+	assert.False(t, ok)
+
+	enabled = true
+	_, err = run(context.Background(), strings.Split("apply -t cluster -f -", " "), []byte(`{"cluster_key":"edge"}`), nil)
+	assert.NoError(t, err)
+	assert.Len(t, passed, 1)
+	_, ok = store.Get("cluster", "edge")
+	assert.True(t, ok)
+}
@@ -0,0 +1,86 @@
+package gmapi
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/greymatter-io/operator/pkg/gitops"
+)
+
+// ApplyZones creates the mesh's additional zones, each in the Control API serving it,
+// and gives Catalog a session with each zone's Control server.
+func ApplyZones(client *Client, mesh *v1alpha1.Mesh) {
+	if len(mesh.Spec.Zones) == 0 {
+		return
+	}
+
+	sessions := make(map[string]string)
+	for _, zone := range mesh.Spec.Zones {
+		data, _ := json.Marshal(map[string]string{"zone_key": zone.Name, "name": zone.Name})
+		client.ControlCmds <- MkApply("zone", data)
+
+		sessions[zone.Name] = zone.SessionURL
+		if sessions[zone.Name] == "" {
+			sessions[zone.Name] = fmt.Sprintf("controlensemble.%s.svc.cluster.local:50000", mesh.Spec.InstallNamespace)
+		}
+	}
+
+	data, _ := json.Marshal(sessions)
+	client.CatalogCmds <- client.queueCatalogRegistration(gitops.CatalogRegistration{
+		Mesh: mesh.Name, Kind: "catalogmesh", Key: mesh.Name, Object: data,
+	}, nil)
+}
+
+// mkAddCatalogSessions returns a Cmd that adds sessions with the given Control servers, by zone, to a Catalog mesh.
+func mkAddCatalogSessions(meshID string, sessions map[string]string) Cmd {
+	return Cmd{
+		args:    fmt.Sprintf("get catalogmesh --mesh-id %s", meshID),
+		requeue: true,
+		modify: func(out []byte) ([]byte, error) {
+			return addCatalogSessions(out, sessions)
+		},
+		then: &Cmd{
+			args: "apply -t catalogmesh -f -",
+			log: func(out string, err error) {
+				if err != nil {
+					logger.Error(fmt.Errorf(out), "failed to add zone sessions to Catalog", "Mesh", meshID)
+				} else {
+					logger.Info("Added zone sessions to Catalog", "Mesh", meshID, "Sessions", sessions)
+				}
+			},
+		},
+	}
+}
+
+// addCatalogSessions adds a session for each zone not already in a Catalog mesh object,
+// connecting to the given Control server address.
+func addCatalogSessions(catalogMesh []byte, sessions map[string]string) ([]byte, error) {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(catalogMesh, &obj); err != nil {
+		return nil, fmt.Errorf("unexpected catalogmesh output: %w", err)
+	}
+
+	type session struct {
+		URL  string `json:"url"`
+		Zone string `json:"zone"`
+	}
+	existing := make(map[string]json.RawMessage)
+	if raw, ok := obj["sessions"]; ok {
+		if err := json.Unmarshal(raw, &existing); err != nil {
+			return nil, fmt.Errorf("unexpected catalogmesh sessions: %w", err)
+		}
+	}
+	for zone, url := range sessions {
+		if _, ok := existing[zone]; ok {
+			continue
+		}
+		existing[zone], _ = json.Marshal(session{URL: url, Zone: zone})
+	}
+
+	var err error
+	if obj["sessions"], err = json.Marshal(existing); err != nil {
+		return nil, err
+	}
+	return json.Marshal(obj)
+}
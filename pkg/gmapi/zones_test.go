@@ -0,0 +1,30 @@
+package gmapi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddCatalogSessions(t *testing.T) {
+	catalogMesh := []byte(`{
+		"mesh_id": "mesh",
+		"sessions": {"default-zone": {"url": "controlensemble.greymatter.svc.cluster.local:50000", "zone": "default-zone"}}
+	}`)
+
+	out, err := addCatalogSessions(catalogMesh, map[string]string{
+		"default-zone": "elsewhere:50000",
+		"east":         "control.east.example.com:50000",
+	})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"mesh_id": "mesh",
+		"sessions": {
+			"default-zone": {"url": "controlensemble.greymatter.svc.cluster.local:50000", "zone": "default-zone"},
+			"east": {"url": "control.east.example.com:50000", "zone": "east"}
+		}
+	}`, string(out))
+
+	_, err = addCatalogSessions([]byte("not found"), nil)
+	assert.Error(t, err)
+}
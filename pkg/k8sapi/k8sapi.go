@@ -2,6 +2,9 @@ package k8sapi
 
 import (
 	"context"
+	"reflect"
+	"sync/atomic"
+	"time"
 
 	"github.com/greymatter-io/operator/pkg/gitops"
 	"k8s.io/apimachinery/pkg/api/errors"
@@ -15,19 +18,60 @@ import (
 
 var (
 	logger = ctrl.Log.WithName("k8sapi")
+
+	// The time each operation may take, as a time.Duration; read and written atomically.
+	timeout = int64(DefaultTimeout)
 )
 
+// DefaultTimeout is the time each operation may take unless SetTimeout is called.
+const DefaultTimeout = 30 * time.Second
+
+// SetTimeout sets the time each operation may take: applying, deleting, snapshotting, or restoring an object, or
+// listing a page of objects. It doesn't extend the deadline of the context the operation is given.
+func SetTimeout(d time.Duration) {
+	if d <= 0 {
+		d = DefaultTimeout
+	}
+	atomic.StoreInt64(&timeout, int64(d))
+}
+
+// WithTimeout returns a context for one operation, which ends with ctx or once the operation's time is up. It bounds
+// calls made directly through a client, in the same way as those made through this package.
+func WithTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, time.Duration(atomic.LoadInt64(&timeout)))
+}
+
+// Applier is the part of a sigs.k8s.io/controller-runtime/pkg/client.Client used by Apply and its ActionFuncs:
+// reads and writes, and the scheme for looking up kinds and setting owner references.
+type Applier interface {
+	client.Reader
+	client.Writer
+	Scheme() *runtime.Scheme
+}
+
+// Lister is the part of a sigs.k8s.io/controller-runtime/pkg/client.Client used by ListPages.
+type Lister interface {
+	List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error
+}
+
+// Deleter is the part of a sigs.k8s.io/controller-runtime/pkg/client.Client used by Delete and DeleteAll.
+type Deleter interface {
+	Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error
+}
+
 // ActionFunc is a type of function that makes a sequence of API calls to a K8s apiserver.
 // If any API call fails, the ActionFunc should return a string describing the failed call,
-// plus the error returned by the sigs.k8s.io/controller-runtime/pkg/client.Client.
+// plus the error returned by the Applier.
 // Otherwise, the ActionFunc should return a string describing its successful result, and a nil error.
-type ActionFunc func(client.Client, client.Object) (string, error)
+type ActionFunc func(context.Context, Applier, client.Object) (string, error)
 
 // Apply is a functional interface for interacting with the K8s apiserver in a consistent way.
-// Each sigs.k8s.io/controller-runtime/pkg/client.Object argument must implement the necessary
-// Reader/Writer interfaces implemented by sigs.k8s.io/controller-runtime/pkg/client.Client.
-func Apply(c *client.Client, obj, owner client.Object, action ActionFunc) error {
-	scheme := (*c).Scheme()
+// Each sigs.k8s.io/controller-runtime/pkg/client.Object argument must be of a kind in the Applier's scheme.
+// The action is given until ctx ends or the operation timeout (see SetTimeout) passes.
+func Apply(ctx context.Context, c Applier, obj, owner client.Object, action ActionFunc) error {
+	ctx, cancel := WithTimeout(ctx)
+	defer cancel()
+	scheme := c.Scheme()
 
 	var kind string
 	if gvk, err := apiutil.GVKForObject(obj.(runtime.Object), scheme); err != nil {
@@ -46,7 +90,7 @@ func Apply(c *client.Client, obj, owner client.Object, action ActionFunc) error
 		}
 	}
 
-	act, err := action(*c, obj)
+	act, err := action(ctx, c, obj)
 	if err != nil {
 		if ownerName != "" {
 			logger.Error(err, act, "Owner", ownerName, kind, client.ObjectKeyFromObject(obj))
@@ -65,35 +109,124 @@ func Apply(c *client.Client, obj, owner client.Object, action ActionFunc) error
 }
 
 // CreateOrUpdate is an Action that applies a resource in the K8s apiserver.
-func CreateOrUpdate(c client.Client, obj client.Object) (string, error) {
+func CreateOrUpdate(ctx context.Context, c Applier, obj client.Object) (string, error) {
 	key := client.ObjectKeyFromObject(obj)
 
 	// Make a pointer copy of the object so that our actual object is not modified by client.Get.
 	// This way, the object passed into client.Update still has our desired state.
 	existing := obj.DeepCopyObject()
-	if err := c.Get(context.TODO(), key, existing.(client.Object)); err != nil {
+	if err := c.Get(ctx, key, existing.(client.Object)); err != nil {
 		if !errors.IsNotFound(err) {
 			return "create/update", err
 		}
-		if err := c.Create(context.TODO(), obj); err != nil {
+		if err := c.Create(ctx, obj); err != nil {
 			return "create", err
 		}
 		return "create", nil
 	}
 
-	if err := c.Update(context.TODO(), obj); err != nil {
+	if err := c.Update(ctx, obj); err != nil {
 		return "update", err
 	}
 
 	return "update", nil
 }
 
+// CreateOrUpdateIfChanged is an Action like CreateOrUpdate, except it skips the update if the live object already
+// matches the desired one, avoiding a write to the apiserver and any rollout triggered by a no-op update.
+func CreateOrUpdateIfChanged(ctx context.Context, c Applier, obj client.Object) (string, error) {
+	key := client.ObjectKeyFromObject(obj)
+
+	existing := obj.DeepCopyObject().(client.Object)
+	if err := c.Get(ctx, key, existing); err != nil {
+		if !errors.IsNotFound(err) {
+			return "create/update", err
+		}
+		if err := c.Create(ctx, obj); err != nil {
+			return "create", err
+		}
+		return "create", nil
+	}
+
+	if matchesLive(obj, existing) {
+		return "unchanged", nil
+	}
+	if err := c.Update(ctx, obj); err != nil {
+		return "update", err
+	}
+
+	return "update", nil
+}
+
+// matchesLive reports whether every field set in the desired object has the same value in the live one.
+// Fields only set in the live object (such as defaults filled in by the apiserver) and its status are ignored,
+// as is metadata other than labels, annotations, and owner references.
+func matchesLive(desired, live client.Object) bool {
+	desiredMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(desired)
+	if err != nil {
+		return false
+	}
+	liveMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(live)
+	if err != nil {
+		return false
+	}
+	for _, m := range []map[string]interface{}{desiredMap, liveMap} {
+		delete(m, "apiVersion")
+		delete(m, "kind")
+		delete(m, "status")
+		if metadata, ok := m["metadata"].(map[string]interface{}); ok {
+			m["metadata"] = map[string]interface{}{
+				"labels":          metadata["labels"],
+				"annotations":     metadata["annotations"],
+				"ownerReferences": metadata["ownerReferences"],
+			}
+		}
+	}
+	return isSubset(desiredMap, liveMap)
+}
+
+// isSubset reports whether desired is contained in live: maps may have extra keys in live,
+// but lists must be the same length, and other values equal.
+func isSubset(desired, live interface{}) bool {
+	switch d := desired.(type) {
+	case map[string]interface{}:
+		l, ok := live.(map[string]interface{})
+		if !ok {
+			return len(d) == 0 && live == nil
+		}
+		for k, v := range d {
+			if !isSubset(v, l[k]) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		l, ok := live.([]interface{})
+		if !ok {
+			return len(d) == 0 && live == nil
+		}
+		if len(d) != len(l) {
+			return false
+		}
+		for idx := range d {
+			if !isSubset(d[idx], l[idx]) {
+				return false
+			}
+		}
+		return true
+	case nil:
+		return true
+	default:
+		return reflect.DeepEqual(desired, live)
+	}
+}
+
 // GetOrCreate is an Action that ensures a resource exists in the K8s apiserver.
-func GetOrCreate(c client.Client, obj client.Object) (string, error) {
+func GetOrCreate(ctx context.Context, c Applier, obj client.Object) (string, error) {
 	key := client.ObjectKeyFromObject(obj)
 
-	if err := c.Get(context.TODO(), key, obj); err != nil {
-		if err := c.Create(context.TODO(), obj); err != nil {
+	if err := c.Get(ctx, key, obj); err != nil {
+		if err := c.Create(ctx, obj); err != nil {
 			return "create", err
 		}
 		return "create", nil
@@ -103,9 +236,9 @@ func GetOrCreate(c client.Client, obj client.Object) (string, error) {
 }
 
 // Get is an Action checks if a resource exists in the K8s apiserver.
-func Get(c client.Client, obj client.Object) (string, error) {
+func Get(ctx context.Context, c Applier, obj client.Object) (string, error) {
 	key := client.ObjectKeyFromObject(obj)
-	if err := c.Get(context.TODO(), key, obj); err != nil {
+	if err := c.Get(ctx, key, obj); err != nil {
 		return "get", err
 	}
 	return "get", nil
@@ -113,15 +246,15 @@ func Get(c client.Client, obj client.Object) (string, error) {
 
 // MkPatchAction returns an Action that applies the patch specified when called.
 func MkPatchAction(patch func(client.Object) client.Object) ActionFunc {
-	return func(c client.Client, obj client.Object) (string, error) {
+	return func(ctx context.Context, c Applier, obj client.Object) (string, error) {
 		key := client.ObjectKeyFromObject(obj)
-		if err := c.Get(context.TODO(), key, obj); err != nil {
+		if err := c.Get(ctx, key, obj); err != nil {
 			return "get", err
 		}
 
 		mp := client.MergeFrom(obj.DeepCopyObject().(client.Object))
 		obj = patch(obj)
-		if err := c.Patch(context.TODO(), obj, mp); err != nil {
+		if err := c.Patch(ctx, obj, mp); err != nil {
 			return "patch", err
 		}
 
@@ -129,19 +262,73 @@ func MkPatchAction(patch func(client.Object) client.Object) ActionFunc {
 	}
 }
 
-func DeleteAll(c *client.Client, deleted []gitops.K8sObjectRef) {
+// DeleteAll deletes each referenced object, recording each outcome in the given report (which may be nil).
+// Once ctx ends, the objects left are recorded as failed without being deleted.
+func DeleteAll(ctx context.Context, c Deleter, deleted []gitops.K8sObjectRef, report *gitops.SyncReport) {
 	for _, obj := range deleted {
-		err := Delete(c, obj)
+		err := Delete(ctx, c, obj)
 		if err != nil {
 			logger.Error(err, "Failed to delete object", "Object", obj.Name)
 		}
+		report.Record("k8s", obj.Kind.Kind, obj.Namespace, obj.Name, "delete", err)
 	}
 }
 
-func Delete(c *client.Client, obj gitops.K8sObjectRef) error {
+// Delete deletes a referenced object, within the operation timeout.
+func Delete(ctx context.Context, c Deleter, obj gitops.K8sObjectRef) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ctx, cancel := WithTimeout(ctx)
+	defer cancel()
 	u := &unstructured.Unstructured{}
 	u.SetName(obj.Name)
 	u.SetNamespace(obj.Namespace)
 	u.SetGroupVersionKind(obj.Kind)
-	return (*c).Delete(context.Background(), u)
+	return c.Delete(ctx, u)
+}
+
+// Snapshot returns a copy of the live version of an object, e.g. so it can be restored after the object is applied,
+// or nil if the object doesn't exist.
+func Snapshot(ctx context.Context, c client.Reader, obj client.Object) (*unstructured.Unstructured, error) {
+	ctx, cancel := WithTimeout(ctx)
+	defer cancel()
+	live := &unstructured.Unstructured{}
+	live.SetGroupVersionKind(obj.GetObjectKind().GroupVersionKind())
+	if err := c.Get(ctx, client.ObjectKeyFromObject(obj), live); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return live, nil
+}
+
+// Restore puts back the version of an object returned by Snapshot, or deletes the object if the snapshot is nil
+// because it didn't exist.
+func Restore(ctx context.Context, c Applier, obj client.Object, snapshot *unstructured.Unstructured) error {
+	ctx, cancel := WithTimeout(ctx)
+	defer cancel()
+	if snapshot == nil {
+		if err := c.Delete(ctx, obj); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		return nil
+	}
+
+	restored := snapshot.DeepCopy()
+	unstructured.RemoveNestedField(restored.Object, "status")
+	restored.SetManagedFields(nil)
+	live := &unstructured.Unstructured{}
+	live.SetGroupVersionKind(snapshot.GroupVersionKind())
+	if err := c.Get(ctx, client.ObjectKeyFromObject(snapshot), live); err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		restored.SetResourceVersion("")
+		restored.SetUID("")
+		return c.Create(ctx, restored)
+	}
+	restored.SetResourceVersion(live.GetResourceVersion())
+	return c.Update(ctx, restored)
 }
@@ -0,0 +1,143 @@
+package k8sapi
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/greymatter-io/operator/pkg/gitops"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestCreateOrUpdateIfChanged(t *testing.T) {
+	scheme := runtime.NewScheme()
+	assert.NoError(t, clientgoscheme.AddToScheme(scheme))
+	var c client.Client = fake.NewClientBuilder().WithScheme(scheme).Build()
+
+	deployment := func(replicas int32) *appsv1.Deployment {
+		return &appsv1.Deployment{
+			TypeMeta:   metav1.TypeMeta{Kind: "Deployment", APIVersion: "apps/v1"},
+			ObjectMeta: metav1.ObjectMeta{Name: "control", Namespace: "greymatter", Labels: map[string]string{"app": "control"}},
+			Spec: appsv1.DeploymentSpec{
+				Replicas: pointer.Int32(replicas),
+				Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "control", Image: "control:1.0"}},
+				}},
+			},
+		}
+	}
+
+	act, err := CreateOrUpdateIfChanged(context.TODO(), c, deployment(1))
+	assert.NoError(t, err)
+	assert.Equal(t, "create", act)
+
+	// Defaults filled in on the live object don't count as changes
+	live := &appsv1.Deployment{}
+	assert.NoError(t, c.Get(context.TODO(), client.ObjectKey{Name: "control", Namespace: "greymatter"}, live))
+	live.Spec.Template.Spec.RestartPolicy = corev1.RestartPolicyAlways
+	live.Labels["added-by"] = "someone-else"
+	assert.NoError(t, c.Update(context.TODO(), live))
+	resourceVersion := live.ResourceVersion
+
+	act, err = CreateOrUpdateIfChanged(context.TODO(), c, deployment(1))
+	assert.NoError(t, err)
+	assert.Equal(t, "unchanged", act)
+	assert.NoError(t, c.Get(context.TODO(), client.ObjectKeyFromObject(live), live))
+	assert.Equal(t, resourceVersion, live.ResourceVersion)
+
+	act, err = CreateOrUpdateIfChanged(context.TODO(), c, deployment(2))
+	assert.NoError(t, err)
+	assert.Equal(t, "update", act)
+	assert.NoError(t, c.Get(context.TODO(), client.ObjectKeyFromObject(live), live))
+	assert.Equal(t, int32(2), *live.Spec.Replicas)
+}
+
+func TestIsSubset(t *testing.T) {
+	for name, tc := range map[string]struct {
+		desired, live interface{}
+		want          bool
+	}{
+		"equal":            {map[string]interface{}{"a": int64(1)}, map[string]interface{}{"a": int64(1)}, true},
+		"extra in live":    {map[string]interface{}{"a": int64(1)}, map[string]interface{}{"a": int64(1), "b": "x"}, true},
+		"different":        {map[string]interface{}{"a": int64(1)}, map[string]interface{}{"a": int64(2)}, false},
+		"missing":          {map[string]interface{}{"a": "x"}, map[string]interface{}{}, false},
+		"empty map":        {map[string]interface{}{"a": map[string]interface{}{}}, map[string]interface{}{}, true},
+		"list length":      {[]interface{}{"a"}, []interface{}{"a", "b"}, false},
+		"list elements":    {[]interface{}{map[string]interface{}{"a": "x"}}, []interface{}{map[string]interface{}{"a": "x", "b": "y"}}, true},
+		"unset in desired": {map[string]interface{}{"a": nil}, map[string]interface{}{"a": "x"}, true},
+	} {
+		assert.Equal(t, tc.want, isSubset(tc.desired, tc.live), name)
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	scheme := runtime.NewScheme()
+	assert.NoError(t, clientgoscheme.AddToScheme(scheme))
+	var c client.Client = fake.NewClientBuilder().WithScheme(scheme).Build()
+
+	configMap := func(value string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{
+			TypeMeta:   metav1.TypeMeta{Kind: "ConfigMap", APIVersion: "v1"},
+			ObjectMeta: metav1.ObjectMeta{Name: "settings", Namespace: "greymatter"},
+			Data:       map[string]string{"zone": value},
+		}
+	}
+	live := &corev1.ConfigMap{}
+
+	// An object that didn't exist is deleted
+	snapshot, err := Snapshot(context.TODO(), c, configMap("east"))
+	assert.NoError(t, err)
+	assert.Nil(t, snapshot)
+	_, err = CreateOrUpdate(context.TODO(), c, configMap("east"))
+	assert.NoError(t, err)
+	assert.NoError(t, Restore(context.TODO(), c, configMap("east"), snapshot))
+	assert.Error(t, c.Get(context.TODO(), client.ObjectKey{Namespace: "greymatter", Name: "settings"}, live))
+
+	// An existing one is put back as it was
+	_, err = CreateOrUpdate(context.TODO(), c, configMap("east"))
+	assert.NoError(t, err)
+	snapshot, err = Snapshot(context.TODO(), c, configMap("west"))
+	assert.NoError(t, err)
+	_, err = CreateOrUpdate(context.TODO(), c, configMap("west"))
+	assert.NoError(t, err)
+	assert.NoError(t, Restore(context.TODO(), c, configMap("west"), snapshot))
+	assert.NoError(t, c.Get(context.TODO(), client.ObjectKey{Namespace: "greymatter", Name: "settings"}, live))
+	assert.Equal(t, "east", live.Data["zone"])
+
+	// Even if it was deleted since
+	assert.NoError(t, c.Delete(context.TODO(), live))
+	assert.NoError(t, Restore(context.TODO(), c, configMap("west"), snapshot))
+	assert.NoError(t, c.Get(context.TODO(), client.ObjectKey{Namespace: "greymatter", Name: "settings"}, live))
+	assert.Equal(t, "east", live.Data["zone"])
+}
+
+func TestOperationTimeout(t *testing.T) {
+	c := fake.NewClientBuilder().Build()
+	defer SetTimeout(DefaultTimeout)
+
+	SetTimeout(time.Second)
+	assert.NoError(t, Apply(context.Background(), c, &corev1.ConfigMap{}, nil, func(ctx context.Context, _ Applier, _ client.Object) (string, error) {
+		deadline, ok := ctx.Deadline()
+		assert.True(t, ok)
+		assert.WithinDuration(t, time.Now().Add(time.Second), deadline, time.Second)
+		return "checked", nil
+	}))
+
+	// Deletions stop once the context ends
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	report := gitops.NewSyncReport("")
+	DeleteAll(ctx, c, []gitops.K8sObjectRef{{Kind: schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, Namespace: "greymatter", Name: "settings"}}, report)
+	_, _, failed, _ := report.Summary()
+	assert.Equal(t, 1, failed)
+	assert.ErrorIs(t, Delete(ctx, c, gitops.K8sObjectRef{}), context.Canceled)
+}
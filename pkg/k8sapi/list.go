@@ -0,0 +1,41 @@
+package k8sapi
+
+import (
+	"context"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// DefaultPageSize is the number of objects requested per page by ListPages when no page size is given.
+const DefaultPageSize = 500
+
+// ListPages lists objects into list one page of at most pageSize objects at a time (DefaultPageSize if pageSize
+// is not positive), and calls onPage after each page is read into list. This keeps large namespaces from being
+// pulled into memory at once and from timing out the apiserver. Listing stops at the first error from the
+// apiserver or onPage, which is returned. Each page is given until ctx ends or the operation timeout (see
+// SetTimeout) passes.
+func ListPages(ctx context.Context, c Lister, list client.ObjectList, pageSize int64, onPage func() error, opts ...client.ListOption) error {
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	opts = append(opts, client.Limit(pageSize))
+	for {
+		if err := listPage(ctx, c, list, opts...); err != nil {
+			return err
+		}
+		if err := onPage(); err != nil {
+			return err
+		}
+		next := list.GetContinue()
+		if next == "" {
+			return nil
+		}
+		opts = append(opts, client.Continue(next))
+	}
+}
+
+func listPage(ctx context.Context, c Lister, list client.ObjectList, opts ...client.ListOption) error {
+	ctx, cancel := WithTimeout(ctx)
+	defer cancel()
+	return c.List(ctx, list, opts...)
+}
@@ -0,0 +1,40 @@
+package k8sapi
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestListPages(t *testing.T) {
+	scheme := runtime.NewScheme()
+	assert.NoError(t, clientgoscheme.AddToScheme(scheme))
+	var c client.Client = fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "apps"}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "b", Namespace: "apps"}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "c", Namespace: "other"}},
+	).Build()
+
+	var names []string
+	pods := &corev1.PodList{}
+	err := ListPages(context.TODO(), c, pods, 0, func() error {
+		for _, pod := range pods.Items {
+			names = append(names, pod.Name)
+		}
+		return nil
+	}, client.InNamespace("apps"))
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a", "b"}, names)
+
+	stop := errors.New("stop")
+	err = ListPages(context.TODO(), c, pods, 1, func() error { return stop })
+	assert.ErrorIs(t, err, stop)
+}
@@ -0,0 +1,124 @@
+package k8sapi
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/greymatter-io/operator/pkg/gitops"
+	"github.com/greymatter-io/operator/pkg/wellknown"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/selection"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Manager identifies the operator and the mesh an object was applied for. Operators and meshes sharing a cluster
+// label what they apply with their own, so each only prunes its own objects.
+type Manager struct {
+	// The operator's identity among those sharing the cluster; wellknown.MANAGED_BY_OPERATOR if empty
+	OperatorID string
+	// The name of the mesh
+	Mesh string
+}
+
+// Labels returns the labels of the objects applied by the manager.
+func (m Manager) Labels() map[string]string {
+	id := m.OperatorID
+	if id == "" {
+		id = wellknown.MANAGED_BY_OPERATOR
+	}
+	return map[string]string{wellknown.LABEL_MANAGED_BY: id, wellknown.LABEL_MESH: m.Mesh}
+}
+
+// MarkManaged labels an object as applied by the manager, so that it can be pruned once it
+// no longer appears in the extracted manifests.
+func MarkManaged(obj client.Object, m Manager) {
+	objLabels := obj.GetLabels()
+	if objLabels == nil {
+		objLabels = make(map[string]string)
+	}
+	for k, v := range m.Labels() {
+		objLabels[k] = v
+	}
+	obj.SetLabels(objLabels)
+}
+
+// NotManaged returns a List option that skips objects labeled as managed by any operator,
+// i.e. the meshes' own core components.
+func NotManaged() client.ListOption {
+	req, _ := labels.NewRequirement(wellknown.LABEL_MANAGED_BY, selection.DoesNotExist, nil)
+	return client.MatchingLabelsSelector{Selector: labels.NewSelector().Add(*req)}
+}
+
+// Pruner is the part of a sigs.k8s.io/controller-runtime/pkg/client.Client used by Prune.
+type Pruner interface {
+	Lister
+	Deleter
+}
+
+// Prune deletes every object labeled as applied by the manager that is not among the desired objects.
+// Only kinds found in the desired objects or the inventory of previously applied objects are listed,
+// similar to `kubectl apply --prune` but driven by the operator's own inventory rather than a fixed allow-list.
+// Of the namespaced objects, only those in the given namespaces (the mesh's) are pruned.
+// Objects are listed pageSize at a time (see ListPages). If guard isn't nil, it's given the orphaned objects and
+// the number of managed objects listed, and returns those to delete (e.g. gitops.SyncState.GuardOrphans).
+// Each deletion is recorded in the given report (which may be nil).
+func Prune(ctx context.Context, c Pruner, m Manager, namespaces []string, desired []client.Object, inventory []gitops.K8sObjectRef, pageSize int64, guard func(orphans []gitops.K8sObjectRef, managed int) []gitops.K8sObjectRef, report *gitops.SyncReport) {
+	orphans, managed := Orphans(ctx, c, m, namespaces, desired, inventory, pageSize)
+	if guard != nil {
+		orphans = guard(orphans, managed)
+	}
+	for _, orphan := range orphans {
+		logger.Info("Pruning orphaned object", "Kind", orphan.Kind.Kind, "Namespace", orphan.Namespace, "Name", orphan.Name)
+	}
+	DeleteAll(ctx, c, orphans, report)
+}
+
+// Orphans returns a reference to every object labeled as applied by the manager that is not among the desired
+// objects, found as described for Prune, along with the number of managed objects listed.
+func Orphans(ctx context.Context, c Lister, m Manager, namespaces []string, desired []client.Object, inventory []gitops.K8sObjectRef, pageSize int64) (orphans []gitops.K8sObjectRef, managed int) {
+	keep := make(map[string]struct{}, len(desired))
+	kinds := make(map[schema.GroupVersionKind]struct{})
+	for _, obj := range desired {
+		gvk := obj.GetObjectKind().GroupVersionKind()
+		keep[pruneKey(gvk, obj.GetNamespace(), obj.GetName())] = struct{}{}
+		kinds[gvk] = struct{}{}
+	}
+	for _, ref := range inventory {
+		kinds[ref.Kind] = struct{}{}
+	}
+	inScope := make(map[string]bool, len(namespaces))
+	for _, ns := range namespaces {
+		inScope[ns] = true
+	}
+
+	for gvk := range kinds {
+		if gvk.Kind == "" {
+			continue
+		}
+		list := &unstructured.UnstructuredList{}
+		list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+		err := ListPages(ctx, c, list, pageSize, func() error {
+			for _, item := range list.Items {
+				if ns := item.GetNamespace(); ns != "" && !inScope[ns] {
+					continue
+				}
+				managed++
+				if _, ok := keep[pruneKey(gvk, item.GetNamespace(), item.GetName())]; ok {
+					continue
+				}
+				orphans = append(orphans, gitops.K8sObjectRef{Namespace: item.GetNamespace(), Kind: gvk, Name: item.GetName()})
+			}
+			return nil
+		}, client.MatchingLabels(m.Labels()))
+		if err != nil {
+			logger.Error(err, "Failed to list managed objects for pruning", "Kind", gvk)
+		}
+	}
+	return orphans, managed
+}
+
+func pruneKey(gvk schema.GroupVersionKind, namespace, name string) string {
+	return fmt.Sprintf("%s/%s/%s", gvk, namespace, name)
+}
@@ -0,0 +1,98 @@
+package k8sapi
+
+import (
+	"context"
+	"testing"
+
+	"github.com/greymatter-io/operator/pkg/gitops"
+	"github.com/greymatter-io/operator/pkg/wellknown"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestPrune(t *testing.T) {
+	scheme := runtime.NewScheme()
+	assert.NoError(t, clientgoscheme.AddToScheme(scheme))
+
+	m := Manager{Mesh: "mesh"}
+	managed := m.Labels()
+	deployment := func(name string, labels map[string]string) *appsv1.Deployment {
+		return &appsv1.Deployment{
+			TypeMeta:   metav1.TypeMeta{Kind: "Deployment", APIVersion: "apps/v1"},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "greymatter", Labels: labels},
+		}
+	}
+	// Applied by another operator, for another mesh, or for this one in a namespace it no longer watches
+	otherOperator := deployment("other-operator", Manager{OperatorID: "gm-operator-2", Mesh: "mesh"}.Labels())
+	otherMesh := deployment("other-mesh", Manager{Mesh: "other"}.Labels())
+	unwatched := deployment("unwatched", managed)
+	unwatched.Namespace = "apps"
+	orphanedService := &corev1.Service{
+		TypeMeta:   metav1.TypeMeta{Kind: "Service", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{Name: "old", Namespace: "greymatter", Labels: managed},
+	}
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		deployment("control", managed),
+		deployment("orphan", managed),
+		deployment("unmanaged", nil),
+		otherOperator, otherMesh, unwatched,
+		orphanedService,
+	).Build()
+
+	report := gitops.NewSyncReport("")
+	desired := []client.Object{deployment("control", managed)}
+	// Services are only known through the inventory, since none are desired anymore.
+	inventory := []gitops.K8sObjectRef{{Namespace: "greymatter", Kind: orphanedService.GroupVersionKind(), Name: "old"}}
+	// Nothing is deleted while the guard holds the orphans back
+	var guarded int
+	Prune(context.TODO(), c, m, []string{"greymatter"}, desired, inventory, 1, func(orphans []gitops.K8sObjectRef, managed int) []gitops.K8sObjectRef {
+		assert.Len(t, orphans, 2)
+		guarded = managed
+		return nil
+	}, report)
+	assert.Equal(t, 3, guarded)
+	_, deleted, _, _ := report.Summary()
+	assert.Equal(t, 0, deleted)
+
+	Prune(context.TODO(), c, m, []string{"greymatter"}, desired, inventory, 1, nil, report)
+
+	deployments := &appsv1.DeploymentList{}
+	assert.NoError(t, c.List(context.TODO(), deployments))
+	var names []string
+	for _, d := range deployments.Items {
+		names = append(names, d.Name)
+	}
+	assert.ElementsMatch(t, []string{"control", "unmanaged", "other-operator", "other-mesh", "unwatched"}, names)
+
+	services := &corev1.ServiceList{}
+	assert.NoError(t, c.List(context.TODO(), services))
+	assert.Empty(t, services.Items)
+
+	_, deleted, failed, _ := report.Summary()
+	assert.Equal(t, 2, deleted)
+	assert.Equal(t, 0, failed)
+}
+
+func TestNotManaged(t *testing.T) {
+	scheme := runtime.NewScheme()
+	assert.NoError(t, clientgoscheme.AddToScheme(scheme))
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "control", Namespace: "apps", Labels: Manager{Mesh: "mesh"}.Labels()}},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "edge", Namespace: "apps", Labels: map[string]string{wellknown.LABEL_MANAGED_BY: "gm-operator-2"}}},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "apps"}},
+	).Build()
+
+	deployments := &appsv1.DeploymentList{}
+	assert.NoError(t, c.List(context.TODO(), deployments, client.InNamespace("apps"), NotManaged()))
+	if assert.Len(t, deployments.Items, 1) {
+		assert.Equal(t, "app", deployments.Items[0].Name)
+	}
+}
@@ -0,0 +1,293 @@
+// Package logging sets up the operator's structured logs: JSON or console encoding, sampling of repeated entries,
+// and a verbosity for each subsystem that can be changed while the operator runs.
+package logging
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	crzap "sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+const (
+	// FormatJSON encodes each entry as a JSON object.
+	FormatJSON = "json"
+	// FormatConsole encodes each entry as a line of tab-separated text.
+	FormatConsole = "console"
+
+	// DefaultLevel is the key in Levels of the verbosity of logs outside any subsystem.
+	DefaultLevel = "default"
+)
+
+// Subsystems maps the subsystems whose verbosity can be set to the names of their loggers.
+// A subsystem's verbosity also applies to the loggers named under it (e.g. gitops.state is under gitops).
+var Subsystems = map[string]string{
+	"gitops":    "gitops",
+	"gmapi":     "gmapi",
+	"installer": "mesh_install",
+	"state":     "gitops.state",
+}
+
+// Levels maps subsystems (and DefaultLevel) to their verbosity: debug, info, error, or an integer V-level
+// above 1 for more verbose debug logs.
+type Levels map[string]string
+
+// ParseLevels parses comma-delimited subsystem=level pairs, e.g. "default=info,gitops=debug,state=2".
+func ParseLevels(s string) (Levels, error) {
+	levels := make(Levels)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid log level %q: expected subsystem=level", pair)
+		}
+		levels[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return levels, nil
+}
+
+// Options configures the operator's logs.
+type Options struct {
+	// FormatJSON or FormatConsole; defaults to console in development mode, otherwise JSON
+	Format string
+	// Verbosity of each subsystem; subsystems not given log at the DefaultLevel, which defaults to the level of
+	// the -zap-log-level flag if set, debug in development mode, or info
+	Levels Levels
+	// Entries with the same message and level logged each second before only every Nth is; 0 disables sampling.
+	// Debug logs more verbose than V(1) are never sampled.
+	Sampling int
+	// Options from the -zap-* flags
+	Zap crzap.Options
+	// If set, also keeps the latest entries logged
+	Recent *Recent
+}
+
+// New returns a logger configured by the options, and the Verbosity for changing its levels while it runs.
+func New(o Options) (logr.Logger, *Verbosity, error) {
+	zo := o.Zap
+	def := zapcore.InfoLevel
+	if zo.Development {
+		def = zapcore.DebugLevel
+	}
+	if zo.Level != nil {
+		def = lowestEnabled(zo.Level)
+	}
+	v := &Verbosity{def: def, subsystems: make(map[string]zapcore.Level)}
+	if err := v.Set(o.Levels); err != nil {
+		return logr.Logger{}, nil, err
+	}
+
+	switch o.Format {
+	case "":
+	case FormatJSON:
+		zo.NewEncoder, zo.Encoder = jsonEncoder, nil
+	case FormatConsole:
+		zo.NewEncoder, zo.Encoder = consoleEncoder, nil
+	default:
+		return logr.Logger{}, nil, fmt.Errorf("invalid log format %q: expected %s or %s", o.Format, FormatJSON, FormatConsole)
+	}
+	if o.Sampling < 0 {
+		return logr.Logger{}, nil, fmt.Errorf("invalid log sampling %d: must be non-negative", o.Sampling)
+	}
+
+	// Entries are filtered (and sampled) by the filterCore, so the base core, which only knows a single level,
+	// passes everything. This also keeps controller-runtime from adding its own sampler.
+	zo.Level = zap.LevelEnablerFunc(func(zapcore.Level) bool { return true })
+	zo.ZapOpts = append(zo.ZapOpts, zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		if o.Recent != nil {
+			core = zapcore.NewTee(core, o.Recent.core())
+		}
+		fc := &filterCore{Core: core, v: v}
+		if o.Sampling > 0 {
+			fc.sampled = zapcore.NewSamplerWithOptions(core, time.Second, o.Sampling, o.Sampling)
+		}
+		return fc
+	}))
+	return crzap.New(crzap.UseFlagOptions(&zo)), v, nil
+}
+
+// Verbosity holds the levels of a logger returned by New, which may be changed while it runs.
+type Verbosity struct {
+	mu  sync.RWMutex
+	def zapcore.Level
+	// By logger name
+	subsystems map[string]zapcore.Level
+}
+
+// Levels returns the level of each subsystem and the DefaultLevel.
+func (v *Verbosity) Levels() Levels {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	levels := Levels{DefaultLevel: formatLevel(v.def)}
+	for subsystem, name := range Subsystems {
+		level, ok := v.subsystems[name]
+		if !ok {
+			level = v.def
+		}
+		levels[subsystem] = formatLevel(level)
+	}
+	return levels
+}
+
+// Set sets the level of each of the given subsystems, or of the DefaultLevel. An empty level makes a subsystem log
+// at the DefaultLevel again. Other subsystems are unchanged. Nothing is changed if any level is invalid.
+func (v *Verbosity) Set(levels Levels) error {
+	var problems []string
+	parsed := make(map[string]zapcore.Level, len(levels))
+	for subsystem, level := range levels {
+		if _, ok := Subsystems[subsystem]; !ok && subsystem != DefaultLevel {
+			problems = append(problems, fmt.Sprintf("unknown subsystem %q", subsystem))
+			continue
+		}
+		if level == "" && subsystem != DefaultLevel {
+			continue
+		}
+		l, err := parseLevel(level)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", subsystem, err))
+			continue
+		}
+		parsed[subsystem] = l
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("invalid log levels: %s", strings.Join(problems, "; "))
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for subsystem, level := range levels {
+		if subsystem == DefaultLevel {
+			v.def = parsed[subsystem]
+		} else if level == "" {
+			delete(v.subsystems, Subsystems[subsystem])
+		} else {
+			v.subsystems[Subsystems[subsystem]] = parsed[subsystem]
+		}
+	}
+	return nil
+}
+
+// enabled reports whether an entry at the given level from the named logger is logged, by the level of the
+// innermost subsystem it's named under.
+func (v *Verbosity) enabled(name string, level zapcore.Level) bool {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	for ; name != ""; name = parent(name) {
+		if l, ok := v.subsystems[name]; ok {
+			return level >= l
+		}
+	}
+	return level >= v.def
+}
+
+// min returns the most verbose level of any subsystem.
+func (v *Verbosity) min() zapcore.Level {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	min := v.def
+	for _, l := range v.subsystems {
+		if l < min {
+			min = l
+		}
+	}
+	return min
+}
+
+// parent returns the name of the logger a named logger is under, e.g. gitops for gitops.state.
+func parent(name string) string {
+	if idx := strings.LastIndex(name, "."); idx >= 0 {
+		return name[:idx]
+	}
+	return ""
+}
+
+// filterCore drops entries below the level of the subsystem that logged them, and samples the rest if sampling.
+type filterCore struct {
+	zapcore.Core
+	// The Core wrapped in a sampler, or nil if not sampling
+	sampled zapcore.Core
+	v       *Verbosity
+}
+
+func (c *filterCore) Enabled(level zapcore.Level) bool {
+	return level >= c.v.min()
+}
+
+func (c *filterCore) With(fields []zapcore.Field) zapcore.Core {
+	with := &filterCore{Core: c.Core.With(fields), v: c.v}
+	if c.sampled != nil {
+		with.sampled = c.sampled.With(fields)
+	}
+	return with
+}
+
+func (c *filterCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.v.enabled(entry.LoggerName, entry.Level) {
+		return checked
+	}
+	// zap's sampler only counts levels from debug up
+	if c.sampled != nil && entry.Level >= zapcore.DebugLevel {
+		return c.sampled.Check(entry, checked)
+	}
+	return c.Core.Check(entry, checked)
+}
+
+// parseLevel parses a level name, or an integer V-level (e.g. 2 for logger.V(2)).
+func parseLevel(s string) (zapcore.Level, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		if n < 0 {
+			return 0, fmt.Errorf("invalid level %d: must be non-negative", n)
+		}
+		return zapcore.Level(-n), nil
+	}
+	var level zapcore.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("invalid level %q: expected debug, info, error, or an integer", s)
+	}
+	return level, nil
+}
+
+// formatLevel returns the name of a level, or its V-level if it's more verbose than debug.
+func formatLevel(level zapcore.Level) string {
+	if level < zapcore.DebugLevel {
+		return strconv.Itoa(-int(level))
+	}
+	return level.String()
+}
+
+// lowestEnabled returns the most verbose level a LevelEnabler (e.g. from -zap-log-level) enables.
+func lowestEnabled(enabler zapcore.LevelEnabler) zapcore.Level {
+	level := zapcore.FatalLevel
+	for level > -127 && enabler.Enabled(level-1) {
+		level--
+	}
+	return level
+}
+
+// jsonEncoder and consoleEncoder match controller-runtime's production and development encoders.
+func jsonEncoder(opts ...crzap.EncoderConfigOption) zapcore.Encoder {
+	cfg := zap.NewProductionEncoderConfig()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return zapcore.NewJSONEncoder(cfg)
+}
+
+func consoleEncoder(opts ...crzap.EncoderConfigOption) zapcore.Encoder {
+	cfg := zap.NewDevelopmentEncoderConfig()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return zapcore.NewConsoleEncoder(cfg)
+}
@@ -0,0 +1,120 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap/zapcore"
+	crzap "sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+func TestParseLevels(t *testing.T) {
+	levels, err := ParseLevels(" default=error, gitops=debug,state=2,")
+	assert.NoError(t, err)
+	assert.Equal(t, Levels{"default": "error", "gitops": "debug", "state": "2"}, levels)
+
+	_, err = ParseLevels("gitops")
+	assert.Contains(t, fmt.Sprint(err), `invalid log level "gitops"`)
+}
+
+func TestVerbosity(t *testing.T) {
+	v := &Verbosity{subsystems: make(map[string]zapcore.Level)}
+	assert.NoError(t, v.Set(Levels{"gitops": "debug", "state": "3"}))
+	assert.Equal(t, Levels{"default": "info", "gitops": "debug", "gmapi": "info", "installer": "info", "state": "3"}, v.Levels())
+
+	// Loggers are named under their subsystem's
+	assert.True(t, v.enabled("gitops.checkout", -1))
+	assert.False(t, v.enabled("gitops.checkout", -2))
+	assert.True(t, v.enabled("gitops.state", -3))
+	assert.False(t, v.enabled("mesh_install", -1))
+	assert.Equal(t, zapcore.Level(-3), v.min())
+
+	// Invalid levels change nothing
+	err := v.Set(Levels{"default": "error", "gmapi": "loud", "redis": "info"})
+	assert.Contains(t, fmt.Sprint(err), `gmapi: invalid level "loud"`)
+	assert.Contains(t, fmt.Sprint(err), `unknown subsystem "redis"`)
+	assert.Equal(t, "info", v.Levels()[DefaultLevel])
+
+	assert.NoError(t, v.Set(Levels{"default": "error", "state": ""}))
+	assert.Equal(t, "error", v.Levels()["state"])
+	assert.True(t, v.enabled("gitops.state", -1), "still under gitops")
+}
+
+func TestNew(t *testing.T) {
+	var out bytes.Buffer
+	log, v, err := New(Options{Format: FormatJSON, Levels: Levels{"gitops": "debug"}, Sampling: 2, Zap: crzap.Options{Development: true, DestWriter: &out}})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "debug", v.Levels()[DefaultLevel], "development mode defaults to debug")
+	assert.NoError(t, v.Set(Levels{"default": "info"}))
+
+	log.WithName("gitops").V(1).Info("fetching")
+	log.WithName("gmapi").V(1).Info("applying")
+	for i := 0; i < 5; i++ {
+		log.WithName("mesh_install").Info("reconciling")
+	}
+	// Until the level is changed
+	assert.NoError(t, v.Set(Levels{"gmapi": "2"}))
+	log.WithName("gmapi").V(2).Info("applied")
+
+	var messages []string
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var entry struct {
+			Logger string `json:"logger"`
+			Msg    string `json:"msg"`
+		}
+		if assert.NoError(t, json.Unmarshal([]byte(line), &entry), line) {
+			messages = append(messages, entry.Logger+": "+entry.Msg)
+		}
+	}
+	// The repeated entry is sampled: the first two, then every second
+	assert.Equal(t, []string{
+		"gitops: fetching",
+		"mesh_install: reconciling",
+		"mesh_install: reconciling",
+		"mesh_install: reconciling",
+		"gmapi: applied",
+	}, messages)
+
+	_, _, err = New(Options{Format: "xml"})
+	assert.Contains(t, fmt.Sprint(err), `invalid log format "xml"`)
+}
+
+func TestRecent(t *testing.T) {
+	recent := NewRecent(3)
+	log, _, err := New(Options{Format: FormatJSON, Levels: Levels{"default": "info"}, Zap: crzap.Options{DestWriter: &bytes.Buffer{}}, Recent: recent})
+	if !assert.NoError(t, err) {
+		return
+	}
+	messages := func() []string {
+		var out bytes.Buffer
+		_, err := recent.WriteTo(&out)
+		assert.NoError(t, err)
+		var messages []string
+		for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+			var entry struct {
+				Msg  string `json:"msg"`
+				Mesh string `json:"Mesh"`
+			}
+			if assert.NoError(t, json.Unmarshal([]byte(line), &entry), line) {
+				messages = append(messages, entry.Msg+entry.Mesh)
+			}
+		}
+		return messages
+	}
+
+	log.Info("starting")
+	log.V(1).Info("filtered out")
+	log.WithValues("Mesh", "-a").Info("applying")
+	assert.Equal(t, []string{"starting", "applying-a"}, messages())
+
+	// Only the latest are kept
+	log.Info("applied")
+	log.Info("synced")
+	assert.Equal(t, []string{"applying-a", "applied", "synced"}, messages())
+}
@@ -0,0 +1,102 @@
+package logging
+
+import (
+	"io"
+	"sync"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Recent keeps the latest entries logged by a logger returned by New, encoded as JSON lines, e.g. for a support
+// bundle. It keeps only the entries that pass the logger's levels and sampling.
+type Recent struct {
+	mu sync.Mutex
+	// A ring of encoded entries; next is the oldest once it's full
+	lines [][]byte
+	next  int
+	full  bool
+}
+
+// NewRecent returns a Recent that keeps the latest size entries.
+func NewRecent(size int) *Recent {
+	if size < 1 {
+		size = 1
+	}
+	return &Recent{lines: make([][]byte, size)}
+}
+
+// WriteTo writes the kept entries to w, oldest first.
+func (r *Recent) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	lines := append([][]byte{}, r.lines[r.next:]...)
+	if !r.full {
+		lines = nil
+	}
+	lines = append(lines, r.lines[:r.next]...)
+	r.mu.Unlock()
+
+	var written int64
+	for _, line := range lines {
+		n, err := w.Write(line)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+func (r *Recent) add(line []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lines[r.next] = line
+	r.next++
+	if r.next == len(r.lines) {
+		r.next, r.full = 0, true
+	}
+}
+
+// core returns a zapcore.Core that keeps every entry written to it in the Recent.
+func (r *Recent) core() zapcore.Core {
+	cfg := zap.NewProductionEncoderConfig()
+	cfg.EncodeTime = zapcore.ISO8601TimeEncoder
+	return &recentCore{enc: zapcore.NewJSONEncoder(cfg), recent: r}
+}
+
+// recentCore encodes the entries it's given into a Recent; levels are left to the filterCore it's under.
+type recentCore struct {
+	enc    zapcore.Encoder
+	recent *Recent
+}
+
+func (c *recentCore) Enabled(zapcore.Level) bool {
+	return true
+}
+
+func (c *recentCore) With(fields []zapcore.Field) zapcore.Core {
+	enc := c.enc.Clone()
+	for _, field := range fields {
+		field.AddTo(enc)
+	}
+	return &recentCore{enc: enc, recent: c.recent}
+}
+
+func (c *recentCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	return checked.AddCore(entry, c)
+}
+
+func (c *recentCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	buf, err := c.enc.EncodeEntry(entry, fields)
+	if err != nil {
+		return err
+	}
+	line := append([]byte{}, buf.Bytes()...)
+	buf.Free()
+	c.recent.add(line)
+	return nil
+}
+
+func (c *recentCore) Sync() error {
+	return nil
+}
@@ -0,0 +1,149 @@
+package mesh_install
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/greymatter-io/operator/pkg/gitops"
+	"github.com/greymatter-io/operator/pkg/wellknown"
+	appsv1 "k8s.io/api/apps/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// AdoptedWorkloads returns the pre-existing workloads adopted into THE mesh and not rolled back.
+func (i *Installer) AdoptedWorkloads() []gitops.AdoptedWorkload {
+	if i.Sync == nil || i.Sync.SyncState == nil {
+		return nil
+	}
+	return i.Sync.SyncState.DerivedDefaults().AdoptedWorkloads
+}
+
+// Adoption returns the record of a workload's adoption, if it was adopted.
+func (i *Installer) Adoption(kind, namespace, name string) (gitops.AdoptedWorkload, bool) {
+	for _, adopted := range i.AdoptedWorkloads() {
+		if adopted.Kind == kind && adopted.Namespace == namespace && adopted.Name == name {
+			return adopted, true
+		}
+	}
+	return gitops.AdoptedWorkload{}, false
+}
+
+// RecordAdoption persists the record of a workload's adoption, replacing any earlier one.
+func (i *Installer) RecordAdoption(adopted gitops.AdoptedWorkload) {
+	if i.Sync == nil || i.Sync.SyncState == nil {
+		logger.Info("Not recording adoption since sync state has not been initialized", "Kind", adopted.Kind, "Namespace", adopted.Namespace, "Name", adopted.Name)
+		return
+	}
+	i.Sync.SyncState.UpdateDerivedDefaults(func(dd *gitops.DerivedDefaults) bool {
+		dd.AdoptedWorkloads = append(withoutAdoption(dd.AdoptedWorkloads, adopted.Kind, adopted.Namespace, adopted.Name), adopted)
+		sort.Slice(dd.AdoptedWorkloads, func(a, b int) bool {
+			x, y := dd.AdoptedWorkloads[a], dd.AdoptedWorkloads[b]
+			if x.Namespace != y.Namespace {
+				return x.Namespace < y.Namespace
+			}
+			if x.Name != y.Name {
+				return x.Name < y.Name
+			}
+			return x.Kind < y.Kind
+		})
+		return true
+	})
+}
+
+// ForgetAdoption removes the record of a workload's adoption, e.g. once it's rolled back or the workload is deleted.
+func (i *Installer) ForgetAdoption(kind, namespace, name string) {
+	if i.Sync == nil || i.Sync.SyncState == nil {
+		return
+	}
+	i.Sync.SyncState.UpdateDerivedDefaults(func(dd *gitops.DerivedDefaults) bool {
+		remaining := withoutAdoption(dd.AdoptedWorkloads, kind, namespace, name)
+		if len(remaining) == len(dd.AdoptedWorkloads) {
+			return false
+		}
+		dd.AdoptedWorkloads = remaining
+		return true
+	})
+}
+
+func withoutAdoption(adopted []gitops.AdoptedWorkload, kind, namespace, name string) []gitops.AdoptedWorkload {
+	var remaining []gitops.AdoptedWorkload
+	for _, a := range adopted {
+		if a.Kind != kind || a.Namespace != namespace || a.Name != name {
+			remaining = append(remaining, a)
+		}
+	}
+	return remaining
+}
+
+// AdoptWorkload adopts a pre-existing Deployment or StatefulSet into THE mesh, injecting a sidecar to the given port.
+// It annotates the workload for adoption, and the workload webhook (which refuses workloads it can't adopt) does the
+// rest, so adoption is the same whether it's requested here or with the annotation.
+func (i *Installer) AdoptWorkload(ctx context.Context, kind, namespace, name, port string) error {
+	if err := ValidateAdoptionPort(port); err != nil {
+		return err
+	}
+	if err := i.checkAdoptable(namespace); err != nil {
+		return err
+	}
+	if _, ok := i.Adoption(kind, namespace, name); ok {
+		return fmt.Errorf("%s %s/%s is already adopted", kind, namespace, name)
+	}
+	return i.annotateForAdoption(ctx, kind, namespace, name, port)
+}
+
+// RollbackAdoption rolls back a workload's adoption, removing what it added, through the workload webhook like
+// AdoptWorkload.
+func (i *Installer) RollbackAdoption(ctx context.Context, kind, namespace, name string) error {
+	if _, ok := i.Adoption(kind, namespace, name); !ok {
+		return fmt.Errorf("%s %s/%s was not adopted", kind, namespace, name)
+	}
+	return i.annotateForAdoption(ctx, kind, namespace, name, wellknown.ADOPT_ROLLBACK)
+}
+
+// ValidateAdoptionPort checks that a workload is adopted with the port of its container to inject a sidecar to.
+func ValidateAdoptionPort(port string) error {
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("port must be a port number, not %q", port)
+	}
+	return nil
+}
+
+// checkAdoptable checks that THE mesh is applied and watches the namespace, so the workload webhook will handle an
+// adoption in it.
+func (i *Installer) checkAdoptable(namespace string) error {
+	mesh := i.CurrentMesh()
+	if mesh == nil || mesh.Name == "" || mesh.UID == "" {
+		return fmt.Errorf("no mesh is applied to adopt workloads into")
+	}
+	for _, ns := range meshNamespaces(mesh) {
+		if ns == namespace {
+			return nil
+		}
+	}
+	return fmt.Errorf("namespace %s is not watched by mesh %s", namespace, mesh.Name)
+}
+
+// annotateForAdoption sets the adoption annotation on a Deployment or StatefulSet.
+func (i *Installer) annotateForAdoption(ctx context.Context, kind, namespace, name, value string) error {
+	var obj client.Object
+	switch kind {
+	case "Deployment":
+		obj = &appsv1.Deployment{}
+	case "StatefulSet":
+		obj = &appsv1.StatefulSet{}
+	default:
+		return fmt.Errorf("kind must be Deployment or StatefulSet, not %q", kind)
+	}
+	if err := i.K8sClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, obj); err != nil {
+		return err
+	}
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[wellknown.ANNOTATION_ADOPT] = value
+	obj.SetAnnotations(annotations)
+	return i.K8sClient.Update(ctx, obj)
+}
@@ -0,0 +1,74 @@
+package mesh_install
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/greymatter-io/operator/pkg/gitops"
+	"github.com/greymatter-io/operator/pkg/wellknown"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestRecordAdoption(t *testing.T) {
+	i, _ := newTestInstaller(t)
+	i.Sync.SyncState = &gitops.SyncState{}
+
+	i.RecordAdoption(gitops.AdoptedWorkload{Kind: "Deployment", Namespace: "apps", Name: "web", Port: "8080"})
+	i.RecordAdoption(gitops.AdoptedWorkload{Kind: "StatefulSet", Namespace: "apps", Name: "db", Port: "5432"})
+	i.RecordAdoption(gitops.AdoptedWorkload{Kind: "Deployment", Namespace: "apps", Name: "web", Port: "9090"})
+	adopted := i.AdoptedWorkloads()
+	assert.Len(t, adopted, 2)
+	assert.Equal(t, "db", adopted[0].Name)
+	assert.Equal(t, "9090", adopted[1].Port)
+
+	w, ok := i.Adoption("Deployment", "apps", "web")
+	assert.True(t, ok)
+	assert.Equal(t, "9090", w.Port)
+
+	i.ForgetAdoption("Deployment", "apps", "web")
+	_, ok = i.Adoption("Deployment", "apps", "web")
+	assert.False(t, ok)
+	assert.Len(t, i.AdoptedWorkloads(), 1)
+}
+
+func TestAdoptWorkload(t *testing.T) {
+	i, c := newTestInstaller(t,
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "apps"}},
+	)
+	i.Sync.SyncState = &gitops.SyncState{}
+	ctx := context.TODO()
+
+	err := i.AdoptWorkload(ctx, "Deployment", "apps", "web", "8080")
+	assert.Contains(t, fmt.Sprint(err), "no mesh is applied")
+
+	i.Mesh = &v1alpha1.Mesh{
+		ObjectMeta: metav1.ObjectMeta{Name: "mesh-sample", UID: "1234"},
+		Spec:       v1alpha1.MeshSpec{InstallNamespace: "greymatter", WatchNamespaces: []string{"apps"}},
+	}
+	err = i.AdoptWorkload(ctx, "Deployment", "legacy", "web", "8080")
+	assert.Contains(t, fmt.Sprint(err), "not watched")
+	err = i.AdoptWorkload(ctx, "Deployment", "apps", "web", "http")
+	assert.Contains(t, fmt.Sprint(err), "port must be a port number")
+	err = i.AdoptWorkload(ctx, "DaemonSet", "apps", "web", "8080")
+	assert.Contains(t, fmt.Sprint(err), "kind must be Deployment or StatefulSet")
+
+	assert.NoError(t, i.AdoptWorkload(ctx, "Deployment", "apps", "web", "8080"))
+	deployment := &appsv1.Deployment{}
+	assert.NoError(t, c.Get(ctx, client.ObjectKey{Namespace: "apps", Name: "web"}, deployment))
+	assert.Equal(t, "8080", deployment.Annotations[wellknown.ANNOTATION_ADOPT])
+
+	// Rolled back once the webhook has recorded it
+	err = i.RollbackAdoption(ctx, "Deployment", "apps", "web")
+	assert.Contains(t, fmt.Sprint(err), "was not adopted")
+	i.RecordAdoption(gitops.AdoptedWorkload{Kind: "Deployment", Namespace: "apps", Name: "web", Port: "8080"})
+	err = i.AdoptWorkload(ctx, "Deployment", "apps", "web", "8080")
+	assert.Contains(t, fmt.Sprint(err), "already adopted")
+	assert.NoError(t, i.RollbackAdoption(ctx, "Deployment", "apps", "web"))
+	assert.NoError(t, c.Get(ctx, client.ObjectKey{Namespace: "apps", Name: "web"}, deployment))
+	assert.Equal(t, wellknown.ADOPT_ROLLBACK, deployment.Annotations[wellknown.ANNOTATION_ADOPT])
+}
@@ -0,0 +1,36 @@
+package mesh_install
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/greymatter-io/operator/pkg/gmapi"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// configureAPIAuth sets the client credentials the greymatter CLI logs in to Control and Catalog with from
+// config.api_auth, read from its Secret in the gm-operator namespace.
+func (i *Installer) configureAPIAuth(ctx context.Context) error {
+	conf := i.Config.APIAuth
+	if conf.TokenURL == "" {
+		return nil
+	}
+	secret := &corev1.Secret{}
+	key := client.ObjectKey{Namespace: "gm-operator", Name: conf.CredentialsSecret}
+	if err := i.K8sClient.Get(ctx, key, secret); err != nil {
+		return fmt.Errorf("failed to read API client credentials from Secret %s/%s: %w", key.Namespace, key.Name, err)
+	}
+	creds := gmapi.APICredentials{
+		TokenURL:     conf.TokenURL,
+		ClientID:     string(secret.Data["client_id"]),
+		ClientSecret: string(secret.Data["client_secret"]),
+		Scopes:       conf.Scopes,
+	}
+	if creds.ClientID == "" || creds.ClientSecret == "" {
+		return fmt.Errorf("secret %s/%s must have client_id and client_secret keys", key.Namespace, key.Name)
+	}
+	i.SetAPICredentials(creds)
+	logger.Info("Configured API login", "TokenURL", conf.TokenURL, "Secret", conf.CredentialsSecret)
+	return nil
+}
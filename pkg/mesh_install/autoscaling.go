@@ -0,0 +1,135 @@
+package mesh_install
+
+import (
+	"context"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Kind of the VerticalPodAutoscalers generated to recommend core components' resource requests.
+var vpaGVK = schema.GroupVersionKind{Group: "autoscaling.k8s.io", Version: "v1", Kind: "VerticalPodAutoscaler"}
+
+// The target CPU utilization of an autoscaled core component if the Mesh sets no target.
+const defaultTargetCPUUtilization = 80
+
+// componentAutoscaling returns the Mesh's autoscaling of the core component whose Deployment or StatefulSet has the
+// given name, if any.
+func componentAutoscaling(mesh *v1alpha1.Mesh, workload string) *v1alpha1.ComponentAutoscaling {
+	if mesh == nil || mesh.Spec.Autoscaling == nil {
+		return nil
+	}
+	return map[string]*v1alpha1.ComponentAutoscaling{
+		cuemodule.ComponentWorkloads["control"]: mesh.Spec.Autoscaling.Control,
+		cuemodule.ComponentWorkloads["edge"]:    mesh.Spec.Autoscaling.Edge,
+	}[workload]
+}
+
+// mkAutoscalingObjects returns a HorizontalPodAutoscaler, and if asked for a recommendation-only
+// VerticalPodAutoscaler, for each core component workload among the manifests that the Mesh autoscales. The
+// workloads' replicas are set to their minimum, to be replaced with those of their live versions by
+// keepAutoscaledReplicas.
+func mkAutoscalingObjects(mesh *v1alpha1.Mesh, manifestObjects []client.Object) []client.Object {
+	var objects []client.Object
+	for _, obj := range manifestObjects {
+		autoscaling := componentAutoscaling(mesh, obj.GetName())
+		replicas := workloadReplicas(obj)
+		if autoscaling == nil || replicas == nil {
+			continue
+		}
+		minReplicas := int32(1)
+		if autoscaling.MinReplicas != nil {
+			minReplicas = *autoscaling.MinReplicas
+		}
+		workloadMin := minReplicas
+		*replicas = &workloadMin
+		kind := "Deployment"
+		if _, ok := obj.(*appsv1.StatefulSet); ok {
+			kind = "StatefulSet"
+		}
+
+		var metrics []autoscalingv2.MetricSpec
+		for _, target := range []struct {
+			resource    corev1.ResourceName
+			utilization *int32
+		}{{corev1.ResourceCPU, autoscaling.TargetCPUUtilization}, {corev1.ResourceMemory, autoscaling.TargetMemoryUtilization}} {
+			if target.utilization != nil {
+				metrics = append(metrics, resourceMetric(target.resource, *target.utilization))
+			}
+		}
+		if len(metrics) == 0 {
+			metrics = append(metrics, resourceMetric(corev1.ResourceCPU, defaultTargetCPUUtilization))
+		}
+		objects = append(objects, &autoscalingv2.HorizontalPodAutoscaler{
+			TypeMeta:   metav1.TypeMeta{Kind: "HorizontalPodAutoscaler", APIVersion: "autoscaling/v2"},
+			ObjectMeta: metav1.ObjectMeta{Name: obj.GetName(), Namespace: obj.GetNamespace()},
+			Spec: autoscalingv2.HorizontalPodAutoscalerSpec{
+				ScaleTargetRef: autoscalingv2.CrossVersionObjectReference{APIVersion: "apps/v1", Kind: kind, Name: obj.GetName()},
+				MinReplicas:    &minReplicas,
+				MaxReplicas:    autoscaling.MaxReplicas,
+				Metrics:        metrics,
+			},
+		})
+
+		if autoscaling.VPARecommendations {
+			vpa := &unstructured.Unstructured{Object: map[string]interface{}{
+				"metadata": map[string]interface{}{"name": obj.GetName(), "namespace": obj.GetNamespace()},
+				"spec": map[string]interface{}{
+					"targetRef":    map[string]interface{}{"apiVersion": "apps/v1", "kind": kind, "name": obj.GetName()},
+					"updatePolicy": map[string]interface{}{"updateMode": "Off"},
+				},
+			}}
+			vpa.SetGroupVersionKind(vpaGVK)
+			objects = append(objects, vpa)
+		}
+	}
+	return objects
+}
+
+func resourceMetric(name corev1.ResourceName, utilization int32) autoscalingv2.MetricSpec {
+	return autoscalingv2.MetricSpec{
+		Type: autoscalingv2.ResourceMetricSourceType,
+		Resource: &autoscalingv2.ResourceMetricSource{
+			Name:   name,
+			Target: autoscalingv2.MetricTarget{Type: autoscalingv2.UtilizationMetricType, AverageUtilization: &utilization},
+		},
+	}
+}
+
+// keepAutoscaledReplicas sets the replicas of the autoscaled core component workloads among the manifests to those
+// of their live versions, if they're within the Mesh's bounds, so applying them doesn't undo their autoscaling.
+func (i *Installer) keepAutoscaledReplicas(ctx context.Context, mesh *v1alpha1.Mesh, manifestObjects []client.Object) {
+	for _, obj := range manifestObjects {
+		autoscaling := componentAutoscaling(mesh, obj.GetName())
+		replicas := workloadReplicas(obj)
+		if autoscaling == nil || replicas == nil || *replicas == nil {
+			continue
+		}
+		live := obj.DeepCopyObject().(client.Object)
+		if err := i.K8sClient.Get(ctx, client.ObjectKeyFromObject(obj), live); err != nil {
+			continue
+		}
+		if liveReplicas := *workloadReplicas(live); liveReplicas != nil && *liveReplicas >= **replicas && *liveReplicas <= autoscaling.MaxReplicas {
+			kept := *liveReplicas
+			*replicas = &kept
+		}
+	}
+}
+
+// workloadReplicas returns the replicas field of a Deployment or StatefulSet, or nil if it's neither.
+func workloadReplicas(obj client.Object) **int32 {
+	switch workload := obj.(type) {
+	case *appsv1.Deployment:
+		return &workload.Spec.Replicas
+	case *appsv1.StatefulSet:
+		return &workload.Spec.Replicas
+	}
+	return nil
+}
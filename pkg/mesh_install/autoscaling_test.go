@@ -0,0 +1,82 @@
+package mesh_install
+
+import (
+	"context"
+	"testing"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestMkAutoscalingObjects(t *testing.T) {
+	one, three, memory := int32(1), int32(3), int32(70)
+	manifests := func() []client.Object {
+		return []client.Object{
+			&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "controlensemble", Namespace: "greymatter"}, Spec: appsv1.StatefulSetSpec{Replicas: &one}},
+			&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "edge", Namespace: "greymatter"}, Spec: appsv1.DeploymentSpec{Replicas: &one}},
+			&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "catalog", Namespace: "greymatter"}, Spec: appsv1.DeploymentSpec{Replicas: &one}},
+		}
+	}
+	mesh := &v1alpha1.Mesh{Spec: v1alpha1.MeshSpec{Autoscaling: &v1alpha1.Autoscaling{
+		Control: &v1alpha1.ComponentAutoscaling{MaxReplicas: 3},
+		Edge:    &v1alpha1.ComponentAutoscaling{MinReplicas: &three, MaxReplicas: 10, TargetMemoryUtilization: &memory, VPARecommendations: true},
+	}}}
+
+	assert.Empty(t, mkAutoscalingObjects(&v1alpha1.Mesh{}, manifests()))
+
+	objects := manifests()
+	generated := mkAutoscalingObjects(mesh, objects)
+	if assert.Len(t, generated, 3) {
+		control := generated[0].(*autoscalingv2.HorizontalPodAutoscaler)
+		assert.Equal(t, autoscalingv2.CrossVersionObjectReference{APIVersion: "apps/v1", Kind: "StatefulSet", Name: "controlensemble"}, control.Spec.ScaleTargetRef)
+		assert.Equal(t, int32(1), *control.Spec.MinReplicas)
+		assert.Equal(t, int32(3), control.Spec.MaxReplicas)
+		if assert.Len(t, control.Spec.Metrics, 1) {
+			assert.Equal(t, corev1.ResourceCPU, control.Spec.Metrics[0].Resource.Name)
+			assert.Equal(t, int32(defaultTargetCPUUtilization), *control.Spec.Metrics[0].Resource.Target.AverageUtilization)
+		}
+
+		edge := generated[1].(*autoscalingv2.HorizontalPodAutoscaler)
+		assert.Equal(t, "greymatter", edge.Namespace)
+		assert.Equal(t, int32(3), *edge.Spec.MinReplicas)
+		if assert.Len(t, edge.Spec.Metrics, 1) {
+			assert.Equal(t, corev1.ResourceMemory, edge.Spec.Metrics[0].Resource.Name)
+			assert.Equal(t, memory, *edge.Spec.Metrics[0].Resource.Target.AverageUtilization)
+		}
+
+		vpa := generated[2].(*unstructured.Unstructured)
+		assert.Equal(t, vpaGVK, vpa.GroupVersionKind())
+		mode, _, _ := unstructured.NestedString(vpa.Object, "spec", "updatePolicy", "updateMode")
+		assert.Equal(t, "Off", mode)
+	}
+	assert.Equal(t, int32(3), *objects[1].(*appsv1.Deployment).Spec.Replicas)
+	assert.Equal(t, int32(1), *objects[2].(*appsv1.Deployment).Spec.Replicas)
+}
+
+func TestKeepAutoscaledReplicas(t *testing.T) {
+	five, twenty := int32(5), int32(20)
+	i, _ := newTestInstaller(t,
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "edge", Namespace: "greymatter"}, Spec: appsv1.DeploymentSpec{Replicas: &five}},
+		&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "controlensemble", Namespace: "greymatter"}, Spec: appsv1.StatefulSetSpec{Replicas: &twenty}},
+	)
+	mesh := &v1alpha1.Mesh{Spec: v1alpha1.MeshSpec{Autoscaling: &v1alpha1.Autoscaling{
+		Control: &v1alpha1.ComponentAutoscaling{MaxReplicas: 3},
+		Edge:    &v1alpha1.ComponentAutoscaling{MaxReplicas: 10},
+	}}}
+	manifests := []client.Object{
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "edge", Namespace: "greymatter"}},
+		&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "controlensemble", Namespace: "greymatter"}},
+	}
+	mkAutoscalingObjects(mesh, manifests)
+
+	i.keepAutoscaledReplicas(context.Background(), mesh, manifests)
+	assert.Equal(t, int32(5), *manifests[0].(*appsv1.Deployment).Spec.Replicas)
+	// Live replicas beyond the Mesh's bounds aren't kept
+	assert.Equal(t, int32(1), *manifests[1].(*appsv1.StatefulSet).Spec.Replicas)
+}
@@ -0,0 +1,96 @@
+package mesh_install
+
+import (
+	"strings"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	schedulingv1 "k8s.io/api/scheduling/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// mkAvailabilityObjects gives the core component workloads among the manifests the PriorityClass of
+// config.availability, unless the CUE or the Mesh's overrides give them their own, and returns that PriorityClass
+// (unless it's built in) and a PodDisruptionBudget for each of them, to be applied ahead of the workloads.
+func mkAvailabilityObjects(config cuemodule.AvailabilityConfig, mesh *v1alpha1.Mesh, manifestObjects []client.Object) []client.Object {
+	if !config.Enabled {
+		return nil
+	}
+	className := config.PriorityClassName
+	if className == "" {
+		className = cuemodule.DefaultPriorityClassName
+	}
+	core := make(map[string]bool)
+	for _, workload := range cuemodule.ComponentWorkloads {
+		core[workload] = true
+	}
+
+	var budgets []client.Object
+	classUsed := false
+	for _, obj := range manifestObjects {
+		if !core[obj.GetName()] {
+			continue
+		}
+		var spec *corev1.PodSpec
+		var selector *metav1.LabelSelector
+		switch workload := obj.(type) {
+		case *appsv1.Deployment:
+			spec, selector = &workload.Spec.Template.Spec, workload.Spec.Selector
+		case *appsv1.StatefulSet:
+			spec, selector = &workload.Spec.Template.Spec, workload.Spec.Selector
+		default:
+			continue
+		}
+		if spec.PriorityClassName == "" {
+			spec.PriorityClassName = className
+		}
+		classUsed = classUsed || spec.PriorityClassName == className
+		if selector == nil {
+			continue
+		}
+		budgets = append(budgets, mkPodDisruptionBudget(config, cuemodule.WorkloadOverride(mesh.Spec.Overrides, obj.GetName()), obj, selector))
+	}
+
+	// Built-in classes (and only those) are prefixed with "system-", and can't be created
+	if !classUsed || strings.HasPrefix(className, "system-") {
+		return budgets
+	}
+	value := config.PriorityValue
+	if value == 0 {
+		value = cuemodule.DefaultPriorityValue
+	}
+	class := &schedulingv1.PriorityClass{
+		TypeMeta:    metav1.TypeMeta{Kind: "PriorityClass", APIVersion: "scheduling.k8s.io/v1"},
+		ObjectMeta:  metav1.ObjectMeta{Name: className},
+		Value:       value,
+		Description: "Core components of Grey Matter meshes",
+	}
+	return append([]client.Object{class}, budgets...)
+}
+
+// mkPodDisruptionBudget returns the PodDisruptionBudget of a core component's workload, from its override if that
+// sets a budget, or otherwise from config.availability.
+func mkPodDisruptionBudget(config cuemodule.AvailabilityConfig, override *v1alpha1.ComponentOverride, workload client.Object, selector *metav1.LabelSelector) *policyv1.PodDisruptionBudget {
+	minAvailable, maxUnavailable := config.MinAvailable, config.MaxUnavailable
+	if override != nil && override.PodDisruptionBudget != nil && (override.PodDisruptionBudget.MinAvailable != nil || override.PodDisruptionBudget.MaxUnavailable != nil) {
+		minAvailable, maxUnavailable = override.PodDisruptionBudget.MinAvailable, override.PodDisruptionBudget.MaxUnavailable
+	}
+	if minAvailable == nil && maxUnavailable == nil {
+		one := intstr.FromInt(1)
+		maxUnavailable = &one
+	}
+	return &policyv1.PodDisruptionBudget{
+		TypeMeta:   metav1.TypeMeta{Kind: "PodDisruptionBudget", APIVersion: "policy/v1"},
+		ObjectMeta: metav1.ObjectMeta{Name: workload.GetName(), Namespace: workload.GetNamespace()},
+		Spec: policyv1.PodDisruptionBudgetSpec{
+			Selector:       selector.DeepCopy(),
+			MinAvailable:   minAvailable,
+			MaxUnavailable: maxUnavailable,
+		},
+	}
+}
@@ -0,0 +1,61 @@
+package mesh_install
+
+import (
+	"testing"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	schedulingv1 "k8s.io/api/scheduling/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestMkAvailabilityObjects(t *testing.T) {
+	selector := &metav1.LabelSelector{MatchLabels: map[string]string{"greymatter.io/cluster": "catalog"}}
+	manifests := func() []client.Object {
+		return []client.Object{
+			&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "catalog", Namespace: "greymatter"}, Spec: appsv1.DeploymentSpec{Selector: selector}},
+			&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "controlensemble", Namespace: "greymatter"}, Spec: appsv1.StatefulSetSpec{
+				Selector: selector,
+				Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{PriorityClassName: "system-cluster-critical"}},
+			}},
+			// Only core components get them
+			&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "dashboard", Namespace: "greymatter"}, Spec: appsv1.DeploymentSpec{Selector: selector}},
+		}
+	}
+	twoPods := intstr.FromInt(2)
+	mesh := &v1alpha1.Mesh{Spec: v1alpha1.MeshSpec{Overrides: &v1alpha1.ComponentOverrides{
+		Control: &v1alpha1.ComponentOverride{PodDisruptionBudget: &v1alpha1.PodDisruptionBudgetOverride{MinAvailable: &twoPods}},
+	}}}
+
+	objects := manifests()
+	assert.Empty(t, mkAvailabilityObjects(cuemodule.AvailabilityConfig{}, mesh, objects))
+	assert.Empty(t, objects[0].(*appsv1.Deployment).Spec.Template.Spec.PriorityClassName)
+
+	generated := mkAvailabilityObjects(cuemodule.AvailabilityConfig{Enabled: true}, mesh, objects)
+	if assert.Len(t, generated, 3) {
+		class := generated[0].(*schedulingv1.PriorityClass)
+		assert.Equal(t, "greymatter-core", class.Name)
+		assert.Equal(t, int32(1000000), class.Value)
+
+		catalog := generated[1].(*policyv1.PodDisruptionBudget)
+		assert.Equal(t, "catalog", catalog.Name)
+		assert.Equal(t, selector, catalog.Spec.Selector)
+		assert.Equal(t, intstr.FromInt(1), *catalog.Spec.MaxUnavailable)
+		control := generated[2].(*policyv1.PodDisruptionBudget)
+		assert.Nil(t, control.Spec.MaxUnavailable)
+		assert.Equal(t, twoPods, *control.Spec.MinAvailable)
+	}
+	assert.Equal(t, "greymatter-core", objects[0].(*appsv1.Deployment).Spec.Template.Spec.PriorityClassName)
+	assert.Equal(t, "system-cluster-critical", objects[1].(*appsv1.StatefulSet).Spec.Template.Spec.PriorityClassName)
+	assert.Empty(t, objects[2].(*appsv1.Deployment).Spec.Template.Spec.PriorityClassName)
+
+	// Built-in classes aren't created
+	generated = mkAvailabilityObjects(cuemodule.AvailabilityConfig{Enabled: true, PriorityClassName: "system-node-critical"}, mesh, manifests())
+	assert.Len(t, generated, 2)
+}
@@ -0,0 +1,304 @@
+package mesh_install
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	"github.com/greymatter-io/operator/pkg/gitops"
+	"github.com/greymatter-io/operator/pkg/k8sapi"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// The resources whose requests are budgeted.
+var budgetedResources = []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory}
+
+// requests are CPU and memory requests, in thousandths of a core or byte, so they can be summed and compared.
+type requests map[corev1.ResourceName]int64
+
+func (r requests) add(other requests, times int64) {
+	for _, name := range budgetedResources {
+		r[name] += other[name] * times
+	}
+}
+
+// exceeds returns the first resource that r requests more of than is available, if any.
+func (r requests) exceeds(available requests) (corev1.ResourceName, bool) {
+	for _, name := range budgetedResources {
+		if r[name] > available[name] {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// quantity formats an amount of a budgeted resource.
+func quantity(name corev1.ResourceName, milli int64) string {
+	if name == corev1.ResourceMemory {
+		return resource.NewQuantity(milli/1000, resource.BinarySI).String()
+	}
+	return resource.NewMilliQuantity(milli, resource.DecimalSI).String()
+}
+
+func listRequests(list corev1.ResourceList) requests {
+	r := requests{}
+	for _, name := range budgetedResources {
+		if q, ok := list[name]; ok {
+			r[name] = q.MilliValue()
+		}
+	}
+	return r
+}
+
+// podRequests returns what the scheduler reserves for a pod: the sum of its containers' requests, or the largest of
+// its init containers' if that's more, plus its overhead.
+func podRequests(spec corev1.PodSpec) requests {
+	r := requests{}
+	for _, container := range spec.Containers {
+		r.add(listRequests(container.Resources.Requests), 1)
+	}
+	for _, container := range spec.InitContainers {
+		init := listRequests(container.Resources.Requests)
+		for _, name := range budgetedResources {
+			if init[name] > r[name] {
+				r[name] = init[name]
+			}
+		}
+	}
+	r.add(listRequests(spec.Overhead), 1)
+	return r
+}
+
+// workloadRequests returns the requests of each of a Deployment's or StatefulSet's pods, and how many it runs.
+func workloadRequests(obj client.Object) (requests, int64, bool) {
+	replicas := int64(1)
+	var spec corev1.PodSpec
+	switch workload := obj.(type) {
+	case *appsv1.Deployment:
+		if workload.Spec.Replicas != nil {
+			replicas = int64(*workload.Spec.Replicas)
+		}
+		spec = workload.Spec.Template.Spec
+	case *appsv1.StatefulSet:
+		if workload.Spec.Replicas != nil {
+			replicas = int64(*workload.Spec.Replicas)
+		}
+		spec = workload.Spec.Template.Spec
+	default:
+		return nil, 0, false
+	}
+	return podRequests(spec), replicas, true
+}
+
+// budgetProblem is a reason the workloads among a sync's manifests won't fit, with the object it concerns.
+type budgetProblem struct {
+	kind, namespace, name string
+	problem               string
+}
+
+// checkResourceBudget compares the CPU and memory requested by the workloads among a sync's manifests, beyond what
+// their live versions already request, with what's left in their namespaces' ResourceQuotas and on the cluster's
+// schedulable Linux nodes, so a mesh that can't be scheduled is reported before it's applied rather than left with
+// Pending pods. With config.resource_budget "enforce", each problem is recorded in the sync report and an error
+// listing them is returned; otherwise they're only logged and emitted as an event on the mesh.
+func (i *Installer) checkResourceBudget(ctx context.Context, mesh *v1alpha1.Mesh, manifestObjects []client.Object, report *gitops.SyncReport) error {
+	mode := i.Config.ResourceBudget
+	if mode == cuemodule.ResourceBudgetOff {
+		return nil
+	}
+	problems := i.resourceBudgetProblems(ctx, manifestObjects)
+	if len(problems) == 0 {
+		return nil
+	}
+
+	var lines []string
+	for _, p := range problems {
+		if mode == cuemodule.ResourceBudgetEnforce {
+			report.Record("k8s", p.kind, p.namespace, p.name, "budget", errors.New(p.problem))
+		}
+		lines = append(lines, p.problem)
+	}
+	err := fmt.Errorf("core components request more than the cluster has left:\n  %s", strings.Join(lines, "\n  "))
+	if mode == cuemodule.ResourceBudgetEnforce {
+		err = fmt.Errorf("refusing to apply Kubernetes manifests: %w", err)
+		logger.Error(err, "Sync failed", "Mesh", mesh.Name)
+	} else {
+		logger.Error(err, "Applying anyway; pods may stay Pending", "Mesh", mesh.Name)
+	}
+	if i.recorder != nil && mesh.UID != "" {
+		i.recorder.Event(mesh, corev1.EventTypeWarning, "InsufficientResources", err.Error())
+	}
+	if mode == cuemodule.ResourceBudgetEnforce {
+		return err
+	}
+	return nil
+}
+
+// resourceBudgetProblems returns the reasons the workloads among the manifests won't fit, if any. Budgets that
+// can't be read (e.g. because nodes can't be listed) aren't checked.
+func (i *Installer) resourceBudgetProblems(ctx context.Context, manifestObjects []client.Object) []budgetProblem {
+	type growth struct {
+		obj    client.Object
+		perPod requests
+	}
+	// What each namespace's workloads request beyond their live versions, and the workloads whose pods each request
+	// more than their live ones, which must each fit on a node
+	byNamespace := make(map[string]requests)
+	var grown []growth
+	for _, obj := range manifestObjects {
+		perPod, replicas, ok := workloadRequests(obj)
+		if !ok {
+			continue
+		}
+		delta := requests{}
+		delta.add(perPod, replicas)
+		livePerPod := requests{}
+		live := obj.DeepCopyObject().(client.Object)
+		if err := i.K8sClient.Get(ctx, client.ObjectKeyFromObject(obj), live); err == nil {
+			var liveReplicas int64
+			livePerPod, liveReplicas, _ = workloadRequests(live)
+			delta.add(livePerPod, -liveReplicas)
+		}
+		if byNamespace[obj.GetNamespace()] == nil {
+			byNamespace[obj.GetNamespace()] = requests{}
+		}
+		byNamespace[obj.GetNamespace()].add(delta, 1)
+		if _, more := perPod.exceeds(livePerPod); more {
+			grown = append(grown, growth{obj, perPod})
+		}
+	}
+
+	var problems []budgetProblem
+	total := requests{}
+	var namespaces []string
+	for ns, delta := range byNamespace {
+		namespaces = append(namespaces, ns)
+		total.add(delta, 1)
+	}
+	sort.Strings(namespaces)
+	for _, ns := range namespaces {
+		problems = append(problems, i.quotaProblems(ctx, ns, byNamespace[ns])...)
+	}
+
+	if _, more := total.exceeds(requests{}); !more && len(grown) == 0 {
+		return problems
+	}
+	free, err := i.freeNodeCapacity(ctx)
+	if err != nil {
+		logger.Error(err, "Not checking core components' requests against the cluster's nodes")
+		return problems
+	}
+	if len(free) == 0 {
+		return problems
+	}
+	clusterFree := requests{}
+	for _, node := range free {
+		clusterFree.add(node, 1)
+	}
+	if name, more := total.exceeds(clusterFree); more {
+		problems = append(problems, budgetProblem{kind: "Node", problem: fmt.Sprintf("the cluster's schedulable Linux nodes have %s %s free, but %s more is requested",
+			quantity(name, clusterFree[name]), name, quantity(name, total[name]))})
+	}
+	for _, g := range grown {
+		fits := false
+		for _, node := range free {
+			if _, more := g.perPod.exceeds(node); !more {
+				fits = true
+				break
+			}
+		}
+		if !fits {
+			kind := g.obj.GetObjectKind().GroupVersionKind().Kind
+			problems = append(problems, budgetProblem{kind: kind, namespace: g.obj.GetNamespace(), name: g.obj.GetName(),
+				problem: fmt.Sprintf("%s %s/%s: no schedulable Linux node has the %s CPU and %s memory each of its pods requests free", kind, g.obj.GetNamespace(), g.obj.GetName(),
+					quantity(corev1.ResourceCPU, g.perPod[corev1.ResourceCPU]), quantity(corev1.ResourceMemory, g.perPod[corev1.ResourceMemory]))})
+		}
+	}
+	return problems
+}
+
+// quotaProblems returns the ResourceQuotas of a namespace with less CPU or memory left than its workloads request
+// beyond their live versions.
+func (i *Installer) quotaProblems(ctx context.Context, namespace string, delta requests) []budgetProblem {
+	if _, more := delta.exceeds(requests{}); !more {
+		return nil
+	}
+	quotas := &corev1.ResourceQuotaList{}
+	if err := i.K8sClient.List(ctx, quotas, client.InNamespace(namespace)); err != nil {
+		logger.Error(err, "Not checking core components' requests against ResourceQuotas", "Namespace", namespace)
+		return nil
+	}
+	var problems []budgetProblem
+	for _, quota := range quotas.Items {
+		hard := quota.Status.Hard
+		if hard == nil {
+			hard = quota.Spec.Hard
+		}
+		for _, name := range budgetedResources {
+			// A quota on cpu or memory is one on their requests
+			for _, key := range []corev1.ResourceName{"requests." + name, name} {
+				limit, ok := hard[key]
+				if !ok {
+					continue
+				}
+				used := quota.Status.Used[key]
+				left := limit.MilliValue() - used.MilliValue()
+				if delta[name] > left {
+					problems = append(problems, budgetProblem{kind: "ResourceQuota", namespace: namespace, name: quota.Name,
+						problem: fmt.Sprintf("ResourceQuota %s/%s has %s of %s left, but %s more is requested", namespace, quota.Name, quantity(name, left), key, quantity(name, delta[name]))})
+				}
+				break
+			}
+		}
+	}
+	return problems
+}
+
+// freeNodeCapacity returns the CPU and memory left on each of the cluster's schedulable, ready Linux nodes: their
+// allocatable resources less the requests of the pods running on them.
+func (i *Installer) freeNodeCapacity(ctx context.Context) (map[string]requests, error) {
+	free := make(map[string]requests)
+	nodes := &corev1.NodeList{}
+	if err := k8sapi.ListPages(ctx, i.K8sClient, nodes, i.Config.Reconcile.PageSize, func() error {
+		for _, node := range nodes.Items {
+			if os := node.Labels[corev1.LabelOSStable]; node.Spec.Unschedulable || (os != "" && os != "linux") {
+				continue
+			}
+			ready := false
+			for _, cond := range node.Status.Conditions {
+				ready = ready || (cond.Type == corev1.NodeReady && cond.Status == corev1.ConditionTrue)
+			}
+			if ready {
+				free[node.Name] = listRequests(node.Status.Allocatable)
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+	if len(free) == 0 {
+		return free, nil
+	}
+
+	pods := &corev1.PodList{}
+	if err := k8sapi.ListPages(ctx, i.K8sClient, pods, i.Config.Reconcile.PageSize, func() error {
+		for _, pod := range pods.Items {
+			node, ok := free[pod.Spec.NodeName]
+			if !ok || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+				continue
+			}
+			node.add(podRequests(pod.Spec), -1)
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to list pods: %w", err)
+	}
+	return free, nil
+}
@@ -0,0 +1,81 @@
+package mesh_install
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	"github.com/greymatter-io/operator/pkg/gitops"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestCheckResourceBudget(t *testing.T) {
+	resources := func(cpu, memory string) corev1.ResourceList {
+		return corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpu), corev1.ResourceMemory: resource.MustParse(memory)}
+	}
+	node := func(name, cpu, memory string) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"kubernetes.io/os": "linux"}},
+			Status: corev1.NodeStatus{
+				Allocatable: resources(cpu, memory),
+				Conditions:  []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+			},
+		}
+	}
+	deployment := func(name string, replicas int32, cpu, memory string) *appsv1.Deployment {
+		return &appsv1.Deployment{
+			TypeMeta:   metav1.TypeMeta{Kind: "Deployment", APIVersion: "apps/v1"},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "greymatter"},
+			Spec: appsv1.DeploymentSpec{Replicas: &replicas, Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: name, Resources: corev1.ResourceRequirements{Requests: resources(cpu, memory)}}},
+			}}},
+		}
+	}
+	mesh := &v1alpha1.Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh"}}
+
+	i, _ := newTestInstaller(t,
+		node("a", "2", "4Gi"),
+		node("b", "2", "4Gi"),
+		// Half of a's CPU is taken
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "busy", Namespace: "apps"}, Spec: corev1.PodSpec{NodeName: "a", Containers: []corev1.Container{
+			{Resources: corev1.ResourceRequirements{Requests: resources("1", "0")}},
+		}}},
+		// The live catalog already requests what its manifest does
+		deployment("catalog", 1, "1", "1Gi"),
+		&corev1.ResourceQuota{
+			ObjectMeta: metav1.ObjectMeta{Name: "compute", Namespace: "greymatter"},
+			Spec:       corev1.ResourceQuotaSpec{Hard: corev1.ResourceList{"requests.memory": resource.MustParse("3Gi")}},
+			Status: corev1.ResourceQuotaStatus{
+				Hard: corev1.ResourceList{"requests.memory": resource.MustParse("3Gi")},
+				Used: corev1.ResourceList{"requests.memory": resource.MustParse("1Gi")},
+			},
+		},
+	)
+
+	// What fits is applied
+	report := gitops.NewSyncReport("")
+	fits := []client.Object{deployment("catalog", 1, "1", "1Gi"), deployment("control", 1, "1", "1Gi"), &corev1.Service{}}
+	i.Config.ResourceBudget = cuemodule.ResourceBudgetEnforce
+	assert.NoError(t, i.checkResourceBudget(context.TODO(), mesh, fits, report))
+
+	// What doesn't is refused when enforced, with each problem recorded
+	tooBig := []client.Object{deployment("catalog", 1, "1", "1Gi"), deployment("control", 2, "1", "1Gi"), deployment("edge", 1, "3", "512Mi")}
+	err := i.checkResourceBudget(context.TODO(), mesh, tooBig, report)
+	assert.Contains(t, fmt.Sprint(err), "ResourceQuota greymatter/compute has 2Gi of requests.memory left, but 2560Mi more is requested")
+	assert.Contains(t, fmt.Sprint(err), "the cluster's schedulable Linux nodes have 3 cpu free, but 5 more is requested")
+	assert.Contains(t, fmt.Sprint(err), "Deployment greymatter/edge: no schedulable Linux node has the 3 CPU and 512Mi memory each of its pods requests free")
+	assert.NotContains(t, fmt.Sprint(err), "Deployment greymatter/control")
+	_, _, failed, _ := report.Summary()
+	assert.Equal(t, 3, failed)
+
+	// And only warned of otherwise
+	i.Config.ResourceBudget = ""
+	assert.NoError(t, i.checkResourceBudget(context.TODO(), mesh, tooBig, gitops.NewSyncReport("")))
+}
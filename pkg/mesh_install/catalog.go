@@ -0,0 +1,131 @@
+package mesh_install
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cloudflare/cfssl/csr"
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	"github.com/greymatter-io/operator/pkg/gmapi"
+	"github.com/greymatter-io/operator/pkg/k8sapi"
+	"github.com/greymatter-io/operator/pkg/wellknown"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// The name of the Catalog prune loop in config.reconcile.disabled.
+	reconcilerCatalogPrune = "catalog_prune"
+	// How often Catalog services of deleted workloads are looked for, unless config.reconcile.interval_seconds is set.
+	catalogPrunePollInterval = time.Minute
+	// The key of config.catalog_api.token_secret holding the bearer token.
+	catalogTokenKey = "token"
+)
+
+// configureCatalogAuth sets how the greymatter CLI connects to Catalog and authenticates to it from
+// config.catalog_api, issuing the operator a client certificate if it's to present one.
+func (i *Installer) configureCatalogAuth(ctx context.Context) error {
+	conf := i.Config.CatalogAPI
+	if conf == (cuemodule.CatalogAPIConfig{}) {
+		return nil
+	}
+	auth := gmapi.CatalogAuth{URL: conf.URL, CABundle: []byte(conf.CABundle)}
+	if conf.ClientCertificate {
+		cert, key, err := i.cfssl.RequestCert(csr.CertificateRequest{
+			CN:         "gm-operator",
+			KeyRequest: &csr.KeyRequest{A: "ecdsa", S: 256},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to issue Catalog client certificate: %w", err)
+		}
+		auth.Cert, auth.Key = cert, key
+	}
+	if conf.TokenSecret != "" {
+		secret := &corev1.Secret{}
+		if err := i.K8sClient.Get(ctx, client.ObjectKey{Namespace: "gm-operator", Name: conf.TokenSecret}, secret); err != nil {
+			return fmt.Errorf("failed to get Catalog token Secret gm-operator/%s: %w", conf.TokenSecret, err)
+		}
+		token, ok := secret.Data[catalogTokenKey]
+		if !ok {
+			return fmt.Errorf("Catalog token Secret gm-operator/%s has no key %q", conf.TokenSecret, catalogTokenKey)
+		}
+		auth.Token = strings.TrimSpace(string(token))
+	}
+	if err := i.SetCatalogAuth(auth, filepath.Join(os.TempDir(), "gm-operator-catalog")); err != nil {
+		return err
+	}
+	logger.Info("Configured Catalog API connection", "URL", conf.URL, "ClientCertificate", conf.ClientCertificate, "Token", conf.TokenSecret != "")
+	return nil
+}
+
+// pruneCatalogWorkloads periodically deletes the Catalog services of workloads that were deleted while the operator
+// wasn't watching, until the context or the mesh client is cancelled.
+func (i *Installer) pruneCatalogWorkloads(ctx context.Context) {
+	ticker := time.NewTicker(i.Config.Reconcile.Interval(catalogPrunePollInterval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if i.Client == nil {
+			continue
+		}
+		select {
+		case <-i.Client.Ctx.Done():
+			logger.Info("greymatter client context cancelled - stopping reconciliation loop")
+			return
+		default:
+		}
+
+		workloads, err := i.listSidecarWorkloads(ctx)
+		if err != nil {
+			// Acting on a partial view would delete the services of workloads that still exist
+			logger.Error(err, "Failed to list workloads to prune Catalog - will retry")
+			continue
+		}
+		if pruned := i.Client.PruneCatalogWorkloads(ctx, workloads); len(pruned) > 0 {
+			logger.Info("Deleted Catalog services of deleted workloads", "Workloads", pruned)
+		}
+	}
+}
+
+// listSidecarWorkloads returns the names of the Deployments and StatefulSets with an injected sidecar across all of
+// the mesh's namespaces. It fails if any namespace can't be listed, rather than returning a partial list.
+func (i *Installer) listSidecarWorkloads(ctx context.Context) (map[string]bool, error) {
+	namespaces := meshNamespaces(i.CurrentMesh())
+
+	workloads := make(map[string]bool)
+	add := func(name string, tmpl corev1.PodTemplateSpec) {
+		if _, ok := tmpl.Annotations[wellknown.ANNOTATION_INJECT_SIDECAR_TO_PORT]; ok {
+			workloads[name] = true
+		}
+	}
+	for _, ns := range namespaces {
+		deployments := &appsv1.DeploymentList{}
+		if err := k8sapi.ListPages(ctx, i.K8sClient, deployments, i.Config.Reconcile.PageSize, func() error {
+			for _, d := range deployments.Items {
+				add(d.Name, d.Spec.Template)
+			}
+			return nil
+		}, client.InNamespace(ns)); err != nil {
+			return nil, fmt.Errorf("failed to list deployments in namespace %s: %w", ns, err)
+		}
+		statefulsets := &appsv1.StatefulSetList{}
+		if err := k8sapi.ListPages(ctx, i.K8sClient, statefulsets, i.Config.Reconcile.PageSize, func() error {
+			for _, s := range statefulsets.Items {
+				add(s.Name, s.Spec.Template)
+			}
+			return nil
+		}, client.InNamespace(ns)); err != nil {
+			return nil, fmt.Errorf("failed to list statefulsets in namespace %s: %w", ns, err)
+		}
+	}
+	return workloads, nil
+}
@@ -0,0 +1,223 @@
+package mesh_install
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	"github.com/greymatter-io/operator/pkg/k8sapi"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// The name of the edge certificate loop in config.reconcile.disabled.
+	reconcilerCertificates = "certificates"
+	// How often edge certificates are checked, unless config.reconcile.interval_seconds is set.
+	certificateCheckInterval = time.Minute
+	// Annotation of the edge's pod template with the revisions of its certificates, which restarts it on renewal.
+	certificateRevisionAnnotation = "greymatter.io/certificate-revisions"
+)
+
+// Kind of the cert-manager Certificates requested for edge hosts.
+var certificateGVK = schema.GroupVersionKind{Group: "cert-manager.io", Version: "v1", Kind: "Certificate"}
+
+// certificateTracker tracks the edge certificates last checked.
+type certificateTracker struct {
+	mu sync.Mutex
+	// The EdgeCertificatesReady condition last set on the mesh
+	status  metav1.ConditionStatus
+	reason  string
+	message string
+	// Revision of each certificate, by name, as of the last check
+	revisions map[string]int64
+}
+
+// mkEdgeCertificates returns a cert-manager Certificate for each TLS Secret of the edge hosts, for all the hosts
+// using it, if config.ingress.certificates.issuer is set. cert-manager writes the issued certificate to the Secret,
+// and renews it there before it expires.
+func mkEdgeCertificates(config cuemodule.IngressConfig, namespace string, hosts []cuemodule.EdgeHost) []client.Object {
+	if config.Certificates.Issuer == "" {
+		return nil
+	}
+	issuerKind := config.Certificates.IssuerKind
+	if issuerKind == "" {
+		issuerKind = "ClusterIssuer"
+	}
+
+	var secrets []string
+	dnsNames := make(map[string][]interface{})
+	for _, host := range hosts {
+		if host.TLSSecret == "" {
+			continue
+		}
+		if _, ok := dnsNames[host.TLSSecret]; !ok {
+			secrets = append(secrets, host.TLSSecret)
+		}
+		dnsNames[host.TLSSecret] = append(dnsNames[host.TLSSecret], host.Host)
+	}
+
+	var objects []client.Object
+	for _, secret := range secrets {
+		spec := map[string]interface{}{
+			"secretName": secret,
+			"dnsNames":   dnsNames[secret],
+			"issuerRef": map[string]interface{}{
+				"name":  config.Certificates.Issuer,
+				"kind":  issuerKind,
+				"group": certificateGVK.Group,
+			},
+		}
+		if config.Certificates.RenewBeforeHours > 0 {
+			spec["renewBefore"] = fmt.Sprintf("%dh", config.Certificates.RenewBeforeHours)
+		}
+		cert := &unstructured.Unstructured{Object: map[string]interface{}{
+			"metadata": map[string]interface{}{"name": secret, "namespace": namespace},
+			"spec":     spec,
+		}}
+		cert.SetGroupVersionKind(certificateGVK)
+		objects = append(objects, cert)
+	}
+	return objects
+}
+
+// certificateStatus returns whether a Certificate is ready, and if not, why not; its expiry, if issued; and its
+// revision, which cert-manager increments each time it's issued.
+func certificateStatus(cert *unstructured.Unstructured, now time.Time) (ready bool, problem string, notAfter time.Time, revision int64) {
+	revision, _, _ = unstructured.NestedInt64(cert.Object, "status", "revision")
+	if s, ok, _ := unstructured.NestedString(cert.Object, "status", "notAfter"); ok {
+		notAfter, _ = time.Parse(time.RFC3339, s)
+	}
+	conditions, _, _ := unstructured.NestedSlice(cert.Object, "status", "conditions")
+	for _, c := range conditions {
+		cond, ok := c.(map[string]interface{})
+		if !ok || cond["type"] != "Ready" {
+			continue
+		}
+		if cond["status"] == "True" {
+			ready = true
+		} else {
+			problem = fmt.Sprintf("%v", cond["message"])
+		}
+	}
+	switch {
+	case !notAfter.IsZero() && !now.Before(notAfter):
+		return false, fmt.Sprintf("expired at %s", notAfter.UTC().Format(time.RFC3339)), notAfter, revision
+	case !ready && problem == "":
+		problem = "not yet issued"
+	}
+	return ready, problem, notAfter, revision
+}
+
+// checkCertificates periodically reports the readiness and expiry of the edge certificates in the mesh's
+// EdgeCertificatesReady condition and in metrics, and restarts the edge when they're renewed if
+// config.ingress.certificates.restart_edge is set.
+func (i *Installer) checkCertificates(ctx context.Context) {
+	ticker := time.NewTicker(i.Config.Reconcile.Interval(certificateCheckInterval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if mesh := i.CurrentMesh(); mesh != nil && mesh.UID != "" {
+			i.reportCertificates(ctx, mesh, time.Now())
+		}
+	}
+}
+
+// reportCertificates checks the edge certificates in the install namespace, reporting them and restarting the edge
+// if any were renewed.
+func (i *Installer) reportCertificates(ctx context.Context, mesh *v1alpha1.Mesh, now time.Time) {
+	namespace := mesh.Spec.InstallNamespace
+	certs := &unstructured.UnstructuredList{}
+	certs.SetGroupVersionKind(certificateGVK.GroupVersion().WithKind(certificateGVK.Kind + "List"))
+	listCtx, cancel := k8sapi.WithTimeout(ctx)
+	defer cancel()
+	if err := i.K8sClient.List(listCtx, certs, client.InNamespace(namespace),
+		client.MatchingLabels(i.manager(mesh).Labels())); err != nil {
+		logger.Error(err, "Failed to list edge certificates; is cert-manager installed?", "Namespace", namespace)
+		return
+	}
+
+	var problems []string
+	revisions := make(map[string]int64)
+	edgeCertificateReady.Reset()
+	edgeCertificateExpiry.Reset()
+	for idx := range certs.Items {
+		cert := &certs.Items[idx]
+		ready, problem, notAfter, revision := certificateStatus(cert, now)
+		value := 0.0
+		if ready {
+			value = 1
+		} else {
+			problems = append(problems, fmt.Sprintf("%s: %s", cert.GetName(), problem))
+		}
+		edgeCertificateReady.WithLabelValues(cert.GetNamespace(), cert.GetName()).Set(value)
+		if !notAfter.IsZero() {
+			edgeCertificateExpiry.WithLabelValues(cert.GetNamespace(), cert.GetName()).Set(float64(notAfter.Unix()))
+		}
+		revisions[cert.GetName()] = revision
+	}
+	sort.Strings(problems)
+
+	status, reason, message := metav1.ConditionTrue, "CertificatesReady", fmt.Sprintf("All %d edge certificates are issued", len(certs.Items))
+	if len(problems) > 0 {
+		status, reason, message = metav1.ConditionFalse, "CertificatesNotReady", strings.Join(problems, "; ")
+	}
+	i.certificates.mu.Lock()
+	changed := status != i.certificates.status || reason != i.certificates.reason || message != i.certificates.message
+	renewed := false
+	for name, revision := range revisions {
+		if prev, ok := i.certificates.revisions[name]; ok && revision > prev {
+			renewed = true
+		}
+	}
+	i.certificates.status, i.certificates.reason, i.certificates.message = status, reason, message
+	i.certificates.revisions = revisions
+	i.certificates.mu.Unlock()
+
+	if changed {
+		i.setMeshCondition(v1alpha1.ConditionEdgeCertificatesReady, status, reason, message)
+	}
+	if renewed && i.Config.Ingress.Certificates.RestartEdge {
+		i.restartEdge(ctx, namespace, revisions)
+	}
+}
+
+// restartEdge rolls out the edge Deployment by annotating its pod template with the revisions of its certificates.
+func (i *Installer) restartEdge(ctx context.Context, namespace string, revisions map[string]int64) {
+	var names []string
+	for name := range revisions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var annotation []string
+	for _, name := range names {
+		annotation = append(annotation, fmt.Sprintf("%s=%d", name, revisions[name]))
+	}
+
+	edge := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "edge", Namespace: namespace}}
+	err := k8sapi.Apply(ctx, i.K8sClient, edge, nil, k8sapi.MkPatchAction(func(obj client.Object) client.Object {
+		deployment := obj.(*appsv1.Deployment)
+		if deployment.Spec.Template.Annotations == nil {
+			deployment.Spec.Template.Annotations = make(map[string]string)
+		}
+		deployment.Spec.Template.Annotations[certificateRevisionAnnotation] = strings.Join(annotation, ",")
+		return deployment
+	}))
+	if err != nil {
+		logger.Error(err, "Failed to restart the edge after its certificates were renewed", "Namespace", namespace)
+		return
+	}
+	logger.Info("Restarted the edge after its certificates were renewed", "Namespace", namespace)
+}
@@ -0,0 +1,117 @@
+package mesh_install
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	"github.com/greymatter-io/operator/pkg/k8sapi"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestMkEdgeCertificates(t *testing.T) {
+	hosts := []cuemodule.EdgeHost{
+		{Service: "catalog", Host: "catalog.mesh.example.com", TLSSecret: "mesh-tls"},
+		{Service: "dashboard", Host: "dashboard.mesh.example.com", TLSSecret: "mesh-tls"},
+		{Service: "fibonacci", Host: "fibonacci.mesh.example.com", TLSSecret: "fibonacci-tls"},
+		{Service: "plain", Host: "plain.mesh.example.com"},
+	}
+	assert.Empty(t, mkEdgeCertificates(cuemodule.IngressConfig{}, "greymatter", hosts))
+
+	config := cuemodule.IngressConfig{Certificates: cuemodule.CertificatesConfig{Issuer: "letsencrypt", RenewBeforeHours: 360}}
+	certs := mkEdgeCertificates(config, "greymatter", hosts)
+	if assert.Len(t, certs, 2) {
+		cert := certs[0].(*unstructured.Unstructured)
+		assert.Equal(t, certificateGVK, cert.GroupVersionKind())
+		assert.Equal(t, "greymatter", cert.GetNamespace())
+		assert.Equal(t, "mesh-tls", cert.GetName())
+		dnsNames, _, _ := unstructured.NestedStringSlice(cert.Object, "spec", "dnsNames")
+		assert.Equal(t, []string{"catalog.mesh.example.com", "dashboard.mesh.example.com"}, dnsNames)
+		issuer, _, _ := unstructured.NestedStringMap(cert.Object, "spec", "issuerRef")
+		assert.Equal(t, map[string]string{"name": "letsencrypt", "kind": "ClusterIssuer", "group": "cert-manager.io"}, issuer)
+		renewBefore, _, _ := unstructured.NestedString(cert.Object, "spec", "renewBefore")
+		assert.Equal(t, "360h", renewBefore)
+
+		secret, _, _ := unstructured.NestedString(certs[1].(*unstructured.Unstructured).Object, "spec", "secretName")
+		assert.Equal(t, "fibonacci-tls", secret)
+	}
+}
+
+func TestReportCertificates(t *testing.T) {
+	now := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	certificate := func(name string, ready bool, message, notAfter string, revision int64) *unstructured.Unstructured {
+		status := "False"
+		if ready {
+			status = "True"
+		}
+		cert := &unstructured.Unstructured{Object: map[string]interface{}{
+			"metadata": map[string]interface{}{"name": name, "namespace": "greymatter"},
+			"status": map[string]interface{}{
+				"conditions": []interface{}{map[string]interface{}{"type": "Ready", "status": status, "message": message}},
+				"notAfter":   notAfter,
+				"revision":   revision,
+			},
+		}}
+		cert.SetGroupVersionKind(certificateGVK)
+		k8sapi.MarkManaged(cert, k8sapi.Manager{Mesh: "greymatter-mesh"})
+		return cert
+	}
+	edge := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "edge", Namespace: "greymatter"}}
+
+	i, c := newTestInstaller(t, append(startObjects(), edge,
+		certificate("mesh-tls", true, "", "2022-08-01T00:00:00Z", 1),
+		certificate("fibonacci-tls", false, "Issuing certificate as Secret does not exist", "", 0),
+	)...)
+	i.Config.Ingress.Certificates.RestartEdge = true
+	i.Mesh.UID = "mesh-uid"
+	assert.NoError(t, c.Create(context.TODO(), i.Mesh))
+	condition := func() *metav1.Condition {
+		live := &v1alpha1.Mesh{}
+		assert.NoError(t, c.Get(context.TODO(), client.ObjectKeyFromObject(i.Mesh), live))
+		return meta.FindStatusCondition(live.Status.Conditions, v1alpha1.ConditionEdgeCertificatesReady)
+	}
+	restarted := func() string {
+		live := &appsv1.Deployment{}
+		assert.NoError(t, c.Get(context.TODO(), client.ObjectKeyFromObject(edge), live))
+		return live.Spec.Template.Annotations[certificateRevisionAnnotation]
+	}
+
+	i.reportCertificates(context.TODO(), i.Mesh, now)
+	if cond := condition(); assert.NotNil(t, cond) {
+		assert.Equal(t, metav1.ConditionFalse, cond.Status)
+		assert.Equal(t, "fibonacci-tls: Issuing certificate as Secret does not exist", cond.Message)
+	}
+	assert.Equal(t, 1.0, testutil.ToFloat64(edgeCertificateReady.WithLabelValues("greymatter", "mesh-tls")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(edgeCertificateReady.WithLabelValues("greymatter", "fibonacci-tls")))
+	assert.Equal(t, float64(time.Date(2022, 8, 1, 0, 0, 0, 0, time.UTC).Unix()),
+		testutil.ToFloat64(edgeCertificateExpiry.WithLabelValues("greymatter", "mesh-tls")))
+	assert.Empty(t, restarted())
+
+	// Once issued, the edge is restarted to pick up the new certificate
+	issued := certificate("fibonacci-tls", true, "", "2022-08-15T00:00:00Z", 1)
+	live := issued.DeepCopy()
+	assert.NoError(t, c.Get(context.TODO(), client.ObjectKeyFromObject(issued), live))
+	issued.SetResourceVersion(live.GetResourceVersion())
+	assert.NoError(t, c.Update(context.TODO(), issued))
+	i.reportCertificates(context.TODO(), i.Mesh, now)
+	if cond := condition(); assert.NotNil(t, cond) {
+		assert.Equal(t, metav1.ConditionTrue, cond.Status)
+		assert.Equal(t, "All 2 edge certificates are issued", cond.Message)
+	}
+	assert.Equal(t, "fibonacci-tls=1,mesh-tls=1", restarted())
+
+	// Certificates that weren't renewed in time are reported once they expire
+	i.reportCertificates(context.TODO(), i.Mesh, time.Date(2022, 8, 2, 0, 0, 0, 0, time.UTC))
+	if cond := condition(); assert.NotNil(t, cond) {
+		assert.Equal(t, metav1.ConditionFalse, cond.Status)
+		assert.Equal(t, "mesh-tls: expired at 2022-08-01T00:00:00Z", cond.Message)
+	}
+}
@@ -0,0 +1,38 @@
+package mesh_install
+
+import (
+	"context"
+	"time"
+
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+)
+
+const (
+	// The name of the drift enforcement loop in config.reconcile.disabled.
+	reconcilerDrift = "drift"
+	// How often the mesh is reapplied with the drift_enforcement feature on, unless config.reconcile.interval_seconds
+	// is set.
+	driftInterval = 5 * time.Minute
+)
+
+// enforceDrift periodically reapplies the mesh while the drift_enforcement feature is on, restoring the Kubernetes
+// objects changed out of band since it was last applied, until the context is cancelled.
+func (i *Installer) enforceDrift(ctx context.Context) {
+	ticker := time.NewTicker(i.Config.Reconcile.Interval(driftInterval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !i.FeatureEnabled(cuemodule.FeatureDriftEnforcement) {
+			continue
+		}
+		if mesh := i.CurrentMesh(); mesh != nil && mesh.UID != "" {
+			if err := i.ApplyMesh(ctx, mesh, mesh.DeepCopy()); err != nil {
+				logger.Error(err, "Failed to reapply the mesh to restore drifted objects - will retry", "Mesh", mesh.Name)
+			}
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package mesh_install
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	"github.com/greymatter-io/operator/pkg/k8sapi"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// configureEdgeAuth sets the authentication and authorization added to the edge listener of the given CUE from the
+// mesh's spec.edge_auth, reading the OIDC client's credentials from their Secret in the install namespace.
+// If they can't be read, it's left as it was, so the edge isn't left open.
+func (i *Installer) configureEdgeAuth(ctx context.Context, operatorCUE *cuemodule.OperatorCUE, mesh *v1alpha1.Mesh) error {
+	spec := mesh.Spec.EdgeAuth
+	if spec == nil || (spec.OIDC == nil && spec.ExtAuthz == nil) {
+		operatorCUE.SetEdgeAuth(nil)
+		return nil
+	}
+
+	auth := &cuemodule.EdgeAuth{EdgeAuth: *spec.DeepCopy()}
+	if spec.OIDC != nil {
+		secret := &corev1.Secret{}
+		key := client.ObjectKey{Namespace: mesh.Spec.InstallNamespace, Name: spec.OIDC.CredentialsSecret}
+		getCtx, cancel := k8sapi.WithTimeout(ctx)
+		err := i.K8sClient.Get(getCtx, key, secret)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("failed to read the edge's OIDC client credentials from Secret %s/%s: %w", key.Namespace, key.Name, err)
+		}
+		auth.ClientID, auth.ClientSecret = string(secret.Data["client_id"]), string(secret.Data["client_secret"])
+		if auth.ClientID == "" || auth.ClientSecret == "" {
+			return fmt.Errorf("secret %s/%s must have client_id and client_secret keys", key.Namespace, key.Name)
+		}
+	}
+	operatorCUE.SetEdgeAuth(auth)
+	return nil
+}
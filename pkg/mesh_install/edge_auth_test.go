@@ -0,0 +1,44 @@
+package mesh_install
+
+import (
+	"context"
+	"testing"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestConfigureEdgeAuth(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "edge-oidc", Namespace: "greymatter"},
+		Data:       map[string][]byte{"client_id": []byte("edge"), "client_secret": []byte("s3cret")},
+	}
+	i, _ := newTestInstaller(t, append(startObjects(), secret)...)
+	i.OperatorCUE.GM = cuemodule.FromStrings(`mesh_configs: [{listener_key: "edge", zone_key: "default-zone"}]`)
+
+	mesh := i.Mesh.DeepCopy()
+	mesh.Spec.EdgeAuth = &v1alpha1.EdgeAuth{OIDC: &v1alpha1.OIDCAuth{
+		IssuerURL:         "https://keycloak.example.com/auth/realms/greymatter",
+		CredentialsSecret: "edge-oidc",
+		ServiceURL:        "https://edge.example.com",
+	}}
+	assert.NoError(t, i.configureEdgeAuth(context.TODO(), i.OperatorCUE, mesh))
+	configs, _, err := i.OperatorCUE.ExtractCoreMeshConfigs()
+	assert.NoError(t, err)
+	assert.Equal(t, "s3cret", gjson.GetBytes(configs[0], "http_filters.gm_oidc-authentication.clientSecret").String())
+
+	// Missing credentials leave the edge's auth as it was
+	mesh.Spec.EdgeAuth.OIDC.CredentialsSecret = "missing"
+	assert.Error(t, i.configureEdgeAuth(context.TODO(), i.OperatorCUE, mesh))
+	configs, _, _ = i.OperatorCUE.ExtractCoreMeshConfigs()
+	assert.True(t, gjson.GetBytes(configs[0], "http_filters.gm_oidc-authentication").Exists())
+
+	mesh.Spec.EdgeAuth = nil
+	assert.NoError(t, i.configureEdgeAuth(context.TODO(), i.OperatorCUE, mesh))
+	configs, _, _ = i.OperatorCUE.ExtractCoreMeshConfigs()
+	assert.False(t, gjson.GetBytes(configs[0], "http_filters").Exists())
+}
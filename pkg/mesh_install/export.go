@@ -0,0 +1,181 @@
+package mesh_install
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	"github.com/greymatter-io/operator/pkg/gitops"
+	"github.com/greymatter-io/operator/pkg/gmapi"
+	"github.com/greymatter-io/operator/pkg/k8sapi"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// DerivedConfig returns the core Grey Matter config objects currently derived from CUE
+// (including defaults the operator derives at runtime), along with their kinds.
+func (i *Installer) DerivedConfig() ([]json.RawMessage, []string, error) {
+	operatorCUE := i.CurrentOperatorCUE()
+	if operatorCUE == nil {
+		return nil, nil, errors.New("operator CUE has not been loaded")
+	}
+	tempOperatorCUE, err := operatorCUE.TempGMValueUnifiedWithDefaults(i.CurrentDefaults())
+	if err != nil {
+		return nil, nil, err
+	}
+	return tempOperatorCUE.ExtractCoreMeshConfigs()
+}
+
+// Render returns the core K8s manifests and Grey Matter config objects rendered from the CUE currently checked out,
+// unified with THE mesh (or the CUE's default mesh if none has been applied), as ApplyMesh would apply them.
+// Unlike ApplyMesh, it loads its own copy of the CUE, so it neither waits on nor affects an apply.
+func (i *Installer) Render() ([]client.Object, []json.RawMessage, []string, error) {
+	mesh := i.CurrentMesh()
+	operatorCUE, initialMesh, err := cuemodule.LoadAll(i.CurrentCueRoot(), i.CUEOptions...)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to load CUE: %w", err)
+	}
+	if mesh == nil {
+		mesh = initialMesh
+	}
+	return i.render(operatorCUE, mesh)
+}
+
+// Simulate returns what a sync cycle would create, change, and delete if the config tree at cueRoot were checked out,
+// relative to the operator's current state, without applying anything. The mesh is rendered from the tree as a
+// sync cycle would render it, but with the applied mesh's disabled components, config_version, and edge auth, which
+// are only switched when a mesh is applied.
+func (i *Installer) Simulate(cueRoot string) (gitops.SimulatedChanges, error) {
+	if i.Sync == nil || i.Sync.SyncState == nil {
+		return gitops.SimulatedChanges{}, errors.New("sync state has not been initialized")
+	}
+	operatorCUE, mesh, err := cuemodule.LoadAll(cueRoot, i.CUEOptions...)
+	if err != nil {
+		return gitops.SimulatedChanges{}, fmt.Errorf("failed to load CUE: %w", err)
+	}
+	if current := i.CurrentMesh(); current != nil {
+		withLiveMeshValues(current, mesh)
+	}
+	manifests, configs, kinds, err := i.render(operatorCUE, mesh)
+	if err != nil {
+		return gitops.SimulatedChanges{}, err
+	}
+	return i.Sync.SyncState.Simulate(manifests, configs, kinds), nil
+}
+
+// render returns the core K8s manifests and Grey Matter config objects rendered from the given CUE, unified with the
+// given mesh, with the settings of the mesh last applied, such as its edge auth.
+func (i *Installer) render(operatorCUE *cuemodule.OperatorCUE, mesh *v1alpha1.Mesh) ([]client.Object, []json.RawMessage, []string, error) {
+	if current := i.CurrentOperatorCUE(); current != nil {
+		operatorCUE.CopyMeshSettings(current)
+	}
+	defaults := i.CurrentDefaults()
+	i.RLock()
+	ingress := i.Config.Ingress
+	monitoringConfig := i.Config.Monitoring
+	ingressProvider := i.ingress
+	monitoring := i.monitoring
+	pinLinux := i.Config.NodePlatforms.PinLinux
+	archImages := i.Config.NodePlatforms.ArchImages
+	availability := i.Config.Availability
+	nodeArchitectures := i.nodeArchitectures
+	i.RUnlock()
+
+	if err := operatorCUE.UnifyWithMesh(mesh); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to unify the mesh with CUE: %w", err)
+	}
+
+	manifests, err := operatorCUE.ExtractCoreK8sManifests()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to extract K8s manifests: %w", err)
+	}
+	cuemodule.ApplyComponentOverrides(manifests, mesh.Spec.Overrides)
+	if pinLinux {
+		cuemodule.PinToLinux(manifests)
+	}
+	cuemodule.ApplyArchImages(manifests, archImages, nodeArchitectures)
+	manifests = append(mkAvailabilityObjects(availability, mesh, manifests), manifests...)
+	manifests = append(manifests, mkAutoscalingObjects(mesh, manifests)...)
+	manifests = append(manifests, mkIngressObjects(ingress, ingressProvider, manifests)...)
+
+	gmCUE, err := operatorCUE.TempGMValueUnifiedWithDefaults(defaults)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	hosts, err := gmCUE.EdgeHosts()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to generate edge hosts: %w", err)
+	}
+	manifests = append(manifests, mkEdgeHostObjects(ingress, ingressProvider, hosts, manifests)...)
+	manifests = append(manifests, mkEdgeCertificates(ingress, mesh.Spec.InstallNamespace, hosts)...)
+	manifests = append(manifests, mkMonitoringObjects(monitoringConfig, monitoring, mesh, manifests)...)
+	for _, manifest := range manifests {
+		k8sapi.MarkManaged(manifest, i.manager(mesh))
+	}
+
+	configs, kinds, err := gmCUE.ExtractCoreMeshConfigs()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to extract Grey Matter config: %w", err)
+	}
+	return manifests, configs, kinds, nil
+}
+
+// LiveConfig returns the Grey Matter config objects currently in Control and Catalog, along with their kinds.
+func (i *Installer) LiveConfig(ctx context.Context) ([]json.RawMessage, []string, error) {
+	if i.CLI == nil {
+		return nil, nil, errors.New("greymatter CLI has not been initialized")
+	}
+	i.RLock()
+	defer i.RUnlock()
+
+	if i.Client == nil {
+		return nil, nil, errors.New("not connected to the mesh's Control and Catalog APIs")
+	}
+
+	var objects []json.RawMessage
+	var kinds []string
+	for _, kind := range cuemodule.GMKinds() {
+		listed, err := i.Client.List(ctx, kind)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, obj := range listed {
+			objects = append(objects, obj)
+			kinds = append(kinds, kind)
+		}
+	}
+	return objects, kinds, nil
+}
+
+// Reapply asynchronously re-applies THE mesh's K8s manifests and Grey Matter config in full,
+// e.g. after the sync state is purged.
+func (i *Installer) Reapply() error {
+	mesh := i.CurrentMesh()
+	if mesh == nil || mesh.UID == "" {
+		return errors.New("no mesh has been applied yet")
+	}
+	go i.ApplyMesh(i.RunContext(), mesh, mesh.DeepCopy())
+	return nil
+}
+
+// ReleaseDeletions deletes the K8s objects and Grey Matter config objects whose deletion is held back by the
+// deletion policy, returning references to them.
+func (i *Installer) ReleaseDeletions() (gitops.HeldDeletions, error) {
+	if i.Sync.SyncState == nil {
+		return gitops.HeldDeletions{}, errors.New("sync state has not been initialized")
+	}
+	i.RLock()
+	defer i.RUnlock()
+
+	if len(i.Sync.SyncState.HeldDeletions().GM) > 0 && i.Client == nil {
+		return gitops.HeldDeletions{}, errors.New("not connected to the mesh's Control and Catalog APIs")
+	}
+	released := i.Sync.SyncState.ReleaseDeletions()
+	k8sapi.DeleteAll(i.RunContext(), i.K8sClient, released.K8s, i.Sync.CurrentReport())
+	if len(released.GM) > 0 {
+		gmapi.DeleteAllByGMObjectRefs(i.Client, released.GM)
+	}
+	return released, nil
+}
@@ -0,0 +1,44 @@
+package mesh_install
+
+import (
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+)
+
+// Features returns whether each of the mesh's feature flags is on, as of the CUE last applied (see
+// cuemodule.Features).
+func (i *Installer) Features() map[string]bool {
+	i.state.RLock()
+	defer i.state.RUnlock()
+	return i.features.Resolved()
+}
+
+// FeatureEnabled reports whether the named feature flag is on for the mesh, as of the CUE last applied.
+func (i *Installer) FeatureEnabled(name string) bool {
+	i.state.RLock()
+	defer i.state.RUnlock()
+	return i.features.Enabled(name)
+}
+
+// refreshFeatures reads the mesh's feature flags from the CUE being applied, so a GitOps commit can switch them. If
+// the xds flag is switched, the sync state is reset, so all of the Grey Matter config is sent to where it's now
+// delivered.
+func (i *Installer) refreshFeatures() {
+	features, err := i.CurrentOperatorCUE().ExtractFeatures()
+	if err != nil {
+		logger.Error(err, "Failed to extract feature flags; keeping the previous ones")
+		return
+	}
+	i.state.Lock()
+	prev := i.features
+	i.features = features
+	i.state.Unlock()
+
+	for _, name := range cuemodule.KnownFeatures() {
+		if prev.Enabled(name) != features.Enabled(name) {
+			logger.Info("Feature flag switched", "Feature", name, "Enabled", features.Enabled(name))
+		}
+	}
+	if prev.Enabled(cuemodule.FeatureXDS) != features.Enabled(cuemodule.FeatureXDS) && i.Sync != nil && i.Sync.SyncState != nil {
+		i.Sync.SyncState.Reset()
+	}
+}
@@ -0,0 +1,25 @@
+package mesh_install
+
+import (
+	"testing"
+
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRefreshFeatures(t *testing.T) {
+	i, _ := newTestInstaller(t)
+	assert.True(t, i.FeatureEnabled(cuemodule.FeatureXDS))
+	assert.False(t, i.FeatureEnabled(cuemodule.FeatureDriftEnforcement))
+
+	// Switched in the CUE of the next sync cycle, without a restart
+	cuemodule.WithConfigOverrides([]byte(`{"features":{"xds":false,"drift_enforcement":true}}`))(i.OperatorCUE)
+	i.refreshFeatures()
+	assert.False(t, i.FeatureEnabled(cuemodule.FeatureXDS))
+	assert.True(t, i.FeatureEnabled(cuemodule.FeatureDriftEnforcement))
+	assert.Equal(t, map[string]bool{
+		cuemodule.FeatureWebhookInjection: true,
+		cuemodule.FeatureXDS:              false,
+		cuemodule.FeatureDriftEnforcement: true,
+	}, i.Features())
+}
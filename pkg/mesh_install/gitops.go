@@ -0,0 +1,64 @@
+package mesh_install
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/greymatter-io/operator/pkg/credentials"
+	"github.com/greymatter-io/operator/pkg/gitops"
+	"github.com/greymatter-io/operator/pkg/k8sapi"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// configureGitOps points the sync at the mesh's GitOps source, with the credentials in its Secret,
+// or back at the source from the startup flags if the mesh doesn't set one. Only a change to the mesh's
+// source reconfigures the sync, so a ref switched through the admin API holds until then.
+func (i *Installer) configureGitOps(ctx context.Context, mesh *v1alpha1.Mesh) {
+	if i.Sync == nil || mesh == nil {
+		return
+	}
+	spec := mesh.Spec.GitOps
+
+	// Credentials are re-read on every apply, so a rotated Secret is picked up
+	if spec != nil && spec.CredentialsSecret != "" {
+		secret := &corev1.Secret{}
+		key := client.ObjectKey{Namespace: credentials.Namespace, Name: spec.CredentialsSecret}
+		getCtx, cancel := k8sapi.WithTimeout(ctx)
+		err := i.K8sClient.Get(getCtx, key, secret)
+		cancel()
+		if err != nil {
+			i.setMeshCondition(v1alpha1.ConditionGitOpsSourceReady, metav1.ConditionFalse, "CredentialsUnavailable",
+				fmt.Sprintf("Failed to get GitOps credentials Secret %s: %v", key, err))
+			return
+		}
+		i.Sync.SetGitCredentials(credentials.GitCredentials(secret))
+	}
+	if reflect.DeepEqual(spec, i.gitOpsSource) {
+		return
+	}
+
+	if spec == nil {
+		if changed, _ := i.Sync.Reconfigure(i.flagSource); changed {
+			logger.Info("Restored the GitOps source from the startup flags", "Remote", i.flagSource.Remote)
+		}
+		i.gitOpsSource = nil
+		return
+	}
+	if _, err := i.Sync.Reconfigure(gitops.Source{
+		Remote:      spec.Remote,
+		Branch:      spec.Branch,
+		Tag:         spec.Tag,
+		Interval:    spec.IntervalSeconds,
+		PathFilters: spec.PathFilters,
+	}); err != nil {
+		i.setMeshCondition(v1alpha1.ConditionGitOpsSourceReady, metav1.ConditionFalse, "InvalidSource", err.Error())
+		return
+	}
+	i.gitOpsSource = spec.DeepCopy()
+	i.setMeshCondition(v1alpha1.ConditionGitOpsSourceReady, metav1.ConditionTrue, "SourceConfigured",
+		fmt.Sprintf("Syncing config from %s", spec.Remote))
+}
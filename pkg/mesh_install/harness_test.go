@@ -0,0 +1,88 @@
+package mesh_install
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	"github.com/greymatter-io/operator/pkg/gitops"
+	"github.com/greymatter-io/operator/pkg/gmapi"
+	configv1 "github.com/openshift/api/config/v1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	extv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// testScheme has the kinds the operator reads and writes.
+func testScheme(t *testing.T) *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	assert.NoError(t, clientgoscheme.AddToScheme(scheme))
+	assert.NoError(t, v1alpha1.AddToScheme(scheme))
+	assert.NoError(t, extv1.AddToScheme(scheme))
+	assert.NoError(t, configv1.AddToScheme(scheme))
+	// cert-manager's, the Prometheus Operator's, and the metrics-server's kinds are only read and written as
+	// unstructured objects
+	for _, gvk := range []schema.GroupVersionKind{certificateGVK, podMonitorGVK, podMetricsGVK} {
+		scheme.AddKnownTypeWithName(gvk, &unstructured.Unstructured{})
+		scheme.AddKnownTypeWithName(gvk.GroupVersion().WithKind(gvk.Kind+"List"), &unstructured.UnstructuredList{})
+	}
+	return scheme
+}
+
+// startObjects are what Start needs to find in the cluster: the Mesh CRD (which the initial Mesh waits for) and the
+// operator's image pull secret.
+func startObjects() []client.Object {
+	return []client.Object{
+		&extv1.CustomResourceDefinition{ObjectMeta: metav1.ObjectMeta{Name: "meshes.greymatter.io"}},
+		&corev1.Secret{
+			// The fake client doesn't set creation timestamps, which getImagePullSecret waits for
+			ObjectMeta: metav1.ObjectMeta{Name: "gm-docker-secret", Namespace: "gm-operator", CreationTimestamp: metav1.Now()},
+			Type:       corev1.SecretTypeDockerConfigJson,
+			Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths":{}}`)},
+		},
+	}
+}
+
+// testCUE is a minimal operator CUE module, standing in for the core module.
+var testCUE = map[string]string{
+	"cue.mod/module.cue":      `module: "greymatter.io/operator/test"`,
+	"k8s/outputs/outputs.cue": "package outputs\n\nconfig: cluster_ingress_name: \"cluster\"\nmesh: metadata: name: \"greymatter-mesh\"\nmesh: spec: install_namespace: \"greymatter\"\n",
+	"gm/outputs/outputs.cue":  "package outputs\n\nmesh_configs: []\n",
+}
+
+// newTestInstaller returns an Installer for a minimal CUE module, backed by a fake client with the given objects,
+// and with a sync that has no remote. Its greymatter CLI has no client, so nothing is sent to Control, and
+// its config leaves the default mesh unapplied. Goroutines started by the Installer end with the test.
+func newTestInstaller(t *testing.T, objs ...client.Object) (*Installer, client.Client) {
+	c := fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(objs...).Build()
+
+	root := t.TempDir()
+	for path, contents := range testCUE {
+		path = filepath.Join(root, path)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, os.WriteFile(path, []byte(contents), 0600))
+	}
+	operatorCUE, mesh, err := cuemodule.LoadAll(root)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	s := gitops.New("", ctx, cancel)
+
+	i, err := New(c, operatorCUE, mesh, root, &gmapi.CLI{RWMutex: &sync.RWMutex{}}, nil, s, record.NewFakeRecorder(100))
+	assert.NoError(t, err)
+	return i, c
+}
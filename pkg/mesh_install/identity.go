@@ -0,0 +1,194 @@
+package mesh_install
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cloudflare/cfssl/csr"
+	"github.com/cloudflare/cfssl/helpers"
+	"github.com/greymatter-io/operator/pkg/cfsslsrv"
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	"github.com/greymatter-io/operator/pkg/k8sapi"
+	"github.com/greymatter-io/operator/pkg/wellknown"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// The trust domain of the SPIFFE IDs in ServiceAccount identity certificates.
+	identityTrustDomain = "greymatter.io"
+	// The name of the identity renewal loop in config.reconcile.disabled.
+	reconcilerIdentity = "identity"
+	// How often identity certificates are checked for renewal, unless config.reconcile.interval_seconds is set.
+	identityRenewalInterval = time.Minute
+	// Identity certificates are renewed once they have less than this fraction of their lifetime left.
+	identityRenewalFraction = 3
+	// The volume holding a sidecar's identity certificate and ServiceAccount token.
+	identityVolumeName = "gm-identity"
+	// The audience of the ServiceAccount token projected into sidecars.
+	identityTokenAudience = "greymatter.io"
+	// How long the projected ServiceAccount token is valid before the kubelet refreshes it.
+	identityTokenExpirySeconds = int64(3600)
+)
+
+// identityVolume returns a volume projecting the given identity Secret's certificate, key, and CA alongside a
+// ServiceAccount token for the pod, for sidecars to identify themselves by their pods' ServiceAccounts.
+func identityVolume(secretName string) corev1.Volume {
+	expiry := identityTokenExpirySeconds
+	return corev1.Volume{
+		Name: identityVolumeName,
+		VolumeSource: corev1.VolumeSource{Projected: &corev1.ProjectedVolumeSource{
+			Sources: []corev1.VolumeProjection{
+				{Secret: &corev1.SecretProjection{
+					LocalObjectReference: corev1.LocalObjectReference{Name: secretName},
+					Items: []corev1.KeyToPath{
+						{Key: corev1.TLSCertKey, Path: "tls.crt"},
+						{Key: corev1.TLSPrivateKeyKey, Path: "tls.key"},
+						{Key: "ca.crt", Path: "ca.crt"},
+					},
+				}},
+				{ServiceAccountToken: &corev1.ServiceAccountTokenProjection{
+					Audience:          identityTokenAudience,
+					ExpirationSeconds: &expiry,
+					Path:              "token",
+				}},
+			},
+		}},
+	}
+}
+
+// InjectIdentity mounts the identity in the given identity Secret into the sidecar container of a pod spec.
+func InjectIdentity(spec *corev1.PodSpec, sidecar *corev1.Container, secretName string) {
+	for _, v := range spec.Volumes {
+		if v.Name == identityVolumeName {
+			return
+		}
+	}
+	spec.Volumes = append(spec.Volumes, identityVolume(secretName))
+	sidecar.VolumeMounts = append(sidecar.VolumeMounts, corev1.VolumeMount{
+		Name:      identityVolumeName,
+		MountPath: wellknown.IDENTITY_MOUNT_PATH,
+		ReadOnly:  true,
+	})
+}
+
+// ServiceAccountIdentity reports whether sidecars get their identity from their ServiceAccounts instead of SPIRE.
+func (i *Installer) ServiceAccountIdentity() bool {
+	return i.Config.IdentityMode == cuemodule.IdentityModeServiceAccount
+}
+
+// identitySecretName returns the name of the Secret holding the identity certificate for a ServiceAccount.
+func identitySecretName(serviceAccount string) string {
+	return fmt.Sprintf("gm-identity-%s", serviceAccount)
+}
+
+// identitySPIFFEID returns the SPIFFE ID of a ServiceAccount, which identifies its sidecars.
+func identitySPIFFEID(namespace, serviceAccount string) string {
+	return fmt.Sprintf("spiffe://%s/ns/%s/sa/%s", identityTrustDomain, namespace, serviceAccount)
+}
+
+// EnsureWorkloadIdentity issues an identity certificate for a ServiceAccount into a Secret in its namespace,
+// unless a current one already exists, and returns the Secret's name.
+func (i *Installer) EnsureWorkloadIdentity(ctx context.Context, namespace, serviceAccount string) (string, error) {
+	name := identitySecretName(serviceAccount)
+	existing := &corev1.Secret{}
+	getCtx, cancel := k8sapi.WithTimeout(ctx)
+	err := i.K8sClient.Get(getCtx, client.ObjectKey{Name: name, Namespace: namespace}, existing)
+	cancel()
+	if err == nil && !identityNeedsRenewal(existing.Data[corev1.TLSCertKey], time.Now()) {
+		return name, nil
+	}
+	if err != nil && !errors.IsNotFound(err) {
+		return "", fmt.Errorf("failed to get identity secret %s/%s: %w", namespace, name, err)
+	}
+	if err := i.issueWorkloadIdentity(ctx, namespace, serviceAccount); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// issueWorkloadIdentity writes a newly issued identity certificate for a ServiceAccount into its Secret.
+func (i *Installer) issueWorkloadIdentity(ctx context.Context, namespace, serviceAccount string) error {
+	spiffeID := identitySPIFFEID(namespace, serviceAccount)
+	cert, key, err := i.cfssl.RequestWorkloadCert(csr.CertificateRequest{
+		CN:         serviceAccount,
+		Hosts:      []string{spiffeID},
+		KeyRequest: &csr.KeyRequest{A: "ecdsa", S: 256},
+		Names: []csr.Name{
+			{C: "US", ST: "VA", L: "Alexandria", O: "Grey Matter"},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to issue identity for %s: %w", spiffeID, err)
+	}
+
+	secret := &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{Kind: "Secret", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      identitySecretName(serviceAccount),
+			Namespace: namespace,
+			Labels:    map[string]string{wellknown.LABEL_IDENTITY: serviceAccount},
+		},
+		Type: corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       cert,
+			corev1.TLSPrivateKeyKey: key,
+			"ca.crt":                i.cfssl.GetRootCA(),
+		},
+	}
+	return i.applyKeySecret(ctx, secret, nil)
+}
+
+// identityNeedsRenewal reports whether an identity certificate is missing, invalid, or near the end of its lifetime.
+func identityNeedsRenewal(certPEM []byte, now time.Time) bool {
+	cert, err := helpers.ParseCertificatePEM(certPEM)
+	if err != nil {
+		return true
+	}
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	if lifetime <= 0 {
+		lifetime = cfsslsrv.WorkloadCertExpiry
+	}
+	return cert.NotAfter.Sub(now) < lifetime/identityRenewalFraction
+}
+
+// renewWorkloadIdentities periodically renews the identity certificates in the mesh's namespaces before they expire,
+// until the context is cancelled. Sidecars pick up renewed certificates from their mounted Secrets.
+func (i *Installer) renewWorkloadIdentities(ctx context.Context) {
+	ticker := time.NewTicker(i.Config.Reconcile.Interval(identityRenewalInterval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		i.renewWorkloadIdentitiesOnce(ctx, time.Now())
+	}
+}
+
+func (i *Installer) renewWorkloadIdentitiesOnce(ctx context.Context, now time.Time) {
+	namespaces := meshNamespaces(i.CurrentMesh())
+
+	for _, ns := range namespaces {
+		secrets := &corev1.SecretList{}
+		if err := k8sapi.ListPages(ctx, i.K8sClient, secrets, i.Config.Reconcile.PageSize, func() error {
+			for _, secret := range secrets.Items {
+				if !identityNeedsRenewal(secret.Data[corev1.TLSCertKey], now) {
+					continue
+				}
+				serviceAccount := secret.Labels[wellknown.LABEL_IDENTITY]
+				logger.Info("Renewing sidecar identity", "ServiceAccount", serviceAccount, "Namespace", ns)
+				if err := i.issueWorkloadIdentity(ctx, ns, serviceAccount); err != nil {
+					logger.Error(err, "Failed to renew sidecar identity - will retry", "ServiceAccount", serviceAccount, "Namespace", ns)
+				}
+			}
+			return nil
+		}, client.InNamespace(ns), client.HasLabels{wellknown.LABEL_IDENTITY}); err != nil {
+			logger.Error(err, "Failed to list sidecar identities for renewal", "Namespace", ns)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package mesh_install
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/greymatter-io/operator/pkg/wellknown"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestIdentityNeedsRenewal(t *testing.T) {
+	issued := time.Date(2022, 7, 1, 0, 0, 0, 0, time.UTC)
+	certPEM := selfSignedCertPEM(t, issued, issued.Add(24*time.Hour))
+
+	assert.False(t, identityNeedsRenewal(certPEM, issued.Add(time.Hour)))
+	assert.False(t, identityNeedsRenewal(certPEM, issued.Add(15*time.Hour)))
+	assert.True(t, identityNeedsRenewal(certPEM, issued.Add(17*time.Hour)), "less than a third of its lifetime left")
+	assert.True(t, identityNeedsRenewal(certPEM, issued.Add(25*time.Hour)), "expired")
+	assert.True(t, identityNeedsRenewal(nil, issued), "missing")
+	assert.True(t, identityNeedsRenewal([]byte("not a certificate"), issued), "invalid")
+}
+
+func TestIdentitySPIFFEID(t *testing.T) {
+	assert.Equal(t, "spiffe://greymatter.io/ns/apps/sa/web", identitySPIFFEID("apps", "web"))
+	assert.Equal(t, "gm-identity-web", identitySecretName("web"))
+}
+
+func selfSignedCertPEM(t *testing.T, notBefore, notAfter time.Time) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), NotBefore: notBefore, NotAfter: notAfter}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestInjectIdentity(t *testing.T) {
+	spec := &corev1.PodSpec{Volumes: []corev1.Volume{{Name: "spire-socket"}}}
+	sidecar := &corev1.Container{Name: "sidecar"}
+
+	InjectIdentity(spec, sidecar, "gm-identity-web")
+	// Injecting again, e.g. on a pod update, changes nothing
+	InjectIdentity(spec, sidecar, "gm-identity-web")
+
+	if assert.Len(t, spec.Volumes, 2) {
+		projected := spec.Volumes[1].Projected
+		if assert.NotNil(t, projected) && assert.Len(t, projected.Sources, 2) {
+			assert.Equal(t, "gm-identity-web", projected.Sources[0].Secret.Name)
+			assert.Equal(t, identityTokenAudience, projected.Sources[1].ServiceAccountToken.Audience)
+		}
+	}
+	assert.Equal(t, []corev1.VolumeMount{
+		{Name: identityVolumeName, MountPath: wellknown.IDENTITY_MOUNT_PATH, ReadOnly: true},
+	}, sidecar.VolumeMounts)
+}
@@ -0,0 +1,354 @@
+package mesh_install
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	"github.com/greymatter-io/operator/pkg/k8sapi"
+	configv1 "github.com/openshift/api/config/v1"
+	routev1 "github.com/openshift/api/route/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Core services given external access if config.ingress.services is unset.
+var defaultExposedServices = []string{"edge", "dashboard", "catalog"}
+
+// Ingress providers that may be set in config.ingress.provider. If unset, one is detected on start.
+const (
+	IngressOpenShift = "openshift"
+	IngressNginx     = "nginx"
+	IngressTraefik   = "traefik"
+	IngressNone      = "none"
+	// Plain Ingresses for any other ingress controller; only detected when config.ingress.domain is set
+	IngressGeneric = "ingress"
+)
+
+// Annotation of an IngressClass with the domain under which its controller serves hosts, since unlike OpenShift's,
+// other ingress controllers don't know it.
+const ingressDomainAnnotation = "greymatter.io/ingress-domain"
+
+// IngressProvider gives core services external access through the cluster's ingress controller.
+type IngressProvider interface {
+	// Name is the provider's name in config.ingress.provider.
+	Name() string
+	// Domain returns the domain under which services get hosts unless config.ingress.domain is set,
+	// or "" if the provider can't derive one.
+	Domain() string
+	// Expose returns the objects, with the given name, giving a service's port external access at the given host.
+	Expose(config cuemodule.IngressConfig, name string, svc *corev1.Service, port corev1.ServicePort, host string) []client.Object
+}
+
+// mkIngressObjects returns the objects the provider creates to give external access to each exposed core service
+// among the manifests. Each service's host is "<service>-<namespace>.<domain>".
+func mkIngressObjects(config cuemodule.IngressConfig, provider IngressProvider, manifestObjects []client.Object) []client.Object {
+	if provider == nil {
+		provider = genericIngress{}
+	}
+	if !config.Enabled || provider.Name() == IngressNone {
+		return nil
+	}
+	domain := config.Domain
+	if domain == "" {
+		domain = provider.Domain()
+	}
+	if domain == "" {
+		logger.Info("Not exposing core services because config.ingress.domain is unset", "Provider", provider.Name())
+		return nil
+	}
+
+	exposed := config.Services
+	if len(exposed) == 0 {
+		exposed = defaultExposedServices
+	}
+	services := make(map[string]*corev1.Service)
+	for _, obj := range manifestObjects {
+		if svc, ok := obj.(*corev1.Service); ok {
+			services[svc.Name] = svc
+		}
+	}
+
+	var objects []client.Object
+	for _, name := range exposed {
+		svc, ok := services[name]
+		if !ok || len(svc.Spec.Ports) == 0 {
+			logger.Info("Not exposing core service without a Service in the manifests", "Service", name)
+			continue
+		}
+		port := svc.Spec.Ports[0]
+		host := fmt.Sprintf("%s-%s.%s", svc.Name, svc.Namespace, domain)
+		objects = append(objects, provider.Expose(config, svc.Name, svc, port, host)...)
+	}
+	return objects
+}
+
+// mkEdgeHostObjects returns the objects the provider creates to give the edge Service among the manifests external
+// access at each host generated for a catalogued service, with the host's TLS Secret.
+func mkEdgeHostObjects(config cuemodule.IngressConfig, provider IngressProvider, hosts []cuemodule.EdgeHost, manifestObjects []client.Object) []client.Object {
+	if provider == nil {
+		provider = genericIngress{}
+	}
+	if !config.Enabled || provider.Name() == IngressNone || len(hosts) == 0 {
+		return nil
+	}
+	var edge *corev1.Service
+	for _, obj := range manifestObjects {
+		if svc, ok := obj.(*corev1.Service); ok && svc.Name == "edge" {
+			edge = svc
+		}
+	}
+	if edge == nil || len(edge.Spec.Ports) == 0 {
+		logger.Info("Not exposing edge hosts without an edge Service in the manifests")
+		return nil
+	}
+
+	var objects []client.Object
+	for _, host := range hosts {
+		hostConfig := config
+		hostConfig.TLSSecretName = host.TLSSecret
+		name := fmt.Sprintf("edge-host-%s", host.Service)
+		objects = append(objects, provider.Expose(hostConfig, name, edge, edge.Spec.Ports[0], host.Host)...)
+	}
+	return objects
+}
+
+// detectIngressProvider returns the provider named in config.ingress.provider, or if unset, the first found of:
+// OpenShift's router if the cluster has an OpenShift ingress config, the nginx or Traefik controller of an
+// IngressClass (preferring config.ingress.ingress_class, then the default class), plain Ingresses if
+// config.ingress.domain is set, or none.
+func detectIngressProvider(ctx context.Context, c client.Reader, config cuemodule.Config) IngressProvider {
+	switch config.Ingress.Provider {
+	case IngressOpenShift:
+		domain, ok := getOpenshiftClusterIngressDomain(ctx, c, config.ClusterIngressName)
+		if !ok {
+			logger.Info("OpenShift cluster ingress config not found; config.ingress.domain must be set", "Name", config.ClusterIngressName)
+		}
+		return openshiftRouter{domain: domain}
+	case IngressNginx, IngressTraefik:
+		class := findIngressClass(ctx, c, config.Ingress.IngressClass, ingressControllers[config.Ingress.Provider])
+		return ingressFor(config.Ingress.Provider, class)
+	case IngressNone:
+		return manualIngress{}
+	case IngressGeneric:
+		return genericIngress{}
+	}
+
+	if domain, ok := getOpenshiftClusterIngressDomain(ctx, c, config.ClusterIngressName); ok {
+		logger.Info("Identified OpenShift cluster domain name", "Domain", domain)
+		return openshiftRouter{domain: domain}
+	}
+	if class := findIngressClass(ctx, c, config.Ingress.IngressClass, ""); class != nil {
+		for provider, controller := range ingressControllers {
+			if class.Spec.Controller == controller {
+				logger.Info("Identified ingress controller", "Provider", provider, "IngressClass", class.Name)
+				return ingressFor(provider, class)
+			}
+		}
+	}
+	if config.Ingress.Domain != "" {
+		return genericIngress{}
+	}
+	return manualIngress{}
+}
+
+func getOpenshiftClusterIngressDomain(ctx context.Context, c client.Reader, ingressName string) (string, bool) {
+	ctx, cancel := k8sapi.WithTimeout(ctx)
+	defer cancel()
+	clusterIngressList := &configv1.IngressList{}
+	if err := c.List(ctx, clusterIngressList); err != nil {
+		return "", false
+	} else {
+		for _, i := range clusterIngressList.Items {
+			if i.Name == ingressName {
+				return i.Spec.Domain, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Controllers of the IngressClasses of each supported ingress controller.
+var ingressControllers = map[string]string{
+	IngressNginx:   "k8s.io/ingress-nginx",
+	IngressTraefik: "traefik.io/ingress-controller",
+}
+
+// findIngressClass returns the named IngressClass if it exists, otherwise the default class, or else the first
+// class. If a controller is given, only its classes are considered. Returns nil if there's none.
+func findIngressClass(ctx context.Context, c client.Reader, name, controller string) *networkingv1.IngressClass {
+	ctx, cancel := k8sapi.WithTimeout(ctx)
+	defer cancel()
+	classes := &networkingv1.IngressClassList{}
+	if err := c.List(ctx, classes); err != nil {
+		return nil
+	}
+	var found *networkingv1.IngressClass
+	for idx := range classes.Items {
+		class := &classes.Items[idx]
+		if controller != "" && class.Spec.Controller != controller {
+			continue
+		}
+		switch {
+		case name != "" && class.Name == name:
+			return class
+		case class.Annotations[networkingv1.AnnotationIsDefaultIngressClass] == "true":
+			found = class
+		case found == nil:
+			found = class
+		}
+	}
+	return found
+}
+
+// ingressFor returns the provider of an ingress controller, whose Ingresses are of the given class (unless
+// config.ingress.ingress_class is set) and serve hosts under the domain the class is annotated with, if any.
+func ingressFor(provider string, class *networkingv1.IngressClass) IngressProvider {
+	var name, domain string
+	if class != nil {
+		name, domain = class.Name, class.Annotations[ingressDomainAnnotation]
+	}
+	if provider == IngressTraefik {
+		return traefikIngress{class: name, domain: domain}
+	}
+	return nginxIngress{class: name, domain: domain}
+}
+
+// openshiftRouter exposes services with Routes, under the OpenShift cluster ingress domain.
+type openshiftRouter struct {
+	domain string
+}
+
+func (openshiftRouter) Name() string { return IngressOpenShift }
+
+func (r openshiftRouter) Domain() string { return r.domain }
+
+func (openshiftRouter) Expose(config cuemodule.IngressConfig, name string, svc *corev1.Service, port corev1.ServicePort, host string) []client.Object {
+	return []client.Object{mkRoute(config, name, svc, port, host)}
+}
+
+// nginxIngress exposes services with Ingresses for the ingress-nginx controller, redirecting to HTTPS if they have TLS.
+type nginxIngress struct {
+	class, domain string
+}
+
+func (nginxIngress) Name() string { return IngressNginx }
+
+func (n nginxIngress) Domain() string { return n.domain }
+
+func (n nginxIngress) Expose(config cuemodule.IngressConfig, name string, svc *corev1.Service, port corev1.ServicePort, host string) []client.Object {
+	if config.IngressClass == "" {
+		config.IngressClass = n.class
+	}
+	ingress := mkIngress(config, name, svc, port, host)
+	if config.TLSSecretName != "" {
+		ingress.Annotations = map[string]string{"nginx.ingress.kubernetes.io/force-ssl-redirect": "true"}
+	}
+	return []client.Object{ingress}
+}
+
+// traefikIngress exposes services with Ingresses for Traefik, routed from its websecure entrypoint if they have TLS,
+// otherwise its web entrypoint.
+type traefikIngress struct {
+	class, domain string
+}
+
+func (traefikIngress) Name() string { return IngressTraefik }
+
+func (t traefikIngress) Domain() string { return t.domain }
+
+func (t traefikIngress) Expose(config cuemodule.IngressConfig, name string, svc *corev1.Service, port corev1.ServicePort, host string) []client.Object {
+	if config.IngressClass == "" {
+		config.IngressClass = t.class
+	}
+	ingress := mkIngress(config, name, svc, port, host)
+	ingress.Annotations = map[string]string{"traefik.ingress.kubernetes.io/router.entrypoints": "web"}
+	if config.TLSSecretName != "" {
+		ingress.Annotations["traefik.ingress.kubernetes.io/router.entrypoints"] = "websecure"
+		ingress.Annotations["traefik.ingress.kubernetes.io/router.tls"] = "true"
+	}
+	return []client.Object{ingress}
+}
+
+// genericIngress exposes services with plain Ingresses, under config.ingress.domain.
+type genericIngress struct{}
+
+func (genericIngress) Name() string { return IngressGeneric }
+
+func (genericIngress) Domain() string { return "" }
+
+func (genericIngress) Expose(config cuemodule.IngressConfig, name string, svc *corev1.Service, port corev1.ServicePort, host string) []client.Object {
+	return []client.Object{mkIngress(config, name, svc, port, host)}
+}
+
+// manualIngress leaves exposing services to the user.
+type manualIngress struct{}
+
+func (manualIngress) Name() string { return IngressNone }
+
+func (manualIngress) Domain() string { return "" }
+
+func (manualIngress) Expose(cuemodule.IngressConfig, string, *corev1.Service, corev1.ServicePort, string) []client.Object {
+	return nil
+}
+
+func mkRoute(config cuemodule.IngressConfig, name string, svc *corev1.Service, port corev1.ServicePort, host string) *routev1.Route {
+	targetPort := intstr.FromInt(int(port.Port))
+	if port.Name != "" {
+		targetPort = intstr.FromString(port.Name)
+	}
+	route := &routev1.Route{
+		TypeMeta:   metav1.TypeMeta{Kind: "Route", APIVersion: "route.openshift.io/v1"},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: svc.Namespace},
+		Spec: routev1.RouteSpec{
+			Host: host,
+			To:   routev1.RouteTargetReference{Kind: "Service", Name: svc.Name},
+			Port: &routev1.RoutePort{TargetPort: targetPort},
+		},
+	}
+	if config.TLSTermination != "" {
+		route.Spec.TLS = &routev1.TLSConfig{
+			Termination:                   routev1.TLSTerminationType(config.TLSTermination),
+			InsecureEdgeTerminationPolicy: routev1.InsecureEdgeTerminationPolicyRedirect,
+		}
+	}
+	return route
+}
+
+func mkIngress(config cuemodule.IngressConfig, name string, svc *corev1.Service, port corev1.ServicePort, host string) *networkingv1.Ingress {
+	backendPort := networkingv1.ServiceBackendPort{Number: port.Port}
+	if port.Name != "" {
+		backendPort = networkingv1.ServiceBackendPort{Name: port.Name}
+	}
+	pathType := networkingv1.PathTypePrefix
+	ingress := &networkingv1.Ingress{
+		TypeMeta:   metav1.TypeMeta{Kind: "Ingress", APIVersion: "networking.k8s.io/v1"},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: svc.Namespace},
+		Spec: networkingv1.IngressSpec{
+			Rules: []networkingv1.IngressRule{{
+				Host: host,
+				IngressRuleValue: networkingv1.IngressRuleValue{HTTP: &networkingv1.HTTPIngressRuleValue{
+					Paths: []networkingv1.HTTPIngressPath{{
+						Path:     "/",
+						PathType: &pathType,
+						Backend: networkingv1.IngressBackend{Service: &networkingv1.IngressServiceBackend{
+							Name: svc.Name,
+							Port: backendPort,
+						}},
+					}},
+				}},
+			}},
+		},
+	}
+	if config.IngressClass != "" {
+		ingressClass := config.IngressClass
+		ingress.Spec.IngressClassName = &ingressClass
+	}
+	if config.TLSSecretName != "" {
+		ingress.Spec.TLS = []networkingv1.IngressTLS{{Hosts: []string{host}, SecretName: config.TLSSecretName}}
+	}
+	return ingress
+}
@@ -0,0 +1,144 @@
+package mesh_install
+
+import (
+	"context"
+	"testing"
+
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	configv1 "github.com/openshift/api/config/v1"
+	routev1 "github.com/openshift/api/route/v1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestMkIngressObjects(t *testing.T) {
+	svc := func(name string, port corev1.ServicePort) *corev1.Service {
+		return &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "greymatter"},
+			Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{port}},
+		}
+	}
+	manifests := []client.Object{
+		svc("edge", corev1.ServicePort{Name: "ingress", Port: 10808}),
+		svc("dashboard", corev1.ServicePort{Port: 1337}),
+		svc("redis", corev1.ServicePort{Port: 6379}),
+	}
+	openshift := openshiftRouter{domain: "apps.example.com"}
+
+	assert.Empty(t, mkIngressObjects(cuemodule.IngressConfig{}, openshift, manifests))
+	assert.Empty(t, mkIngressObjects(cuemodule.IngressConfig{Enabled: true}, genericIngress{}, manifests))
+	assert.Empty(t, mkIngressObjects(cuemodule.IngressConfig{Enabled: true, Domain: "mesh.example.com"}, manualIngress{}, manifests))
+
+	// OpenShift
+	objects := mkIngressObjects(cuemodule.IngressConfig{Enabled: true, TLSTermination: "edge"}, openshift, manifests)
+	if assert.Len(t, objects, 2) {
+		route := objects[0].(*routev1.Route)
+		assert.Equal(t, "edge-greymatter.apps.example.com", route.Spec.Host)
+		assert.Equal(t, "ingress", route.Spec.Port.TargetPort.StrVal)
+		assert.Equal(t, routev1.TLSTerminationEdge, route.Spec.TLS.Termination)
+		assert.Equal(t, int32(1337), objects[1].(*routev1.Route).Spec.Port.TargetPort.IntVal)
+	}
+
+	// Elsewhere
+	objects = mkIngressObjects(cuemodule.IngressConfig{
+		Enabled:       true,
+		Services:      []string{"dashboard"},
+		Domain:        "mesh.example.com",
+		IngressClass:  "nginx",
+		TLSSecretName: "mesh-tls",
+	}, genericIngress{}, manifests)
+	if assert.Len(t, objects, 1) {
+		ingress := objects[0].(*networkingv1.Ingress)
+		assert.Equal(t, "dashboard-greymatter.mesh.example.com", ingress.Spec.Rules[0].Host)
+		assert.Equal(t, int32(1337), ingress.Spec.Rules[0].HTTP.Paths[0].Backend.Service.Port.Number)
+		assert.Equal(t, "nginx", *ingress.Spec.IngressClassName)
+		assert.Equal(t, "mesh-tls", ingress.Spec.TLS[0].SecretName)
+	}
+
+	// Ingress controllers serve hosts under the domain of their class, and annotate them for TLS
+	config := cuemodule.IngressConfig{Enabled: true, Services: []string{"edge"}, TLSSecretName: "mesh-tls"}
+	objects = mkIngressObjects(config, nginxIngress{class: "public", domain: "nginx.example.com"}, manifests)
+	if assert.Len(t, objects, 1) {
+		ingress := objects[0].(*networkingv1.Ingress)
+		assert.Equal(t, "edge-greymatter.nginx.example.com", ingress.Spec.Rules[0].Host)
+		assert.Equal(t, "public", *ingress.Spec.IngressClassName)
+		assert.Equal(t, "true", ingress.Annotations["nginx.ingress.kubernetes.io/force-ssl-redirect"])
+	}
+	objects = mkIngressObjects(config, traefikIngress{class: "traefik", domain: "traefik.example.com"}, manifests)
+	if assert.Len(t, objects, 1) {
+		ingress := objects[0].(*networkingv1.Ingress)
+		assert.Equal(t, "edge-greymatter.traefik.example.com", ingress.Spec.Rules[0].Host)
+		assert.Equal(t, "websecure", ingress.Annotations["traefik.ingress.kubernetes.io/router.entrypoints"])
+		assert.Equal(t, "true", ingress.Annotations["traefik.ingress.kubernetes.io/router.tls"])
+	}
+}
+
+func TestMkEdgeHostObjects(t *testing.T) {
+	edge := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "edge", Namespace: "greymatter"},
+		Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Name: "ingress", Port: 10808}}},
+	}
+	hosts := []cuemodule.EdgeHost{
+		{Service: "catalog", Host: "catalog.mesh-sample.mesh.example.com", TLSSecret: "catalog-tls"},
+		{Service: "dashboard", Host: "dashboard.mesh-sample.mesh.example.com"},
+	}
+	config := cuemodule.IngressConfig{Enabled: true, IngressClass: "nginx"}
+
+	assert.Empty(t, mkEdgeHostObjects(cuemodule.IngressConfig{}, genericIngress{}, hosts, []client.Object{edge}))
+	assert.Empty(t, mkEdgeHostObjects(config, manualIngress{}, hosts, []client.Object{edge}))
+	assert.Empty(t, mkEdgeHostObjects(config, genericIngress{}, hosts, nil))
+
+	objects := mkEdgeHostObjects(config, genericIngress{}, hosts, []client.Object{edge})
+	if assert.Len(t, objects, 2) {
+		ingress := objects[0].(*networkingv1.Ingress)
+		assert.Equal(t, "edge-host-catalog", ingress.Name)
+		assert.Equal(t, "catalog.mesh-sample.mesh.example.com", ingress.Spec.Rules[0].Host)
+		assert.Equal(t, "edge", ingress.Spec.Rules[0].HTTP.Paths[0].Backend.Service.Name)
+		assert.Equal(t, "catalog-tls", ingress.Spec.TLS[0].SecretName)
+		assert.Empty(t, objects[1].(*networkingv1.Ingress).Spec.TLS)
+	}
+
+	objects = mkEdgeHostObjects(config, openshiftRouter{domain: "apps.example.com"}, hosts, []client.Object{edge})
+	if assert.Len(t, objects, 2) {
+		route := objects[1].(*routev1.Route)
+		assert.Equal(t, "edge-host-dashboard", route.Name)
+		assert.Equal(t, "dashboard.mesh-sample.mesh.example.com", route.Spec.Host)
+		assert.Equal(t, "edge", route.Spec.To.Name)
+	}
+}
+
+func TestDetectIngressProvider(t *testing.T) {
+	detect := func(ingress cuemodule.IngressConfig, objs ...client.Object) IngressProvider {
+		c := fake.NewClientBuilder().WithScheme(testScheme(t)).WithObjects(objs...).Build()
+		return detectIngressProvider(context.TODO(), c, cuemodule.Config{ClusterIngressName: "cluster", Ingress: ingress})
+	}
+	class := func(name, controller string, annotations map[string]string) *networkingv1.IngressClass {
+		return &networkingv1.IngressClass{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: annotations},
+			Spec:       networkingv1.IngressClassSpec{Controller: controller},
+		}
+	}
+	clusterIngress := &configv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: "cluster"}, Spec: configv1.IngressSpec{Domain: "apps.example.com"}}
+	nginx := class("nginx", "k8s.io/ingress-nginx", map[string]string{ingressDomainAnnotation: "nginx.example.com"})
+	traefik := class("traefik", "traefik.io/ingress-controller", map[string]string{networkingv1.AnnotationIsDefaultIngressClass: "true"})
+
+	// OpenShift is detected first
+	assert.Equal(t, openshiftRouter{domain: "apps.example.com"}, detect(cuemodule.IngressConfig{}, clusterIngress, nginx))
+
+	// Then the configured IngressClass, or the default one
+	assert.Equal(t, nginxIngress{class: "nginx", domain: "nginx.example.com"}, detect(cuemodule.IngressConfig{IngressClass: "nginx"}, nginx, traefik))
+	assert.Equal(t, traefikIngress{class: "traefik"}, detect(cuemodule.IngressConfig{}, nginx, traefik))
+
+	// Then plain Ingresses if there's a domain for them, or none
+	assert.Equal(t, genericIngress{}, detect(cuemodule.IngressConfig{Domain: "mesh.example.com"}, class("haproxy", "haproxy.org/ingress-controller", nil)))
+	assert.Equal(t, manualIngress{}, detect(cuemodule.IngressConfig{}))
+
+	// A configured provider isn't detected
+	assert.Equal(t, manualIngress{}, detect(cuemodule.IngressConfig{Provider: IngressNone}, clusterIngress))
+	assert.Equal(t, nginxIngress{class: "nginx", domain: "nginx.example.com"}, detect(cuemodule.IngressConfig{Provider: IngressNginx}, nginx, traefik))
+	assert.Equal(t, openshiftRouter{}, detect(cuemodule.IngressConfig{Provider: IngressOpenShift}, nginx))
+}
@@ -0,0 +1,205 @@
+package mesh_install
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/greymatter-io/operator/pkg/k8sapi"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	extv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/watch"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// The Mesh CRD, which must be established before a Mesh can be applied.
+	meshCRDName = "meshes.greymatter.io"
+	// Delay before retrying a failed initial Mesh apply, doubled on each failure up to the max.
+	initialApplyMinDelay = time.Second
+	initialApplyMaxDelay = time.Minute
+)
+
+// The webhook configurations whose Services must be reachable before a Mesh can be applied, since they admit it.
+var (
+	meshMutatingWebhooks   = client.ObjectKey{Name: "gm-mutate-config"}
+	meshValidatingWebhooks = client.ObjectKey{Name: "gm-validate-config"}
+)
+
+// How often readiness is checked again while waiting on a watch, in case the watch missed a change or couldn't be
+// opened.
+var readinessRecheckInterval = 30 * time.Second
+
+// WebhooksRegistered tells the installer the operator's admission webhooks are registered with the webhook server, so
+// the initial Mesh can be applied once they're reachable.
+func (i *Installer) WebhooksRegistered() {
+	i.webhooksOnce.Do(func() { close(i.webhooksRegistered) })
+}
+
+// applyInitialMesh applies the default Mesh from the CUE as soon as it can be admitted: once the Mesh CRD is
+// established, and the operator's webhooks are registered and their Services have ready endpoints. Failed applies are
+// retried with exponential backoff, capped at initialApplyMaxDelay, until the Mesh is applied or ctx is done.
+func (i *Installer) applyInitialMesh(ctx context.Context) error {
+	logger.Info("Waiting for the Mesh CRD and webhooks to be ready to apply loaded default Mesh resource to cluster")
+	if err := i.waitForMeshAdmission(ctx); err != nil {
+		return err
+	}
+
+	delay := initialApplyMinDelay
+	for {
+		// Applied as a copy, since applying it reads the live Mesh into it
+		mesh := i.CurrentMesh().DeepCopy()
+		err := k8sapi.Apply(ctx, i.K8sClient, mesh, nil, k8sapi.GetOrCreate)
+		if err == nil {
+			initialApplyAttempts.WithLabelValues("applied").Inc()
+			initialApplyRetryDelay.Set(0)
+			logger.Info("Applied loaded default Mesh resource to cluster", "Name", mesh.Name)
+			return nil
+		}
+		initialApplyAttempts.WithLabelValues("failed").Inc()
+		initialApplyRetryDelay.Set(delay.Seconds())
+		logger.Error(err, "Failed to apply Mesh resource - will retry", "Name", mesh.Name, "Delay", delay.String())
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > initialApplyMaxDelay {
+			delay = initialApplyMaxDelay
+		}
+	}
+}
+
+// waitForMeshAdmission blocks until a Mesh can be applied, or ctx is done.
+func (i *Installer) waitForMeshAdmission(ctx context.Context) error {
+	initialApplyWaiting.WithLabelValues("crd").Set(1)
+	err := waitUntil(ctx, i.K8sClient, &extv1.CustomResourceDefinition{}, &extv1.CustomResourceDefinitionList{},
+		client.ObjectKey{Name: meshCRDName}, crdEstablished)
+	initialApplyWaiting.WithLabelValues("crd").Set(0)
+	if err != nil {
+		return err
+	}
+
+	initialApplyWaiting.WithLabelValues("webhooks").Set(1)
+	defer initialApplyWaiting.WithLabelValues("webhooks").Set(0)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-i.webhooksRegistered:
+	}
+	services, err := webhookServices(ctx, i.K8sClient)
+	if err != nil {
+		return err
+	}
+	for _, key := range services {
+		logger.Info("Waiting for webhook Service endpoints", "Namespace", key.Namespace, "Name", key.Name)
+		if err := waitUntil(ctx, i.K8sClient, &corev1.Endpoints{}, &corev1.EndpointsList{}, key, endpointsReady); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// webhookServices returns the Services that the operator's webhook configurations send admission requests to. A
+// configuration that doesn't exist, e.g. when the operator is run outside of the cluster, has none.
+func webhookServices(ctx context.Context, c client.Client) ([]client.ObjectKey, error) {
+	var services []client.ObjectKey
+	seen := make(map[client.ObjectKey]bool)
+	add := func(ref *admissionregistrationv1.ServiceReference) {
+		if ref == nil {
+			return
+		}
+		key := client.ObjectKey{Namespace: ref.Namespace, Name: ref.Name}
+		if !seen[key] {
+			seen[key] = true
+			services = append(services, key)
+		}
+	}
+
+	mwc := &admissionregistrationv1.MutatingWebhookConfiguration{}
+	if err := c.Get(ctx, meshMutatingWebhooks, mwc); err != nil && !errors.IsNotFound(err) {
+		return nil, fmt.Errorf("failed to get MutatingWebhookConfiguration %s: %w", meshMutatingWebhooks.Name, err)
+	}
+	for _, webhook := range mwc.Webhooks {
+		add(webhook.ClientConfig.Service)
+	}
+	vwc := &admissionregistrationv1.ValidatingWebhookConfiguration{}
+	if err := c.Get(ctx, meshValidatingWebhooks, vwc); err != nil && !errors.IsNotFound(err) {
+		return nil, fmt.Errorf("failed to get ValidatingWebhookConfiguration %s: %w", meshValidatingWebhooks.Name, err)
+	}
+	for _, webhook := range vwc.Webhooks {
+		add(webhook.ClientConfig.Service)
+	}
+	return services, nil
+}
+
+func crdEstablished(obj client.Object) bool {
+	for _, cond := range obj.(*extv1.CustomResourceDefinition).Status.Conditions {
+		if cond.Type == extv1.Established && cond.Status == extv1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+func endpointsReady(obj client.Object) bool {
+	for _, subset := range obj.(*corev1.Endpoints).Subsets {
+		if len(subset.Addresses) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// waitUntil blocks until ready reports true of the object with the key, or ctx is done. The object (of obj's type,
+// listed as list's) is checked whenever a watch of it reports a change, and every readinessRecheckInterval.
+func waitUntil(ctx context.Context, c client.Client, obj client.Object, list client.ObjectList, key client.ObjectKey, ready func(client.Object) bool) error {
+	for {
+		err := c.Get(ctx, key, obj)
+		if err == nil && ready(obj) {
+			return nil
+		}
+		if err != nil && !errors.IsNotFound(err) {
+			logger.Error(err, "Failed to check readiness - will retry", "Type", fmt.Sprintf("%T", obj), "Key", key.String())
+		}
+		if done, err := watchUntil(ctx, c, list, key, ready); done || err != nil {
+			return err
+		}
+	}
+}
+
+// watchUntil watches the object with the key until ready reports true of it (returning true), the watch ends or
+// readinessRecheckInterval passes (returning false), or ctx is done. If the client can't watch, it only waits.
+func watchUntil(ctx context.Context, c client.Client, list client.ObjectList, key client.ObjectKey, ready func(client.Object) bool) (bool, error) {
+	recheck := time.NewTimer(readinessRecheckInterval)
+	defer recheck.Stop()
+	var events <-chan watch.Event
+	if wc, ok := c.(client.WithWatch); ok {
+		w, err := wc.Watch(ctx, list, client.InNamespace(key.Namespace), client.MatchingFields{"metadata.name": key.Name})
+		if err != nil {
+			logger.Error(err, "Failed to watch for readiness - will poll", "Type", fmt.Sprintf("%T", list), "Key", key.String())
+		} else {
+			defer w.Stop()
+			events = w.ResultChan()
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-recheck.C:
+			return false, nil
+		case event, ok := <-events:
+			if !ok {
+				return false, nil
+			}
+			obj, isObj := event.Object.(client.Object)
+			if isObj && obj.GetName() == key.Name && obj.GetNamespace() == key.Namespace && ready(obj) {
+				return true, nil
+			}
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package mesh_install
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	extv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestApplyInitialMesh(t *testing.T) {
+	recheck := readinessRecheckInterval
+	readinessRecheckInterval = 50 * time.Millisecond
+	defer func() { readinessRecheckInterval = recheck }()
+
+	webhookService := &admissionregistrationv1.ServiceReference{Namespace: "gm-operator", Name: "gm-webhook"}
+	i, c := newTestInstaller(t, append(startObjects(),
+		&admissionregistrationv1.MutatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: "gm-mutate-config"},
+			Webhooks: []admissionregistrationv1.MutatingWebhook{
+				{Name: "mutate-mesh.greymatter.io", ClientConfig: admissionregistrationv1.WebhookClientConfig{Service: webhookService}},
+				{Name: "mutate-workload.greymatter.io", ClientConfig: admissionregistrationv1.WebhookClientConfig{Service: webhookService}},
+			},
+		},
+		&corev1.Endpoints{ObjectMeta: metav1.ObjectMeta{Name: "gm-webhook", Namespace: "gm-operator"}},
+	)...)
+	applied := func() bool {
+		return c.Get(context.TODO(), client.ObjectKeyFromObject(i.Mesh), &v1alpha1.Mesh{}) == nil
+	}
+	attempts := testutil.ToFloat64(initialApplyAttempts.WithLabelValues("applied"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- i.applyInitialMesh(ctx) }()
+
+	// Not until the CRD is established
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 1.0, testutil.ToFloat64(initialApplyWaiting.WithLabelValues("crd")))
+	crd := &extv1.CustomResourceDefinition{}
+	assert.NoError(t, c.Get(context.TODO(), client.ObjectKey{Name: meshCRDName}, crd))
+	crd.Status.Conditions = []extv1.CustomResourceDefinitionCondition{{Type: extv1.Established, Status: extv1.ConditionTrue}}
+	assert.NoError(t, c.Update(context.TODO(), crd))
+
+	// Nor until the webhooks are registered and their Service has ready endpoints
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 0.0, testutil.ToFloat64(initialApplyWaiting.WithLabelValues("crd")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(initialApplyWaiting.WithLabelValues("webhooks")))
+	i.WebhooksRegistered()
+	i.WebhooksRegistered()
+	time.Sleep(100 * time.Millisecond)
+	assert.False(t, applied())
+	endpoints := &corev1.Endpoints{}
+	assert.NoError(t, c.Get(context.TODO(), client.ObjectKey{Namespace: "gm-operator", Name: "gm-webhook"}, endpoints))
+	endpoints.Subsets = []corev1.EndpointSubset{{Addresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}}}}
+	assert.NoError(t, c.Update(context.TODO(), endpoints))
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("initial Mesh was not applied")
+	}
+	assert.True(t, applied())
+	assert.Equal(t, attempts+1, testutil.ToFloat64(initialApplyAttempts.WithLabelValues("applied")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(initialApplyWaiting.WithLabelValues("webhooks")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(initialApplyRetryDelay))
+}
+
+func TestApplyInitialMeshCancelled(t *testing.T) {
+	i, c := newTestInstaller(t, startObjects()...)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, i.applyInitialMesh(ctx), context.DeadlineExceeded)
+	assert.Error(t, c.Get(context.TODO(), client.ObjectKeyFromObject(i.Mesh), &v1alpha1.Mesh{}))
+}
+
+func TestWebhookServices(t *testing.T) {
+	i, _ := newTestInstaller(t)
+	services, err := webhookServices(context.TODO(), i.K8sClient)
+	assert.NoError(t, err)
+	assert.Empty(t, services)
+}
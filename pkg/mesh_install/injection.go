@@ -0,0 +1,178 @@
+package mesh_install
+
+import (
+	"fmt"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	"github.com/greymatter-io/operator/pkg/k8sapi"
+	"github.com/greymatter-io/operator/pkg/wellknown"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Namespaces that never receive sidecars, regardless of the mesh's injection policy.
+var systemNamespaces = map[string]bool{
+	"kube-system":     true,
+	"kube-public":     true,
+	"kube-node-lease": true,
+	"gm-operator":     true,
+}
+
+// SidecarRequested reports whether a workload's pod template in the given namespace asks for a sidecar with the
+// inject-sidecar-to annotation, and is eligible for one under THE mesh's injection policy.
+func (i *Installer) SidecarRequested(namespace string, tmpl *corev1.PodTemplateSpec) bool {
+	if tmpl == nil {
+		return false
+	}
+	if _, ok := tmpl.Annotations[wellknown.ANNOTATION_INJECT_SIDECAR_TO_PORT]; !ok {
+		return false
+	}
+	if ok, _ := i.InjectionAllowed(namespace, tmpl.Labels, tmpl.Annotations); !ok {
+		return false
+	}
+	_, ok, _ := i.SidecarPlatform(tmpl.Spec)
+	return ok
+}
+
+// SidecarPlatform reports whether a pod with the given spec can run a sidecar on the nodes it's pinned to, under
+// config.node_platforms, and returns the image its sidecar uses instead of the CUE's, if any. If it can't, it also
+// returns the reason. Pods that aren't pinned to an OS or architecture are assumed to run on Linux nodes the
+// sidecar image is built for.
+func (i *Installer) SidecarPlatform(spec corev1.PodSpec) (image string, ok bool, reason string) {
+	config := i.Config.NodePlatforms
+	os, arch := cuemodule.PodPlatform(spec)
+	switch os {
+	case "", "linux":
+	case "windows":
+		if config.WindowsSidecarImage == "" {
+			return "", false, "pinned to Windows nodes, and config.node_platforms.windows_sidecar_image is unset"
+		}
+		image = config.WindowsSidecarImage
+	default:
+		return "", false, fmt.Sprintf("pinned to %s nodes", os)
+	}
+
+	if arch != "" {
+		architectures := config.SidecarArchitectures
+		if len(architectures) == 0 {
+			architectures = cuemodule.DefaultSidecarArchitectures
+		}
+		supported := false
+		for _, a := range architectures {
+			supported = supported || a == arch
+		}
+		if !supported {
+			return "", false, fmt.Sprintf("pinned to %s nodes, which the sidecar image isn't built for", arch)
+		}
+	}
+	return image, true, ""
+}
+
+// InjectionAllowed reports whether a workload with the given pod template labels and annotations in the
+// given namespace is eligible for sidecar injection under THE mesh's injection policy, including whether
+// workloads injected by another service mesh are skipped. If not, it also returns the reason.
+// It does not check for a watched namespace or the inject-sidecar-to annotation.
+func (i *Installer) InjectionAllowed(namespace string, podLabels, podAnnotations map[string]string) (bool, string) {
+	if systemNamespaces[namespace] {
+		return false, fmt.Sprintf("namespace %s is a system namespace", namespace)
+	}
+	if podAnnotations[wellknown.ANNOTATION_EXCLUDE_SIDECAR] == "true" {
+		return false, fmt.Sprintf("annotated with %s", wellknown.ANNOTATION_EXCLUDE_SIDECAR)
+	}
+
+	policy := &v1alpha1.InjectionPolicy{}
+	if mesh := i.CurrentMesh(); mesh != nil && mesh.Spec.Injection != nil {
+		policy = mesh.Spec.Injection
+	}
+
+	for _, excluded := range policy.ExcludedNamespaces {
+		if namespace == excluded {
+			return false, fmt.Sprintf("namespace %s is excluded", namespace)
+		}
+	}
+
+	if policy.PodSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(policy.PodSelector)
+		if err != nil {
+			return false, fmt.Sprintf("invalid pod selector: %v", err)
+		}
+		if !selector.Matches(labels.Set(podLabels)) {
+			return false, "pod labels do not match the pod selector"
+		}
+	}
+
+	ns := &corev1.Namespace{}
+	ctx, cancel := k8sapi.WithTimeout(i.RunContext())
+	defer cancel()
+	if err := i.K8sClient.Get(ctx, client.ObjectKey{Name: namespace}, ns); err != nil {
+		if policy.NamespaceSelector != nil {
+			return false, fmt.Sprintf("failed to get namespace %s: %v", namespace, err)
+		}
+		logger.Error(err, "Failed to get namespace to check for other service meshes", "Namespace", namespace)
+	}
+
+	if policy.NamespaceSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(policy.NamespaceSelector)
+		if err != nil {
+			return false, fmt.Sprintf("invalid namespace selector: %v", err)
+		}
+		if !selector.Matches(labels.Set(ns.Labels)) {
+			return false, fmt.Sprintf("namespace %s labels do not match the namespace selector", namespace)
+		}
+	}
+
+	if other := otherMeshInjector(ns.ObjectMeta, podLabels, podAnnotations); other != "" {
+		if policy.OtherMeshes == otherMeshesInject {
+			i.warnOtherMesh(namespace, other, "injecting a Grey Matter sidecar anyway")
+			return true, ""
+		}
+		i.warnOtherMesh(namespace, other, "skipping Grey Matter sidecar injection")
+		return false, fmt.Sprintf("sidecar is injected by %s", other)
+	}
+
+	return true, ""
+}
+
+// The injection policy's other_meshes value for injecting a sidecar into workloads meshed by another service mesh.
+const otherMeshesInject = "inject"
+
+// otherMeshInjector returns the name of another service mesh that injects (or has injected) its sidecar
+// into a workload with the given namespace metadata and pod template labels and annotations, or "" if none does.
+func otherMeshInjector(ns metav1.ObjectMeta, podLabels, podAnnotations map[string]string) string {
+	switch {
+	case podAnnotations["sidecar.istio.io/status"] != "":
+		return "Istio"
+	case podLabels["sidecar.istio.io/inject"] == "false" || podAnnotations["sidecar.istio.io/inject"] == "false":
+		// Explicitly opted out of Istio, so neither of the following apply
+	case podLabels["sidecar.istio.io/inject"] == "true" || podAnnotations["sidecar.istio.io/inject"] == "true":
+		return "Istio"
+	case ns.Labels["istio-injection"] == "enabled" || ns.Labels["istio.io/rev"] != "":
+		return "Istio"
+	}
+
+	switch {
+	case podAnnotations["linkerd.io/proxy-version"] != "":
+		return "Linkerd"
+	case podAnnotations["linkerd.io/inject"] == "disabled":
+	case podAnnotations["linkerd.io/inject"] == "enabled":
+		return "Linkerd"
+	case ns.Annotations["linkerd.io/inject"] == "enabled":
+		return "Linkerd"
+	}
+
+	return ""
+}
+
+// warnOtherMesh emits a warning event on THE mesh about a workload that another service mesh injects into.
+func (i *Installer) warnOtherMesh(namespace, other, action string) {
+	logger.Info("Workload is also meshed by another service mesh", "Namespace", namespace, "Mesh", other, "Action", action)
+	mesh := i.CurrentMesh()
+	if i.recorder == nil || mesh == nil || mesh.UID == "" {
+		return
+	}
+	i.recorder.Eventf(mesh, corev1.EventTypeWarning, "OtherMeshDetected",
+		"A workload in namespace %s is injected with a %s sidecar; %s", namespace, other, action)
+}
@@ -0,0 +1,84 @@
+package mesh_install
+
+import (
+	"testing"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	"github.com/greymatter-io/operator/pkg/wellknown"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestInjectionAllowed(t *testing.T) {
+	scheme := runtime.NewScheme()
+	assert.NoError(t, clientgoscheme.AddToScheme(scheme))
+	var c client.Client = fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps", Labels: map[string]string{"mesh": "enabled"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "legacy"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "istio-apps", Labels: map[string]string{"istio-injection": "enabled"}}},
+	).Build()
+
+	policy := &v1alpha1.InjectionPolicy{
+		NamespaceSelector:  &metav1.LabelSelector{MatchLabels: map[string]string{"mesh": "enabled"}},
+		PodSelector:        &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "no-mesh", Operator: metav1.LabelSelectorOpDoesNotExist}}},
+		ExcludedNamespaces: []string{"batch"},
+	}
+
+	cases := map[string]struct {
+		policy      *v1alpha1.InjectionPolicy
+		namespace   string
+		labels      map[string]string
+		annotations map[string]string
+		expected    bool
+	}{
+		"no policy":               {namespace: "legacy", expected: true},
+		"system namespace":        {namespace: "kube-system", expected: false},
+		"exclusion annotation":    {namespace: "legacy", annotations: map[string]string{wellknown.ANNOTATION_EXCLUDE_SIDECAR: "true"}, expected: false},
+		"matching namespace":      {policy: policy, namespace: "apps", expected: true},
+		"excluded namespace":      {policy: policy, namespace: "batch", expected: false},
+		"non-matching namespace":  {policy: policy, namespace: "legacy", expected: false},
+		"non-matching pod labels": {policy: policy, namespace: "apps", labels: map[string]string{"no-mesh": ""}, expected: false},
+		"istio namespace":         {namespace: "istio-apps", expected: false},
+		"istio opt-out":           {namespace: "istio-apps", labels: map[string]string{"sidecar.istio.io/inject": "false"}, expected: true},
+		"linkerd annotation":      {namespace: "legacy", annotations: map[string]string{"linkerd.io/inject": "enabled"}, expected: false},
+		"other mesh allowed":      {policy: &v1alpha1.InjectionPolicy{OtherMeshes: "inject"}, namespace: "istio-apps", expected: true},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			i := &Installer{K8sClient: c, Mesh: &v1alpha1.Mesh{Spec: v1alpha1.MeshSpec{Injection: tc.policy}}}
+			allowed, reason := i.InjectionAllowed(tc.namespace, tc.labels, tc.annotations)
+			assert.Equal(t, tc.expected, allowed, reason)
+		})
+	}
+}
+
+func TestSidecarPlatform(t *testing.T) {
+	windows := corev1.PodSpec{NodeSelector: map[string]string{"kubernetes.io/os": "windows"}}
+	s390x := corev1.PodSpec{NodeSelector: map[string]string{"kubernetes.io/arch": "s390x"}}
+
+	i := &Installer{}
+	image, ok, _ := i.SidecarPlatform(corev1.PodSpec{})
+	assert.True(t, ok)
+	assert.Empty(t, image)
+	_, ok, reason := i.SidecarPlatform(windows)
+	assert.False(t, ok)
+	assert.Contains(t, reason, "windows_sidecar_image")
+	_, ok, _ = i.SidecarPlatform(s390x)
+	assert.False(t, ok)
+
+	i.Config.NodePlatforms = cuemodule.NodePlatformConfig{WindowsSidecarImage: "greymatter/proxy:windows", SidecarArchitectures: []string{"s390x"}}
+	image, ok, _ = i.SidecarPlatform(windows)
+	assert.True(t, ok)
+	assert.Equal(t, "greymatter/proxy:windows", image)
+	_, ok, _ = i.SidecarPlatform(s390x)
+	assert.True(t, ok)
+	_, ok, _ = i.SidecarPlatform(corev1.PodSpec{NodeSelector: map[string]string{"kubernetes.io/arch": "amd64"}})
+	assert.False(t, ok)
+}
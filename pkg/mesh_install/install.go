@@ -2,24 +2,47 @@ package mesh_install
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/greymatter-io/operator/api/v1alpha1"
 	"github.com/greymatter-io/operator/pkg/cuemodule"
-	"github.com/greymatter-io/operator/pkg/gmapi"
+	"github.com/greymatter-io/operator/pkg/gitops"
 	"github.com/greymatter-io/operator/pkg/k8sapi"
 	"github.com/greymatter-io/operator/pkg/wellknown"
 	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 // ApplyMesh installs and updates Grey Matter core components and dependencies for a single mesh.
-func (i *Installer) ApplyMesh(prev, mesh *v1alpha1.Mesh) {
+// The rollouts of the Deployments and StatefulSets it changes are tracked in the mesh's RolledOut condition.
+// When updating a mesh with rollback configured, it waits for the changed workloads to roll out, and if any fail,
+// restores the previous version of each changed manifest and returns an error.
+// Each request to the apiserver ends with ctx, or once its time is up (see k8sapi.SetTimeout).
+// Applies and removals of the mesh are made one at a time, in the order they're called.
+// Once the operator is shutting down, it's refused (see Shutdown).
+func (i *Installer) ApplyMesh(ctx context.Context, prev, mesh *v1alpha1.Mesh) error {
+	if !i.applies.begin() {
+		logger.Info("Not applying Mesh while shutting down", "Name", mesh.Name)
+		return errShuttingDown
+	}
+	defer i.applies.end()
+	i.apply.Lock()
+	defer i.apply.Unlock()
 	if prev == nil {
 		logger.Info("Installing Mesh", "Name", mesh.Name)
 	} else {
 		logger.Info("Updating Mesh", "Name", mesh.Name)
 	}
+	i.configureGitOps(ctx, mesh)
+	// Watch the namespaces matching the mesh's selector along with those it lists
+	mesh = i.withSelectedNamespaces(ctx, mesh)
 
 	// Create Namespace and image pull secret if this Mesh is new.
 	if prev == nil {
@@ -29,14 +52,14 @@ func (i *Installer) ApplyMesh(prev, mesh *v1alpha1.Mesh) {
 				Name: mesh.Spec.InstallNamespace,
 			},
 		}
-		k8sapi.Apply(i.K8sClient, namespace, mesh, k8sapi.GetOrCreate)
+		k8sapi.Apply(ctx, i.K8sClient, namespace, mesh, k8sapi.GetOrCreate)
 		secret := i.imagePullSecret.DeepCopy()
 		secret.Namespace = mesh.Spec.InstallNamespace
 
 		if i.Config.AutoCopyImagePullSecret {
-			k8sapi.Apply(i.K8sClient, secret, mesh, k8sapi.GetOrCreate)
+			k8sapi.Apply(ctx, i.K8sClient, secret, mesh, k8sapi.GetOrCreate)
 		} else {
-			err := k8sapi.Apply(i.K8sClient, secret, mesh, k8sapi.Get)
+			err := k8sapi.Apply(ctx, i.K8sClient, secret, mesh, k8sapi.Get)
 			if err != nil {
 				logger.Info("imagePullSecret not found in Core Mesh namespace", "AutoCopyImagePullSecret", i.Config.AutoCopyImagePullSecret, "Mesh Namespace", mesh.Spec.InstallNamespace)
 			}
@@ -52,148 +75,299 @@ func (i *Installer) ApplyMesh(prev, mesh *v1alpha1.Mesh) {
 			},
 		}
 
-		k8sapi.Apply(i.K8sClient, namespace, mesh, k8sapi.GetOrCreate)
-		// Copy the imagePullSecret into all watched namespaces
-		secret := i.imagePullSecret.DeepCopy()
-		secret.Namespace = watchedNS
-
-		if i.Config.AutoCopyImagePullSecret {
-			k8sapi.Apply(i.K8sClient, secret, mesh, k8sapi.GetOrCreate)
-			logger.Info("imagePullSecret found or created", "AutoCopyImagePullSecret", i.Config.AutoCopyImagePullSecret, "WatchNamespace", watchedNS)
-		} else {
-			err := k8sapi.Apply(i.K8sClient, secret, mesh, k8sapi.Get)
+		k8sapi.Apply(ctx, i.K8sClient, namespace, mesh, k8sapi.GetOrCreate)
+		// The imagePullSecret is copied into all watched namespaces below if configured; otherwise it must exist
+		if !i.Config.AutoCopyImagePullSecret {
+			secret := i.imagePullSecret.DeepCopy()
+			secret.Namespace = watchedNS
+			err := k8sapi.Apply(ctx, i.K8sClient, secret, mesh, k8sapi.Get)
 			if err != nil {
 				logger.Info("imagePullSecret not found in watched namespace", "AutoCopyImagePullSecret", i.Config.AutoCopyImagePullSecret, "WatchNamespace", watchedNS)
 			}
 		}
 	}
+	// Copy the imagePullSecret and apply the configured Roles into the watched namespaces, and remove them from
+	// namespaces no longer watched
+	i.applyWatchedNamespaces(ctx, mesh)
+
+	// Label the mesh's namespaces, and unlabel those no longer in it
+	i.labelNamespaces(ctx, mesh)
 
 	// If we're updating an existing mesh, we need to reload the CUE before unification to avoid a situation
 	// where the old concrete values conflict with the new ones
 	// TODO once the CRD is removed, this will be redundant because the new CUE will already be reloaded into the Installer
+	operatorCUE := *i.CurrentOperatorCUE()
 	if prev != nil {
-		freshLoadOperatorCUE, _, err := cuemodule.LoadAll(i.CueRoot)
+		freshLoadOperatorCUE, _, err := cuemodule.LoadAll(i.CurrentCueRoot(), i.CUEOptions...)
 		if err != nil {
 			logger.Error(err, "failed to load CUE during Apply")
-			return
+			return err
 		}
-		i.OperatorCUE = freshLoadOperatorCUE
+		freshLoadOperatorCUE.CopyMeshSettings(&operatorCUE)
+		operatorCUE = *freshLoadOperatorCUE
 	}
-	// Do unification between the Mesh and K8s CUE here before extraction, and save the unified values
-	err := i.OperatorCUE.UnifyWithMesh(mesh)
+	// Do unification between the Mesh and K8s CUE here before extraction, and save the unified values, replacing
+	// OperatorCUE only once they're unified so it's never read half-way through
+	err := operatorCUE.UnifyWithMesh(mesh)
 	if err != nil {
 		logger.Error(err,
 			"error while attempting to unify provided Mesh resource with loaded CUE",
 			"Mesh", mesh)
-		return
+		return err
+	}
+	// The edge's auth is added to its listener wherever the Grey Matter config is extracted, the config is migrated
+	// from the release it's written for, and disabled components are left out
+	if err := i.configureEdgeAuth(ctx, &operatorCUE, mesh); err != nil {
+		logger.Error(err, "failed to configure edge auth", "Mesh", mesh.Name)
+		return err
 	}
+	operatorCUE.SetGMConfigVersion(mesh.Spec.ConfigVersion)
+	operatorCUE.SetDisabledComponents(mesh.Spec.DisabledComponents)
+	i.setOperatorCUE(&operatorCUE)
+	// Unlike the rest of the config, feature flags are taken from the CUE being applied
+	i.refreshFeatures()
 
 	// Extract 'em
-	manifestObjects, err := i.OperatorCUE.ExtractCoreK8sManifests()
+	manifestObjects, err := operatorCUE.ExtractCoreK8sManifests()
 	if err != nil {
 		logger.Error(err, "failed to extract k8s manifests")
-		return
+		return err
+	}
+	cuemodule.ApplyComponentOverrides(manifestObjects, mesh.Spec.Overrides)
+	if i.Config.NodePlatforms.PinLinux {
+		cuemodule.PinToLinux(manifestObjects)
+	}
+	cuemodule.ApplyArchImages(manifestObjects, i.Config.NodePlatforms.ArchImages, i.nodeArchitectures)
+	// Preceded by the PriorityClass and PodDisruptionBudgets of the core components, if configured
+	manifestObjects = append(mkAvailabilityObjects(i.Config.Availability, mesh, manifestObjects), manifestObjects...)
+	// And followed by the autoscalers of those the mesh autoscales, which keep the replicas they've scaled to
+	manifestObjects = append(manifestObjects, mkAutoscalingObjects(mesh, manifestObjects)...)
+	i.keepAutoscaledReplicas(ctx, mesh, manifestObjects)
+	manifestObjects = append(manifestObjects, mkIngressObjects(i.Config.Ingress, i.ingress, manifestObjects)...)
+	// Along with each catalogued service's host at the edge, if generated from config.ingress.host_template, and its
+	// certificate
+	if gmCUE, err := operatorCUE.TempGMValueUnifiedWithDefaults(i.CurrentDefaults()); err != nil {
+		logger.Error(err, "failed to generate edge hosts")
+	} else if hosts, err := gmCUE.EdgeHosts(); err != nil {
+		logger.Error(err, "failed to generate edge hosts")
+	} else {
+		manifestObjects = append(manifestObjects, mkEdgeHostObjects(i.Config.Ingress, i.ingress, hosts, manifestObjects)...)
+		manifestObjects = append(manifestObjects, mkEdgeCertificates(i.Config.Ingress, mesh.Spec.InstallNamespace, hosts)...)
+	}
+	// Along with the scrape targets of core components and sidecars
+	manifestObjects = append(manifestObjects, mkMonitoringObjects(i.Config.Monitoring, i.monitoring, mesh, manifestObjects)...)
+
+	// Installs outside of a GitOps cycle get a fresh report of their own
+	report := i.Sync.CurrentReport()
+	if prev == nil || report == nil {
+		report = i.Sync.BeginReport("")
+	}
+
+	// Refuse the whole sync, before anything is applied, if Control would reject pieces of its Grey Matter config
+	configs, kinds, err := i.auditMeshConfigs(mesh, report)
+	if err != nil {
+		return err
+	}
+	i.publishTopology(ctx, configs, kinds, report.Commit())
+	// Or if the core components won't fit in their namespaces' quotas or on the cluster's nodes, unless that's only
+	// warned of
+	if err := i.checkResourceBudget(ctx, mesh, manifestObjects, report); err != nil {
+		return err
+	}
+
+	// Label everything we apply, so objects that later disappear from the manifests can be found and pruned
+	for _, manifest := range manifestObjects {
+		k8sapi.MarkManaged(manifest, i.manager(mesh))
 	}
+	inventory := i.Sync.SyncState.K8sInventory()
 
 	// Remove anything from the list that hasn't changed since the last known update
 	changedManifestObjects, deletedManifestObjects := i.Sync.SyncState.FilterChangedK8s(manifestObjects)
-	// Apply the changed k8s manifests
+	// Apply the changed k8s manifests, optionally skipping those that already match the live objects
 	logger.Info("Applying updated Kubernetes manifests, if any")
+	apply := k8sapi.CreateOrUpdate
+	if i.Config.Reconcile.SkipUnchangedLive {
+		apply = k8sapi.CreateOrUpdateIfChanged
+	}
+	// With drift enforcement, the unchanged manifests are applied too, restoring live objects changed out of band,
+	// though only those that no longer match are written
+	if i.FeatureEnabled(cuemodule.FeatureDriftEnforcement) {
+		changedManifestObjects, apply = manifestObjects, k8sapi.CreateOrUpdateIfChanged
+	}
+	// An initial install isn't rolled back, since there's nothing to restore
+	rollback := i.Config.Rollout.Rollback && prev != nil
+	applyStarted := time.Now()
+	var applied []appliedManifest
 	for _, manifest := range changedManifestObjects {
 		logger.Info("Applying manifest:",
 			"Name", manifest.GetName(),
 			"Repr", manifest)
 
-		k8sapi.Apply(i.K8sClient, manifest, mesh, k8sapi.CreateOrUpdate)
+		// Capture the live version first, so it can be restored if the sync cycle's rollouts fail
+		a := appliedManifest{manifest: manifest, ref: *gitops.NewK8sObjectRef(manifest)}
+		if rollback {
+			prior, err := k8sapi.Snapshot(ctx, i.K8sClient, manifest)
+			if err != nil {
+				logger.Error(err, "Failed to capture the live version of manifest; it won't be rolled back", "Name", manifest.GetName())
+			}
+			a.prior, a.captured = prior, err == nil
+		}
+
+		err := k8sapi.Apply(ctx, i.K8sClient, manifest, mesh, apply)
+		report.Record("k8s", manifest.GetObjectKind().GroupVersionKind().Kind, manifest.GetNamespace(), manifest.GetName(), "apply", err)
+		if err == nil {
+			applied = append(applied, a)
+		}
+	}
+	// And delete the deleted ones (unless held back by the deletion policy)
+	k8sapi.DeleteAll(ctx, i.K8sClient, deletedManifestObjects, report)
+	// And anything labeled as ours that the inventory lost track of (e.g. after a reset of state in Redis)
+	if i.Config.PruneOrphans {
+		k8sapi.Prune(ctx, i.K8sClient, i.manager(mesh), meshNamespaces(mesh), manifestObjects, inventory, i.Config.Reconcile.PageSize, i.Sync.SyncState.GuardOrphans, report)
 	}
-	// And delete the deleted ones
-	k8sapi.DeleteAll(i.K8sClient, deletedManifestObjects)
 
-	if prev == nil {
-		i.ConfigureMeshClient(mesh, i.Sync) // Synchronously applies the Grey Matter configuration once Control and Catalog are up
-	} else {
+	// Then wait for the changed workloads to roll out if any failures are to be rolled back
+	var rolloutErr error
+	workloads := rolloutWorkloads(applied)
+	if rollback && len(workloads) > 0 {
+		if failed := i.trackRollouts(i.beginRollouts(ctx), workloads, applyStarted, i.Config.Rollout.Timeout()); len(failed) > 0 {
+			var failures []string
+			for workload, err := range failed {
+				kind := workload.GetObjectKind().GroupVersionKind().Kind
+				report.Record("k8s", kind, workload.GetNamespace(), workload.GetName(), "rollout", err)
+				failures = append(failures, fmt.Sprintf("%s %s/%s: %v", kind, workload.GetNamespace(), workload.GetName(), err))
+			}
+			sort.Strings(failures)
+			rolloutErr = fmt.Errorf("rolled back the Kubernetes manifests after failed rollouts: %s", strings.Join(failures, "; "))
+			if err := i.rollBack(ctx, applied, failed, report); err != nil {
+				rolloutErr = fmt.Errorf("%w; %v", rolloutErr, err)
+			}
+			logger.Error(rolloutErr, "Sync failed", "Mesh", mesh.Name)
+			if i.recorder != nil && mesh.UID != "" {
+				i.recorder.Event(mesh, v1.EventTypeWarning, "RolledBack", rolloutErr.Error())
+			}
+		}
+	}
+
+	// Applies the Grey Matter configuration once Control and Catalog are up, with a new client if the mesh is new or
+	// its API endpoints changed
+	if prev != nil {
 		logger.Info("Applying updated mesh configs, if any")
-		i.EnsureClient("ApplyMesh")
-		go gmapi.ApplyCoreMeshConfigs(i.Client, i.OperatorCUE)
 	}
-	i.Mesh = mesh // set this mesh as THE mesh managed by the operator
+	i.ConfigureMeshClient(&operatorCUE, mesh, i.Sync)
+	i.setMesh(mesh) // set this mesh as THE mesh managed by the operator
+	if mesh.UID != "" {
+		i.registerSelf(ctx, mesh)
+	}
+	// Otherwise track their rollouts in the background
+	if !rollback && len(workloads) > 0 {
+		go i.trackRollouts(i.beginRollouts(ctx), workloads, applyStarted, i.Config.Rollout.Timeout())
+	}
+	return rolloutErr
+}
+
+// auditMeshConfigs checks the core Grey Matter config extracted for the mesh with cuemodule.AuditGMConfigObjects,
+// and against the schema of Control in the mesh's release version, recording each problem in the sync report and
+// returning an error listing them all. Otherwise it returns the config, with its kinds.
+func (i *Installer) auditMeshConfigs(mesh *v1alpha1.Mesh, report *gitops.SyncReport) ([]json.RawMessage, []string, error) {
+	configs, kinds, err := i.CurrentOperatorCUE().ExtractCoreMeshConfigs()
+	if err != nil {
+		logger.Error(err, "failed to extract Grey Matter config")
+		return nil, nil, err
+	}
+	problems := cuemodule.AuditGMConfigObjects(configs, kinds)
+	problems = append(problems, cuemodule.ValidateGMSchema(mesh.Spec.ReleaseVersion, configs, kinds)...)
+	if len(problems) == 0 {
+		return configs, kinds, nil
+	}
+	var lines []string
+	for _, p := range problems {
+		report.Record("gm", p.Kind, p.Zone, p.Key, "audit", errors.New(p.Problem))
+		lines = append(lines, p.String())
+	}
+	err = fmt.Errorf("refusing to apply Grey Matter config with %d problems:\n  %s", len(problems), strings.Join(lines, "\n  "))
+	logger.Error(err, "Sync failed", "Mesh", mesh.Name)
+	if i.recorder != nil && mesh.UID != "" {
+		i.recorder.Event(mesh, v1.EventTypeWarning, "InvalidConfig", err.Error())
+	}
+	return nil, nil, err
 }
 
 // RemoveMesh removes all references to a deleted Mesh custom resource.
 // It does not uninstall core components and dependencies, since that is handled
 // by the apiserver when the Mesh custom resource is deleted.
-func (i *Installer) RemoveMesh(mesh *v1alpha1.Mesh) {
+func (i *Installer) RemoveMesh(ctx context.Context, mesh *v1alpha1.Mesh) {
+	if !i.applies.begin() {
+		logger.Info("Not uninstalling Mesh while shutting down", "Name", mesh.Name)
+		return
+	}
+	defer i.applies.end()
+	i.apply.Lock()
+	defer i.apply.Unlock()
 	logger.Info("Uninstalling Mesh", "Name", mesh.Name)
 
-	go i.RemoveMeshClient()
+	go i.RemoveMeshClient(mesh.Name)
 
 	// Reload the starter Mesh CUE so it can be unified with a new one in the future
-	freshLoadOperatorCUE, freshLoadMesh, err := cuemodule.LoadAll(i.CueRoot)
+	freshLoadOperatorCUE, freshLoadMesh, err := cuemodule.LoadAll(i.CurrentCueRoot(), i.CUEOptions...)
 	if err != nil {
 		logger.Error(err, "unable to load fresh CUE from disk while removing mesh - check mesh integrity")
-	}
-	i.OperatorCUE = freshLoadOperatorCUE
-	i.Mesh = freshLoadMesh
-
-	// Remove label for existing deployments and statefulsets
-	deployments := &appsv1.DeploymentList{}
-	(*i.K8sClient).List(context.TODO(), deployments)
-	for _, deployment := range deployments.Items {
-		watched := false
-		for _, ns := range mesh.Spec.WatchNamespaces {
-			if deployment.Namespace == ns {
-				watched = true
-				break
-			}
-		}
-		if watched {
-			dirty := false
-			if deployment.Spec.Template.Labels == nil {
-				dirty = true
-				deployment.Spec.Template.Labels = make(map[string]string)
-			}
-			if _, ok := deployment.Spec.Template.Labels[wellknown.LABEL_CLUSTER]; ok {
-				dirty = true
-				delete(deployment.Spec.Template.Labels, wellknown.LABEL_CLUSTER)
-			}
-			if _, ok := deployment.Spec.Template.Labels[wellknown.LABEL_WORKLOAD]; ok {
-				dirty = true
-				delete(deployment.Spec.Template.Labels, wellknown.LABEL_WORKLOAD)
-			}
-			if dirty {
-				k8sapi.Apply(i.K8sClient, &deployment, nil, k8sapi.CreateOrUpdate)
-			}
+	} else {
+		// Keeping the cluster's domain, which doesn't change with the mesh
+		if i.ingress != nil {
+			freshLoadOperatorCUE.SetClusterDomain(i.ingress.Domain())
 		}
+		i.setOperatorCUE(freshLoadOperatorCUE)
 	}
+	i.setMesh(freshLoadMesh)
 
-	statefulsets := &appsv1.StatefulSetList{}
-	(*i.K8sClient).List(context.TODO(), statefulsets)
-	for _, statefulset := range statefulsets.Items {
-		watched := false
-		for _, ns := range mesh.Spec.WatchNamespaces {
-			if statefulset.Namespace == ns {
-				watched = true
-				break
+	// Remove the standard labels from the mesh's namespaces, and the resources applied to its watched namespaces
+	i.unlabelNamespaces(ctx, mesh.Name, nil)
+	i.cleanUpWatchedNamespaces(ctx, mesh, nil)
+	// Remove the operator's own sidecar, which has no mesh to join
+	i.unregisterSelf(ctx)
+
+	// Remove label for existing deployments and statefulsets in watched namespaces (other than our own components)
+	pageSize := i.Config.Reconcile.PageSize
+	for _, ns := range mesh.Spec.WatchNamespaces {
+		deployments := &appsv1.DeploymentList{}
+		if err := k8sapi.ListPages(ctx, i.K8sClient, deployments, pageSize, func() error {
+			for idx := range deployments.Items {
+				deployment := &deployments.Items[idx]
+				if removeClusterLabels(&deployment.Spec.Template) {
+					k8sapi.Apply(ctx, i.K8sClient, deployment, nil, k8sapi.CreateOrUpdate)
+				}
 			}
+			return nil
+		}, client.InNamespace(ns), k8sapi.NotManaged()); err != nil {
+			logger.Error(err, "Failed to list deployments to remove mesh labels", "Namespace", ns)
 		}
-		if watched {
-			dirty := false
-			if statefulset.Spec.Template.Labels == nil {
-				dirty = true
-				statefulset.Spec.Template.Labels = make(map[string]string)
-			}
-			if _, ok := statefulset.Spec.Template.Labels[wellknown.LABEL_CLUSTER]; ok {
-				dirty = true
-				delete(statefulset.Spec.Template.Labels, wellknown.LABEL_CLUSTER)
-			}
-			if _, ok := statefulset.Spec.Template.Labels[wellknown.LABEL_WORKLOAD]; ok {
-				dirty = true
-				delete(statefulset.Spec.Template.Labels, wellknown.LABEL_WORKLOAD)
-			}
-			if dirty {
-				k8sapi.Apply(i.K8sClient, &statefulset, nil, k8sapi.CreateOrUpdate)
+
+		statefulsets := &appsv1.StatefulSetList{}
+		if err := k8sapi.ListPages(ctx, i.K8sClient, statefulsets, pageSize, func() error {
+			for idx := range statefulsets.Items {
+				statefulset := &statefulsets.Items[idx]
+				if removeClusterLabels(&statefulset.Spec.Template) {
+					k8sapi.Apply(ctx, i.K8sClient, statefulset, nil, k8sapi.CreateOrUpdate)
+				}
 			}
+			return nil
+		}, client.InNamespace(ns), k8sapi.NotManaged()); err != nil {
+			logger.Error(err, "Failed to list statefulsets to remove mesh labels", "Namespace", ns)
 		}
 	}
+}
 
+// removeClusterLabels removes the labels added to a pod template for a mesh, and reports whether it changed.
+func removeClusterLabels(template *v1.PodTemplateSpec) bool {
+	dirty := false
+	if _, ok := template.Labels[wellknown.LABEL_CLUSTER]; ok {
+		dirty = true
+		delete(template.Labels, wellknown.LABEL_CLUSTER)
+	}
+	if _, ok := template.Labels[wellknown.LABEL_WORKLOAD]; ok {
+		dirty = true
+		delete(template.Labels, wellknown.LABEL_WORKLOAD)
+	}
+	return dirty
 }
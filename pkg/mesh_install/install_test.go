@@ -0,0 +1,51 @@
+package mesh_install
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	"github.com/greymatter-io/operator/pkg/gitops"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestApplyMeshRefusesInvalidConfig(t *testing.T) {
+	i, c := newTestInstaller(t, startObjects()...)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	i.Sync.SyncState = gitops.NewSyncState(ctx, cuemodule.Defaults{RedisHost: "127.0.0.1", RedisPort: 1}, "")
+
+	write := func(path, contents string) {
+		assert.NoError(t, os.WriteFile(filepath.Join(i.CueRoot, path), []byte(contents), 0600))
+	}
+	write("k8s/outputs/outputs.cue", testCUE["k8s/outputs/outputs.cue"]+`
+k8s_manifests: [{
+	apiVersion: "v1"
+	kind:       "ConfigMap"
+	metadata: {name: "settings", namespace: "greymatter"}
+}]
+`)
+	write("gm/outputs/outputs.cue", `package outputs
+
+mesh_configs: [
+	{route_key: "catalog", domain_key: "edge", zone_key: "default-zone"},
+]
+`)
+	_, mesh, err := cuemodule.LoadAll(i.CueRoot)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	err = i.ApplyMesh(context.TODO(), mesh, mesh.DeepCopy())
+	assert.Contains(t, fmt.Sprint(err), `route default-zone/catalog: domain_key refers to domain "edge", which doesn't exist`)
+	_, _, failed, failures := i.Sync.CurrentReport().Summary()
+	assert.Equal(t, 1, failed)
+	assert.Contains(t, fmt.Sprint(failures), "audit route default-zone/catalog")
+	assert.Error(t, c.Get(context.TODO(), client.ObjectKey{Namespace: "greymatter", Name: "settings"}, &corev1.ConfigMap{}),
+		"nothing is applied")
+}
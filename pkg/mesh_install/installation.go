@@ -0,0 +1,253 @@
+package mesh_install
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	"github.com/greymatter-io/operator/pkg/gitops"
+	"github.com/greymatter-io/operator/pkg/wellknown"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// The name of the OperatorInstallation that owns the operator's cluster-scoped resources.
+	installationName = "gm-operator"
+	// The CRD that owned cluster-scoped resources before OperatorInstallations, whose ownership is handed over.
+	legacyOwnerCRD = "meshes.greymatter.io"
+)
+
+// How long to wait before retrying the cleanup of a deleted OperatorInstallation.
+var cleanupRetryInterval = 10 * time.Second
+
+// ensureInstallation gets the OperatorInstallation, creating it if it doesn't exist, and makes sure it holds the
+// cleanup finalizer unless it's already being deleted.
+func (i *Installer) ensureInstallation(ctx context.Context) error {
+	installation := &v1alpha1.OperatorInstallation{}
+	err := i.K8sClient.Get(ctx, client.ObjectKey{Name: installationName}, installation)
+	if errors.IsNotFound(err) {
+		installation = &v1alpha1.OperatorInstallation{
+			ObjectMeta: metav1.ObjectMeta{Name: installationName, Finalizers: []string{wellknown.FINALIZER_CLEANUP}},
+			Spec:       v1alpha1.OperatorInstallationSpec{CleanupPolicy: v1alpha1.CleanupDelete},
+		}
+		if err := i.K8sClient.Create(ctx, installation); err != nil {
+			return fmt.Errorf("failed to create OperatorInstallation %s: %w", installationName, err)
+		}
+		logger.Info("Created OperatorInstallation", "Name", installationName)
+	} else if err != nil {
+		return fmt.Errorf("failed to get OperatorInstallation %s: %w", installationName, err)
+	} else if installation.DeletionTimestamp.IsZero() && controllerutil.AddFinalizer(installation, wellknown.FINALIZER_CLEANUP) {
+		if err := i.K8sClient.Update(ctx, installation); err != nil {
+			return fmt.Errorf("failed to add finalizer to OperatorInstallation %s: %w", installationName, err)
+		}
+	}
+	i.owner = installation
+	return nil
+}
+
+// installationObjects returns references to the cluster-scoped resources the operator installs outside of a mesh,
+// which are owned by the OperatorInstallation, in the order they're cleaned up: the SPIRE manifests, then the
+// server-ca secret (or what it's delivered by), then the spire namespace.
+func (i *Installer) installationObjects() []gitops.K8sObjectRef {
+	if !i.Config.Spire {
+		return nil
+	}
+	external := i.Config.SpireInstall.External
+
+	var refs []gitops.K8sObjectRef
+	if !external {
+		manifests, err := i.CurrentOperatorCUE().ExtractSpireK8sManifests()
+		if err != nil {
+			logger.Error(err, "failed to extract SPIRE manifests")
+		}
+		for _, manifest := range manifests {
+			refs = append(refs, *gitops.NewK8sObjectRef(manifest))
+		}
+	}
+
+	serverCA := schema.GroupVersionKind{Version: "v1", Kind: "Secret"}
+	switch i.Config.KeyDelivery.Mode {
+	case cuemodule.KeyDeliveryExternalSecret:
+		serverCA = schema.GroupVersionKind{Group: "external-secrets.io", Version: "v1beta1", Kind: "ExternalSecret"}
+	case cuemodule.KeyDeliverySealedSecret:
+		serverCA = schema.GroupVersionKind{Group: "bitnami.com", Version: "v1alpha1", Kind: "SealedSecret"}
+	}
+	refs = append(refs, gitops.K8sObjectRef{Namespace: spireNamespace, Kind: serverCA, Name: "server-ca"})
+
+	if !external {
+		refs = append(refs, gitops.K8sObjectRef{Kind: schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}, Name: spireNamespace})
+	}
+	return refs
+}
+
+// forEachInstallationObject calls fn with each of the installation's objects that exists, returning the errors of
+// those it couldn't get or fn failed for. Objects of kinds the cluster doesn't serve are skipped.
+func (i *Installer) forEachInstallationObject(ctx context.Context, fn func(*unstructured.Unstructured) error) error {
+	var failed []string
+	for _, ref := range i.installationObjects() {
+		obj := &unstructured.Unstructured{}
+		obj.SetGroupVersionKind(ref.Kind)
+		err := i.K8sClient.Get(ctx, client.ObjectKey{Namespace: ref.Namespace, Name: ref.Name}, obj)
+		if errors.IsNotFound(err) || meta.IsNoMatchError(err) || runtime.IsNotRegisteredError(err) {
+			continue
+		}
+		if err == nil {
+			err = fn(obj)
+		}
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s %s: %v", ref.Kind.Kind, client.ObjectKey{Namespace: ref.Namespace, Name: ref.Name}, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// installationRef returns an owner reference to the OperatorInstallation.
+func (i *Installer) installationRef() metav1.OwnerReference {
+	return metav1.OwnerReference{
+		APIVersion: v1alpha1.GroupVersion.String(),
+		Kind:       "OperatorInstallation",
+		Name:       i.owner.Name,
+		UID:        i.owner.UID,
+	}
+}
+
+// adoptInstallationObjects hands ownership of the installation's objects from the meshes.greymatter.io CRD, which
+// owned them before OperatorInstallations, to the OperatorInstallation, so deleting the CRD no longer deletes them.
+func (i *Installer) adoptInstallationObjects(ctx context.Context) error {
+	return i.forEachInstallationObject(ctx, func(obj *unstructured.Unstructured) error {
+		var refs []metav1.OwnerReference
+		adopted := false
+		for _, ref := range obj.GetOwnerReferences() {
+			if ref.Kind == "CustomResourceDefinition" && ref.Name == legacyOwnerCRD {
+				adopted = true
+				continue
+			}
+			refs = append(refs, ref)
+		}
+		if !adopted {
+			return nil
+		}
+		if !ownedBy(refs, i.owner) {
+			refs = append(refs, i.installationRef())
+		}
+		obj.SetOwnerReferences(refs)
+		logger.Info("Handing ownership to OperatorInstallation", "Kind", obj.GetKind(), "Namespace", obj.GetNamespace(), "Name", obj.GetName())
+		return i.K8sClient.Update(ctx, obj)
+	})
+}
+
+// watchInstallation waits for the OperatorInstallation to be deleted, then stops SPIRE's reconciliation and cleans up
+// the resources the installation owns according to its cleanup policy, retrying until it succeeds or ctx is done.
+// Once they're cleaned up, it removes the installation's finalizer so its deletion can complete.
+func (i *Installer) watchInstallation(ctx context.Context, stopSpire context.CancelFunc) {
+	installation := &v1alpha1.OperatorInstallation{}
+	key := client.ObjectKey{Name: installationName}
+	if err := waitUntil(ctx, i.K8sClient, installation, &v1alpha1.OperatorInstallationList{}, key, func(obj client.Object) bool {
+		return !obj.GetDeletionTimestamp().IsZero()
+	}); err != nil {
+		return
+	}
+	logger.Info("OperatorInstallation deleted, cleaning up the resources it owns", "Name", installationName)
+	stopSpire()
+
+	for {
+		// Get it again, as waitUntil's copy may predate the deletion
+		if err := i.K8sClient.Get(ctx, key, installation); errors.IsNotFound(err) {
+			return
+		} else if err != nil {
+			logger.Error(err, "Failed to get OperatorInstallation - will retry", "Name", installationName)
+		} else if err := i.cleanUpInstallation(ctx, installation); err != nil {
+			logger.Error(err, "Failed to clean up OperatorInstallation - will retry", "Name", installationName, "CleanupPolicy", installation.Spec.CleanupPolicy)
+			i.setCleanupBlocked(ctx, err)
+		} else {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(cleanupRetryInterval):
+		}
+	}
+}
+
+// cleanUpInstallation deletes the installation's objects if its cleanup policy is Delete, or leaves them unowned if
+// it's Orphan, then removes its finalizer. Objects it doesn't own are left alone.
+func (i *Installer) cleanUpInstallation(ctx context.Context, installation *v1alpha1.OperatorInstallation) error {
+	err := i.forEachInstallationObject(ctx, func(obj *unstructured.Unstructured) error {
+		refs := obj.GetOwnerReferences()
+		if !ownedBy(refs, installation) {
+			return nil
+		}
+		if installation.Spec.CleanupPolicy == v1alpha1.CleanupOrphan {
+			var kept []metav1.OwnerReference
+			for _, ref := range refs {
+				if !isInstallationRef(ref, installation) {
+					kept = append(kept, ref)
+				}
+			}
+			obj.SetOwnerReferences(kept)
+			return i.K8sClient.Update(ctx, obj)
+		}
+		if err := i.K8sClient.Delete(ctx, obj); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if controllerutil.RemoveFinalizer(installation, wellknown.FINALIZER_CLEANUP) {
+		if err := i.K8sClient.Update(ctx, installation); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to remove finalizer: %w", err)
+		}
+	}
+	logger.Info("Cleaned up OperatorInstallation", "Name", installationName)
+	return nil
+}
+
+// setCleanupBlocked records on the OperatorInstallation why its cleanup is blocked.
+func (i *Installer) setCleanupBlocked(ctx context.Context, cause error) {
+	live := &v1alpha1.OperatorInstallation{}
+	if err := i.K8sClient.Get(ctx, client.ObjectKey{Name: installationName}, live); err != nil {
+		return
+	}
+	meta.SetStatusCondition(&live.Status.Conditions, metav1.Condition{
+		Type:               v1alpha1.ConditionCleanupBlocked,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: live.Generation,
+		Reason:             "CleanupFailed",
+		Message:            cause.Error(),
+	})
+	if err := i.K8sClient.Status().Update(ctx, live); err != nil {
+		logger.Error(err, "Failed to update OperatorInstallation status", "Name", installationName, "Condition", v1alpha1.ConditionCleanupBlocked)
+	}
+}
+
+func ownedBy(refs []metav1.OwnerReference, installation *v1alpha1.OperatorInstallation) bool {
+	for _, ref := range refs {
+		if isInstallationRef(ref, installation) {
+			return true
+		}
+	}
+	return false
+}
+
+func isInstallationRef(ref metav1.OwnerReference, installation *v1alpha1.OperatorInstallation) bool {
+	gv, err := schema.ParseGroupVersion(ref.APIVersion)
+	return err == nil && gv.Group == v1alpha1.GroupVersion.Group &&
+		ref.Kind == "OperatorInstallation" && ref.Name == installation.Name
+}
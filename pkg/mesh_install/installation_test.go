@@ -0,0 +1,110 @@
+package mesh_install
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/greymatter-io/operator/pkg/wellknown"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// spireObjects returns the spire namespace and server-ca Secret, with the given owners.
+func spireObjects(owners ...metav1.OwnerReference) []client.Object {
+	return []client.Object{
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: spireNamespace, OwnerReferences: owners}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "server-ca", Namespace: spireNamespace, OwnerReferences: owners}},
+	}
+}
+
+func TestAdoptInstallationObjects(t *testing.T) {
+	crdRef := metav1.OwnerReference{APIVersion: "apiextensions.k8s.io/v1", Kind: "CustomResourceDefinition", Name: "meshes.greymatter.io"}
+	otherRef := metav1.OwnerReference{APIVersion: "v1", Kind: "ConfigMap", Name: "other"}
+	i, c := newTestInstaller(t, spireObjects(crdRef, otherRef)...)
+	i.Config.Spire = true
+	assert.NoError(t, i.ensureInstallation(context.TODO()))
+	assert.NoError(t, i.adoptInstallationObjects(context.TODO()))
+
+	for _, obj := range spireObjects() {
+		assert.NoError(t, c.Get(context.TODO(), client.ObjectKeyFromObject(obj), obj))
+		if assert.Len(t, obj.GetOwnerReferences(), 2, obj.GetName()) {
+			assert.Equal(t, otherRef, obj.GetOwnerReferences()[0])
+			assert.Equal(t, "OperatorInstallation", obj.GetOwnerReferences()[1].Kind)
+			assert.Equal(t, "gm-operator", obj.GetOwnerReferences()[1].Name)
+		}
+	}
+}
+
+func TestCleanUpInstallation(t *testing.T) {
+	installationRef := metav1.OwnerReference{APIVersion: v1alpha1.GroupVersion.String(), Kind: "OperatorInstallation", Name: "gm-operator"}
+
+	// Under the Delete policy, owned objects are deleted, and others left alone
+	i, c := newTestInstaller(t, spireObjects(installationRef)[1:]...)
+	i.Config.Spire = true
+	assert.NoError(t, c.Create(context.TODO(), spireObjects()[0]))
+	assert.NoError(t, i.ensureInstallation(context.TODO()))
+	assert.NoError(t, c.Delete(context.TODO(), i.owner))
+	assert.NoError(t, c.Get(context.TODO(), client.ObjectKey{Name: "gm-operator"}, i.owner))
+	assert.NoError(t, i.cleanUpInstallation(context.TODO(), i.owner))
+	assert.True(t, errors.IsNotFound(c.Get(context.TODO(), client.ObjectKey{Namespace: spireNamespace, Name: "server-ca"}, &corev1.Secret{})))
+	assert.NoError(t, c.Get(context.TODO(), client.ObjectKey{Name: spireNamespace}, &corev1.Namespace{}))
+	// With its finalizer removed, the installation is gone
+	assert.True(t, errors.IsNotFound(c.Get(context.TODO(), client.ObjectKey{Name: "gm-operator"}, &v1alpha1.OperatorInstallation{})))
+
+	// Under the Orphan policy, owned objects are kept, no longer owned
+	i, c = newTestInstaller(t, spireObjects(installationRef)...)
+	i.Config.Spire = true
+	assert.NoError(t, i.ensureInstallation(context.TODO()))
+	i.owner.Spec.CleanupPolicy = v1alpha1.CleanupOrphan
+	assert.NoError(t, c.Update(context.TODO(), i.owner))
+	assert.NoError(t, i.cleanUpInstallation(context.TODO(), i.owner))
+	for _, obj := range spireObjects() {
+		assert.NoError(t, c.Get(context.TODO(), client.ObjectKeyFromObject(obj), obj))
+		assert.Empty(t, obj.GetOwnerReferences(), obj.GetName())
+	}
+}
+
+func TestWatchInstallation(t *testing.T) {
+	recheck := readinessRecheckInterval
+	readinessRecheckInterval = 50 * time.Millisecond
+	defer func() { readinessRecheckInterval = recheck }()
+
+	installationRef := metav1.OwnerReference{APIVersion: v1alpha1.GroupVersion.String(), Kind: "OperatorInstallation", Name: "gm-operator"}
+	i, c := newTestInstaller(t, spireObjects(installationRef)...)
+	i.Config.Spire = true
+	assert.NoError(t, i.ensureInstallation(context.TODO()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	spireCtx, stopSpire := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		i.watchInstallation(ctx, stopSpire)
+		close(done)
+	}()
+
+	// Nothing is cleaned up until the installation is deleted
+	time.Sleep(100 * time.Millisecond)
+	assert.NoError(t, spireCtx.Err())
+	assert.NoError(t, c.Get(context.TODO(), client.ObjectKey{Name: spireNamespace}, &corev1.Namespace{}))
+
+	installation := &v1alpha1.OperatorInstallation{}
+	assert.NoError(t, c.Get(context.TODO(), client.ObjectKey{Name: "gm-operator"}, installation))
+	assert.Equal(t, []string{wellknown.FINALIZER_CLEANUP}, installation.Finalizers)
+	assert.NoError(t, c.Delete(context.TODO(), installation))
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("OperatorInstallation was not cleaned up")
+	}
+	assert.Error(t, spireCtx.Err())
+	for _, obj := range spireObjects() {
+		assert.True(t, errors.IsNotFound(c.Get(context.TODO(), client.ObjectKeyFromObject(obj), obj)), obj.GetName())
+	}
+	assert.True(t, errors.IsNotFound(c.Get(context.TODO(), client.ObjectKey{Name: "gm-operator"}, installation)))
+}
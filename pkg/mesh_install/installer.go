@@ -4,13 +4,10 @@ package mesh_install
 
 import (
 	"context"
-	"encoding/json"
+	"fmt"
 	"github.com/cloudflare/cfssl/csr"
-	"github.com/greymatter-io/operator/pkg/wellknown"
-	configv1 "github.com/openshift/api/config/v1"
-	"reflect"
-	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/greymatter-io/operator/api/v1alpha1"
@@ -19,11 +16,11 @@ import (
 	"github.com/greymatter-io/operator/pkg/gitops"
 	"github.com/greymatter-io/operator/pkg/gmapi"
 	"github.com/greymatter-io/operator/pkg/k8sapi"
+	"github.com/greymatter-io/operator/pkg/wellknown"
 
 	corev1 "k8s.io/api/core/v1"
-	networkingv1 "k8s.io/api/networking/v1"
-	extv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -35,119 +32,278 @@ var (
 // Installer stores a map of version.Version and a distinct version.Sidecar for each mesh.
 type Installer struct {
 	*gmapi.CLI // Grey Matter CLI
-	K8sClient  *client.Client
+	K8sClient  client.Client
 
 	cfssl *cfsslsrv.CFSSLServer
 
-	// The meshes.greymatter.io CRD, used as an owner when applying cluster-scoped resources.
-	// If the operator is uninstalled on a cluster, owned cluster-scoped resources will be cleaned up.
-	owner *extv1.CustomResourceDefinition
+	// Emits events on THE mesh for notable occurrences such as rejected GitOps commits.
+	recorder record.EventRecorder
+
+	// The OperatorInstallation, used as an owner when applying cluster-scoped resources.
+	// When it's deleted, its finalizer has the resources it owns cleaned up according to its cleanup policy.
+	owner *v1alpha1.OperatorInstallation
+	// The version of the operator, set as a label on the mesh's namespaces.
+	OperatorVersion string
+	// The operator's identity among those sharing the cluster (-operatorID), labeling the objects it applies.
+	OperatorID string
+	// The port of the admin API, which the operator's sidecar sends traffic from the edge to with
+	// config.self_registration; 0 if it isn't served.
+	AdminPort int
+	// Whether changes through the admin API must carry its token. Self-registration exposes the admin API to
+	// anyone who can reach the edge, and the requests the sidecar forwards can't present a client certificate,
+	// so the operator isn't registered without it.
+	AdminTokenRequired bool
 	// The Docker image pull secret to create in namespaces where core services are installed.
 	imagePullSecret *corev1.Secret
 
+	// Guards Mesh, OperatorCUE, CueRoot, Defaults and ctx. Once the installer has started, they're replaced rather than changed in place, so the
+	// snapshots returned by CurrentMesh, CurrentOperatorCUE and CurrentDefaults can be read without holding it. It's separate from the
+	// CLI's lock, which guards the mesh clients, and is never held while calling out.
+	state sync.RWMutex
+
 	// Container for THE mesh (on the way to an experimental 1:1 operator:mesh paradigm)
-	// Contains the default after load
+	// Contains the default after load. Read it with CurrentMesh and replace it with setMesh.
 	Mesh *v1alpha1.Mesh
 
 	// Container for all K8s and GM CUE cue.Values
+	// Read it with CurrentOperatorCUE and replace it with setOperatorCUE.
 	OperatorCUE *cuemodule.OperatorCUE
+	// Options the CUE is reloaded with, such as its environment and config overrides (see cuemodule.LoadAll)
+	CUEOptions []func(*cuemodule.OperatorCUE)
 
 	// Root on disk of the operator CUE. Used for reloading the default configs on teardown
+	// Read it with CurrentCueRoot and replace it with setCueRoot.
 	CueRoot string
 
 	// Operator config loadable from CUE
 	Config cuemodule.Config
 
-	// Select defaults that may be directly overridden from Go
+	// The context work that isn't given one of its own, such as a GitOps-triggered apply, runs with. Unlike the
+	// context the installer was started with, it outlives the signal to shut down, so applies in flight can finish,
+	// and it's cancelled by Shutdown. Read it with RunContext.
+	ctx     context.Context
+	stopRun context.CancelFunc
+	// Tracks the applies of the mesh in flight, for Shutdown to wait for
+	applies applyTracker
+	// Held for each apply and removal of the mesh, which are made one at a time since each unifies the mesh with the
+	// CUE and replaces OperatorCUE with the result
+	apply sync.Mutex
+	// The mesh's feature flags, as of the CUE last applied. Read them with FeatureEnabled.
+	features cuemodule.Features
+
+	// Select defaults that may be directly overridden from Go.
+	// Read them with CurrentDefaults and change them with updateDefaults.
 	Defaults cuemodule.Defaults
 
-	// Looked up on start
-	clusterIngressDomain string
+	// Selected or detected on start
+	ingress IngressProvider
+	// How scrape targets are generated; selected or detected on start
+	monitoring string
+	// Architectures of the cluster's Linux nodes, most preferred first; selected or detected on start
+	nodeArchitectures []string
 
 	// Sync configuration with access to a callback for updating on git repo changes
 	Sync *gitops.Sync
+	// The GitOps source from the startup flags, restored when a mesh stops setting its own
+	flagSource gitops.Source
+	// The GitOps source last set by the mesh, if any
+	gitOpsSource *v1alpha1.GitOpsSource
+	// Tracks the rollouts of the workloads applied in the latest sync cycle
+	rollouts rolloutTracker
+	// Tracks the certificates of the edge hosts
+	certificates certificateTracker
+	// Tracks the usage of sidecars, and the requests recommended for them
+	rightsizing rightsizingTracker
+	// Closed once the operator's admission webhooks are registered, so a Mesh can be admitted
+	webhooksRegistered chan struct{}
+	webhooksOnce       sync.Once
 }
 
 // New returns a new *Installer instance for installing Grey Matter components and dependencies.
-func New(c *client.Client, operatorCUE *cuemodule.OperatorCUE, initialMesh *v1alpha1.Mesh, cueRoot string, gmcli *gmapi.CLI, cfssl *cfsslsrv.CFSSLServer, sync *gitops.Sync) (*Installer, error) {
+func New(c client.Client, operatorCUE *cuemodule.OperatorCUE, initialMesh *v1alpha1.Mesh, cueRoot string, gmcli *gmapi.CLI, cfssl *cfsslsrv.CFSSLServer, sync *gitops.Sync, recorder record.EventRecorder) (*Installer, error) {
 	config, defaults := operatorCUE.ExtractConfig()
+	// Start from the last known view of the environment, so a restarted operator doesn't churn config that depends on it
+	if sync != nil && sync.SyncState != nil {
+		sync.SyncState.DerivedDefaults().ApplyTo(&defaults)
+	}
+	var flagSource gitops.Source
+	if sync != nil {
+		flagSource = sync.Source()
+	}
 	return &Installer{
 		CLI:         gmcli,
 		K8sClient:   c,
 		cfssl:       cfssl,
+		recorder:    recorder,
 		OperatorCUE: operatorCUE,
 		Mesh:        initialMesh,
 		CueRoot:     cueRoot,
 		Config:      config,
 		Defaults:    defaults,
 		Sync:        sync,
+		features:    config.Features,
+		flagSource:  flagSource,
+
+		webhooksRegistered: make(chan struct{}),
 	}, nil
 }
 
+// CurrentMesh returns THE mesh managed by the operator (or the CUE's default mesh if none has been applied).
+// It's a snapshot that must not be changed, since it's shared with other readers.
+func (i *Installer) CurrentMesh() *v1alpha1.Mesh {
+	i.state.RLock()
+	defer i.state.RUnlock()
+	return i.Mesh
+}
+
+// RunContext returns the context the installer runs work with once started, which is cancelled by Shutdown, or the
+// background context if it hasn't started.
+func (i *Installer) RunContext() context.Context {
+	i.state.RLock()
+	defer i.state.RUnlock()
+	if i.ctx == nil {
+		return context.Background()
+	}
+	return i.ctx
+}
+
+// setMesh replaces THE mesh managed by the operator.
+func (i *Installer) setMesh(mesh *v1alpha1.Mesh) {
+	i.state.Lock()
+	defer i.state.Unlock()
+	i.Mesh = mesh
+}
+
+// CurrentOperatorCUE returns the CUE last unified with THE mesh. It's replaced rather than changed in place.
+func (i *Installer) CurrentOperatorCUE() *cuemodule.OperatorCUE {
+	i.state.RLock()
+	defer i.state.RUnlock()
+	return i.OperatorCUE
+}
+
+// setOperatorCUE replaces the CUE unified with THE mesh.
+func (i *Installer) setOperatorCUE(operatorCUE *cuemodule.OperatorCUE) {
+	i.state.Lock()
+	defer i.state.Unlock()
+	i.OperatorCUE = operatorCUE
+}
+
+// CurrentCueRoot returns the root on disk of the operator CUE, which moves to the GitOps checkout once it's synced.
+func (i *Installer) CurrentCueRoot() string {
+	i.state.RLock()
+	defer i.state.RUnlock()
+	return i.CueRoot
+}
+
+// setCueRoot replaces the root on disk of the operator CUE.
+func (i *Installer) setCueRoot(cueRoot string) {
+	i.state.Lock()
+	defer i.state.Unlock()
+	i.CueRoot = cueRoot
+}
+
+// CurrentDefaults returns a copy of the defaults that may be directly overridden from Go.
+// Its slices are shared with other readers, so they must be replaced rather than changed in place.
+func (i *Installer) CurrentDefaults() cuemodule.Defaults {
+	i.state.RLock()
+	defer i.state.RUnlock()
+	return i.Defaults
+}
+
+// updateDefaults changes the defaults that may be directly overridden from Go with the given function, which must
+// replace any slice it changes rather than change it in place.
+func (i *Installer) updateDefaults(update func(defaults *cuemodule.Defaults)) {
+	i.state.Lock()
+	defer i.state.Unlock()
+	update(&i.Defaults)
+}
+
 // Start initializes resources and configurations after controller-manager has launched.
 // It implements the controller-runtime Runnable interface.
 func (i *Installer) Start(ctx context.Context) error {
+	i.state.Lock()
+	i.ctx, i.stopRun = context.WithCancel(context.Background())
+	i.state.Unlock()
 
 	// Retrieve the operator image secret from the apiserver (block until it's retrieved).
 	// This secret will be re-created in each install namespace and watch namespaces where core services are pulled.
-	i.imagePullSecret = getImagePullSecret(i.K8sClient)
+	i.imagePullSecret = getImagePullSecret(ctx, i.K8sClient)
+	if i.imagePullSecret == nil {
+		return ctx.Err()
+	}
 
-	// Get our Mesh CRD to set as an owner for cluster-scoped resources
-	i.owner = &extv1.CustomResourceDefinition{}
-	err := (*i.K8sClient).Get(ctx, client.ObjectKey{Name: "meshes.greymatter.io"}, i.owner)
-	if err != nil {
-		logger.Error(err, "Failed to get CustomResourceDefinition meshes.greymatter.io")
+	// Get or create the OperatorInstallation to set as an owner for cluster-scoped resources, taking them over from
+	// the Mesh CRD that used to own them
+	if err := i.ensureInstallation(ctx); err != nil {
+		logger.Error(err, "Failed to ensure OperatorInstallation")
 		return err
 	}
+	if err := i.adoptInstallationObjects(ctx); err != nil {
+		logger.Error(err, "Failed to hand cluster-scoped resources to OperatorInstallation")
+	}
+	spireCtx, stopSpire := context.WithCancel(ctx)
+	go i.watchInstallation(ctx, stopSpire)
 
-	if i.Config.Spire {
-		logger.Info("Attempting to apply spire server-ca secret")
-		spireSecret := &corev1.Secret{
-			TypeMeta: metav1.TypeMeta{
-				Kind:       "Secret",
-				APIVersion: "v1",
-			},
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      "server-ca",
-				Namespace: "spire",
-			},
-		}
-		spireSecret, err = injectGeneratedCertificates(spireSecret, i.cfssl)
-		if err != nil {
-			logger.Error(err, "Error while attempting to apply spire server-ca secret", "secret object", spireSecret)
+	// Install SPIRE, or just its intermediate CA if it's installed separately, unless the installation is being
+	// cleaned up
+	if i.Config.Spire && i.owner.DeletionTimestamp.IsZero() {
+		if err := i.installSpire(spireCtx); err != nil {
 			return err
 		}
-		k8sapi.Apply(i.K8sClient, spireSecret, i.owner, k8sapi.CreateOrUpdate)
 	}
 
-	// Try to get the OpenShift cluster ingress domain if it exists.
-	clusterIngressDomain, ok := getOpenshiftClusterIngressDomain(i.K8sClient, i.Config.ClusterIngressName)
-	if ok {
-		// TODO: When not in OpenShift, check for other supported ingress class types such as Nginx or Voyager.
-		// If no supported ingress types are found, just assume the user will configure ingress on their own.
-		logger.Info("Identified OpenShift cluster domain name", "Domain", clusterIngressDomain)
-		i.clusterIngressDomain = clusterIngressDomain
+	// Select how core services are exposed, detecting the cluster's ingress controller unless configured
+	i.ingress = detectIngressProvider(ctx, i.K8sClient, i.Config)
+	logger.Info("Using ingress provider", "Provider", i.ingress.Name())
+	i.apply.Lock()
+	operatorCUE := *i.CurrentOperatorCUE()
+	operatorCUE.SetClusterDomain(i.ingress.Domain())
+	i.setOperatorCUE(&operatorCUE)
+	i.apply.Unlock()
+
+	// Select how Prometheus is told to scrape sidecars and core components, detecting the Prometheus Operator
+	// unless configured
+	i.monitoring = detectMonitoring(ctx, i.K8sClient, i.Config)
+	logger.Info("Using monitoring mode", "Mode", i.monitoring)
+
+	// Select the builds of single-architecture images for the cluster's nodes, detecting their architectures unless
+	// configured
+	i.nodeArchitectures = detectNodeArchitectures(ctx, i.K8sClient, i.Config)
+	logger.Info("Using node architectures", "Architectures", i.nodeArchitectures)
+
+	// Connect to Catalog over TLS and authenticate to it, if configured
+	if err := i.configureCatalogAuth(ctx); err != nil {
+		return err
+	}
+	// Log in to Control and Catalog for session tokens, if configured
+	if err := i.configureAPIAuth(ctx); err != nil {
+		return err
 	}
 
 	// If this operator's Mesh CR already exists in the environment, load it
 	meshAlreadyDeployed := false
+	var meshes []v1alpha1.Mesh
 	meshList := &v1alpha1.MeshList{}
-	if err := (*i.K8sClient).List(context.TODO(), meshList); err != nil {
+	if err := k8sapi.ListPages(ctx, i.K8sClient, meshList, i.Config.Reconcile.PageSize, func() error {
+		meshes = append(meshes, meshList.Items...)
+		return nil
+	}); err != nil {
 		logger.Error(err, "failed to list all meshes for state restoration - check operator permissions")
 	}
-	for _, mesh := range meshList.Items {
-		if mesh.Name == i.Mesh.Name {
+	initialMesh := i.CurrentMesh()
+	for idx := range meshes {
+		mesh := &meshes[idx]
+		if mesh.Name == initialMesh.Name {
 			logger.Info("Mesh already deployed. Reloading values.", "Name", mesh.Name)
-			i.Mesh = &mesh // load the live version of the mesh
+			mesh = i.withSelectedNamespaces(ctx, mesh)
+			i.setMesh(mesh) // load the live version of the mesh
 			// immediately update OperatorCUE and the SidecarList
-			err := i.OperatorCUE.UnifyWithMesh(i.Mesh)
+			operatorCUE, err := i.restoreOperatorCUE(ctx, mesh)
 			if err != nil {
-				logger.Error(err,
-					"error while attempting to unify existing deployed Mesh with Grey Matter mesh configs CUE",
-					"Mesh", mesh)
 				return err
 			}
-			i.ConfigureMeshClient(i.Mesh, i.Sync)
+			i.ConfigureMeshClient(operatorCUE, mesh, i.Sync)
+			i.configureGitOps(ctx, mesh)
 			meshAlreadyDeployed = true
 			break
 		}
@@ -156,34 +312,66 @@ func (i *Installer) Start(ctx context.Context) error {
 	// called on completion of a gitops sync cycle if there are new commits
 	i.Sync.OnSyncCompleted = func() error {
 		logger.Info("GitOps repo updated and synchronized. Reapplying configuration...")
-		// reload CUE here
-		_, freshLoadMesh, err := cuemodule.LoadAll(i.CueRoot)
+		// reload CUE here, from the checkout (which may only now exist, if a mesh set the GitOps source)
+		cueRoot := i.Sync.GitDir
+		i.setCueRoot(cueRoot)
+		_, freshLoadMesh, err := cuemodule.LoadAll(cueRoot, i.CUEOptions...)
 		if err != nil {
 			return err
 		}
-		// copy in old mesh dynamic values
-		freshLoadMesh.TypeMeta = i.Mesh.TypeMeta
-		i.Mesh.ObjectMeta.DeepCopyInto(&freshLoadMesh.ObjectMeta)
-
-		i.ApplyMesh(i.Mesh, freshLoadMesh)
+		current := i.CurrentMesh()
+		withLiveMeshValues(current, freshLoadMesh)
+		if err := i.ApplyMesh(i.RunContext(), current, freshLoadMesh); err != nil {
+			return err
+		}
+		i.setMeshCondition(v1alpha1.ConditionGitOpsVerified, metav1.ConditionTrue, "CommitApplied", "Latest GitOps commit was applied")
 
 		return nil
 	}
 
+	// called when a fetched gitops commit fails signature verification and is not applied
+	i.Sync.OnSyncRejected = func(err error) {
+		logger.Error(err, "Refusing to apply unverified GitOps commit")
+		i.setMeshCondition(v1alpha1.ConditionGitOpsVerified, metav1.ConditionFalse, "UnverifiedCommit", err.Error())
+	}
+
+	// Report whether the operator's state is backed up to Redis, or only kept in memory until Redis is available
+	if i.Sync.SyncState != nil {
+		i.Sync.SyncState.OnAvailabilityChange(func(err error) {
+			if err != nil {
+				i.setMeshCondition(v1alpha1.ConditionStateBackupAvailable, metav1.ConditionFalse, "RedisUnavailable", err.Error())
+			} else {
+				i.setMeshCondition(v1alpha1.ConditionStateBackupAvailable, metav1.ConditionTrue, "RedisAvailable", "Operator state is backed up to Redis")
+			}
+		})
+		// And whether it's had changes not persisted to Redis for too long, so a restart would lose them
+		i.Sync.SyncState.OnDivergenceChange(func(since time.Time) {
+			if !since.IsZero() {
+				i.setMeshCondition(v1alpha1.ConditionStatePersisted, metav1.ConditionFalse, "StateDiverged",
+					fmt.Sprintf("Operator state has had changes not persisted to Redis since %s", since.UTC().Format(time.RFC3339)))
+			} else {
+				i.setMeshCondition(v1alpha1.ConditionStatePersisted, metav1.ConditionTrue, "StatePersisted", "Operator state is persisted to Redis")
+			}
+		})
+		// And whether deletions are held back, e.g. because a commit would delete most of the mesh
+		i.Sync.SyncState.OnDeletionsHeld(func(held gitops.HeldDeletions) {
+			if held.Len() > 0 {
+				i.setMeshCondition(v1alpha1.ConditionDeletionsReleased, metav1.ConditionFalse, "DeletionsHeld",
+					fmt.Sprintf("Deletion of %d K8s objects and %d Grey Matter config objects is held until released through the admin API", len(held.K8s), len(held.GM)))
+			} else {
+				i.setMeshCondition(v1alpha1.ConditionDeletionsReleased, metav1.ConditionTrue, "NoDeletionsHeld", "No deletions are held")
+			}
+		})
+	}
+
 	// Immediately apply the default mesh from the CUE if the flag is set and we don't already have a mesh
 	// Then re-apply the mesh whenever the repository is updated (checked by polling)
 	go func() {
-		// initial mesh application
+		// initial mesh application, as soon as the Mesh can be admitted
 		if i.Config.AutoApplyMesh && !meshAlreadyDeployed {
-			logger.Info("Waiting 30 seconds to apply loaded default Mesh resource to cluster.")
-			time.Sleep(30 * time.Second) // Sleep for an arbitrary initial duration
-			for {
-				err := k8sapi.Apply(i.K8sClient, i.Mesh, nil, k8sapi.GetOrCreate)
-				if err == nil {
-					break
-				}
-				logger.Info("Temporary failure to apply Mesh resource. Will retry in 10 seconds.")
-				time.Sleep(10 * time.Second)
+			if err := i.applyInitialMesh(ctx); err != nil {
+				logger.Error(err, "Stopped applying loaded default Mesh resource to cluster")
+				return
 			}
 		}
 
@@ -191,23 +379,122 @@ func (i *Installer) Start(ctx context.Context) error {
 		i.Sync.Watch() // Executes its callback (defined above) whenever there are new commits
 	}()
 
+	// Publish the outcome of each sync cycle for config authors
+	if i.Config.Reconcile.Enabled(reconcilerSyncReport) {
+		go i.publishSyncReports(ctx)
+	}
+
+	// Report on the edge hosts' certificates, restarting the edge on renewal if configured
+	if i.Config.Ingress.Certificates.Issuer != "" && i.Config.Reconcile.Enabled(reconcilerCertificates) {
+		go i.checkCertificates(ctx)
+	}
+
+	// If sidecar identities come from ServiceAccounts, renew their certificates before they expire
+	if i.ServiceAccountIdentity() {
+		if i.Config.Spire {
+			logger.Info("ServiceAccount identities are mounted in sidecars alongside SPIRE's since the spire flag is also set; the sidecar CUE determines which is used")
+		}
+		if i.Config.Reconcile.Enabled(reconcilerIdentity) {
+			go i.renewWorkloadIdentities(ctx)
+		}
+	}
+
+	// Keep the standard labels on the mesh's namespaces
+	if i.Config.Reconcile.Enabled(reconcilerNamespaceLabels) {
+		go i.reconcileNamespaceLabels(ctx)
+	}
+
+	// Follow the namespaces matching the mesh's watch_namespace_selector, and keep the resources it needs in its
+	// watched namespaces
+	if i.Config.Reconcile.Enabled(reconcilerWatchedNamespaces) {
+		go i.reconcileWatchedNamespaces(ctx)
+	}
+
+	// Delete the Catalog services of workloads deleted while the operator wasn't watching
+	if i.Config.Reconcile.Enabled(reconcilerCatalogPrune) {
+		go i.pruneCatalogWorkloads(ctx)
+	}
+
+	// Remove the sidecars of workloads that no longer ask for one, if the workload webhook didn't see them change
+	if i.Config.Reconcile.Enabled(reconcilerSidecarRemoval) {
+		go i.reconcileSidecarRemoval(ctx)
+	}
+
 	// If Spire, set up to periodically reconcile the extant sidecars with the Redis listener's allowable subjects
-	if i.Config.Spire {
-		go i.reconcileSidecarListForRedisIngress(i.Mesh)
+	if i.Config.Spire && i.Config.Reconcile.Enabled(reconcilerSidecarList) {
+		go i.reconcileSidecarListForRedisIngress(ctx)
+	}
+
+	// Sample sidecars' usage, to recommend the resources they request
+	if i.Config.Rightsizing.Enabled && i.Config.Reconcile.Enabled(reconcilerRightsizing) {
+		go i.sampleSidecarUsage(ctx)
+	}
+
+	// Reapply the mesh while the drift_enforcement feature is on, restoring objects changed out of band
+	if i.Config.Reconcile.Enabled(reconcilerDrift) {
+		go i.enforceDrift(ctx)
 	}
 
 	return nil
 }
 
+// restoreOperatorCUE unifies the already deployed mesh with the CUE on start, along with its settings that change
+// what's extracted from it, as ApplyMesh would, and returns the result, which replaces OperatorCUE.
+func (i *Installer) restoreOperatorCUE(ctx context.Context, mesh *v1alpha1.Mesh) (*cuemodule.OperatorCUE, error) {
+	i.apply.Lock()
+	defer i.apply.Unlock()
+
+	operatorCUE := *i.CurrentOperatorCUE()
+	if err := operatorCUE.UnifyWithMesh(mesh); err != nil {
+		logger.Error(err,
+			"error while attempting to unify existing deployed Mesh with Grey Matter mesh configs CUE",
+			"Mesh", mesh)
+		return nil, err
+	}
+	if err := i.configureEdgeAuth(ctx, &operatorCUE, mesh); err != nil {
+		logger.Error(err, "failed to configure edge auth of existing deployed Mesh", "Mesh", mesh.Name)
+		return nil, err
+	}
+	operatorCUE.SetGMConfigVersion(mesh.Spec.ConfigVersion)
+	operatorCUE.SetDisabledComponents(mesh.Spec.DisabledComponents)
+	i.setOperatorCUE(&operatorCUE)
+	return &operatorCUE, nil
+}
+
+// withLiveMeshValues copies the values of the applied mesh that don't come from the config repo into the mesh loaded
+// from a commit of it.
+func withLiveMeshValues(current, fresh *v1alpha1.Mesh) {
+	fresh.TypeMeta = current.TypeMeta
+	current.ObjectMeta.DeepCopyInto(&fresh.ObjectMeta)
+	// The fresh mesh's namespaces are selected afresh when it's applied
+	delete(fresh.Annotations, annotationSelectedNamespaces)
+	// The GitOps source is normally set on the Mesh resource rather than in the repo it points to
+	if fresh.Spec.GitOps == nil {
+		fresh.Spec.GitOps = current.Spec.GitOps.DeepCopy()
+	}
+	// As is its edge auth, which refers to a Secret in the cluster
+	if fresh.Spec.EdgeAuth == nil {
+		fresh.Spec.EdgeAuth = current.Spec.EdgeAuth.DeepCopy()
+	}
+}
+
 // Retrieves the image pull secret in the gm-operator namespace.
-// This retries indefinitely at 30s intervals and will block by design.
-func getImagePullSecret(c *client.Client) *corev1.Secret {
+// This retries indefinitely at 30s intervals and will block by design, until the context is cancelled, when it
+// returns nil.
+func getImagePullSecret(ctx context.Context, c client.Client) *corev1.Secret {
 	key := client.ObjectKey{Name: "gm-docker-secret", Namespace: "gm-operator"}
 	operatorSecret := &corev1.Secret{}
 	for operatorSecret.CreationTimestamp.IsZero() {
-		if err := (*c).Get(context.TODO(), key, operatorSecret); err != nil {
+		getCtx, cancel := k8sapi.WithTimeout(ctx)
+		err := c.Get(getCtx, key, operatorSecret)
+		cancel()
+		if err != nil {
 			logger.Error(err, "No 'gm-docker-secret' image pull secret found in gm-operator namespace. Will retry in 30s.")
-			time.Sleep(time.Second * 30)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(time.Second * 30):
+			}
 		}
 	}
 
@@ -219,35 +506,31 @@ func getImagePullSecret(c *client.Client) *corev1.Secret {
 	}
 }
 
-func getOpenshiftClusterIngressDomain(c *client.Client, ingressName string) (string, bool) {
-	clusterIngressList := &configv1.IngressList{}
-	if err := (*c).List(context.TODO(), clusterIngressList); err != nil {
-		return "", false
-	} else {
-		for _, i := range clusterIngressList.Items {
-			if i.Name == ingressName {
-				return i.Spec.Domain, true
-			}
-		}
+// SetImagePullSecret replaces the image pull secret copied into mesh namespaces, e.g. after it was rotated
+// in an external store, and updates the existing copies if config.auto_copy_image_pull_secret is set.
+func (i *Installer) SetImagePullSecret(operatorSecret *corev1.Secret) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "gm-docker-secret"},
+		Type:       operatorSecret.Type,
+		Data:       operatorSecret.Data,
 	}
-	return "", false
-}
 
-// Check that a suported ingress controller class exists in a kubernetes cluster.
-// This will be expanded later on as we support additional ingress implementations.
-//lint:ignore U1000 save for reference
-func isSupportedKubernetesIngressClassPresent(c client.Client) bool {
-	ingressClassList := &networkingv1.IngressClassList{}
-	if err := c.List(context.TODO(), ingressClassList); err != nil {
-		return false
-	}
-	for _, i := range ingressClassList.Items {
-		switch i.Spec.Controller {
-		case "nginx", "voyager":
-			return true
+	i.Lock()
+	i.imagePullSecret = secret
+	i.Unlock()
+	mesh := i.CurrentMesh()
+
+	if !i.Config.AutoCopyImagePullSecret || mesh == nil {
+		return
+	}
+	for _, ns := range append([]string{mesh.Spec.InstallNamespace}, mesh.Spec.WatchNamespaces...) {
+		copied := secret.DeepCopy()
+		copied.Namespace = ns
+		if ns != mesh.Spec.InstallNamespace {
+			copied.Labels = map[string]string{wellknown.LABEL_MESH: mesh.Name}
 		}
+		k8sapi.Apply(i.RunContext(), i.K8sClient, copied, mesh, k8sapi.CreateOrUpdate)
 	}
-	return false
 }
 
 func injectGeneratedCertificates(secret *corev1.Secret, cs *cfsslsrv.CFSSLServer) (*corev1.Secret, error) {
@@ -271,84 +554,3 @@ func injectGeneratedCertificates(secret *corev1.Secret, cs *cfsslsrv.CFSSLServer
 
 	return secret, nil
 }
-func (i *Installer) reconcileSidecarListForRedisIngress(mesh *v1alpha1.Mesh) {
-	var redisListener json.RawMessage
-	var tempOperatorCUE cuemodule.OperatorCUE
-	var err error
-ReconciliationLoop:
-	for {
-		time.Sleep(30 * time.Second)
-		sidecarSet := make(map[string]struct{})
-		// TODO it may be better to do Deployments and StatefulSets (but as a first pass, Pods are far simpler)
-		i.RLock()
-		// List all pods anywhere
-		pods := &corev1.PodList{}
-		(*i.K8sClient).List(context.TODO(), pods)
-		for _, pod := range pods.Items {
-			// Filter to only the relevant namespaces for this mesh
-			watched := false
-			for _, ns := range mesh.Spec.WatchNamespaces {
-				if pod.Namespace == ns {
-					watched = true
-					break
-				}
-			}
-			if watched || pod.Namespace == mesh.Spec.InstallNamespace {
-				// Further filter to only the pods with a sidecar (assumed to have a container with a "proxy" port)
-				for _, container := range pod.Spec.Containers {
-					for _, p := range container.Ports {
-						// TODO don't hard-code the port name, pull it from the CUE
-						// TODO also, seriously? There's got to be a better way to identify sidecars than this
-						if p.Name == "proxy" {
-							if pod.Labels == nil {
-								pod.Labels = make(map[string]string)
-							}
-							if clusterName, ok := pod.Labels[wellknown.LABEL_CLUSTER]; ok {
-								sidecarSet[clusterName] = struct{}{}
-							}
-						}
-					}
-				}
-			}
-		}
-		var sidecarList []string
-		for name := range sidecarSet {
-			sidecarList = append(sidecarList, name)
-		}
-		sort.Strings(sidecarList)
-		sort.Strings(i.Defaults.SidecarList)
-		if len(sidecarList) == 0 || reflect.DeepEqual(sidecarList, i.Defaults.SidecarList) {
-			goto LoopEnd
-		}
-		logger.Info("The list of sidecars in the environment has changed. Updating Redis ingress for health checks.", "Updated List", sidecarList)
-		i.Defaults.SidecarList = sidecarList
-		tempOperatorCUE, err = i.OperatorCUE.TempGMValueUnifiedWithDefaults(i.Defaults)
-		if err != nil {
-			logger.Error(err,
-				"error attempting to unify mesh after sidecarList update - this should never happen - check Mesh integrity",
-				"Mesh", i.Mesh)
-			goto LoopEnd
-		}
-		redisListener, err = tempOperatorCUE.ExtractRedisListener()
-		if err != nil {
-			logger.Error(err,
-				"error extracting redis_listener from CUE - ignoring",
-				"Mesh", i.Mesh)
-			goto LoopEnd
-		}
-		if i.Client != nil {
-			i.Client.ControlCmds <- gmapi.MkApply("listener", redisListener)
-		}
-
-	LoopEnd:
-		if i.Client != nil {
-			select {
-			case <-i.Client.Ctx.Done():
-				logger.Info("greymatter client context cancelled - stopping reconciliation loop")
-				break ReconciliationLoop
-			default:
-			}
-		}
-		i.RUnlock()
-	}
-}
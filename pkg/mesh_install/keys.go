@@ -0,0 +1,93 @@
+package mesh_install
+
+import (
+	"context"
+	"crypto/rsa"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	"github.com/greymatter-io/operator/pkg/k8sapi"
+	"github.com/greymatter-io/operator/pkg/sealedsecrets"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Keys of SPIRE's intermediate CA in the server-ca Secret, and properties of the remote key it's pulled from
+// with external-secrets.
+var spireCAKeys = []string{"root.crt", "intermediate.crt", "intermediate.key"}
+
+var sealingCertClient = &http.Client{Timeout: 30 * time.Second}
+
+// applyKeySecret writes a Secret holding private keys generated by the operator, sealing it first
+// if config.key_delivery.mode is sealed_secret.
+func (i *Installer) applyKeySecret(ctx context.Context, secret *corev1.Secret, owner client.Object) error {
+	if i.Config.KeyDelivery.Mode != cuemodule.KeyDeliverySealedSecret {
+		return k8sapi.Apply(ctx, i.K8sClient, secret, owner, k8sapi.CreateOrUpdate)
+	}
+
+	pubKey, err := i.sealingKey(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get sealed-secrets key to seal %s/%s: %w", secret.Namespace, secret.Name, err)
+	}
+	sealed, err := sealedsecrets.Seal(pubKey, secret)
+	if err != nil {
+		return fmt.Errorf("failed to seal %s/%s: %w", secret.Namespace, secret.Name, err)
+	}
+	return k8sapi.Apply(ctx, i.K8sClient, sealed, owner, k8sapi.CreateOrUpdate)
+}
+
+// sealingKey returns the public key of the sealed-secrets controller's active key pair, from the configured
+// certificate or else the one its Service serves. Its private key is never read.
+func (i *Installer) sealingKey(ctx context.Context) (*rsa.PublicKey, error) {
+	config := i.Config.KeyDelivery
+	if config.SealingCert != "" {
+		return sealedsecrets.ParsePublicKey([]byte(config.SealingCert))
+	}
+	namespace, service := config.SealingControllerNamespace, config.SealingControllerService
+	if namespace == "" {
+		namespace = sealedsecrets.DefaultControllerNamespace
+	}
+	if service == "" {
+		service = sealedsecrets.DefaultControllerService
+	}
+	ctx, cancel := k8sapi.WithTimeout(ctx)
+	defer cancel()
+	return sealedsecrets.FetchPublicKey(ctx, sealingCertClient, sealedsecrets.CertURL(namespace, service))
+}
+
+// mkSpireCAExternalSecret returns an ExternalSecret that has the external-secrets operator create the server-ca
+// Secret from the configured store, instead of the operator generating SPIRE's intermediate CA.
+func mkSpireCAExternalSecret(config cuemodule.KeyDeliveryConfig) (*unstructured.Unstructured, error) {
+	if config.SecretStore == "" || config.RemoteKey == "" {
+		return nil, fmt.Errorf("config.key_delivery.secret_store and config.key_delivery.remote_key are required for %s delivery", cuemodule.KeyDeliveryExternalSecret)
+	}
+	storeKind := config.SecretStoreKind
+	if storeKind == "" {
+		storeKind = "SecretStore"
+	}
+
+	data := make([]interface{}, len(spireCAKeys))
+	for idx, key := range spireCAKeys {
+		data[idx] = map[string]interface{}{
+			"secretKey": key,
+			"remoteRef": map[string]interface{}{"key": config.RemoteKey, "property": key},
+		}
+	}
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "external-secrets.io/v1beta1",
+		"kind":       "ExternalSecret",
+		"metadata": map[string]interface{}{
+			"name":      "server-ca",
+			"namespace": spireNamespace,
+		},
+		"spec": map[string]interface{}{
+			"refreshInterval": "1h",
+			"secretStoreRef":  map[string]interface{}{"name": config.SecretStore, "kind": storeKind},
+			"target":          map[string]interface{}{"name": "server-ca", "creationPolicy": "Owner"},
+			"data":            data,
+		},
+	}}, nil
+}
@@ -0,0 +1,40 @@
+package mesh_install
+
+import (
+	"testing"
+
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestMkSpireCAExternalSecret(t *testing.T) {
+	_, err := mkSpireCAExternalSecret(cuemodule.KeyDeliveryConfig{Mode: cuemodule.KeyDeliveryExternalSecret, SecretStore: "vault"})
+	assert.Error(t, err, "remote key is required")
+
+	externalSecret, err := mkSpireCAExternalSecret(cuemodule.KeyDeliveryConfig{
+		Mode:        cuemodule.KeyDeliveryExternalSecret,
+		SecretStore: "vault",
+		RemoteKey:   "greymatter/spire-ca",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "ExternalSecret", externalSecret.GetKind())
+	assert.Equal(t, spireNamespace, externalSecret.GetNamespace())
+
+	storeRef, _, _ := unstructured.NestedStringMap(externalSecret.Object, "spec", "secretStoreRef")
+	assert.Equal(t, map[string]string{"name": "vault", "kind": "SecretStore"}, storeRef)
+	target, _, _ := unstructured.NestedString(externalSecret.Object, "spec", "target", "name")
+	assert.Equal(t, "server-ca", target)
+
+	data, _, _ := unstructured.NestedSlice(externalSecret.Object, "spec", "data")
+	var keys []string
+	for _, d := range data {
+		entry := d.(map[string]interface{})
+		keys = append(keys, entry["secretKey"].(string))
+		property, _, _ := unstructured.NestedString(entry, "remoteRef", "property")
+		assert.Equal(t, entry["secretKey"], property)
+	}
+	assert.Equal(t, []string{"root.crt", "intermediate.crt", "intermediate.key"}, keys)
+}
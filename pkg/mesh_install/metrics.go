@@ -0,0 +1,49 @@
+package mesh_install
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	rolloutReplicas = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gm_operator_rollout_replicas",
+		Help: "Replicas of each Deployment and StatefulSet applied in the latest sync cycle, by state (desired, updated, or available).",
+	}, []string{"kind", "namespace", "name", "state"})
+
+	rolloutStatus = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gm_operator_rollout_status",
+		Help: "1 for the rollout status (progressing, complete, or failed) of each Deployment and StatefulSet applied in the latest sync cycle, otherwise 0.",
+	}, []string{"kind", "namespace", "name", "status"})
+
+	edgeCertificateReady = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gm_operator_edge_certificate_ready",
+		Help: "1 if each certificate requested for the edge hosts is issued and unexpired, otherwise 0.",
+	}, []string{"namespace", "name"})
+
+	edgeCertificateExpiry = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gm_operator_edge_certificate_expiry_timestamp_seconds",
+		Help: "When each certificate issued for the edge hosts expires, in Unix seconds.",
+	}, []string{"namespace", "name"})
+
+	initialApplyWaiting = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gm_operator_initial_apply_waiting",
+		Help: "1 while the initial Mesh apply waits for each condition (crd or webhooks) to be ready, otherwise 0.",
+	}, []string{"condition"})
+
+	initialApplyAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gm_operator_initial_apply_attempts_total",
+		Help: "Attempts to apply the initial Mesh, by result (applied or failed).",
+	}, []string{"result"})
+
+	initialApplyRetryDelay = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "gm_operator_initial_apply_retry_delay_seconds",
+		Help: "Backoff before the next attempt to apply the initial Mesh after a failure, or 0 once it's applied.",
+	})
+)
+
+func init() {
+	// Served by the manager's metrics endpoint
+	metrics.Registry.MustRegister(rolloutReplicas, rolloutStatus, edgeCertificateReady, edgeCertificateExpiry,
+		initialApplyWaiting, initialApplyAttempts, initialApplyRetryDelay)
+}
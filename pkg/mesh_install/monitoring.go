@@ -0,0 +1,163 @@
+package mesh_install
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	"github.com/greymatter-io/operator/pkg/k8sapi"
+	"github.com/greymatter-io/operator/pkg/wellknown"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Kind of the Prometheus Operator PodMonitors generated for sidecars and core components.
+var podMonitorGVK = schema.GroupVersionKind{Group: "monitoring.coreos.com", Version: "v1", Kind: "PodMonitor"}
+
+// Name of the PodMonitor of the injected sidecars in each watched namespace.
+const sidecarPodMonitorName = "greymatter-sidecars"
+
+// Pod annotations understood by the Prometheus community Helm chart's kubernetes-pods scrape job.
+const (
+	annotationPrometheusScrape = "prometheus.io/scrape"
+	annotationPrometheusPort   = "prometheus.io/port"
+	annotationPrometheusPath   = "prometheus.io/path"
+)
+
+// detectMonitoring returns how scrape targets are generated: config.monitoring.mode, or if unset, PodMonitors if
+// the Prometheus Operator's CRDs are installed and otherwise pod annotations. Returns MonitoringNone unless
+// config.monitoring.enabled is set.
+func detectMonitoring(ctx context.Context, c client.Reader, config cuemodule.Config) string {
+	if !config.Monitoring.Enabled {
+		return cuemodule.MonitoringNone
+	}
+	if config.Monitoring.Mode != "" {
+		return config.Monitoring.Mode
+	}
+	ctx, cancel := k8sapi.WithTimeout(ctx)
+	defer cancel()
+	podMonitors := &unstructured.UnstructuredList{}
+	podMonitors.SetGroupVersionKind(podMonitorGVK.GroupVersion().WithKind(podMonitorGVK.Kind + "List"))
+	if err := c.List(ctx, podMonitors, client.Limit(1)); err != nil {
+		return cuemodule.MonitoringAnnotations
+	}
+	return cuemodule.MonitoringPrometheusOperator
+}
+
+// mkMonitoringObjects returns a PodMonitor for each Deployment and StatefulSet among the manifests with a metrics
+// port, and one for the injected sidecars in each of the mesh's watched namespaces. With MonitoringAnnotations, it
+// instead annotates the pod templates of those manifests in place, leaving sidecars to be annotated on injection.
+func mkMonitoringObjects(config cuemodule.MonitoringConfig, mode string, mesh *v1alpha1.Mesh, manifestObjects []client.Object) []client.Object {
+	if mode != cuemodule.MonitoringPrometheusOperator && mode != cuemodule.MonitoringAnnotations {
+		return nil
+	}
+
+	var objects []client.Object
+	for _, obj := range manifestObjects {
+		var template *corev1.PodTemplateSpec
+		switch workload := obj.(type) {
+		case *appsv1.Deployment:
+			template = &workload.Spec.Template
+		case *appsv1.StatefulSet:
+			template = &workload.Spec.Template
+		default:
+			continue
+		}
+		annotations := scrapeAnnotations(config, template.Spec.Containers)
+		if annotations == nil {
+			continue
+		}
+		if mode == cuemodule.MonitoringAnnotations {
+			if template.Annotations == nil {
+				template.Annotations = make(map[string]string)
+			}
+			for k, v := range annotations {
+				template.Annotations[k] = v
+			}
+			continue
+		}
+		selector := make(map[string]interface{})
+		for k, v := range template.Labels {
+			selector[k] = v
+		}
+		objects = append(objects, mkPodMonitor(config, obj.GetName(), obj.GetNamespace(), map[string]interface{}{"matchLabels": selector}))
+	}
+
+	if mode == cuemodule.MonitoringPrometheusOperator {
+		// Injected sidecars are labeled with their cluster, and only scraped if they have the metrics port
+		for _, ns := range mesh.Spec.WatchNamespaces {
+			objects = append(objects, mkPodMonitor(config, sidecarPodMonitorName, ns, map[string]interface{}{
+				"matchExpressions": []interface{}{map[string]interface{}{"key": wellknown.LABEL_CLUSTER, "operator": "Exists"}},
+			}))
+		}
+	}
+	return objects
+}
+
+// mkPodMonitor returns a PodMonitor scraping the metrics port of the selected pods.
+func mkPodMonitor(config cuemodule.MonitoringConfig, name, namespace string, selector map[string]interface{}) client.Object {
+	endpoint := map[string]interface{}{
+		"port": metricsPort(config),
+		"path": metricsPath(config),
+	}
+	if config.IntervalSeconds > 0 {
+		endpoint["interval"] = fmt.Sprintf("%ds", config.IntervalSeconds)
+	}
+	podMonitor := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": name, "namespace": namespace},
+		"spec": map[string]interface{}{
+			"selector":            selector,
+			"podMetricsEndpoints": []interface{}{endpoint},
+		},
+	}}
+	podMonitor.SetGroupVersionKind(podMonitorGVK)
+	if len(config.Labels) > 0 {
+		podMonitor.SetLabels(config.Labels)
+	}
+	return podMonitor
+}
+
+// scrapeAnnotations returns the annotations telling Prometheus to scrape the metrics port of the given containers,
+// or nil if none has it.
+func scrapeAnnotations(config cuemodule.MonitoringConfig, containers []corev1.Container) map[string]string {
+	for _, container := range containers {
+		for _, port := range container.Ports {
+			if port.Name == metricsPort(config) {
+				return map[string]string{
+					annotationPrometheusScrape: "true",
+					annotationPrometheusPort:   strconv.Itoa(int(port.ContainerPort)),
+					annotationPrometheusPath:   metricsPath(config),
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// ScrapeAnnotations returns the annotations telling Prometheus to scrape an injected sidecar, or nil unless scrape
+// targets are pod annotations.
+func (i *Installer) ScrapeAnnotations(sidecar corev1.Container) map[string]string {
+	if i.monitoring != cuemodule.MonitoringAnnotations {
+		return nil
+	}
+	return scrapeAnnotations(i.Config.Monitoring, []corev1.Container{sidecar})
+}
+
+func metricsPort(config cuemodule.MonitoringConfig) string {
+	if config.Port != "" {
+		return config.Port
+	}
+	return "metrics"
+}
+
+func metricsPath(config cuemodule.MonitoringConfig) string {
+	if config.Path != "" {
+		return config.Path
+	}
+	return "/prometheus"
+}
@@ -0,0 +1,112 @@
+package mesh_install
+
+import (
+	"context"
+	"testing"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestDetectMonitoring(t *testing.T) {
+	withPrometheusOperator := fake.NewClientBuilder().WithScheme(testScheme(t)).Build()
+	without := noPodMonitors{withPrometheusOperator}
+
+	enabled := cuemodule.Config{Monitoring: cuemodule.MonitoringConfig{Enabled: true}}
+	assert.Equal(t, cuemodule.MonitoringNone, detectMonitoring(context.TODO(), withPrometheusOperator, cuemodule.Config{}))
+	assert.Equal(t, cuemodule.MonitoringPrometheusOperator, detectMonitoring(context.TODO(), withPrometheusOperator, enabled))
+	assert.Equal(t, cuemodule.MonitoringAnnotations, detectMonitoring(context.TODO(), without, enabled))
+
+	enabled.Monitoring.Mode = cuemodule.MonitoringAnnotations
+	assert.Equal(t, cuemodule.MonitoringAnnotations, detectMonitoring(context.TODO(), withPrometheusOperator, enabled))
+}
+
+// noPodMonitors is a cluster without the Prometheus Operator's CRDs.
+type noPodMonitors struct {
+	client.Reader
+}
+
+func (r noPodMonitors) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	if list.GetObjectKind().GroupVersionKind().Group == podMonitorGVK.Group {
+		return &meta.NoKindMatchError{GroupKind: podMonitorGVK.GroupKind()}
+	}
+	return r.Reader.List(ctx, list, opts...)
+}
+
+func TestMkMonitoringObjects(t *testing.T) {
+	manifests := func() []client.Object {
+		withPort := func(name string, port int32) corev1.PodTemplateSpec {
+			return corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": name}},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{
+					{Name: name, Ports: []corev1.ContainerPort{{Name: "metrics", ContainerPort: port}}},
+				}},
+			}
+		}
+		return []client.Object{
+			&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "control", Namespace: "greymatter"},
+				Spec: appsv1.DeploymentSpec{Template: withPort("control", 8081)}},
+			&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "redis", Namespace: "greymatter"},
+				Spec: appsv1.StatefulSetSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "redis"}}}}}},
+			&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "control", Namespace: "greymatter"}},
+		}
+	}
+	mesh := &v1alpha1.Mesh{Spec: v1alpha1.MeshSpec{InstallNamespace: "greymatter", WatchNamespaces: []string{"apps"}}}
+	config := cuemodule.MonitoringConfig{Enabled: true, IntervalSeconds: 30, Labels: map[string]string{"release": "prometheus"}}
+
+	assert.Empty(t, mkMonitoringObjects(config, cuemodule.MonitoringNone, mesh, manifests()))
+
+	objects := mkMonitoringObjects(config, cuemodule.MonitoringPrometheusOperator, mesh, manifests())
+	if assert.Len(t, objects, 2) {
+		control := objects[0].(*unstructured.Unstructured)
+		assert.Equal(t, podMonitorGVK, control.GroupVersionKind())
+		assert.Equal(t, "control", control.GetName())
+		assert.Equal(t, "greymatter", control.GetNamespace())
+		assert.Equal(t, map[string]string{"release": "prometheus"}, control.GetLabels())
+		labels, _, _ := unstructured.NestedStringMap(control.Object, "spec", "selector", "matchLabels")
+		assert.Equal(t, map[string]string{"app": "control"}, labels)
+		endpoints, _, _ := unstructured.NestedSlice(control.Object, "spec", "podMetricsEndpoints")
+		assert.Equal(t, []interface{}{map[string]interface{}{"port": "metrics", "path": "/prometheus", "interval": "30s"}}, endpoints)
+
+		sidecars := objects[1].(*unstructured.Unstructured)
+		assert.Equal(t, sidecarPodMonitorName, sidecars.GetName())
+		assert.Equal(t, "apps", sidecars.GetNamespace())
+	}
+
+	annotated := manifests()
+	assert.Empty(t, mkMonitoringObjects(config, cuemodule.MonitoringAnnotations, mesh, annotated))
+	assert.Equal(t, map[string]string{
+		"prometheus.io/scrape": "true",
+		"prometheus.io/port":   "8081",
+		"prometheus.io/path":   "/prometheus",
+	}, annotated[0].(*appsv1.Deployment).Spec.Template.Annotations)
+	assert.Empty(t, annotated[1].(*appsv1.StatefulSet).Spec.Template.Annotations)
+}
+
+func TestScrapeAnnotations(t *testing.T) {
+	i, _ := newTestInstaller(t, startObjects()...)
+	sidecar := corev1.Container{Name: "sidecar", Ports: []corev1.ContainerPort{
+		{Name: "proxy", ContainerPort: 10808},
+		{Name: "stats", ContainerPort: 8082},
+	}}
+	i.Config.Monitoring = cuemodule.MonitoringConfig{Enabled: true, Port: "stats", Path: "/stats/prometheus"}
+
+	i.monitoring = cuemodule.MonitoringPrometheusOperator
+	assert.Nil(t, i.ScrapeAnnotations(sidecar))
+
+	i.monitoring = cuemodule.MonitoringAnnotations
+	assert.Equal(t, map[string]string{
+		"prometheus.io/scrape": "true",
+		"prometheus.io/port":   "8082",
+		"prometheus.io/path":   "/stats/prometheus",
+	}, i.ScrapeAnnotations(sidecar))
+	assert.Nil(t, i.ScrapeAnnotations(corev1.Container{Name: "app"}))
+}
@@ -0,0 +1,169 @@
+package mesh_install
+
+import (
+	"context"
+	"time"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/greymatter-io/operator/pkg/k8sapi"
+	"github.com/greymatter-io/operator/pkg/wellknown"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// The name of the namespace labeling loop in config.reconcile.disabled.
+	reconcilerNamespaceLabels = "namespace_labels"
+	// How often mesh namespaces' labels are reconciled, unless config.reconcile.interval_seconds is set.
+	namespaceLabelsInterval = time.Minute
+)
+
+// The labels kept on a mesh's namespaces, and removed from those no longer in it.
+var namespaceLabelKeys = []string{wellknown.LABEL_MESH, wellknown.LABEL_OPERATOR_VERSION, wellknown.LABEL_INJECTION}
+
+// meshNamespaces returns the mesh's install namespace followed by its watched namespaces.
+func meshNamespaces(mesh *v1alpha1.Mesh) []string {
+	namespaces := []string{mesh.Spec.InstallNamespace}
+	for _, ns := range mesh.Spec.WatchNamespaces {
+		if ns != mesh.Spec.InstallNamespace {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	return namespaces
+}
+
+// manager returns the operator and mesh the objects applied for the mesh are labeled with.
+func (i *Installer) manager(mesh *v1alpha1.Mesh) k8sapi.Manager {
+	return k8sapi.Manager{OperatorID: i.OperatorID, Mesh: mesh.Name}
+}
+
+// namespaceLabels returns the standard labels for one of the mesh's namespaces, given its current labels.
+func (i *Installer) namespaceLabels(mesh *v1alpha1.Mesh, ns *corev1.Namespace) map[string]string {
+	desired := map[string]string{
+		wellknown.LABEL_MESH:      mesh.Name,
+		wellknown.LABEL_INJECTION: namespaceInjection(mesh, ns),
+	}
+	if version := i.OperatorVersion; version != "" {
+		if problems := validation.IsValidLabelValue(version); len(problems) == 0 {
+			desired[wellknown.LABEL_OPERATOR_VERSION] = version
+		}
+	}
+	return desired
+}
+
+// namespaceInjection returns whether workloads in one of the mesh's namespaces are eligible for sidecar injection
+// under its injection policy, as the value of the injection label. A namespace selector is matched against the
+// namespace's other labels, so it can't select on the injection label itself.
+func namespaceInjection(mesh *v1alpha1.Mesh, ns *corev1.Namespace) string {
+	if systemNamespaces[ns.Name] {
+		return wellknown.INJECTION_DISABLED
+	}
+	policy := mesh.Spec.Injection
+	if policy == nil {
+		return wellknown.INJECTION_ENABLED
+	}
+	for _, excluded := range policy.ExcludedNamespaces {
+		if ns.Name == excluded {
+			return wellknown.INJECTION_DISABLED
+		}
+	}
+	if policy.NamespaceSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(policy.NamespaceSelector)
+		if err != nil {
+			return wellknown.INJECTION_DISABLED
+		}
+		others := labels.Set{}
+		for k, v := range ns.Labels {
+			if k != wellknown.LABEL_INJECTION {
+				others[k] = v
+			}
+		}
+		if !selector.Matches(others) {
+			return wellknown.INJECTION_DISABLED
+		}
+	}
+	return wellknown.INJECTION_ENABLED
+}
+
+// labelNamespaces sets the standard labels on each of the mesh's namespaces that exists, and removes them from
+// namespaces labeled for the mesh that are no longer in it.
+func (i *Installer) labelNamespaces(ctx context.Context, mesh *v1alpha1.Mesh) {
+	inMesh := make(map[string]bool)
+	for _, name := range meshNamespaces(mesh) {
+		inMesh[name] = true
+		ns := &corev1.Namespace{}
+		if err := i.K8sClient.Get(ctx, client.ObjectKey{Name: name}, ns); err != nil {
+			if !errors.IsNotFound(err) {
+				logger.Error(err, "Failed to get namespace to label", "Namespace", name)
+			}
+			continue
+		}
+		i.patchNamespaceLabels(ctx, ns, i.namespaceLabels(mesh, ns))
+	}
+	i.unlabelNamespaces(ctx, mesh.Name, inMesh)
+}
+
+// unlabelNamespaces removes the standard labels from the namespaces labeled for the named mesh, except those kept.
+func (i *Installer) unlabelNamespaces(ctx context.Context, meshName string, kept map[string]bool) {
+	namespaces := &corev1.NamespaceList{}
+	if err := i.K8sClient.List(ctx, namespaces, client.MatchingLabels{wellknown.LABEL_MESH: meshName}); err != nil {
+		logger.Error(err, "Failed to list namespaces labeled for mesh", "Mesh", meshName)
+		return
+	}
+	for idx := range namespaces.Items {
+		if ns := &namespaces.Items[idx]; !kept[ns.Name] {
+			i.patchNamespaceLabels(ctx, ns, nil)
+		}
+	}
+}
+
+// patchNamespaceLabels sets the standard labels on a namespace to those desired, removing any not desired, and leaves
+// its other labels alone. It doesn't write the namespace if they're already set.
+func (i *Installer) patchNamespaceLabels(ctx context.Context, ns *corev1.Namespace, desired map[string]string) {
+	patched := ns.DeepCopy()
+	if patched.Labels == nil {
+		patched.Labels = make(map[string]string)
+	}
+	changed := false
+	for _, key := range namespaceLabelKeys {
+		value, want := desired[key]
+		current, has := patched.Labels[key]
+		switch {
+		case want && (!has || current != value):
+			patched.Labels[key] = value
+			changed = true
+		case !want && has:
+			delete(patched.Labels, key)
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+	if err := i.K8sClient.Patch(ctx, patched, client.MergeFrom(ns)); err != nil {
+		logger.Error(err, "Failed to label namespace", "Namespace", ns.Name)
+		return
+	}
+	logger.Info("Labeled namespace", "Namespace", ns.Name, "Labels", desired)
+}
+
+// reconcileNamespaceLabels periodically restores the standard labels on THE mesh's namespaces, e.g. after they're
+// edited or the operator is upgraded, until the context is cancelled.
+func (i *Installer) reconcileNamespaceLabels(ctx context.Context) {
+	ticker := time.NewTicker(i.Config.Reconcile.Interval(namespaceLabelsInterval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if mesh := i.CurrentMesh(); mesh != nil && mesh.UID != "" {
+			i.labelNamespaces(ctx, mesh)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package mesh_install
+
+import (
+	"context"
+	"testing"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/greymatter-io/operator/pkg/wellknown"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestLabelNamespaces(t *testing.T) {
+	i, c := newTestInstaller(t,
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "greymatter"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps", Labels: map[string]string{"team": "payments"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "legacy", Labels: map[string]string{"team": "billing"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "old", Labels: map[string]string{
+			wellknown.LABEL_MESH: "mesh-sample", wellknown.LABEL_INJECTION: wellknown.INJECTION_ENABLED, "team": "ops",
+		}}},
+	)
+	i.OperatorVersion = "1.2.3"
+	mesh := &v1alpha1.Mesh{
+		ObjectMeta: metav1.ObjectMeta{Name: "mesh-sample"},
+		Spec: v1alpha1.MeshSpec{
+			InstallNamespace: "greymatter",
+			WatchNamespaces:  []string{"apps", "legacy", "missing"},
+			Injection: &v1alpha1.InjectionPolicy{
+				NamespaceSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "team", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"billing"}},
+				}},
+			},
+		},
+	}
+	labelsOf := func(name string) map[string]string {
+		ns := &corev1.Namespace{}
+		assert.NoError(t, c.Get(context.TODO(), client.ObjectKey{Name: name}, ns))
+		return ns.Labels
+	}
+
+	i.labelNamespaces(context.TODO(), mesh)
+	assert.Equal(t, map[string]string{
+		wellknown.LABEL_MESH: "mesh-sample", wellknown.LABEL_OPERATOR_VERSION: "1.2.3", wellknown.LABEL_INJECTION: wellknown.INJECTION_ENABLED,
+	}, labelsOf("greymatter"))
+	assert.Equal(t, map[string]string{
+		wellknown.LABEL_MESH: "mesh-sample", wellknown.LABEL_OPERATOR_VERSION: "1.2.3", wellknown.LABEL_INJECTION: wellknown.INJECTION_ENABLED, "team": "payments",
+	}, labelsOf("apps"))
+	// Not selected by the injection policy
+	assert.Equal(t, wellknown.INJECTION_DISABLED, labelsOf("legacy")[wellknown.LABEL_INJECTION])
+	// No longer in the mesh
+	assert.Equal(t, map[string]string{"team": "ops"}, labelsOf("old"))
+
+	// Edited labels are restored, and removed when the mesh is
+	apps := &corev1.Namespace{}
+	assert.NoError(t, c.Get(context.TODO(), client.ObjectKey{Name: "apps"}, apps))
+	apps.Labels[wellknown.LABEL_INJECTION] = wellknown.INJECTION_DISABLED
+	assert.NoError(t, c.Update(context.TODO(), apps))
+	i.labelNamespaces(context.TODO(), mesh)
+	assert.Equal(t, wellknown.INJECTION_ENABLED, labelsOf("apps")[wellknown.LABEL_INJECTION])
+
+	i.unlabelNamespaces(context.TODO(), mesh.Name, nil)
+	assert.Empty(t, labelsOf("greymatter"))
+	assert.Equal(t, map[string]string{"team": "payments"}, labelsOf("apps"))
+}
+
+func TestNamespaceInjection(t *testing.T) {
+	mesh := &v1alpha1.Mesh{Spec: v1alpha1.MeshSpec{Injection: &v1alpha1.InjectionPolicy{ExcludedNamespaces: []string{"batch"}}}}
+	ns := func(name string) *corev1.Namespace {
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	}
+	assert.Equal(t, wellknown.INJECTION_ENABLED, namespaceInjection(mesh, ns("apps")))
+	assert.Equal(t, wellknown.INJECTION_DISABLED, namespaceInjection(mesh, ns("batch")))
+	assert.Equal(t, wellknown.INJECTION_DISABLED, namespaceInjection(mesh, ns("kube-system")))
+
+	// The selector can't key off the injection label itself
+	mesh.Spec.Injection.NamespaceSelector = &metav1.LabelSelector{MatchLabels: map[string]string{wellknown.LABEL_INJECTION: wellknown.INJECTION_ENABLED}}
+	labeled := ns("apps")
+	labeled.Labels = map[string]string{wellknown.LABEL_INJECTION: wellknown.INJECTION_ENABLED}
+	assert.Equal(t, wellknown.INJECTION_DISABLED, namespaceInjection(mesh, labeled))
+}
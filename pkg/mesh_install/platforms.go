@@ -0,0 +1,54 @@
+package mesh_install
+
+import (
+	"context"
+	"sort"
+
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	"github.com/greymatter-io/operator/pkg/k8sapi"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// detectNodeArchitectures returns the architectures of the cluster's Linux nodes, most preferred first:
+// config.node_platforms.node_architectures, or if unset, those of the nodes' kubernetes.io/arch labels, the most
+// common first. Returns nil if the nodes can't be listed.
+func detectNodeArchitectures(ctx context.Context, c client.Reader, config cuemodule.Config) []string {
+	if len(config.NodePlatforms.NodeArchitectures) > 0 {
+		return config.NodePlatforms.NodeArchitectures
+	}
+	counts := make(map[string]int)
+	nodes := &corev1.NodeList{}
+	if err := k8sapi.ListPages(ctx, c, nodes, config.Reconcile.PageSize, func() error {
+		for _, node := range nodes.Items {
+			if os := node.Labels[corev1.LabelOSStable]; os != "" && os != "linux" {
+				continue
+			}
+			if arch := node.Labels[corev1.LabelArchStable]; arch != "" {
+				counts[arch]++
+			}
+		}
+		return nil
+	}); err != nil {
+		logger.Error(err, "Failed to list nodes to detect their architectures")
+		return nil
+	}
+	var archs []string
+	for arch := range counts {
+		archs = append(archs, arch)
+	}
+	sort.Slice(archs, func(i, j int) bool {
+		if counts[archs[i]] != counts[archs[j]] {
+			return counts[archs[i]] > counts[archs[j]]
+		}
+		return archs[i] < archs[j]
+	})
+	return archs
+}
+
+// SelectArchImages replaces the single-architecture images of a pod's containers, as listed in
+// config.node_platforms.arch_images, with their builds for the architecture of the nodes it's scheduled on, pinning
+// it to that architecture if the cluster's nodes have several.
+func (i *Installer) SelectArchImages(spec *corev1.PodSpec) error {
+	return cuemodule.SelectArchImages(spec, i.Config.NodePlatforms.ArchImages, i.nodeArchitectures)
+}
@@ -0,0 +1,28 @@
+package mesh_install
+
+import (
+	"context"
+	"testing"
+
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDetectNodeArchitectures(t *testing.T) {
+	node := func(name, os, arch string) *corev1.Node {
+		return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"kubernetes.io/os": os, "kubernetes.io/arch": arch}}}
+	}
+	i, _ := newTestInstaller(t,
+		node("a", "linux", "arm64"),
+		node("b", "linux", "amd64"),
+		node("c", "linux", "amd64"),
+		node("d", "windows", "arm"),
+	)
+
+	// The most common architecture of the Linux nodes comes first
+	assert.Equal(t, []string{"amd64", "arm64"}, detectNodeArchitectures(context.TODO(), i.K8sClient, i.Config))
+	// Unless they're configured
+	assert.Equal(t, []string{"arm64"}, detectNodeArchitectures(context.TODO(), i.K8sClient, cuemodule.Config{NodePlatforms: cuemodule.NodePlatformConfig{NodeArchitectures: []string{"arm64"}}}))
+}
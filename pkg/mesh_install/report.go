@@ -0,0 +1,65 @@
+package mesh_install
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/greymatter-io/operator/pkg/gitops"
+	"github.com/greymatter-io/operator/pkg/k8sapi"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// The ConfigMap (in the gm-operator namespace) that holds the latest sync report.
+	syncReportConfigMapName = "gm-sync-report"
+	// The name of the sync report loop in config.reconcile.disabled.
+	reconcilerSyncReport = "sync_report"
+	// How often the sync report is checked for changes, unless config.reconcile.interval_seconds is set.
+	syncReportInterval = 10 * time.Second
+)
+
+// publishSyncReports periodically writes the latest sync report to a ConfigMap whenever it changes.
+// Grey Matter objects are applied asynchronously, so the report keeps filling in after ApplyMesh returns.
+func (i *Installer) publishSyncReports(ctx context.Context) {
+	var lastReport *gitops.SyncReport
+	var lastVersion uint64
+
+	ticker := time.NewTicker(i.Config.Reconcile.Interval(syncReportInterval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		report := i.Sync.CurrentReport()
+		if report == nil {
+			continue
+		}
+		version := report.Version()
+		if report == lastReport && version == lastVersion {
+			continue
+		}
+
+		b, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			logger.Error(err, "Failed to serialize sync report")
+			continue
+		}
+		cm := &corev1.ConfigMap{
+			TypeMeta: metav1.TypeMeta{Kind: "ConfigMap", APIVersion: "v1"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      syncReportConfigMapName,
+				Namespace: "gm-operator",
+			},
+			Data: map[string]string{"report.json": string(b)},
+		}
+		if err := k8sapi.Apply(ctx, i.K8sClient, cm, nil, k8sapi.CreateOrUpdate); err != nil {
+			continue
+		}
+		lastReport, lastVersion = report, version
+	}
+}
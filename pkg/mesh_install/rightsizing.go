@@ -0,0 +1,332 @@
+package mesh_install
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	"github.com/greymatter-io/operator/pkg/wellknown"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// The name of the sidecar usage sampling loop in config.reconcile.disabled.
+	reconcilerRightsizing = "rightsizing"
+	// How often sidecars' usage is sampled, unless config.reconcile.interval_seconds is set.
+	rightsizingSampleInterval = time.Minute
+	// Defaults of config.rightsizing.
+	defaultRightsizingWindow     = 1440
+	defaultRightsizingMinSamples = 60
+	defaultRightsizingHeadroom   = 20
+	// The name of the injected sidecar container.
+	sidecarContainerName = "sidecar"
+)
+
+// Kind of the metrics-server's pod usage.
+var podMetricsGVK = schema.GroupVersionKind{Group: "metrics.k8s.io", Version: "v1beta1", Kind: "PodMetrics"}
+
+// Queries a Prometheus with cAdvisor metrics is sent for each sidecar's CPU, in cores, and memory, in bytes.
+const (
+	prometheusSidecarCPUQuery    = `sum by (namespace, pod) (rate(container_cpu_usage_seconds_total{container="sidecar"}[5m]))`
+	prometheusSidecarMemoryQuery = `sum by (namespace, pod) (container_memory_working_set_bytes{container="sidecar"})`
+)
+
+var prometheusClient = &http.Client{Timeout: 30 * time.Second}
+
+// sidecarWorkload identifies a workload by its namespace and its pods' cluster label.
+type sidecarWorkload struct {
+	namespace, cluster string
+}
+
+// sidecarUsage is the CPU, in thousandths of a core, and memory, in bytes, used by a sidecar when sampled.
+type sidecarUsage struct {
+	cpu, memory int64
+}
+
+// rightsizingTracker keeps the latest samples of each workload's sidecars, and the recommendations made from them.
+type rightsizingTracker struct {
+	mu              sync.Mutex
+	samples         map[sidecarWorkload][]sidecarUsage
+	recommendations []v1alpha1.SidecarRecommendation
+}
+
+// sampleSidecarUsage periodically samples the usage of the sidecars in the mesh's namespaces, and records the
+// requests recommended for them in the mesh's status when they change.
+func (i *Installer) sampleSidecarUsage(ctx context.Context) {
+	ticker := time.NewTicker(i.Config.Reconcile.Interval(rightsizingSampleInterval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if mesh := i.CurrentMesh(); mesh != nil && mesh.UID != "" {
+			i.rightsizeSidecars(ctx, mesh)
+		}
+	}
+}
+
+// rightsizeSidecars takes a sample of the usage of the sidecars in the mesh's namespaces.
+func (i *Installer) rightsizeSidecars(ctx context.Context, mesh *v1alpha1.Mesh) {
+	var usage map[sidecarWorkload][]sidecarUsage
+	var err error
+	if i.Config.Rightsizing.Source == cuemodule.RightsizingPrometheus {
+		usage, err = i.prometheusSidecarUsage(ctx, mesh)
+	} else {
+		usage, err = i.metricsServerSidecarUsage(ctx, mesh)
+	}
+	if err != nil {
+		// A partial sample would drop the history of the workloads left out, so wait for the next cycle.
+		logger.Error(err, "Failed to sample sidecar usage - will retry")
+		return
+	}
+	if recommendations, changed := i.rightsizing.record(usage, i.Config.Rightsizing); changed {
+		logger.Info("Sidecar resource recommendations changed", "Workloads", len(recommendations))
+		i.setSidecarRecommendations(recommendations)
+	}
+}
+
+// metricsServerSidecarUsage reads the usage of the sidecars in the mesh's namespaces from the metrics-server.
+func (i *Installer) metricsServerSidecarUsage(ctx context.Context, mesh *v1alpha1.Mesh) (map[sidecarWorkload][]sidecarUsage, error) {
+	usage := make(map[sidecarWorkload][]sidecarUsage)
+	for _, ns := range meshNamespaces(mesh) {
+		// The metrics-server labels each pod's metrics with the pod's labels
+		list := &unstructured.UnstructuredList{}
+		list.SetGroupVersionKind(podMetricsGVK.GroupVersion().WithKind(podMetricsGVK.Kind + "List"))
+		if err := i.K8sClient.List(ctx, list, client.InNamespace(ns), client.HasLabels{wellknown.LABEL_CLUSTER}); err != nil {
+			return nil, fmt.Errorf("failed to list pod metrics in namespace %s; is the metrics-server installed? %w", ns, err)
+		}
+		for _, item := range list.Items {
+			containers, _, _ := unstructured.NestedSlice(item.Object, "containers")
+			for _, c := range containers {
+				container, ok := c.(map[string]interface{})
+				if !ok || container["name"] != sidecarContainerName {
+					continue
+				}
+				cpu, _, _ := unstructured.NestedString(container, "usage", "cpu")
+				memory, _, _ := unstructured.NestedString(container, "usage", "memory")
+				cpuQuantity, cpuErr := resource.ParseQuantity(cpu)
+				memoryQuantity, memoryErr := resource.ParseQuantity(memory)
+				if cpuErr != nil || memoryErr != nil {
+					continue
+				}
+				workload := sidecarWorkload{ns, item.GetLabels()[wellknown.LABEL_CLUSTER]}
+				usage[workload] = append(usage[workload], sidecarUsage{cpuQuantity.MilliValue(), memoryQuantity.Value()})
+			}
+		}
+	}
+	return usage, nil
+}
+
+// prometheusSidecarUsage reads the usage of the sidecars of the pods in the mesh's namespaces from Prometheus.
+func (i *Installer) prometheusSidecarUsage(ctx context.Context, mesh *v1alpha1.Mesh) (map[sidecarWorkload][]sidecarUsage, error) {
+	// Prometheus knows pods by name, so their workloads are found from the pods themselves
+	workloads := make(map[string]sidecarWorkload)
+	for _, ns := range meshNamespaces(mesh) {
+		pods := &corev1.PodList{}
+		if err := i.K8sClient.List(ctx, pods, client.InNamespace(ns), client.HasLabels{wellknown.LABEL_CLUSTER}); err != nil {
+			return nil, fmt.Errorf("failed to list pods in namespace %s: %w", ns, err)
+		}
+		for _, pod := range pods.Items {
+			workloads[ns+"/"+pod.Name] = sidecarWorkload{ns, pod.Labels[wellknown.LABEL_CLUSTER]}
+		}
+	}
+
+	base := i.Config.Rightsizing.PrometheusURL
+	cpu, err := queryPrometheus(ctx, base, prometheusSidecarCPUQuery)
+	if err != nil {
+		return nil, err
+	}
+	memory, err := queryPrometheus(ctx, base, prometheusSidecarMemoryQuery)
+	if err != nil {
+		return nil, err
+	}
+	usage := make(map[sidecarWorkload][]sidecarUsage)
+	for pod, cores := range cpu {
+		workload, ok := workloads[pod]
+		bytes, sampled := memory[pod]
+		if !ok || !sampled {
+			continue
+		}
+		usage[workload] = append(usage[workload], sidecarUsage{int64(cores * 1000), int64(bytes)})
+	}
+	return usage, nil
+}
+
+// queryPrometheus returns the value of each namespace/pod in the result of an instant query.
+func queryPrometheus(ctx context.Context, base, query string) (map[string]float64, error) {
+	u := strings.TrimSuffix(base, "/") + "/api/v1/query?" + url.Values{"query": {query}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query Prometheus: %w", err)
+	}
+	resp, err := prometheusClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query Prometheus: %w", err)
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+		Data   struct {
+			Result []struct {
+				Metric map[string]string `json:"metric"`
+				Value  [2]interface{}    `json:"value"`
+			} `json:"result"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode Prometheus response (HTTP %d): %w", resp.StatusCode, err)
+	}
+	if result.Status != "success" {
+		return nil, fmt.Errorf("Prometheus query failed (HTTP %d): %s", resp.StatusCode, result.Error)
+	}
+	values := make(map[string]float64)
+	for _, sample := range result.Data.Result {
+		s, _ := sample.Value[1].(string)
+		if v, err := strconv.ParseFloat(s, 64); err == nil {
+			values[sample.Metric["namespace"]+"/"+sample.Metric["pod"]] = v
+		}
+	}
+	return values, nil
+}
+
+// record adds a sample of each workload's sidecars to their history, trimmed to the configured window, and forgets
+// workloads no longer sampled. It returns the recommendations for workloads with enough samples, and whether they
+// changed.
+func (t *rightsizingTracker) record(usage map[sidecarWorkload][]sidecarUsage, config cuemodule.RightsizingConfig) ([]v1alpha1.SidecarRecommendation, bool) {
+	window, minSamples := config.Window, config.MinSamples
+	if window <= 0 {
+		window = defaultRightsizingWindow
+	}
+	if minSamples <= 0 {
+		minSamples = defaultRightsizingMinSamples
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	samples := make(map[sidecarWorkload][]sidecarUsage, len(usage))
+	var recommendations []v1alpha1.SidecarRecommendation
+	for workload, sampled := range usage {
+		history := append(t.samples[workload], sampled...)
+		if len(history) > window {
+			history = append([]sidecarUsage(nil), history[len(history)-window:]...)
+		}
+		samples[workload] = history
+		if len(history) >= minSamples {
+			cpu, memory := recommendRequests(history, config)
+			recommendations = append(recommendations, v1alpha1.SidecarRecommendation{
+				Namespace: workload.namespace,
+				Cluster:   workload.cluster,
+				CPU:       resource.NewMilliQuantity(cpu, resource.DecimalSI).String(),
+				Memory:    resource.NewQuantity(memory, resource.BinarySI).String(),
+			})
+		}
+	}
+	sort.Slice(recommendations, func(a, b int) bool {
+		if recommendations[a].Namespace != recommendations[b].Namespace {
+			return recommendations[a].Namespace < recommendations[b].Namespace
+		}
+		return recommendations[a].Cluster < recommendations[b].Cluster
+	})
+	t.samples = samples
+	changed := !reflect.DeepEqual(recommendations, t.recommendations)
+	t.recommendations = recommendations
+	return recommendations, changed
+}
+
+// recommendRequests returns the CPU, in thousandths of a core, and memory, in bytes, recommended from a workload's
+// samples: the 90th percentile of their CPU and their peak memory, plus headroom, rounded up to 5m and 1Mi (so
+// recommendations don't change with every sample), within the configured bounds.
+func recommendRequests(samples []sidecarUsage, config cuemodule.RightsizingConfig) (cpu, memory int64) {
+	cpus := make([]int64, len(samples))
+	for idx, sample := range samples {
+		cpus[idx] = sample.cpu
+		if sample.memory > memory {
+			memory = sample.memory
+		}
+	}
+	sort.Slice(cpus, func(a, b int) bool { return cpus[a] < cpus[b] })
+	cpu = cpus[(len(cpus)*9+9)/10-1]
+
+	headroom := int64(config.HeadroomPercent)
+	if config.HeadroomPercent == 0 {
+		headroom = defaultRightsizingHeadroom
+	}
+	cpu = roundUp(cpu*(100+headroom)/100, 5)
+	memory = roundUp(memory*(100+headroom)/100, 1<<20)
+
+	cpu = clamp(cpu, quantityBound(config.MinCPU, true), quantityBound(config.MaxCPU, true))
+	memory = clamp(memory, quantityBound(config.MinMemory, false), quantityBound(config.MaxMemory, false))
+	return cpu, memory
+}
+
+func roundUp(n, multiple int64) int64 {
+	if n%multiple == 0 {
+		return n
+	}
+	return (n/multiple + 1) * multiple
+}
+
+// clamp returns n within the bounds that are set (non-zero).
+func clamp(n, min, max int64) int64 {
+	if min > 0 && n < min {
+		n = min
+	}
+	if max > 0 && n > max {
+		n = max
+	}
+	return n
+}
+
+// quantityBound returns a configured bound, in thousandths if milli, or 0 if it's unset.
+func quantityBound(value string, milli bool) int64 {
+	q, err := resource.ParseQuantity(value)
+	if err != nil {
+		return 0
+	}
+	if milli {
+		return q.MilliValue()
+	}
+	return q.Value()
+}
+
+// SidecarRecommendations returns the CPU and memory requests recommended for each workload's sidecars from their
+// sampled usage, if config.rightsizing is enabled.
+func (i *Installer) SidecarRecommendations() []v1alpha1.SidecarRecommendation {
+	i.rightsizing.mu.Lock()
+	defer i.rightsizing.mu.Unlock()
+	return append([]v1alpha1.SidecarRecommendation(nil), i.rightsizing.recommendations...)
+}
+
+// RecommendedSidecarRequests returns the requests recommended for the sidecars of the workload in a namespace with
+// the given cluster label, if config.rightsizing.auto_apply is set and it has a recommendation.
+func (i *Installer) RecommendedSidecarRequests(namespace, cluster string) (corev1.ResourceList, bool) {
+	if !i.Config.Rightsizing.Enabled || !i.Config.Rightsizing.AutoApply {
+		return nil, false
+	}
+	for _, recommendation := range i.SidecarRecommendations() {
+		if recommendation.Namespace == namespace && recommendation.Cluster == cluster {
+			return corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse(recommendation.CPU),
+				corev1.ResourceMemory: resource.MustParse(recommendation.Memory),
+			}, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,119 @@
+package mesh_install
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	"github.com/greymatter-io/operator/pkg/wellknown"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestRecommendRequests(t *testing.T) {
+	var samples []sidecarUsage
+	for n := int64(1); n <= 10; n++ {
+		samples = append(samples, sidecarUsage{cpu: n * 10, memory: n << 20})
+	}
+	// The 90th percentile CPU (90m) and peak memory (10Mi), plus 20%, rounded up
+	cpu, memory := recommendRequests(samples, cuemodule.RightsizingConfig{})
+	assert.Equal(t, int64(110), cpu)
+	assert.Equal(t, int64(12<<20), memory)
+
+	cpu, memory = recommendRequests(samples, cuemodule.RightsizingConfig{HeadroomPercent: 100, MaxCPU: "100m", MinMemory: "64Mi"})
+	assert.Equal(t, int64(100), cpu)
+	assert.Equal(t, int64(64<<20), memory)
+}
+
+func TestRightsizingTrackerRecord(t *testing.T) {
+	var tracker rightsizingTracker
+	config := cuemodule.RightsizingConfig{Window: 3, MinSamples: 2}
+	web, api := sidecarWorkload{"apps", "web"}, sidecarWorkload{"apps", "api"}
+
+	recommendations, changed := tracker.record(map[sidecarWorkload][]sidecarUsage{web: {{cpu: 10, memory: 1 << 20}}}, config)
+	assert.Empty(t, recommendations, "not enough samples")
+	assert.False(t, changed)
+
+	recommendations, changed = tracker.record(map[sidecarWorkload][]sidecarUsage{
+		web: {{cpu: 40, memory: 1 << 20}},
+		api: {{cpu: 5, memory: 2 << 20}, {cpu: 5, memory: 2 << 20}},
+	}, config)
+	assert.True(t, changed)
+	assert.Equal(t, []v1alpha1.SidecarRecommendation{
+		{Namespace: "apps", Cluster: "api", CPU: "10m", Memory: "3Mi"},
+		{Namespace: "apps", Cluster: "web", CPU: "50m", Memory: "2Mi"},
+	}, recommendations)
+
+	// Samples beyond the window are dropped, as are workloads no longer sampled
+	for n := 0; n < 2; n++ {
+		tracker.record(map[sidecarWorkload][]sidecarUsage{web: {{cpu: 10, memory: 1 << 20}}}, config)
+	}
+	recommendations, _ = tracker.record(map[sidecarWorkload][]sidecarUsage{web: {{cpu: 10, memory: 1 << 20}}}, config)
+	assert.Equal(t, []v1alpha1.SidecarRecommendation{{Namespace: "apps", Cluster: "web", CPU: "15m", Memory: "2Mi"}}, recommendations)
+	assert.Len(t, tracker.samples[web], 3)
+}
+
+func TestMetricsServerSidecarUsage(t *testing.T) {
+	metrics := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "web-1", "namespace": "apps", "labels": map[string]interface{}{wellknown.LABEL_CLUSTER: "web"}},
+		"containers": []interface{}{
+			map[string]interface{}{"name": "web", "usage": map[string]interface{}{"cpu": "500m", "memory": "1Gi"}},
+			map[string]interface{}{"name": "sidecar", "usage": map[string]interface{}{"cpu": "12345678n", "memory": "20Mi"}},
+		},
+	}}
+	metrics.SetGroupVersionKind(podMetricsGVK)
+	i, _ := newTestInstaller(t, metrics)
+	mesh := &v1alpha1.Mesh{Spec: v1alpha1.MeshSpec{InstallNamespace: "greymatter", WatchNamespaces: []string{"apps"}}}
+
+	usage, err := i.metricsServerSidecarUsage(context.TODO(), mesh)
+	assert.NoError(t, err)
+	assert.Equal(t, map[sidecarWorkload][]sidecarUsage{{"apps", "web"}: {{cpu: 13, memory: 20 << 20}}}, usage)
+}
+
+func TestPrometheusSidecarUsage(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		value := "0.025"
+		if r.URL.Query().Get("query") == prometheusSidecarMemoryQuery {
+			value = "31457280"
+		}
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":[
+			{"metric":{"namespace":"apps","pod":"web-1"},"value":[1700000000,%q]},
+			{"metric":{"namespace":"other","pod":"db-1"},"value":[1700000000,%q]}]}}`, value, value)
+	}))
+	defer srv.Close()
+	i, _ := newTestInstaller(t, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "apps", Labels: map[string]string{wellknown.LABEL_CLUSTER: "web"}}})
+	i.Config.Rightsizing = cuemodule.RightsizingConfig{Enabled: true, Source: cuemodule.RightsizingPrometheus, PrometheusURL: srv.URL + "/"}
+	mesh := &v1alpha1.Mesh{Spec: v1alpha1.MeshSpec{InstallNamespace: "greymatter", WatchNamespaces: []string{"apps"}}}
+
+	usage, err := i.prometheusSidecarUsage(context.TODO(), mesh)
+	assert.NoError(t, err)
+	assert.Equal(t, map[sidecarWorkload][]sidecarUsage{{"apps", "web"}: {{cpu: 25, memory: 30 << 20}}}, usage)
+}
+
+func TestRecommendedSidecarRequests(t *testing.T) {
+	i, c := newTestInstaller(t)
+	i.Mesh.UID = "mesh-uid"
+	assert.NoError(t, c.Create(context.TODO(), i.Mesh))
+	i.rightsizing.recommendations = []v1alpha1.SidecarRecommendation{{Namespace: "apps", Cluster: "web", CPU: "25m", Memory: "48Mi"}}
+
+	i.Config.Rightsizing = cuemodule.RightsizingConfig{Enabled: true}
+	_, ok := i.RecommendedSidecarRequests("apps", "web")
+	assert.False(t, ok, "not auto-applied")
+
+	i.Config.Rightsizing.AutoApply = true
+	requests, ok := i.RecommendedSidecarRequests("apps", "web")
+	assert.True(t, ok)
+	assert.Equal(t, resource.MustParse("48Mi"), requests[corev1.ResourceMemory])
+	_, ok = i.RecommendedSidecarRequests("apps", "api")
+	assert.False(t, ok)
+
+	i.setSidecarRecommendations(i.SidecarRecommendations())
+	assert.Equal(t, i.SidecarRecommendations(), i.CurrentMesh().Status.SidecarRecommendations)
+}
@@ -0,0 +1,291 @@
+package mesh_install
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/greymatter-io/operator/pkg/gitops"
+	"github.com/greymatter-io/operator/pkg/k8sapi"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// How often the workloads applied in a sync cycle are checked while they roll out.
+var rolloutPollInterval = 2 * time.Second
+
+// appliedManifest is a manifest applied in a sync cycle, and what's needed to roll it back.
+type appliedManifest struct {
+	manifest client.Object
+	// Taken before the manifest was applied, since applying it may change it
+	ref gitops.K8sObjectRef
+	// The live version from before the manifest was applied, or nil if it didn't exist
+	prior *unstructured.Unstructured
+	// False if the live version couldn't be read, so the manifest can't be rolled back
+	captured bool
+}
+
+// rolloutTracker tracks the rollouts of the workloads applied in the latest sync cycle.
+type rolloutTracker struct {
+	mu sync.Mutex
+	// Stops tracking the previous sync cycle's rollouts
+	cancel context.CancelFunc
+	// The RolledOut condition last set on the mesh
+	status  metav1.ConditionStatus
+	reason  string
+	message string
+}
+
+// rolloutProgress is the progress of a Deployment or StatefulSet rolling out.
+type rolloutProgress struct {
+	desired, updated, available int32
+	done                        bool
+	// Why the rollout failed, if it has
+	err error
+}
+
+// rolloutWorkloads returns the Deployments and StatefulSets among the applied manifests, which roll out.
+func rolloutWorkloads(applied []appliedManifest) []client.Object {
+	var workloads []client.Object
+	for _, a := range applied {
+		switch a.manifest.(type) {
+		case *appsv1.Deployment, *appsv1.StatefulSet:
+			workloads = append(workloads, a.manifest)
+		}
+	}
+	return workloads
+}
+
+// beginRollouts stops tracking the previous sync cycle's rollouts, returning a context for tracking the latest, which
+// ends with the given one.
+func (i *Installer) beginRollouts(ctx context.Context) context.Context {
+	i.rollouts.mu.Lock()
+	defer i.rollouts.mu.Unlock()
+	if i.rollouts.cancel != nil {
+		i.rollouts.cancel()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	i.rollouts.cancel = cancel
+	rolloutReplicas.Reset()
+	rolloutStatus.Reset()
+	return ctx
+}
+
+// trackRollouts waits for each workload to roll out, reporting their progress in the mesh's RolledOut condition and
+// in metrics as it goes, and returns why each that didn't failed: because one of its pods started since the given time
+// is crash-looping, its progress deadline was exceeded, or it didn't roll out within the timeout.
+// Tracking stops early if the context is cancelled, e.g. by the next sync cycle.
+func (i *Installer) trackRollouts(ctx context.Context, workloads []client.Object, since time.Time, timeout time.Duration) map[client.Object]error {
+	failed := make(map[client.Object]error)
+	progress := make([]rolloutProgress, len(workloads))
+	deadline := time.Now().Add(timeout)
+	for {
+		pending := false
+		for idx, workload := range workloads {
+			if progress[idx].done || progress[idx].err != nil {
+				continue
+			}
+			progress[idx] = checkRollout(ctx, i.K8sClient, workload, since)
+			if !progress[idx].done && progress[idx].err == nil && time.Now().After(deadline) {
+				progress[idx].err = fmt.Errorf("not rolled out within %s", timeout)
+			}
+			if progress[idx].err != nil {
+				failed[workload] = progress[idx].err
+			} else if !progress[idx].done {
+				pending = true
+			}
+		}
+		if ctx.Err() != nil {
+			return failed
+		}
+		i.reportRollouts(workloads, progress)
+		if !pending {
+			return failed
+		}
+		select {
+		case <-ctx.Done():
+			return failed
+		case <-time.After(rolloutPollInterval):
+		}
+	}
+}
+
+// reportRollouts sets the rollout metrics of each workload, and the mesh's RolledOut condition if it has changed.
+func (i *Installer) reportRollouts(workloads []client.Object, progress []rolloutProgress) {
+	var failures, rollingOut []string
+	for idx, workload := range workloads {
+		p := progress[idx]
+		kind := workload.GetObjectKind().GroupVersionKind().Kind
+		component := fmt.Sprintf("%s %s/%s", kind, workload.GetNamespace(), workload.GetName())
+		status := "complete"
+		switch {
+		case p.err != nil:
+			status = "failed"
+			failures = append(failures, fmt.Sprintf("%s: %v", component, p.err))
+		case !p.done:
+			status = "progressing"
+			rollingOut = append(rollingOut, fmt.Sprintf("%s: %d/%d updated, %d/%d available", component, p.updated, p.desired, p.available, p.desired))
+		}
+		for _, s := range []string{"progressing", "complete", "failed"} {
+			value := 0.0
+			if s == status {
+				value = 1
+			}
+			rolloutStatus.WithLabelValues(kind, workload.GetNamespace(), workload.GetName(), s).Set(value)
+		}
+		rolloutReplicas.WithLabelValues(kind, workload.GetNamespace(), workload.GetName(), "desired").Set(float64(p.desired))
+		rolloutReplicas.WithLabelValues(kind, workload.GetNamespace(), workload.GetName(), "updated").Set(float64(p.updated))
+		rolloutReplicas.WithLabelValues(kind, workload.GetNamespace(), workload.GetName(), "available").Set(float64(p.available))
+	}
+
+	status, reason, message := metav1.ConditionTrue, "RolledOut", fmt.Sprintf("All %d workloads applied in the latest sync cycle rolled out", len(workloads))
+	switch {
+	case len(failures) > 0:
+		status, reason, message = metav1.ConditionFalse, "RolloutFailed", strings.Join(failures, "; ")
+	case len(rollingOut) > 0:
+		status, reason, message = metav1.ConditionUnknown, "RollingOut", strings.Join(rollingOut, "; ")
+	}
+	i.rollouts.mu.Lock()
+	changed := status != i.rollouts.status || reason != i.rollouts.reason || message != i.rollouts.message
+	i.rollouts.status, i.rollouts.reason, i.rollouts.message = status, reason, message
+	i.rollouts.mu.Unlock()
+	if changed {
+		i.setMeshCondition(v1alpha1.ConditionRolledOut, status, reason, message)
+	}
+}
+
+// checkRollout returns the progress of a Deployment or StatefulSet rolling out. Other kinds are done once applied.
+// Errors reading the workload are logged and treated as still rolling out.
+func checkRollout(ctx context.Context, c client.Reader, workload client.Object, since time.Time) rolloutProgress {
+	ctx, cancel := k8sapi.WithTimeout(ctx)
+	defer cancel()
+	key := client.ObjectKeyFromObject(workload)
+	switch workload.(type) {
+	case *appsv1.Deployment:
+		live := &appsv1.Deployment{}
+		if err := c.Get(ctx, key, live); err != nil {
+			logger.Error(err, "Failed to get Deployment rolling out", "Namespace", key.Namespace, "Name", key.Name)
+			return rolloutProgress{}
+		}
+		progress := rolloutProgress{
+			desired:   replicas(live.Spec.Replicas),
+			updated:   live.Status.UpdatedReplicas,
+			available: live.Status.AvailableReplicas,
+			done:      deploymentRolledOut(live),
+		}
+		for _, cond := range live.Status.Conditions {
+			if cond.Type == appsv1.DeploymentProgressing && cond.Reason == "ProgressDeadlineExceeded" {
+				progress.done, progress.err = false, fmt.Errorf("progress deadline exceeded: %s", cond.Message)
+				return progress
+			}
+		}
+		if err := crashLooping(ctx, c, key.Namespace, live.Spec.Selector, since); err != nil {
+			progress.done, progress.err = false, err
+		}
+		return progress
+
+	case *appsv1.StatefulSet:
+		live := &appsv1.StatefulSet{}
+		if err := c.Get(ctx, key, live); err != nil {
+			logger.Error(err, "Failed to get StatefulSet rolling out", "Namespace", key.Namespace, "Name", key.Name)
+			return rolloutProgress{}
+		}
+		progress := rolloutProgress{
+			desired:   replicas(live.Spec.Replicas),
+			updated:   live.Status.UpdatedReplicas,
+			available: live.Status.ReadyReplicas,
+			done:      statefulSetRolledOut(live),
+		}
+		if err := crashLooping(ctx, c, key.Namespace, live.Spec.Selector, since); err != nil {
+			progress.done, progress.err = false, err
+		}
+		return progress
+	}
+	return rolloutProgress{done: true}
+}
+
+// replicas returns the desired replicas of a workload, which default to 1.
+func replicas(specReplicas *int32) int32 {
+	if specReplicas == nil {
+		return 1
+	}
+	return *specReplicas
+}
+
+// deploymentRolledOut reports whether a Deployment's latest spec has been observed, all of its replicas are
+// updated and available, and none of its old replicas remain.
+func deploymentRolledOut(d *appsv1.Deployment) bool {
+	replicas := replicas(d.Spec.Replicas)
+	return d.Status.ObservedGeneration >= d.Generation &&
+		d.Status.UpdatedReplicas == replicas &&
+		d.Status.Replicas == replicas &&
+		d.Status.AvailableReplicas == replicas
+}
+
+// crashLooping returns an error naming a container in CrashLoopBackOff among the selected pods started since the
+// given time, if there is one. Older pods are ignored, since they belong to the version being replaced.
+func crashLooping(ctx context.Context, c client.Reader, namespace string, selector *metav1.LabelSelector, since time.Time) error {
+	sel, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return nil
+	}
+	pods := &corev1.PodList{}
+	if err := c.List(ctx, pods, client.InNamespace(namespace), client.MatchingLabelsSelector{Selector: sel}); err != nil {
+		logger.Error(err, "Failed to list pods rolling out", "Namespace", namespace, "Selector", sel.String())
+		return nil
+	}
+	for _, pod := range pods.Items {
+		if pod.CreationTimestamp.Time.Before(since.Truncate(time.Second)) {
+			continue
+		}
+		for _, status := range append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...) {
+			if status.State.Waiting != nil && status.State.Waiting.Reason == "CrashLoopBackOff" {
+				return fmt.Errorf("container %s of pod %s is in CrashLoopBackOff", status.Name, pod.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// rollBack restores the live versions of the manifests applied in a sync cycle from before they were applied,
+// deleting those that didn't exist, and forgets their state entries so the next sync cycle applies them again.
+// Each restored manifest that didn't fail to roll out is recorded in the report as rolled back.
+func (i *Installer) rollBack(ctx context.Context, applied []appliedManifest, failed map[client.Object]error, report *gitops.SyncReport) error {
+	var refs []gitops.K8sObjectRef
+	var errs []string
+	for idx := len(applied) - 1; idx >= 0; idx-- {
+		a := applied[idx]
+		kind := a.ref.Kind.Kind
+		if !a.captured {
+			logger.Info("Not rolling back manifest whose previous version is unknown", "Kind", kind, "Namespace", a.ref.Namespace, "Name", a.ref.Name)
+			continue
+		}
+		refs = append(refs, a.ref)
+		err := k8sapi.Restore(ctx, i.K8sClient, a.manifest, a.prior)
+		if err != nil {
+			logger.Error(err, "Failed to roll back manifest", "Kind", kind, "Namespace", a.ref.Namespace, "Name", a.ref.Name)
+			errs = append(errs, fmt.Sprintf("%s %s/%s: %v", kind, a.ref.Namespace, a.ref.Name, err))
+		} else {
+			logger.Info("Rolled back manifest", "Kind", kind, "Namespace", a.ref.Namespace, "Name", a.ref.Name)
+		}
+		if rolloutErr, ok := failed[a.manifest]; ok {
+			if err != nil {
+				report.Record("k8s", kind, a.ref.Namespace, a.ref.Name, "rollout", fmt.Errorf("%v; rollback failed: %w", rolloutErr, err))
+			}
+			continue
+		}
+		report.Record("k8s", kind, a.ref.Namespace, a.ref.Name, "rollback", err)
+	}
+	i.Sync.SyncState.ForgetK8s(refs)
+
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to roll back %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
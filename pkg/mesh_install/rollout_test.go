@@ -0,0 +1,261 @@
+package mesh_install
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	"github.com/greymatter-io/operator/pkg/gitops"
+	"github.com/greymatter-io/operator/pkg/gmapi"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestCheckRollout(t *testing.T) {
+	since := time.Now()
+	selector := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "control"}}
+	deployment := func(status appsv1.DeploymentStatus) *appsv1.Deployment {
+		return &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "control", Namespace: "greymatter", Generation: 2},
+			Spec:       appsv1.DeploymentSpec{Replicas: pointer.Int32(2), Selector: selector},
+			Status:     status,
+		}
+	}
+	pod := func(name string, created time.Time, reason string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "greymatter", Labels: selector.MatchLabels, CreationTimestamp: metav1.NewTime(created)},
+			Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+				Name:  "control",
+				State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: reason}},
+			}}},
+		}
+	}
+	rolledOut := appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 2, UpdatedReplicas: 2, AvailableReplicas: 2}
+
+	cases := map[string]struct {
+		objects  []client.Object
+		progress rolloutProgress
+		err      string
+	}{
+		"rolled out": {
+			// An old pod crash-looping doesn't fail the rollout replacing it
+			objects:  []client.Object{deployment(rolledOut), pod("old", since.Add(-time.Minute), "CrashLoopBackOff")},
+			progress: rolloutProgress{desired: 2, updated: 2, available: 2, done: true},
+		},
+		"old replicas remain": {
+			objects:  []client.Object{deployment(appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 3, UpdatedReplicas: 2, AvailableReplicas: 2})},
+			progress: rolloutProgress{desired: 2, updated: 2, available: 2},
+		},
+		"crash-looping": {
+			objects:  []client.Object{deployment(rolledOut), pod("new", since.Add(time.Second), "CrashLoopBackOff")},
+			progress: rolloutProgress{desired: 2, updated: 2, available: 2},
+			err:      "container control of pod new is in CrashLoopBackOff",
+		},
+		"progress deadline exceeded": {
+			objects: []client.Object{deployment(appsv1.DeploymentStatus{ObservedGeneration: 2, Conditions: []appsv1.DeploymentCondition{{
+				Type: appsv1.DeploymentProgressing, Status: corev1.ConditionFalse, Reason: "ProgressDeadlineExceeded", Message: "timed out",
+			}}})},
+			progress: rolloutProgress{desired: 2},
+			err:      "progress deadline exceeded: timed out",
+		},
+		"not found": {},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			c := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(tc.objects...).Build()
+			progress := checkRollout(context.TODO(), c, deployment(appsv1.DeploymentStatus{}), since)
+			if tc.err != "" {
+				assert.Contains(t, fmt.Sprint(progress.err), tc.err)
+			} else {
+				assert.NoError(t, progress.err)
+			}
+			progress.err = nil
+			assert.Equal(t, tc.progress, progress)
+		})
+	}
+
+	// Other kinds have nothing to roll out
+	progress := checkRollout(context.TODO(), fake.NewClientBuilder().Build(), &corev1.ConfigMap{}, since)
+	assert.True(t, progress.done)
+	assert.NoError(t, progress.err)
+}
+
+func TestTrackRollouts(t *testing.T) {
+	defer func(interval time.Duration) { rolloutPollInterval = interval }(rolloutPollInterval)
+	rolloutPollInterval = 10 * time.Millisecond
+
+	control := &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Name: "control", Namespace: "greymatter", Generation: 1},
+		Spec:       appsv1.DeploymentSpec{Replicas: pointer.Int32(3)},
+		Status:     appsv1.DeploymentStatus{ObservedGeneration: 1, Replicas: 3, UpdatedReplicas: 1},
+	}
+	redis := &appsv1.StatefulSet{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "StatefulSet"},
+		ObjectMeta: metav1.ObjectMeta{Name: "redis", Namespace: "greymatter", Generation: 1},
+		Status:     appsv1.StatefulSetStatus{ObservedGeneration: 1, Replicas: 1, UpdatedReplicas: 1, ReadyReplicas: 1, CurrentRevision: "r1", UpdateRevision: "r1"},
+	}
+	i, c := newTestInstaller(t, append(startObjects(), control, redis)...)
+	i.Mesh.UID = "mesh-uid"
+	assert.NoError(t, c.Create(context.TODO(), i.Mesh))
+	// The mesh is replaced as its status is updated while rollouts are tracked
+	key := client.ObjectKeyFromObject(i.Mesh)
+	condition := func() *metav1.Condition {
+		live := &v1alpha1.Mesh{}
+		assert.NoError(t, c.Get(context.TODO(), key, live))
+		return meta.FindStatusCondition(live.Status.Conditions, v1alpha1.ConditionRolledOut)
+	}
+
+	done := make(chan map[client.Object]error)
+	go func() {
+		done <- i.trackRollouts(i.beginRollouts(context.TODO()), []client.Object{control, redis}, time.Now(), time.Minute)
+	}()
+
+	// Progress is reported while the Deployment rolls out
+	assert.Eventually(t, func() bool {
+		cond := condition()
+		return cond != nil && cond.Status == metav1.ConditionUnknown
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "Deployment greymatter/control: 1/3 updated, 0/3 available", condition().Message)
+	assert.Equal(t, 3.0, testutil.ToFloat64(rolloutReplicas.WithLabelValues("Deployment", "greymatter", "control", "desired")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(rolloutStatus.WithLabelValues("Deployment", "greymatter", "control", "progressing")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(rolloutStatus.WithLabelValues("StatefulSet", "greymatter", "redis", "complete")))
+
+	live := &appsv1.Deployment{}
+	assert.NoError(t, c.Get(context.TODO(), client.ObjectKeyFromObject(control), live))
+	live.Status = appsv1.DeploymentStatus{ObservedGeneration: 1, Replicas: 3, UpdatedReplicas: 3, AvailableReplicas: 3}
+	assert.NoError(t, c.Status().Update(context.TODO(), live))
+
+	select {
+	case failed := <-done:
+		assert.Empty(t, failed)
+	case <-time.After(5 * time.Second):
+		t.Fatal("rollouts still tracked after the Deployment rolled out")
+	}
+	if cond := condition(); assert.NotNil(t, cond) {
+		assert.Equal(t, metav1.ConditionTrue, cond.Status)
+		assert.Equal(t, "RolledOut", cond.Reason)
+	}
+	assert.Equal(t, 0.0, testutil.ToFloat64(rolloutStatus.WithLabelValues("Deployment", "greymatter", "control", "progressing")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(rolloutStatus.WithLabelValues("Deployment", "greymatter", "control", "complete")))
+
+	// The next sync cycle stops tracking the last one's rollouts
+	go func() {
+		done <- i.trackRollouts(i.beginRollouts(context.TODO()), []client.Object{&appsv1.Deployment{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+			ObjectMeta: metav1.ObjectMeta{Name: "catalog", Namespace: "greymatter"},
+		}}, time.Now(), time.Minute)
+	}()
+	assert.Eventually(t, func() bool {
+		return condition().Status == metav1.ConditionUnknown
+	}, time.Second, 10*time.Millisecond)
+	i.beginRollouts(context.TODO())
+	select {
+	case failed := <-done:
+		assert.Empty(t, failed)
+	case <-time.After(5 * time.Second):
+		t.Fatal("rollouts still tracked after the next sync cycle began")
+	}
+}
+
+// rolloutCUE installs a ConfigMap and a Deployment of the given version, and a Service from version 2.
+const rolloutCUE = `package outputs
+
+mesh: metadata: name: "greymatter-mesh"
+mesh: spec: install_namespace: "greymatter"
+release: %d
+k8s_manifests: [{
+	apiVersion: "v1"
+	kind:       "ConfigMap"
+	metadata: {name: "settings", namespace: "greymatter"}
+	data: version: "\(release)"
+}, {
+	apiVersion: "apps/v1"
+	kind:       "Deployment"
+	metadata: {name: "control", namespace: "greymatter"}
+	spec: {
+		selector: matchLabels: app: "control"
+		template: metadata: labels: app: "control"
+		template: spec: containers: [{name: "control", image: "control:\(release)"}]
+	}
+}] + [ if release > 1 {
+	apiVersion: "v1"
+	kind:       "Service"
+	metadata: {name: "control-admin", namespace: "greymatter"}
+	spec: ports: [{port: 8080}]
+}]
+`
+
+func TestApplyMeshRollsBack(t *testing.T) {
+	defer func(interval time.Duration) { rolloutPollInterval = interval }(rolloutPollInterval)
+	rolloutPollInterval = 10 * time.Millisecond
+
+	i, c := newTestInstaller(t, startObjects()...)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	i.Sync.SyncState = gitops.NewSyncState(ctx, cuemodule.Defaults{RedisHost: "127.0.0.1", RedisPort: 1}, "")
+	i.imagePullSecret = startObjects()[1].(*corev1.Secret)
+	m := gmapi.NewMockAPI()
+	defer m.Close()
+	i.CLI = m.NewCLI(ctx, i.OperatorCUE)
+	i.Config.Rollout = cuemodule.RolloutConfig{Rollback: true, TimeoutSeconds: 1}
+
+	writeVersion := func(version int) {
+		path := filepath.Join(i.CueRoot, "k8s/outputs/outputs.cue")
+		assert.NoError(t, os.WriteFile(path, []byte(fmt.Sprintf(rolloutCUE, version)), 0600))
+	}
+	get := func(name string, obj client.Object) error {
+		return c.Get(context.TODO(), client.ObjectKey{Namespace: "greymatter", Name: name}, obj)
+	}
+	settings, control := &corev1.ConfigMap{}, &appsv1.Deployment{}
+
+	// An initial install isn't rolled back, so it doesn't wait for the Deployment
+	writeVersion(1)
+	operatorCUE, mesh, err := cuemodule.LoadAll(i.CueRoot)
+	if !assert.NoError(t, err) {
+		return
+	}
+	i.OperatorCUE = operatorCUE
+	assert.NoError(t, i.ApplyMesh(context.TODO(), nil, mesh))
+
+	// The fake client never rolls out the updated Deployment, so every change is restored
+	writeVersion(2)
+	err = i.ApplyMesh(context.TODO(), mesh, mesh.DeepCopy())
+	assert.Contains(t, fmt.Sprint(err), "Deployment greymatter/control: not rolled out within 1s")
+	if assert.NoError(t, get("settings", settings)) {
+		assert.Equal(t, "1", settings.Data["version"])
+	}
+	if assert.NoError(t, get("control", control)) {
+		assert.Equal(t, "control:1", control.Spec.Template.Spec.Containers[0].Image)
+	}
+	assert.Error(t, get("control-admin", &corev1.Service{}), "created objects are deleted")
+	_, _, failed, failures := i.Sync.CurrentReport().Summary()
+	assert.Equal(t, 1, failed)
+	assert.Contains(t, fmt.Sprint(failures), "rollout Deployment greymatter/control")
+
+	// And the next sync cycle applies them again
+	i.Config.Rollout.Rollback = false
+	assert.NoError(t, i.ApplyMesh(context.TODO(), mesh, mesh.DeepCopy()))
+	if assert.NoError(t, get("control", control)) {
+		assert.Equal(t, "control:2", control.Spec.Template.Spec.Containers[0].Image)
+	}
+	assert.NoError(t, get("control-admin", &corev1.Service{}))
+	// Its rollout is tracked in the background until it times out
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(rolloutStatus.WithLabelValues("Deployment", "greymatter", "control", "failed")) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
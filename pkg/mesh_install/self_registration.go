@@ -0,0 +1,264 @@
+package mesh_install
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/greymatter-io/operator/pkg/k8sapi"
+	"github.com/greymatter-io/operator/pkg/wellknown"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// The namespace and StatefulSet the operator runs as.
+	operatorNamespace   = "gm-operator"
+	operatorStatefulSet = "gm-operator"
+	// The Service the edge reaches the operator's sidecar through.
+	operatorProxyService = "gm-operator-proxy"
+	// The cluster and Catalog service the operator registers itself as, unless config.self_registration.name is set.
+	defaultSelfRegistrationName = "gm-operator"
+	// Annotation of the operator's pod template with what self-registration added to it.
+	selfRegisteredAnnotation = "greymatter.io/self-registered"
+	// The port of a sidecar's proxy if its container doesn't name one "proxy".
+	defaultProxyPort = 10808
+)
+
+// selfRegistration is what self-registration added to the operator's pod template, so it can be replaced when it
+// changes, and removed when self-registration is disabled.
+type selfRegistration struct {
+	// The cluster the operator registered itself as, and the port of the admin API its sidecar sends traffic to
+	Name string `json:"name"`
+	Port int    `json:"port"`
+	// A hash of the name and port and of the sidecar container and volumes added
+	Hash      string   `json:"hash"`
+	Container string   `json:"container"`
+	Volumes   []string `json:"volumes"`
+}
+
+// selfRegistrationName returns the name of the cluster and Catalog service the operator registers itself as.
+func (i *Installer) selfRegistrationName() string {
+	if name := i.Config.SelfRegistration.Name; name != "" {
+		return name
+	}
+	return defaultSelfRegistrationName
+}
+
+// selfRegistrationAnnotations returns the annotations the operator's sidecar is configured from, as if they were on
+// its pod template, describing it in Catalog.
+func (i *Installer) selfRegistrationAnnotations(port int) map[string]string {
+	annotations := map[string]string{
+		wellknown.ANNOTATION_INJECT_SIDECAR_TO_PORT: strconv.Itoa(port),
+		wellknown.ANNOTATION_CATALOG_NAME:           "Grey Matter Operator",
+		wellknown.ANNOTATION_CATALOG_DESCRIPTION:    "The operator's admin API, with its Prometheus metrics at /metrics",
+	}
+	if i.OperatorVersion != "" {
+		annotations[wellknown.ANNOTATION_CATALOG_VERSION] = i.OperatorVersion
+	}
+	return annotations
+}
+
+// registerSelf adds the operator to THE mesh if config.self_registration is enabled, and otherwise removes it from
+// the mesh if it was added. The operator's pods aren't injected by the workload webhook, which they serve, so a
+// sidecar is added to their pod template, rolling them out; the edge reaches it through a Service, since Control
+// doesn't discover pods in the operator's namespace. The sidecar sends traffic to the admin API, which must be served,
+// and require its token for every request but those for metrics (see admin.WithAuthenticatedReads).
+func (i *Installer) registerSelf(ctx context.Context, mesh *v1alpha1.Mesh) {
+	if !i.Config.SelfRegistration.Enabled {
+		i.unregisterSelf(ctx)
+		return
+	}
+	if i.AdminPort == 0 {
+		logger.Error(errors.New("the admin API isn't served over plain HTTP (-adminAddr without -adminTLSCert)"), "Not registering the operator into the mesh")
+		return
+	}
+	if !i.AdminTokenRequired {
+		logger.Error(errors.New("the admin API doesn't require a token for changes (-adminToken)"), "Not registering the operator into the mesh")
+		return
+	}
+	operator := &appsv1.StatefulSet{}
+	getCtx, cancel := k8sapi.WithTimeout(ctx)
+	err := i.K8sClient.Get(getCtx, client.ObjectKey{Namespace: operatorNamespace, Name: operatorStatefulSet}, operator)
+	cancel()
+	if err != nil {
+		logger.Error(err, "Not registering the operator into the mesh", "StatefulSet", operatorStatefulSet)
+		return
+	}
+	name := i.selfRegistrationName()
+
+	container, volumes, err := i.CurrentOperatorCUE().UnifyAndExtractSidecar(name, "")
+	if err != nil {
+		logger.Error(err, "Not registering the operator into the mesh")
+		return
+	}
+	spec := corev1.PodSpec{Volumes: volumes}
+	if i.ServiceAccountIdentity() {
+		serviceAccount := operator.Spec.Template.Spec.ServiceAccountName
+		if serviceAccount == "" {
+			serviceAccount = "default"
+		}
+		secretName, err := i.EnsureWorkloadIdentity(ctx, operatorNamespace, serviceAccount)
+		if err != nil {
+			logger.Error(err, "Not registering the operator into the mesh", "ServiceAccount", serviceAccount)
+			return
+		}
+		InjectIdentity(&spec, &container, secretName)
+	}
+	proxyPort := defaultProxyPort
+	for _, p := range container.Ports {
+		if p.Name == "proxy" {
+			proxyPort = int(p.ContainerPort)
+		}
+	}
+
+	var selector map[string]string
+	if operator.Spec.Selector != nil {
+		selector = operator.Spec.Selector.MatchLabels
+	}
+	service := &corev1.Service{
+		TypeMeta:   metav1.TypeMeta{Kind: "Service", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{Name: operatorProxyService, Namespace: operatorNamespace},
+		Spec: corev1.ServiceSpec{
+			Selector: selector,
+			Ports:    []corev1.ServicePort{{Name: "proxy", Port: int32(proxyPort), TargetPort: intstr.FromInt(proxyPort)}},
+		},
+	}
+	if err := k8sapi.Apply(ctx, i.K8sClient, service, mesh, k8sapi.CreateOrUpdateIfChanged); err != nil {
+		return
+	}
+
+	var previous selfRegistration
+	if raw, ok := operator.Spec.Template.Annotations[selfRegisteredAnnotation]; ok {
+		_ = json.Unmarshal([]byte(raw), &previous)
+	}
+	if previous.Name != "" && previous.Name != name && i.CLI != nil {
+		if err := i.UnconfigureSidecar(i.CurrentOperatorCUE(), previous.Name, i.selfRegistrationAnnotations(previous.Port)); err != nil {
+			logger.Error(err, "Failed to remove the operator's previous sidecar", "Name", previous.Name)
+		}
+	}
+	if i.CLI != nil {
+		host := fmt.Sprintf("%s.%s.svc", operatorProxyService, operatorNamespace)
+		if err := i.ConfigureStaticSidecar(i.CurrentOperatorCUE(), name, i.AdminPort, host, proxyPort, i.selfRegistrationAnnotations(i.AdminPort)); err != nil {
+			logger.Error(err, "Failed to configure the operator's sidecar", "Name", name)
+		}
+	}
+
+	registered := selfRegistration{Name: name, Port: i.AdminPort, Container: container.Name}
+	for _, v := range spec.Volumes {
+		registered.Volumes = append(registered.Volumes, v.Name)
+	}
+	hashed, err := json.Marshal(struct {
+		Registered selfRegistration
+		Container  corev1.Container
+		Volumes    []corev1.Volume
+	}{registered, container, spec.Volumes})
+	if err != nil {
+		logger.Error(err, "Not registering the operator into the mesh")
+		return
+	}
+	sum := sha256.Sum256(hashed)
+	registered.Hash = hex.EncodeToString(sum[:8])
+	if registered.Hash == previous.Hash {
+		return
+	}
+	annotation, _ := json.Marshal(registered)
+
+	logger.Info("Adding a sidecar to the operator's pods, which restarts the operator", "Name", name, "AdminPort", i.AdminPort)
+	err = k8sapi.Apply(ctx, i.K8sClient, operator, nil, k8sapi.MkPatchAction(func(obj client.Object) client.Object {
+		tmpl := &obj.(*appsv1.StatefulSet).Spec.Template
+		removeSelfRegistration(tmpl)
+		tmpl.Spec.Containers = append(tmpl.Spec.Containers, container)
+		tmpl.Spec.Volumes = append(tmpl.Spec.Volumes, spec.Volumes...)
+		if tmpl.Labels == nil {
+			tmpl.Labels = make(map[string]string)
+		}
+		tmpl.Labels[wellknown.LABEL_CLUSTER] = name
+		if tmpl.Annotations == nil {
+			tmpl.Annotations = make(map[string]string)
+		}
+		tmpl.Annotations[selfRegisteredAnnotation] = string(annotation)
+		return obj
+	}))
+	if err != nil {
+		logger.Error(err, "Failed to add a sidecar to the operator's pods")
+	}
+}
+
+// unregisterSelf removes the operator from THE mesh, if registerSelf added it: its sidecar from its pod template,
+// rolling out its pods, along with the sidecar's Service and Grey Matter config.
+func (i *Installer) unregisterSelf(ctx context.Context) {
+	operator := &appsv1.StatefulSet{}
+	getCtx, cancel := k8sapi.WithTimeout(ctx)
+	err := i.K8sClient.Get(getCtx, client.ObjectKey{Namespace: operatorNamespace, Name: operatorStatefulSet}, operator)
+	cancel()
+	if err != nil {
+		return
+	}
+	raw, ok := operator.Spec.Template.Annotations[selfRegisteredAnnotation]
+	if !ok {
+		return
+	}
+	var registered selfRegistration
+	_ = json.Unmarshal([]byte(raw), &registered)
+
+	logger.Info("Removing the operator's sidecar, which restarts the operator", "Name", registered.Name)
+	if i.CLI != nil && registered.Name != "" {
+		if err := i.UnconfigureSidecar(i.CurrentOperatorCUE(), registered.Name, i.selfRegistrationAnnotations(registered.Port)); err != nil {
+			logger.Error(err, "Failed to remove the operator's sidecar", "Name", registered.Name)
+		}
+	}
+	deleteCtx, cancel := k8sapi.WithTimeout(ctx)
+	err = i.K8sClient.Delete(deleteCtx, &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: operatorProxyService, Namespace: operatorNamespace}})
+	cancel()
+	if client.IgnoreNotFound(err) != nil {
+		logger.Error(err, "Failed to delete the operator's sidecar Service", "Service", operatorProxyService)
+	}
+	err = k8sapi.Apply(ctx, i.K8sClient, operator, nil, k8sapi.MkPatchAction(func(obj client.Object) client.Object {
+		removeSelfRegistration(&obj.(*appsv1.StatefulSet).Spec.Template)
+		return obj
+	}))
+	if err != nil {
+		logger.Error(err, "Failed to remove the sidecar from the operator's pods")
+	}
+}
+
+// removeSelfRegistration removes what self-registration added to the operator's pod template, as recorded in its
+// annotation, if anything.
+func removeSelfRegistration(tmpl *corev1.PodTemplateSpec) {
+	raw, ok := tmpl.Annotations[selfRegisteredAnnotation]
+	if !ok {
+		return
+	}
+	var registered selfRegistration
+	_ = json.Unmarshal([]byte(raw), &registered)
+
+	var containers []corev1.Container
+	for _, c := range tmpl.Spec.Containers {
+		if c.Name != registered.Container {
+			containers = append(containers, c)
+		}
+	}
+	tmpl.Spec.Containers = containers
+	added := make(map[string]bool)
+	for _, name := range registered.Volumes {
+		added[name] = true
+	}
+	var volumes []corev1.Volume
+	for _, v := range tmpl.Spec.Volumes {
+		if !added[v.Name] {
+			volumes = append(volumes, v)
+		}
+	}
+	tmpl.Spec.Volumes = volumes
+	delete(tmpl.Labels, wellknown.LABEL_CLUSTER)
+	delete(tmpl.Annotations, selfRegisteredAnnotation)
+}
@@ -0,0 +1,95 @@
+package mesh_install
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	"github.com/greymatter-io/operator/pkg/wellknown"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestRegisterSelf(t *testing.T) {
+	labels := map[string]string{"name": "gm-operator"}
+	i, c := newTestInstaller(t, &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "gm-operator", Namespace: "gm-operator"},
+		Spec: appsv1.StatefulSetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "operator", Image: "gm-operator"}}},
+			},
+		},
+	})
+	assert.NoError(t, os.WriteFile(filepath.Join(i.CueRoot, "k8s/outputs/outputs.cue"), []byte(testCUE["k8s/outputs/outputs.cue"]+`
+sidecar_container: {
+	name:     string
+	_cluster: name
+	container: {name: "sidecar", image: "greymatter/proxy", env: [{name: "XDS_CLUSTER", value: _cluster}], ports: [{name: "proxy", containerPort: 10808}]}
+	volumes: [{name: "spire-socket", emptyDir: {}}]
+}
+`), 0600))
+	operatorCUE, _, err := cuemodule.LoadAll(i.CueRoot)
+	if !assert.NoError(t, err) {
+		return
+	}
+	i.OperatorCUE = operatorCUE
+	i.Config.SelfRegistration.Enabled = true
+	i.AdminPort = 9090
+	i.AdminTokenRequired = true
+	mesh := &v1alpha1.Mesh{ObjectMeta: metav1.ObjectMeta{Name: "mesh", UID: "mesh-uid"}}
+
+	// Not registered without the admin API to send traffic to
+	i.AdminPort = 0
+	i.registerSelf(context.TODO(), mesh)
+	operator := &appsv1.StatefulSet{}
+	assert.NoError(t, c.Get(context.TODO(), client.ObjectKey{Namespace: "gm-operator", Name: "gm-operator"}, operator))
+	assert.Len(t, operator.Spec.Template.Spec.Containers, 1)
+
+	// Nor if changes through it needn't be authenticated, since the edge would expose them
+	i.AdminPort, i.AdminTokenRequired = 9090, false
+	i.registerSelf(context.TODO(), mesh)
+	assert.NoError(t, c.Get(context.TODO(), client.ObjectKey{Namespace: "gm-operator", Name: "gm-operator"}, operator))
+	assert.Len(t, operator.Spec.Template.Spec.Containers, 1)
+
+	i.AdminTokenRequired = true
+	i.registerSelf(context.TODO(), mesh)
+	assert.NoError(t, c.Get(context.TODO(), client.ObjectKey{Namespace: "gm-operator", Name: "gm-operator"}, operator))
+	tmpl := operator.Spec.Template
+	if assert.Len(t, tmpl.Spec.Containers, 2) {
+		assert.Equal(t, "sidecar", tmpl.Spec.Containers[1].Name)
+		assert.Equal(t, "gm-operator", tmpl.Spec.Containers[1].Env[0].Value)
+	}
+	assert.Equal(t, []corev1.Volume{{Name: "spire-socket", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}}, tmpl.Spec.Volumes)
+	assert.Equal(t, "gm-operator", tmpl.Labels[wellknown.LABEL_CLUSTER])
+	assert.Contains(t, tmpl.Annotations[selfRegisteredAnnotation], `"container":"sidecar"`)
+	service := &corev1.Service{}
+	if assert.NoError(t, c.Get(context.TODO(), client.ObjectKey{Namespace: "gm-operator", Name: "gm-operator-proxy"}, service)) {
+		assert.Equal(t, labels, service.Spec.Selector)
+		assert.Equal(t, int32(10808), service.Spec.Ports[0].Port)
+	}
+
+	// Registering again changes nothing, so the operator isn't restarted
+	version := operator.ResourceVersion
+	i.registerSelf(context.TODO(), mesh)
+	assert.NoError(t, c.Get(context.TODO(), client.ObjectKey{Namespace: "gm-operator", Name: "gm-operator"}, operator))
+	assert.Equal(t, version, operator.ResourceVersion)
+
+	// Disabled, what was added is removed
+	i.Config.SelfRegistration.Enabled = false
+	i.registerSelf(context.TODO(), mesh)
+	assert.NoError(t, c.Get(context.TODO(), client.ObjectKey{Namespace: "gm-operator", Name: "gm-operator"}, operator))
+	tmpl = operator.Spec.Template
+	assert.Equal(t, []corev1.Container{{Name: "operator", Image: "gm-operator"}}, tmpl.Spec.Containers)
+	assert.Empty(t, tmpl.Spec.Volumes)
+	assert.Equal(t, labels, tmpl.Labels)
+	assert.NotContains(t, tmpl.Annotations, selfRegisteredAnnotation)
+	assert.Error(t, c.Get(context.TODO(), client.ObjectKey{Namespace: "gm-operator", Name: "gm-operator-proxy"}, service))
+}
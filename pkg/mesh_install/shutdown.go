@@ -0,0 +1,135 @@
+package mesh_install
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errShuttingDown is returned for applies of the mesh refused because the operator is shutting down.
+var errShuttingDown = errors.New("the operator is shutting down")
+
+// How long the applies and sync cycle cut short when the shutdown timeout is up are given to return, so their
+// outcome is saved with the rest of the state.
+const abortGrace = 5 * time.Second
+
+// applyTracker counts the applies and removals of the mesh in flight, so the operator can wait for them to finish
+// when it shuts down, and refuses new ones once it has begun to.
+type applyTracker struct {
+	inFlight int
+	draining bool
+	// Closed once none are in flight while draining
+	idle chan struct{}
+	mu   sync.Mutex
+}
+
+// begin records an apply in flight, returning false if it's refused because the tracker is draining.
+func (t *applyTracker) begin() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.draining {
+		return false
+	}
+	t.inFlight++
+	return true
+}
+
+// end records that an apply begun with begin has finished.
+func (t *applyTracker) end() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.inFlight--
+	if t.draining && t.inFlight == 0 {
+		close(t.idle)
+	}
+}
+
+// drain refuses further applies, returning how many are still in flight, and a channel closed once none are.
+func (t *applyTracker) drain() (int, <-chan struct{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.draining {
+		t.draining = true
+		t.idle = make(chan struct{})
+		if t.inFlight == 0 {
+			close(t.idle)
+		}
+	}
+	return t.inFlight, t.idle
+}
+
+// Shutdown stops the operator's work in an order that leaves nothing half done, within ctx's deadline:
+//
+//  1. No more GitOps sync cycles are started, and applies of the mesh are refused.
+//  2. The sync cycle and applies in flight are waited for. If ctx is done first, they're cut short, and the sync
+//     cycle is left in progress in the state, to be re-run when the operator next starts.
+//  3. The state is flushed to its store.
+//  4. Each mesh's greymatter CLI client is closed once the commands it has queued for Control, and then for Catalog,
+//     have been sent.
+//  5. The state store is closed.
+//
+// It returns what was left unfinished, if anything.
+func (i *Installer) Shutdown(ctx context.Context) error {
+	var problems []string
+	if i.Sync != nil {
+		i.Sync.Stop()
+	}
+	inFlight, idle := i.applies.drain()
+	logger.Info("Shutting down", "AppliesInFlight", inFlight)
+
+	var err error
+	if i.Sync != nil {
+		err = i.Sync.Drain(ctx)
+	}
+	if err == nil {
+		select {
+		case <-idle:
+		case <-ctx.Done():
+			err = fmt.Errorf("applies of the mesh still in flight: %w", ctx.Err())
+		}
+	}
+	i.state.Lock()
+	stopRun := i.stopRun
+	i.state.Unlock()
+	if stopRun != nil {
+		stopRun()
+	}
+	if err != nil {
+		problems = append(problems, err.Error())
+		// Cut short, they return promptly
+		abortCtx, cancel := context.WithTimeout(context.Background(), abortGrace)
+		if i.Sync != nil {
+			_ = i.Sync.Drain(abortCtx)
+		}
+		select {
+		case <-idle:
+		case <-abortCtx.Done():
+		}
+		cancel()
+	}
+
+	if i.Sync != nil && i.Sync.SyncState != nil {
+		if err := i.Sync.SyncState.Flush(ctx); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if i.CLI != nil {
+		if err := i.CLI.Close(ctx); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if i.Sync != nil {
+		if err := i.Sync.Close(); err != nil {
+			problems = append(problems, fmt.Sprintf("failed to close state store: %v", err))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("shut down with work unfinished: %s", strings.Join(problems, "; "))
+	}
+	logger.Info("Shut down cleanly")
+	return nil
+}
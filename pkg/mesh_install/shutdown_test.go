@@ -0,0 +1,55 @@
+package mesh_install
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShutdown(t *testing.T) {
+	i, _ := newTestInstaller(t, startObjects()...)
+	assert.NoError(t, i.Start(context.Background()))
+	run := i.RunContext()
+
+	// An apply in flight is waited for, and the run context outlives the signal to shut down until it's finished
+	assert.True(t, i.applies.begin())
+	finished := make(chan struct{})
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		assert.NoError(t, run.Err(), "the run context is cancelled only once the apply has finished")
+		close(finished)
+		i.applies.end()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, i.Shutdown(ctx))
+	select {
+	case <-finished:
+	default:
+		t.Error("Shutdown returned before the apply in flight finished")
+	}
+	assert.Error(t, run.Err())
+
+	// New applies are refused
+	assert.Equal(t, errShuttingDown, i.ApplyMesh(run, nil, i.CurrentMesh()))
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	i, _ := newTestInstaller(t, startObjects()...)
+	assert.NoError(t, i.Start(context.Background()))
+
+	// An apply that outlasts the timeout is cut short by cancelling the run context
+	assert.True(t, i.applies.begin())
+	go func() {
+		<-i.RunContext().Done()
+		i.applies.end()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := i.Shutdown(ctx)
+	assert.Contains(t, fmt.Sprint(err), "applies of the mesh still in flight")
+	assert.Error(t, i.RunContext().Err())
+}
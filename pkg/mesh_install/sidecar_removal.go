@@ -0,0 +1,135 @@
+package mesh_install
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/greymatter-io/operator/pkg/k8sapi"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// The name of the sidecar removal loop in config.reconcile.disabled.
+	reconcilerSidecarRemoval = "sidecar_removal"
+	// How often workloads are checked for sidecars they no longer ask for, unless config.reconcile.interval_seconds is set.
+	sidecarRemovalPollInterval = time.Minute
+	// Annotation of a workload's pod template with when its sidecar was removed, which rolls out its pods without one.
+	sidecarRemovedAnnotation = "greymatter.io/sidecar-removed"
+)
+
+// reconcileSidecarRemoval periodically removes the sidecars of workloads that no longer ask for one, until the context
+// is cancelled.
+func (i *Installer) reconcileSidecarRemoval(ctx context.Context) {
+	ticker := time.NewTicker(i.Config.Reconcile.Interval(sidecarRemovalPollInterval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := i.removeSidecars(ctx); err != nil {
+			logger.Error(err, "Failed to check workloads for removed sidecars - will retry")
+		}
+	}
+}
+
+// removeSidecars finds the Deployments and StatefulSets in THE mesh's namespaces that have finished rolling out but
+// still run pods with a sidecar their pod template no longer asks for, e.g. because their inject-sidecar-to annotation
+// was removed while the workload webhook wasn't serving, or the mesh's injection policy changed to exclude them.
+// For each, it deletes the sidecar's Grey Matter config and rolls out the workload's pods without one.
+// Workloads still rolling out are left alone, since their old pods are already being replaced.
+func (i *Installer) removeSidecars(ctx context.Context) error {
+	mesh := i.CurrentMesh()
+	if mesh == nil || mesh.UID == "" {
+		return nil
+	}
+	namespaces := meshNamespaces(mesh)
+
+	for _, ns := range namespaces {
+		deployments := &appsv1.DeploymentList{}
+		if err := k8sapi.ListPages(ctx, i.K8sClient, deployments, i.Config.Reconcile.PageSize, func() error {
+			for idx := range deployments.Items {
+				d := &deployments.Items[idx]
+				if deploymentRolledOut(d) && !i.SidecarRequested(ns, &d.Spec.Template) {
+					i.removeSidecar(ctx, "Deployment", d, d.Spec.Selector)
+				}
+			}
+			return nil
+		}, client.InNamespace(ns)); err != nil {
+			return fmt.Errorf("failed to list deployments in namespace %s: %w", ns, err)
+		}
+		statefulsets := &appsv1.StatefulSetList{}
+		if err := k8sapi.ListPages(ctx, i.K8sClient, statefulsets, i.Config.Reconcile.PageSize, func() error {
+			for idx := range statefulsets.Items {
+				s := &statefulsets.Items[idx]
+				if statefulSetRolledOut(s) && !i.SidecarRequested(ns, &s.Spec.Template) {
+					i.removeSidecar(ctx, "StatefulSet", s, s.Spec.Selector)
+				}
+			}
+			return nil
+		}, client.InNamespace(ns)); err != nil {
+			return fmt.Errorf("failed to list statefulsets in namespace %s: %w", ns, err)
+		}
+	}
+	return nil
+}
+
+// removeSidecar deletes the Grey Matter config of a workload's sidecar, as configured from the annotations its pods
+// were created with, and rolls out its pods without the sidecar, if any of its selected pods still run one.
+func (i *Installer) removeSidecar(ctx context.Context, kind string, workload client.Object, selector *metav1.LabelSelector) {
+	sel, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return
+	}
+	pods := &corev1.PodList{}
+	if err := i.K8sClient.List(ctx, pods, client.InNamespace(workload.GetNamespace()), client.MatchingLabelsSelector{Selector: sel}); err != nil {
+		logger.Error(err, "Failed to list pods to check for removed sidecars", "Namespace", workload.GetNamespace(), "Name", workload.GetName())
+		return
+	}
+	var injected *corev1.Pod
+	for idx := range pods.Items {
+		if pod := &pods.Items[idx]; pod.DeletionTimestamp.IsZero() && hasSidecar(pod) {
+			injected = pod
+			break
+		}
+	}
+	if injected == nil {
+		return
+	}
+
+	logger.Info("Removing sidecar the workload no longer asks for", "Kind", kind, "Namespace", workload.GetNamespace(), "Name", workload.GetName(), "Pod", injected.Name)
+	if i.CLI != nil {
+		if err := i.UnconfigureSidecar(i.CurrentOperatorCUE(), workload.GetName(), injected.Annotations); err != nil {
+			logger.Error(err, "Failed to remove sidecar configuration", "Kind", kind, "Namespace", workload.GetNamespace(), "Name", workload.GetName())
+		}
+	}
+
+	// Pods get sidecars when they're created, so they're replaced to remove them
+	removed := time.Now().UTC().Format(time.RFC3339)
+	err = k8sapi.Apply(ctx, i.K8sClient, workload, nil, k8sapi.MkPatchAction(func(obj client.Object) client.Object {
+		var tmpl *corev1.PodTemplateSpec
+		switch w := obj.(type) {
+		case *appsv1.Deployment:
+			tmpl = &w.Spec.Template
+		case *appsv1.StatefulSet:
+			tmpl = &w.Spec.Template
+		default:
+			return obj
+		}
+		if tmpl.Annotations == nil {
+			tmpl.Annotations = make(map[string]string)
+		}
+		tmpl.Annotations[sidecarRemovedAnnotation] = removed
+		return obj
+	}))
+	if err != nil {
+		logger.Error(err, "Failed to roll out workload without its sidecar", "Kind", kind, "Namespace", workload.GetNamespace(), "Name", workload.GetName())
+		return
+	}
+	logger.Info("Rolling out workload without its sidecar", "Kind", kind, "Namespace", workload.GetNamespace(), "Name", workload.GetName())
+}
@@ -0,0 +1,75 @@
+package mesh_install
+
+import (
+	"context"
+	"testing"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/greymatter-io/operator/pkg/wellknown"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestRemoveSidecars(t *testing.T) {
+	deployment := func(name string, annotations map[string]string, rolledOut bool) *appsv1.Deployment {
+		d := &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "apps"},
+			Spec: appsv1.DeploymentSpec{
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": name}},
+				Template: corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{
+					Labels:      map[string]string{"app": name},
+					Annotations: annotations,
+				}},
+			},
+		}
+		if rolledOut {
+			d.Status = appsv1.DeploymentStatus{Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1}
+		}
+		return d
+	}
+	pod := func(name, app string, sidecar bool) *corev1.Pod {
+		p := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "apps", Labels: map[string]string{"app": app, wellknown.LABEL_CLUSTER: app},
+				Annotations: map[string]string{wellknown.ANNOTATION_INJECT_SIDECAR_TO_PORT: "8080"}},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+		}
+		if sidecar {
+			p.Spec.Containers = append(p.Spec.Containers, corev1.Container{Name: "sidecar", Ports: []corev1.ContainerPort{{Name: "proxy", ContainerPort: 10808}}})
+		}
+		return p
+	}
+	injected := map[string]string{wellknown.ANNOTATION_INJECT_SIDECAR_TO_PORT: "8080"}
+
+	i, c := newTestInstaller(t,
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps"}},
+		deployment("removed", nil, true), pod("removed-1", "removed", true),
+		deployment("rolling", nil, false), pod("rolling-1", "rolling", true),
+		deployment("injected", injected, true), pod("injected-1", "injected", true),
+		deployment("plain", nil, true), pod("plain-1", "plain", false),
+	)
+	i.Mesh = &v1alpha1.Mesh{
+		ObjectMeta: metav1.ObjectMeta{Name: "mesh-sample", UID: "1234"},
+		Spec:       v1alpha1.MeshSpec{InstallNamespace: "greymatter", WatchNamespaces: []string{"apps"}},
+	}
+
+	assert.NoError(t, i.removeSidecars(context.TODO()))
+	restarted := func(name string) bool {
+		d := &appsv1.Deployment{}
+		assert.NoError(t, c.Get(context.TODO(), client.ObjectKey{Namespace: "apps", Name: name}, d))
+		_, ok := d.Spec.Template.Annotations[sidecarRemovedAnnotation]
+		return ok
+	}
+	assert.True(t, restarted("removed"))
+	// Its old pods are already being replaced
+	assert.False(t, restarted("rolling"))
+	assert.False(t, restarted("injected"))
+	assert.False(t, restarted("plain"))
+
+	// Excluded by the injection policy
+	i.Mesh.Spec.Injection = &v1alpha1.InjectionPolicy{ExcludedNamespaces: []string{"apps"}}
+	assert.NoError(t, i.removeSidecars(context.TODO()))
+	assert.True(t, restarted("injected"))
+}
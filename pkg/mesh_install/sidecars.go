@@ -0,0 +1,181 @@
+package mesh_install
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	"github.com/greymatter-io/operator/pkg/gitops"
+	"github.com/greymatter-io/operator/pkg/gmapi"
+	"github.com/greymatter-io/operator/pkg/k8sapi"
+	"github.com/greymatter-io/operator/pkg/wellknown"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// The name of the sidecar list loop in config.reconcile.disabled.
+	reconcilerSidecarList = "sidecar_list"
+	// How often the sidecars in the environment are listed, unless config.reconcile.interval_seconds is set.
+	sidecarListPollInterval = 10 * time.Second
+	// How long a changed sidecar list must stay the same before the Redis listener is updated.
+	sidecarListDebounce = 30 * time.Second
+)
+
+// reconcileSidecarListForRedisIngress periodically reconciles the sidecars in the mesh's namespaces
+// with the subjects allowed by the Redis listener, until the context or the mesh client is cancelled.
+func (i *Installer) reconcileSidecarListForRedisIngress(ctx context.Context) {
+	var debouncer sidecarListDebouncer
+	ticker := time.NewTicker(i.Config.Reconcile.Interval(sidecarListPollInterval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if i.Client != nil {
+			select {
+			case <-i.Client.Ctx.Done():
+				logger.Info("greymatter client context cancelled - stopping reconciliation loop")
+				return
+			default:
+			}
+		}
+		i.reconcileSidecarList(ctx, &debouncer, time.Now())
+	}
+}
+
+func (i *Installer) reconcileSidecarList(ctx context.Context, debouncer *sidecarListDebouncer, now time.Time) {
+	mesh := i.CurrentMesh()
+	sidecarList, err := i.listSidecars(ctx, mesh)
+	if err != nil {
+		// Acting on a partial view would drop sidecars from the listener, so wait for the next cycle.
+		logger.Error(err, "Failed to list sidecars for Redis ingress - will retry")
+		return
+	}
+
+	// Compared as a sorted copy, since the current list is shared with other readers
+	current := append([]string(nil), i.CurrentDefaults().SidecarList...)
+	sort.Strings(current)
+	if len(sidecarList) == 0 || reflect.DeepEqual(sidecarList, current) {
+		debouncer.reset()
+		return
+	}
+	if !debouncer.settled(sidecarList, now, sidecarListDebounce) {
+		return
+	}
+	debouncer.reset()
+
+	logger.Info("The list of sidecars in the environment has changed. Updating Redis ingress for health checks.", "Updated List", sidecarList)
+	i.updateDefaults(func(defaults *cuemodule.Defaults) {
+		defaults.SidecarList = sidecarList
+	})
+	i.Sync.SyncState.UpdateDerivedDefaults(func(derived *gitops.DerivedDefaults) bool {
+		derived.SidecarList = sidecarList
+		return true
+	})
+
+	tempOperatorCUE, err := i.CurrentOperatorCUE().TempGMValueUnifiedWithDefaults(i.CurrentDefaults())
+	if err != nil {
+		logger.Error(err,
+			"error attempting to unify mesh after sidecarList update - this should never happen - check Mesh integrity",
+			"Mesh", mesh)
+		return
+	}
+	redisListener, err := tempOperatorCUE.ExtractRedisListener()
+	if err != nil {
+		logger.Error(err,
+			"error extracting redis_listener from CUE - ignoring",
+			"Mesh", mesh)
+		return
+	}
+	if i.Client != nil {
+		i.Client.ControlCmds <- gmapi.MkApply("listener", redisListener)
+	}
+}
+
+// listSidecars returns the sorted cluster names of sidecars across all of the mesh's namespaces.
+// It fails if any namespace can't be listed, rather than returning a partial list.
+func (i *Installer) listSidecars(ctx context.Context, mesh *v1alpha1.Mesh) ([]string, error) {
+	sidecarSet := make(map[string]struct{})
+	for _, ns := range meshNamespaces(mesh) {
+		// Only pods labeled for the mesh can have a sidecar
+		podList := &corev1.PodList{}
+		if err := k8sapi.ListPages(ctx, i.K8sClient, podList, i.Config.Reconcile.PageSize, func() error {
+			for _, name := range sidecarClusterNames(podList.Items) {
+				sidecarSet[name] = struct{}{}
+			}
+			return nil
+		}, client.InNamespace(ns), client.HasLabels{wellknown.LABEL_CLUSTER}); err != nil {
+			return nil, fmt.Errorf("failed to list pods in namespace %s: %w", ns, err)
+		}
+	}
+
+	sidecarList := make([]string, 0, len(sidecarSet))
+	for name := range sidecarSet {
+		sidecarList = append(sidecarList, name)
+	}
+	sort.Strings(sidecarList)
+	return sidecarList, nil
+}
+
+// sidecarClusterNames returns the sorted, de-duplicated cluster labels of pods with a sidecar.
+// Terminating pods are left out, so a removed sidecar leaves the list as soon as its pods are being replaced.
+func sidecarClusterNames(pods []corev1.Pod) []string {
+	sidecarSet := make(map[string]struct{})
+	for idx := range pods {
+		pod := &pods[idx]
+		clusterName, ok := pod.Labels[wellknown.LABEL_CLUSTER]
+		if !ok || !pod.DeletionTimestamp.IsZero() {
+			continue
+		}
+		if hasSidecar(pod) {
+			sidecarSet[clusterName] = struct{}{}
+		}
+	}
+
+	sidecarList := make([]string, 0, len(sidecarSet))
+	for name := range sidecarSet {
+		sidecarList = append(sidecarList, name)
+	}
+	sort.Strings(sidecarList)
+	return sidecarList
+}
+
+// hasSidecar reports whether a pod has an injected sidecar (assumed to be a container with a "proxy" port).
+func hasSidecar(pod *corev1.Pod) bool {
+	for _, container := range pod.Spec.Containers {
+		for _, p := range container.Ports {
+			// TODO don't hard-code the port name, pull it from the CUE
+			if p.Name == "proxy" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sidecarListDebouncer holds back a changed sidecar list until it has been observed unchanged for a while,
+// so pods rolling through a deployment don't cause a listener update on every poll.
+type sidecarListDebouncer struct {
+	pending []string
+	since   time.Time
+}
+
+// settled records an observed list, and reports whether it has been the same for at least wait.
+func (d *sidecarListDebouncer) settled(sidecarList []string, now time.Time, wait time.Duration) bool {
+	if d.pending == nil || !reflect.DeepEqual(sidecarList, d.pending) {
+		d.pending = sidecarList
+		d.since = now
+	}
+	return now.Sub(d.since) >= wait
+}
+
+func (d *sidecarListDebouncer) reset() {
+	d.pending = nil
+}
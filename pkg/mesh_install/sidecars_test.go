@@ -0,0 +1,103 @@
+package mesh_install
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/greymatter-io/operator/pkg/gitops"
+	"github.com/greymatter-io/operator/pkg/wellknown"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSidecarClusterNames(t *testing.T) {
+	withSidecar := func(cluster string) corev1.Pod {
+		return corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{wellknown.LABEL_CLUSTER: cluster}},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{
+				{Name: "app"},
+				{Name: "sidecar", Ports: []corev1.ContainerPort{{Name: "proxy", ContainerPort: 10808}}},
+			}},
+		}
+	}
+	noSidecar := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{wellknown.LABEL_CLUSTER: "plain"}},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+	}
+	unlabeled := withSidecar("")
+	unlabeled.Labels = nil
+	terminating := withSidecar("removed")
+	terminating.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+
+	names := sidecarClusterNames([]corev1.Pod{withSidecar("web"), withSidecar("api"), withSidecar("web"), noSidecar, unlabeled, terminating})
+	assert.Equal(t, []string{"api", "web"}, names)
+}
+
+func TestSidecarListDebouncer(t *testing.T) {
+	var d sidecarListDebouncer
+	start := time.Now()
+	wait := 30 * time.Second
+
+	assert.False(t, d.settled([]string{"a"}, start, wait))
+	assert.False(t, d.settled([]string{"a"}, start.Add(10*time.Second), wait))
+	// A further change restarts the wait
+	assert.False(t, d.settled([]string{"a", "b"}, start.Add(20*time.Second), wait))
+	assert.False(t, d.settled([]string{"a", "b"}, start.Add(40*time.Second), wait))
+	assert.True(t, d.settled([]string{"a", "b"}, start.Add(50*time.Second), wait))
+
+	d.reset()
+	assert.False(t, d.settled([]string{"a", "b"}, start.Add(60*time.Second), wait))
+}
+
+func TestReconcileSidecarListWhileRead(t *testing.T) {
+	i, _ := newTestInstaller(t, &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "greymatter", Labels: map[string]string{wellknown.LABEL_CLUSTER: "web"}},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "sidecar", Ports: []corev1.ContainerPort{{Name: "proxy"}}}}},
+	})
+	i.Sync.SyncState = &gitops.SyncState{}
+	i.Defaults.SidecarList = []string{"web", "edge"}
+	snapshot := i.CurrentDefaults()
+
+	// Readers of the defaults and mesh never see them change underneath them (checked by go test -race)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			_ = append([]string(nil), i.CurrentDefaults().SidecarList...)
+			_ = i.CurrentMesh().Spec.InstallNamespace
+		}
+	}()
+	var debouncer sidecarListDebouncer
+	start := time.Now()
+	i.reconcileSidecarList(context.TODO(), &debouncer, start)
+	i.reconcileSidecarList(context.TODO(), &debouncer, start.Add(sidecarListDebounce))
+	close(stop)
+	wg.Wait()
+
+	assert.Equal(t, []string{"web"}, i.CurrentDefaults().SidecarList)
+	// The earlier snapshot's list is neither sorted nor replaced in place
+	assert.Equal(t, []string{"web", "edge"}, snapshot.SidecarList)
+}
+
+func TestSetMeshConditionReplacesMesh(t *testing.T) {
+	i, c := newTestInstaller(t)
+	i.Mesh.UID = "mesh-uid"
+	assert.NoError(t, c.Create(context.TODO(), i.Mesh))
+	snapshot := i.CurrentMesh()
+
+	i.setMeshCondition(v1alpha1.ConditionRolledOut, metav1.ConditionTrue, "RolledOut", "All workloads rolled out")
+	assert.Empty(t, snapshot.Status.Conditions, "a snapshot isn't changed in place")
+	assert.NotNil(t, meta.FindStatusCondition(i.CurrentMesh().Status.Conditions, v1alpha1.ConditionRolledOut))
+}
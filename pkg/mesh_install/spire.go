@@ -0,0 +1,287 @@
+package mesh_install
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	"github.com/greymatter-io/operator/pkg/gitops"
+	"github.com/greymatter-io/operator/pkg/k8sapi"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// The namespace SPIRE is installed in.
+	spireNamespace = "spire"
+	// The ConfigMap the SPIRE server publishes its trust bundle to, and the name of its copies in mesh namespaces.
+	spireBundleConfigMapName = "spire-bundle"
+	spireBundleKey           = "bundle.crt"
+	// The name of the SPIRE loop in config.reconcile.disabled.
+	reconcilerSpire = "spire"
+	// How often SPIRE is reconciled, unless config.reconcile.interval_seconds is set.
+	spireReconcileInterval = 10 * time.Second
+)
+
+// spireState is what the SPIRE loop last applied, so unchanged manifests and bundles aren't rewritten every cycle.
+type spireState struct {
+	// Manifest hashes by gitops.K8sObjectRef key
+	applied map[string]uint64
+	// Trust bundles by namespace
+	published map[string]string
+}
+
+// installSpire creates the spire namespace and the server-ca secret holding SPIRE's intermediate CA
+// (or an ExternalSecret that has it pulled from an external store).
+// Unless SPIRE is external, it then keeps the SPIRE server and agents applied from the CUE and
+// publishes the trust bundle to mesh namespaces until ctx is done.
+func (i *Installer) installSpire(ctx context.Context) error {
+	external := i.Config.SpireInstall.External
+	if !external {
+		namespace := &corev1.Namespace{
+			TypeMeta:   metav1.TypeMeta{Kind: "Namespace", APIVersion: "v1"},
+			ObjectMeta: metav1.ObjectMeta{Name: spireNamespace},
+		}
+		k8sapi.Apply(ctx, i.K8sClient, namespace, i.owner, k8sapi.GetOrCreate)
+	}
+
+	// Have SPIRE's intermediate CA pulled from an external store instead, if configured
+	if i.Config.KeyDelivery.Mode == cuemodule.KeyDeliveryExternalSecret {
+		externalSecret, err := mkSpireCAExternalSecret(i.Config.KeyDelivery)
+		if err != nil {
+			logger.Error(err, "Error while attempting to apply spire server-ca external secret")
+			return err
+		}
+		k8sapi.Apply(ctx, i.K8sClient, externalSecret, i.owner, k8sapi.CreateOrUpdate)
+	} else {
+		if err := i.applySpireCASecret(ctx); err != nil {
+			return err
+		}
+	}
+
+	if !external && i.Config.Reconcile.Enabled(reconcilerSpire) {
+		go i.reconcileSpire(ctx)
+	}
+	return nil
+}
+
+// applySpireCASecret generates SPIRE's intermediate CA and writes it to the server-ca secret.
+func (i *Installer) applySpireCASecret(ctx context.Context) error {
+	logger.Info("Attempting to apply spire server-ca secret")
+	spireSecret := &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Secret",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "server-ca",
+			Namespace: spireNamespace,
+		},
+	}
+	spireSecret, err := injectGeneratedCertificates(spireSecret, i.cfssl)
+	if err != nil {
+		logger.Error(err, "Error while attempting to apply spire server-ca secret", "secret object", spireSecret)
+		return err
+	}
+	if err := i.applyKeySecret(ctx, spireSecret, i.owner); err != nil {
+		logger.Error(err, "Error while attempting to apply spire server-ca secret")
+	}
+	return nil
+}
+
+// reconcileSpire applies SPIRE immediately and then periodically, until the context is cancelled.
+func (i *Installer) reconcileSpire(ctx context.Context) {
+	state := &spireState{applied: make(map[string]uint64), published: make(map[string]string)}
+	ticker := time.NewTicker(i.Config.Reconcile.Interval(spireReconcileInterval))
+	defer ticker.Stop()
+	for {
+		i.applySpire(ctx, state)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (i *Installer) applySpire(ctx context.Context, state *spireState) {
+	i.RLock()
+	defer i.RUnlock()
+
+	manifests, err := i.CurrentOperatorCUE().ExtractSpireK8sManifests()
+	if err != nil {
+		logger.Error(err, "failed to extract SPIRE manifests")
+		return
+	}
+	configureSpireDatastore(manifests, i.Config.SpireInstall.DatastoreSecret)
+
+	var servers, agents []client.Object
+	for _, manifest := range state.changed(manifests) {
+		if _, ok := manifest.(*appsv1.DaemonSet); ok {
+			agents = append(agents, manifest)
+		} else {
+			servers = append(servers, manifest)
+		}
+	}
+	i.applySpireManifests(ctx, state, servers)
+
+	// Agents may be older than the server they connect to but not newer, so during an upgrade
+	// they're only updated once the server has rolled out.
+	if len(agents) > 0 {
+		if i.spireServerRolledOut(ctx, manifests) {
+			i.applySpireManifests(ctx, state, agents)
+		} else {
+			logger.Info("Waiting for the SPIRE server to roll out before updating agents")
+		}
+	}
+
+	extra := i.Config.SpireInstall.TrustBundleNamespaces
+	if i.Config.SelfRegistration.Enabled {
+		// For the operator's own sidecar
+		extra = append(append([]string{}, extra...), operatorNamespace)
+	}
+	i.publishSpireTrustBundle(ctx, state, spireTrustBundleNamespaces(i.CurrentMesh(), extra))
+}
+
+// changed returns the manifests that differ from those last applied.
+func (state *spireState) changed(manifests []client.Object) []client.Object {
+	var changed []client.Object
+	for _, manifest := range manifests {
+		ref := gitops.NewK8sObjectRef(manifest)
+		if hash, ok := state.applied[ref.HashKey()]; !ok || hash != ref.Hash {
+			changed = append(changed, manifest)
+		}
+	}
+	return changed
+}
+
+func (i *Installer) applySpireManifests(ctx context.Context, state *spireState, manifests []client.Object) {
+	for _, manifest := range manifests {
+		ref := gitops.NewK8sObjectRef(manifest) // before Apply sets an owner reference
+		if err := k8sapi.Apply(ctx, i.K8sClient, manifest, i.owner, k8sapi.CreateOrUpdate); err != nil {
+			continue // retried next cycle
+		}
+		state.applied[ref.HashKey()] = ref.Hash
+	}
+}
+
+// spireServerRolledOut reports whether every SPIRE server StatefulSet among the manifests has finished rolling out.
+func (i *Installer) spireServerRolledOut(ctx context.Context, manifests []client.Object) bool {
+	for _, manifest := range manifests {
+		if _, ok := manifest.(*appsv1.StatefulSet); !ok {
+			continue
+		}
+		live := &appsv1.StatefulSet{}
+		getCtx, cancel := k8sapi.WithTimeout(ctx)
+		err := i.K8sClient.Get(getCtx, client.ObjectKeyFromObject(manifest), live)
+		cancel()
+		if err != nil {
+			logger.Error(err, "Failed to get SPIRE server", "Name", manifest.GetName())
+			return false
+		}
+		if !statefulSetRolledOut(live) {
+			return false
+		}
+	}
+	return true
+}
+
+// statefulSetRolledOut reports whether a StatefulSet's latest spec has been observed and all of its replicas are
+// updated and ready.
+func statefulSetRolledOut(sts *appsv1.StatefulSet) bool {
+	replicas := int32(1)
+	if sts.Spec.Replicas != nil {
+		replicas = *sts.Spec.Replicas
+	}
+	return sts.Status.ObservedGeneration >= sts.Generation &&
+		sts.Status.UpdatedReplicas == replicas &&
+		sts.Status.ReadyReplicas == replicas
+}
+
+// configureSpireDatastore sets the keys of the given Secret as environment variables in each SPIRE server container,
+// and has the server expand them in its config, so datastore credentials needn't be written into the CUE.
+func configureSpireDatastore(manifests []client.Object, secretName string) {
+	if secretName == "" {
+		return
+	}
+	for _, manifest := range manifests {
+		sts, ok := manifest.(*appsv1.StatefulSet)
+		if !ok {
+			continue
+		}
+		for idx := range sts.Spec.Template.Spec.Containers {
+			container := &sts.Spec.Template.Spec.Containers[idx]
+			if !strings.Contains(container.Image, "spire-server") {
+				continue
+			}
+			container.EnvFrom = append(container.EnvFrom, corev1.EnvFromSource{
+				SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: secretName}},
+			})
+			expandEnv := false
+			for _, arg := range container.Args {
+				if arg == "-expandEnv" {
+					expandEnv = true
+				}
+			}
+			if !expandEnv {
+				container.Args = append(container.Args, "-expandEnv")
+			}
+		}
+	}
+}
+
+// spireTrustBundleNamespaces returns the sorted namespaces the trust bundle is published to:
+// the mesh's install and watched namespaces and any configured extras.
+func spireTrustBundleNamespaces(mesh *v1alpha1.Mesh, extra []string) []string {
+	set := make(map[string]struct{})
+	for _, ns := range append(append([]string{mesh.Spec.InstallNamespace}, mesh.Spec.WatchNamespaces...), extra...) {
+		if ns != "" && ns != spireNamespace {
+			set[ns] = struct{}{}
+		}
+	}
+	namespaces := make([]string, 0, len(set))
+	for ns := range set {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
+
+// publishSpireTrustBundle copies the trust bundle published by the SPIRE server into each namespace where it changed.
+func (i *Installer) publishSpireTrustBundle(ctx context.Context, state *spireState, namespaces []string) {
+	source := &corev1.ConfigMap{}
+	key := client.ObjectKey{Name: spireBundleConfigMapName, Namespace: spireNamespace}
+	getCtx, cancel := k8sapi.WithTimeout(ctx)
+	defer cancel()
+	if err := i.K8sClient.Get(getCtx, key, source); err != nil {
+		if !errors.IsNotFound(err) {
+			logger.Error(err, "Failed to get SPIRE trust bundle")
+		}
+		return // not yet published by the SPIRE server
+	}
+	bundle := source.Data[spireBundleKey]
+	if bundle == "" {
+		return
+	}
+
+	for _, ns := range namespaces {
+		if state.published[ns] == bundle {
+			continue
+		}
+		cm := &corev1.ConfigMap{
+			TypeMeta:   metav1.TypeMeta{Kind: "ConfigMap", APIVersion: "v1"},
+			ObjectMeta: metav1.ObjectMeta{Name: spireBundleConfigMapName, Namespace: ns},
+			Data:       map[string]string{spireBundleKey: bundle},
+		}
+		if err := k8sapi.Apply(ctx, i.K8sClient, cm, nil, k8sapi.CreateOrUpdate); err != nil {
+			continue
+		}
+		state.published[ns] = bundle
+	}
+}
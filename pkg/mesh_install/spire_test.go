@@ -0,0 +1,97 @@
+package mesh_install
+
+import (
+	"testing"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestStatefulSetRolledOut(t *testing.T) {
+	three := int32(3)
+	cases := map[string]struct {
+		replicas   *int32
+		generation int64
+		status     appsv1.StatefulSetStatus
+		rolledOut  bool
+	}{
+		"rolled out": {
+			replicas:   &three,
+			generation: 2,
+			status:     appsv1.StatefulSetStatus{ObservedGeneration: 2, UpdatedReplicas: 3, ReadyReplicas: 3},
+			rolledOut:  true,
+		},
+		"default of one replica": {
+			generation: 1,
+			status:     appsv1.StatefulSetStatus{ObservedGeneration: 1, UpdatedReplicas: 1, ReadyReplicas: 1},
+			rolledOut:  true,
+		},
+		"spec not yet observed": {
+			replicas:   &three,
+			generation: 3,
+			status:     appsv1.StatefulSetStatus{ObservedGeneration: 2, UpdatedReplicas: 3, ReadyReplicas: 3},
+		},
+		"replicas still updating": {
+			replicas:   &three,
+			generation: 2,
+			status:     appsv1.StatefulSetStatus{ObservedGeneration: 2, UpdatedReplicas: 2, ReadyReplicas: 3},
+		},
+		"replicas not ready": {
+			replicas:   &three,
+			generation: 2,
+			status:     appsv1.StatefulSetStatus{ObservedGeneration: 2, UpdatedReplicas: 3, ReadyReplicas: 1},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			sts := &appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{Generation: tc.generation},
+				Spec:       appsv1.StatefulSetSpec{Replicas: tc.replicas},
+				Status:     tc.status,
+			}
+			assert.Equal(t, tc.rolledOut, statefulSetRolledOut(sts))
+		})
+	}
+}
+
+func TestConfigureSpireDatastore(t *testing.T) {
+	server := &appsv1.StatefulSet{
+		Spec: appsv1.StatefulSetSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{
+			{Name: "server", Image: "gcr.io/spiffe-io/spire-server:1.2.0", Args: []string{"-config", "/run/spire/config/server.conf"}},
+			{Name: "registrar", Image: "gcr.io/spiffe-io/k8s-workload-registrar:1.2.0"},
+		}}}},
+	}
+	agent := &appsv1.DaemonSet{
+		Spec: appsv1.DaemonSetSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{
+			{Name: "agent", Image: "gcr.io/spiffe-io/spire-agent:1.2.0"},
+		}}}},
+	}
+	manifests := []client.Object{server, agent}
+
+	configureSpireDatastore(manifests, "")
+	assert.Empty(t, server.Spec.Template.Spec.Containers[0].EnvFrom)
+
+	configureSpireDatastore(manifests, "spire-datastore")
+
+	serverContainer := server.Spec.Template.Spec.Containers[0]
+	assert.Equal(t, []string{"-config", "/run/spire/config/server.conf", "-expandEnv"}, serverContainer.Args)
+	if assert.Len(t, serverContainer.EnvFrom, 1) {
+		assert.Equal(t, "spire-datastore", serverContainer.EnvFrom[0].SecretRef.Name)
+	}
+	assert.Empty(t, server.Spec.Template.Spec.Containers[1].EnvFrom)
+	assert.Empty(t, agent.Spec.Template.Spec.Containers[0].EnvFrom)
+}
+
+func TestSpireTrustBundleNamespaces(t *testing.T) {
+	mesh := &v1alpha1.Mesh{Spec: v1alpha1.MeshSpec{
+		InstallNamespace: "greymatter",
+		WatchNamespaces:  []string{"apps", "greymatter", "spire"},
+	}}
+	assert.Equal(t, []string{"apps", "greymatter", "observability"},
+		spireTrustBundleNamespaces(mesh, []string{"observability", ""}))
+}
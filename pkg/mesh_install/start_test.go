@@ -0,0 +1,83 @@
+package mesh_install
+
+import (
+	"context"
+	"testing"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/greymatter-io/operator/pkg/wellknown"
+	configv1 "github.com/openshift/api/config/v1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestStart(t *testing.T) {
+	ingress := &configv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: "cluster"}, Spec: configv1.IngressSpec{Domain: "apps.example.com"}}
+	i, c := newTestInstaller(t, append(startObjects(), ingress)...)
+	assert.NoError(t, i.Start(context.Background()))
+	// Cluster-scoped resources are owned by an OperatorInstallation, created with its cleanup finalizer
+	installation := &v1alpha1.OperatorInstallation{}
+	assert.NoError(t, c.Get(context.TODO(), client.ObjectKey{Name: "gm-operator"}, installation))
+	assert.Equal(t, []string{wellknown.FINALIZER_CLEANUP}, installation.Finalizers)
+	assert.Equal(t, v1alpha1.CleanupDelete, installation.Spec.CleanupPolicy)
+	assert.Equal(t, "gm-operator", i.owner.Name)
+	assert.Equal(t, corev1.SecretTypeDockerConfigJson, i.imagePullSecret.Type)
+	assert.Empty(t, i.imagePullSecret.Namespace)
+	assert.Equal(t, openshiftRouter{domain: "apps.example.com"}, i.ingress)
+	assert.NotNil(t, i.Sync.OnSyncCompleted)
+	assert.NotNil(t, i.Sync.OnSyncRejected)
+}
+
+func TestSetImagePullSecret(t *testing.T) {
+	i, c := newTestInstaller(t, startObjects()...)
+	assert.NoError(t, i.Start(context.Background()))
+	i.Config.AutoCopyImagePullSecret = true
+	i.Mesh.Spec.InstallNamespace = "greymatter"
+	i.Mesh.Spec.WatchNamespaces = []string{"apps"}
+
+	rotated := &corev1.Secret{Type: corev1.SecretTypeDockerConfigJson, Data: map[string][]byte{corev1.DockerConfigJsonKey: []byte("rotated")}}
+	i.SetImagePullSecret(rotated)
+	for _, ns := range []string{"greymatter", "apps"} {
+		copied := &corev1.Secret{}
+		assert.NoError(t, c.Get(context.TODO(), client.ObjectKey{Namespace: ns, Name: "gm-docker-secret"}, copied), ns)
+		assert.Equal(t, []byte("rotated"), copied.Data[corev1.DockerConfigJsonKey], ns)
+	}
+}
+
+func TestConfigureGitOps(t *testing.T) {
+	i, c := newTestInstaller(t, startObjects()...)
+	i.Mesh.UID = "mesh-uid"
+	i.Mesh.Spec.GitOps = &v1alpha1.GitOpsSource{
+		Remote:            "git@github.com:greymatter-io/gitops-core.git",
+		Branch:            "release",
+		CredentialsSecret: "gitops-core-credentials",
+	}
+	assert.NoError(t, c.Create(context.TODO(), i.Mesh))
+	condition := func() *metav1.Condition {
+		live := &v1alpha1.Mesh{}
+		assert.NoError(t, c.Get(context.TODO(), client.ObjectKeyFromObject(i.Mesh), live))
+		return meta.FindStatusCondition(live.Status.Conditions, v1alpha1.ConditionGitOpsSourceReady)
+	}
+
+	// The source isn't switched until its credentials are available
+	i.configureGitOps(context.TODO(), i.Mesh)
+	if cond := condition(); assert.NotNil(t, cond) {
+		assert.Equal(t, "CredentialsUnavailable", cond.Reason)
+	}
+	assert.Empty(t, i.Sync.Source().Remote)
+
+	assert.NoError(t, c.Create(context.TODO(), &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "gitops-core-credentials", Namespace: "gm-operator"},
+		Data:       map[string][]byte{"token": []byte("token")},
+	}))
+	i.configureGitOps(context.TODO(), i.Mesh)
+	if cond := condition(); assert.NotNil(t, cond) {
+		assert.Equal(t, metav1.ConditionTrue, cond.Status)
+		assert.Equal(t, "SourceConfigured", cond.Reason)
+	}
+	assert.Equal(t, "git@github.com:greymatter-io/gitops-core.git", i.Sync.Source().Remote)
+	assert.Equal(t, "release", i.Sync.Source().Branch)
+}
@@ -0,0 +1,91 @@
+package mesh_install
+
+import (
+	"reflect"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/greymatter-io/operator/pkg/k8sapi"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// setMeshCondition records a condition on the status of THE mesh managed by the operator
+// and emits a matching event on it. It is a no-op (beyond logging) if the mesh has not
+// yet been applied to the cluster.
+func (i *Installer) setMeshCondition(condType string, status metav1.ConditionStatus, reason, message string) {
+	mesh := i.CurrentMesh()
+	if mesh == nil || mesh.UID == "" {
+		logger.Info("Mesh not yet applied; not recording condition", "Type", condType, "Reason", reason, "Message", message)
+		return
+	}
+
+	eventType := corev1.EventTypeNormal
+	if status == metav1.ConditionFalse {
+		eventType = corev1.EventTypeWarning
+	}
+	if i.recorder != nil {
+		i.recorder.Event(mesh, eventType, reason, message)
+	}
+
+	i.updateMeshStatus(mesh, "Condition", condType, func(live *v1alpha1.Mesh) bool {
+		if existing := meta.FindStatusCondition(live.Status.Conditions, condType); existing != nil &&
+			existing.Status == status && existing.Reason == reason && existing.Message == message {
+			return false
+		}
+		meta.SetStatusCondition(&live.Status.Conditions, metav1.Condition{
+			Type:               condType,
+			Status:             status,
+			ObservedGeneration: live.Generation,
+			Reason:             reason,
+			Message:            message,
+		})
+		return true
+	})
+}
+
+// setSidecarRecommendations records the resource requests recommended for workloads' sidecars on the status of THE
+// mesh, if it has been applied to the cluster.
+func (i *Installer) setSidecarRecommendations(recommendations []v1alpha1.SidecarRecommendation) {
+	mesh := i.CurrentMesh()
+	if mesh == nil || mesh.UID == "" {
+		return
+	}
+	i.updateMeshStatus(mesh, "Field", "sidecar_recommendations", func(live *v1alpha1.Mesh) bool {
+		if reflect.DeepEqual(live.Status.SidecarRecommendations, recommendations) {
+			return false
+		}
+		live.Status.SidecarRecommendations = recommendations
+		return true
+	})
+}
+
+// updateMeshStatus changes the status of the live version of the mesh, and writes it unless change reports that it
+// made no change. THE mesh is then replaced with a copy carrying the written status.
+func (i *Installer) updateMeshStatus(mesh *v1alpha1.Mesh, key, value string, change func(live *v1alpha1.Mesh) bool) {
+	ctx, cancel := k8sapi.WithTimeout(i.RunContext())
+	defer cancel()
+	// Re-read the live mesh so we don't clobber status written elsewhere.
+	live := &v1alpha1.Mesh{}
+	if err := i.K8sClient.Get(ctx, client.ObjectKeyFromObject(mesh), live); err != nil {
+		logger.Error(err, "Failed to get Mesh for status update", "Name", mesh.Name)
+		return
+	}
+	if !change(live) {
+		return
+	}
+	if err := i.K8sClient.Status().Update(ctx, live); err != nil {
+		logger.Error(err, "Failed to update Mesh status", "Name", mesh.Name, key, value)
+		return
+	}
+
+	// Replace THE mesh with a copy carrying the new status, unless it was replaced in the meantime
+	i.state.Lock()
+	defer i.state.Unlock()
+	if i.Mesh == mesh {
+		updated := mesh.DeepCopy()
+		updated.Status = live.Status
+		i.Mesh = updated
+	}
+}
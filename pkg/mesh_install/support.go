@@ -0,0 +1,43 @@
+package mesh_install
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	"github.com/greymatter-io/operator/pkg/k8sapi"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// EffectiveConfig returns the operator's config and the defaults currently in effect (including those derived at
+// runtime) as JSON, with the Redis password redacted.
+func (i *Installer) EffectiveConfig() (json.RawMessage, error) {
+	defaults := i.CurrentDefaults()
+	if defaults.RedisPassword != "" {
+		defaults.RedisPassword = "<redacted>"
+	}
+	i.RLock()
+	config := i.Config
+	i.RUnlock()
+	return json.Marshal(struct {
+		Config   cuemodule.Config   `json:"config"`
+		Defaults cuemodule.Defaults `json:"defaults"`
+	}{config, defaults})
+}
+
+// ComponentPods returns the pods in THE mesh's install namespace, where its core components run.
+func (i *Installer) ComponentPods(ctx context.Context) ([]corev1.Pod, error) {
+	mesh := i.CurrentMesh()
+	if mesh == nil || mesh.Spec.InstallNamespace == "" {
+		return nil, errors.New("no mesh has been loaded")
+	}
+	var pods []corev1.Pod
+	list := &corev1.PodList{}
+	err := k8sapi.ListPages(ctx, i.K8sClient, list, i.Config.Reconcile.PageSize, func() error {
+		pods = append(pods, list.Items...)
+		return nil
+	}, client.InNamespace(mesh.Spec.InstallNamespace))
+	return pods, err
+}
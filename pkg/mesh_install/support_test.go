@@ -0,0 +1,42 @@
+package mesh_install
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSupportSource(t *testing.T) {
+	i, _ := newTestInstaller(t,
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "control-0", Namespace: "greymatter"}, Status: corev1.PodStatus{Phase: corev1.PodRunning}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-0", Namespace: "apps"}},
+	)
+	i.Defaults.RedisPassword = "hunter2"
+	i.Config.Spire = true
+
+	b, err := i.EffectiveConfig()
+	if assert.NoError(t, err) {
+		var effective struct {
+			Config struct {
+				Spire bool `json:"spire"`
+			} `json:"config"`
+			Defaults struct {
+				RedisPassword string `json:"redis_password"`
+			} `json:"defaults"`
+		}
+		assert.NoError(t, json.Unmarshal(b, &effective))
+		assert.True(t, effective.Config.Spire)
+		assert.Equal(t, "<redacted>", effective.Defaults.RedisPassword)
+	}
+	assert.Equal(t, "hunter2", i.CurrentDefaults().RedisPassword)
+
+	pods, err := i.ComponentPods(context.TODO())
+	assert.NoError(t, err)
+	if assert.Len(t, pods, 1) {
+		assert.Equal(t, corev1.PodRunning, pods[0].Status.Phase)
+	}
+}
@@ -0,0 +1,65 @@
+package mesh_install
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	"github.com/greymatter-io/operator/pkg/k8sapi"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// The ConfigMap (in the gm-operator namespace) that holds the topology of the mesh's core config.
+	topologyConfigMapName = "gm-mesh-topology"
+	// The name of the topology publishing in config.reconcile.disabled.
+	reconcilerTopology = "topology"
+)
+
+// Topology returns the topology of the Grey Matter config currently derived from CUE, or if live, of the config
+// currently in Control and Catalog, which includes that of workloads' sidecars.
+func (i *Installer) Topology(ctx context.Context, live bool) (cuemodule.Topology, error) {
+	var configs []json.RawMessage
+	var kinds []string
+	var err error
+	if live {
+		configs, kinds, err = i.LiveConfig(ctx)
+	} else {
+		configs, kinds, err = i.DerivedConfig()
+	}
+	if err != nil {
+		return cuemodule.Topology{}, err
+	}
+	topology := cuemodule.BuildTopology(configs, kinds)
+	if !live && i.Sync != nil {
+		if report := i.Sync.CurrentReport(); report != nil {
+			topology.Commit = report.Commit()
+		}
+	}
+	return topology, nil
+}
+
+// publishTopology writes the topology of the core Grey Matter config extracted for a sync to a ConfigMap, if it
+// changed, so it can be consumed without querying Control.
+func (i *Installer) publishTopology(ctx context.Context, configs []json.RawMessage, kinds []string, commit string) {
+	if !i.Config.Reconcile.Enabled(reconcilerTopology) {
+		return
+	}
+	topology := cuemodule.BuildTopology(configs, kinds)
+	topology.Commit = commit
+	b, err := json.MarshalIndent(topology, "", "  ")
+	if err != nil {
+		logger.Error(err, "Failed to serialize mesh topology")
+		return
+	}
+	cm := &corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{Kind: "ConfigMap", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      topologyConfigMapName,
+			Namespace: "gm-operator",
+		},
+		Data: map[string]string{"topology.json": string(b)},
+	}
+	k8sapi.Apply(ctx, i.K8sClient, cm, nil, k8sapi.CreateOrUpdateIfChanged)
+}
@@ -0,0 +1,42 @@
+package mesh_install
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestPublishTopology(t *testing.T) {
+	i, c := newTestInstaller(t)
+	configs := []json.RawMessage{
+		json.RawMessage(`{"proxy_key":"edge","zone_key":"default-zone","name":"edge","domain_keys":["edge"]}`),
+		json.RawMessage(`{"route_key":"edge-to-catalog","domain_key":"edge","zone_key":"default-zone","rules":[{"constraints":{"light":[{"cluster_key":"catalog"}]}}]}`),
+		json.RawMessage(`{"cluster_key":"catalog","zone_key":"default-zone","name":"catalog"}`),
+	}
+	kinds := []string{"proxy", "route", "cluster"}
+
+	i.publishTopology(context.TODO(), configs, kinds, "abc123")
+	cm := &corev1.ConfigMap{}
+	if assert.NoError(t, c.Get(context.TODO(), client.ObjectKey{Namespace: "gm-operator", Name: topologyConfigMapName}, cm)) {
+		var topology cuemodule.Topology
+		assert.NoError(t, json.Unmarshal([]byte(cm.Data["topology.json"]), &topology))
+		assert.Equal(t, "abc123", topology.Commit)
+		assert.Equal(t, []cuemodule.TopologyEdge{{From: "edge", To: "catalog", Zone: "default-zone", Cluster: "catalog", Route: "edge-to-catalog", Traffic: "light"}}, topology.Edges)
+	}
+
+	// Unless disabled
+	assert.NoError(t, c.Delete(context.TODO(), cm))
+	i.Config.Reconcile.Disabled = []string{reconcilerTopology}
+	i.publishTopology(context.TODO(), configs, kinds, "abc123")
+	assert.Error(t, c.Get(context.TODO(), client.ObjectKey{Namespace: "gm-operator", Name: topologyConfigMapName}, &corev1.ConfigMap{}))
+
+	// The derived config's topology is served too
+	topology, err := i.Topology(context.TODO(), false)
+	assert.NoError(t, err)
+	assert.Empty(t, topology.Services)
+}
@@ -0,0 +1,274 @@
+package mesh_install
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	"github.com/greymatter-io/operator/pkg/k8sapi"
+	"github.com/greymatter-io/operator/pkg/wellknown"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// The name of the watched namespace loop in config.reconcile.disabled.
+	reconcilerWatchedNamespaces = "watched_namespaces"
+	// How often the namespaces selected by the mesh and their resources are reconciled, unless
+	// config.reconcile.interval_seconds is set.
+	watchedNamespacesInterval = time.Minute
+	// Records on the applied mesh which of its watched namespaces were added by its watch_namespace_selector, so they're
+	// selected afresh whenever it's applied again. It's only set on the operator's copy, never on the Mesh resource.
+	annotationSelectedNamespaces = "greymatter.io/selected-namespaces"
+)
+
+// withSelectedNamespaces returns the mesh with the namespaces matched by its watch_namespace_selector appended to its
+// watched namespaces, in place of those it was last given. The install namespace, system namespaces, and namespaces
+// being deleted are never selected. If the namespaces can't be listed, the mesh is returned as it is.
+func (i *Installer) withSelectedNamespaces(ctx context.Context, mesh *v1alpha1.Mesh) *v1alpha1.Mesh {
+	previous := selectedNamespaces(mesh)
+	if mesh.Spec.WatchNamespaceSelector == nil && len(previous) == 0 {
+		return mesh
+	}
+
+	var selected []string
+	if mesh.Spec.WatchNamespaceSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(mesh.Spec.WatchNamespaceSelector)
+		if err != nil {
+			logger.Error(err, "Invalid watch_namespace_selector", "Mesh", mesh.Name)
+			return mesh
+		}
+		namespaces := &corev1.NamespaceList{}
+		if err := i.K8sClient.List(ctx, namespaces, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+			logger.Error(err, "Failed to list namespaces matching watch_namespace_selector", "Mesh", mesh.Name)
+			return mesh
+		}
+		excluded := map[string]bool{mesh.Spec.InstallNamespace: true}
+		for _, ns := range mesh.Spec.WatchNamespaces {
+			excluded[ns] = !previous[ns]
+		}
+		for _, ns := range namespaces.Items {
+			if !excluded[ns.Name] && !systemNamespaces[ns.Name] && ns.DeletionTimestamp.IsZero() {
+				selected = append(selected, ns.Name)
+			}
+		}
+		sort.Strings(selected)
+	}
+
+	selecting := mesh.DeepCopy()
+	selecting.Spec.WatchNamespaces = nil
+	for _, ns := range mesh.Spec.WatchNamespaces {
+		if !previous[ns] {
+			selecting.Spec.WatchNamespaces = append(selecting.Spec.WatchNamespaces, ns)
+		}
+	}
+	selecting.Spec.WatchNamespaces = append(selecting.Spec.WatchNamespaces, selected...)
+	delete(selecting.Annotations, annotationSelectedNamespaces)
+	if len(selected) > 0 {
+		if selecting.Annotations == nil {
+			selecting.Annotations = make(map[string]string)
+		}
+		selecting.Annotations[annotationSelectedNamespaces] = strings.Join(selected, ",")
+	}
+	return selecting
+}
+
+// selectedNamespaces returns the watched namespaces the mesh was given by its watch_namespace_selector.
+func selectedNamespaces(mesh *v1alpha1.Mesh) map[string]bool {
+	selected := make(map[string]bool)
+	if value := mesh.Annotations[annotationSelectedNamespaces]; value != "" {
+		for _, ns := range strings.Split(value, ",") {
+			selected[ns] = true
+		}
+	}
+	return selected
+}
+
+// watchedNamespaceObjects returns the resources the mesh needs in each of its watched namespaces: a copy of the image
+// pull secret if config.auto_copy_image_pull_secret is set, and the Roles and RoleBindings of
+// config.watched_namespace_rbac. Each is labeled with the mesh's name, so it's removed along with the namespace.
+func (i *Installer) watchedNamespaceObjects(mesh *v1alpha1.Mesh, namespace string) []client.Object {
+	labels := map[string]string{wellknown.LABEL_MESH: mesh.Name}
+	var objects []client.Object
+
+	i.RLock()
+	imagePullSecret := i.imagePullSecret
+	i.RUnlock()
+	if i.Config.AutoCopyImagePullSecret && imagePullSecret != nil {
+		secret := imagePullSecret.DeepCopy()
+		secret.TypeMeta = metav1.TypeMeta{Kind: "Secret", APIVersion: "v1"}
+		secret.Namespace = namespace
+		secret.Labels = labels
+		objects = append(objects, secret)
+	}
+
+	for _, role := range i.Config.WatchedNamespaceRBAC {
+		objects = append(objects,
+			&rbacv1.Role{
+				TypeMeta:   metav1.TypeMeta{Kind: "Role", APIVersion: "rbac.authorization.k8s.io/v1"},
+				ObjectMeta: metav1.ObjectMeta{Name: role.Name, Namespace: namespace, Labels: labels},
+				Rules:      role.Rules,
+			},
+			&rbacv1.RoleBinding{
+				TypeMeta:   metav1.TypeMeta{Kind: "RoleBinding", APIVersion: "rbac.authorization.k8s.io/v1"},
+				ObjectMeta: metav1.ObjectMeta{Name: role.Name, Namespace: namespace, Labels: labels},
+				RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: role.Name},
+				Subjects:   roleSubjects(role, mesh.Spec.InstallNamespace),
+			},
+		)
+	}
+	return objects
+}
+
+// roleSubjects returns the ServiceAccounts bound to a watched namespace Role, which are in the install namespace
+// unless given as "namespace/name".
+func roleSubjects(role cuemodule.NamespaceRole, installNamespace string) []rbacv1.Subject {
+	var subjects []rbacv1.Subject
+	for _, sa := range role.ServiceAccounts {
+		namespace, name := installNamespace, sa
+		if parts := strings.SplitN(sa, "/", 2); len(parts) == 2 {
+			namespace, name = parts[0], parts[1]
+		}
+		subjects = append(subjects, rbacv1.Subject{Kind: rbacv1.ServiceAccountKind, Name: name, Namespace: namespace})
+	}
+	return subjects
+}
+
+// applyWatchedNamespaces applies the resources the mesh needs in each of its watched namespaces that exists, and
+// removes those it applied to namespaces no longer watched, or that are no longer configured.
+func (i *Installer) applyWatchedNamespaces(ctx context.Context, mesh *v1alpha1.Mesh) {
+	desired := make(map[string]bool)
+	for _, namespace := range meshNamespaces(mesh)[1:] {
+		ns := &corev1.Namespace{}
+		if err := i.K8sClient.Get(ctx, client.ObjectKey{Name: namespace}, ns); err != nil {
+			if !errors.IsNotFound(err) {
+				logger.Error(err, "Failed to get watched namespace", "Namespace", namespace)
+			}
+			continue
+		}
+		if !ns.DeletionTimestamp.IsZero() {
+			continue
+		}
+		for _, obj := range i.watchedNamespaceObjects(mesh, namespace) {
+			desired[watchedObjectKey(obj)] = true
+			i.applyIfChanged(ctx, obj, mesh)
+		}
+	}
+	i.cleanUpWatchedNamespaces(ctx, mesh, desired)
+}
+
+// applyIfChanged applies an object owned by the mesh with k8sapi.CreateOrUpdateIfChanged, logging only if it changes,
+// since the watched namespaces' resources are reapplied periodically.
+func (i *Installer) applyIfChanged(ctx context.Context, obj client.Object, mesh *v1alpha1.Mesh) {
+	if err := controllerutil.SetOwnerReference(mesh, obj, i.K8sClient.Scheme()); err != nil {
+		logger.Error(err, "Failed to set owner reference", "Owner", mesh.Name, "Namespace", obj.GetNamespace(), "Name", obj.GetName())
+		return
+	}
+	kind := obj.GetObjectKind().GroupVersionKind().Kind
+	ctx, cancel := k8sapi.WithTimeout(ctx)
+	defer cancel()
+	act, err := k8sapi.CreateOrUpdateIfChanged(ctx, i.K8sClient, obj)
+	if err != nil {
+		logger.Error(err, act, "Owner", mesh.Name, kind, client.ObjectKeyFromObject(obj))
+	} else if act != "unchanged" {
+		logger.Info(act, "Owner", mesh.Name, kind, client.ObjectKeyFromObject(obj))
+	}
+}
+
+// cleanUpWatchedNamespaces deletes the resources labeled for the mesh in its watched namespaces that aren't desired,
+// given by watchedObjectKey. A nil desired set deletes all of them, e.g. when the mesh is removed. Those in the install
+// namespace are left alone, since they're the core components'.
+func (i *Installer) cleanUpWatchedNamespaces(ctx context.Context, mesh *v1alpha1.Mesh, desired map[string]bool) {
+	labeled := client.MatchingLabels{wellknown.LABEL_MESH: mesh.Name}
+	var objects []client.Object
+
+	secrets := &corev1.SecretList{}
+	if err := i.K8sClient.List(ctx, secrets, labeled); err != nil {
+		logger.Error(err, "Failed to list image pull secrets copied for mesh", "Mesh", mesh.Name)
+	}
+	for idx := range secrets.Items {
+		if secret := &secrets.Items[idx]; secret.Name == "gm-docker-secret" {
+			secret.Kind = "Secret"
+			objects = append(objects, secret)
+		}
+	}
+	roles := &rbacv1.RoleList{}
+	if err := i.K8sClient.List(ctx, roles, labeled); err != nil {
+		logger.Error(err, "Failed to list Roles applied for mesh", "Mesh", mesh.Name)
+	}
+	for idx := range roles.Items {
+		roles.Items[idx].Kind = "Role"
+		objects = append(objects, &roles.Items[idx])
+	}
+	bindings := &rbacv1.RoleBindingList{}
+	if err := i.K8sClient.List(ctx, bindings, labeled); err != nil {
+		logger.Error(err, "Failed to list RoleBindings applied for mesh", "Mesh", mesh.Name)
+	}
+	for idx := range bindings.Items {
+		bindings.Items[idx].Kind = "RoleBinding"
+		objects = append(objects, &bindings.Items[idx])
+	}
+
+	for _, obj := range objects {
+		if obj.GetNamespace() == mesh.Spec.InstallNamespace || desired[watchedObjectKey(obj)] {
+			continue
+		}
+		kind := obj.GetObjectKind().GroupVersionKind().Kind
+		if err := i.K8sClient.Delete(ctx, obj); err != nil && !errors.IsNotFound(err) {
+			logger.Error(err, "Failed to delete resource from namespace no longer watched", kind, client.ObjectKeyFromObject(obj))
+			continue
+		}
+		logger.Info("Deleted resource no longer needed in watched namespace", "Mesh", mesh.Name, kind, client.ObjectKeyFromObject(obj))
+	}
+}
+
+// watchedObjectKey identifies one of the resources applied to a watched namespace.
+func watchedObjectKey(obj client.Object) string {
+	return obj.GetObjectKind().GroupVersionKind().Kind + "/" + obj.GetNamespace() + "/" + obj.GetName()
+}
+
+// reconcileWatchedNamespaces periodically reapplies THE mesh when the namespaces its watch_namespace_selector matches
+// change, and otherwise restores the resources it needs in its watched namespaces, until the context is cancelled.
+func (i *Installer) reconcileWatchedNamespaces(ctx context.Context) {
+	ticker := time.NewTicker(i.Config.Reconcile.Interval(watchedNamespacesInterval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		mesh := i.CurrentMesh()
+		if mesh == nil || mesh.UID == "" {
+			continue
+		}
+		if selected := i.withSelectedNamespaces(ctx, mesh); !equalStrings(selected.Spec.WatchNamespaces, mesh.Spec.WatchNamespaces) {
+			logger.Info("Namespaces matching watch_namespace_selector changed; reapplying the mesh", "Mesh", mesh.Name,
+				"Selected", selected.Annotations[annotationSelectedNamespaces])
+			if err := i.ApplyMesh(i.RunContext(), mesh, mesh.DeepCopy()); err != nil {
+				logger.Error(err, "Failed to reapply mesh for its selected namespaces", "Mesh", mesh.Name)
+			}
+			continue
+		}
+		i.applyWatchedNamespaces(ctx, mesh)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for idx := range a {
+		if a[idx] != b[idx] {
+			return false
+		}
+	}
+	return true
+}
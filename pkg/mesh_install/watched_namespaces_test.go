@@ -0,0 +1,116 @@
+package mesh_install
+
+import (
+	"context"
+	"testing"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	"github.com/greymatter-io/operator/pkg/wellknown"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestWithSelectedNamespaces(t *testing.T) {
+	team := map[string]string{"team": "payments"}
+	i, c := newTestInstaller(t,
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "greymatter", Labels: team}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps", Labels: team}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "payments", Labels: team}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system", Labels: team}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "billing"}},
+	)
+	mesh := &v1alpha1.Mesh{
+		ObjectMeta: metav1.ObjectMeta{Name: "mesh-sample"},
+		Spec: v1alpha1.MeshSpec{
+			InstallNamespace:       "greymatter",
+			WatchNamespaces:        []string{"apps", "legacy"},
+			WatchNamespaceSelector: &metav1.LabelSelector{MatchLabels: team},
+		},
+	}
+
+	// Listed, install, and system namespaces aren't selected
+	selected := i.withSelectedNamespaces(context.TODO(), mesh)
+	assert.Equal(t, []string{"apps", "legacy", "payments"}, selected.Spec.WatchNamespaces)
+	assert.Equal(t, []string{"apps", "legacy"}, mesh.Spec.WatchNamespaces, "the given mesh is unchanged")
+
+	// Selecting again replaces the namespaces selected before
+	billing := &corev1.Namespace{}
+	assert.NoError(t, c.Get(context.TODO(), client.ObjectKey{Name: "billing"}, billing))
+	billing.Labels = team
+	assert.NoError(t, c.Update(context.TODO(), billing))
+	payments := &corev1.Namespace{}
+	assert.NoError(t, c.Get(context.TODO(), client.ObjectKey{Name: "payments"}, payments))
+	payments.Labels = nil
+	assert.NoError(t, c.Update(context.TODO(), payments))
+	assert.Equal(t, []string{"apps", "legacy", "billing"}, i.withSelectedNamespaces(context.TODO(), selected).Spec.WatchNamespaces)
+
+	// And without a selector, none are
+	selected.Spec.WatchNamespaceSelector = nil
+	unselected := i.withSelectedNamespaces(context.TODO(), selected)
+	assert.Equal(t, []string{"apps", "legacy"}, unselected.Spec.WatchNamespaces)
+	assert.NotContains(t, unselected.Annotations, annotationSelectedNamespaces)
+}
+
+func TestApplyWatchedNamespaces(t *testing.T) {
+	i, c := newTestInstaller(t,
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "greymatter"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "payments"}},
+	)
+	i.imagePullSecret = startObjects()[1].(*corev1.Secret)
+	i.Config.AutoCopyImagePullSecret = true
+	i.Config.WatchedNamespaceRBAC = []cuemodule.NamespaceRole{{
+		Name:            "greymatter-control",
+		Rules:           []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get", "list", "watch"}}},
+		ServiceAccounts: []string{"control", "monitoring/prometheus"},
+	}}
+	mesh := &v1alpha1.Mesh{
+		ObjectMeta: metav1.ObjectMeta{Name: "mesh-sample"},
+		Spec:       v1alpha1.MeshSpec{InstallNamespace: "greymatter", WatchNamespaces: []string{"apps", "payments", "missing"}},
+	}
+	get := func(namespace, name string, obj client.Object) error {
+		return c.Get(context.TODO(), client.ObjectKey{Namespace: namespace, Name: name}, obj)
+	}
+
+	i.applyWatchedNamespaces(context.TODO(), mesh)
+	for _, ns := range []string{"apps", "payments"} {
+		secret := &corev1.Secret{}
+		if assert.NoError(t, get(ns, "gm-docker-secret", secret)) {
+			assert.Equal(t, "mesh-sample", secret.Labels[wellknown.LABEL_MESH])
+			assert.Equal(t, i.imagePullSecret.Data, secret.Data)
+		}
+		role := &rbacv1.Role{}
+		if assert.NoError(t, get(ns, "greymatter-control", role)) {
+			assert.Equal(t, i.Config.WatchedNamespaceRBAC[0].Rules, role.Rules)
+		}
+		binding := &rbacv1.RoleBinding{}
+		if assert.NoError(t, get(ns, "greymatter-control", binding)) {
+			assert.Equal(t, []rbacv1.Subject{
+				{Kind: rbacv1.ServiceAccountKind, Name: "control", Namespace: "greymatter"},
+				{Kind: rbacv1.ServiceAccountKind, Name: "prometheus", Namespace: "monitoring"},
+			}, binding.Subjects)
+			assert.Equal(t, "greymatter-control", binding.RoleRef.Name)
+		}
+	}
+	assert.Error(t, get("greymatter", "greymatter-control", &rbacv1.Role{}), "the install namespace is left alone")
+
+	// A namespace no longer watched is cleaned up, as is a Role no longer configured
+	i.Config.WatchedNamespaceRBAC[0].Name = "greymatter-catalog"
+	mesh.Spec.WatchNamespaces = []string{"apps"}
+	i.applyWatchedNamespaces(context.TODO(), mesh)
+	assert.NoError(t, get("apps", "gm-docker-secret", &corev1.Secret{}))
+	assert.NoError(t, get("apps", "greymatter-catalog", &rbacv1.Role{}))
+	assert.Error(t, get("apps", "greymatter-control", &rbacv1.Role{}))
+	assert.Error(t, get("apps", "greymatter-control", &rbacv1.RoleBinding{}))
+	assert.Error(t, get("payments", "gm-docker-secret", &corev1.Secret{}))
+	assert.Error(t, get("payments", "greymatter-catalog", &rbacv1.Role{}))
+
+	// And everything is removed with the mesh
+	i.cleanUpWatchedNamespaces(context.TODO(), mesh, nil)
+	assert.Error(t, get("apps", "gm-docker-secret", &corev1.Secret{}))
+	assert.Error(t, get("apps", "greymatter-catalog", &rbacv1.RoleBinding{}))
+}
@@ -0,0 +1,182 @@
+// Package meshtest runs the operator's install pipeline against a local apiserver and etcd, started with
+// controller-runtime's envtest, so changes to the operator or to a CUE module can be tested without a cluster.
+// Grey Matter config is sent to an in-memory gmapi.MockAPI rather than a Control and Catalog API, and no
+// controllers run, so applied Deployments never roll out.
+//
+// The kube-apiserver and etcd binaries are found in the directory named by KUBEBUILDER_ASSETS
+// (or /usr/local/kubebuilder/bin), e.g. as installed by `make envtest`:
+//
+//	env, err := meshtest.Start("path/to/cue")
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	defer env.Stop()
+//	err = env.ApplyMesh(mesh)
+package meshtest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/greymatter-io/operator/config"
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	"github.com/greymatter-io/operator/pkg/gitops"
+	"github.com/greymatter-io/operator/pkg/gmapi"
+	"github.com/greymatter-io/operator/pkg/mesh_install"
+	configv1 "github.com/openshift/api/config/v1"
+	corev1 "k8s.io/api/core/v1"
+	extv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+	"sigs.k8s.io/yaml"
+)
+
+// defaultAssetsDir is where envtest looks for its binaries if KUBEBUILDER_ASSETS isn't set.
+const defaultAssetsDir = "/usr/local/kubebuilder/bin"
+
+// Env is a local control plane with the Mesh CRD installed, and an Installer applying a CUE module to it.
+type Env struct {
+	// Client for the control plane.
+	Client client.Client
+	// Installer for the CUE module, already started.
+	Installer *mesh_install.Installer
+	// Mock Control and Catalog APIs receiving the Installer's Grey Matter config.
+	API *gmapi.MockAPI
+
+	testEnv *envtest.Environment
+	cancel  context.CancelFunc
+}
+
+// Available reports whether the envtest binaries can be found, so tests can be skipped where they can't.
+func Available() bool {
+	dir := os.Getenv("KUBEBUILDER_ASSETS")
+	if dir == "" {
+		dir = defaultAssetsDir
+	}
+	_, err := os.Stat(filepath.Join(dir, "kube-apiserver"))
+	return err == nil
+}
+
+// Start starts a control plane, and an Installer for the CUE module in cueRoot as the operator would start it,
+// with the image pull secret it expects already created. Options change the Installer (e.g. its Config)
+// before it's started. The default mesh is never applied automatically.
+func Start(cueRoot string, options ...func(*mesh_install.Installer)) (*Env, error) {
+	scheme := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(v1alpha1.AddToScheme(scheme))
+	utilruntime.Must(extv1.AddToScheme(scheme))
+	utilruntime.Must(configv1.AddToScheme(scheme))
+
+	b, err := config.MeshCRD()
+	if err != nil {
+		return nil, err
+	}
+	crd := &extv1.CustomResourceDefinition{}
+	if err := yaml.Unmarshal(b, crd); err != nil {
+		return nil, fmt.Errorf("failed to parse Mesh CRD: %w", err)
+	}
+
+	operatorCUE, initialMesh, err := cuemodule.LoadAll(cueRoot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load CUE from %s: %w", cueRoot, err)
+	}
+
+	env := &Env{
+		API: gmapi.NewMockAPI(),
+		testEnv: &envtest.Environment{
+			Scheme:            scheme,
+			CRDInstallOptions: envtest.CRDInstallOptions{CRDs: []*extv1.CustomResourceDefinition{crd}},
+		},
+	}
+	restConfig, err := env.testEnv.Start()
+	if err != nil {
+		env.API.Close()
+		return nil, fmt.Errorf("failed to start control plane: %w", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	env.cancel = cancel
+	if err := env.start(ctx, restConfig, operatorCUE, initialMesh, cueRoot, options); err != nil {
+		env.Stop()
+		return nil, err
+	}
+	return env, nil
+}
+
+func (env *Env) start(ctx context.Context, restConfig *rest.Config, operatorCUE *cuemodule.OperatorCUE, initialMesh *v1alpha1.Mesh, cueRoot string, options []func(*mesh_install.Installer)) error {
+	c, err := client.New(restConfig, client.Options{Scheme: env.testEnv.Scheme})
+	if err != nil {
+		return err
+	}
+	env.Client = c
+
+	// The operator's namespace and image pull secret, which it waits for on startup
+	for _, obj := range []client.Object{
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "gm-operator"}},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "gm-docker-secret", Namespace: "gm-operator"},
+			Type:       corev1.SecretTypeDockerConfigJson,
+			Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths":{}}`)},
+		},
+	} {
+		if err := c.Create(ctx, obj); err != nil {
+			return err
+		}
+	}
+
+	// State is kept in memory, unless the CUE configures a reachable Redis
+	sync := gitops.New("", ctx, env.cancel)
+	sync.StartStateBackup(ctx, operatorCUE, initialMesh)
+	// Events are dropped, since a FakeRecorder without a channel doesn't keep them
+	i, err := mesh_install.New(c, operatorCUE, initialMesh, cueRoot, env.API.NewCLI(ctx, operatorCUE), nil, sync, &record.FakeRecorder{})
+	if err != nil {
+		return err
+	}
+	i.Config.AutoApplyMesh = false
+	for _, option := range options {
+		option(i)
+	}
+	if err := i.Start(ctx); err != nil {
+		return fmt.Errorf("failed to start installer: %w", err)
+	}
+	env.Installer = i
+	return nil
+}
+
+// ApplyMesh creates the Mesh in the control plane and installs it, as the operator does when a Mesh is created.
+// Its Kubernetes manifests have been applied when it returns, and an error lists any that failed. Its Grey Matter
+// config is applied to the mock APIs asynchronously.
+func (env *Env) ApplyMesh(mesh *v1alpha1.Mesh) error {
+	ctx := env.Installer.RunContext()
+	if err := env.Client.Create(ctx, mesh); err != nil {
+		return err
+	}
+	if err := env.Installer.ApplyMesh(ctx, nil, mesh); err != nil {
+		return err
+	}
+
+	if report := env.Installer.Sync.CurrentReport(); report != nil {
+		if _, _, failed, failures := report.Summary(); failed > 0 {
+			return fmt.Errorf("%d objects failed to apply: %s", failed, strings.Join(failures, "; "))
+		}
+	}
+	return nil
+}
+
+// Stop stops the Installer's goroutines, the mock APIs, and the control plane.
+func (env *Env) Stop() error {
+	if env.cancel != nil {
+		env.cancel()
+	}
+	env.API.Close()
+	return env.testEnv.Stop()
+}
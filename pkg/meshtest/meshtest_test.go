@@ -0,0 +1,103 @@
+package meshtest
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/greymatter-io/operator/pkg/wellknown"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// testCUE is a CUE module installing a ConfigMap and a Deployment into the mesh's install namespace, and a cluster in its zone.
+var testCUE = map[string]string{
+	"cue.mod/module.cue": `module: "greymatter.io/operator/test"`,
+	"k8s/outputs/outputs.cue": `package outputs
+
+mesh: {
+	metadata: name: *"greymatter-mesh" | string
+	spec: install_namespace: *"greymatter" | string
+	spec: zone: *"default-zone" | string
+	...
+}
+k8s_manifests: [{
+	apiVersion: "v1"
+	kind:       "ConfigMap"
+	metadata: {name: "mesh-settings", namespace: mesh.spec.install_namespace}
+	data: zone: mesh.spec.zone
+}, {
+	apiVersion: "apps/v1"
+	kind:       "Deployment"
+	metadata: {name: "control", namespace: mesh.spec.install_namespace}
+	spec: {
+		selector: matchLabels: app: "control"
+		template: metadata: labels: app: "control"
+		template: spec: containers: [{name: "control", image: "control:test"}]
+	}
+}]
+`,
+	"gm/outputs/outputs.cue": "package outputs\n\nmesh: {...}\nmesh_configs: [{cluster_key: \"control\", zone_key: mesh.spec.zone}]\n",
+}
+
+func TestApplyMesh(t *testing.T) {
+	if !Available() {
+		t.Skip("envtest binaries not found; set KUBEBUILDER_ASSETS (see `make envtest`)")
+	}
+	root := t.TempDir()
+	for path, contents := range testCUE {
+		path = filepath.Join(root, path)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, os.WriteFile(path, []byte(contents), 0600))
+	}
+
+	env, err := Start(root)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer env.Stop()
+
+	mesh := &v1alpha1.Mesh{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "greymatter.io/v1alpha1", Kind: "Mesh"},
+		ObjectMeta: metav1.ObjectMeta{Name: "greymatter-mesh"},
+		Spec: v1alpha1.MeshSpec{
+			InstallNamespace: "mesh-system",
+			WatchNamespaces:  []string{"apps"},
+			Zone:             "east",
+			ReleaseVersion:   "latest",
+		},
+	}
+	assert.NoError(t, env.ApplyMesh(mesh))
+
+	// The install and watched namespaces are created
+	for _, ns := range []string{"mesh-system", "apps"} {
+		assert.NoError(t, env.Client.Get(context.TODO(), client.ObjectKey{Name: ns}, &corev1.Namespace{}))
+	}
+
+	// And the manifests are unified with the Mesh, labeled as managed, and owned by it
+	settings := &corev1.ConfigMap{}
+	if assert.NoError(t, env.Client.Get(context.TODO(), client.ObjectKey{Namespace: "mesh-system", Name: "mesh-settings"}, settings)) {
+		assert.Equal(t, "east", settings.Data["zone"])
+		assert.Equal(t, wellknown.MANAGED_BY_OPERATOR, settings.Labels[wellknown.LABEL_MANAGED_BY])
+		if assert.Len(t, settings.OwnerReferences, 1) {
+			assert.Equal(t, mesh.UID, settings.OwnerReferences[0].UID)
+		}
+	}
+	deployment := &appsv1.Deployment{}
+	if assert.NoError(t, env.Client.Get(context.TODO(), client.ObjectKey{Namespace: "mesh-system", Name: "control"}, deployment)) {
+		assert.Equal(t, "control:test", deployment.Spec.Template.Spec.Containers[0].Image)
+	}
+
+	// The Grey Matter config follows once the mock APIs respond
+	assert.Eventually(t, func() bool {
+		cluster, ok := env.API.Object("cluster", "control")
+		return ok && gjson.GetBytes(cluster, "zone_key").String() == "east"
+	}, 10*time.Second, 100*time.Millisecond)
+}
@@ -0,0 +1,202 @@
+// Package notify posts the outcome of GitOps sync cycles to chat and generic webhook endpoints.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/greymatter-io/operator/pkg/gitops"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+var (
+	logger = ctrl.Log.WithName("notify")
+)
+
+// Supported target kinds.
+const (
+	KindSlack   = "slack"
+	KindTeams   = "teams"
+	KindGeneric = "generic"
+)
+
+// How long the results of a sync cycle must stay unchanged before they're considered settled,
+// and the longest we wait for that before notifying anyway.
+var (
+	settleInterval = 15 * time.Second
+	settleTimeout  = 5 * time.Minute
+)
+
+// Target is a single webhook endpoint to notify.
+type Target struct {
+	Kind string
+	URL  string
+}
+
+// Event is the outcome of a sync cycle. It is posted as-is to generic targets.
+type Event struct {
+	Commit   gitops.CommitInfo `json:"commit"`
+	Status   string            `json:"status"` // "succeeded", "failed", or "rejected"
+	Applied  int               `json:"applied"`
+	Deleted  int               `json:"deleted"`
+	Failed   int               `json:"failed"`
+	Failures []string          `json:"failures,omitempty"`
+	Error    string            `json:"error,omitempty"`
+}
+
+// Notifier posts sync outcomes to its targets. It implements gitops.SyncListener.
+type Notifier struct {
+	ctx     context.Context
+	targets []Target
+	client  *http.Client
+}
+
+// New returns a *Notifier for target specs of the form "kind=url" (where kind is slack, teams, or generic)
+// or a bare URL, which is treated as generic. It returns nil if no specs are given.
+func New(ctx context.Context, specs []string) (*Notifier, error) {
+	var targets []Target
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		t := Target{Kind: KindGeneric, URL: spec}
+		if parts := strings.SplitN(spec, "=", 2); len(parts) == 2 && !strings.Contains(parts[0], "://") {
+			t = Target{Kind: parts[0], URL: parts[1]}
+		}
+		switch t.Kind {
+		case KindSlack, KindTeams, KindGeneric:
+		default:
+			return nil, fmt.Errorf("unsupported notification target kind %q (expected slack, teams, or generic)", t.Kind)
+		}
+		targets = append(targets, t)
+	}
+	if len(targets) == 0 {
+		return nil, nil
+	}
+
+	return &Notifier{
+		ctx:     ctx,
+		targets: targets,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// SyncFinished waits for the report to settle, then posts a summary of it to all targets.
+func (n *Notifier) SyncFinished(commit gitops.CommitInfo, report *gitops.SyncReport, err error) {
+	go func() {
+		waitForSettled(n.ctx, report)
+
+		e := Event{Commit: commit, Status: "succeeded"}
+		if report != nil {
+			e.Applied, e.Deleted, e.Failed, e.Failures = report.Summary()
+		}
+		if err != nil {
+			e.Error = err.Error()
+		}
+		if err != nil || e.Failed > 0 {
+			e.Status = "failed"
+		}
+		n.send(e)
+	}()
+}
+
+// SyncRejected posts the reason a fetched commit was refused to all targets.
+func (n *Notifier) SyncRejected(err error) {
+	go n.send(Event{Status: "rejected", Error: err.Error()})
+}
+
+// waitForSettled blocks until the report hasn't changed for settleInterval, or settleTimeout elapses.
+func waitForSettled(ctx context.Context, report *gitops.SyncReport) {
+	if report == nil {
+		return
+	}
+	deadline := time.After(settleTimeout)
+	last := report.Version()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-deadline:
+			return
+		case <-time.After(settleInterval):
+		}
+		if v := report.Version(); v != last {
+			last = v
+			continue
+		}
+		return
+	}
+}
+
+func (n *Notifier) send(e Event) {
+	for _, t := range n.targets {
+		body, err := payload(t.Kind, e)
+		if err != nil {
+			logger.Error(err, "Failed to build notification", "kind", t.Kind)
+			continue
+		}
+		req, err := http.NewRequestWithContext(n.ctx, http.MethodPost, t.URL, bytes.NewReader(body))
+		if err != nil {
+			logger.Error(err, "Failed to build notification request", "kind", t.Kind)
+			continue
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := n.client.Do(req)
+		if err != nil {
+			logger.Error(err, "Failed to send notification", "kind", t.Kind)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			logger.Error(fmt.Errorf("status %s", resp.Status), "Notification target rejected request", "kind", t.Kind)
+		}
+	}
+}
+
+// payload renders an Event in the format expected by a target kind.
+func payload(kind string, e Event) ([]byte, error) {
+	switch kind {
+	case KindSlack:
+		return json.Marshal(map[string]string{"text": summarize(e)})
+	case KindTeams:
+		return json.Marshal(map[string]string{
+			"@type":    "MessageCard",
+			"@context": "https://schema.org/extensions",
+			"summary":  fmt.Sprintf("Grey Matter GitOps sync %s", e.Status),
+			"text":     strings.ReplaceAll(summarize(e), "\n", "\n\n"),
+		})
+	default:
+		return json.Marshal(e)
+	}
+}
+
+// summarize renders an Event as human-readable text for chat targets.
+func summarize(e Event) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Grey Matter GitOps sync %s", e.Status)
+	if e.Commit.SHA != "" {
+		fmt.Fprintf(&b, " for commit %s", e.Commit.SHA)
+		if e.Commit.Author != "" {
+			fmt.Fprintf(&b, " by %s", e.Commit.Author)
+		}
+	}
+	if e.Commit.Message != "" {
+		fmt.Fprintf(&b, "\n> %s", strings.SplitN(e.Commit.Message, "\n", 2)[0])
+	}
+	if e.Status != "rejected" {
+		fmt.Fprintf(&b, "\nApplied: %d, Deleted: %d, Failed: %d", e.Applied, e.Deleted, e.Failed)
+	}
+	for _, f := range e.Failures {
+		fmt.Fprintf(&b, "\n- %s", f)
+	}
+	if e.Error != "" {
+		fmt.Fprintf(&b, "\nError: %s", e.Error)
+	}
+	return b.String()
+}
@@ -0,0 +1,97 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/greymatter-io/operator/pkg/gitops"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	cases := map[string]struct {
+		specs    []string
+		expected []Target
+		err      bool
+	}{
+		"empty": {
+			specs: []string{""},
+		},
+		"bare url is generic": {
+			specs:    []string{"https://example.com/hook"},
+			expected: []Target{{Kind: KindGeneric, URL: "https://example.com/hook"}},
+		},
+		"bare url with query": {
+			specs:    []string{"https://example.com/hook?token=abc"},
+			expected: []Target{{Kind: KindGeneric, URL: "https://example.com/hook?token=abc"}},
+		},
+		"kinds": {
+			specs: []string{"slack=https://hooks.slack.com/x", " teams=https://outlook.office.com/y"},
+			expected: []Target{
+				{Kind: KindSlack, URL: "https://hooks.slack.com/x"},
+				{Kind: KindTeams, URL: "https://outlook.office.com/y"},
+			},
+		},
+		"unknown kind": {
+			specs: []string{"discord=https://example.com"},
+			err:   true,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			n, err := New(context.Background(), tc.specs)
+			if tc.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			if tc.expected == nil {
+				assert.Nil(t, n)
+				return
+			}
+			assert.Equal(t, tc.expected, n.targets)
+		})
+	}
+}
+
+func TestSend(t *testing.T) {
+	bodies := make(chan []byte, 3)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies <- b
+	}))
+	defer srv.Close()
+
+	n, err := New(context.Background(), []string{"slack=" + srv.URL, "teams=" + srv.URL, srv.URL})
+	assert.NoError(t, err)
+
+	report := gitops.NewSyncReport("abc123")
+	report.Record("gm", "cluster", "default-zone", "edge", "apply", nil)
+	report.Record("gm", "route", "default-zone", "edge", "apply", errors.New("boom"))
+
+	e := Event{Commit: gitops.CommitInfo{SHA: "abc123", Author: "dev", Message: "Update edge\n\nDetails"}, Status: "failed"}
+	e.Applied, e.Deleted, e.Failed, e.Failures = report.Summary()
+	n.send(e)
+
+	var slack map[string]string
+	assert.NoError(t, json.Unmarshal(<-bodies, &slack))
+	assert.True(t, strings.HasPrefix(slack["text"], "Grey Matter GitOps sync failed for commit abc123 by dev\n> Update edge\n"))
+	assert.Contains(t, slack["text"], "Applied: 1, Deleted: 0, Failed: 1")
+	assert.Contains(t, slack["text"], "apply route default-zone/edge: boom")
+
+	var teams map[string]string
+	assert.NoError(t, json.Unmarshal(<-bodies, &teams))
+	assert.Equal(t, "MessageCard", teams["@type"])
+	assert.Equal(t, "Grey Matter GitOps sync failed", teams["summary"])
+
+	var generic Event
+	assert.NoError(t, json.Unmarshal(<-bodies, &generic))
+	assert.Equal(t, e, generic)
+}
@@ -0,0 +1,156 @@
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	extv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Permission is a verb the operator needs on a kind of resource, optionally only on the one with the given name.
+type Permission struct {
+	Group, Resource, Name string
+	Verbs                 []string
+}
+
+// OperatorPermissions are those the operator needs to install and manage a mesh, from its ClusterRole.
+// Those for optional features (ingress, monitoring, SPIRE, and key delivery) aren't checked.
+var OperatorPermissions = []Permission{
+	{Group: "apiextensions.k8s.io", Resource: "customresourcedefinitions", Name: "meshes.greymatter.io", Verbs: []string{"get", "watch"}},
+	{Group: "greymatter.io", Resource: "meshes", Verbs: []string{"get", "list", "watch", "update"}},
+	{Group: "greymatter.io", Resource: "meshes/status", Verbs: []string{"update"}},
+	{Group: "greymatter.io", Resource: "operatorinstallations", Verbs: []string{"get", "create", "update", "watch"}},
+	{Group: "greymatter.io", Resource: "operatorinstallations/status", Verbs: []string{"update"}},
+	{Resource: "events", Verbs: []string{"create"}},
+	{Resource: "endpoints", Verbs: []string{"get", "watch"}},
+	{Group: "admissionregistration.k8s.io", Resource: "mutatingwebhookconfigurations", Name: "gm-mutate-config", Verbs: []string{"get", "patch"}},
+	{Group: "apps", Resource: "deployments", Verbs: []string{"get", "list", "create", "update", "patch", "delete"}},
+	{Group: "apps", Resource: "statefulsets", Verbs: []string{"get", "list", "create", "update", "patch", "delete"}},
+	{Resource: "configmaps", Verbs: []string{"get", "list", "create", "update", "delete"}},
+	{Resource: "secrets", Verbs: []string{"get", "list", "create", "update", "delete"}},
+	{Resource: "services", Verbs: []string{"get", "list", "create", "update", "delete"}},
+	{Resource: "serviceaccounts", Verbs: []string{"get", "create", "update"}},
+	{Resource: "namespaces", Verbs: []string{"get", "list", "create", "patch"}},
+	{Group: "rbac.authorization.k8s.io", Resource: "clusterroles", Verbs: []string{"get", "create", "update"}},
+	{Group: "rbac.authorization.k8s.io", Resource: "clusterrolebindings", Verbs: []string{"get", "create", "update"}},
+}
+
+// CRDs returns a required check that the named CustomResourceDefinitions are installed and established.
+func CRDs(c client.Client, names ...string) Check {
+	return Check{Name: "crds", Required: true, Run: func(ctx context.Context) error {
+		var problems []string
+		for _, name := range names {
+			crd := &extv1.CustomResourceDefinition{}
+			if err := c.Get(ctx, client.ObjectKey{Name: name}, crd); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", name, err))
+				continue
+			}
+			established := false
+			for _, cond := range crd.Status.Conditions {
+				if cond.Type == extv1.Established && cond.Status == extv1.ConditionTrue {
+					established = true
+				}
+			}
+			if !established {
+				problems = append(problems, fmt.Sprintf("%s is not established", name))
+			}
+		}
+		if len(problems) > 0 {
+			return fmt.Errorf("%s", strings.Join(problems, "; "))
+		}
+		return nil
+	}}
+}
+
+// RBAC returns a check that the operator has the given permissions, by reviewing each with a
+// SelfSubjectAccessReview across all namespaces. It reports every one that's denied.
+func RBAC(c client.Client, permissions []Permission) Check {
+	return Check{Name: "rbac", Run: func(ctx context.Context) error {
+		var denied []string
+		for _, p := range permissions {
+			for _, verb := range p.Verbs {
+				review := &authorizationv1.SelfSubjectAccessReview{
+					Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+						ResourceAttributes: &authorizationv1.ResourceAttributes{Group: p.Group, Verb: verb, Name: p.Name},
+					},
+				}
+				review.Spec.ResourceAttributes.Resource, review.Spec.ResourceAttributes.Subresource = p.Resource, ""
+				if idx := strings.Index(p.Resource, "/"); idx >= 0 {
+					review.Spec.ResourceAttributes.Resource, review.Spec.ResourceAttributes.Subresource = p.Resource[:idx], p.Resource[idx+1:]
+				}
+				if err := c.Create(ctx, review); err != nil {
+					return fmt.Errorf("failed to review access: %w", err)
+				}
+				if !review.Status.Allowed {
+					denied = append(denied, fmt.Sprintf("%s %s", verb, p.String()))
+				}
+			}
+		}
+		if len(denied) > 0 {
+			return fmt.Errorf("missing permissions: %s", strings.Join(denied, ", "))
+		}
+		return nil
+	}}
+}
+
+func (p Permission) String() string {
+	s := p.Resource
+	if p.Group != "" {
+		s += "." + p.Group
+	}
+	if p.Name != "" {
+		s += "/" + p.Name
+	}
+	return s
+}
+
+// DNS returns a check that each of the given hosts resolves.
+func DNS(hosts ...string) Check {
+	return Check{Name: "dns", Run: func(ctx context.Context) error {
+		var problems []string
+		for _, host := range hosts {
+			if _, err := net.DefaultResolver.LookupHost(ctx, host); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", host, err))
+			}
+		}
+		if len(problems) > 0 {
+			return fmt.Errorf("failed to resolve %s", strings.Join(problems, "; "))
+		}
+		return nil
+	}}
+}
+
+// ClusterDNSHost is resolved by the cluster's DNS wherever the operator runs.
+const ClusterDNSHost = "kubernetes.default.svc.cluster.local"
+
+// APIHosts returns the hosts that must resolve for Control and Catalog to be reached: the cluster's DNS, which resolves
+// their Services (they're created by the operator, so don't resolve before a mesh is first installed), and the hosts
+// of the Catalog API, the API login, and other zones' Control APIs, if they're configured.
+func APIHosts(config cuemodule.Config, mesh *v1alpha1.Mesh) []string {
+	hosts := []string{ClusterDNSHost}
+	seen := map[string]bool{ClusterDNSHost: true}
+	add := func(rawURL string) {
+		u, err := url.Parse(rawURL)
+		if err != nil || u.Hostname() == "" || seen[u.Hostname()] {
+			return
+		}
+		seen[u.Hostname()] = true
+		hosts = append(hosts, u.Hostname())
+	}
+	add(config.CatalogAPI.URL)
+	add(config.APIAuth.TokenURL)
+	if mesh != nil {
+		for _, zone := range mesh.Spec.Zones {
+			add(zone.APIURL)
+		}
+	}
+	return hosts
+}
@@ -0,0 +1,119 @@
+// Package preflight checks everything the operator depends on before it starts, so every problem is reported at
+// once instead of surfacing piecemeal as the operator runs into them.
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+var (
+	logger = ctrl.Log.WithName("preflight")
+)
+
+// How long each check may take.
+const checkTimeout = 15 * time.Second
+
+// Policies for failed checks that aren't required.
+const (
+	// PolicyStrict refuses to start if any check fails.
+	PolicyStrict = "strict"
+	// PolicyDegrade starts unless a required check fails, logging the others' failures. The operator then runs
+	// degraded, e.g. keeping its state in memory until Redis is reachable.
+	PolicyDegrade = "degrade"
+)
+
+// Statuses of a check's Result.
+const (
+	StatusPassed  = "passed"
+	StatusFailed  = "failed"
+	StatusSkipped = "skipped"
+)
+
+// Check is something the operator depends on.
+type Check struct {
+	Name string
+	// Whether the operator can't start without it, whatever the policy.
+	Required bool
+	// Names of earlier checks that must pass for it to be run; it's skipped otherwise.
+	After []string
+	Run   func(ctx context.Context) error
+}
+
+// Result is the outcome of a Check.
+type Result struct {
+	Name     string `json:"name"`
+	Required bool   `json:"required"`
+	// StatusPassed, StatusFailed, or StatusSkipped
+	Status  string `json:"status"`
+	Problem string `json:"problem,omitempty"`
+}
+
+// Report is the outcome of every check, in the order they were run.
+type Report []Result
+
+// Run runs the checks in order, giving each checkTimeout to finish if it heeds its context, and returns their results.
+func Run(ctx context.Context, checks ...Check) Report {
+	report := make(Report, 0, len(checks))
+	passed := make(map[string]bool)
+	for _, check := range checks {
+		result := Result{Name: check.Name, Required: check.Required, Status: StatusPassed}
+		for _, dep := range check.After {
+			if !passed[dep] {
+				result.Status, result.Problem = StatusSkipped, fmt.Sprintf("%s did not pass", dep)
+				break
+			}
+		}
+		if result.Status == StatusPassed {
+			checkCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+			if err := check.Run(checkCtx); err != nil {
+				result.Status, result.Problem = StatusFailed, err.Error()
+			}
+			cancel()
+		}
+		passed[check.Name] = result.Status == StatusPassed
+		report = append(report, result)
+	}
+	return report
+}
+
+// Log logs the report as a single entry, with an error if any check failed.
+func (r Report) Log() {
+	summary := make(map[string]string, len(r))
+	for _, result := range r {
+		summary[result.Name] = result.Status
+		if result.Problem != "" {
+			summary[result.Name] += ": " + result.Problem
+		}
+	}
+	if r.failures(false) == nil {
+		logger.Info("Preflight checks passed", "Checks", summary)
+		return
+	}
+	logger.Error(fmt.Errorf("%d preflight checks did not pass", len(r.failures(false))), "Preflight report", "Checks", summary)
+}
+
+// Err returns an error listing every check that stops the operator from starting under the policy: any that failed
+// or was skipped with PolicyStrict, or only required ones with PolicyDegrade.
+func (r Report) Err(policy string) error {
+	failures := r.failures(policy != PolicyStrict)
+	if len(failures) == 0 {
+		return nil
+	}
+	return fmt.Errorf("preflight checks failed:\n  %s", strings.Join(failures, "\n  "))
+}
+
+func (r Report) failures(requiredOnly bool) []string {
+	var failures []string
+	for _, result := range r {
+		if result.Status == StatusPassed || (requiredOnly && !result.Required) {
+			continue
+		}
+		failures = append(failures, fmt.Sprintf("%s (%s): %s", result.Name, result.Status, result.Problem))
+	}
+	return failures
+}
@@ -0,0 +1,111 @@
+package preflight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	"github.com/stretchr/testify/assert"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	extv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestRun(t *testing.T) {
+	pass := func(context.Context) error { return nil }
+	fail := func(context.Context) error { return errors.New("unreachable") }
+
+	report := Run(context.Background(),
+		Check{Name: "crds", Required: true, Run: pass},
+		Check{Name: "git", Run: fail},
+		Check{Name: "checkout", Required: true, After: []string{"git"}, Run: pass},
+		Check{Name: "dns", After: []string{"crds"}, Run: pass},
+	)
+	assert.Equal(t, Report{
+		{Name: "crds", Required: true, Status: StatusPassed},
+		{Name: "git", Status: StatusFailed, Problem: "unreachable"},
+		{Name: "checkout", Required: true, Status: StatusSkipped, Problem: "git did not pass"},
+		{Name: "dns", Status: StatusPassed},
+	}, report)
+
+	err := report.Err(PolicyStrict)
+	assert.Equal(t, "preflight checks failed:\n  git (failed): unreachable\n  checkout (skipped): git did not pass", fmt.Sprint(err))
+	err = report.Err(PolicyDegrade)
+	assert.Equal(t, "preflight checks failed:\n  checkout (skipped): git did not pass", fmt.Sprint(err))
+
+	report = Run(context.Background(), Check{Name: "crds", Required: true, Run: pass}, Check{Name: "git", Run: fail})
+	assert.Error(t, report.Err(PolicyStrict))
+	assert.NoError(t, report.Err(PolicyDegrade))
+}
+
+func TestCRDs(t *testing.T) {
+	scheme := runtime.NewScheme()
+	assert.NoError(t, extv1.AddToScheme(scheme))
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&extv1.CustomResourceDefinition{
+			ObjectMeta: metav1.ObjectMeta{Name: "meshes.greymatter.io"},
+			Status: extv1.CustomResourceDefinitionStatus{Conditions: []extv1.CustomResourceDefinitionCondition{
+				{Type: extv1.Established, Status: extv1.ConditionTrue},
+			}},
+		},
+		&extv1.CustomResourceDefinition{ObjectMeta: metav1.ObjectMeta{Name: "pending.greymatter.io"}},
+	).Build()
+
+	check := CRDs(c, "meshes.greymatter.io")
+	assert.True(t, check.Required)
+	assert.NoError(t, check.Run(context.Background()))
+
+	err := CRDs(c, "meshes.greymatter.io", "pending.greymatter.io", "missing.greymatter.io").Run(context.Background())
+	assert.Contains(t, fmt.Sprint(err), "pending.greymatter.io is not established")
+	assert.Contains(t, fmt.Sprint(err), "missing.greymatter.io: ")
+}
+
+// reviewClient allows access to everything but the denied resources.
+type reviewClient struct {
+	client.Client
+	denied map[string]bool
+}
+
+func (c reviewClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	review := obj.(*authorizationv1.SelfSubjectAccessReview)
+	attrs := review.Spec.ResourceAttributes
+	review.Status.Allowed = !c.denied[attrs.Verb+" "+attrs.Resource+"/"+attrs.Subresource]
+	return nil
+}
+
+func TestRBAC(t *testing.T) {
+	permissions := []Permission{
+		{Group: "greymatter.io", Resource: "meshes", Verbs: []string{"get", "watch"}},
+		{Group: "greymatter.io", Resource: "meshes/status", Verbs: []string{"update"}},
+		{Group: "admissionregistration.k8s.io", Resource: "mutatingwebhookconfigurations", Name: "gm-mutate-config", Verbs: []string{"patch"}},
+	}
+	assert.NoError(t, RBAC(reviewClient{}, permissions).Run(context.Background()))
+
+	c := reviewClient{denied: map[string]bool{
+		"watch meshes/":                        true,
+		"update meshes/status":                 true,
+		"patch mutatingwebhookconfigurations/": true,
+	}}
+	err := RBAC(c, permissions).Run(context.Background())
+	assert.Equal(t, "missing permissions: watch meshes.greymatter.io, update meshes/status.greymatter.io, "+
+		"patch mutatingwebhookconfigurations.admissionregistration.k8s.io/gm-mutate-config", fmt.Sprint(err))
+}
+
+func TestAPIHosts(t *testing.T) {
+	config := cuemodule.Config{
+		CatalogAPI: cuemodule.CatalogAPIConfig{URL: "https://catalog.example.com:8443"},
+		APIAuth:    cuemodule.APIAuthConfig{TokenURL: "https://login.example.com/oauth2/token"},
+	}
+	mesh := &v1alpha1.Mesh{Spec: v1alpha1.MeshSpec{Zones: []v1alpha1.Zone{
+		{Name: "east", APIURL: "http://control.east.example.com:5555"},
+		{Name: "west"},
+	}}}
+	assert.Equal(t, []string{ClusterDNSHost, "catalog.example.com", "login.example.com", "control.east.example.com"}, APIHosts(config, mesh))
+	assert.Equal(t, []string{ClusterDNSHost}, APIHosts(cuemodule.Config{}, nil))
+}
@@ -0,0 +1,167 @@
+// Package sealedsecrets encrypts Secrets into SealedSecrets for the Bitnami sealed-secrets controller,
+// so private keys generated by the operator are only written to the apiserver encrypted.
+// The format matches kubeseal's, using the controller's public key with strict (namespace and name) scope.
+package sealedsecrets
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	// DefaultControllerNamespace and DefaultControllerService are where the sealed-secrets controller is
+	// installed by default.
+	DefaultControllerNamespace = "kube-system"
+	DefaultControllerService   = "sealed-secrets-controller"
+	// The most of a certificate read from the controller
+	maxCertBytes = 64 << 10
+
+	sessionKeyBytes = 32
+)
+
+// ParsePublicKey returns the RSA public key in a PEM-encoded certificate of the sealed-secrets controller.
+func ParsePublicKey(certPEM []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("no PEM-encoded certificate found")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	pubKey, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("expected an RSA public key, found %T", cert.PublicKey)
+	}
+	return pubKey, nil
+}
+
+// Seal returns a SealedSecret that the sealed-secrets controller decrypts into the given Secret.
+// Values in StringData are sealed along with Data.
+func Seal(pubKey *rsa.PublicKey, secret *corev1.Secret) (*unstructured.Unstructured, error) {
+	values := make(map[string][]byte)
+	for k, v := range secret.Data {
+		values[k] = v
+	}
+	for k, v := range secret.StringData {
+		values[k] = []byte(v)
+	}
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	label := []byte(fmt.Sprintf("%s/%s", secret.Namespace, secret.Name))
+	encryptedData := make(map[string]interface{}, len(values))
+	for _, k := range keys {
+		ciphertext, err := HybridEncrypt(pubKey, values[k], label)
+		if err != nil {
+			return nil, fmt.Errorf("failed to seal %s: %w", k, err)
+		}
+		encryptedData[k] = base64.StdEncoding.EncodeToString(ciphertext)
+	}
+
+	metadata := map[string]interface{}{
+		"name":      secret.Name,
+		"namespace": secret.Namespace,
+	}
+	if len(secret.Labels) > 0 {
+		labels := make(map[string]interface{}, len(secret.Labels))
+		for k, v := range secret.Labels {
+			labels[k] = v
+		}
+		metadata["labels"] = labels
+	}
+	template := map[string]interface{}{"metadata": metadata}
+	if secret.Type != "" {
+		template["type"] = string(secret.Type)
+	}
+
+	sealed := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "bitnami.com/v1alpha1",
+		"kind":       "SealedSecret",
+		"metadata": map[string]interface{}{
+			"name":      secret.Name,
+			"namespace": secret.Namespace,
+		},
+		"spec": map[string]interface{}{
+			"encryptedData": encryptedData,
+			"template":      template,
+		},
+	}}
+	return sealed, nil
+}
+
+// HybridEncrypt encrypts plaintext with a random AES-256-GCM session key, itself encrypted with RSA-OAEP using
+// the given public key and label. The output is the encrypted session key's length as two big-endian bytes,
+// the encrypted session key, and the encrypted plaintext.
+func HybridEncrypt(pubKey *rsa.PublicKey, plaintext, label []byte) ([]byte, error) {
+	sessionKey := make([]byte, sessionKeyBytes)
+	if _, err := rand.Read(sessionKey); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	aed, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	rsaCiphertext, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pubKey, sessionKey, label)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 2, 2+len(rsaCiphertext)+len(plaintext)+aed.Overhead())
+	binary.BigEndian.PutUint16(out, uint16(len(rsaCiphertext)))
+	out = append(out, rsaCiphertext...)
+	// The session key is only ever used once, so a zero nonce is safe
+	zeroNonce := make([]byte, aed.NonceSize())
+	return aed.Seal(out, zeroNonce, plaintext, nil), nil
+}
+
+// CertURL is where the sealed-secrets controller's Service in the given namespace serves the certificate of its
+// active key, as kubeseal --fetch-cert reads it.
+func CertURL(namespace, service string) string {
+	return fmt.Sprintf("http://%s.%s.svc:8080/v1/cert.pem", service, namespace)
+}
+
+// FetchPublicKey returns the public key in the certificate the sealed-secrets controller serves at the given URL.
+func FetchPublicKey(ctx context.Context, c *http.Client, url string) (*rsa.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	certPEM, err := io.ReadAll(io.LimitReader(resp.Body, maxCertBytes))
+	if err != nil {
+		return nil, err
+	}
+	return ParsePublicKey(certPEM)
+}
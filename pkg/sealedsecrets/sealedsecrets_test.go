@@ -0,0 +1,116 @@
+package sealedsecrets
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestSeal(t *testing.T) {
+	key, certPEM := mkKeyPair(t)
+	pubKey, err := ParsePublicKey(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "server-ca", Namespace: "spire", Labels: map[string]string{"app": "spire"}},
+		Type:       corev1.SecretTypeOpaque,
+		Data:       map[string][]byte{"root.crt": []byte("root")},
+		StringData: map[string]string{"intermediate.key": "key"},
+	}
+	sealed, err := Seal(pubKey, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, "SealedSecret", sealed.GetKind())
+	assert.Equal(t, "spire", sealed.GetNamespace())
+	assert.Equal(t, "server-ca", sealed.GetName())
+	labels, _, _ := unstructured.NestedStringMap(sealed.Object, "spec", "template", "metadata", "labels")
+	assert.Equal(t, map[string]string{"app": "spire"}, labels)
+
+	encryptedData, _, _ := unstructured.NestedStringMap(sealed.Object, "spec", "encryptedData")
+	assert.Len(t, encryptedData, 2)
+	for k, want := range map[string]string{"root.crt": "root", "intermediate.key": "key"} {
+		ciphertext, err := base64.StdEncoding.DecodeString(encryptedData[k])
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, want, string(hybridDecrypt(t, key, ciphertext, []byte("spire/server-ca"))))
+	}
+}
+
+func TestFetchPublicKey(t *testing.T) {
+	key, cert := mkKeyPair(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/cert.pem" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(cert)
+	}))
+	defer server.Close()
+
+	pubKey, err := FetchPublicKey(context.TODO(), server.Client(), server.URL+"/v1/cert.pem")
+	if assert.NoError(t, err) {
+		assert.Equal(t, &key.PublicKey, pubKey)
+	}
+	_, err = FetchPublicKey(context.TODO(), server.Client(), server.URL+"/v1/verify")
+	assert.Error(t, err)
+
+	assert.Equal(t, "http://sealed-secrets-controller.kube-system.svc:8080/v1/cert.pem",
+		CertURL(DefaultControllerNamespace, DefaultControllerService))
+}
+
+func mkKeyPair(t *testing.T) (*rsa.PrivateKey, []byte) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{SerialNumber: big.NewInt(1), NotBefore: time.Now(), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// hybridDecrypt reverses HybridEncrypt as the sealed-secrets controller does.
+func hybridDecrypt(t *testing.T, key *rsa.PrivateKey, ciphertext, label []byte) []byte {
+	rsaLen := int(binary.BigEndian.Uint16(ciphertext))
+	sessionKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, key, ciphertext[2:2+rsaLen], label)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := aes.NewCipher(sessionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aed, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := aed.Open(nil, make([]byte, aed.NonceSize()), ciphertext[2+rsaLen:], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return plaintext
+}
@@ -0,0 +1,214 @@
+// Package stateredis deploys a small Redis in the gm-operator namespace for the operator's own sync state, so simple
+// installs don't need an external Redis in defaults.redis_host. Its password is generated once and kept in a Secret,
+// and its data is kept on a PersistentVolumeClaim of the configured size, so state outlives restarts of either.
+package stateredis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/greymatter-io/operator/pkg/k8sapi"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var logger = ctrl.Log.WithName("stateredis")
+
+const (
+	// Namespace is where the managed Redis runs.
+	Namespace = "gm-operator"
+	// Name of the managed Redis's StatefulSet and Service.
+	Name = "gm-operator-redis"
+	// PasswordSecretName is the Secret with the generated password, under PasswordKey.
+	PasswordSecretName = "gm-operator-redis-password"
+	PasswordKey        = "password"
+	// Port the managed Redis listens on.
+	Port = 6379
+
+	// Used if Options.Image or Options.Storage is unset.
+	DefaultImage   = "docker.io/library/redis:6.2-alpine"
+	DefaultStorage = "1Gi"
+
+	// The name of the StatefulSet's volume claim template, mounted where the image keeps its data.
+	dataVolume = "data"
+	dataPath   = "/data"
+)
+
+// Options configures the managed Redis.
+type Options struct {
+	Image string
+	// Size of the PersistentVolumeClaim, e.g. "1Gi"
+	Storage string
+	// StorageClass of the PersistentVolumeClaim; the cluster's default if unset
+	StorageClass string
+}
+
+// Host returns the in-cluster host name of the managed Redis.
+func Host() string {
+	return fmt.Sprintf("%s.%s.svc.cluster.local", Name, Namespace)
+}
+
+// Apply ensures the managed Redis's password Secret exists, generating a password if it doesn't, and creates or
+// updates its Service and StatefulSet. It returns the password. The StatefulSet's volume claim template can't be
+// changed once created, so a PersistentVolumeClaim is resized by editing it, where its StorageClass allows.
+func Apply(ctx context.Context, c k8sapi.Applier, opts Options) (string, error) {
+	if opts.Image == "" {
+		opts.Image = DefaultImage
+	}
+	if opts.Storage == "" {
+		opts.Storage = DefaultStorage
+	}
+	storage, err := resource.ParseQuantity(opts.Storage)
+	if err != nil {
+		return "", fmt.Errorf("invalid managed Redis storage %q: %w", opts.Storage, err)
+	}
+
+	password, err := ensurePassword(ctx, c)
+	if err != nil {
+		return "", err
+	}
+
+	service := mkService()
+	if err := k8sapi.Apply(ctx, c, service, nil, k8sapi.CreateOrUpdateIfChanged); err != nil {
+		return "", fmt.Errorf("failed to apply managed Redis Service: %w", err)
+	}
+
+	statefulset := mkStatefulSet(opts, storage)
+	live := &appsv1.StatefulSet{}
+	if err := c.Get(ctx, client.ObjectKeyFromObject(statefulset), live); err == nil {
+		if liveStorage := claimStorage(live); liveStorage.Cmp(storage) != 0 {
+			logger.Info("Managed Redis storage differs from its existing volume claim template, which can't be changed; resize its PersistentVolumeClaim instead",
+				"requested", storage.String(), "template", liveStorage.String())
+		}
+		statefulset.Spec.VolumeClaimTemplates = live.Spec.VolumeClaimTemplates
+	} else if !errors.IsNotFound(err) {
+		return "", fmt.Errorf("failed to get managed Redis StatefulSet: %w", err)
+	}
+	if err := k8sapi.Apply(ctx, c, statefulset, nil, k8sapi.CreateOrUpdateIfChanged); err != nil {
+		return "", fmt.Errorf("failed to apply managed Redis StatefulSet: %w", err)
+	}
+	return password, nil
+}
+
+// ensurePassword returns the password in the managed Redis's Secret, creating the Secret with a generated one if it
+// doesn't exist yet.
+func ensurePassword(ctx context.Context, c k8sapi.Applier) (string, error) {
+	secret := &corev1.Secret{}
+	err := c.Get(ctx, client.ObjectKey{Namespace: Namespace, Name: PasswordSecretName}, secret)
+	if err == nil && len(secret.Data[PasswordKey]) > 0 {
+		return string(secret.Data[PasswordKey]), nil
+	}
+	if err != nil && !errors.IsNotFound(err) {
+		return "", fmt.Errorf("failed to get managed Redis password: %w", err)
+	}
+
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate managed Redis password: %w", err)
+	}
+	password := hex.EncodeToString(b)
+	secret = &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: PasswordSecretName, Namespace: Namespace, Labels: labels()},
+		Type:       corev1.SecretTypeOpaque,
+		Data:       map[string][]byte{PasswordKey: []byte(password)},
+	}
+	// Replaces a Secret without the key, so the Redis and the operator agree on the password
+	if err := k8sapi.Apply(ctx, c, secret, nil, k8sapi.CreateOrUpdate); err != nil {
+		return "", fmt.Errorf("failed to apply managed Redis password: %w", err)
+	}
+	return password, nil
+}
+
+func labels() map[string]string {
+	return map[string]string{"app.kubernetes.io/name": Name, "app.kubernetes.io/managed-by": "gm-operator"}
+}
+
+func mkService() *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: Name, Namespace: Namespace, Labels: labels()},
+		Spec: corev1.ServiceSpec{
+			Selector: labels(),
+			Ports: []corev1.ServicePort{{
+				Name:       "redis",
+				Port:       Port,
+				TargetPort: intstr.FromInt(Port),
+				Protocol:   corev1.ProtocolTCP,
+			}},
+		},
+	}
+}
+
+func mkStatefulSet(opts Options, storage resource.Quantity) *appsv1.StatefulSet {
+	replicas := int32(1)
+	claim := corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: dataVolume},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: storage},
+			},
+		},
+	}
+	if opts.StorageClass != "" {
+		claim.Spec.StorageClassName = &opts.StorageClass
+	}
+	return &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: Name, Namespace: Namespace, Labels: labels()},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas:    &replicas,
+			ServiceName: Name,
+			Selector:    &metav1.LabelSelector{MatchLabels: labels()},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels()},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{
+						Name:  "redis",
+						Image: opts.Image,
+						// Appending to its log on every write keeps state changes since the last snapshot
+						Args: []string{"redis-server", "--appendonly", "yes", "--requirepass", "$(REDIS_PASSWORD)"},
+						Env: []corev1.EnvVar{{
+							Name: "REDIS_PASSWORD",
+							ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+								LocalObjectReference: corev1.LocalObjectReference{Name: PasswordSecretName},
+								Key:                  PasswordKey,
+							}},
+						}},
+						Ports: []corev1.ContainerPort{{Name: "redis", ContainerPort: Port}},
+						ReadinessProbe: &corev1.Probe{
+							ProbeHandler: corev1.ProbeHandler{TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromInt(Port)}},
+						},
+						Resources: corev1.ResourceRequirements{
+							Requests: corev1.ResourceList{
+								corev1.ResourceCPU:    resource.MustParse("50m"),
+								corev1.ResourceMemory: resource.MustParse("64Mi"),
+							},
+							Limits: corev1.ResourceList{
+								corev1.ResourceMemory: resource.MustParse("256Mi"),
+							},
+						},
+						VolumeMounts: []corev1.VolumeMount{{Name: dataVolume, MountPath: dataPath}},
+					}},
+				},
+			},
+			VolumeClaimTemplates: []corev1.PersistentVolumeClaim{claim},
+		},
+	}
+}
+
+// claimStorage returns the storage requested by a managed Redis StatefulSet's volume claim template.
+func claimStorage(statefulset *appsv1.StatefulSet) resource.Quantity {
+	for _, claim := range statefulset.Spec.VolumeClaimTemplates {
+		if claim.Name == dataVolume {
+			return claim.Spec.Resources.Requests[corev1.ResourceStorage]
+		}
+	}
+	return resource.Quantity{}
+}
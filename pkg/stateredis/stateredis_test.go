@@ -0,0 +1,49 @@
+package stateredis
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestApply(t *testing.T) {
+	c := fake.NewClientBuilder().Build()
+
+	password, err := Apply(context.TODO(), c, Options{StorageClass: "fast"})
+	assert.NoError(t, err)
+	assert.Len(t, password, 48)
+
+	secret := &corev1.Secret{}
+	assert.NoError(t, c.Get(context.TODO(), client.ObjectKey{Namespace: Namespace, Name: PasswordSecretName}, secret))
+	assert.Equal(t, password, string(secret.Data[PasswordKey]))
+
+	service := &corev1.Service{}
+	assert.NoError(t, c.Get(context.TODO(), client.ObjectKey{Namespace: Namespace, Name: Name}, service))
+	assert.Equal(t, int32(Port), service.Spec.Ports[0].Port)
+
+	statefulset := &appsv1.StatefulSet{}
+	assert.NoError(t, c.Get(context.TODO(), client.ObjectKey{Namespace: Namespace, Name: Name}, statefulset))
+	assert.Equal(t, DefaultImage, statefulset.Spec.Template.Spec.Containers[0].Image)
+	assert.Equal(t, PasswordSecretName, statefulset.Spec.Template.Spec.Containers[0].Env[0].ValueFrom.SecretKeyRef.Name)
+	assert.Equal(t, "fast", *statefulset.Spec.VolumeClaimTemplates[0].Spec.StorageClassName)
+	storage := claimStorage(statefulset)
+	assert.Equal(t, "1Gi", storage.String())
+
+	// The password is kept, and the volume claim template isn't changed
+	again, err := Apply(context.TODO(), c, Options{Image: "redis:7", Storage: "5Gi"})
+	assert.NoError(t, err)
+	assert.Equal(t, password, again)
+	assert.NoError(t, c.Get(context.TODO(), client.ObjectKey{Namespace: Namespace, Name: Name}, statefulset))
+	assert.Equal(t, "redis:7", statefulset.Spec.Template.Spec.Containers[0].Image)
+	storage = claimStorage(statefulset)
+	assert.Equal(t, 0, storage.Cmp(resource.MustParse("1Gi")))
+
+	_, err = Apply(context.TODO(), c, Options{Storage: "lots"})
+	assert.Error(t, err)
+}
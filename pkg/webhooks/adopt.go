@@ -0,0 +1,74 @@
+package webhooks
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/greymatter-io/operator/pkg/gitops"
+	"github.com/greymatter-io/operator/pkg/mesh_install"
+	"github.com/greymatter-io/operator/pkg/wellknown"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// handleAdoption adopts a pre-existing Deployment or StatefulSet into the mesh if it's annotated to be, or rolls back
+// its adoption, removing the annotation either way. Adopting it annotates its pod template for sidecar injection and
+// configuration, which the rest of the request's handling and its rollout act on, and records what was added.
+// Rolling back removes what its adoption added, so the rest of the request's handling removes its sidecar
+// configuration and its pods are rolled out without sidecars. It returns why the workload can't be adopted or rolled back, if it can't.
+func (wd *workloadDefaulter) handleAdoption(req admission.Request, annotations map[string]string, tmpl *corev1.PodTemplateSpec) string {
+	value, ok := annotations[wellknown.ANNOTATION_ADOPT]
+	if !ok {
+		return ""
+	}
+	delete(annotations, wellknown.ANNOTATION_ADOPT)
+	kind := req.Kind.Kind
+	dryRun := req.DryRun != nil && *req.DryRun
+
+	if value == wellknown.ADOPT_ROLLBACK {
+		adopted, ok := wd.Adoption(kind, req.Namespace, req.Name)
+		if !ok {
+			return fmt.Sprintf("%s %s/%s was not adopted", kind, req.Namespace, req.Name)
+		}
+		if !dryRun {
+			wd.ForgetAdoption(kind, req.Namespace, req.Name)
+			logger.Info("Rolled back adoption", "kind", kind, "name", req.Name, "namespace", req.Namespace)
+		}
+		for _, key := range adopted.Annotations {
+			delete(tmpl.Annotations, key)
+		}
+		return ""
+	}
+
+	if err := mesh_install.ValidateAdoptionPort(value); err != nil {
+		return err.Error()
+	}
+	if _, ok := tmpl.Annotations[wellknown.ANNOTATION_INJECT_SIDECAR_TO_PORT]; ok {
+		return fmt.Sprintf("%s %s/%s already has a sidecar", kind, req.Namespace, req.Name)
+	}
+	if ok, reason := wd.InjectionAllowed(req.Namespace, tmpl.Labels, tmpl.Annotations); !ok {
+		return fmt.Sprintf("%s %s/%s is not eligible for sidecar injection: %s", kind, req.Namespace, req.Name, reason)
+	}
+
+	if tmpl.Annotations == nil {
+		tmpl.Annotations = make(map[string]string)
+	}
+	added := []string{wellknown.ANNOTATION_INJECT_SIDECAR_TO_PORT}
+	tmpl.Annotations[wellknown.ANNOTATION_INJECT_SIDECAR_TO_PORT] = value
+	if _, ok := tmpl.Annotations[wellknown.ANNOTATION_CONFIGURE_SIDECAR]; !ok {
+		tmpl.Annotations[wellknown.ANNOTATION_CONFIGURE_SIDECAR] = "true"
+		added = append(added, wellknown.ANNOTATION_CONFIGURE_SIDECAR)
+	}
+	if !dryRun {
+		wd.RecordAdoption(gitops.AdoptedWorkload{
+			Kind:        kind,
+			Namespace:   req.Namespace,
+			Name:        req.Name,
+			Port:        value,
+			Annotations: added,
+			Adopted:     time.Now().UTC(),
+		})
+		logger.Info("Adopted workload", "kind", kind, "name", req.Name, "namespace", req.Namespace, "port", value)
+	}
+	return ""
+}
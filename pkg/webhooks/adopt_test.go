@@ -0,0 +1,64 @@
+package webhooks
+
+import (
+	"testing"
+
+	"github.com/greymatter-io/operator/pkg/gitops"
+	"github.com/greymatter-io/operator/pkg/mesh_install"
+	"github.com/greymatter-io/operator/pkg/wellknown"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestHandleAdoption(t *testing.T) {
+	wd := &workloadDefaulter{
+		Installer: &mesh_install.Installer{
+			K8sClient: fake.NewClientBuilder().Build(),
+			Sync:      &gitops.Sync{SyncState: &gitops.SyncState{}},
+		},
+	}
+	req := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Kind:      metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"},
+		Namespace: "apps",
+		Name:      "web",
+	}}
+
+	// Not annotated for adoption
+	annotations := map[string]string{}
+	tmpl := &corev1.PodTemplateSpec{}
+	assert.Empty(t, wd.handleAdoption(req, annotations, tmpl))
+	assert.Empty(t, wd.AdoptedWorkloads())
+
+	annotations[wellknown.ANNOTATION_ADOPT] = "8080"
+	tmpl.Annotations = map[string]string{wellknown.ANNOTATION_CONFIGURE_SIDECAR: "false", "team": "payments"}
+	assert.Empty(t, wd.handleAdoption(req, annotations, tmpl))
+	assert.NotContains(t, annotations, wellknown.ANNOTATION_ADOPT)
+	assert.Equal(t, "8080", tmpl.Annotations[wellknown.ANNOTATION_INJECT_SIDECAR_TO_PORT])
+	adopted, ok := wd.Adoption("Deployment", "apps", "web")
+	assert.True(t, ok)
+	// Only what was added is recorded, so only that's removed on rollback
+	assert.Equal(t, []string{wellknown.ANNOTATION_INJECT_SIDECAR_TO_PORT}, adopted.Annotations)
+
+	annotations[wellknown.ANNOTATION_ADOPT] = "8080"
+	assert.Contains(t, wd.handleAdoption(req, annotations, tmpl), "already has a sidecar")
+
+	annotations[wellknown.ANNOTATION_ADOPT] = wellknown.ADOPT_ROLLBACK
+	assert.Empty(t, wd.handleAdoption(req, annotations, tmpl))
+	assert.Equal(t, map[string]string{wellknown.ANNOTATION_CONFIGURE_SIDECAR: "false", "team": "payments"}, tmpl.Annotations)
+	_, ok = wd.Adoption("Deployment", "apps", "web")
+	assert.False(t, ok)
+
+	annotations[wellknown.ANNOTATION_ADOPT] = wellknown.ADOPT_ROLLBACK
+	assert.Contains(t, wd.handleAdoption(req, annotations, tmpl), "was not adopted")
+
+	annotations[wellknown.ANNOTATION_ADOPT] = "http"
+	assert.Contains(t, wd.handleAdoption(req, annotations, tmpl), "port must be a port number")
+
+	req.Namespace = "kube-system"
+	annotations[wellknown.ANNOTATION_ADOPT] = "8080"
+	assert.Contains(t, wd.handleAdoption(req, annotations, tmpl), "not eligible for sidecar injection")
+}
@@ -0,0 +1,241 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/greymatter-io/operator/pkg/k8sapi"
+	"github.com/greymatter-io/operator/pkg/wellknown"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// The name of the API spec loop in config.reconcile.disabled.
+	reconcilerCatalogAPISpecs = "catalog_api_specs"
+	// How often workloads' API specs are fetched again, unless config.reconcile.interval_seconds is set.
+	apiSpecPollInterval = 5 * time.Minute
+	// The key of an API spec ConfigMap read if the annotation doesn't name one.
+	defaultAPISpecKey = "openapi.yaml"
+	// The most of an API spec read from its URL.
+	maxAPISpecBytes = 10 << 20
+)
+
+// apiSpecHTTPClient fetches API specs from their URLs.
+var apiSpecHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// apiSpecInfo is what Catalog is told about a workload from its OpenAPI (or Swagger) spec.
+type apiSpecInfo struct {
+	Description string
+	Version     string
+}
+
+// parseAPISpec reads the info of an OpenAPI or Swagger spec in YAML or JSON.
+func parseAPISpec(raw []byte) (apiSpecInfo, error) {
+	var spec struct {
+		OpenAPI string `json:"openapi"`
+		Swagger string `json:"swagger"`
+		Info    struct {
+			Description string `json:"description"`
+			Version     string `json:"version"`
+		} `json:"info"`
+	}
+	if err := yaml.Unmarshal(raw, &spec); err != nil {
+		return apiSpecInfo{}, fmt.Errorf("failed to parse API spec: %w", err)
+	}
+	if spec.OpenAPI == "" && spec.Swagger == "" {
+		return apiSpecInfo{}, fmt.Errorf("API spec has neither an openapi nor a swagger version")
+	}
+	return apiSpecInfo{
+		Description: strings.TrimSpace(spec.Info.Description),
+		Version:     spec.Info.Version,
+	}, nil
+}
+
+// fetchAPISpec returns a workload's API spec, fetched from the URL in its catalog-api-spec annotation or read from
+// the ConfigMap named by its catalog-api-spec-configmap annotation. It returns nil if neither is set.
+func fetchAPISpec(c client.Reader, namespace string, annotations map[string]string) ([]byte, error) {
+	specURL, hasURL := annotations[wellknown.ANNOTATION_CATALOG_API_SPEC]
+	ref, hasRef := annotations[wellknown.ANNOTATION_CATALOG_API_SPEC_CONFIGMAP]
+	switch {
+	case hasURL && hasRef:
+		return nil, fmt.Errorf("only one of the %s and %s annotations may be set",
+			wellknown.ANNOTATION_CATALOG_API_SPEC, wellknown.ANNOTATION_CATALOG_API_SPEC_CONFIGMAP)
+	case hasURL:
+		return fetchAPISpecURL(specURL)
+	case !hasRef:
+		return nil, nil
+	}
+
+	name, key := ref, defaultAPISpecKey
+	if idx := strings.Index(ref, "/"); idx >= 0 {
+		name, key = ref[:idx], ref[idx+1:]
+	}
+	cm := &corev1.ConfigMap{}
+	if err := c.Get(context.TODO(), client.ObjectKey{Namespace: namespace, Name: name}, cm); err != nil {
+		return nil, fmt.Errorf("failed to get API spec ConfigMap %s/%s: %w", namespace, name, err)
+	}
+	spec, ok := cm.Data[key]
+	if !ok {
+		return nil, fmt.Errorf("API spec ConfigMap %s/%s has no key %q", namespace, name, key)
+	}
+	return []byte(spec), nil
+}
+
+func fetchAPISpecURL(specURL string) ([]byte, error) {
+	resp, err := apiSpecHTTPClient.Get(specURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch API spec from %s: %w", specURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch API spec from %s: %s", specURL, resp.Status)
+	}
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, maxAPISpecBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API spec from %s: %w", specURL, err)
+	}
+	return raw, nil
+}
+
+// apiSpecTracker records the hash of the API spec last applied to each workload's catalogservice, so specs are only
+// re-applied when they change.
+type apiSpecTracker struct {
+	mu     sync.Mutex
+	hashes map[string]string
+}
+
+func newAPISpecTracker() *apiSpecTracker {
+	return &apiSpecTracker{hashes: make(map[string]string)}
+}
+
+// changed reports whether the spec differs from the one last recorded for the workload.
+func (t *apiSpecTracker) changed(name string, raw []byte) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.hashes[name] != hashAPISpec(raw)
+}
+
+func (t *apiSpecTracker) record(name string, raw []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if raw == nil {
+		delete(t.hashes, name)
+		return
+	}
+	t.hashes[name] = hashAPISpec(raw)
+}
+
+func hashAPISpec(raw []byte) string {
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// withAPISpec returns a copy of a workload's annotations with the description and version of its API spec added as
+// its catalog annotations, unless they're set. The spec is recorded as applied to the workload; if it can't be
+// fetched or parsed, the annotations are returned as they are.
+func (wd *workloadDefaulter) withAPISpec(name, namespace string, annotations map[string]string) map[string]string {
+	raw, err := fetchAPISpec(wd.K8sClient, namespace, annotations)
+	if err != nil {
+		logger.Error(err, "Not adding API spec to Catalog", "name", name, "namespace", namespace)
+		return annotations
+	}
+	if raw == nil {
+		wd.apiSpecs.record(name, nil)
+		return annotations
+	}
+	info, err := parseAPISpec(raw)
+	if err != nil {
+		logger.Error(err, "Not adding API spec to Catalog", "name", name, "namespace", namespace)
+		return annotations
+	}
+	wd.apiSpecs.record(name, raw)
+
+	merged := make(map[string]string, len(annotations)+2)
+	for k, v := range annotations {
+		merged[k] = v
+	}
+	if _, ok := merged[wellknown.ANNOTATION_CATALOG_DESCRIPTION]; !ok && info.Description != "" {
+		merged[wellknown.ANNOTATION_CATALOG_DESCRIPTION] = info.Description
+	}
+	if _, ok := merged[wellknown.ANNOTATION_CATALOG_VERSION]; !ok && info.Version != "" {
+		merged[wellknown.ANNOTATION_CATALOG_VERSION] = info.Version
+	}
+	return merged
+}
+
+// refreshAPISpecs periodically fetches the API specs of the workloads in the mesh's watched namespaces, and requeues
+// the sidecar configuration of each one whose spec changed, until the context is cancelled.
+func (wd *workloadDefaulter) refreshAPISpecs(ctx context.Context) {
+	ticker := time.NewTicker(wd.Config.Reconcile.Interval(apiSpecPollInterval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		wd.refreshAPISpecsOnce(ctx)
+	}
+}
+
+func (wd *workloadDefaulter) refreshAPISpecsOnce(ctx context.Context) {
+	mesh := wd.CurrentMesh()
+	if mesh == nil || mesh.Name == "" || mesh.UID == "" {
+		return
+	}
+	namespaces := append([]string{mesh.Spec.InstallNamespace}, mesh.Spec.WatchNamespaces...)
+	for _, ns := range namespaces {
+		deployments := &appsv1.DeploymentList{}
+		if err := k8sapi.ListPages(ctx, wd.K8sClient, deployments, wd.Config.Reconcile.PageSize, func() error {
+			for _, d := range deployments.Items {
+				wd.refreshAPISpec(d.Name, ns, d.Spec.Template)
+			}
+			return nil
+		}, client.InNamespace(ns)); err != nil {
+			logger.Error(err, "Failed to list Deployments to refresh API specs", "namespace", ns)
+		}
+		statefulsets := &appsv1.StatefulSetList{}
+		if err := k8sapi.ListPages(ctx, wd.K8sClient, statefulsets, wd.Config.Reconcile.PageSize, func() error {
+			for _, s := range statefulsets.Items {
+				wd.refreshAPISpec(s.Name, ns, s.Spec.Template)
+			}
+			return nil
+		}, client.InNamespace(ns)); err != nil {
+			logger.Error(err, "Failed to list StatefulSets to refresh API specs", "namespace", ns)
+		}
+	}
+}
+
+// refreshAPISpec requeues a workload's sidecar configuration if it has a sidecar and its API spec has changed.
+func (wd *workloadDefaulter) refreshAPISpec(name, namespace string, tmpl corev1.PodTemplateSpec) {
+	annotations := tmpl.Annotations
+	if _, ok := annotations[wellknown.ANNOTATION_INJECT_SIDECAR_TO_PORT]; !ok {
+		return
+	}
+	if ok, _ := wd.InjectionAllowed(namespace, tmpl.Labels, annotations); !ok {
+		return
+	}
+	raw, err := fetchAPISpec(wd.K8sClient, namespace, annotations)
+	if err != nil {
+		logger.Error(err, "Failed to refresh API spec", "name", name, "namespace", namespace)
+		return
+	}
+	if raw == nil || !wd.apiSpecs.changed(name, raw) {
+		return
+	}
+	logger.Info("API spec changed, updating Catalog", "name", name, "namespace", namespace)
+	wd.sidecars.add(namespace, name, sidecarWork{
+		configure:   true,
+		annotations: withNamespaceEgress(wd.K8sClient, namespace, annotations),
+	})
+}
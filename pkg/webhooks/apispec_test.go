@@ -0,0 +1,94 @@
+package webhooks
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/greymatter-io/operator/pkg/mesh_install"
+	"github.com/greymatter-io/operator/pkg/wellknown"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestParseAPISpec(t *testing.T) {
+	info, err := parseAPISpec([]byte("openapi: 3.0.0\ninfo:\n  title: Example\n  description: |\n    An example API\n  version: 1.2.0\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, apiSpecInfo{Description: "An example API", Version: "1.2.0"}, info)
+
+	info, err = parseAPISpec([]byte(`{"swagger":"2.0","info":{"version":"v1"}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, apiSpecInfo{Version: "v1"}, info)
+
+	_, err = parseAPISpec([]byte("info: {version: v1}"))
+	assert.Contains(t, fmt.Sprint(err), "neither an openapi nor a swagger version")
+}
+
+func TestFetchAPISpec(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/openapi.json" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"openapi":"3.0.0"}`)
+	}))
+	defer server.Close()
+	c := fake.NewClientBuilder().WithObjects(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "example-api", Namespace: "apps"},
+		Data:       map[string]string{"openapi.yaml": "openapi: 3.0.0", "v2.yaml": "swagger: '2.0'"},
+	}).Build()
+
+	raw, err := fetchAPISpec(c, "apps", nil)
+	assert.NoError(t, err)
+	assert.Nil(t, raw)
+
+	raw, err = fetchAPISpec(c, "apps", map[string]string{wellknown.ANNOTATION_CATALOG_API_SPEC: server.URL + "/openapi.json"})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"openapi":"3.0.0"}`, string(raw))
+	_, err = fetchAPISpec(c, "apps", map[string]string{wellknown.ANNOTATION_CATALOG_API_SPEC: server.URL + "/missing.json"})
+	assert.Contains(t, fmt.Sprint(err), "404")
+
+	raw, err = fetchAPISpec(c, "apps", map[string]string{wellknown.ANNOTATION_CATALOG_API_SPEC_CONFIGMAP: "example-api"})
+	assert.NoError(t, err)
+	assert.Equal(t, "openapi: 3.0.0", string(raw))
+	raw, err = fetchAPISpec(c, "apps", map[string]string{wellknown.ANNOTATION_CATALOG_API_SPEC_CONFIGMAP: "example-api/v2.yaml"})
+	assert.NoError(t, err)
+	assert.Equal(t, "swagger: '2.0'", string(raw))
+	_, err = fetchAPISpec(c, "apps", map[string]string{wellknown.ANNOTATION_CATALOG_API_SPEC_CONFIGMAP: "example-api/missing.yaml"})
+	assert.Contains(t, fmt.Sprint(err), `has no key "missing.yaml"`)
+
+	_, err = fetchAPISpec(c, "apps", map[string]string{
+		wellknown.ANNOTATION_CATALOG_API_SPEC:           server.URL + "/openapi.json",
+		wellknown.ANNOTATION_CATALOG_API_SPEC_CONFIGMAP: "example-api",
+	})
+	assert.Contains(t, fmt.Sprint(err), "only one of")
+}
+
+func TestWithAPISpec(t *testing.T) {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "example-api", Namespace: "apps"},
+		Data:       map[string]string{"openapi.yaml": "openapi: 3.0.0\ninfo: {description: From the spec, version: 1.0.0}"},
+	}
+	c := fake.NewClientBuilder().WithObjects(cm).Build()
+	wd := &workloadDefaulter{Installer: &mesh_install.Installer{K8sClient: c}, apiSpecs: newAPISpecTracker()}
+
+	annotations := map[string]string{
+		wellknown.ANNOTATION_CATALOG_API_SPEC_CONFIGMAP: "example-api",
+		wellknown.ANNOTATION_CATALOG_DESCRIPTION:        "From the annotation",
+	}
+	merged := wd.withAPISpec("example", "apps", annotations)
+	assert.Equal(t, "From the annotation", merged[wellknown.ANNOTATION_CATALOG_DESCRIPTION], "annotations take precedence")
+	assert.Equal(t, "1.0.0", merged[wellknown.ANNOTATION_CATALOG_VERSION])
+	assert.NotContains(t, annotations, wellknown.ANNOTATION_CATALOG_VERSION, "the given annotations are unchanged")
+
+	// The applied spec is only reported as changed once it's edited
+	assert.False(t, wd.apiSpecs.changed("example", []byte(cm.Data["openapi.yaml"])))
+	assert.True(t, wd.apiSpecs.changed("example", []byte("openapi: 3.0.0\ninfo: {version: 1.1.0}")))
+
+	// A spec that can't be read leaves the annotations as they are
+	missing := map[string]string{wellknown.ANNOTATION_CATALOG_API_SPEC_CONFIGMAP: "missing"}
+	assert.Equal(t, missing, wd.withAPISpec("other", "apps", missing))
+}
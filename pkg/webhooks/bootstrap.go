@@ -0,0 +1,45 @@
+package webhooks
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/greymatter-io/operator/pkg/wellknown"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// The key of a bootstrap override ConfigMap read if the annotation doesn't name one.
+const defaultBootstrapOverrideKey = "bootstrap.yaml"
+
+// bootstrapOverride returns the envoy bootstrap override for a pod's sidecar, given inline by the bootstrap-override
+// annotation or read from the ConfigMap named by the bootstrap-override-configmap annotation. It returns "" if
+// neither is set.
+func bootstrapOverride(c client.Reader, namespace string, annotations map[string]string) (string, error) {
+	inline, hasInline := annotations[wellknown.ANNOTATION_BOOTSTRAP_OVERRIDE]
+	ref, hasRef := annotations[wellknown.ANNOTATION_BOOTSTRAP_OVERRIDE_CONFIGMAP]
+	switch {
+	case hasInline && hasRef:
+		return "", fmt.Errorf("only one of the %s and %s annotations may be set",
+			wellknown.ANNOTATION_BOOTSTRAP_OVERRIDE, wellknown.ANNOTATION_BOOTSTRAP_OVERRIDE_CONFIGMAP)
+	case hasInline:
+		return inline, nil
+	case !hasRef:
+		return "", nil
+	}
+
+	name, key := ref, defaultBootstrapOverrideKey
+	if idx := strings.Index(ref, "/"); idx >= 0 {
+		name, key = ref[:idx], ref[idx+1:]
+	}
+	cm := &corev1.ConfigMap{}
+	if err := c.Get(context.TODO(), client.ObjectKey{Namespace: namespace, Name: name}, cm); err != nil {
+		return "", fmt.Errorf("failed to get bootstrap override ConfigMap %s/%s: %w", namespace, name, err)
+	}
+	override, ok := cm.Data[key]
+	if !ok {
+		return "", fmt.Errorf("bootstrap override ConfigMap %s/%s has no key %q", namespace, name, key)
+	}
+	return override, nil
+}
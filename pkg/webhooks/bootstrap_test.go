@@ -0,0 +1,48 @@
+package webhooks
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/greymatter-io/operator/pkg/wellknown"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestBootstrapOverride(t *testing.T) {
+	c := fake.NewClientBuilder().WithObjects(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "tracing", Namespace: "apps"},
+		Data: map[string]string{
+			"bootstrap.yaml": "tracing: {http: {name: envoy.tracers.zipkin}}",
+			"logs.yaml":      "admin: {access_log_path: /dev/stdout}",
+		},
+	}).Build()
+
+	override, err := bootstrapOverride(c, "apps", nil)
+	assert.NoError(t, err)
+	assert.Empty(t, override)
+
+	override, err = bootstrapOverride(c, "apps", map[string]string{wellknown.ANNOTATION_BOOTSTRAP_OVERRIDE: "stats_flush_interval: 10s"})
+	assert.NoError(t, err)
+	assert.Equal(t, "stats_flush_interval: 10s", override)
+
+	override, err = bootstrapOverride(c, "apps", map[string]string{wellknown.ANNOTATION_BOOTSTRAP_OVERRIDE_CONFIGMAP: "tracing"})
+	assert.NoError(t, err)
+	assert.Equal(t, "tracing: {http: {name: envoy.tracers.zipkin}}", override)
+
+	override, err = bootstrapOverride(c, "apps", map[string]string{wellknown.ANNOTATION_BOOTSTRAP_OVERRIDE_CONFIGMAP: "tracing/logs.yaml"})
+	assert.NoError(t, err)
+	assert.Equal(t, "admin: {access_log_path: /dev/stdout}", override)
+
+	_, err = bootstrapOverride(c, "apps", map[string]string{wellknown.ANNOTATION_BOOTSTRAP_OVERRIDE_CONFIGMAP: "tracing/missing.yaml"})
+	assert.Contains(t, fmt.Sprint(err), `has no key "missing.yaml"`)
+	_, err = bootstrapOverride(c, "other", map[string]string{wellknown.ANNOTATION_BOOTSTRAP_OVERRIDE_CONFIGMAP: "tracing"})
+	assert.Error(t, err)
+	_, err = bootstrapOverride(c, "apps", map[string]string{
+		wellknown.ANNOTATION_BOOTSTRAP_OVERRIDE:           "stats_flush_interval: 10s",
+		wellknown.ANNOTATION_BOOTSTRAP_OVERRIDE_CONFIGMAP: "tracing",
+	})
+	assert.Contains(t, fmt.Sprint(err), "only one of")
+}
@@ -0,0 +1,44 @@
+package webhooks
+
+import (
+	"context"
+	"strings"
+
+	"github.com/greymatter-io/operator/pkg/wellknown"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// withNamespaceEgress returns a copy of a workload's annotations with the external dependencies in its Namespace's
+// egress annotation added to its own, so every sidecar in the Namespace is configured to reach them.
+func withNamespaceEgress(c client.Reader, namespace string, annotations map[string]string) map[string]string {
+	ns := &corev1.Namespace{}
+	if err := c.Get(context.TODO(), client.ObjectKey{Name: namespace}, ns); err != nil {
+		logger.Error(err, "Failed to get Namespace for its egress annotation", "namespace", namespace)
+		return annotations
+	}
+	nsEgress := strings.TrimSpace(ns.Annotations[wellknown.ANNOTATION_EGRESS])
+	if nsEgress == "" {
+		return annotations
+	}
+	merged := make(map[string]string, len(annotations)+1)
+	for k, v := range annotations {
+		merged[k] = v
+	}
+	if own := strings.TrimSpace(merged[wellknown.ANNOTATION_EGRESS]); own != "" {
+		nsEgress = own + "," + nsEgress
+	}
+	merged[wellknown.ANNOTATION_EGRESS] = nsEgress
+	return merged
+}
+
+// previousEgress returns the egress annotation of an updated Deployment or StatefulSet's pod template before the
+// update, with its Namespace's added, or "" for other requests.
+func (wd *workloadDefaulter) previousEgress(req admission.Request) string {
+	previous := wd.previousTemplate(req)
+	if previous == nil {
+		return ""
+	}
+	return withNamespaceEgress(wd.K8sClient, req.Namespace, previous.Annotations)[wellknown.ANNOTATION_EGRESS]
+}
@@ -0,0 +1,27 @@
+package webhooks
+
+import (
+	"testing"
+
+	"github.com/greymatter-io/operator/pkg/wellknown"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestWithNamespaceEgress(t *testing.T) {
+	c := fake.NewClientBuilder().WithObjects(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps", Annotations: map[string]string{wellknown.ANNOTATION_EGRESS: "https://api.stripe.com"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "plain"}},
+	).Build()
+
+	own := map[string]string{wellknown.ANNOTATION_EGRESS: "db.example.com:5432"}
+	merged := withNamespaceEgress(c, "apps", own)
+	assert.Equal(t, "db.example.com:5432,https://api.stripe.com", merged[wellknown.ANNOTATION_EGRESS])
+	assert.Equal(t, "db.example.com:5432", own[wellknown.ANNOTATION_EGRESS], "the workload's annotations are unchanged")
+
+	assert.Equal(t, "https://api.stripe.com", withNamespaceEgress(c, "apps", nil)[wellknown.ANNOTATION_EGRESS])
+	assert.Equal(t, own, withNamespaceEgress(c, "plain", own))
+	assert.Equal(t, own, withNamespaceEgress(c, "missing", own))
+}
@@ -0,0 +1,13 @@
+package webhooks
+
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
+// podServiceAccount returns the name of the ServiceAccount a pod runs as.
+func podServiceAccount(pod *corev1.Pod) string {
+	if pod.Spec.ServiceAccountName != "" {
+		return pod.Spec.ServiceAccountName
+	}
+	return "default"
+}
@@ -0,0 +1,13 @@
+package webhooks
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestPodServiceAccount(t *testing.T) {
+	assert.Equal(t, "default", podServiceAccount(&corev1.Pod{}))
+	assert.Equal(t, "web", podServiceAccount(&corev1.Pod{Spec: corev1.PodSpec{ServiceAccountName: "web"}}))
+}
@@ -61,7 +61,7 @@ func (mv *meshValidator) Handle(ctx context.Context, req admission.Request) admi
 		if err := mv.DecodeRaw(req.OldObject, prev); err != nil {
 			return admission.Errored(http.StatusInternalServerError, err)
 		}
-		go mv.RemoveMesh(prev)
+		go mv.RemoveMesh(mv.RunContext(), prev)
 		return admission.ValidationResponse(true, "allowed")
 	}
 
@@ -75,13 +75,17 @@ func (mv *meshValidator) Handle(ctx context.Context, req admission.Request) admi
 		return admission.ValidationResponse(false, "blocked attempt to install Mesh in 'gm-operator' namespace")
 	}
 
+	if src := mesh.Spec.GitOps; src != nil && src.Branch != "" && src.Tag != "" {
+		return admission.ValidationResponse(false, "spec.gitops has a branch or a tag, not both")
+	}
+
 	watchNS := strings.Join(mesh.Spec.WatchNamespaces, ",")
 	if strings.Contains(watchNS, installNS) {
 		return admission.ValidationResponse(false, "install namespace should not be included in watch namespaces")
 	}
 
 	meshList := &v1alpha1.MeshList{}
-	if err := mv.List(context.TODO(), meshList); err != nil {
+	if err := mv.List(ctx, meshList); err != nil {
 		logger.Error(err, "failed to list all meshes to validate namespaces", "Mesh", mesh.Name)
 		return admission.ValidationResponse(false, "Internal server error; check logs with valid cluster permissions")
 	}
@@ -110,13 +114,13 @@ func (mv *meshValidator) Handle(ctx context.Context, req admission.Request) admi
 	}
 
 	if req.Operation == admissionv1.Create {
-		go mv.ApplyMesh(nil, mesh)
+		go mv.ApplyMesh(mv.RunContext(), nil, mesh)
 	} else {
 		prev := &v1alpha1.Mesh{}
 		if err := mv.DecodeRaw(req.OldObject, prev); err != nil {
 			return admission.Errored(http.StatusInternalServerError, err)
 		}
-		go mv.ApplyMesh(prev, mesh)
+		go mv.ApplyMesh(mv.RunContext(), prev, mesh)
 	}
 
 	return admission.ValidationResponse(true, "allowed")
@@ -0,0 +1,116 @@
+package webhooks
+
+import (
+	"context"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	// Number of workers applying sidecar configuration if config.reconcile.workers is unset.
+	defaultSidecarWorkers = 4
+	// Times a failed change to a workload's sidecar configuration is retried, with backoff, before it's dropped.
+	maxSidecarRetries = 10
+)
+
+// sidecarWork is the latest requested change to a workload's sidecar configuration in Grey Matter.
+type sidecarWork struct {
+	configure   bool // apply the configuration if set, otherwise remove it
+	annotations map[string]string
+	// The workload's egress annotation before an update, whose dependencies are removed if no longer declared
+	previousEgress string
+}
+
+// sidecarQueue applies sidecar configuration changes requested by admission webhooks, keyed by workload namespace
+// and name. Changes requested for a workload while it's waiting are coalesced so only the latest is applied, a
+// workload is only handled by one worker at a time, and at most the given number of workloads are handled at once.
+// A change that fails is retried with backoff, unless a newer one was requested meanwhile.
+type sidecarQueue struct {
+	queue   workqueue.RateLimitingInterface
+	mu      sync.Mutex
+	pending map[types.NamespacedName]sidecarWork
+	process func(key types.NamespacedName, work sidecarWork) error
+}
+
+func newSidecarQueue(process func(key types.NamespacedName, work sidecarWork) error) *sidecarQueue {
+	return &sidecarQueue{
+		queue:   workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		pending: make(map[types.NamespacedName]sidecarWork),
+		process: process,
+	}
+}
+
+// add requests a change to the workload's sidecar configuration, replacing any change still waiting.
+func (q *sidecarQueue) add(namespace, name string, work sidecarWork) {
+	key := types.NamespacedName{Namespace: namespace, Name: name}
+	q.mu.Lock()
+	if waiting, ok := q.pending[key]; ok && waiting.previousEgress != "" {
+		// The waiting change was never applied, so what's stale is relative to the configuration before it
+		work.previousEgress = waiting.previousEgress
+	}
+	q.pending[key] = work
+	q.mu.Unlock()
+	q.queue.Add(key)
+}
+
+// run starts the given number of workers (defaultSidecarWorkers if not positive), and stops them once ctx is done.
+func (q *sidecarQueue) run(ctx context.Context, workers int) {
+	if workers <= 0 {
+		workers = defaultSidecarWorkers
+	}
+	for n := 0; n < workers; n++ {
+		go func() {
+			for q.processNext() {
+			}
+		}()
+	}
+	<-ctx.Done()
+	q.queue.ShutDown()
+}
+
+// processNext handles the next workload in the queue, blocking until there is one.
+// It returns false once the queue is shut down.
+func (q *sidecarQueue) processNext() bool {
+	item, shutdown := q.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer q.queue.Done(item)
+
+	key := item.(types.NamespacedName)
+	q.mu.Lock()
+	work, ok := q.pending[key]
+	delete(q.pending, key)
+	q.mu.Unlock()
+	if !ok {
+		return true
+	}
+
+	err := q.process(key, work)
+	if err == nil {
+		q.queue.Forget(key)
+		return true
+	}
+	if q.queue.NumRequeues(key) >= maxSidecarRetries {
+		logger.Error(err, "Giving up on sidecar configuration", "name", key.Name, "namespace", key.Namespace)
+		q.queue.Forget(key)
+		return true
+	}
+	logger.Error(err, "Failed to apply sidecar configuration, retrying", "name", key.Name, "namespace", key.Namespace)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if newer, ok := q.pending[key]; ok {
+		// A newer change is already queued, and replaces the failed one
+		if work.previousEgress != "" {
+			newer.previousEgress = work.previousEgress
+			q.pending[key] = newer
+		}
+		q.queue.Forget(key)
+		return true
+	}
+	q.pending[key] = work
+	q.queue.AddRateLimited(key)
+	return true
+}
@@ -0,0 +1,142 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func TestSidecarQueueCoalescesPendingWork(t *testing.T) {
+	var processed []sidecarWork
+	q := newSidecarQueue(func(key types.NamespacedName, work sidecarWork) error {
+		processed = append(processed, work)
+		return nil
+	})
+
+	q.add("apps", "example", sidecarWork{configure: true})
+	q.add("apps", "example", sidecarWork{configure: false})
+	assert.Equal(t, 1, q.queue.Len())
+
+	q.queue.ShutDown() // drains the queue before Get reports shutdown
+	for q.processNext() {
+	}
+	assert.Equal(t, []sidecarWork{{configure: false}}, processed)
+}
+
+func TestSidecarQueueKeepsEgressBeforeCoalescedWork(t *testing.T) {
+	var processed []sidecarWork
+	q := newSidecarQueue(func(key types.NamespacedName, work sidecarWork) error {
+		processed = append(processed, work)
+		return nil
+	})
+
+	q.add("apps", "example", sidecarWork{configure: true, previousEgress: "https://a.example.com"})
+	q.add("apps", "example", sidecarWork{configure: true, previousEgress: "https://b.example.com"})
+
+	q.queue.ShutDown()
+	for q.processNext() {
+	}
+	assert.Equal(t, []sidecarWork{{configure: true, previousEgress: "https://a.example.com"}}, processed)
+}
+
+func TestSidecarQueueSerializesPerWorkload(t *testing.T) {
+	started := make(chan sidecarWork, 2)
+	release := make(chan struct{})
+	q := newSidecarQueue(func(key types.NamespacedName, work sidecarWork) error {
+		started <- work
+		<-release
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.run(ctx, 4)
+
+	q.add("apps", "example", sidecarWork{configure: true})
+	assert.Equal(t, sidecarWork{configure: true}, <-started)
+
+	// A change requested while the workload is being handled waits for it, despite idle workers
+	q.add("apps", "example", sidecarWork{configure: false})
+	select {
+	case <-started:
+		t.Fatal("workload was handled by two workers at once")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	release <- struct{}{}
+	assert.Equal(t, sidecarWork{configure: false}, <-started)
+	release <- struct{}{}
+}
+
+func TestSidecarQueueKeysByNamespace(t *testing.T) {
+	var processed []types.NamespacedName
+	q := newSidecarQueue(func(key types.NamespacedName, work sidecarWork) error {
+		processed = append(processed, key)
+		return nil
+	})
+
+	q.add("apps", "example", sidecarWork{configure: true})
+	q.add("team", "example", sidecarWork{configure: true})
+	assert.Equal(t, 2, q.queue.Len())
+
+	q.queue.ShutDown()
+	for q.processNext() {
+	}
+	assert.ElementsMatch(t, []types.NamespacedName{{Namespace: "apps", Name: "example"}, {Namespace: "team", Name: "example"}}, processed)
+}
+
+func TestSidecarQueueRetriesFailedWork(t *testing.T) {
+	processed := make(chan sidecarWork, maxSidecarRetries+2)
+	failures := 2
+	q := newSidecarQueue(func(key types.NamespacedName, work sidecarWork) error {
+		processed <- work
+		if failures > 0 {
+			failures--
+			return errors.New("no mesh yet")
+		}
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.run(ctx, 1)
+
+	q.add("apps", "example", sidecarWork{configure: true, previousEgress: "https://a.example.com"})
+	for n := 0; n < 3; n++ {
+		select {
+		case work := <-processed:
+			assert.Equal(t, sidecarWork{configure: true, previousEgress: "https://a.example.com"}, work)
+		case <-time.After(5 * time.Second):
+			t.Fatal("failed work was not retried")
+		}
+	}
+	assert.Eventually(t, func() bool {
+		return q.queue.NumRequeues(types.NamespacedName{Namespace: "apps", Name: "example"}) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestSidecarQueueGivesUpOnFailingWork(t *testing.T) {
+	attempts := 0
+	q := newSidecarQueue(func(key types.NamespacedName, work sidecarWork) error {
+		attempts++
+		return errors.New("invalid CUE")
+	})
+
+	q.queue = workqueue.NewRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(time.Millisecond, time.Millisecond))
+	q.add("apps", "example", sidecarWork{configure: true})
+	for n := 0; n <= maxSidecarRetries; n++ {
+		q.processNext()
+	}
+	assert.Equal(t, maxSidecarRetries+1, attempts)
+	assert.Equal(t, 0, q.queue.NumRequeues(types.NamespacedName{Namespace: "apps", Name: "example"}))
+
+	q.queue.ShutDown()
+	for q.processNext() {
+	}
+	assert.Equal(t, maxSidecarRetries+1, attempts, "dropped after the last retry")
+}
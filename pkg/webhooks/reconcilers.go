@@ -0,0 +1,179 @@
+package webhooks
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	"github.com/greymatter-io/operator/pkg/wellknown"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// ReconcileRequest describes the object a reconciler is run on as it's admitted into one of the mesh's namespaces.
+type ReconcileRequest struct {
+	// THE mesh
+	Mesh *v1alpha1.Mesh
+	// Pod, Deployment, or StatefulSet
+	Kind      string
+	Namespace string
+	// Empty for a pod whose name is generated
+	Name string
+}
+
+// A PodReconciler changes a pod as it's created or updated in one of the mesh's watched namespaces, after the
+// operator has injected its sidecar, if it asked for one.
+type PodReconciler func(req ReconcileRequest, pod *corev1.Pod) error
+
+// A WorkloadReconciler changes the pod template of a Deployment or StatefulSet as it's created or updated in one of
+// the mesh's namespaces, after the operator has added its cluster labels and before it checks whether the pods ask
+// for a sidecar, so it may also ask for one.
+type WorkloadReconciler func(req ReconcileRequest, template *corev1.PodTemplateSpec) error
+
+// reconcilers are the registered reconcilers, by name.
+var reconcilers = struct {
+	sync.RWMutex
+	pods      map[string]PodReconciler
+	workloads map[string]WorkloadReconciler
+}{pods: make(map[string]PodReconciler), workloads: make(map[string]WorkloadReconciler)}
+
+// RegisterPodReconciler adds a reconciler run on every pod admitted into the mesh's watched namespaces, so
+// downstream builds of the operator can change pods without forking it. It should be called before the operator
+// starts. Reconcilers run in name order; one that returns an error is logged, and its changes are discarded.
+func RegisterPodReconciler(name string, reconciler PodReconciler) error {
+	reconcilers.Lock()
+	defer reconcilers.Unlock()
+	if _, ok := reconcilers.pods[name]; ok {
+		return fmt.Errorf("pod reconciler %q is already registered", name)
+	}
+	reconcilers.pods[name] = reconciler
+	logger.Info("Registered pod reconciler", "Name", name)
+	return nil
+}
+
+// RegisterWorkloadReconciler is RegisterPodReconciler for the Deployments and StatefulSets admitted into the mesh's
+// namespaces.
+func RegisterWorkloadReconciler(name string, reconciler WorkloadReconciler) error {
+	reconcilers.Lock()
+	defer reconcilers.Unlock()
+	if _, ok := reconcilers.workloads[name]; ok {
+		return fmt.Errorf("workload reconciler %q is already registered", name)
+	}
+	reconcilers.workloads[name] = reconciler
+	logger.Info("Registered workload reconciler", "Name", name)
+	return nil
+}
+
+// registerCustomReconcilers registers the reconcilers of config.custom_reconcilers, each named after its entry.
+func registerCustomReconcilers(custom []cuemodule.CustomReconciler) error {
+	for _, config := range custom {
+		name := "config/" + config.Name
+		kinds := map[string]bool{}
+		for _, kind := range config.Kinds {
+			kinds[kind] = true
+		}
+		if len(kinds) == 0 {
+			kinds["Deployment"], kinds["StatefulSet"] = true, true
+		}
+		if kinds["Pod"] {
+			reconcile := customReconciler(config)
+			if err := RegisterPodReconciler(name, func(req ReconcileRequest, pod *corev1.Pod) error {
+				reconcile(req, &pod.ObjectMeta)
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+		if kinds["Deployment"] || kinds["StatefulSet"] {
+			reconcile := customReconciler(config)
+			if err := RegisterWorkloadReconciler(name, func(req ReconcileRequest, template *corev1.PodTemplateSpec) error {
+				if kinds[req.Kind] {
+					reconcile(req, &template.ObjectMeta)
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// customReconciler returns a function that sets a custom reconciler's labels and annotations on the metadata of a
+// pod or pod template, if it's in one of the reconciler's namespaces and asks for a sidecar when required.
+func customReconciler(config cuemodule.CustomReconciler) func(ReconcileRequest, *metav1.ObjectMeta) {
+	namespaces := map[string]bool{}
+	for _, ns := range config.Namespaces {
+		namespaces[ns] = true
+	}
+	return func(req ReconcileRequest, meta *metav1.ObjectMeta) {
+		if len(namespaces) > 0 && !namespaces[req.Namespace] {
+			return
+		}
+		if _, ok := meta.Annotations[wellknown.ANNOTATION_INJECT_SIDECAR_TO_PORT]; config.SidecarOnly && !ok {
+			return
+		}
+		meta.Labels = withDefaults(meta.Labels, config.Labels)
+		meta.Annotations = withDefaults(meta.Annotations, config.Annotations)
+	}
+}
+
+// withDefaults returns values with the defaults it doesn't already have added.
+func withDefaults(values, defaults map[string]string) map[string]string {
+	if len(defaults) == 0 {
+		return values
+	}
+	if values == nil {
+		values = make(map[string]string, len(defaults))
+	}
+	for key, value := range defaults {
+		if _, ok := values[key]; !ok {
+			values[key] = value
+		}
+	}
+	return values
+}
+
+// reconcilePod runs the registered pod reconcilers on a pod admitted by the request.
+func reconcilePod(req admission.Request, mesh *v1alpha1.Mesh, pod *corev1.Pod) {
+	reconcilers.RLock()
+	defer reconcilers.RUnlock()
+	rr := ReconcileRequest{Mesh: mesh, Kind: req.Kind.Kind, Namespace: req.Namespace, Name: req.Name}
+	names := make([]string, 0, len(reconcilers.pods))
+	for name := range reconcilers.pods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		reconciled := pod.DeepCopy()
+		if err := reconcilers.pods[name](rr, reconciled); err != nil {
+			logger.Error(err, "Pod reconciler failed; discarding its changes", "Reconciler", name, "name", req.Name, "namespace", req.Namespace)
+			continue
+		}
+		*pod = *reconciled
+	}
+}
+
+// reconcileWorkload runs the registered workload reconcilers on the pod template of a Deployment or StatefulSet
+// admitted by the request.
+func reconcileWorkload(req admission.Request, mesh *v1alpha1.Mesh, template *corev1.PodTemplateSpec) {
+	reconcilers.RLock()
+	defer reconcilers.RUnlock()
+	rr := ReconcileRequest{Mesh: mesh, Kind: req.Kind.Kind, Namespace: req.Namespace, Name: req.Name}
+	names := make([]string, 0, len(reconcilers.workloads))
+	for name := range reconcilers.workloads {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		reconciled := template.DeepCopy()
+		if err := reconcilers.workloads[name](rr, reconciled); err != nil {
+			logger.Error(err, "Workload reconciler failed; discarding its changes", "Reconciler", name, "kind", req.Kind.Kind, "name", req.Name, "namespace", req.Namespace)
+			continue
+		}
+		*template = *reconciled
+	}
+}
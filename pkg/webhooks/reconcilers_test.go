@@ -0,0 +1,105 @@
+package webhooks
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/greymatter-io/operator/api/v1alpha1"
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	"github.com/greymatter-io/operator/pkg/wellknown"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// resetReconcilers clears the registered reconcilers when the test finishes.
+func resetReconcilers(t *testing.T) {
+	t.Cleanup(func() {
+		reconcilers.Lock()
+		defer reconcilers.Unlock()
+		reconcilers.pods = make(map[string]PodReconciler)
+		reconcilers.workloads = make(map[string]WorkloadReconciler)
+	})
+}
+
+func reconcileRequest(kind, namespace, name string) admission.Request {
+	return admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Kind:      metav1.GroupVersionKind{Kind: kind},
+		Namespace: namespace,
+		Name:      name,
+	}}
+}
+
+func TestRegisterReconcilers(t *testing.T) {
+	resetReconcilers(t)
+	var order []string
+	label := func(name string) PodReconciler {
+		return func(req ReconcileRequest, pod *corev1.Pod) error {
+			order = append(order, name)
+			if pod.Labels == nil {
+				pod.Labels = map[string]string{}
+			}
+			pod.Labels[name] = req.Namespace
+			return nil
+		}
+	}
+	assert.NoError(t, RegisterPodReconciler("b", label("b")))
+	assert.NoError(t, RegisterPodReconciler("a", label("a")))
+	assert.NoError(t, RegisterPodReconciler("c", func(req ReconcileRequest, pod *corev1.Pod) error {
+		pod.Labels["c"] = "discarded"
+		return errors.New("failed")
+	}))
+	err := RegisterPodReconciler("a", label("a"))
+	assert.Contains(t, fmt.Sprint(err), `pod reconciler "a" is already registered`)
+
+	pod := &corev1.Pod{}
+	reconcilePod(reconcileRequest("Pod", "apps", ""), &v1alpha1.Mesh{}, pod)
+	assert.Equal(t, []string{"a", "b"}, order, "reconcilers run in name order")
+	assert.Equal(t, map[string]string{"a": "apps", "b": "apps"}, pod.Labels, "a failed reconciler's changes are discarded")
+
+	// Pod and workload reconcilers are registered separately
+	assert.NoError(t, RegisterWorkloadReconciler("a", func(req ReconcileRequest, template *corev1.PodTemplateSpec) error {
+		template.Annotations = map[string]string{wellknown.ANNOTATION_INJECT_SIDECAR_TO_PORT: "8080"}
+		return nil
+	}))
+	template := &corev1.PodTemplateSpec{}
+	reconcileWorkload(reconcileRequest("Deployment", "apps", "web"), &v1alpha1.Mesh{}, template)
+	assert.Equal(t, "8080", template.Annotations[wellknown.ANNOTATION_INJECT_SIDECAR_TO_PORT])
+}
+
+func TestCustomReconcilers(t *testing.T) {
+	resetReconcilers(t)
+	assert.NoError(t, registerCustomReconcilers([]cuemodule.CustomReconciler{
+		{Name: "cost-center", Namespaces: []string{"apps"}, Labels: map[string]string{"example.com/cost-center": "1234"}},
+		{Name: "audit", Kinds: []string{"Pod", "StatefulSet"}, SidecarOnly: true, Annotations: map[string]string{"example.com/audit": "true"}},
+	}))
+	mesh := &v1alpha1.Mesh{}
+
+	// Workloads get the default kinds, in the given namespaces, without overriding their own values
+	template := &corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "web"}}}
+	reconcileWorkload(reconcileRequest("Deployment", "apps", "web"), mesh, template)
+	assert.Equal(t, map[string]string{"app": "web", "example.com/cost-center": "1234"}, template.Labels)
+	template = &corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"example.com/cost-center": "5678"}}}
+	reconcileWorkload(reconcileRequest("StatefulSet", "apps", "db"), mesh, template)
+	assert.Equal(t, "5678", template.Labels["example.com/cost-center"])
+	assert.Empty(t, template.Annotations, "without a sidecar, sidecar-only reconcilers are skipped")
+	template = &corev1.PodTemplateSpec{}
+	reconcileWorkload(reconcileRequest("Deployment", "payments", "web"), mesh, template)
+	assert.Empty(t, template.Labels)
+
+	// Only the reconcilers configured for a kind change it
+	annotations := map[string]string{wellknown.ANNOTATION_INJECT_SIDECAR_TO_PORT: "8080"}
+	template = &corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Annotations: annotations}}
+	reconcileWorkload(reconcileRequest("Deployment", "payments", "web"), mesh, template)
+	assert.NotContains(t, template.Annotations, "example.com/audit")
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{wellknown.ANNOTATION_INJECT_SIDECAR_TO_PORT: "8080"}}}
+	reconcilePod(reconcileRequest("Pod", "payments", ""), mesh, pod)
+	assert.Equal(t, "true", pod.Annotations["example.com/audit"])
+	assert.Empty(t, pod.Labels)
+
+	// Registering the same config twice fails
+	assert.Error(t, registerCustomReconcilers([]cuemodule.CustomReconciler{{Name: "audit", Kinds: []string{"Pod"}}}))
+}
@@ -0,0 +1,114 @@
+package webhooks
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/greymatter-io/operator/pkg/cuemodule"
+	"github.com/greymatter-io/operator/pkg/wellknown"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Traffic redirection modes, set with the traffic-redirect annotation.
+const (
+	redirectInbound = "inbound" // capture inbound TCP to the pod
+	redirectAll     = "all"     // capture inbound and outbound TCP
+)
+
+const (
+	// The sidecar listener that receives captured outbound traffic, unless overridden by annotation.
+	defaultOutboundPort = cuemodule.DefaultSidecarEgressPort
+	// The UID the sidecar runs as (when not set in its CUE), so its own traffic is not recaptured.
+	defaultSidecarUID = int64(1337)
+)
+
+// trafficRedirectInitContainer returns an init container that installs iptables REDIRECT rules sending the pod's
+// TCP traffic through the given sidecar container, which is updated to run as the UID exempted from outbound capture.
+// The mode and outbound port are read from the pod's annotations. It returns false if redirection isn't requested.
+// The image (defaults.traffic_redirect_image) must be pinned by digest and already contain iptables, since nothing is
+// installed when the init container runs.
+func trafficRedirectInitContainer(image string, sidecar *corev1.Container, annotations map[string]string) (corev1.Container, bool, error) {
+	mode := annotations[wellknown.ANNOTATION_TRAFFIC_REDIRECT]
+	if mode == "" || mode == "false" {
+		return corev1.Container{}, false, nil
+	}
+	if mode != redirectInbound && mode != redirectAll {
+		return corev1.Container{}, false, fmt.Errorf("invalid %s annotation %q (expected %q or %q)",
+			wellknown.ANNOTATION_TRAFFIC_REDIRECT, mode, redirectInbound, redirectAll)
+	}
+
+	var proxyPort int32
+	for _, p := range sidecar.Ports {
+		if p.Name == "proxy" {
+			proxyPort = p.ContainerPort
+		}
+	}
+	if proxyPort == 0 {
+		return corev1.Container{}, false, fmt.Errorf("sidecar container has no port named proxy to redirect inbound traffic to")
+	}
+
+	rules := []string{
+		// Inbound: everything except the sidecar's own ports goes to its ingress listener.
+		"iptables -t nat -N GM_INBOUND",
+	}
+	for _, p := range sidecar.Ports {
+		rules = append(rules, fmt.Sprintf("iptables -t nat -A GM_INBOUND -p tcp --dport %d -j RETURN", p.ContainerPort))
+	}
+	rules = append(rules,
+		fmt.Sprintf("iptables -t nat -A GM_INBOUND -p tcp -j REDIRECT --to-ports %d", proxyPort),
+		"iptables -t nat -A PREROUTING -p tcp -j GM_INBOUND",
+	)
+
+	if mode == redirectAll {
+		outboundPort := int64(defaultOutboundPort)
+		if v, ok := annotations[wellknown.ANNOTATION_TRAFFIC_REDIRECT_OUTBOUND_PORT]; ok {
+			parsed, err := strconv.ParseInt(v, 10, 32)
+			if err != nil || parsed <= 0 {
+				return corev1.Container{}, false, fmt.Errorf("invalid %s annotation %q", wellknown.ANNOTATION_TRAFFIC_REDIRECT_OUTBOUND_PORT, v)
+			}
+			outboundPort = parsed
+		}
+
+		if sidecar.SecurityContext == nil {
+			sidecar.SecurityContext = &corev1.SecurityContext{}
+		}
+		if sidecar.SecurityContext.RunAsUser == nil {
+			uid := defaultSidecarUID
+			sidecar.SecurityContext.RunAsUser = &uid
+		}
+
+		rules = append(rules,
+			// Outbound: everything not sent by the sidecar itself, or to localhost, goes to its egress listener.
+			"iptables -t nat -N GM_OUTBOUND",
+			fmt.Sprintf("iptables -t nat -A GM_OUTBOUND -m owner --uid-owner %d -j RETURN", *sidecar.SecurityContext.RunAsUser),
+			"iptables -t nat -A GM_OUTBOUND -d 127.0.0.1/32 -j RETURN",
+			fmt.Sprintf("iptables -t nat -A GM_OUTBOUND -p tcp -j REDIRECT --to-ports %d", outboundPort),
+			"iptables -t nat -A OUTPUT -p tcp -j GM_OUTBOUND",
+		)
+	}
+
+	if image == "" {
+		return corev1.Container{}, false, fmt.Errorf("defaults.traffic_redirect_image is unset; set it to an image pinned by digest that contains iptables")
+	}
+	if !cuemodule.PinnedByDigest(image) {
+		return corev1.Container{}, false, fmt.Errorf("defaults.traffic_redirect_image %q is not pinned by digest", image)
+	}
+	root := int64(0)
+	nonRoot := false
+	script := "set -e\ncommand -v iptables >/dev/null || { echo 'iptables not found in the traffic redirect image' >&2; exit 1; }\n" +
+		strings.Join(rules, "\n")
+
+	return corev1.Container{
+		Name:    "gm-traffic-redirect",
+		Image:   image,
+		Command: []string{"sh", "-c", script},
+		SecurityContext: &corev1.SecurityContext{
+			RunAsUser:    &root,
+			RunAsNonRoot: &nonRoot,
+			Capabilities: &corev1.Capabilities{
+				Add: []corev1.Capability{"NET_ADMIN", "NET_RAW"},
+			},
+		},
+	}, true, nil
+}
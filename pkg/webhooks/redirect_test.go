@@ -0,0 +1,103 @@
+package webhooks
+
+import (
+	"testing"
+
+	"github.com/greymatter-io/operator/pkg/wellknown"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestTrafficRedirectInitContainer(t *testing.T) {
+	const image = "registry.example.com/iptables@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	sidecar := func() *corev1.Container {
+		return &corev1.Container{
+			Name: "sidecar",
+			Ports: []corev1.ContainerPort{
+				{Name: "proxy", ContainerPort: 10808},
+				{Name: "metrics", ContainerPort: 8081},
+			},
+		}
+	}
+
+	cases := map[string]struct {
+		image       string
+		annotations map[string]string
+		redirect    bool
+		err         bool
+		contains    []string
+		excludes    []string
+	}{
+		"not requested": {
+			annotations: map[string]string{},
+		},
+		"invalid mode": {
+			annotations: map[string]string{wellknown.ANNOTATION_TRAFFIC_REDIRECT: "everything"},
+			err:         true,
+		},
+		"inbound": {
+			annotations: map[string]string{wellknown.ANNOTATION_TRAFFIC_REDIRECT: "inbound"},
+			redirect:    true,
+			contains: []string{
+				"--dport 8081 -j RETURN",
+				"GM_INBOUND -p tcp -j REDIRECT --to-ports 10808",
+			},
+			excludes: []string{"GM_OUTBOUND"},
+		},
+		"all with outbound port": {
+			annotations: map[string]string{
+				wellknown.ANNOTATION_TRAFFIC_REDIRECT:               "all",
+				wellknown.ANNOTATION_TRAFFIC_REDIRECT_OUTBOUND_PORT: "15001",
+			},
+			redirect: true,
+			contains: []string{
+				"--uid-owner 1337 -j RETURN",
+				"GM_OUTBOUND -p tcp -j REDIRECT --to-ports 15001",
+			},
+		},
+		"image pinned by tag": {
+			image:       "docker.io/library/alpine:3.16",
+			annotations: map[string]string{wellknown.ANNOTATION_TRAFFIC_REDIRECT: "inbound"},
+			err:         true,
+		},
+		"invalid outbound port": {
+			annotations: map[string]string{
+				wellknown.ANNOTATION_TRAFFIC_REDIRECT:               "all",
+				wellknown.ANNOTATION_TRAFFIC_REDIRECT_OUTBOUND_PORT: "egress",
+			},
+			err: true,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			sc := sidecar()
+			if tc.image == "" {
+				tc.image = image
+			}
+			init, redirect, err := trafficRedirectInitContainer(tc.image, sc, tc.annotations)
+			if tc.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.redirect, redirect)
+			if !redirect {
+				return
+			}
+			assert.Equal(t, image, init.Image)
+			assert.Contains(t, init.SecurityContext.Capabilities.Add, corev1.Capability("NET_ADMIN"))
+			script := init.Command[len(init.Command)-1]
+			assert.NotContains(t, script, "apk add")
+			for _, c := range tc.contains {
+				assert.Contains(t, script, c)
+			}
+			for _, e := range tc.excludes {
+				assert.NotContains(t, script, e)
+			}
+			if tc.annotations[wellknown.ANNOTATION_TRAFFIC_REDIRECT] == "all" {
+				assert.Equal(t, defaultSidecarUID, *sc.SecurityContext.RunAsUser)
+			}
+		})
+	}
+}
@@ -40,13 +40,13 @@ type Loader struct {
 }
 
 func New(
-	cl *client.Client,
+	cl client.Client,
 	i *mesh_install.Installer,
 	c *gmapi.CLI,
 	cs *cfsslsrv.CFSSLServer,
 	get func() *webhook.Server) (*Loader, error) {
 
-	wl := &Loader{Client: *cl, Installer: i, CLI: c, CFSSLServer: cs, getServer: get}
+	wl := &Loader{Client: cl, Installer: i, CLI: c, CFSSLServer: cs, getServer: get}
 
 	if !i.Config.GenerateWebhookCerts {
 		logger.Info("webhook server cert generation disabled; expecting webhook server certs to be mounted from external source")
@@ -76,7 +76,7 @@ func (wl *Loader) Start(ctx context.Context) error {
 
 	// If webhook cert generation is disabled, just register the webhook handlers and exit
 	if !wl.Config.GenerateWebhookCerts {
-		wl.register()
+		wl.register(ctx)
 		return nil
 	}
 
@@ -87,7 +87,7 @@ func (wl *Loader) Start(ctx context.Context) error {
 			Namespace: "gm-operator",
 		},
 	}
-	k8sapi.Apply(&wl.Client, secret, nil, k8sapi.MkPatchAction(func(obj client.Object) client.Object {
+	k8sapi.Apply(ctx, wl.Client, secret, nil, k8sapi.MkPatchAction(func(obj client.Object) client.Object {
 		s := obj.(*corev1.Secret)
 		if s.StringData == nil {
 			s.StringData = make(map[string]string)
@@ -101,7 +101,7 @@ func (wl *Loader) Start(ctx context.Context) error {
 	mwc := &admissionregistrationv1.MutatingWebhookConfiguration{
 		ObjectMeta: metav1.ObjectMeta{Name: "gm-mutate-config"},
 	}
-	k8sapi.Apply(&wl.Client, mwc, nil, k8sapi.MkPatchAction(func(obj client.Object) client.Object {
+	k8sapi.Apply(ctx, wl.Client, mwc, nil, k8sapi.MkPatchAction(func(obj client.Object) client.Object {
 		m := obj.(*admissionregistrationv1.MutatingWebhookConfiguration)
 		for i := range m.Webhooks {
 			m.Webhooks[i].ClientConfig.CABundle = wl.caBundle
@@ -113,7 +113,7 @@ func (wl *Loader) Start(ctx context.Context) error {
 	vwc := &admissionregistrationv1.ValidatingWebhookConfiguration{
 		ObjectMeta: metav1.ObjectMeta{Name: "gm-validate-config"},
 	}
-	k8sapi.Apply(&wl.Client, vwc, nil, k8sapi.MkPatchAction(func(obj client.Object) client.Object {
+	k8sapi.Apply(ctx, wl.Client, vwc, nil, k8sapi.MkPatchAction(func(obj client.Object) client.Object {
 		v := obj.(*admissionregistrationv1.ValidatingWebhookConfiguration)
 		for i := range v.Webhooks {
 			v.Webhooks[i].ClientConfig.CABundle = wl.caBundle
@@ -132,14 +132,25 @@ func (wl *Loader) Start(ctx context.Context) error {
 		time.Sleep(time.Second * 2)
 	}
 	logger.Info("New webhook TLS certs detected", "Elapsed", time.Since(start).String())
-	wl.register()
+	wl.register(ctx)
 
 	return nil
 }
 
-func (wl *Loader) register() {
+func (wl *Loader) register(ctx context.Context) {
+	wd := &workloadDefaulter{Installer: wl.Installer, CLI: wl.CLI, apiSpecs: newAPISpecTracker()}
+	wd.sidecars = newSidecarQueue(wd.applySidecarWork)
+	go wd.sidecars.run(ctx, wl.Config.Reconcile.Workers)
+	if wl.Config.Reconcile.Enabled(reconcilerCatalogAPISpecs) {
+		go wd.refreshAPISpecs(ctx)
+	}
+	if err := registerCustomReconcilers(wl.Config.CustomReconcilers); err != nil {
+		logger.Error(err, "Failed to register custom reconcilers")
+	}
+
 	server := wl.getServer()
 	server.Register("/mutate-mesh", &admission.Webhook{Handler: &meshDefaulter{Installer: wl.Installer}})
 	server.Register("/validate-mesh", &admission.Webhook{Handler: &meshValidator{Installer: wl.Installer, Client: wl.Client}})
-	server.Register("/mutate-workload", &admission.Webhook{Handler: &workloadDefaulter{Installer: wl.Installer, CLI: wl.CLI}})
+	server.Register("/mutate-workload", &admission.Webhook{Handler: wd})
+	wl.Installer.WebhooksRegistered()
 }
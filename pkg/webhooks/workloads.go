@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/greymatter-io/operator/pkg/cuemodule"
 	"github.com/greymatter-io/operator/pkg/gmapi"
 	"github.com/greymatter-io/operator/pkg/mesh_install"
 	"github.com/greymatter-io/operator/pkg/wellknown"
@@ -14,6 +15,8 @@ import (
 	admissionv1 "k8s.io/api/admission/v1"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 )
 
@@ -21,6 +24,10 @@ type workloadDefaulter struct {
 	*mesh_install.Installer
 	*gmapi.CLI
 	*admission.Decoder
+	// Sidecar configuration changes for workloads, applied outside of admission requests
+	sidecars *sidecarQueue
+	// The API specs last added to workloads' Catalog entries
+	apiSpecs *apiSpecTracker
 }
 
 // InjectDecoder implements admission.DecoderInjector.
@@ -35,23 +42,24 @@ func (wd *workloadDefaulter) InjectDecoder(d *admission.Decoder) error {
 // or when creating or updating pods.
 func (wd *workloadDefaulter) Handle(ctx context.Context, req admission.Request) admission.Response {
 	if req.Kind.Kind == "Pod" {
-		return wd.handlePod(req)
+		return wd.handlePod(ctx, req)
 	}
 	return wd.handleWorkload(req)
 }
 
-func (wd *workloadDefaulter) handlePod(req admission.Request) admission.Response {
+func (wd *workloadDefaulter) handlePod(ctx context.Context, req admission.Request) admission.Response {
 	if req.Operation == admissionv1.Delete {
 		return admission.ValidationResponse(true, "allowed")
 	}
 
 	// If there's no mesh, don't assist deployment
-	if wd.Mesh.Name == "" || wd.Installer.Mesh.UID == "" {
+	mesh := wd.CurrentMesh()
+	if mesh == nil || mesh.Name == "" || mesh.UID == "" {
 		return admission.ValidationResponse(true, "allowed")
 	}
 	// If the pod isn't in a watched namespace, don't assist deployment
 	watched := false
-	for _, ns := range wd.Mesh.Spec.WatchNamespaces {
+	for _, ns := range mesh.Spec.WatchNamespaces {
 		if req.Namespace == ns {
 			watched = true
 			break
@@ -66,33 +74,135 @@ func (wd *workloadDefaulter) handlePod(req admission.Request) admission.Response
 		return admission.Errored(http.StatusInternalServerError, err)
 	}
 
+	original := pod.DeepCopy()
+	wd.injectSidecar(ctx, req, pod)
+	reconcilePod(req, mesh, pod)
+	if equality.Semantic.DeepEqual(original, pod) {
+		return admission.ValidationResponse(true, "allowed")
+	}
+
+	rawUpdate, err := json.Marshal(pod)
+	if err != nil {
+		logger.Error(err, "Failed to decode corev1.Pod", "Name", req.Name, "Namespace", req.Namespace)
+		return admission.ValidationResponse(false, "failed to decode")
+	}
+
+	return admission.PatchResponseFromRaw(req.Object.Raw, rawUpdate)
+}
+
+// injectSidecar injects a sidecar into a pod that asks for one with the inject-sidecar-to annotation, if it's eligible
+// for one under THE mesh's injection policy and its workload was labeled with a cluster.
+func (wd *workloadDefaulter) injectSidecar(ctx context.Context, req admission.Request, pod *corev1.Pod) {
 	annotations := pod.Annotations
 	if injectSidecarTo, injectSidecar := annotations[wellknown.ANNOTATION_INJECT_SIDECAR_TO_PORT]; !injectSidecar || injectSidecarTo == "" {
 		logger.Info("No inject-sidecar-to annotation, skipping", "name", req.Name, "annotations", annotations)
-		return admission.ValidationResponse(true, "allowed")
+		return
+	}
+	if !wd.FeatureEnabled(cuemodule.FeatureWebhookInjection) {
+		logger.Info("Sidecar injection is switched off by config.features, skipping", "name", req.Name, "namespace", req.Namespace)
+		return
+	}
+	if ok, reason := wd.InjectionAllowed(req.Namespace, pod.Labels, annotations); !ok {
+		logger.Info("Not eligible for sidecar injection, skipping", "name", req.Name, "namespace", req.Namespace, "reason", reason)
+		return
+	}
+	image, ok, reason := wd.SidecarPlatform(pod.Spec)
+	if !ok {
+		logger.Info("Sidecar can't run on the pod's nodes, skipping", "name", req.Name, "namespace", req.Namespace, "reason", reason)
+		return
 	}
 
 	// Check for a cluster label; if not found, this pod does not belong to a Mesh.
 	clusterLabel, ok := pod.Labels[wellknown.LABEL_CLUSTER]
 	if !ok {
-		return admission.ValidationResponse(true, "allowed")
+		return
 	}
 	// Check for an existing proxy port; if found, this pod already has a sidecar.
 	for _, container := range pod.Spec.Containers {
 		for _, p := range container.Ports {
 			if p.Name == "proxy" {
-				return admission.ValidationResponse(true, "allowed")
+				return
 			}
 		}
 	}
 
-	container, volumes, err := wd.OperatorCUE.UnifyAndExtractSidecar(clusterLabel)
+	// Merge in the workload's own envoy bootstrap config, if any
+	override, err := bootstrapOverride(wd.K8sClient, req.Namespace, annotations)
 	if err != nil {
-		return admission.ValidationResponse(true, "allowed")
+		logger.Error(err, "Not overriding sidecar bootstrap config", "name", req.Name, "namespace", req.Namespace)
+		override = ""
+	}
+	container, volumes, err := wd.CurrentOperatorCUE().UnifyAndExtractSidecar(clusterLabel, override)
+	if err != nil && override != "" {
+		logger.Error(err, "Not overriding sidecar bootstrap config", "name", req.Name, "namespace", req.Namespace)
+		container, volumes, err = wd.CurrentOperatorCUE().UnifyAndExtractSidecar(clusterLabel, "")
+	}
+	if err != nil {
+		return
+	}
+
+	// Windows pods get a sidecar built for Windows, which can't capture their traffic with iptables
+	windows := image != ""
+	if windows {
+		container.Image = image
+	} else {
+		// Others get the build of the sidecar for their nodes' architecture, if its image is built for a single one
+		spec := pod.Spec.DeepCopy()
+		n := len(spec.Containers)
+		spec.Containers = append(spec.Containers, container)
+		if err := wd.SelectArchImages(spec); err != nil {
+			logger.Error(err, "Sidecar can't run on the pod's nodes, skipping", "name", req.Name, "namespace", req.Namespace)
+			return
+		}
+		container, spec.Containers = spec.Containers[n], spec.Containers[:n]
+		pod.Spec = *spec
+	}
+
+	// Optionally capture the pod's traffic transparently, so apps needn't target the sidecar port
+	if windows {
+		if _, ok := annotations[wellknown.ANNOTATION_TRAFFIC_REDIRECT]; ok {
+			logger.Info("Not redirecting traffic through Windows sidecar", "name", req.Name, "namespace", req.Namespace)
+		}
+	} else if initContainer, redirect, err := trafficRedirectInitContainer(wd.CurrentDefaults().TrafficRedirectImage, &container, annotations); err != nil {
+		logger.Error(err, "Not redirecting traffic through sidecar", "name", req.Name, "namespace", req.Namespace)
+	} else if redirect {
+		pod.Spec.InitContainers = append(pod.Spec.InitContainers, initContainer)
+	}
+
+	// Keep the sidecar off Windows nodes in mixed-OS clusters, if the pod doesn't choose its OS
+	if wd.Config.NodePlatforms.PinLinux {
+		cuemodule.PinPodToLinux(&pod.Spec)
 	}
 
-	pod.Spec.Containers = append(pod.Spec.Containers, container)
 	pod.Spec.Volumes = append(pod.Spec.Volumes, volumes...)
+
+	// Request the resources recommended from the usage of the workload's sidecars, if auto-applied
+	if recommended, ok := wd.RecommendedSidecarRequests(req.Namespace, clusterLabel); ok {
+		applySidecarRequests(&container, recommended)
+	}
+
+	// Identify the sidecar by the pod's ServiceAccount if SPIRE isn't issuing identities
+	if wd.ServiceAccountIdentity() {
+		serviceAccount := podServiceAccount(pod)
+		if secretName, err := wd.EnsureWorkloadIdentity(ctx, req.Namespace, serviceAccount); err != nil {
+			logger.Error(err, "Failed to issue sidecar identity", "name", req.Name, "namespace", req.Namespace, "serviceAccount", serviceAccount)
+		} else {
+			mesh_install.InjectIdentity(&pod.Spec, &container, secretName)
+		}
+	}
+
+	pod.Spec.Containers = append(pod.Spec.Containers, container)
+	// Have Prometheus scrape the sidecar, without overriding the pod's own scrape annotations
+	if scrape := wd.ScrapeAnnotations(container); scrape != nil {
+		if pod.Annotations == nil {
+			pod.Annotations = make(map[string]string)
+		}
+		if _, ok := pod.Annotations["prometheus.io/scrape"]; !ok {
+			for k, v := range scrape {
+				pod.Annotations[k] = v
+			}
+		}
+	}
 	logger.Info("injected sidecar", "name", clusterLabel, "kind", "Pod", "generateName", pod.GenerateName+"*", "namespace", req.Namespace)
 
 	// Inject a reference to the image pull secret
@@ -105,34 +215,27 @@ func (wd *workloadDefaulter) handlePod(req admission.Request) admission.Response
 	if !hasImagePullSecret {
 		pod.Spec.ImagePullSecrets = append(pod.Spec.ImagePullSecrets, corev1.LocalObjectReference{Name: "gm-docker-secret"})
 	}
-
-	rawUpdate, err := json.Marshal(pod)
-	if err != nil {
-		logger.Error(err, "Failed to decode corev1.Pod", "Name", req.Name, "Namespace", req.Namespace)
-		return admission.ValidationResponse(false, "failed to decode")
-	}
-
-	return admission.PatchResponseFromRaw(req.Object.Raw, rawUpdate)
 }
 
 // TODO: Modification should happen using a CUE package.
 func (wd *workloadDefaulter) handleWorkload(req admission.Request) admission.Response {
 	// If there's no mesh, don't assist deployment
-	meshName := wd.Mesh.Name                           // wd.WatchedBy(req.Namespace)
-	if meshName == "" || wd.Installer.Mesh.UID == "" { // If the mesh isn't actually applied, don't assist deployments
+	mesh := wd.CurrentMesh()
+	if mesh == nil || mesh.Name == "" || mesh.UID == "" { // If the mesh isn't actually applied, don't assist deployments
 		return admission.ValidationResponse(true, "allowed")
 	}
+	meshName := mesh.Name // wd.WatchedBy(req.Namespace)
 
 	// If the workload isn't in a watched namespace, don't assist deployment
 	// TODO also need the install namespace in here
 	watched := false
-	for _, ns := range wd.Mesh.Spec.WatchNamespaces {
+	for _, ns := range mesh.Spec.WatchNamespaces {
 		if req.Namespace == ns {
 			watched = true
 			break
 		}
 	}
-	if req.Namespace == wd.Mesh.Spec.InstallNamespace {
+	if req.Namespace == mesh.Spec.InstallNamespace {
 		watched = true
 	}
 	if !watched {
@@ -150,8 +253,12 @@ func (wd *workloadDefaulter) handleWorkload(req admission.Request) admission.Res
 			if deployment.Spec.Template.Annotations == nil {
 				deployment.Spec.Template.Annotations = make(map[string]string)
 			}
+			if reason := wd.handleAdoption(req, deployment.Annotations, &deployment.Spec.Template); reason != "" {
+				return admission.ValidationResponse(false, reason)
+			}
 			deployment.Spec.Template.Annotations[wellknown.ANNOTATION_LAST_APPLIED] = time.Now().String()
 			deployment.Spec.Template = addClusterLabels(deployment.Spec.Template, meshName, req.Name)
+			reconcileWorkload(req, mesh, &deployment.Spec.Template)
 			rawUpdate, err = json.Marshal(deployment)
 			if err != nil {
 				logger.Error(err, "Failed to add cluster label to Deployment", "Name", req.Name, "Namespace", req.Namespace)
@@ -162,20 +269,35 @@ func (wd *workloadDefaulter) handleWorkload(req admission.Request) admission.Res
 			annotations := deployment.Spec.Template.Annotations
 			_, injectSidecar := annotations[wellknown.ANNOTATION_INJECT_SIDECAR_TO_PORT]
 			if injectSidecar {
-				go func() {
-					wd.ConfigureSidecar(wd.OperatorCUE, req.Name, annotations)
-				}()
+				if !wd.FeatureEnabled(cuemodule.FeatureWebhookInjection) {
+					logger.Info("Sidecar injection is switched off by config.features, skipping configuration", "kind", req.Kind.Kind, "name", req.Name, "namespace", req.Namespace)
+					injectSidecar = false
+				} else if ok, reason := wd.InjectionAllowed(req.Namespace, deployment.Spec.Template.Labels, annotations); !ok {
+					logger.Info("Not eligible for sidecar injection, skipping configuration", "kind", req.Kind.Kind, "name", req.Name, "namespace", req.Namespace, "reason", reason)
+					injectSidecar = false
+				} else if _, ok, reason := wd.SidecarPlatform(deployment.Spec.Template.Spec); !ok {
+					logger.Info("Sidecar can't run on the workload's nodes, skipping configuration", "kind", req.Kind.Kind, "name", req.Name, "namespace", req.Namespace, "reason", reason)
+					injectSidecar = false
+				}
+			}
+			if injectSidecar {
+				wd.sidecars.add(req.Namespace, req.Name, sidecarWork{
+					configure:      true,
+					annotations:    withNamespaceEgress(wd.K8sClient, req.Namespace, annotations),
+					previousEgress: wd.previousEgress(req),
+				})
+			} else {
+				wd.removeSidecar(req)
 			}
 
 		} else { // if this Deployment is being deleted...
 			wd.DecodeRaw(req.OldObject, deployment)
+			wd.ForgetAdoption(req.Kind.Kind, req.Namespace, req.Name)
 
 			annotations := deployment.Spec.Template.Annotations
 			_, injectSidecar := annotations[wellknown.ANNOTATION_INJECT_SIDECAR_TO_PORT]
 			if injectSidecar {
-				go func() {
-					wd.UnconfigureSidecar(wd.OperatorCUE, req.Name, annotations)
-				}()
+				wd.sidecars.add(req.Namespace, req.Name, sidecarWork{configure: false, annotations: withNamespaceEgress(wd.K8sClient, req.Namespace, annotations)})
 			}
 			return admission.ValidationResponse(true, "allowed")
 		}
@@ -187,8 +309,12 @@ func (wd *workloadDefaulter) handleWorkload(req admission.Request) admission.Res
 			if statefulset.Annotations == nil {
 				statefulset.Annotations = make(map[string]string)
 			}
+			if reason := wd.handleAdoption(req, statefulset.Annotations, &statefulset.Spec.Template); reason != "" {
+				return admission.ValidationResponse(false, reason)
+			}
 			statefulset.Annotations[wellknown.ANNOTATION_LAST_APPLIED] = time.Now().String()
 			statefulset.Spec.Template = addClusterLabels(statefulset.Spec.Template, meshName, req.Name)
+			reconcileWorkload(req, mesh, &statefulset.Spec.Template)
 			rawUpdate, err = json.Marshal(statefulset)
 			if err != nil {
 				logger.Error(err, "Failed to add cluster label to StatefulSet", "Name", req.Name, "Namespace", req.Namespace)
@@ -199,20 +325,35 @@ func (wd *workloadDefaulter) handleWorkload(req admission.Request) admission.Res
 			annotations := statefulset.Spec.Template.Annotations
 			_, injectSidecar := annotations[wellknown.ANNOTATION_INJECT_SIDECAR_TO_PORT]
 			if injectSidecar {
-				go func() {
-					wd.ConfigureSidecar(wd.OperatorCUE, req.Name, annotations)
-				}()
+				if !wd.FeatureEnabled(cuemodule.FeatureWebhookInjection) {
+					logger.Info("Sidecar injection is switched off by config.features, skipping configuration", "kind", req.Kind.Kind, "name", req.Name, "namespace", req.Namespace)
+					injectSidecar = false
+				} else if ok, reason := wd.InjectionAllowed(req.Namespace, statefulset.Spec.Template.Labels, annotations); !ok {
+					logger.Info("Not eligible for sidecar injection, skipping configuration", "kind", req.Kind.Kind, "name", req.Name, "namespace", req.Namespace, "reason", reason)
+					injectSidecar = false
+				} else if _, ok, reason := wd.SidecarPlatform(statefulset.Spec.Template.Spec); !ok {
+					logger.Info("Sidecar can't run on the workload's nodes, skipping configuration", "kind", req.Kind.Kind, "name", req.Name, "namespace", req.Namespace, "reason", reason)
+					injectSidecar = false
+				}
+			}
+			if injectSidecar {
+				wd.sidecars.add(req.Namespace, req.Name, sidecarWork{
+					configure:      true,
+					annotations:    withNamespaceEgress(wd.K8sClient, req.Namespace, annotations),
+					previousEgress: wd.previousEgress(req),
+				})
+			} else {
+				wd.removeSidecar(req)
 			}
 
 		} else { // if this StatefulSet is being deleted...
 			wd.DecodeRaw(req.OldObject, statefulset)
+			wd.ForgetAdoption(req.Kind.Kind, req.Namespace, req.Name)
 
 			annotations := statefulset.Spec.Template.Annotations
 			_, injectSidecar := annotations[wellknown.ANNOTATION_INJECT_SIDECAR_TO_PORT]
 			if injectSidecar {
-				go func() {
-					wd.UnconfigureSidecar(wd.OperatorCUE, req.Name, annotations)
-				}()
+				wd.sidecars.add(req.Namespace, req.Name, sidecarWork{configure: false, annotations: withNamespaceEgress(wd.K8sClient, req.Namespace, annotations)})
 			}
 			return admission.ValidationResponse(true, "allowed")
 		}
@@ -221,6 +362,60 @@ func (wd *workloadDefaulter) handleWorkload(req admission.Request) admission.Res
 	return admission.PatchResponseFromRaw(req.Object.Raw, rawUpdate)
 }
 
+// previousTemplate returns the pod template of an updated Deployment or StatefulSet from before the update, or nil for
+// other requests.
+func (wd *workloadDefaulter) previousTemplate(req admission.Request) *corev1.PodTemplateSpec {
+	if req.Operation != admissionv1.Update {
+		return nil
+	}
+	switch req.Kind.Kind {
+	case "Deployment":
+		deployment := &appsv1.Deployment{}
+		if err := wd.DecodeRaw(req.OldObject, deployment); err != nil {
+			return nil
+		}
+		return &deployment.Spec.Template
+	case "StatefulSet":
+		statefulset := &appsv1.StatefulSet{}
+		if err := wd.DecodeRaw(req.OldObject, statefulset); err != nil {
+			return nil
+		}
+		return &statefulset.Spec.Template
+	}
+	return nil
+}
+
+// removeSidecar removes the sidecar configuration of an updated Deployment or StatefulSet whose pod template asked for
+// a sidecar before the update, but no longer does or is no longer eligible for one. Its pods are rolled out without
+// the sidecar, since the template changed, and the configuration is removed with the annotations it was made from.
+func (wd *workloadDefaulter) removeSidecar(req admission.Request) {
+	previous := wd.previousTemplate(req)
+	if !wd.SidecarRequested(req.Namespace, previous) {
+		return
+	}
+	logger.Info("Sidecar removed, unconfiguring", "kind", req.Kind.Kind, "name", req.Name, "namespace", req.Namespace)
+	wd.sidecars.add(req.Namespace, req.Name, sidecarWork{configure: false, annotations: withNamespaceEgress(wd.K8sClient, req.Namespace, previous.Annotations)})
+}
+
+// applySidecarWork applies or removes a workload's sidecar configuration in Grey Matter.
+func (wd *workloadDefaulter) applySidecarWork(key types.NamespacedName, work sidecarWork) error {
+	if work.configure {
+		annotations := wd.withAPISpec(key.Name, key.Namespace, work.annotations)
+		if err := wd.ConfigureSidecar(wd.CurrentOperatorCUE(), key.Name, annotations); err != nil {
+			return err
+		}
+	} else {
+		if err := wd.UnconfigureSidecar(wd.CurrentOperatorCUE(), key.Name, work.annotations); err != nil {
+			return err
+		}
+		wd.apiSpecs.record(key.Name, nil)
+	}
+	if work.previousEgress != "" {
+		return wd.RemoveStaleSidecarEgress(wd.CurrentOperatorCUE(), key.Name, work.previousEgress, work.annotations)
+	}
+	return nil
+}
+
 func addClusterLabels(tmpl corev1.PodTemplateSpec, meshName, clusterName string) corev1.PodTemplateSpec {
 	if tmpl.Labels == nil {
 		tmpl.Labels = make(map[string]string)
@@ -231,3 +426,16 @@ func addClusterLabels(tmpl corev1.PodTemplateSpec, meshName, clusterName string)
 	tmpl.Labels[wellknown.LABEL_WORKLOAD] = fmt.Sprintf("%s.%s", meshName, clusterName)
 	return tmpl
 }
+
+// applySidecarRequests sets the sidecar's requests to those recommended, but no higher than its limits.
+func applySidecarRequests(container *corev1.Container, recommended corev1.ResourceList) {
+	if container.Resources.Requests == nil {
+		container.Resources.Requests = corev1.ResourceList{}
+	}
+	for name, quantity := range recommended {
+		if limit, ok := container.Resources.Limits[name]; ok && quantity.Cmp(limit) > 0 {
+			quantity = limit
+		}
+		container.Resources.Requests[name] = quantity
+	}
+}
@@ -0,0 +1,79 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/greymatter-io/operator/pkg/mesh_install"
+	"github.com/greymatter-io/operator/pkg/wellknown"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestRemoveSidecar(t *testing.T) {
+	decoder, err := admission.NewDecoder(clientgoscheme.Scheme)
+	assert.NoError(t, err)
+	var processed []sidecarWork
+	wd := &workloadDefaulter{
+		Installer: &mesh_install.Installer{K8sClient: fake.NewClientBuilder().Build()},
+		Decoder:   decoder,
+		sidecars: newSidecarQueue(func(key types.NamespacedName, work sidecarWork) error {
+			processed = append(processed, work)
+			return nil
+		}),
+	}
+	update := func(annotations map[string]string) admission.Request {
+		deployment := &appsv1.Deployment{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "apps"},
+			Spec:       appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Annotations: annotations}}},
+		}
+		raw, err := json.Marshal(deployment)
+		assert.NoError(t, err)
+		return admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+			Kind:      metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"},
+			Operation: admissionv1.Update,
+			Namespace: "apps",
+			Name:      "web",
+			OldObject: runtime.RawExtension{Raw: raw},
+		}}
+	}
+
+	// Never had a sidecar
+	wd.removeSidecar(update(nil))
+	// Excluded before the update, so never configured
+	wd.removeSidecar(update(map[string]string{wellknown.ANNOTATION_INJECT_SIDECAR_TO_PORT: "8080", wellknown.ANNOTATION_EXCLUDE_SIDECAR: "true"}))
+	assert.Equal(t, 0, wd.sidecars.queue.Len())
+
+	wd.removeSidecar(update(map[string]string{wellknown.ANNOTATION_INJECT_SIDECAR_TO_PORT: "8080", wellknown.ANNOTATION_CONFIGURE_SIDECAR: "true"}))
+	wd.sidecars.queue.ShutDown()
+	for wd.sidecars.processNext() {
+	}
+	if assert.Len(t, processed, 1) {
+		assert.False(t, processed[0].configure)
+		assert.Equal(t, "8080", processed[0].annotations[wellknown.ANNOTATION_INJECT_SIDECAR_TO_PORT])
+	}
+}
+
+func TestApplySidecarRequests(t *testing.T) {
+	container := corev1.Container{Resources: corev1.ResourceRequirements{
+		Limits:   corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("64Mi")},
+		Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")},
+	}}
+	applySidecarRequests(&container, corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("25m"),
+		corev1.ResourceMemory: resource.MustParse("96Mi"),
+	})
+	assert.Equal(t, resource.MustParse("25m"), container.Resources.Requests[corev1.ResourceCPU])
+	// Requests never exceed limits
+	assert.Equal(t, resource.MustParse("64Mi"), container.Resources.Requests[corev1.ResourceMemory])
+}
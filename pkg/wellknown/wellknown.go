@@ -4,6 +4,54 @@ const (
 	ANNOTATION_INJECT_SIDECAR_TO_PORT = "greymatter.io/inject-sidecar-to" // whether to inject sidecar, and upstream port
 	ANNOTATION_CONFIGURE_SIDECAR      = "greymatter.io/configure-sidecar" // whether to apply automatic configuration to sidecar
 	ANNOTATION_LAST_APPLIED           = "greymatter.io/last-applied"
+	ANNOTATION_EXCLUDE_SIDECAR        = "greymatter.io/exclude-sidecar" // "true" to never inject a sidecar, regardless of other settings
 	LABEL_CLUSTER                     = "greymatter.io/cluster"
 	LABEL_WORKLOAD                    = "greymatter.io/workload"
+	LABEL_MANAGED_BY                  = "greymatter.io/managed-by" // marks objects applied (and prunable) by the operator with its identity
+	MANAGED_BY_OPERATOR               = "gm-operator"              // the operator's default identity
+
+	// The CustomResourceDefinitions installed and upgraded by the operator
+	ANNOTATION_CRD_SCHEMA_HASH = "greymatter.io/crd-schema-hash" // hash of the embedded definition a CRD was last applied from
+
+	// The OperatorInstallation owning the operator's cluster-scoped resources
+	FINALIZER_CLEANUP = "greymatter.io/cleanup" // held until the resources the installation owns are cleaned up
+
+	// Adoption of a pre-existing Deployment or StatefulSet into the mesh, set on the workload itself and removed once handled
+	ANNOTATION_ADOPT = "greymatter.io/adopt" // the port to inject a sidecar to, or ADOPT_ROLLBACK
+	ADOPT_ROLLBACK   = "rollback"            // removes what the workload's adoption added
+
+	// Standard labels kept on a mesh's install and watched namespaces
+	LABEL_MESH             = "greymatter.io/mesh"             // the name of the mesh the namespace (or managed object) belongs to
+	LABEL_OPERATOR_VERSION = "greymatter.io/operator-version" // the version of the operator managing the mesh
+	LABEL_INJECTION        = "greymatter.io/injection"        // INJECTION_ENABLED or INJECTION_DISABLED
+	INJECTION_ENABLED      = "enabled"                        // the namespace's workloads are eligible for sidecar injection
+	INJECTION_DISABLED     = "disabled"                       // they aren't (system namespaces, excluded, or not selected)
+
+	// ServiceAccount identities for sidecars, when not issued by SPIRE
+	LABEL_IDENTITY      = "greymatter.io/identity"   // the ServiceAccount whose certificate a Secret holds
+	IDENTITY_MOUNT_PATH = "/etc/greymatter/identity" // where the certificate and ServiceAccount token are mounted in sidecars
+
+	// Transparent traffic capture for injected sidecars
+	ANNOTATION_TRAFFIC_REDIRECT               = "greymatter.io/traffic-redirect"               // "inbound" or "all" to capture pod traffic with iptables
+	ANNOTATION_TRAFFIC_REDIRECT_OUTBOUND_PORT = "greymatter.io/traffic-redirect-outbound-port" // sidecar listener for captured outbound traffic
+
+	// Envoy bootstrap config merged into injected sidecars, for e.g. custom tracing or access log sinks
+	ANNOTATION_BOOTSTRAP_OVERRIDE           = "greymatter.io/bootstrap-override"           // the override's YAML or JSON
+	ANNOTATION_BOOTSTRAP_OVERRIDE_CONFIGMAP = "greymatter.io/bootstrap-override-configmap" // "<name>" or "<name>/<key>" of a ConfigMap in the pod's namespace with it
+
+	// External dependencies routed to by a workload's sidecar, set on the workload's pod template or its Namespace
+	ANNOTATION_EGRESS = "greymatter.io/egress" // comma-separated http(s) URLs or host:ports
+
+	// How a workload describes itself in Catalog, set on its pod template
+	ANNOTATION_CATALOG_NAME         = "greymatter.io/catalog-name"
+	ANNOTATION_CATALOG_DESCRIPTION  = "greymatter.io/catalog-description"
+	ANNOTATION_CATALOG_OWNER        = "greymatter.io/catalog-owner"
+	ANNOTATION_CATALOG_DOCS         = "greymatter.io/catalog-docs"         // documentation URL
+	ANNOTATION_CATALOG_API_ENDPOINT = "greymatter.io/catalog-api-endpoint" // path or URL of the service's API
+	ANNOTATION_CATALOG_VERSION      = "greymatter.io/catalog-version"
+
+	// A workload's OpenAPI (or Swagger) spec, whose description and version are added to its Catalog entry and kept
+	// in sync with it, set on its pod template
+	ANNOTATION_CATALOG_API_SPEC           = "greymatter.io/catalog-api-spec"           // URL of the spec, also set as the entry's api_spec_endpoint
+	ANNOTATION_CATALOG_API_SPEC_CONFIGMAP = "greymatter.io/catalog-api-spec-configmap" // "<name>" or "<name>/<key>" of a ConfigMap in the pod's namespace with it
 )
@@ -0,0 +1,389 @@
+package xds
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// The types of the resources served.
+const (
+	typeListener           = "type.googleapis.com/envoy.config.listener.v3.Listener"
+	typeRouteConfiguration = "type.googleapis.com/envoy.config.route.v3.RouteConfiguration"
+	typeCluster            = "type.googleapis.com/envoy.config.cluster.v3.Cluster"
+
+	typeHTTPConnectionManager = "type.googleapis.com/envoy.extensions.filters.network.http_connection_manager.v3.HttpConnectionManager"
+	typeRouter                = "type.googleapis.com/envoy.extensions.filters.http.router.v3.Router"
+	typeUpstreamTLSContext    = "type.googleapis.com/envoy.extensions.transport_sockets.tls.v3.UpstreamTlsContext"
+	typeHTTPProtocolOptions   = "type.googleapis.com/envoy.extensions.upstreams.http.v3.HttpProtocolOptions"
+)
+
+// objects are the Grey Matter config objects of each kind, by key.
+type objects map[string]map[string]json.RawMessage
+
+// resources returns the resources of the given type for the proxy named by a sidecar's node cluster, limited to the
+// given names, if any.
+func (o objects) resources(node, typeURL string, names []string) ([]json.RawMessage, error) {
+	proxy, ok := o.proxy(node)
+	if !ok {
+		return nil, fmt.Errorf("no proxy named %q", node)
+	}
+	var resources []map[string]interface{}
+	switch typeURL {
+	case typeListener:
+		for _, listener := range o.listeners(proxy) {
+			resources = append(resources, translateListener(listener))
+		}
+	case typeRouteConfiguration:
+		for _, listener := range o.listeners(proxy) {
+			resources = append(resources, o.translateRouteConfiguration(proxy, listener))
+		}
+	case typeCluster:
+		for _, cluster := range o.clusters(proxy) {
+			resources = append(resources, translateCluster(cluster))
+		}
+	default:
+		return nil, fmt.Errorf("unsupported type %s", typeURL)
+	}
+
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = true
+	}
+	rendered := make([]json.RawMessage, 0, len(resources))
+	for _, resource := range resources {
+		if len(wanted) > 0 && !wanted[resource["name"].(string)] {
+			continue
+		}
+		resource["@type"] = typeURL
+		b, err := json.Marshal(resource)
+		if err != nil {
+			return nil, err
+		}
+		rendered = append(rendered, b)
+	}
+	return rendered, nil
+}
+
+// proxy returns the proxy with the given name or key.
+func (o objects) proxy(name string) (gjson.Result, bool) {
+	if obj, ok := o["proxy"][name]; ok {
+		return gjson.ParseBytes(obj), true
+	}
+	for _, key := range sortedKeys(o["proxy"]) {
+		if proxy := gjson.ParseBytes(o["proxy"][key]); proxy.Get("name").String() == name {
+			return proxy, true
+		}
+	}
+	return gjson.Result{}, false
+}
+
+// listeners returns a proxy's listeners, in the order it lists them.
+func (o objects) listeners(proxy gjson.Result) []gjson.Result {
+	var listeners []gjson.Result
+	for _, key := range proxy.Get("listener_keys").Array() {
+		if obj, ok := o["listener"][key.String()]; ok {
+			listeners = append(listeners, gjson.ParseBytes(obj))
+		}
+	}
+	return listeners
+}
+
+// domains returns the domains served on a listener, or the proxy's if it lists none.
+func (o objects) domains(proxy, listener gjson.Result) []gjson.Result {
+	keys := listener.Get("domain_keys").Array()
+	if len(keys) == 0 {
+		keys = proxy.Get("domain_keys").Array()
+	}
+	var domains []gjson.Result
+	for _, key := range keys {
+		if obj, ok := o["domain"][key.String()]; ok {
+			domains = append(domains, gjson.ParseBytes(obj))
+		}
+	}
+	return domains
+}
+
+// routes returns a domain's routes, in the order Envoy should match them: high-priority routes first, then by
+// longest path.
+func (o objects) routes(domainKey string) []gjson.Result {
+	var routes []gjson.Result
+	for _, key := range sortedKeys(o["route"]) {
+		if route := gjson.ParseBytes(o["route"][key]); route.Get("domain_key").String() == domainKey {
+			routes = append(routes, route)
+		}
+	}
+	sort.SliceStable(routes, func(i, j int) bool {
+		if pi, pj := routes[i].Get("high_priority").Bool(), routes[j].Get("high_priority").Bool(); pi != pj {
+			return pi
+		}
+		return len(routePath(routes[i])) > len(routePath(routes[j]))
+	})
+	return routes
+}
+
+// clusters returns the clusters a proxy's routes lead to, sorted by key.
+func (o objects) clusters(proxy gjson.Result) []gjson.Result {
+	keys := make(map[string]bool)
+	for _, listener := range o.listeners(proxy) {
+		for _, domain := range o.domains(proxy, listener) {
+			for _, route := range o.routes(domain.Get("domain_key").String()) {
+				for _, target := range o.routeTargets(route) {
+					keys[target.Get("cluster_key").String()] = true
+				}
+			}
+		}
+	}
+	var clusters []gjson.Result
+	for _, key := range sortedKeys(o["cluster"]) {
+		if keys[key] {
+			clusters = append(clusters, gjson.ParseBytes(o["cluster"][key]))
+		}
+	}
+	return clusters
+}
+
+// routeTargets returns the clusters a route sends its traffic to, with their weights: those of its first rule, or
+// else of its shared rules.
+func (o objects) routeTargets(route gjson.Result) []gjson.Result {
+	if light := route.Get("rules.0.constraints.light").Array(); len(light) > 0 {
+		return light
+	}
+	if shared, ok := o["sharedrules"][route.Get("shared_rules_key").String()]; ok {
+		return gjson.GetBytes(shared, "default.light").Array()
+	}
+	return nil
+}
+
+func translateListener(listener gjson.Result) map[string]interface{} {
+	key := listener.Get("listener_key").String()
+	address := listener.Get("ip").String()
+	if address == "" {
+		address = "0.0.0.0"
+	}
+	hcm := map[string]interface{}{
+		"@type":       typeHTTPConnectionManager,
+		"stat_prefix": key,
+		"rds": map[string]interface{}{
+			"route_config_name": key,
+			"config_source":     configSource(),
+		},
+		"http_filters": []interface{}{
+			map[string]interface{}{"name": "envoy.filters.http.router", "typed_config": map[string]interface{}{"@type": typeRouter}},
+		},
+	}
+	if listener.Get("use_remote_address").Bool() {
+		hcm["use_remote_address"] = true
+	}
+	for field, timeout := range map[string]string{
+		"stream_idle_timeout": listener.Get("stream_idle_timeout").String(),
+		"request_timeout":     listener.Get("request_timeout").String(),
+		"drain_timeout":       listener.Get("drain_timeout").String(),
+	} {
+		if d, ok := duration(timeout); ok {
+			hcm[field] = d
+		}
+	}
+	return map[string]interface{}{
+		"name": key,
+		"address": map[string]interface{}{
+			"socket_address": map[string]interface{}{"address": address, "port_value": listener.Get("port").Int()},
+		},
+		"filter_chains": []interface{}{
+			map[string]interface{}{"filters": []interface{}{
+				map[string]interface{}{"name": "envoy.filters.network.http_connection_manager", "typed_config": hcm},
+			}},
+		},
+	}
+}
+
+// configSource returns the source of the route config of the listeners served, the operator's xDS server.
+func configSource() map[string]interface{} {
+	return map[string]interface{}{
+		"resource_api_version": "V3",
+		"api_config_source": map[string]interface{}{
+			"api_type":              "REST",
+			"transport_api_version": "V3",
+			"cluster_names":         []string{ClusterName},
+			"refresh_delay":         refreshDelay,
+		},
+	}
+}
+
+// translateRouteConfiguration returns the route configuration of a listener, named after it, with a virtual host for
+// each of its domains.
+func (o objects) translateRouteConfiguration(proxy, listener gjson.Result) map[string]interface{} {
+	virtualHosts := []interface{}{}
+	for _, domain := range o.domains(proxy, listener) {
+		domainKey := domain.Get("domain_key").String()
+		routes := []interface{}{}
+		for _, route := range o.routes(domainKey) {
+			if translated, ok := o.translateRoute(route); ok {
+				routes = append(routes, translated)
+			}
+		}
+		virtualHosts = append(virtualHosts, map[string]interface{}{
+			"name":    domainKey,
+			"domains": virtualHostDomains(domain),
+			"routes":  routes,
+		})
+	}
+	return map[string]interface{}{
+		"name":          listener.Get("listener_key").String(),
+		"virtual_hosts": virtualHosts,
+	}
+}
+
+// virtualHostDomains returns the hosts a domain matches, with and without its port.
+func virtualHostDomains(domain gjson.Result) []string {
+	name := domain.Get("name").String()
+	if name == "" || name == "*" {
+		return []string{"*"}
+	}
+	hosts := []string{name}
+	if port := domain.Get("port").Int(); port > 0 {
+		hosts = append(hosts, fmt.Sprintf("%s:%d", name, port))
+	}
+	for _, alias := range domain.Get("aliases").Array() {
+		hosts = append(hosts, alias.String())
+	}
+	return hosts
+}
+
+// translateRoute returns the Envoy route of a route to one or more clusters; routes without any aren't served.
+func (o objects) translateRoute(route gjson.Result) (map[string]interface{}, bool) {
+	targets := o.routeTargets(route)
+	if len(targets) == 0 {
+		return nil, false
+	}
+	action := map[string]interface{}{}
+	if len(targets) == 1 {
+		action["cluster"] = targets[0].Get("cluster_key").String()
+	} else {
+		clusters := make([]interface{}, 0, len(targets))
+		for _, target := range targets {
+			weight := target.Get("weight").Int()
+			if weight <= 0 {
+				weight = 1
+			}
+			clusters = append(clusters, map[string]interface{}{"name": target.Get("cluster_key").String(), "weight": weight})
+		}
+		action["weighted_clusters"] = map[string]interface{}{"clusters": clusters}
+	}
+	if rewrite := route.Get("prefix_rewrite").String(); rewrite != "" {
+		action["prefix_rewrite"] = rewrite
+	}
+	if d, ok := duration(route.Get("timeout").String()); ok {
+		action["timeout"] = d
+	}
+	if d, ok := duration(route.Get("idle_timeout").String()); ok {
+		action["idle_timeout"] = d
+	}
+
+	path := routePath(route)
+	match := map[string]interface{}{"prefix": path}
+	switch strings.ToLower(route.Get("route_match.match_type").String()) {
+	case "exact":
+		match = map[string]interface{}{"path": path}
+	case "regex":
+		match = map[string]interface{}{"safe_regex": map[string]interface{}{"google_re2": map[string]interface{}{}, "regex": path}}
+	}
+	return map[string]interface{}{
+		"name":  route.Get("route_key").String(),
+		"match": match,
+		"route": action,
+	}, true
+}
+
+// routePath returns the path a route matches, "/" if it sets none.
+func routePath(route gjson.Result) string {
+	if path := route.Get("route_match.path").String(); path != "" {
+		return path
+	}
+	if path := route.Get("path").String(); path != "" {
+		return path
+	}
+	return "/"
+}
+
+// The Envoy discovery types of the Grey Matter clusters' dns_type values.
+var discoveryTypes = map[string]string{"strict_dns": "STRICT_DNS", "logical_dns": "LOGICAL_DNS", "static": "STATIC"}
+
+func translateCluster(cluster gjson.Result) map[string]interface{} {
+	key := cluster.Get("cluster_key").String()
+	discoveryType, resolved := discoveryTypes[strings.ToLower(cluster.Get("dns_type").String())]
+	endpoints := []interface{}{}
+	for _, instance := range cluster.Get("instances").Array() {
+		host := instance.Get("host").String()
+		if !resolved && net.ParseIP(host) == nil {
+			discoveryType = "STRICT_DNS"
+		}
+		endpoints = append(endpoints, map[string]interface{}{"endpoint": map[string]interface{}{
+			"address": map[string]interface{}{
+				"socket_address": map[string]interface{}{"address": host, "port_value": instance.Get("port").Int()},
+			},
+		}})
+	}
+	if discoveryType == "" {
+		discoveryType = "STATIC"
+	}
+	connectTimeout, ok := duration(cluster.Get("connect_timeout").String())
+	if !ok {
+		connectTimeout = "5s"
+	}
+	translated := map[string]interface{}{
+		"name":            key,
+		"type":            discoveryType,
+		"connect_timeout": connectTimeout,
+		"load_assignment": map[string]interface{}{
+			"cluster_name": key,
+			"endpoints":    []interface{}{map[string]interface{}{"lb_endpoints": endpoints}},
+		},
+	}
+	if lbPolicy := cluster.Get("lb_policy").String(); lbPolicy != "" {
+		translated["lb_policy"] = strings.ToUpper(lbPolicy)
+	}
+	if cluster.Get("http2_protocol_options").Exists() || cluster.Get("protocol").String() == "http2" {
+		translated["typed_extension_protocol_options"] = map[string]interface{}{
+			"envoy.extensions.upstreams.http.v3.HttpProtocolOptions": map[string]interface{}{
+				"@type":                typeHTTPProtocolOptions,
+				"explicit_http_config": map[string]interface{}{"http2_protocol_options": map[string]interface{}{}},
+			},
+		}
+	}
+	if cluster.Get("require_tls").Bool() {
+		tlsContext := map[string]interface{}{"@type": typeUpstreamTLSContext}
+		if sni := cluster.Get("ssl_config.sni").String(); sni != "" {
+			tlsContext["sni"] = sni
+		}
+		translated["transport_socket"] = map[string]interface{}{"name": "envoy.transport_sockets.tls", "typed_config": tlsContext}
+	}
+	return translated
+}
+
+// duration returns a Grey Matter duration (e.g. "1m30s") as an Envoy one (e.g. "90s"); it's not ok if it's unset or
+// invalid.
+func duration(d string) (string, bool) {
+	if d == "" {
+		return "", false
+	}
+	parsed, err := time.ParseDuration(d)
+	if err != nil {
+		return "", false
+	}
+	return fmt.Sprintf("%gs", parsed.Seconds()), true
+}
+
+func sortedKeys(objs map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(objs))
+	for key := range objs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,242 @@
+// Package xds serves the Grey Matter config the operator extracts from CUE to sidecars directly, as Envoy v3 xDS
+// resources translated from its proxies, listeners, domains, routes, and clusters, so Control isn't needed.
+// Sidecars poll it over Envoy's REST-JSON xDS transport (api_type REST), over TLS, authenticating with a client
+// certificate.
+package xds
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+var (
+	logger = ctrl.Log.WithName("xds")
+)
+
+const (
+	// ClusterName is the cluster sidecars reach the operator's xDS server through, which their bootstrap config must
+	// define. Route config is fetched through it too.
+	ClusterName = "gm-operator-xds"
+	// How often sidecars poll for route config.
+	refreshDelay = "5s"
+)
+
+// The discovery services served, by path, and the type of the resources each returns.
+var typeURLs = map[string]string{
+	"/v3/discovery:listeners": typeListener,
+	"/v3/discovery:routes":    typeRouteConfiguration,
+	"/v3/discovery:clusters":  typeCluster,
+}
+
+// Server stores the Grey Matter config objects sent to it in place of Control, as a gmapi.ObjectStore, and serves
+// each sidecar the xDS resources of the proxy it runs. It is added to the controller manager as a Runnable, so it
+// only runs on the elected leader, whose mesh client sends it the config. It's only served over TLS, to sidecars
+// presenting a client certificate signed by one of the client CAs (see WithTLS).
+//
+//	POST /v3/discovery:listeners
+//	POST /v3/discovery:routes
+//	POST /v3/discovery:clusters
+//	              return the listeners, route configurations, and clusters of the proxy named by the requesting
+//	              node's cluster, as a DiscoveryResponse
+type Server struct {
+	addr string
+	mux  *http.ServeMux
+	// TLS certificate and key the server is served with, and the CAs of the client certificates it accepts
+	certFile, keyFile, clientCAFile string
+
+	mu      sync.RWMutex
+	objects map[string]map[string]json.RawMessage
+}
+
+// New returns a Server listening on the given address once started.
+func New(addr string, opts ...func(*Server)) *Server {
+	s := &Server{addr: addr, mux: http.NewServeMux(), objects: make(map[string]map[string]json.RawMessage)}
+	for path := range typeURLs {
+		s.mux.HandleFunc(path, s.handleDiscovery)
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// WithTLS serves xDS over TLS with the given certificate and key, to sidecars presenting a client certificate signed
+// by one of the PEM-encoded CAs in clientCAFile. All three are required for the server to start.
+func WithTLS(certFile, keyFile, clientCAFile string) func(*Server) {
+	return func(s *Server) {
+		s.certFile, s.keyFile, s.clientCAFile = certFile, keyFile, clientCAFile
+	}
+}
+
+// tlsConfig returns the TLS config xDS is served with, which requires a client certificate signed by the client CAs.
+func (s *Server) tlsConfig() (*tls.Config, error) {
+	if s.certFile == "" || s.keyFile == "" || s.clientCAFile == "" {
+		return nil, errors.New("a TLS certificate, key, and client CAs are required to serve xDS")
+	}
+	cert, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load xDS server certificate: %w", err)
+	}
+	pem, err := os.ReadFile(s.clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read xDS client CAs: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no PEM-encoded certificates in %s", s.clientCAFile)
+	}
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}, nil
+}
+
+// Start implements sigs.k8s.io/controller-runtime/pkg/manager.Runnable.
+func (s *Server) Start(ctx context.Context) error {
+	tlsConfig, err := s.tlsConfig()
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Addr: s.addr, Handler: s.mux, TLSConfig: tlsConfig, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	logger.Info("Starting xDS server", "Addr", s.addr)
+	if err := srv.ListenAndServeTLS("", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("xDS server failed: %w", err)
+	}
+	return nil
+}
+
+// Apply implements gmapi.ObjectStore.
+func (s *Server) Apply(kind, key string, obj json.RawMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.objects[kind] == nil {
+		s.objects[kind] = make(map[string]json.RawMessage)
+	}
+	s.objects[kind][key] = append(json.RawMessage(nil), obj...)
+}
+
+// Delete implements gmapi.ObjectStore.
+func (s *Server) Delete(kind, key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.objects[kind][key]
+	delete(s.objects[kind], key)
+	return ok
+}
+
+// Get implements gmapi.ObjectStore.
+func (s *Server) Get(kind, key string) (json.RawMessage, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	obj, ok := s.objects[kind][key]
+	return obj, ok
+}
+
+// List implements gmapi.ObjectStore.
+func (s *Server) List(kind string) []json.RawMessage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]string, 0, len(s.objects[kind]))
+	for key := range s.objects[kind] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	list := make([]json.RawMessage, 0, len(keys))
+	for _, key := range keys {
+		list = append(list, s.objects[kind][key])
+	}
+	return list
+}
+
+// discoveryRequest is the part of an Envoy DiscoveryRequest the server reads.
+type discoveryRequest struct {
+	VersionInfo string `json:"version_info"`
+	Node        struct {
+		ID      string `json:"id"`
+		Cluster string `json:"cluster"`
+	} `json:"node"`
+	ResourceNames []string `json:"resource_names"`
+}
+
+// discoveryResponse is an Envoy DiscoveryResponse.
+type discoveryResponse struct {
+	VersionInfo string            `json:"version_info"`
+	Resources   []json.RawMessage `json:"resources"`
+	TypeURL     string            `json:"type_url"`
+}
+
+func (s *Server) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var req discoveryRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid DiscoveryRequest: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	typeURL := typeURLs[r.URL.Path]
+	s.mu.RLock()
+	resources, err := s.snapshot().resources(req.Node.Cluster, typeURL, req.ResourceNames)
+	s.mu.RUnlock()
+	if err != nil {
+		// The sidecar keeps the config it has, and polls again
+		logger.Info("Not serving xDS resources", "node", req.Node.ID, "cluster", req.Node.Cluster, "type", typeURL, "reason", err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	resp := discoveryResponse{VersionInfo: version(resources), Resources: resources, TypeURL: typeURL}
+	if resp.VersionInfo != req.VersionInfo {
+		logger.Info("Serving changed xDS resources", "node", req.Node.ID, "cluster", req.Node.Cluster, "type", typeURL, "count", len(resources), "version", resp.VersionInfo)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logger.Error(err, "Failed to write DiscoveryResponse")
+	}
+}
+
+// snapshot returns the stored objects to translate; the caller holds the read lock while it's used.
+func (s *Server) snapshot() objects {
+	return objects(s.objects)
+}
+
+// version identifies a set of resources, so a sidecar's version only changes when its resources do.
+func version(resources []json.RawMessage) string {
+	h := sha256.New()
+	for _, resource := range resources {
+		h.Write(resource)
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
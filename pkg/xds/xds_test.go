@@ -0,0 +1,182 @@
+package xds
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func testServer() *Server {
+	s := New("")
+	for kind, objs := range map[string][]string{
+		"proxy":    {`{"proxy_key":"example","zone_key":"default-zone","name":"example","listener_keys":["example-ingress"],"domain_keys":["example-ingress"]}`},
+		"listener": {`{"listener_key":"example-ingress","zone_key":"default-zone","port":10808,"domain_keys":["example-ingress"],"request_timeout":"1m"}`},
+		"domain":   {`{"domain_key":"example-ingress","zone_key":"default-zone","name":"*","port":10808}`},
+		"route": {
+			`{"route_key":"example-root","domain_key":"example-ingress","zone_key":"default-zone","route_match":{"path":"/","match_type":"prefix"},"rules":[{"constraints":{"light":[{"cluster_key":"example-local","weight":1}]}}]}`,
+			`{"route_key":"example-api","domain_key":"example-ingress","zone_key":"default-zone","route_match":{"path":"/api/","match_type":"prefix"},"prefix_rewrite":"/","rules":[{"constraints":{"light":[{"cluster_key":"example-local","weight":3},{"cluster_key":"example-v2","weight":1}]}}]}`,
+			`{"route_key":"other","domain_key":"other","zone_key":"default-zone","rules":[{"constraints":{"light":[{"cluster_key":"other"}]}}]}`,
+		},
+		"cluster": {
+			`{"cluster_key":"example-local","zone_key":"default-zone","name":"local","instances":[{"host":"127.0.0.1","port":8080}]}`,
+			`{"cluster_key":"example-v2","zone_key":"default-zone","name":"example-v2","require_tls":true,"instances":[{"host":"example-v2.apps.svc","port":10808}]}`,
+			`{"cluster_key":"other","zone_key":"default-zone","name":"other"}`,
+		},
+	} {
+		for _, obj := range objs {
+			key := gjson.Get(obj, kind+"_key").String()
+			s.Apply(kind, key, json.RawMessage(obj))
+		}
+	}
+	return s
+}
+
+func discover(t *testing.T, s *Server, path, request string) (int, discoveryResponse) {
+	rec := httptest.NewRecorder()
+	s.mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(request)))
+	var resp discoveryResponse
+	if rec.Code == http.StatusOK {
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	}
+	return rec.Code, resp
+}
+
+func TestDiscovery(t *testing.T) {
+	s := testServer()
+	node := `{"node":{"id":"example-1","cluster":"example"}}`
+
+	code, listeners := discover(t, s, "/v3/discovery:listeners", node)
+	if assert.Equal(t, http.StatusOK, code) && assert.Len(t, listeners.Resources, 1) {
+		listener := gjson.ParseBytes(listeners.Resources[0])
+		assert.Equal(t, typeListener, listener.Get("@type").String())
+		assert.Equal(t, int64(10808), listener.Get("address.socket_address.port_value").Int())
+		hcm := listener.Get("filter_chains.0.filters.0.typed_config")
+		assert.Equal(t, "example-ingress", hcm.Get("rds.route_config_name").String())
+		assert.Equal(t, ClusterName, hcm.Get("rds.config_source.api_config_source.cluster_names.0").String())
+		assert.Equal(t, "60s", hcm.Get("request_timeout").String())
+	}
+
+	code, routes := discover(t, s, "/v3/discovery:routes", `{"node":{"cluster":"example"},"resource_names":["example-ingress"]}`)
+	if assert.Equal(t, http.StatusOK, code) && assert.Len(t, routes.Resources, 1) {
+		vhost := gjson.GetBytes(routes.Resources[0], "virtual_hosts.0")
+		assert.Equal(t, `["*"]`, vhost.Get("domains").Raw)
+		// Longer paths are matched first
+		assert.Equal(t, "/api/", vhost.Get("routes.0.match.prefix").String())
+		assert.Equal(t, "/", vhost.Get("routes.0.route.prefix_rewrite").String())
+		assert.Equal(t, int64(3), vhost.Get("routes.0.route.weighted_clusters.clusters.0.weight").Int())
+		assert.Equal(t, "example-local", vhost.Get("routes.1.route.cluster").String())
+	}
+	_, routes = discover(t, s, "/v3/discovery:routes", `{"node":{"cluster":"example"},"resource_names":["missing"]}`)
+	assert.Empty(t, routes.Resources)
+
+	// Only the clusters the proxy's routes lead to are served
+	code, clusters := discover(t, s, "/v3/discovery:clusters", node)
+	if assert.Equal(t, http.StatusOK, code) && assert.Len(t, clusters.Resources, 2) {
+		local, v2 := gjson.ParseBytes(clusters.Resources[0]), gjson.ParseBytes(clusters.Resources[1])
+		assert.Equal(t, "STATIC", local.Get("type").String())
+		assert.Equal(t, "127.0.0.1", local.Get("load_assignment.endpoints.0.lb_endpoints.0.endpoint.address.socket_address.address").String())
+		assert.Equal(t, "STRICT_DNS", v2.Get("type").String())
+		assert.Equal(t, "envoy.transport_sockets.tls", v2.Get("transport_socket.name").String())
+	}
+
+	// The version only changes with the node's resources
+	s.Apply("cluster", "other", json.RawMessage(`{"cluster_key":"other","zone_key":"default-zone","name":"changed"}`))
+	_, unchanged := discover(t, s, "/v3/discovery:clusters", node)
+	assert.Equal(t, clusters.VersionInfo, unchanged.VersionInfo)
+	assert.True(t, s.Delete("cluster", "example-v2"))
+	_, changed := discover(t, s, "/v3/discovery:clusters", node)
+	assert.NotEqual(t, clusters.VersionInfo, changed.VersionInfo)
+	assert.Len(t, changed.Resources, 1)
+
+	// Sidecars of unknown proxies are refused, so they keep their config
+	code, _ = discover(t, s, "/v3/discovery:listeners", `{"node":{"cluster":"missing"}}`)
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+// issue returns a PEM-encoded certificate and key for the template, signed by the parent (self-signed if nil).
+func issue(t *testing.T, template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, []byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template.NotBefore, template.NotAfter = time.Now().Add(-time.Minute), time.Now().Add(time.Hour)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+	return cert, key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestDiscoveryRequiresClientCertificate(t *testing.T) {
+	_, err := New("").tlsConfig()
+	assert.Error(t, err, "xDS isn't served without TLS and client CAs")
+
+	ca, caKey, caPEM, _ := issue(t, &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "mesh CA"},
+		IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}, nil, nil)
+	_, _, serverPEM, serverKeyPEM := issue(t, &x509.Certificate{SerialNumber: big.NewInt(2), IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}, ca, caKey)
+	_, _, clientPEM, clientKeyPEM := issue(t, &x509.Certificate{SerialNumber: big.NewInt(3), Subject: pkix.Name{CommonName: "example"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}, ca, caKey)
+	_, _, otherPEM, otherKeyPEM := issue(t, &x509.Certificate{SerialNumber: big.NewInt(4), Subject: pkix.Name{CommonName: "example"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}, nil, nil)
+
+	dir := t.TempDir()
+	for name, contents := range map[string][]byte{"ca.crt": caPEM, "tls.crt": serverPEM, "tls.key": serverKeyPEM} {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), contents, 0600))
+	}
+	s := testServer()
+	WithTLS(filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt"))(s)
+	config, err := s.tlsConfig()
+	if !assert.NoError(t, err) {
+		return
+	}
+	srv := httptest.NewUnstartedServer(s.mux)
+	srv.TLS = config
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	post := func(certPEM, keyPEM []byte) (*http.Response, error) {
+		clientConfig := &tls.Config{RootCAs: roots}
+		if certPEM != nil {
+			cert, err := tls.X509KeyPair(certPEM, keyPEM)
+			assert.NoError(t, err)
+			clientConfig.Certificates = []tls.Certificate{cert}
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientConfig}}
+		return client.Post(srv.URL+"/v3/discovery:listeners", "application/json", strings.NewReader(`{"node":{"cluster":"example"}}`))
+	}
+
+	resp, err := post(clientPEM, clientKeyPEM)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	// Sidecars without a certificate, or with one the client CAs didn't sign, are refused
+	_, err = post(nil, nil)
+	assert.Error(t, err)
+	_, err = post(otherPEM, otherKeyPEM)
+	assert.Error(t, err)
+}